@@ -0,0 +1,79 @@
+package dedup
+
+import (
+	"io"
+	"os"
+)
+
+const (
+	// adviceSequential and adviceDontNeed are the POSIX_FADV_SEQUENTIAL and POSIX_FADV_DONTNEED advice
+	adviceSequential = 2
+	adviceDontNeed   = 4
+
+	// dropBehindBytes is how many bytes of an input file are read between advising the kernel to drop
+	// them from the page cache
+	dropBehindBytes = 16 * 1024 * 1024
+)
+
+// dropBehindReader reads a file sequentially, and advises the kernel to drop what has been read
+// from the page cache every so often, since it is never read again
+type dropBehindReader struct {
+	f       *os.File
+	offset  int64
+	dropped int64
+}
+
+func (r *dropBehindReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	r.offset += int64(n)
+	if r.offset-r.dropped >= dropBehindBytes || (err != nil && r.offset > r.dropped) {
+		fadvise(r.f, r.dropped, r.offset-r.dropped, adviceDontNeed)
+		r.dropped = r.offset
+	}
+	return n, err
+}
+
+// adviseInput returns the input, with FileAdvice reading each file of it, or of its Sources, with
+// the kernel advised to read ahead of it and to drop what has been read
+func (j *job) adviseInput(r io.Reader) io.Reader {
+	if !j.opts.FileAdvice {
+		return r
+	}
+	if sources, ok := r.(*Sources); ok {
+		for i, source := range sources.sources {
+			sources.sources[i].Reader = adviseFile(source.Reader)
+		}
+		return sources
+	}
+	return adviseFile(r)
+}
+
+// adviseFile returns a drop behind reader of the reader if it is a file, or else the reader itself
+func adviseFile(r io.Reader) io.Reader {
+	f, ok := r.(*os.File)
+	if !ok {
+		return r
+	}
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		// Pipes and terminals have no page cache to advise about
+		return r
+	}
+	fadvise(f, offset, 0, adviceSequential)
+	return &dropBehindReader{f: f, offset: offset, dropped: offset}
+}
+
+// adviseSequential advises the kernel that the file is read from start to end, with FileAdvice
+func (j *job) adviseSequential(f *os.File) {
+	if j.opts.FileAdvice {
+		fadvise(f, 0, 0, adviceSequential)
+	}
+}
+
+// adviseWritten advises the kernel to drop the pages of the file from the page cache once they have
+// been written to disk, with FileAdvice. Pages not yet written back are left alone by the kernel.
+func (j *job) adviseWritten(f *os.File) {
+	if j.opts.FileAdvice && f != nil {
+		fadvise(f, 0, 0, adviceDontNeed)
+	}
+}
@@ -0,0 +1,64 @@
+package dedup
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunFileAdvice(t *testing.T) {
+	input := strings.Repeat("b\na\nc\n", 1000)
+	inFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(inFile.Name())
+	defer inFile.Close()
+	if _, err = inFile.WriteString(input); err != nil {
+		t.Fatal(err)
+	}
+
+	// Where advice is not supported, it does nothing
+	if err = fadvise(inFile, 0, 0, adviceSequential); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files are read through the advice, whether alone or as sources, and anything else as it is
+	j := &job{opts: Options{FileAdvice: true}}
+	for _, in := range []io.Reader{inFile, NewSources(Source{Name: "in", Reader: inFile})} {
+		if _, err = inFile.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(j.adviseInput(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != input {
+			t.Fatalf("Unexpected input read with advice from %T", in)
+		}
+	}
+	if r := strings.NewReader(input); j.adviseInput(r) != r {
+		t.Fatal("Expected a reader that is not a file to be read as it is")
+	}
+
+	if _, err = inFile.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	outFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+	if _, err = Run(outFile, Options{FileAdvice: true, TmpFileBytes: 4}, inFile, nil); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(outFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "a\nb\nc\n" {
+		t.Fatalf("Unexpected output: %q", content)
+	}
+}
@@ -0,0 +1,69 @@
+package dedup
+
+import (
+	"strings"
+)
+
+// stripANSI returns the line without its ANSI escape sequences, such as the colors and formatting of a
+// terminal: control sequences, such as "\x1b[1;31m", operating system commands ended by BEL or ST,
+// such as the hyperlinks "\x1b]8;;url\x1b\\", and the other escapes of ESC and a final byte, such as
+// "\x1b(B". An escape cut short by the end of the line is left out to the end.
+func stripANSI(line string) string {
+	i := strings.IndexByte(line, '\x1b')
+	if i < 0 {
+		return line
+	}
+	var b strings.Builder
+	b.Grow(len(line))
+	for i >= 0 {
+		b.WriteString(line[:i])
+		line = line[i+ansiLen(line[i:]):]
+		i = strings.IndexByte(line, '\x1b')
+	}
+	b.WriteString(line)
+	return b.String()
+}
+
+// ansiLen returns the length of the escape sequence the string begins with, which begins with ESC
+func ansiLen(s string) int {
+	if len(s) < 2 {
+		return len(s)
+	}
+	switch s[1] {
+	case '[':
+		// Parameter and intermediate bytes, then a final byte
+		for i := 2; i < len(s); i++ {
+			if s[i] >= 0x40 && s[i] <= 0x7e {
+				return i + 1
+			}
+			if s[i] < 0x20 || s[i] > 0x3f {
+				// Not a control sequence after all, so only its introducer is left out
+				return i
+			}
+		}
+		return len(s)
+	case ']', 'P', '_', '^':
+		// A string ended by BEL, or by ST, which is ESC \
+		for i := 2; i < len(s); i++ {
+			if s[i] == '\a' {
+				return i + 1
+			}
+			if s[i] == '\x1b' && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return len(s)
+	default:
+		// Intermediate bytes, then a final byte
+		for i := 1; i < len(s); i++ {
+			if s[i] < 0x20 {
+				// A control character, such as another ESC, is not part of it
+				return i
+			}
+			if s[i] > 0x2f {
+				return i + 1
+			}
+		}
+		return len(s)
+	}
+}
@@ -0,0 +1,69 @@
+package dedup
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStripANSI(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{line: "plain", expected: "plain"},
+		{line: "\x1b[31merror\x1b[0m: failed", expected: "error: failed"},
+		{line: "\x1b[1;38;5;208mbold orange\x1b[m", expected: "bold orange"},
+		{line: "\x1b]8;;https://a.com\x1b\\link\x1b]8;;\x1b\\", expected: "link"},
+		{line: "\x1b]0;title\atext", expected: "text"},
+		{line: "\x1b(Bcharset", expected: "charset"},
+		{line: "\x1b=keypad", expected: "keypad"},
+		{line: "cut short \x1b[31", expected: "cut short "},
+		{line: "trailing \x1b", expected: "trailing "},
+		{line: "\x1b\x1b[32mtwice", expected: "twice"},
+	}
+	for _, tt := range tests {
+		if stripped := stripANSI(tt.line); stripped != tt.expected {
+			t.Errorf("Expected %q stripped to be %q, got %q", tt.line, tt.expected, stripped)
+		}
+	}
+}
+
+func TestRunStripANSI(t *testing.T) {
+	input := "\x1b[32mok\x1b[0m b\nok a\n\x1b[31mfail\x1b[0m\nok b\n\x1b[33mok a\x1b[0m\nfail\n"
+
+	tests := []struct {
+		output   bool
+		expected string
+	}{
+		// The first line read of each is written, sorted by what is left of it
+		{output: false, expected: "\x1b[31mfail\x1b[0m\nok a\n\x1b[32mok\x1b[0m b\n"},
+		{output: true, expected: "fail\nok a\nok b\n"},
+	}
+	for _, tt := range tests {
+		for _, tmpFileBytes := range []uint64{1000, 2} {
+			outFile, err := os.CreateTemp("", "dedup.test.*.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(outFile.Name())
+			defer outFile.Close()
+
+			opts := Options{TmpFileBytes: tmpFileBytes, StripANSI: true, StripANSIOutput: tt.output}
+			stats, err := Run(outFile, opts, strings.NewReader(input), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.LinesUnique != 3 || stats.LinesDuplicate != 3 {
+				t.Fatalf("Unexpected stats with output %t: %+v", tt.output, stats)
+			}
+			content, err := os.ReadFile(outFile.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.expected {
+				t.Fatalf("Unexpected output with output %t and %d tmp file bytes: %q", tt.output, tmpFileBytes, content)
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package dedup
+
+import "unsafe"
+
+const (
+	// minArenaBlock and maxArenaBlock are the byte sizes of the first block of a line arena, and of
+	// the largest block it grows to, so that small chunks do not allocate much more than they hold
+	minArenaBlock = 4 * 1024
+	maxArenaBlock = 1024 * 1024
+)
+
+// lineArena copies the lines of a set into large shared blocks of memory, so that the lines are a
+// few allocations rather than one each, which leaves the garbage collector far fewer objects to
+// track and saves the overhead of each. The blocks are only ever appended to, so the strings pointing
+// into them never change, and a block is freed once none of them are referenced anymore.
+// Each set gets a new arena, so that the blocks of a chunk are freed along with its set.
+type lineArena struct {
+	block  []byte
+	blocks int
+}
+
+// arenaMark is a position in a line arena: the number of blocks started, and the length of the current one
+type arenaMark struct {
+	blocks, length int
+}
+
+// copy returns the bytes as a string, backed by the current block of the arena
+func (a *lineArena) copy(b []byte) string {
+	if !a.reserve(len(b)) {
+		return string(b)
+	}
+	start := len(a.block)
+	a.block = append(a.block, b...)
+	return a.string(start)
+}
+
+// copyString is copy for a string, which is copied into the arena in the same way
+func (a *lineArena) copyString(s string) string {
+	if !a.reserve(len(s)) {
+		return cloneLine(s)
+	}
+	start := len(a.block)
+	a.block = append(a.block, s...)
+	return a.string(start)
+}
+
+// reserve makes room in the current block for n more bytes, starting a new block if needed, or returns
+// false if they are too long for a block
+func (a *lineArena) reserve(n int) bool {
+	if n <= cap(a.block)-len(a.block) {
+		return true
+	}
+	// Long lines get allocations of their own, rather than wasting the rest of a block
+	if n > maxArenaBlock/4 {
+		return false
+	}
+	size := 2 * cap(a.block)
+	if size < minArenaBlock {
+		size = minArenaBlock
+	}
+	if size > maxArenaBlock {
+		size = maxArenaBlock
+	}
+	a.block = make([]byte, 0, size)
+	a.blocks++
+	return true
+}
+
+// string returns the bytes of the current block from start to its end as a string
+func (a *lineArena) string(start int) string {
+	line := a.block[start:len(a.block):len(a.block)]
+	return *(*string)(unsafe.Pointer(&line))
+}
+
+// mark returns the position of the arena, to rewind to
+func (a *lineArena) mark() arenaMark {
+	return arenaMark{blocks: a.blocks, length: len(a.block)}
+}
+
+// rewind gives back the bytes of the lines copied since the mark, which must no longer be referenced.
+// If a new block was started since, the rest of the old one is left unused.
+func (a *lineArena) rewind(mark arenaMark) {
+	if mark.blocks == a.blocks {
+		a.block = a.block[:mark.length]
+	}
+}
+
+// cloneLine returns a copy of the line with memory of its own, for lines that are kept for longer
+// than their set, which would otherwise keep the whole block of the arena they are in alive
+func cloneLine(line string) string {
+	b := make([]byte, len(line))
+	copy(b, line)
+	return *(*string)(unsafe.Pointer(&b))
+}
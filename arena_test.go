@@ -0,0 +1,41 @@
+package dedup
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLineArena(t *testing.T) {
+	var arena lineArena
+	var lines []string
+	for i := 0; i < 2000; i++ {
+		mark := arena.mark()
+		line := arena.copy([]byte(strings.Repeat("x", i%50) + "|"))
+
+		// Every other line is given back, and the next line reuses its bytes
+		if i%2 == 1 {
+			arena.rewind(mark)
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if arena.blocks < 2 {
+		t.Fatalf("Expected the arena to grow past its first block; Got: %d blocks", arena.blocks)
+	}
+
+	// The lines that were kept never change, even once lines after them were given back
+	for i, line := range lines {
+		if expected := strings.Repeat("x", (2*i)%50) + "|"; line != expected {
+			t.Fatalf("Expected line %d to be %q; Got: %q", i, expected, line)
+		}
+	}
+
+	// Long lines are not copied into a block
+	long := strings.Repeat("y", maxArenaBlock)
+	if line := arena.copy([]byte(long)); line != long || arena.mark().length > maxArenaBlock {
+		t.Fatal("Unexpected copy of a long line")
+	}
+	if line := cloneLine(lines[1]); line != lines[1] {
+		t.Fatalf("Unexpected clone: %q", line)
+	}
+}
@@ -0,0 +1,344 @@
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// defaultArrowBatchLines is how many unique lines each record batch of FormatArrow and
+// FormatArrowStream holds, when not configured
+const defaultArrowBatchLines = 64 * 1024
+
+// arrowMaxBatchBytes is how many bytes of lines or sources a record batch holds at most, well within
+// the 32-bit offsets of the utf8 type
+const arrowMaxBatchBytes = 1 << 30
+
+// arrowMagic starts and ends every file of FormatArrow
+const arrowMagic = "ARROW1"
+
+// The metadata version, message headers, and types of the Arrow IPC format used by FormatArrow
+const (
+	arrowV5          = 4
+	arrowSchema      = 1
+	arrowRecordBatch = 3
+	arrowInt         = 2
+	arrowUtf8        = 5
+)
+
+// arrowColumn is a column of FormatArrow, in the order of its schema. The counts and line numbers
+// are unsigned 64-bit integers.
+type arrowColumn struct {
+	name     string
+	utf8     bool
+	nullable bool
+}
+
+// arrowColumns are the columns of FormatArrow
+var arrowColumns = []arrowColumn{
+	{name: "line", utf8: true},
+	{name: "count"},
+	{name: "source", utf8: true, nullable: true},
+	{name: "line_number", nullable: true},
+}
+
+// arrowBlock is where a record batch was written, for the footer of FormatArrow
+type arrowBlock struct {
+	offset   int64
+	metadata int32
+	body     int64
+}
+
+// arrowWriter holds the rows of the current record batch of FormatArrow, column by column, already
+// laid out as the buffers of the batch, and what the footer needs to know about the batches written
+// so far
+type arrowWriter struct {
+	offset int64
+	rows   int
+	values [4]bytes.Buffer
+	ends   [4]bytes.Buffer
+	valid  [4][]byte
+	nulls  [4]int
+	blocks []arrowBlock
+}
+
+// validateArrow returns an error if FormatArrow or FormatArrowStream can not be written with the
+// options. Each partition would be written with batches and offsets of its own, and lines passed
+// through are not rows.
+func validateArrow(opts Options) error {
+	if opts.ArrowBatchLines < 0 {
+		return fmt.Errorf("arrow batch lines must not be negative: %d", opts.ArrowBatchLines)
+	}
+	if opts.Partitions > 1 {
+		return fmt.Errorf("%s output is not supported with partitions", opts.Format)
+	}
+	if opts.PassthroughUnkept {
+		return fmt.Errorf("%s output is not supported when passing through unkept lines", opts.Format)
+	}
+	for _, rule := range opts.Rules {
+		if rule.Action == RulePassthrough {
+			return fmt.Errorf("%s output is not supported with passthrough rules", opts.Format)
+		}
+	}
+	return nil
+}
+
+// arrowStart writes the magic bytes starting a file of FormatArrow, from where the output file is at,
+// since the offsets of the record batches are from the start of the file, and then the schema
+func (j *job) arrowStart(w io.Writer) error {
+	j.arrow = &arrowWriter{}
+	if j.opts.Format == FormatArrow {
+		if j.outFile != nil {
+			if offset, err := j.outFile.Seek(0, io.SeekCurrent); err == nil {
+				j.arrow.offset = offset
+			}
+		}
+		if err := j.writeArrow(w, []byte(arrowMagic+"\x00\x00")); err != nil {
+			return err
+		}
+	}
+	b := newFlatBuilder(512)
+	schema := buildArrowSchema(b)
+	metadata := buildArrowMessage(b, arrowSchema, schema, 0)
+	n, err := writeArrowMessage(w, metadata, nil)
+	j.stats.BytesOut += uint64(n)
+	j.arrow.offset += int64(n)
+	return err
+}
+
+// writeArrowRecord adds the record as a row of the current record batch of FormatArrow, and writes
+// the batch once it is full. It returns how many bytes were written.
+func (j *job) writeArrowRecord(w *bufio.Writer, rec jsonRecord) (uint64, error) {
+	a := j.arrow
+	a.appendString(0, rec.Line, true)
+	a.appendUint64(1, rec.Count, true)
+	a.appendString(2, rec.Source, rec.Source != "")
+	a.appendUint64(3, rec.LineNumber, rec.LineNumber > 0)
+	a.rows++
+	batchLines := j.opts.ArrowBatchLines
+	if batchLines == 0 {
+		batchLines = defaultArrowBatchLines
+	}
+	if a.rows < batchLines && a.values[0].Len() < arrowMaxBatchBytes && a.values[2].Len() < arrowMaxBatchBytes {
+		return 0, nil
+	}
+	return a.flush(w)
+}
+
+// appendString adds the string as the value of the utf8 column of the current row, or a null
+func (a *arrowWriter) appendString(column int, s string, valid bool) {
+	if a.ends[column].Len() == 0 {
+		writeArrowUint32(&a.ends[column], 0)
+	}
+	a.setValid(column, valid)
+	a.values[column].WriteString(s)
+	writeArrowUint32(&a.ends[column], uint32(a.values[column].Len()))
+}
+
+// appendUint64 adds the number as the value of the integer column of the current row, or a null
+func (a *arrowWriter) appendUint64(column int, v uint64, valid bool) {
+	a.setValid(column, valid)
+	var value [8]byte
+	binary.LittleEndian.PutUint64(value[:], v)
+	a.values[column].Write(value[:])
+}
+
+// setValid sets the bit of the current row in the validity bitmap of the column
+func (a *arrowWriter) setValid(column int, valid bool) {
+	if a.rows%8 == 0 {
+		a.valid[column] = append(a.valid[column], 0)
+	}
+	if valid {
+		a.valid[column][a.rows/8] |= 1 << (a.rows % 8)
+	} else {
+		a.nulls[column]++
+	}
+}
+
+// flush writes the current record batch, if it has any rows, and returns how many bytes were written
+func (a *arrowWriter) flush(w io.Writer) (uint64, error) {
+	if a.rows == 0 {
+		return 0, nil
+	}
+
+	// The body has the validity bitmap of each column, which is left empty without nulls, then the
+	// offsets of its strings, if any, and its values, each aligned to 8 bytes
+	var body bytes.Buffer
+	var buffers [][2]int64
+	addBuffer := func(b []byte) {
+		buffers = append(buffers, [2]int64{int64(body.Len()), int64(len(b))})
+		body.Write(b)
+		body.Write(make([]byte, -len(b)&7))
+	}
+	for i, column := range arrowColumns {
+		if a.nulls[i] > 0 {
+			addBuffer(a.valid[i])
+		} else {
+			addBuffer(nil)
+		}
+		if column.utf8 {
+			addBuffer(a.ends[i].Bytes())
+		}
+		addBuffer(a.values[i].Bytes())
+	}
+
+	b := newFlatBuilder(512)
+	b.startVector(16, len(buffers), 8)
+	for i := len(buffers) - 1; i >= 0; i-- {
+		b.prep(8, 16)
+		b.placeUint64(uint64(buffers[i][1]))
+		b.placeUint64(uint64(buffers[i][0]))
+	}
+	buffersVector := b.endVector(len(buffers))
+	b.startVector(16, len(arrowColumns), 8)
+	for i := len(arrowColumns) - 1; i >= 0; i-- {
+		b.prep(8, 16)
+		b.placeUint64(uint64(a.nulls[i]))
+		b.placeUint64(uint64(a.rows))
+	}
+	nodes := b.endVector(len(arrowColumns))
+	b.startTable(3)
+	b.addUint64(0, uint64(a.rows))
+	b.addOffset(1, nodes)
+	b.addOffset(2, buffersVector)
+	batch := b.endTable()
+	metadata := buildArrowMessage(b, arrowRecordBatch, batch, int64(body.Len()))
+
+	block := arrowBlock{offset: a.offset, metadata: int32(arrowPrefixLength(metadata)), body: int64(body.Len())}
+	n, err := writeArrowMessage(w, metadata, body.Bytes())
+	a.offset += int64(n)
+	if err != nil {
+		return uint64(n), err
+	}
+	a.blocks = append(a.blocks, block)
+	for i := range arrowColumns {
+		a.values[i].Reset()
+		a.ends[i].Reset()
+		a.valid[i] = a.valid[i][:0]
+		a.nulls[i] = 0
+	}
+	a.rows = 0
+	return uint64(n), nil
+}
+
+// arrowEnd writes the end of the stream of FormatArrowStream, or for FormatArrow, also the footer of
+// the file, with the schema and where each record batch was written, then its length and the magic
+// bytes ending the file
+func (j *job) arrowEnd(w io.Writer) error {
+	// The continuation marker followed by a length of zero ends the stream
+	end := []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}
+	if j.opts.Format == FormatArrowStream {
+		return j.writeArrow(w, end)
+	}
+
+	a := j.arrow
+	b := newFlatBuilder(512)
+	schema := buildArrowSchema(b)
+	b.startVector(24, len(a.blocks), 8)
+	for i := len(a.blocks) - 1; i >= 0; i-- {
+		b.prep(8, 24)
+		b.placeUint64(uint64(a.blocks[i].body))
+		b.placeUint32(0)
+		b.placeUint32(uint32(a.blocks[i].metadata))
+		b.placeUint64(uint64(a.blocks[i].offset))
+	}
+	blocks := b.endVector(len(a.blocks))
+	b.startVector(24, 0, 8)
+	dictionaries := b.endVector(0)
+	b.startTable(4)
+	b.addOffset(3, blocks)
+	b.addOffset(2, dictionaries)
+	b.addOffset(1, schema)
+	b.addUint16(0, arrowV5)
+	footer := b.finish(b.endTable())
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+	end = append(end, footer...)
+	end = append(end, length[:]...)
+	end = append(end, arrowMagic...)
+	return j.writeArrow(w, end)
+}
+
+// writeArrow writes bytes of FormatArrow that are not rows
+func (j *job) writeArrow(w io.Writer, b []byte) error {
+	n, err := w.Write(b)
+	j.stats.BytesOut += uint64(n)
+	j.arrow.offset += int64(n)
+	return err
+}
+
+// buildArrowSchema builds the Schema of FormatArrow, and returns its offset
+func buildArrowSchema(b *flatBuilder) int {
+	fields := make([]int, len(arrowColumns))
+	for i, column := range arrowColumns {
+		name := b.createString(column.name)
+		children := b.createOffsets(nil)
+		typ := uint8(arrowUtf8)
+		if column.utf8 {
+			b.startTable(0)
+		} else {
+			typ = arrowInt
+			b.startTable(2)
+			b.addUint32(0, 64)
+			b.addUint8(1, 0) // unsigned
+		}
+		typeTable := b.endTable()
+
+		b.startTable(7)
+		b.addOffset(0, name)
+		b.addOffset(3, typeTable)
+		b.addOffset(5, children)
+		if column.nullable {
+			b.addUint8(1, 1)
+		}
+		b.addUint8(2, typ)
+		fields[i] = b.endTable()
+	}
+	fieldsVector := b.createOffsets(fields)
+	b.startTable(2)
+	b.addOffset(1, fieldsVector) // with the default little endianness
+	return b.endTable()
+}
+
+// buildArrowMessage builds the Message with the header, and the length of the body that follows it,
+// and returns the finished FlatBuffer
+func buildArrowMessage(b *flatBuilder, headerType uint8, header int, bodyLength int64) []byte {
+	b.startTable(4)
+	b.addUint64(3, uint64(bodyLength))
+	b.addOffset(2, header)
+	b.addUint16(0, arrowV5)
+	b.addUint8(1, headerType)
+	return b.finish(b.endTable())
+}
+
+// arrowPrefixLength returns how many bytes the message is before its body, with the continuation
+// marker, its length, and its padding to 8 bytes
+func arrowPrefixLength(metadata []byte) int {
+	return 8 + len(metadata) + (-len(metadata) & 7)
+}
+
+// writeArrowMessage writes the encapsulated message, which is the continuation marker, the padded
+// length of the metadata, the metadata, and the body, and returns how many bytes were written
+func writeArrowMessage(w io.Writer, metadata, body []byte) (int, error) {
+	prefix := make([]byte, 8, arrowPrefixLength(metadata))
+	binary.LittleEndian.PutUint32(prefix, 0xffffffff)
+	binary.LittleEndian.PutUint32(prefix[4:], uint32(cap(prefix)-8))
+	prefix = append(prefix, metadata...)
+	prefix = append(prefix, make([]byte, cap(prefix)-len(prefix))...)
+	n, err := w.Write(prefix)
+	if err != nil || len(body) == 0 {
+		return n, err
+	}
+	m, err := w.Write(body)
+	return n + m, err
+}
+
+// writeArrowUint32 writes the number as a little endian 32-bit integer
+func writeArrowUint32(b *bytes.Buffer, v uint32) {
+	var value [4]byte
+	binary.LittleEndian.PutUint32(value[:], v)
+	b.Write(value[:])
+}
@@ -0,0 +1,348 @@
+package dedup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunFormatArrow(t *testing.T) {
+	// Small batches split the lines between several of them
+	for _, tc := range []struct {
+		format       OutputFormat
+		tmpFileBytes uint64
+		batchLines   int
+		batches      int
+	}{
+		{format: FormatArrow, tmpFileBytes: 1000, batches: 1},
+		{format: FormatArrow, tmpFileBytes: 1, batches: 1},
+		{format: FormatArrow, tmpFileBytes: 1000, batchLines: 2, batches: 3},
+		{format: FormatArrowStream, tmpFileBytes: 1000, batches: 1},
+		{format: FormatArrowStream, tmpFileBytes: 4, batchLines: 1, batches: 5},
+	} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.arrow")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		input := NewSources(
+			Source{Name: "one", Reader: strings.NewReader("c\na\n")},
+			Source{Name: "two", Reader: strings.NewReader("a\nb\nc\nd\ne\na\n")},
+		)
+		opts := Options{TmpFileBytes: tc.tmpFileBytes, Format: tc.format, ArrowBatchLines: tc.batchLines, VerifyWrites: true}
+		stats, err := Run(outFile, opts, input, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 5 || stats.BytesOut != uint64(len(content)) {
+			t.Fatalf("Unexpected stats with %+v: %+v", tc, stats)
+		}
+
+		batches, rows := readArrow(t, content, tc.format == FormatArrow)
+		if batches != tc.batches {
+			t.Fatalf("Expected %d record batches with %+v; Got: %d", tc.batches, tc, batches)
+		}
+		expected := []string{"a 3 one 2", "b 1 two 2", "c 2 one 1", "d 1 two 4", "e 1 two 5"}
+		if !reflect.DeepEqual(rows, expected) {
+			t.Fatalf("Unexpected rows with %+v: %q", tc, rows)
+		}
+	}
+
+	// Without Sources, no row has a source, and merged rows have no line number either
+	outFile, err := os.CreateTemp("", "dedup.test.*.arrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+	inFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(inFile.Name())
+	defer inFile.Close()
+	if _, err = inFile.WriteString("a\na\nb\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = inFile.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Merge(outFile, Options{Format: FormatArrow, VerifyWrites: true}, inFile); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(outFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, rows := readArrow(t, content, true); !reflect.DeepEqual(rows, []string{"a 2 <nil> <nil>", "b 1 <nil> <nil>"}) {
+		t.Fatalf("Unexpected merged rows: %q", rows)
+	}
+
+	// Without any lines, the stream has only the schema
+	if err = outFile.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = outFile.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Run(outFile, Options{Format: FormatArrowStream}, strings.NewReader(""), nil); err != nil {
+		t.Fatal(err)
+	}
+	if content, err = os.ReadFile(outFile.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if batches, rows := readArrow(t, content, false); batches != 0 || len(rows) != 0 {
+		t.Fatalf("Expected no record batches; Got: %d %q", batches, rows)
+	}
+
+	if _, err = Run(nil, Options{Format: FormatArrowStream, Partitions: 2}, strings.NewReader("a\n"), nil); err == nil {
+		t.Fatal("Expected an error with partitions")
+	}
+}
+
+func TestArrowGolden(t *testing.T) {
+	// As with Parquet, every byte of both the file and the stream is pinned down
+	for _, tc := range []struct {
+		name   string
+		format OutputFormat
+	}{
+		{name: "golden.arrow", format: FormatArrow},
+		{name: "golden.arrows", format: FormatArrowStream},
+	} {
+		content := runGolden(t, Options{TmpFileBytes: 1000, Format: tc.format, ArrowBatchLines: 2})
+		checkGolden(t, tc.name, content)
+		batches, rows := readArrow(t, content, tc.format == FormatArrow)
+		if expected := []string{"a 3 one 2", "b 1 two 2", "c 2 one 1", "d 1 two 4", "e 1 two 5"}; batches != 3 || !reflect.DeepEqual(rows, expected) {
+			t.Fatalf("Expected %s to have 3 record batches of rows %q; Got: %d of %q", tc.name, expected, batches, rows)
+		}
+	}
+}
+
+// readArrow reads the messages of an Arrow IPC stream written by FormatArrowStream, or of the file
+// written by FormatArrow, whose footer must point to the same record batches, and returns the number
+// of record batches and each row, with its columns separated by spaces
+func readArrow(t *testing.T, content []byte, file bool) (int, []string) {
+	t.Helper()
+	stream := content
+	if file {
+		if !bytes.HasPrefix(content, []byte(arrowMagic+"\x00\x00")) || !bytes.HasSuffix(content, []byte(arrowMagic)) {
+			t.Fatalf("Expected the file to start and end with %s", arrowMagic)
+		}
+		stream = content[8:]
+	}
+
+	var blocks []arrowBlock
+	var rows []string
+	pos := 0
+	for {
+		if binary.LittleEndian.Uint32(stream[pos:]) != 0xffffffff {
+			t.Fatalf("Expected a continuation marker at %d", pos)
+		}
+		length := int(binary.LittleEndian.Uint32(stream[pos+4:]))
+		if length == 0 {
+			pos += 8
+			break
+		}
+		if (pos+8+length)%8 != 0 {
+			t.Fatalf("Expected the message at %d to be padded to 8 bytes", pos)
+		}
+		fb := stream[pos+8 : pos+8+length]
+		message := flatRoot(fb)
+		if version := flatUint16(fb, message, 0); version != arrowV5 {
+			t.Fatalf("Unexpected metadata version: %d", version)
+		}
+		headerType := fb[flatField(fb, message, 1)]
+		header := flatTable(fb, flatField(fb, message, 2))
+		if field := flatField(fb, message, 3); field%8 != 0 {
+			t.Fatalf("Expected the body length to be aligned to 8 bytes; Got: %d", field)
+		}
+		bodyLength := int(flatUint64(fb, message, 3))
+		body := stream[pos+8+length : pos+8+length+bodyLength]
+
+		switch {
+		case pos == 0:
+			if headerType != arrowSchema {
+				t.Fatalf("Expected the schema first; Got: %d", headerType)
+			}
+			checkArrowSchema(t, fb, header)
+		case headerType == arrowRecordBatch:
+			blocks = append(blocks, arrowBlock{offset: int64(pos + len(content) - len(stream)), metadata: int32(8 + length), body: int64(bodyLength)})
+			rows = append(rows, readArrowBatch(t, fb, header, body)...)
+		default:
+			t.Fatalf("Unexpected message: %d", headerType)
+		}
+		pos += 8 + length + bodyLength
+	}
+
+	if !file {
+		if pos != len(stream) {
+			t.Fatalf("Expected the stream to end at %d; Got: %d", len(stream), pos)
+		}
+		return len(blocks), rows
+	}
+	footerLength := int(binary.LittleEndian.Uint32(content[len(content)-10:]))
+	if 8+pos+footerLength+10 != len(content) {
+		t.Fatalf("Expected the footer to follow the stream")
+	}
+	fb := content[len(content)-10-footerLength : len(content)-10]
+	footer := flatRoot(fb)
+	checkArrowSchema(t, fb, flatTable(fb, flatField(fb, footer, 1)))
+	vector, n := flatVector(fb, flatField(fb, footer, 3))
+	var footerBlocks []arrowBlock
+	for i := 0; i < n; i++ {
+		block := fb[vector+24*i:]
+		footerBlocks = append(footerBlocks, arrowBlock{
+			offset:   int64(binary.LittleEndian.Uint64(block)),
+			metadata: int32(binary.LittleEndian.Uint32(block[8:])),
+			body:     int64(binary.LittleEndian.Uint64(block[16:])),
+		})
+	}
+	if !reflect.DeepEqual(footerBlocks, blocks) {
+		t.Fatalf("Expected the footer to point to the record batches %v; Got: %v", blocks, footerBlocks)
+	}
+	return len(blocks), rows
+}
+
+// checkArrowSchema checks the Schema has the columns of FormatArrow, with their types
+func checkArrowSchema(t *testing.T, fb []byte, schema int) {
+	t.Helper()
+	vector, n := flatVector(fb, flatField(fb, schema, 1))
+	var fields []string
+	for i := 0; i < n; i++ {
+		field := flatTable(fb, vector+4*i)
+		name := flatString(fb, flatField(fb, field, 0))
+		nullable := flatField(fb, field, 1) != 0 && fb[flatField(fb, field, 1)] == 1
+		typ := fb[flatField(fb, field, 2)]
+		if children, _ := flatVector(fb, flatField(fb, field, 5)); children == 0 {
+			t.Fatalf("Expected field %s to have children", name)
+		}
+		if typ == arrowInt {
+			intType := flatTable(fb, flatField(fb, field, 3))
+			bitWidth := binary.LittleEndian.Uint32(fb[flatField(fb, intType, 0):])
+			fields = append(fields, fmt.Sprintf("%s:uint%d:%t", name, bitWidth, nullable))
+		} else {
+			fields = append(fields, fmt.Sprintf("%s:%d:%t", name, typ, nullable))
+		}
+	}
+	expected := []string{"line:5:false", "count:uint64:false", "source:5:true", "line_number:uint64:true"}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("Unexpected schema: %v", fields)
+	}
+}
+
+// readArrowBatch reads the rows of the RecordBatch from its body
+func readArrowBatch(t *testing.T, fb []byte, batch int, body []byte) []string {
+	t.Helper()
+	length := int(flatUint64(fb, batch, 0))
+	nodes, numNodes := flatVector(fb, flatField(fb, batch, 1))
+	buffers, numBuffers := flatVector(fb, flatField(fb, batch, 2))
+	if numNodes != len(arrowColumns) || numBuffers != 10 {
+		t.Fatalf("Expected %d nodes and 10 buffers; Got: %d and %d", len(arrowColumns), numNodes, numBuffers)
+	}
+	buffer := func() []byte {
+		offset := binary.LittleEndian.Uint64(fb[buffers:])
+		size := binary.LittleEndian.Uint64(fb[buffers+8:])
+		buffers += 16
+		if offset%8 != 0 {
+			t.Fatalf("Expected buffers aligned to 8 bytes; Got: %d", offset)
+		}
+		return body[offset : offset+size]
+	}
+
+	columns := make([][]string, length)
+	for i, column := range arrowColumns {
+		if rows := binary.LittleEndian.Uint64(fb[nodes+16*i:]); rows != uint64(length) {
+			t.Fatalf("Expected %d rows in column %d; Got: %d", length, i, rows)
+		}
+		nulls := binary.LittleEndian.Uint64(fb[nodes+16*i+8:])
+		valid := buffer()
+		if (nulls == 0) != (len(valid) == 0) {
+			t.Fatalf("Expected a validity bitmap only with nulls in column %d", i)
+		}
+		var ends []byte
+		if column.utf8 {
+			ends = buffer()
+		}
+		values := buffer()
+		for r := range columns {
+			switch {
+			case len(valid) > 0 && valid[r/8]&(1<<(r%8)) == 0:
+				columns[r] = append(columns[r], "<nil>")
+			case column.utf8:
+				start, end := binary.LittleEndian.Uint32(ends[4*r:]), binary.LittleEndian.Uint32(ends[4*r+4:])
+				columns[r] = append(columns[r], string(values[start:end]))
+			default:
+				columns[r] = append(columns[r], fmt.Sprint(binary.LittleEndian.Uint64(values[8*r:])))
+			}
+		}
+	}
+	var rows []string
+	for _, columns := range columns {
+		rows = append(rows, strings.Join(columns, " "))
+	}
+	return rows
+}
+
+// flatRoot returns the position of the root table of the FlatBuffer
+func flatRoot(fb []byte) int {
+	return flatTable(fb, 0)
+}
+
+// flatTable returns the position of the table the offset at the position points to
+func flatTable(fb []byte, pos int) int {
+	return pos + int(binary.LittleEndian.Uint32(fb[pos:]))
+}
+
+// flatField returns the position of the field of the table, or 0 if it is absent
+func flatField(fb []byte, table, field int) int {
+	vtable := table - int(int32(binary.LittleEndian.Uint32(fb[table:])))
+	if 4+2*field >= int(binary.LittleEndian.Uint16(fb[vtable:])) {
+		return 0
+	}
+	off := int(binary.LittleEndian.Uint16(fb[vtable+4+2*field:]))
+	if off == 0 {
+		return 0
+	}
+	return table + off
+}
+
+// flatUint16 and flatUint64 return the value of the field of the table, or 0 if it is absent
+func flatUint16(fb []byte, table, field int) uint16 {
+	if pos := flatField(fb, table, field); pos != 0 {
+		return binary.LittleEndian.Uint16(fb[pos:])
+	}
+	return 0
+}
+
+func flatUint64(fb []byte, table, field int) uint64 {
+	if pos := flatField(fb, table, field); pos != 0 {
+		return binary.LittleEndian.Uint64(fb[pos:])
+	}
+	return 0
+}
+
+// flatVector returns the position of the first element of the vector the field at the position
+// points to, and its length, or 0 if the field is absent
+func flatVector(fb []byte, pos int) (int, int) {
+	if pos == 0 {
+		return 0, 0
+	}
+	vector := flatTable(fb, pos)
+	return vector + 4, int(binary.LittleEndian.Uint32(fb[vector:]))
+}
+
+// flatString returns the string the field at the position points to
+func flatString(fb []byte, pos int) string {
+	start, n := flatVector(fb, pos)
+	return string(fb[start : start+n])
+}
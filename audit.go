@@ -0,0 +1,92 @@
+package dedup
+
+// Position is where a line was read in the input
+type Position struct {
+	// Source is the name of the source the line was read from, if the input is Sources
+	Source string
+
+	// Line is the line number within the source, or within the whole input without Sources,
+	// counting every line read including those skipped
+	Line uint64
+}
+
+// Duplicate is a line that was removed as a duplicate, and the line it duplicates that was retained
+type Duplicate struct {
+	// Line is the duplicated line, as it was deduplicated
+	Line string
+
+	// Position is where the removed duplicate was read
+	Position Position
+
+	// Retained is where the line that was retained, its first occurrence, was read
+	Retained Position
+}
+
+// auditing returns true if the position of every duplicate is being tracked, for OnAudit
+func (j *job) auditing() bool {
+	return j.opts.OnAudit != nil
+}
+
+// locating returns true if the source each line was read from is tracked, for where lines were first
+// read, or for OnDuplicateAt
+func (j *job) locating() bool {
+	return j.tracksFirst() || j.opts.OnDuplicateAt != nil
+}
+
+// duplicate calls OnDuplicate and OnDuplicateAt for a line removed as a duplicate, read at the
+// ordinal plus one, or zero if not known. The lines of read workers are interleaved, so where they
+// were read is not known.
+func (j *job) duplicate(line string, at uint64) error {
+	if j.opts.OnDuplicate != nil {
+		if err := j.opts.OnDuplicate(line); err != nil {
+			return err
+		}
+	}
+	if j.opts.OnDuplicateAt == nil {
+		return nil
+	}
+	var pos Position
+	if at > 0 && (j.sources == nil || j.opts.ReadWorkers <= 1) {
+		pos.Source, pos.Line = j.position(at - 1)
+	}
+	return j.opts.OnDuplicateAt(line, pos)
+}
+
+// duplicateBytes returns how many bytes of memory tracking another duplicate in the current chunk
+// uses, which counts towards TmpFileBytes
+func (j *job) duplicateBytes() uint64 {
+	if j.auditing() {
+		return 8
+	}
+	return 0
+}
+
+// audit calls OnAudit for the removed duplicates of a line in a chunk: the first occurrence in the
+// chunk unless it is the retained one, and the ordinals of the others. Like first, retained and
+// chunkFirst are ordinals plus one.
+func (j *job) audit(line string, retained, chunkFirst uint64, dups []uint64) error {
+	if !j.auditing() {
+		return nil
+	}
+	if chunkFirst > 0 && chunkFirst != retained {
+		if err := j.auditOne(line, retained, chunkFirst-1); err != nil {
+			return err
+		}
+	}
+	for _, dup := range dups {
+		if err := j.auditOne(line, retained, dup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditOne calls OnAudit for a single removed duplicate
+func (j *job) auditOne(line string, retained, ordinal uint64) error {
+	d := Duplicate{Line: line}
+	d.Position.Source, d.Position.Line = j.position(ordinal)
+	if retained > 0 {
+		d.Retained.Source, d.Retained.Line = j.position(retained - 1)
+	}
+	return j.opts.OnAudit(d)
+}
@@ -0,0 +1,44 @@
+package dedup
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRunOnAudit(t *testing.T) {
+	expected := []string{
+		"a two:1 one:2",
+		"a two:5 one:2",
+		"c two:3 one:1",
+	}
+
+	// Whether everything fits in memory or not, duplicates are audited against the first occurrence
+	for _, tmpFileBytes := range []uint64{1000, 4, 1} {
+		var audited []string
+		input := NewSources(
+			Source{Name: "one", Reader: strings.NewReader("c\na\n")},
+			Source{Name: "two", Reader: strings.NewReader("a\nb\nc\nd\na\n")},
+		)
+		opts := Options{TmpFileBytes: tmpFileBytes, DryRun: true, OnAudit: func(d Duplicate) error {
+			audited = append(audited, fmt.Sprintf("%s %s:%d %s:%d",
+				d.Line, d.Position.Source, d.Position.Line, d.Retained.Source, d.Retained.Line))
+			return nil
+		}}
+		stats, err := Run(nil, opts, input, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesDuplicate != 3 {
+			t.Fatalf("Unexpected stats with %d tmp file bytes: %+v", tmpFileBytes, stats)
+		}
+
+		// The duplicates of a line are not in any particular order
+		sort.Strings(audited)
+		if !reflect.DeepEqual(audited, expected) {
+			t.Fatalf("Unexpected audit with %d tmp file bytes: %q", tmpFileBytes, audited)
+		}
+	}
+}
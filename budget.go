@@ -0,0 +1,98 @@
+package dedup
+
+import (
+	"sync"
+)
+
+// Budget caps the bytes of the distinct lines held in memory by every run it is given to with
+// Options.Budget, all together, such as the runs of each tenant of a process, which would otherwise
+// each size their sets as if they had the memory to themselves. The bytes are counted as TmpFileBytes
+// counts them. Once the runs together hold more than the budget, the set of any run holding at least
+// its share of it, the budget divided by the runs reading their input, is spilled, so that a run with a
+// small set is not made to spill it for the others. The sets being written to temporary files by
+// SortWorkers still count, until they are written. It is safe for concurrent use, and all methods are
+// safe to call on a nil Budget, which caps nothing.
+type Budget struct {
+	mu    sync.Mutex
+	bytes uint64
+	used  uint64
+	runs  int
+}
+
+// NewBudget returns a budget of the bytes of the distinct lines held in memory by the runs sharing it
+func NewBudget(bytes uint64) *Budget {
+	return &Budget{bytes: bytes}
+}
+
+// Bytes returns the bytes the runs sharing the budget may hold together
+func (b *Budget) Bytes() uint64 {
+	if b == nil {
+		return 0
+	}
+	return b.bytes
+}
+
+// Used returns the bytes of the distinct lines held in memory by the runs sharing the budget
+func (b *Budget) Used() uint64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// join counts a run reading its input, which shares the budget with the others until it leaves
+func (b *Budget) join() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.runs++
+}
+
+// leave stops counting a run that has read its input, and gives back the bytes its set still holds
+func (b *Budget) leave(held uint64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.runs--
+	b.release(held)
+}
+
+// take adds n bytes to those held by the runs, and returns true if they hold more than the budget
+// together, and the set of the run taking them, which holds held bytes with them, holds at least its share
+func (b *Budget) take(n, held uint64) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used += n
+	runs := uint64(b.runs)
+	if runs == 0 {
+		runs = 1
+	}
+	return b.used > b.bytes && held >= b.bytes/runs
+}
+
+// give gives back n bytes, once the set holding them has been written
+func (b *Budget) give(n uint64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.release(n)
+}
+
+// release takes n bytes from those held, while holding the lock
+func (b *Budget) release(n uint64) {
+	if n > b.used {
+		n = b.used
+	}
+	b.used -= n
+}
@@ -0,0 +1,88 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBudget(t *testing.T) {
+	b := NewBudget(100)
+	b.join()
+	b.join()
+
+	// Over the budget, only a set holding at least its share of it is spilled
+	if b.take(90, 90) {
+		t.Fatal("Expected no spill under the budget")
+	}
+	if b.take(20, 20) {
+		t.Fatal("Expected no spill of a set holding less than its share")
+	}
+	if !b.take(5, 95) {
+		t.Fatal("Expected a spill of a set holding more than its share")
+	}
+	b.give(95)
+	b.leave(20)
+	if b.Used() != 0 {
+		t.Fatalf("Expected every byte to be given back, got %d", b.Used())
+	}
+
+	var none *Budget
+	none.join()
+	if none.take(1000, 1000) || none.Used() != 0 || none.Bytes() != 0 {
+		t.Fatal("Expected a nil budget to cap nothing")
+	}
+}
+
+func TestRunBudget(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&input, "line %03d\nline %03d\n", i, 199-i)
+	}
+	budget := NewBudget(500)
+
+	// Runs sharing the budget spill their sets, though each has room for all of its lines
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = func() error {
+				outFile, err := os.CreateTemp("", "dedup.test.*.log")
+				if err != nil {
+					return err
+				}
+				defer os.Remove(outFile.Name())
+				defer outFile.Close()
+
+				stats, err := Run(outFile, Options{TmpFileBytes: 1 << 20, Budget: budget}, strings.NewReader(input.String()), nil)
+				if err != nil {
+					return err
+				}
+				if stats.Chunks < 2 || stats.LinesUnique != 200 {
+					return fmt.Errorf("unexpected stats: %+v", stats)
+				}
+				content, err := os.ReadFile(outFile.Name())
+				if err != nil {
+					return err
+				}
+				if !strings.HasPrefix(string(content), "line 000\nline 001\n") || len(content) != 200*9 {
+					return fmt.Errorf("unexpected output: %q", content)
+				}
+				return nil
+			}()
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if budget.Used() != 0 {
+		t.Fatalf("Expected every byte to be given back, got %d", budget.Used())
+	}
+}
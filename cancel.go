@@ -0,0 +1,45 @@
+package dedup
+
+import (
+	"fmt"
+)
+
+// cancelled returns true once the Context of the run is done, such as when its deadline has passed,
+// and marks the output as incomplete the first time
+func (j *job) cancelled() bool {
+	if j.opts.Context == nil {
+		return false
+	}
+	select {
+	case <-j.opts.Context.Done():
+	default:
+		return false
+	}
+	if !j.stats.Incomplete {
+		j.stats.Incomplete = true
+		message := fmt.Sprint("Run cancelled: ", j.opts.Context.Err())
+		if j.opts.FlushOnCancel {
+			message = fmt.Sprintf("%s, merging the %d lines read so far into the output", message, j.stats.LinesRead)
+		}
+		j.event(Event{Kind: EventWarning, Lines: j.stats.LinesRead, Err: j.opts.Context.Err(), Message: message})
+	}
+	return true
+}
+
+// cancelErr returns the error of the Context once it is done, unless the lines read before then are
+// being written to the output, with FlushOnCancel
+func (j *job) cancelErr() error {
+	if j.opts.FlushOnCancel || !j.cancelled() {
+		return nil
+	}
+	return j.opts.Context.Err()
+}
+
+// validateCancel returns an error if the lines read are to be written once the run is cancelled,
+// but it can not be
+func validateCancel(opts Options) error {
+	if opts.FlushOnCancel && opts.Context == nil {
+		return fmt.Errorf("flushing the output on cancellation requires a context")
+	}
+	return nil
+}
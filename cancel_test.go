@@ -0,0 +1,81 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunCancelled(t *testing.T) {
+	b, err := os.ReadFile("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = Run(nil, Options{DryRun: true, Context: ctx}, strings.NewReader(string(b)), nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the run to be cancelled; Got: %v", err)
+	}
+	if _, err = Run(nil, Options{DryRun: true, FlushOnCancel: true}, strings.NewReader(string(b)), nil); err == nil {
+		t.Fatal("Expected an error flushing on cancellation without a context")
+	}
+}
+
+func TestRunFlushOnCancel(t *testing.T) {
+	b, err := os.ReadFile("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tmpFileBytes := range []uint64{20 * 50, 0} {
+		// Cancel the run once part of the input has been read, after some chunks have been written
+		ctx, cancel := context.WithCancel(context.Background())
+		in := &cancellingReader{r: strings.NewReader(string(b)), n: 6000, cancel: cancel}
+		outFile, err := os.CreateTemp(t.TempDir(), "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer outFile.Close()
+
+		opts := Options{TmpFileBytes: tmpFileBytes, TempDir: t.TempDir(), ReadBufferSize: 1024, Context: ctx, FlushOnCancel: true}
+		stats, err := Run(outFile, opts, in, nil)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stats.Incomplete || stats.LinesRead == 0 || stats.BytesRead >= uint64(len(b)) {
+			t.Fatalf("Expected part of the input to be read; Got: %+v", stats)
+		}
+
+		// The output is the deduplicated lines read before the run was cancelled
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+		if uint64(len(lines)) != stats.LinesUnique || stats.LinesUnique+stats.LinesDuplicate != stats.LinesRead {
+			t.Fatalf("Expected %d unique lines of %d read; Got: %d", stats.LinesUnique, stats.LinesRead, len(lines))
+		}
+		for i := 1; i < len(lines); i++ {
+			if lines[i-1] >= lines[i] {
+				t.Fatalf("Expected sorted and deduplicated output; Got %q before %q", lines[i-1], lines[i])
+			}
+		}
+	}
+}
+
+// cancellingReader reads the reader, and cancels the context once n bytes have been read
+type cancellingReader struct {
+	r      *strings.Reader
+	n      int
+	cancel context.CancelFunc
+}
+
+func (r *cancellingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if r.n -= n; r.n <= 0 {
+		r.cancel()
+	}
+	return n, err
+}
@@ -0,0 +1,294 @@
+package dedup
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// mergeSpilled merges the chunks spilled by the job into the output writer. With more chunks than
+// MergeFanIn, they are first merged into fewer intermediate files by cascade.
+func (j *job) mergeSpilled(out io.Writer, chunks []*os.File) (err error) {
+	if j.opts.MergeFanIn > 0 {
+		defer func() {
+			err = joinErrors(err, j.closeCascade())
+		}()
+		if chunks, err = j.cascade(chunks); err != nil {
+			return err
+		}
+	}
+	_, _, err = j.mergeChunks(out, chunks)
+	return err
+}
+
+// cascade merges the chunks MergeFanIn at a time into intermediate temporary files, in as many
+// passes as needed, until no more than MergeFanIn are left. It returns those opened again for the
+// final merge, and makes their meta files the meta files of the job. The chunks were closed once
+// written, so no more than MergeFanIn of them, and of their meta files, are ever open at once.
+func (j *job) cascade(chunks []*os.File) ([]*os.File, error) {
+	fanIn := j.opts.MergeFanIn
+	metaFiles := j.metaFiles
+	for len(chunks) > fanIn {
+		var merged, mergedMeta []*os.File
+		for start := 0; start < len(chunks); start += fanIn {
+			end := start + fanIn
+			if end > len(chunks) {
+				end = len(chunks)
+			}
+			var groupMeta []*os.File
+			if len(metaFiles) >= end {
+				groupMeta = metaFiles[start:end]
+			}
+
+			// A group of one is left as it is for the next pass
+			if end-start == 1 {
+				merged = append(merged, chunks[start])
+				mergedMeta = append(mergedMeta, groupMeta...)
+				continue
+			}
+			chunk, metaFile, err := j.mergeGroup(chunks[start:end], groupMeta)
+			if err != nil {
+				return nil, err
+			}
+			merged = append(merged, chunk)
+			if metaFile != nil {
+				mergedMeta = append(mergedMeta, metaFile)
+			}
+		}
+		chunks, metaFiles = merged, mergedMeta
+	}
+
+	// Open whatever is left again, to be merged into the output
+	reopened := make([]*os.File, 0, len(chunks))
+	for _, chunk := range chunks {
+		f, err := j.reopen(chunk)
+		if err != nil {
+			return nil, err
+		}
+		reopened = append(reopened, f)
+	}
+	reopenedMeta := make([]*os.File, 0, len(metaFiles))
+	for _, metaFile := range metaFiles {
+		f, err := j.reopen(metaFile)
+		if err != nil {
+			return nil, err
+		}
+		reopenedMeta = append(reopenedMeta, f)
+	}
+	j.metaFiles = reopenedMeta
+	return reopened, nil
+}
+
+// reopen opens a closed temporary file for reading, to be closed by closeCascade
+func (j *job) reopen(f *os.File) (*os.File, error) {
+	reopened, err := os.Open(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	j.reopened = append(j.reopened, reopened)
+	return reopened, nil
+}
+
+// closeCascade closes the files opened by cascade, and removes the intermediate files it wrote,
+// returning any errors doing so
+func (j *job) closeCascade() error {
+	var c cleanup
+	for _, f := range j.reopened {
+		c.close(f)
+	}
+	for f := range j.intermediate {
+		c.close(f)
+		c.remove(f.Name())
+	}
+	return c.err()
+}
+
+// mergeGroup merges a group of closed chunks, and their meta files if tracking, into a new
+// intermediate chunk file and meta file, which are closed once written. Unlike the final merge,
+// every line is kept, along with what is known about it, so that the final merge sees the same
+// occurrences as it would have from the chunks. Equal lines are kept in the order they were first
+// read, so that the first of them is always the one first read. The group is removed once merged,
+// except for chunks that are kept by KeepTempFiles.
+func (j *job) mergeGroup(chunks, metaFiles []*os.File) (*os.File, *os.File, error) {
+	// Open the group, closing it again once merged, which must be before it is removed on windows
+	scanners := make([]*sortableScanner, 0, len(chunks))
+	closeGroup := func() {
+		for _, ss := range scanners {
+			ss.release()
+			ss.f.Close()
+			if ss.metaFile != nil {
+				ss.metaFile.Close()
+			}
+		}
+		scanners = nil
+	}
+	defer closeGroup()
+	bufSize := bufferSize(j.opts.MergeBufferSize, defaultMergeBufferSize)
+	for i, chunk := range chunks {
+		f, err := os.Open(chunk.Name())
+		if err != nil {
+			return nil, nil, err
+		}
+		ss, err := j.newSortableScanner(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		scanners = append(scanners, ss)
+		if i < len(metaFiles) {
+			if ss.metaFile, err = os.Open(metaFiles[i].Name()); err != nil {
+				return nil, nil, err
+			}
+			ss.meta = bufio.NewReaderSize(j.limitReader(ss.metaFile), bufSize)
+			ss.withDups = j.auditing()
+			ss.withText = j.keyed()
+		}
+	}
+
+	chunk, err := CreateTemp(j.dir, "*.log")
+	if err != nil {
+		return nil, nil, err
+	}
+	j.addIntermediate(chunk)
+	j.event(Event{Kind: EventChunkCreated, Phase: PhaseMerging, File: chunk.Name(),
+		Message: fmt.Sprintf("Merging %d files into temporary file: %s", len(chunks), chunk.Name())})
+	var metaFile *os.File
+	if len(metaFiles) > 0 {
+		if metaFile, err = CreateTemp(j.dir, "*.meta"); err != nil {
+			return nil, nil, err
+		}
+		j.addIntermediate(metaFile)
+	}
+
+	merging := time.Now()
+	lines, written, err := j.mergeKeepingAll(chunk, metaFile, scanners)
+	if err == nil {
+		err = j.syncFile(chunk)
+	}
+	if err == nil {
+		err = chunk.Close()
+	}
+	if err == nil && metaFile != nil {
+		err = metaFile.Close()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	elapsed := time.Since(merging)
+	j.event(Event{Kind: EventChunkWritten, Phase: PhaseMerging, File: chunk.Name(), Lines: lines, Bytes: written,
+		Duration: elapsed, Message: fmt.Sprintf("Wrote %d lines (%d bytes) in %s to temporary file: %s",
+			lines, written, elapsed.Round(time.Microsecond), chunk.Name())})
+
+	closeGroup()
+	for i, merged := range chunks {
+		if !j.opts.KeepTempFiles || j.intermediate[merged] {
+			removeFile(merged.Name())
+		}
+		if i < len(metaFiles) {
+			removeFile(metaFiles[i].Name())
+		}
+	}
+	return chunk, metaFile, nil
+}
+
+// addIntermediate records an intermediate file, to be removed by closeCascade
+func (j *job) addIntermediate(f *os.File) {
+	if j.intermediate == nil {
+		j.intermediate = make(map[*os.File]bool)
+	}
+	j.intermediate[f] = true
+}
+
+// mergeKeepingAll merges the scanners into the chunk and meta file, without removing duplicates,
+// and returns how many lines and bytes were written
+func (j *job) mergeKeepingAll(chunk, metaFile *os.File, scanners []*sortableScanner) (uint64, uint64, error) {
+	h := make(scannerHeap, 0, len(scanners))
+	for _, ss := range scanners {
+		ok, err := ss.next()
+		if err != nil {
+			return 0, 0, err
+		}
+		if ok {
+			h = append(h, ss)
+		}
+	}
+	heap.Init(&h)
+
+	var w io.Writer = j.limitWriter(j.retryWriting(j.syncWriter(chunk)))
+	sum := j.newChunkSum()
+	if sum != nil {
+		w = io.MultiWriter(w, sum)
+	}
+	ew, err := j.encryptTemp(w)
+	if err != nil {
+		return 0, 0, err
+	}
+	writer := getWriter(ew, bufferSize(j.opts.WriteBufferSize, defaultBufferSize))
+	defer putWriter(writer)
+	var metaWriter *bufio.Writer
+	if metaFile != nil {
+		metaWriter = getWriter(j.limitWriter(metaFile), bufferSize(j.opts.WriteBufferSize, defaultBufferSize))
+		defer putWriter(metaWriter)
+	}
+	buf := make([]byte, 2*binary.MaxVarintLen64)
+	var lines, written uint64
+	var longest int
+	for len(h) > 0 {
+		if err := j.cancelErr(); err != nil {
+			return lines, written, err
+		}
+		ss := h[0]
+		if _, err := writer.Write(ss.token); err != nil {
+			return lines, written, err
+		}
+		if _, err := writer.WriteString(j.delim); err != nil {
+			return lines, written, err
+		}
+		if metaWriter != nil {
+			if err := appendMeta(metaWriter, buf, ss.count-1, ss.first, ss.dups); err != nil {
+				return lines, written, err
+			}
+			if ss.withText {
+				if err := appendText(metaWriter, buf, string(ss.text)); err != nil {
+					return lines, written, err
+				}
+			}
+		}
+		lines++
+		written += uint64(len(ss.token) + len(j.delim))
+		if len(ss.token) > longest {
+			longest = len(ss.token)
+		}
+
+		ok, err := ss.next()
+		if err != nil {
+			return lines, written, err
+		}
+		if !ok {
+			j.event(ss.merged())
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+	if metaWriter != nil {
+		if err := metaWriter.Flush(); err != nil {
+			return lines, written, err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return lines, written, err
+	}
+	if err := ew.Close(); err != nil {
+		return lines, written, err
+	}
+	if sum != nil {
+		j.addChunkSum(chunk.Name(), sum.Sum32())
+	}
+	j.addChunkLongest(chunk.Name(), longest)
+	return lines, written, nil
+}
@@ -0,0 +1,97 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRunMergeFanIn(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 500; i++ {
+		input.WriteString(fmt.Sprintf("%02d\n", (i*7919)%60))
+	}
+
+	// run returns the output, stats, and everything reported about the duplicates with the fan-in
+	run := func(fanIn int, format OutputFormat, partitions int) (string, Stats, []string) {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		var reported []string
+		dir := t.TempDir()
+		opts := Options{
+			TempDir:      dir,
+			TmpFileBytes: 40,
+			MergeFanIn:   fanIn,
+			Format:       format,
+			Partitions:   partitions,
+			OnProgress:   func(Progress) {},
+			OnEvent:      func(Event) {},
+			OnDuplicate: func(line string) error {
+				reported = append(reported, "dup "+line)
+				return nil
+			},
+			OnDuplicateCount: func(line string, count uint64) error {
+				reported = append(reported, fmt.Sprintf("count %s %d", line, count))
+				return nil
+			},
+			OnAudit: func(d Duplicate) error {
+				reported = append(reported, fmt.Sprintf("audit %s %d %d", d.Line, d.Position.Line, d.Retained.Line))
+				return nil
+			},
+		}
+		if partitions == 0 {
+			opts.OnLineMapped = func(pos Position, outputLine uint64) error {
+				reported = append(reported, fmt.Sprintf("map %d %d", pos.Line, outputLine))
+				return nil
+			}
+		}
+		stats, err := Run(outFile, opts, strings.NewReader(input.String()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if left, err := os.ReadDir(dir); err != nil || len(left) > 0 {
+			t.Fatalf("Expected every temporary file to be removed with a fan-in of %d; Got: %v %v", fanIn, left, err)
+		}
+		sort.Strings(reported)
+		stats.Elapsed, stats.PhaseElapsed, stats.SpillWait, stats.SortElapsed = 0, nil, 0, 0
+		return string(content), stats, reported
+	}
+
+	// Merging in passes finds the same duplicates, and reports the same about them, as merging at once
+	for _, format := range []OutputFormat{FormatText, FormatJSON} {
+		for _, partitions := range []int{0, 2} {
+			expected, expectedStats, expectedReported := run(0, format, partitions)
+			if expectedStats.Chunks < 20 {
+				t.Fatalf("Expected many chunks; Got: %+v", expectedStats)
+			}
+			for _, fanIn := range []int{2, 3, 1000} {
+				content, stats, reported := run(fanIn, format, partitions)
+				if content != expected {
+					t.Fatalf("Unexpected output with a fan-in of %d:\n%s", fanIn, content)
+				}
+				if !reflect.DeepEqual(stats, expectedStats) {
+					t.Fatalf("Unexpected stats with a fan-in of %d; Expected: %+v; Got: %+v", fanIn, expectedStats, stats)
+				}
+				if !reflect.DeepEqual(reported, expectedReported) {
+					t.Fatalf("Unexpected reports with a fan-in of %d:\n%v", fanIn, reported)
+				}
+			}
+		}
+	}
+
+	if _, err := Run(nil, Options{MergeFanIn: 1, DryRun: true}, strings.NewReader("a\n"), nil); err == nil {
+		t.Fatal("Expected an error with a fan-in of 1")
+	}
+}
@@ -0,0 +1,279 @@
+package dedup
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// checkpointVersion is the version of the format of checkpoint manifests, which are only resumed
+// from if they are the same version
+const checkpointVersion = 1
+
+// checkpoint is the manifest of a run with Checkpoint, rewritten each time a chunk has been written.
+// Every line of the input before Offset is in its chunks, so a run resuming from it skips that much of
+// the input, and continues with the counts of the lines read up to there.
+type checkpoint struct {
+	Version         int               `json:"version"`
+	Delimiter       string            `json:"delimiter"`
+	Partitions      int               `json:"partitions"`
+	PartitionBounds []string          `json:"partition_bounds,omitempty"`
+	InputSize       uint64            `json:"input_size,omitempty"`
+	Offset          uint64            `json:"offset"`
+	LinesRead       uint64            `json:"lines_read"`
+	BytesRead       uint64            `json:"bytes_read"`
+	LinesSkipped    uint64            `json:"lines_skipped"`
+	LinesSeen       uint64            `json:"lines_seen,omitempty"`
+	LinesDuplicate  uint64            `json:"lines_duplicate"`
+	Chunks          []checkpointChunk `json:"chunks"`
+}
+
+// checkpointChunk is a chunk in a checkpoint manifest, with the path, line count, byte size, CRC-32
+// checksum, and longest line length of its file of each partition
+type checkpointChunk struct {
+	Files   []string `json:"files"`
+	Lines   []uint64 `json:"lines"`
+	Bytes   []uint64 `json:"bytes"`
+	CRC32   []uint32 `json:"crc32"`
+	Longest []int    `json:"longest,omitempty"`
+}
+
+// checkpointState is where the input had been read to when a chunk was started, which is saved to
+// the manifest once the chunk, and every chunk before it, has been written
+type checkpointState struct {
+	offset         uint64
+	linesRead      uint64
+	bytesRead      uint64
+	linesSkipped   uint64
+	linesSeen      uint64
+	linesDuplicate uint64
+}
+
+// validateCheckpoint returns an error if the options can not be used with Checkpoint, because the
+// lines of a chunk would not be all of the lines read before it, or more than the chunks would be
+// needed to resume
+func validateCheckpoint(opts Options) error {
+	switch {
+	case opts.Checkpoint == "" && opts.Resume:
+		return fmt.Errorf("resuming requires a checkpoint")
+	case opts.Checkpoint == "":
+		return nil
+	case opts.BuildWorkers > 1 || opts.ReadWorkers > 1:
+		return fmt.Errorf("checkpoints are not supported with build workers or read workers")
+	case opts.PassthroughUnkept:
+		return fmt.Errorf("checkpoints are not supported when passing through unkept lines")
+	case opts.OnDuplicateCount != nil || opts.OnAudit != nil || opts.OnLineMapped != nil || opts.Format.records():
+		return fmt.Errorf("checkpoints are not supported when tracking where lines were read, for counts, audits, line maps, or %s output", opts.Format)
+	}
+	for _, rule := range opts.Rules {
+		if rule.Action == RulePassthrough {
+			return fmt.Errorf("checkpoints are not supported with passthrough rules")
+		}
+	}
+	return nil
+}
+
+// checkpointing returns true if the run writes a checkpoint manifest
+func (j *job) checkpointing() bool {
+	return j.opts.Checkpoint != ""
+}
+
+// trackInput counts the bytes of the input the scanner has consumed, for the offset of checkpoints
+func (j *job) trackInput(scanner *bufio.Scanner) {
+	if !j.checkpointing() {
+		return
+	}
+	split := j.split()
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		j.scanned += uint64(advance)
+		return advance, token, err
+	})
+}
+
+// checkpointState returns where the input has been read to, up to the end of the last line read
+func (j *job) checkpointState() checkpointState {
+	return checkpointState{
+		offset:         j.lineEnd,
+		linesRead:      j.stats.LinesRead,
+		bytesRead:      j.stats.BytesRead,
+		linesSkipped:   j.stats.LinesSkipped,
+		linesSeen:      j.stats.LinesSeen,
+		linesDuplicate: j.stats.LinesDuplicate,
+	}
+}
+
+// saveCheckpoint adds a chunk that has been written to the manifest, and replaces the manifest file
+// with it, through a temporary file that is renamed over it, so that it is never partially written
+func (j *job) saveCheckpoint(c *pendingChunk) error {
+	if !j.checkpointing() {
+		return nil
+	}
+	if j.checkpoint == nil {
+		j.checkpoint = &checkpoint{Version: checkpointVersion, Delimiter: j.delim, Partitions: j.partitions(), InputSize: j.inputSize}
+	}
+	m := j.checkpoint
+	m.PartitionBounds = j.partitionBounds
+	m.Offset, m.LinesRead, m.BytesRead = c.state.offset, c.state.linesRead, c.state.bytesRead
+	m.LinesSkipped, m.LinesSeen, m.LinesDuplicate = c.state.linesSkipped, c.state.linesSeen, c.state.linesDuplicate
+	chunk := checkpointChunk{Bytes: c.written, CRC32: c.sums, Longest: c.longest}
+	for i, f := range c.files {
+		chunk.Files = append(chunk.Files, f.Name())
+		chunk.Lines = append(chunk.Lines, uint64(len(c.parts[i])))
+	}
+	m.Chunks = append(m.Chunks, chunk)
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp := j.opts.Checkpoint + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = j.syncFile(f)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, j.opts.Checkpoint)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if j.opts.Durable {
+		err = syncDir(filepath.Dir(j.opts.Checkpoint))
+	}
+	return err
+}
+
+// resume adds the chunks of the checkpoint manifest to the chunk writer, after checking that each
+// is still as it was written, and skips the part of the input they hold, with Resume. Without a
+// manifest, the run starts from the beginning. It returns true if the run resumed.
+func (j *job) resume(cw *chunkWriter, inFile io.Reader) (bool, error) {
+	if !j.checkpointing() {
+		return false, nil
+	}
+	if !j.opts.Resume {
+		return false, nil
+	}
+	b, err := os.ReadFile(j.opts.Checkpoint)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var m checkpoint
+	if err = json.Unmarshal(b, &m); err != nil {
+		return false, fmt.Errorf("invalid checkpoint %s: %w", j.opts.Checkpoint, err)
+	}
+	switch {
+	case m.Version != checkpointVersion:
+		return false, fmt.Errorf("checkpoint %s is version %d, not %d", j.opts.Checkpoint, m.Version, checkpointVersion)
+	case m.Delimiter != j.delim || m.Partitions != j.partitions():
+		return false, fmt.Errorf("checkpoint %s was written with another delimiter or number of partitions", j.opts.Checkpoint)
+	case m.InputSize != 0 && j.inputSize != 0 && m.InputSize != j.inputSize:
+		return false, fmt.Errorf("checkpoint %s was written for an input of %d bytes, not %d", j.opts.Checkpoint, m.InputSize, j.inputSize)
+	}
+
+	for _, chunk := range m.Chunks {
+		for i, path := range chunk.Files {
+			f, err := openCheckpointChunk(path, chunk.Bytes[i], chunk.CRC32[i])
+			if err != nil {
+				return false, fmt.Errorf("can not resume from checkpoint %s: %w", j.opts.Checkpoint, err)
+			}
+			cw.chunks = append(cw.chunks, f)
+			j.addChunkSum(path, chunk.CRC32[i])
+			if i < len(chunk.Longest) {
+				j.addChunkLongest(path, chunk.Longest[i])
+			}
+			j.stats.Chunks++
+			j.stats.TmpLines += chunk.Lines[i]
+			j.stats.TmpBytes += chunk.Bytes[i]
+			j.opts.Metrics.addChunk(chunk.Bytes[i])
+			j.tmpCap.add(chunk.Bytes[i])
+			if len(chunk.Files) > 1 {
+				if j.partitionLines == nil {
+					j.partitionLines = make([]uint64, len(chunk.Files))
+				}
+				j.partitionLines[i] += chunk.Lines[i]
+			}
+			if j.opts.MergeFanIn > 0 {
+				f.Close()
+			}
+		}
+	}
+
+	// The input is skipped without being read, if it can be
+	if seeker, ok := inFile.(io.Seeker); ok {
+		_, err = seeker.Seek(int64(m.Offset), io.SeekCurrent)
+	} else {
+		_, err = io.CopyN(io.Discard, inFile, int64(m.Offset))
+	}
+	if err != nil {
+		return false, fmt.Errorf("can not skip the input read before checkpoint %s: %w", j.opts.Checkpoint, err)
+	}
+	j.checkpoint, j.partitionBounds = &m, m.PartitionBounds
+	j.scanned, j.lineEnd = m.Offset, m.Offset
+	j.stats.LinesRead, j.stats.BytesRead = m.LinesRead, m.BytesRead
+	j.stats.LinesSkipped, j.stats.LinesSeen, j.stats.LinesDuplicate = m.LinesSkipped, m.LinesSeen, m.LinesDuplicate
+	j.event(Event{Kind: EventResumed, Phase: PhaseSplitting, File: j.opts.Checkpoint, Lines: m.LinesRead, Bytes: m.Offset,
+		Message: fmt.Sprintf("Resumed from checkpoint %s after %d lines (%d bytes) in %d chunks", j.opts.Checkpoint, m.LinesRead, m.Offset, len(m.Chunks))})
+	return true, nil
+}
+
+// openCheckpointChunk opens a chunk file of a checkpoint, and returns an error unless it is the size
+// and has the checksum it was written with
+func openCheckpointChunk(path string, size uint64, sum uint32) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, f)
+	if err == nil && (uint64(n) != size || h.Sum32() != sum) {
+		err = fmt.Errorf("chunk file %s has changed since it was written", path)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// checkpointed returns true if the chunk file is in the checkpoint manifest
+func (j *job) checkpointed(name string) bool {
+	if j.checkpoint == nil {
+		return false
+	}
+	for _, chunk := range j.checkpoint.Chunks {
+		for _, f := range chunk.Files {
+			if f == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeCheckpoint removes the manifest once the run has succeeded, and its chunks with it
+func (j *job) removeCheckpoint() error {
+	if !j.checkpointing() {
+		return nil
+	}
+	if err := os.Remove(j.opts.Checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
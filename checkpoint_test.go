@@ -0,0 +1,156 @@
+package dedup
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCheckpointResume(t *testing.T) {
+	expected, err := os.ReadFile("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Run(nil, Options{DryRun: true}, strings.NewReader(string(expected)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "checkpoint.json")
+	opts := Options{TmpFileBytes: 20 * 50, TempDir: dir, Checkpoint: manifest, Resume: true}
+
+	// Fail the run part way through, once some chunks have been written
+	interrupted := errors.New("interrupted")
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+	failing := opts
+	failing.DryRun = true
+	if _, err = Run(nil, failing, &interruptedReader{r: inFile, n: 6000, err: interrupted}, nil); !errors.Is(err, interrupted) {
+		t.Fatalf("Expected the run to be interrupted; Got: %v", err)
+	}
+	m, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatalf("Expected the checkpoint to be left behind: %v", err)
+	}
+	left, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range left {
+		if entry.Name() != "checkpoint.json" && !strings.Contains(string(m), entry.Name()) {
+			t.Fatalf("Expected %s to be removed, since it is not in the checkpoint", entry.Name())
+		}
+	}
+
+	// Resuming gives the same output and counts as a run that was never interrupted
+	var resumed bool
+	opts.OnEvent = func(e Event) {
+		resumed = resumed || e.Kind == EventResumed
+	}
+	if _, err = inFile.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	outFile, err := os.CreateTemp(dir, "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outFile.Close()
+	stats, err := Run(outFile, opts, inFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resumed {
+		t.Fatal("Expected the run to resume from the checkpoint")
+	}
+	if stats.LinesRead != want.LinesRead || stats.LinesUnique != want.LinesUnique || stats.LinesDuplicate != want.LinesDuplicate {
+		t.Fatalf("Expected the counts of an uninterrupted run %+v; Got: %+v", want, stats)
+	}
+	b, err := os.ReadFile(outFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(len(b)) != want.BytesOut {
+		t.Fatalf("Expected %d bytes written; Got: %d", want.BytesOut, len(b))
+	}
+
+	// The checkpoint and chunks are removed once the run succeeds
+	if _, err = os.Stat(manifest); !os.IsNotExist(err) {
+		t.Fatalf("Expected the checkpoint to be removed: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected only the output left; Got: %d files", len(entries))
+	}
+}
+
+func TestRunCheckpointChanged(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "checkpoint.json")
+	opts := Options{TmpFileBytes: 20 * 50, TempDir: dir, Checkpoint: manifest, Resume: true, DryRun: true}
+
+	interrupted := errors.New("interrupted")
+	b, err := os.ReadFile("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Run(nil, opts, &interruptedReader{r: strings.NewReader(string(b)), n: 6000, err: interrupted}, nil); !errors.Is(err, interrupted) {
+		t.Fatalf("Expected the run to be interrupted; Got: %v", err)
+	}
+
+	// A chunk that is no longer as it was written can not be resumed from
+	m, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := strings.SplitN(strings.SplitN(string(m), `"files":["`, 2)[1], `"`, 2)[0]
+	if err = os.WriteFile(chunk, []byte("changed\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Run(nil, opts, strings.NewReader(string(b)), nil); err == nil {
+		t.Fatal("Expected an error resuming from a changed chunk")
+	}
+}
+
+// interruptedReader reads up to n bytes of the reader, then fails with the error
+type interruptedReader struct {
+	r   io.Reader
+	n   int
+	err error
+}
+
+func (r *interruptedReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, r.err
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	n, err := r.r.Read(p)
+	r.n -= n
+	return n, err
+}
+
+func TestValidateCheckpoint(t *testing.T) {
+	if err := validateCheckpoint(Options{Resume: true}); err == nil {
+		t.Fatal("Expected an error resuming without a checkpoint")
+	}
+	if err := validateCheckpoint(Options{Checkpoint: "checkpoint.json", BuildWorkers: 2}); err == nil {
+		t.Fatal("Expected an error with build workers")
+	}
+	if err := validateCheckpoint(Options{Checkpoint: "checkpoint.json", Format: FormatJSON}); err == nil {
+		t.Fatal("Expected an error with JSON output")
+	}
+	if err := validateCheckpoint(Options{Checkpoint: "checkpoint.json", Resume: true}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,42 @@
+package dedup
+
+import (
+	"fmt"
+	"hash"
+	"hash/crc32"
+)
+
+// checksumming returns true if the CRC-32 checksum of each temporary chunk file is computed as it is
+// written, with ChecksumTempFiles, to record it in the manifest, with Checkpoint, or to verify it once
+// written, with VerifyWrites
+func (j *job) checksumming() bool {
+	return j.opts.ChecksumTempFiles || j.checkpointing() || j.opts.VerifyWrites
+}
+
+// newChunkSum returns the hash of the checksum of a chunk file being written, or nil if not checksumming
+func (j *job) newChunkSum() hash.Hash32 {
+	if !j.checksumming() {
+		return nil
+	}
+	return crc32.NewIEEE()
+}
+
+// addChunkSum records the checksum of a chunk file that has been written, to verify it once it is
+// read back while merging, with ChecksumTempFiles
+func (j *job) addChunkSum(name string, sum uint32) {
+	if !j.opts.ChecksumTempFiles {
+		return
+	}
+	if j.chunkSums == nil {
+		j.chunkSums = make(map[string]uint32)
+	}
+	j.chunkSums[name] = sum
+}
+
+// verifyChunk returns an error if the checksum of a chunk file read back is not the one it was written with
+func verifyChunk(name string, sum, want uint32) error {
+	if sum != want {
+		return fmt.Errorf("temporary file %s is corrupt: its CRC-32 checksum is %08x, but it was written with %08x", name, sum, want)
+	}
+	return nil
+}
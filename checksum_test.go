@@ -0,0 +1,43 @@
+package dedup
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunChecksumTempFiles(t *testing.T) {
+	b, err := os.ReadFile("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A temporary file that changes once written, still sorted, is only noticed with checksums,
+	// whether it is read through a buffer, memory mapped, or merged again by a cascade
+	corrupt := func(e Event) {
+		if e.Kind == EventChunkWritten && e.Phase == PhaseSplitting {
+			if err := os.WriteFile(e.File, []byte("a\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, opts := range []Options{{}, {MergeMmap: true}, {MergeFanIn: 2}, {Partitions: 2}} {
+		opts.TmpFileBytes, opts.DryRun, opts.OnEvent = 20*50, true, corrupt
+		if _, err = Run(nil, opts, strings.NewReader(string(b)), nil); err != nil {
+			t.Fatalf("Expected the corruption to go unnoticed without checksums; Got: %v", err)
+		}
+		opts.ChecksumTempFiles = true
+		if _, err = Run(nil, opts, strings.NewReader(string(b)), nil); err == nil || !strings.Contains(err.Error(), "is corrupt") {
+			t.Fatalf("Expected a corrupt temporary file with %+v; Got: %v", opts, err)
+		}
+	}
+
+	// Files as they were written pass
+	stats, err := Run(nil, Options{TmpFileBytes: 20 * 50, DryRun: true, ChecksumTempFiles: true, MergeFanIn: 2}, strings.NewReader(string(b)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Chunks < 2 {
+		t.Fatalf("Expected several temporary files; Got: %d", stats.Chunks)
+	}
+}
@@ -0,0 +1,268 @@
+package dedup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// defaultSortWorkers is how many chunks are sorted and written at once while the input is read,
+// when not configured
+const defaultSortWorkers = 1
+
+// pendingChunk is the set of a full chunk, that is sorted and written to its temporary file
+// by a worker goroutine, while the input keeps being read into the next set, or the compact set
+// used instead of it. With Partitions,
+// the lines of each partition are written to a temporary file of their own.
+type pendingChunk struct {
+	files     []*os.File
+	metaFiles []*os.File
+	set       map[string]struct{}
+	compact   compactSet
+	meta      map[string]lineMeta
+
+	// The bytes of the shared Budget the set holds, which are given back once it is written
+	held uint64
+
+	// Set by the worker once it is done: all the sorted lines, those that were written, those
+	// written to the file of each partition, how many bytes they were and the length of the longest
+	// of them, how long sorting them and writing each file took, and any error
+	all     []string
+	keys    []string
+	parts   [][]string
+	written []uint64
+	longest []int
+	sorting time.Duration
+	writing []time.Duration
+	err     error
+	done    chan struct{}
+
+	// Where the input had been read to when the chunk was started, and the CRC-32 checksum of each
+	// file once written, with Checkpoint
+	state checkpointState
+	sums  []uint32
+}
+
+// chunkWriter hands the sets of full chunks to worker goroutines to sort and write, so that reading
+// the input is not paused while they are. No more than workers chunks are in progress at once.
+// The chunks are finished in the order they were started, on the reading goroutine, so that
+// the job is only ever updated by it, and callbacks are never called concurrently.
+type chunkWriter struct {
+	j       *job
+	workers int
+	pending []*pendingChunk
+
+	// Every chunk file created, in order, even if writing to it failed
+	chunks []*os.File
+}
+
+// newChunkWriter returns a chunk writer with the configured number of workers, up to Workers
+func (j *job) newChunkWriter() *chunkWriter {
+	workers := j.opts.SortWorkers
+	if workers == 0 {
+		workers = defaultSortWorkers
+	}
+	return &chunkWriter{j: j, workers: j.limitWorkers(workers)}
+}
+
+// write creates new temporary files for the set and its meta, then sorts and writes the set to them.
+// Unless the workers are disabled, it is written on a worker goroutine, once one is free. The bytes
+// of the shared Budget the set holds are given back once it is written, or if it fails to be.
+func (cw *chunkWriter) write(set map[string]struct{}, meta map[string]lineMeta, held uint64) error {
+	c := &pendingChunk{set: set, meta: meta, held: held, done: make(chan struct{})}
+	return cw.start(c, func(size int) []string { return sampleSet(set, size) })
+}
+
+// writeCompact is write for a compact set, whose lines are never tracked
+func (cw *chunkWriter) writeCompact(set compactSet, held uint64) error {
+	return cw.start(&pendingChunk{compact: set, held: held, done: make(chan struct{})}, set.sample)
+}
+
+// start creates the temporary files of the chunk, choosing the ranges of the partitions from a sample
+// of its lines if they have not been yet, and writes it
+func (cw *chunkWriter) start(c *pendingChunk, sample func(size int) []string) error {
+	// A chunk that is never written gives back the bytes of its set here instead
+	writing := false
+	defer func() {
+		if !writing {
+			cw.j.opts.Budget.give(c.held)
+		}
+	}()
+
+	// Reading only waits here if every worker is still busy with an earlier chunk
+	if cw.workers > 0 && len(cw.pending) >= cw.workers {
+		waiting := time.Now()
+		for len(cw.pending) >= cw.workers {
+			if err := cw.finishOldest(); err != nil {
+				return err
+			}
+		}
+		cw.j.stats.SpillWait += time.Since(waiting)
+	}
+
+	// Every chunk has a file for each partition, so that the partition of the chunk file at
+	// index i is always i modulo the number of partitions
+	j := cw.j
+	c.state = j.checkpointState()
+	j.splitRanges(sample)
+	if err := j.checkFreeSpace(j.tempDir()); err != nil {
+		return err
+	}
+	for i := 0; i < j.partitions(); i++ {
+		chunkFile, err := CreateTemp(j.dir, "*.log")
+		if err != nil {
+			return err
+		}
+		cw.chunks = append(cw.chunks, chunkFile)
+		c.files = append(c.files, chunkFile)
+		j.event(Event{Kind: EventChunkCreated, Phase: PhaseSplitting, File: chunkFile.Name(),
+			Message: fmt.Sprint("Creating temporary file: ", chunkFile.Name())})
+		metaFile, err := j.createMeta()
+		if err != nil {
+			return err
+		}
+		c.metaFiles = append(c.metaFiles, metaFile)
+	}
+
+	writing = true
+	if cw.workers <= 0 {
+		c.write(j)
+		return j.finishChunk(c)
+	}
+	cw.pending = append(cw.pending, c)
+	go c.write(j)
+	return nil
+}
+
+// finishOldest waits for the oldest chunk in progress to be written, and finishes it
+func (cw *chunkWriter) finishOldest() error {
+	c := cw.pending[0]
+	cw.pending = cw.pending[1:]
+	<-c.done
+	return cw.j.finishChunk(c)
+}
+
+// wait finishes every chunk in progress, and returns the first error of any of them
+func (cw *chunkWriter) wait() error {
+	var err error
+	for len(cw.pending) > 0 {
+		if finishErr := cw.finishOldest(); err == nil {
+			err = finishErr
+		}
+	}
+	return err
+}
+
+// write sorts the set and writes it to the chunk files, and what is known about its lines to the
+// meta files. It only reads the job, so it can run on a worker goroutine.
+func (c *pendingChunk) write(j *job) {
+	defer close(c.done)
+	defer j.opts.Budget.give(c.held)
+	sorting := time.Now()
+	if c.compact != nil {
+		c.all = c.compact.sortedKeys()
+	} else {
+		c.all = sortKeys(c.set)
+	}
+	c.sorting = time.Since(sorting)
+	c.set, c.compact = nil, nil
+	c.keys = j.limitKeys(c.all)
+	c.parts = j.partitionKeys(c.keys)
+	c.written = make([]uint64, len(c.files))
+	c.longest = make([]int, len(c.files))
+	c.writing = make([]time.Duration, len(c.files))
+	if j.checksumming() {
+		c.sums = make([]uint32, len(c.files))
+	}
+	for i, f := range c.files {
+		writing := time.Now()
+		var w io.Writer = j.capTmp(j.limitWriter(j.retryWriting(j.syncWriter(f))))
+		sum := j.newChunkSum()
+		if sum != nil {
+			w = io.MultiWriter(w, sum)
+		}
+		ew, err := j.encryptTemp(w)
+		if err != nil {
+			c.err = err
+			return
+		}
+		c.longest[i] = longestLine(c.parts[i])
+		c.written[i], c.err = writeSlice(ew, c.parts[i], j.opts.WriteBufferSize, j.delim, nil)
+		if c.err == nil {
+			c.err = ew.Close()
+		}
+		if sum != nil {
+			c.sums[i] = sum.Sum32()
+		}
+		if c.err == nil {
+			c.err = j.syncFile(f)
+		}
+		if c.err == nil && sum != nil {
+			c.err = j.verifyWrittenChunk(f.Name(), uint64(len(c.parts[i])), c.sums[i])
+		}
+		if c.err == nil {
+			c.err = j.writeMeta(c.metaFiles[i], c.meta, c.parts[i])
+		}
+		c.writing[i] = time.Since(writing)
+		if c.err != nil {
+			return
+		}
+	}
+}
+
+// finishChunk records a chunk that has been written in the stats of the job
+func (j *job) finishChunk(c *pendingChunk) error {
+	if len(c.keys) < len(c.all) {
+		j.stats.Limited = true
+	}
+	j.shards.sample(c.keys)
+	err := c.err
+	j.stats.SortElapsed += c.sorting
+	if err == nil {
+		j.event(Event{Kind: EventChunkSorted, Phase: PhaseSplitting, File: c.files[0].Name(), Lines: uint64(len(c.all)),
+			Duration: c.sorting, Message: fmt.Sprintf("Sorted %d lines in %s for temporary file: %s", len(c.all),
+				c.sorting.Round(time.Microsecond), c.files[0].Name())})
+		err = j.mapRemoved(c.meta, c.all[len(c.keys):])
+	}
+	if err == nil {
+		err = j.saveCheckpoint(c)
+	}
+	for i, f := range c.files {
+		lines, written := uint64(len(c.parts[i])), c.written[i]
+		if c.sums != nil {
+			j.addChunkSum(f.Name(), c.sums[i])
+		}
+		j.addChunkLongest(f.Name(), c.longest[i])
+		j.stats.Chunks++
+		j.stats.TmpLines += lines
+		j.stats.ChunkLines = append(j.stats.ChunkLines, lines)
+		if len(c.files) > 1 {
+			if j.partitionLines == nil {
+				j.partitionLines = make([]uint64, len(c.files))
+			}
+			j.partitionLines[i] += lines
+		}
+		j.stats.TmpBytes += written
+		j.opts.Metrics.addChunk(written)
+		if err != nil {
+			continue
+		}
+		j.event(Event{Kind: EventChunkWritten, Phase: PhaseSplitting, File: f.Name(), Lines: lines, Bytes: written,
+			Duration: c.writing[i], Message: fmt.Sprintf("Wrote %d lines (%d bytes) in %s to temporary file: %s",
+				lines, written, c.writing[i].Round(time.Microsecond), f.Name())})
+
+		// A cascaded merge opens the chunks again, a group at a time
+		if j.opts.MergeFanIn > 0 {
+			f.Close()
+			if c.metaFiles[i] != nil {
+				c.metaFiles[i].Close()
+			}
+		}
+	}
+
+	// The sorted lines are no longer needed, since the samples of the shards are copies
+	putKeys(c.all)
+	c.all, c.keys, c.parts = nil, nil, nil
+	return err
+}
@@ -0,0 +1,59 @@
+package dedup
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunSortWorkers(t *testing.T) {
+	input := "c\na\nb\na\nc\na\nd\nc\ne\nf\nb\ng\n"
+	expected := "a\nb\nc\nd\ne\nf\ng\n"
+
+	// On the reading goroutine, and with one or more workers
+	for _, workers := range []int{-1, 0, 1, 4} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		// The chunks are written in the order they were created
+		var created, written []string
+		opts := Options{
+			TmpFileBytes: 4,
+			SortWorkers:  workers,
+			Workers:      4,
+			OnProgress:   func(Progress) {},
+			OnEvent: func(e Event) {
+				switch e.Kind {
+				case EventChunkCreated:
+					created = append(created, e.File)
+				case EventChunkWritten:
+					written = append(written, e.File)
+				}
+			},
+			OnDuplicateCount: func(line string, count uint64) error { return nil },
+		}
+		stats, err := Run(outFile, opts, strings.NewReader(input), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Chunks < 3 || stats.LinesUnique != 7 || !reflect.DeepEqual(created, written) {
+			t.Fatalf("Unexpected stats with %d workers: %+v; Created: %v; Written: %v", workers, stats, created, written)
+		}
+		// Reading only waits for a worker once as many chunks as there are workers are in flight
+		if (workers < 0 && stats.SpillWait != 0) || (workers == 1 && stats.SpillWait == 0) {
+			t.Fatalf("Unexpected spill wait with %d workers: %s", workers, stats.SpillWait)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expected {
+			t.Fatalf("Unexpected output with %d workers:\n%s", workers, content)
+		}
+	}
+}
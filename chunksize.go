@@ -0,0 +1,47 @@
+package dedup
+
+// setLineOverhead is about how many bytes a set uses for each of its lines besides the line itself:
+// the string header of its key, its slot in the map with the room left for the map to grow, and the
+// rounding of its allocation. Sets of short lines use several times the bytes of their lines.
+const setLineOverhead = 48
+
+// lineLengths tracks the average length of the distinct lines read, with AdaptiveChunks, so that the
+// bytes of lines a set is spilled at can be scaled down by the overhead of each of its lines
+type lineLengths struct {
+	lines    uint64
+	bytes    uint64
+	overhead uint64
+}
+
+// newLineLengths returns the line lengths to track, or nil without AdaptiveChunks
+func (j *job) newLineLengths() *lineLengths {
+	if !j.opts.AdaptiveChunks {
+		return nil
+	}
+	return &lineLengths{overhead: setLineOverhead}
+}
+
+// setOverhead changes the overhead of each line, for sets other than maps
+func (l *lineLengths) setOverhead(overhead uint64) {
+	if l != nil {
+		l.overhead = overhead
+	}
+}
+
+// add tracks a distinct line of n bytes, with its delimiter
+func (l *lineLengths) add(n uint64) {
+	if l != nil {
+		l.lines++
+		l.bytes += n
+	}
+}
+
+// threshold returns the bytes of lines at which a set is spilled, for a set using about budget bytes
+// of memory with the lines seen so far. Without lengths, it is the budget itself.
+func (l *lineLengths) threshold(budget uint64) uint64 {
+	if l == nil || l.lines == 0 {
+		return budget
+	}
+	avg := float64(l.bytes) / float64(l.lines)
+	return uint64(float64(budget) * avg / (avg + float64(l.overhead)))
+}
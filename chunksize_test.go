@@ -0,0 +1,52 @@
+package dedup
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLineLengths(t *testing.T) {
+	var none *lineLengths
+	if threshold := none.threshold(1000); threshold != 1000 {
+		t.Fatalf("Expected the budget without lengths; Got: %d", threshold)
+	}
+
+	// Lines as long as the overhead of each can use half the budget
+	l := &lineLengths{overhead: setLineOverhead}
+	l.add(setLineOverhead)
+	l.add(setLineOverhead)
+	if threshold := l.threshold(1000); threshold != 500 {
+		t.Fatalf("Expected a threshold of 500 bytes; Got: %d", threshold)
+	}
+}
+
+func TestRunAdaptiveChunks(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 100; i++ {
+		input.WriteString(strings.Repeat("x", i%10+1) + "\n")
+	}
+
+	// Short lines spill into more chunks once their overhead is counted
+	var chunks [2]int
+	for i, adaptive := range []bool{false, true} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		stats, err := Run(outFile, Options{TmpFileBytes: 100, AdaptiveChunks: adaptive}, strings.NewReader(input.String()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 10 {
+			t.Fatalf("Expected 10 unique lines; Got: %+v", stats)
+		}
+		chunks[i] = stats.Chunks
+	}
+	if chunks[1] <= chunks[0] {
+		t.Fatalf("Expected more chunks with adaptive chunks; Got: %d, then %d", chunks[0], chunks[1])
+	}
+}
@@ -0,0 +1,83 @@
+package dedup
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// multiError is several errors joined into one, such as the failure of a run and those of cleaning
+// up after it. errors.Is and errors.As match any of them.
+type multiError []error
+
+func (m multiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is returns true if any of the errors is the target
+func (m multiError) Is(target error) bool {
+	for _, err := range m {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors that matches the target, and sets the target to it
+func (m multiError) As(target interface{}) bool {
+	for _, err := range m {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinErrors returns the errors that are not nil joined into one, the only one if there is just one,
+// or nil if there are none
+func joinErrors(errs ...error) error {
+	var joined multiError
+	for _, err := range errs {
+		if err != nil {
+			joined = append(joined, err)
+		}
+	}
+	switch len(joined) {
+	case 0:
+		return nil
+	case 1:
+		return joined[0]
+	}
+	return joined
+}
+
+// cleanup collects the errors of closing and removing temporary files, so that every file is still
+// cleaned up after one fails, and the failures are returned once it is done. Files that were already
+// closed or removed are not failures.
+type cleanup struct {
+	errs []error
+}
+
+// close closes the file
+func (c *cleanup) close(f *os.File) {
+	if err := f.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+		c.errs = append(c.errs, err)
+	}
+}
+
+// remove removes the file
+func (c *cleanup) remove(name string) {
+	if err := removeFile(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		c.errs = append(c.errs, err)
+	}
+}
+
+// err returns the errors of cleaning up joined into one, or nil
+func (c *cleanup) err() error {
+	return joinErrors(c.errs...)
+}
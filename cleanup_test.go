@@ -0,0 +1,61 @@
+package dedup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJoinErrors(t *testing.T) {
+	if err := joinErrors(nil, nil); err != nil {
+		t.Fatalf("Expected no error; Got: %v", err)
+	}
+	first := errors.New("first")
+	if err := joinErrors(nil, first); err != first {
+		t.Fatalf("Expected the only error; Got: %v", err)
+	}
+
+	_, notExist := os.Open(filepath.Join(t.TempDir(), "missing"))
+	err := joinErrors(first, notExist)
+	if err.Error() != first.Error()+"; "+notExist.Error() {
+		t.Fatalf("Expected both messages; Got: %v", err)
+	}
+	if !errors.Is(err, first) || !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected the joined error to match both errors; Got: %v", err)
+	}
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) {
+		t.Fatalf("Expected the joined error to match the path error; Got: %v", err)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "chunk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files that were already closed or removed are not failures
+	var c cleanup
+	c.close(f)
+	c.close(f)
+	c.remove(f.Name())
+	c.remove(f.Name())
+	if err = c.err(); err != nil {
+		t.Fatalf("Expected no error; Got: %v", err)
+	}
+
+	// A file that can not be removed is
+	if err = os.Mkdir(filepath.Join(dir, "full"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "full", "file"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	c.remove(filepath.Join(dir, "full"))
+	if err = c.err(); err == nil {
+		t.Fatal("Expected an error removing a directory that is not empty")
+	}
+}
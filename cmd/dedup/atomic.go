@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// addAtomicFlag registers the atomic flag on the flag set
+func addAtomicFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("atomic", false, "write the output to a temporary file in its directory, and only link it to the output file once "+
+		"the run succeeds, so that a partial output is never seen, nor an output created meanwhile replaced. with fsync, the file and its directory are synced first")
+}
+
+// createAtomicFile creates a temporary file in the same directory as the output file, so that it can
+// be renamed to it once complete, and no one reads the output while it is still being written
+func createAtomicFile(path string) (*os.File, error) {
+	if path == "" {
+		return nil, fmt.Errorf("out flag must be non-empty")
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return nil, fmt.Errorf("output file already exists: %s", path)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".dedup.*")
+	if err != nil {
+		return nil, err
+	}
+	// The same mode as createOutFile, rather than the private mode of a temporary file
+	if err = f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// commitAtomic closes each temporary file, then hard links it to its output file and removes it, so
+// that an output file created since it was checked for fails with an error rather than being replaced,
+// which a rename would do. With sync, each file is flushed to disk first, and its directory after, so
+// that the output survives a crash once it is there.
+func commitAtomic(files []*os.File, paths []string, sync bool) error {
+	for i, f := range files {
+		if sync {
+			if err := f.Sync(); err != nil {
+				return err
+			}
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if err := os.Link(f.Name(), paths[i]); err != nil {
+			if os.IsExist(err) {
+				return fmt.Errorf("output file already exists: %s", paths[i])
+			}
+			return err
+		}
+		if err := os.Remove(f.Name()); err != nil {
+			return err
+		}
+		if sync {
+			if err := syncDir(filepath.Dir(paths[i])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
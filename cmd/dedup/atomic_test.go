@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommitAtomic(t *testing.T) {
+	dir := t.TempDir()
+	outFileLoc := filepath.Join(dir, "out.log")
+	f, err := createAtomicFile(outFileLoc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteString("a\nb\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err = commitAtomic([]*os.File{f}, []string{outFileLoc}, true); err != nil {
+		t.Fatal(err)
+	}
+	expectFile(t, outFileLoc, "a\nb\n")
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Fatalf("Expected only the output file to be left; Got: %d files, %v", len(entries), err)
+	}
+}
+
+func TestCommitAtomicExisting(t *testing.T) {
+	// An output file created by anything else while the temporary file was written is kept, and the
+	// temporary file is left to be removed
+	dir := t.TempDir()
+	outFileLoc := filepath.Join(dir, "out.log")
+	f, err := createAtomicFile(outFileLoc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteString("a\nb\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(outFileLoc, []byte("other\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = commitAtomic([]*os.File{f}, []string{outFileLoc}, false); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("Expected an error that the output file already exists; Got: %v", err)
+	}
+	expectFile(t, outFileLoc, "other\n")
+	expectFile(t, f.Name(), "a\nb\n")
+
+	// An output file that exists from the start fails before anything is written
+	if _, err = createAtomicFile(outFileLoc); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("Expected an error that the output file already exists; Got: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/veqryn/dedup"
+)
+
+// auditRecord is a line of the audit log, for a single removed duplicate
+type auditRecord struct {
+	Line               string `json:"line"`
+	Source             string `json:"source"`
+	LineNumber         uint64 `json:"line_number"`
+	RetainedSource     string `json:"retained_source"`
+	RetainedLineNumber uint64 `json:"retained_line_number"`
+}
+
+// auditLog writes every removed duplicate, with its position, to the audit log file
+type auditLog struct {
+	f       *os.File
+	writer  *bufio.Writer
+	enc     *json.Encoder
+	written uint64
+}
+
+// openAuditLog creates the audit log file, unless the path is empty, and sets the options to write
+// to it. The returned log must be closed, and is nil without a path.
+func openAuditLog(path string, opts *dedup.Options) (*auditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("audit log file already exists: %s", path)
+	}
+	f, err := createOutFile(path, false)
+	if err != nil {
+		return nil, err
+	}
+	l := &auditLog{f: f, writer: bufio.NewWriter(f)}
+	l.enc = json.NewEncoder(l.writer)
+	l.enc.SetEscapeHTML(false)
+	opts.OnAudit = l.write
+	return l, nil
+}
+
+// write writes a removed duplicate to the audit log
+func (l *auditLog) write(d dedup.Duplicate) error {
+	l.written++
+	return l.enc.Encode(auditRecord{
+		Line:               d.Line,
+		Source:             d.Position.Source,
+		LineNumber:         d.Position.Line,
+		RetainedSource:     d.Retained.Source,
+		RetainedLineNumber: d.Retained.Line,
+	})
+}
+
+// close flushes and closes the audit log file, and logs how much was written to it.
+// It is safe to call on a nil auditLog, which does nothing.
+func (l *auditLog) close() error {
+	if l == nil {
+		return nil
+	}
+	err := l.writer.Flush()
+	if closeErr := l.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		console.Printf("Wrote %d removed duplicates to audit log: %s", l.written, l.f.Name())
+	}
+	return err
+}
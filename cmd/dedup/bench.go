@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/veqryn/dedup"
+	"github.com/veqryn/dedup/gen"
+)
+
+// benchSettings are the settings of a single benchmarked configuration
+type benchSettings struct {
+	tmpFileBytes uint64
+	sortWorkers  int
+	buildWorkers int
+	set          string
+}
+
+// benchCommand generates test data, or reads the input files given, and deduplicates it with every
+// combination of the settings given, reporting the throughput of each, to tune run for the hardware
+func benchCommand(name string, args []string) error {
+	// Flags
+	fs := newFlagSet(name)
+	var inFileGlobs arrayFlags
+	fs.Var(&inFileGlobs, "in", "input file location or glob to benchmark with, instead of generated data (flag can be used multiple times)")
+	lineCount := fs.Int("lines", 1000000, "how many lines of test data to generate")
+	strlen := fs.Int("strlen", 50, "length of the lines of test data to generate")
+	dupRatio := fs.Float64("dup-ratio", 0.5, "fraction of the lines of test data that repeat an earlier line, from 0 to less than 1")
+	tmpFileBytesList := fs.String("tmp-file-bytes", "16000000,64000000,250000000", "comma separated max temporary file byte sizes to benchmark")
+	sortWorkersList := fs.String("sort-workers", "1", "comma separated numbers of sort workers to benchmark")
+	buildWorkersList := fs.String("build-workers", "1", "comma separated numbers of build workers to benchmark")
+	setsList := fs.String("sets", "map", "comma separated kinds of in-memory set to benchmark: map, hash, or prefix")
+	runs := fs.Int("runs", 1, "how many times to run each combination, reporting the fastest")
+	tmpDir := fs.String("tmp-dir", "", "directory to write the test data, temporary files, and outputs to (default: the os temp dir)")
+	logFlags := addLogFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := logFlags.apply(); err != nil {
+		return err
+	}
+
+	if *runs <= 0 {
+		return fmt.Errorf("runs flag must be a positive integer or omitted for the default")
+	}
+	combinations, err := benchCombinations(*tmpFileBytesList, *sortWorkersList, *buildWorkersList, *setsList)
+	if err != nil {
+		return err
+	}
+
+	// Generate the test data, unless input files were given
+	paths := []string(inFileGlobs)
+	if len(inFileGlobs) > 0 {
+		if paths, err = expandGlobs(inFileGlobs); err != nil {
+			return err
+		}
+	} else {
+		data, err := dedup.CreateTemp(*tmpDir, "bench.*.log")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(data.Name())
+		console.Printf("Generating %d lines: %s", *lineCount, data.Name())
+		err = gen.Generate(data, gen.Options{Lines: *lineCount, StrLen: *strlen, Duplicates: *dupRatio})
+		if closeErr := data.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		paths = []string{data.Name()}
+	}
+
+	fmt.Printf("%-15s %-13s %-14s %-7s %10s %10s %12s %8s %12s\n",
+		"tmp-file-bytes", "sort-workers", "build-workers", "set", "seconds", "MB/s", "lines/s", "chunks", "unique")
+	for _, settings := range combinations {
+		var fastest time.Duration
+		var stats dedup.Stats
+		for i := 0; i < *runs; i++ {
+			console.Verbosef("Running %+v", settings)
+			elapsed, runStats, err := benchRun(paths, *tmpDir, settings)
+			if err != nil {
+				return err
+			}
+			if i == 0 || elapsed < fastest {
+				fastest, stats = elapsed, runStats
+			}
+		}
+		seconds := fastest.Seconds()
+		fmt.Printf("%-15d %-13d %-14d %-7s %10.2f %10.1f %12.0f %8d %12d\n",
+			settings.tmpFileBytes, settings.sortWorkers, settings.buildWorkers, settings.set, seconds,
+			float64(stats.BytesRead)/1e6/seconds, float64(stats.LinesRead)/seconds, stats.Chunks, stats.LinesUnique)
+	}
+	return nil
+}
+
+// benchRun deduplicates the input files once with the settings into a temporary output file,
+// and returns how long it took
+func benchRun(paths []string, tmpDir string, settings benchSettings) (time.Duration, dedup.Stats, error) {
+	inFiles, closeInFiles, err := openFiles(paths)
+	defer closeInFiles()
+	if err != nil {
+		return 0, dedup.Stats{}, err
+	}
+	outFile, err := dedup.CreateTemp(tmpDir, "bench.*.log")
+	if err != nil {
+		return 0, dedup.Stats{}, err
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+
+	opts := dedup.Options{
+		TmpFileBytes: settings.tmpFileBytes,
+		SortWorkers:  sortWorkersFlag{workers: &settings.sortWorkers}.value(),
+		BuildWorkers: settings.buildWorkers,
+		PrefixSet:    settings.set == "prefix",
+		HashSet:      settings.set == "hash",
+		TempDir:      tmpDir,
+		OnEvent:      func(dedup.Event) {},
+		OnProgress:   func(dedup.Progress) {},
+	}
+	start := time.Now()
+	stats, err := dedup.Run(outFile, opts, sources(inFiles), nil)
+	return time.Since(start), stats, err
+}
+
+// benchCombinations returns every combination of the comma separated lists of settings
+func benchCombinations(tmpFileBytesList, sortWorkersList, buildWorkersList, setsList string) ([]benchSettings, error) {
+	tmpFileBytes, err := parseBenchList("tmp-file-bytes", tmpFileBytesList, 1)
+	if err != nil {
+		return nil, err
+	}
+	sortWorkers, err := parseBenchList("sort-workers", sortWorkersList, 0)
+	if err != nil {
+		return nil, err
+	}
+	buildWorkers, err := parseBenchList("build-workers", buildWorkersList, 1)
+	if err != nil {
+		return nil, err
+	}
+	sets := strings.Split(setsList, ",")
+	for _, set := range sets {
+		if set != "map" && set != "hash" && set != "prefix" {
+			return nil, fmt.Errorf("sets flag must only contain map, hash, or prefix")
+		}
+	}
+
+	var combinations []benchSettings
+	for _, b := range tmpFileBytes {
+		for _, sw := range sortWorkers {
+			for _, bw := range buildWorkers {
+				for _, set := range sets {
+					combinations = append(combinations, benchSettings{tmpFileBytes: b, sortWorkers: int(sw), buildWorkers: int(bw), set: set})
+				}
+			}
+		}
+	}
+	return combinations, nil
+}
+
+// parseBenchList parses a comma separated list of integers of at least min
+func parseBenchList(name, list string, min uint64) ([]uint64, error) {
+	var values []uint64
+	for _, field := range strings.Split(list, ",") {
+		value, err := strconv.ParseUint(strings.TrimSpace(field), 10, 64)
+		if err != nil || value < min {
+			return nil, fmt.Errorf("%s flag must be a comma separated list of integers of at least %d", name, min)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
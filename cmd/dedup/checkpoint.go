@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/veqryn/dedup"
+)
+
+// checkpointFlags are the checkpoint and resume flags, which let a failed or interrupted run continue
+// from its last temporary file rather than from the start
+type checkpointFlags struct {
+	path   *string
+	resume *bool
+}
+
+// addCheckpointFlags registers the checkpoint and resume flags on the flag set
+func addCheckpointFlags(fs *flag.FlagSet) checkpointFlags {
+	return checkpointFlags{
+		path: fs.String("checkpoint", "", "file to keep a manifest of the temporary files written so far in, with their checksums "+
+			"and how far into the input they hold, so that a failed run leaves them behind to be resumed from"),
+		resume: fs.Bool("resume", false, "continue from the manifest of the checkpoint flag, if it exists, with the same input and flags, "+
+			"instead of from the start of the input. use with the atomic flag, so that no partial output is left to be replaced"),
+	}
+}
+
+// validate returns an error if the flags are invalid
+func (f checkpointFlags) validate() error {
+	if *f.resume && *f.path == "" {
+		return fmt.Errorf("resume flag requires the checkpoint flag")
+	}
+	return nil
+}
+
+// tmpDirs returns the directories whose temporary files are removed when interrupted, which is none
+// when checkpointing, so that the run can be resumed from them
+func (f checkpointFlags) tmpDirs(tmpDir string) []string {
+	if *f.path != "" {
+		return nil
+	}
+	return []string{tmpDir}
+}
+
+// apply sets the Checkpoint and Resume options of the flags
+func (f checkpointFlags) apply(opts *dedup.Options) {
+	opts.Checkpoint, opts.Resume = *f.path, *f.resume
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/veqryn/dedup"
+)
+
+// cleanCommand removes the temporary files left behind by runs that crashed or were killed
+func cleanCommand(name string, args []string) error {
+	// Flags
+	fs := newFlagSet(name)
+	dir := fs.String("dir", "", "directory to remove orphaned temporary files from (default: the os temp dir)")
+	olderThan := fs.Duration("older-than", 24*time.Hour, "only remove files that were last written to at least this long ago")
+	dryRun := fs.Bool("dry-run", false, "only print the files that would be removed")
+	logFlags := addLogFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := logFlags.apply(); err != nil {
+		return err
+	}
+
+	files, err := dedup.FindTempFiles(*dir)
+	if err != nil {
+		return err
+	}
+
+	// Files of runs that are still running on this host are never removed, no matter their age
+	var removed int
+	var removedBytes int64
+	for _, f := range files {
+		switch {
+		case f.Running():
+			console.Verbosef("Skipping file of running process %d: %s", f.PID, f.Path)
+			continue
+		case time.Since(f.ModTime) < *olderThan:
+			console.Verbosef("Skipping file written %s ago: %s", time.Since(f.ModTime).Round(time.Second), f.Path)
+			continue
+		}
+
+		if *dryRun {
+			console.Printf("Would remove: %s", f.Path)
+		} else {
+			if err = os.Remove(f.Path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			console.Printf("Removed: %s", f.Path)
+		}
+		removed++
+		removedBytes += f.Size
+	}
+
+	if *dryRun {
+		console.Printf("Would remove %d orphaned temporary files (%s)", removed, humanBytes(float64(removedBytes)))
+	} else {
+		console.Printf("Removed %d orphaned temporary files (%s)", removed, humanBytes(float64(removedBytes)))
+	}
+	return nil
+}
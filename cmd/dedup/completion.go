@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// flagCollector, when set, is given the flag set of a subcommand by parseFlags instead of it
+// being parsed, so that the flags of every subcommand can be listed without running them
+var flagCollector func(fs *flag.FlagSet)
+
+// errFlagsCollected stops a subcommand once its flags have been collected
+var errFlagsCollected = errors.New("flags collected")
+
+// completionShells are the shells completion scripts can be generated for
+var completionShells = map[string]func(w io.Writer, prog string, cmds []completionCommand){
+	"bash": writeBashCompletion,
+	"zsh":  writeZshCompletion,
+	"fish": writeFishCompletion,
+}
+
+// completionCommand is a subcommand and its flags, for generating completion scripts
+type completionCommand struct {
+	command
+	flags []*flag.Flag
+}
+
+func init() {
+	commands = append(commands, command{name: "completion", short: "print a bash, zsh, or fish completion script",
+		run: completionScriptCommand, hidden: true})
+}
+
+// completionScriptCommand prints the completion script of a shell, for every visible subcommand and flag
+func completionScriptCommand(name string, args []string) error {
+	fs := newFlagSet(name)
+	progName := fs.String("prog", filepath.Base(os.Args[0]), "name of the executable to complete")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || completionShells[fs.Arg(0)] == nil {
+		return fmt.Errorf("usage: %s %s [--prog=dedup] <bash|zsh|fish>", filepath.Base(os.Args[0]), name)
+	}
+
+	cmds, err := collectCommandFlags()
+	if err != nil {
+		return err
+	}
+	completionShells[fs.Arg(0)](os.Stdout, *progName, cmds)
+	return nil
+}
+
+// collectCommandFlags returns every visible subcommand with its flags
+func collectCommandFlags() ([]completionCommand, error) {
+	defer func() { flagCollector = nil }()
+
+	var cmds []completionCommand
+	for _, cmd := range commands {
+		if cmd.hidden {
+			continue
+		}
+		cc := completionCommand{command: cmd}
+		flagCollector = func(fs *flag.FlagSet) {
+			fs.VisitAll(func(f *flag.Flag) {
+				cc.flags = append(cc.flags, f)
+			})
+		}
+		if err := cmd.run(cmd.name, nil); err != errFlagsCollected {
+			return nil, fmt.Errorf("unable to collect the flags of %s: %v", cmd.name, err)
+		}
+		cmds = append(cmds, cc)
+	}
+	return cmds, nil
+}
+
+// isBoolFlag returns true if the flag does not take a value
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// isRepeatableFlag returns true if the flag can be used multiple times
+func isRepeatableFlag(f *flag.Flag) bool {
+	_, ok := f.Value.(*arrayFlags)
+	return ok
+}
+
+// funcName returns the program name as a shell function name
+func funcName(prog string) string {
+	return "_" + strings.NewReplacer("-", "_", ".", "_").Replace(prog)
+}
+
+// writeBashCompletion writes a bash completion script, completing file names for flag values
+func writeBashCompletion(w io.Writer, prog string, cmds []completionCommand) {
+	var names []string
+	for _, cmd := range cmds {
+		names = append(names, cmd.name)
+	}
+
+	fmt.Fprintf(w, "# bash completion for %s, load with: source <(%s completion bash)\n", prog, prog)
+	fmt.Fprintf(w, "%s() {\n", funcName(prog))
+	fmt.Fprintf(w, "\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	fmt.Fprintf(w, "\tif [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintf(w, "\t\treturn\n\tfi\n")
+	fmt.Fprintf(w, "\tcase \"$cur\" in -*) ;; *) return ;; esac\n")
+	fmt.Fprintf(w, "\tcase \"${COMP_WORDS[1]}\" in\n")
+	for _, cmd := range cmds {
+		var flags []string
+		for _, f := range cmd.flags {
+			flags = append(flags, "--"+f.Name)
+		}
+		fmt.Fprintf(w, "\t%s) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", cmd.name, strings.Join(flags, " "))
+	}
+	fmt.Fprintf(w, "\tesac\n}\n")
+	fmt.Fprintf(w, "complete -o default -F %s %s\n", funcName(prog), prog)
+}
+
+// writeZshCompletion writes a zsh completion script, completing file names for flag values
+func writeZshCompletion(w io.Writer, prog string, cmds []completionCommand) {
+	escape := strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`)
+
+	fmt.Fprintf(w, "#compdef %s\n", prog)
+	fmt.Fprintf(w, "# zsh completion for %s, load with: source <(%s completion zsh)\n", prog, prog)
+	fmt.Fprintf(w, "%s() {\n", funcName(prog))
+	fmt.Fprintf(w, "\tlocal -a subcommands\n\tsubcommands=(\n")
+	for _, cmd := range cmds {
+		fmt.Fprintf(w, "\t\t'%s:%s'\n", cmd.name, escape.Replace(cmd.short))
+	}
+	fmt.Fprintf(w, "\t)\n")
+	fmt.Fprintf(w, "\tif (( CURRENT == 2 )); then\n\t\t_describe 'subcommand' subcommands\n\t\treturn\n\tfi\n")
+	// Complete the flags as if the subcommand was the command
+	fmt.Fprintf(w, "\tlocal cmd=$words[2]\n\tshift words\n\t(( CURRENT-- ))\n")
+	fmt.Fprintf(w, "\tcase $cmd in\n")
+	for _, cmd := range cmds {
+		fmt.Fprintf(w, "\t%s)\n\t\t_arguments \\\n", cmd.name)
+		for _, f := range cmd.flags {
+			usage := escape.Replace(strings.SplitN(f.Usage, "\n", 2)[0])
+			switch {
+			case isBoolFlag(f):
+				fmt.Fprintf(w, "\t\t\t'--%s[%s]' \\\n", f.Name, usage)
+			case isRepeatableFlag(f):
+				fmt.Fprintf(w, "\t\t\t'*--%s=[%s]:value:_files' \\\n", f.Name, usage)
+			default:
+				fmt.Fprintf(w, "\t\t\t'--%s=[%s]:value:_files' \\\n", f.Name, usage)
+			}
+		}
+		fmt.Fprintf(w, "\t\t\t&& return\n\t\t;;\n")
+	}
+	fmt.Fprintf(w, "\tesac\n}\n")
+	fmt.Fprintf(w, "if [ \"$funcstack[1]\" = \"%s\" ]; then\n\t%s \"$@\"\nelse\n\tcompdef %s %s\nfi\n",
+		funcName(prog), funcName(prog), funcName(prog), prog)
+}
+
+// writeFishCompletion writes a fish completion script, completing file names for flag values
+func writeFishCompletion(w io.Writer, prog string, cmds []completionCommand) {
+	escape := strings.NewReplacer(`\`, `\\`, "'", `\'`)
+
+	fmt.Fprintf(w, "# fish completion for %s, load with: %s completion fish | source\n", prog, prog)
+	for _, cmd := range cmds {
+		fmt.Fprintf(w, "complete -c %s -f -n __fish_use_subcommand -a %s -d '%s'\n", prog, cmd.name, escape.Replace(cmd.short))
+	}
+	for _, cmd := range cmds {
+		for _, f := range cmd.flags {
+			requires := " -r -F"
+			if isBoolFlag(f) {
+				requires = ""
+			}
+			fmt.Fprintf(w, "complete -c %s -n '__fish_seen_subcommand_from %s' -l %s%s -d '%s'\n",
+				prog, cmd.name, f.Name, requires, escape.Replace(strings.SplitN(f.Usage, "\n", 2)[0]))
+		}
+	}
+}
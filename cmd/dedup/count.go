@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/veqryn/dedup"
+)
+
+// countCommand counts the lines in the input files
+func countCommand(name string, args []string) error {
+	// Flags
+	fs := newFlagSet(name)
+	var inFileGlobs arrayFlags
+	fs.Var(&inFileGlobs, "in", "input file location or glob (flag can be used multiple times)")
+	inLists := addInListFlag(fs)
+	logFlags := addLogFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := logFlags.apply(); err != nil {
+		return err
+	}
+
+	// Open input files for reading
+	inFileLocs, err := withInLists(inFileGlobs, *inLists)
+	if err != nil {
+		return err
+	}
+	paths, err := expandGlobs(inFileLocs)
+	if err != nil {
+		return err
+	}
+	inFiles, closeInFiles, err := openFiles(paths)
+	defer closeInFiles()
+	if err != nil {
+		return err
+	}
+
+	// Count each file, and the total if there is more than one
+	var total uint64
+	for _, inFile := range inFiles {
+		count, err := dedup.Count(inFile)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d %s\n", count, inFile.Name())
+		total += count
+	}
+	if len(inFiles) > 1 {
+		fmt.Printf("%d total\n", total)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// delimiterNames are the names that can be given to the delimiter flag instead of the delimiter itself
+var delimiterNames = map[string]string{
+	"lf":   "\n",
+	"crlf": "\r\n",
+	"cr":   "\r",
+	"nul":  "\x00",
+	"null": "\x00",
+	"tab":  "\t",
+}
+
+// delimiterFlag is the record delimiter, given either by name, or as a string that may contain escapes
+type delimiterFlag struct {
+	value string
+	raw   string
+}
+
+// addDelimiterFlag registers the delimiter flag on the flag set
+func addDelimiterFlag(fs *flag.FlagSet) *delimiterFlag {
+	d := &delimiterFlag{}
+	fs.Var(d, "delimiter", "string separating the input lines, and ending each output line, such as '\\0' or '\\t', "+
+		"or one of: lf, crlf, cr, nul, tab (default: a new line, also accepting crlf input lines)")
+	return d
+}
+
+func (d *delimiterFlag) String() string {
+	if d == nil {
+		return ""
+	}
+	return d.raw
+}
+
+func (d *delimiterFlag) Set(value string) error {
+	delim, err := parseDelimiter(value)
+	if err != nil {
+		return err
+	}
+	d.value, d.raw = delim, value
+	return nil
+}
+
+// parseDelimiter returns the delimiter for a name, or the string with its escapes, such as \0 or
+// \x1e, interpreted
+func parseDelimiter(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if delim, ok := delimiterNames[strings.ToLower(value)]; ok {
+		return delim, nil
+	}
+	if value == `\0` {
+		return "\x00", nil
+	}
+	if !strings.Contains(value, `\`) {
+		return value, nil
+	}
+	delim, err := strconv.Unquote(`"` + strings.ReplaceAll(value, `"`, `\"`) + `"`)
+	if err != nil {
+		return "", fmt.Errorf("invalid escape in delimiter %q", value)
+	}
+	return delim, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/veqryn/dedup"
+)
+
+// exitDifferent is the exit code when the fail-if-different flag found differences
+const exitDifferent = 3
+
+// what the diff subcommand can show
+const (
+	showBoth    = "both"
+	showAdded   = "added"
+	showRemoved = "removed"
+)
+
+// diffCommand compares the unique lines of two sets of input files, which do not need to be sorted
+func diffCommand(name string, args []string) error {
+	// Flags
+	fs := newFlagSet(name)
+	var oldFileGlobs, newFileGlobs arrayFlags
+	fs.Var(&oldFileGlobs, "old", "old input file location or glob (flag can be used multiple times)")
+	fs.Var(&newFileGlobs, "new", "new input file location or glob (flag can be used multiple times)")
+	outFileLoc := fs.String("out", "", "output file location (default: stdout)")
+	show := fs.String("show", showBoth, fmt.Sprintf("which lines to write: %s (prefixed with + or -), %s, or %s (without a prefix)",
+		showBoth, showAdded, showRemoved))
+	failIfDifferent := fs.Bool("fail-if-different", false, fmt.Sprintf("exit with code %d if the inputs have different lines", exitDifferent))
+	patternFlags := addPatternFlags(fs, false)
+	tmpFileBytes := fs.Uint64("tmp-file-bytes", 250000000,
+		"max temporary file byte size. app will use 2-5x more memory than this to run")
+	tmpDir := fs.String("tmp-dir", "", "directory to write temporary files to (default: the os temp dir)")
+	readBufferBytes := fs.Int("read-buffer-bytes", 256*1024, "byte size of the buffer for reading input files, which is also the maximum line length")
+	writeBufferBytes := fs.Int("write-buffer-bytes", 256*1024, "byte size of the buffer for writing the output and temporary files")
+	mergeBufferBytes := fs.Int("merge-buffer-bytes", 4*1024, "initial byte size of the buffer for reading each file while merging")
+	delimiter := addDelimiterFlag(fs)
+	logFlags := addLogFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := logFlags.apply(); err != nil {
+		return err
+	}
+
+	if *show != showBoth && *show != showAdded && *show != showRemoved {
+		return fmt.Errorf("show flag must be one of: %s, %s, %s", showBoth, showAdded, showRemoved)
+	}
+	if *readBufferBytes <= 0 || *writeBufferBytes <= 0 || *mergeBufferBytes <= 0 {
+		return fmt.Errorf("buffer byte flags must be positive integers or omitted for the defaults")
+	}
+	if *tmpFileBytes <= 0 {
+		return fmt.Errorf("tmp-file-bytes flag must be a positive integer or omitted for the default")
+	}
+
+	// Compile regexp's
+	patterns, err := patternFlags.compile()
+	if err != nil {
+		return err
+	}
+
+	// Open input files for reading
+	if len(oldFileGlobs) == 0 || len(newFileGlobs) == 0 {
+		return fmt.Errorf("old and new flags must be non-empty")
+	}
+	oldPaths, err := expandGlobs(oldFileGlobs)
+	if err != nil {
+		return err
+	}
+	newPaths, err := expandGlobs(newFileGlobs)
+	if err != nil {
+		return err
+	}
+	oldFiles, closeOldFiles, err := openFiles(oldPaths)
+	defer closeOldFiles()
+	if err != nil {
+		return err
+	}
+	newFiles, closeNewFiles, err := openFiles(newPaths)
+	defer closeNewFiles()
+	if err != nil {
+		return err
+	}
+
+	// Open the output file for writing, or write to stdout
+	var out io.Writer = os.Stdout
+	var outFile *os.File
+	if *outFileLoc != "" {
+		if outFile, err = createOutFile(*outFileLoc, false); err != nil {
+			return err
+		}
+		defer outFile.Close()
+		out = outFile
+	}
+	writer := bufio.NewWriterSize(out, *writeBufferBytes)
+	lineEnd := delimiter.value
+	if lineEnd == "" {
+		lineEnd = "\n"
+	}
+	writeLine := func(prefix string) func(line string) error {
+		if *show != showBoth {
+			prefix = ""
+		}
+		return func(line string) error {
+			_, err := writer.WriteString(prefix + line + lineEnd)
+			return err
+		}
+	}
+	var onRemoved, onAdded func(line string) error
+	if *show != showAdded {
+		onRemoved = writeLine("-")
+	}
+	if *show != showRemoved {
+		onAdded = writeLine("+")
+	}
+
+	// Sort both inputs, then compare them
+	console.Printf("Starting diff...")
+	opts := dedup.Options{
+		TmpFileBytes:    *tmpFileBytes,
+		TempDir:         *tmpDir,
+		ReadBufferSize:  *readBufferBytes,
+		WriteBufferSize: *writeBufferBytes,
+		MergeBufferSize: *mergeBufferBytes,
+		Delimiter:       delimiter.value,
+		SkipPatterns:    patterns.skip,
+		KeepPatterns:    patterns.keep,
+		Rules:           patterns.rules,
+		OnEvent:         console.event,
+	}
+	opts.OnProgress, opts.ProgressInterval = console.progress(false, time.Minute)
+	stats, err := dedup.Diff(opts, multiReader(oldFiles), multiReader(newFiles), onRemoved, onAdded)
+	if err != nil {
+		return err
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	if outFile != nil {
+		if err = outFile.Close(); err != nil {
+			return err
+		}
+	}
+
+	console.Printf("Lines removed: %d, added: %d, in both: %d", stats.LinesRemoved, stats.LinesAdded, stats.LinesCommon)
+	if *failIfDifferent && stats.LinesRemoved+stats.LinesAdded > 0 {
+		return &exitError{code: exitDifferent, err: fmt.Errorf("inputs differ by %d lines", stats.LinesRemoved+stats.LinesAdded)}
+	}
+	console.Printf("Success!")
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/veqryn/dedup"
+)
+
+// duplicate report formats
+const (
+	dupReportLines  = "lines"
+	dupReportCounts = "counts"
+)
+
+// dupReportFlags are the flags for writing the removed duplicates to a side file
+type dupReportFlags struct {
+	path   *string
+	format *string
+}
+
+// addDupReportFlags registers the duplicate report flags on the flag set
+func addDupReportFlags(fs *flag.FlagSet) dupReportFlags {
+	return dupReportFlags{
+		path: fs.String("dup-report", "", "file to write the removed duplicates to, while the output only has the unique lines"),
+		format: fs.String("dup-report-format", dupReportLines, "format of the duplicate report: lines, for every removed duplicate as it is found, "+
+			"or counts, for every duplicated line once with the number of times it was read, in sorted order"),
+	}
+}
+
+// validate returns an error if the duplicate report flags are invalid
+func (f dupReportFlags) validate() error {
+	if *f.format != dupReportLines && *f.format != dupReportCounts {
+		return fmt.Errorf("dup-report-format flag must be one of: %s, %s", dupReportLines, dupReportCounts)
+	}
+	return nil
+}
+
+// dupReport writes the removed duplicates to the report file
+type dupReport struct {
+	name    string
+	f       *os.File
+	writer  *bufio.Writer
+	delim   string
+	written uint64
+}
+
+// open creates the report file, if the dup-report flag was given, and sets the options to write
+// to it. The returned report must be closed, and is nil without the flag.
+func (f dupReportFlags) open(opts *dedup.Options) (*dupReport, error) {
+	if *f.path == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(*f.path); err == nil {
+		return nil, fmt.Errorf("duplicate report file already exists: %s", *f.path)
+	}
+	file, err := createOutFile(*f.path, false)
+	if err != nil {
+		return nil, err
+	}
+	r := newDupReport("duplicate report", file, opts.Delimiter)
+
+	if *f.format == dupReportCounts {
+		opts.OnDuplicateCount = r.writeCount
+		return r, nil
+	}
+
+	// Keep any other duplicate callback, such as the check of the fail-if-duplicates flag
+	onDuplicate := opts.OnDuplicate
+	opts.OnDuplicate = func(line string) error {
+		if err := r.write(line); err != nil {
+			return err
+		}
+		if onDuplicate != nil {
+			return onDuplicate(line)
+		}
+		return nil
+	}
+	return r, nil
+}
+
+// newDupReport returns a report writing to the file, named in what is logged once it is written
+func newDupReport(name string, f *os.File, delim string) *dupReport {
+	if delim == "" {
+		delim = "\n"
+	}
+	return &dupReport{name: name, f: f, writer: bufio.NewWriter(f), delim: delim}
+}
+
+// write writes a removed duplicate line to the report
+func (r *dupReport) write(line string) error {
+	r.written++
+	if _, err := r.writer.WriteString(line); err != nil {
+		return err
+	}
+	_, err := r.writer.WriteString(r.delim)
+	return err
+}
+
+// writeCount writes a duplicated line to the report with the number of times it was read,
+// separated by a tab
+func (r *dupReport) writeCount(line string, count uint64) error {
+	if _, err := r.writer.WriteString(strconv.FormatUint(count, 10) + "\t"); err != nil {
+		return err
+	}
+	return r.write(line)
+}
+
+// close flushes and closes the report file, and logs how much was written to it.
+// It is safe to call on a nil dupReport, which does nothing.
+func (r *dupReport) close() error {
+	if r == nil {
+		return nil
+	}
+	err := r.writer.Flush()
+	if closeErr := r.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		console.Printf("Wrote %d lines to %s: %s", r.written, r.name, r.f.Name())
+	}
+	return err
+}
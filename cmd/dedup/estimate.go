@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/veqryn/dedup"
+)
+
+// estimateCommand estimates the duplicate ratio and output size of the input files from a sample of them
+func estimateCommand(name string, args []string) error {
+	// Flags
+	fs := newFlagSet(name)
+	var inFileGlobs arrayFlags
+	fs.Var(&inFileGlobs, "in", "input file location or glob (flag can be used multiple times)")
+	inLists := addInListFlag(fs)
+	sampleBytes := fs.Uint64("sample-bytes", 64000000, "byte size of the sample of lines to read, in blocks at random offsets of the input files")
+	delimiter := addDelimiterFlag(fs)
+	readBufferBytes := fs.Int("read-buffer-bytes", 256*1024, "byte size of the buffer for reading input files, which is also the maximum line length")
+	logFlags := addLogFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := logFlags.apply(); err != nil {
+		return err
+	}
+
+	if *sampleBytes == 0 {
+		return fmt.Errorf("sample-bytes flag must be a positive integer or omitted for the default")
+	}
+
+	// Open input files for reading
+	inFileLocs, err := withInLists(inFileGlobs, *inLists)
+	if err != nil {
+		return err
+	}
+	paths, err := expandGlobs(inFileLocs)
+	if err != nil {
+		return err
+	}
+	inFiles, closeInFiles, err := openFiles(paths)
+	defer closeInFiles()
+	if err != nil {
+		return err
+	}
+
+	opts := dedup.Options{Delimiter: delimiter.value, ReadBufferSize: *readBufferBytes}
+	e, err := dedup.EstimateDuplicates(opts, *sampleBytes, inFiles...)
+	if err != nil {
+		return err
+	}
+	kind := "Estimated"
+	if e.Exact {
+		kind = "Exact"
+	}
+	fmt.Printf("Sampled %s lines (%s) of %s\n", humanCount(float64(e.SampledLines)), humanBytes(float64(e.SampledBytes)), humanBytes(float64(e.Bytes)))
+	fmt.Printf("%s lines: %d\n", kind, e.Lines)
+	fmt.Printf("%s unique lines: %d (%.1f%% duplicates)\n", kind, e.UniqueLines, e.DuplicateRatio()*100)
+	fmt.Printf("%s output size: %s\n", kind, humanBytes(float64(e.OutputBytes)))
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/veqryn/dedup"
+)
+
+// exitUnexpectedUnique is the exit code when the expect-unique flag found a different number of unique lines
+const exitUnexpectedUnique = 5
+
+// unexpectedUniqueError fails a run whose number of unique lines is not the one expected
+type unexpectedUniqueError struct {
+	linesUnique uint64
+	low, high   uint64
+}
+
+func (e *unexpectedUniqueError) Error() string {
+	if e.low == e.high {
+		return fmt.Sprintf("%d, expected %d", e.linesUnique, e.low)
+	}
+	return fmt.Sprintf("%d, expected %d to %d", e.linesUnique, e.low, e.high)
+}
+
+// expectFlags are the expect-unique and expect-unique-tolerance flags, which assert the number of unique
+// lines a run writes, such as to catch a regression in the data written upstream
+type expectFlags struct {
+	unique    *string
+	tolerance *string
+
+	expected uint64
+	within   uint64
+	percent  float64
+}
+
+// addExpectFlags registers the expect-unique and expect-unique-tolerance flags on the flag set
+func addExpectFlags(fs *flag.FlagSet) *expectFlags {
+	return &expectFlags{
+		unique: fs.String("expect-unique", "", fmt.Sprintf("number of unique lines the run is expected to write, "+
+			"exiting with code %d and keeping the temporary files to investigate if it writes any other number (default: not checked)", exitUnexpectedUnique)),
+		tolerance: fs.String("expect-unique-tolerance", "0", "how far the number of unique lines may be from expect-unique, "+
+			"as a number of lines, or a percentage of expect-unique such as 2.5%"),
+	}
+}
+
+// validate returns an error if the flags are invalid, and parses them
+func (f *expectFlags) validate() error {
+	if *f.unique == "" {
+		if *f.tolerance != "0" {
+			return fmt.Errorf("expect-unique-tolerance flag requires the expect-unique flag")
+		}
+		return nil
+	}
+	expected, err := strconv.ParseUint(*f.unique, 10, 64)
+	if err != nil {
+		return fmt.Errorf("expect-unique flag must be a number of lines: %s", *f.unique)
+	}
+	f.expected = expected
+	if percent := strings.TrimSuffix(*f.tolerance, "%"); percent != *f.tolerance {
+		f.percent, err = strconv.ParseFloat(percent, 64)
+		if err != nil || f.percent < 0 {
+			return fmt.Errorf("expect-unique-tolerance flag must be a number of lines or a percentage: %s", *f.tolerance)
+		}
+		return nil
+	}
+	if f.within, err = strconv.ParseUint(*f.tolerance, 10, 64); err != nil {
+		return fmt.Errorf("expect-unique-tolerance flag must be a number of lines or a percentage: %s", *f.tolerance)
+	}
+	return nil
+}
+
+// apply sets the ExpectUnique option of the flags
+func (f *expectFlags) apply(opts *dedup.Options) {
+	if *f.unique == "" {
+		return
+	}
+	within := f.within
+	if f.percent > 0 {
+		within = uint64(float64(f.expected) * f.percent / 100)
+	}
+	low := uint64(0)
+	if f.expected > within {
+		low = f.expected - within
+	}
+	high := f.expected + within
+	opts.ExpectUnique = func(linesUnique uint64) error {
+		if linesUnique < low || linesUnique > high {
+			return &unexpectedUniqueError{linesUnique: linesUnique, low: low, high: high}
+		}
+		return nil
+	}
+}
+
+// failed returns the error of a run that the expect-unique flag failed as one exiting with
+// exitUnexpectedUnique, and any other error as it is
+func (f *expectFlags) failed(err error) error {
+	var unexpected *unexpectedUniqueError
+	if errors.As(err, &unexpected) {
+		return &exitError{code: exitUnexpectedUnique, err: err}
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/veqryn/dedup"
+)
+
+// formatFlags are the format (or output-format), table, postgres-batch-lines, redis-key, redis-batch-lines,
+// redis-ttl, parquet-row-group-bytes, and arrow-batch-lines flags, which decide how the unique lines are
+// written
+type formatFlags struct {
+	format             *string
+	table              *string
+	postgresBatchLines *int
+	redisKey           *string
+	redisBatchLines    *int
+	redisTTL           *time.Duration
+	rowGroupBytes      *int
+	arrowBatchLines    *int
+}
+
+// addFormatFlags registers the format and output-format, table, postgres-batch-lines, redis-key,
+// redis-batch-lines, redis-ttl, parquet-row-group-bytes, and arrow-batch-lines flags on the flag set,
+// describing what each record of a unique line has
+func addFormatFlags(fs *flag.FlagSet, record string) formatFlags {
+	format := fs.String("format", string(dedup.FormatText), "format of the output: text, json (or jsonl) for a JSON object per unique line with "+
+		record+", csv and tsv for a header row and then a row per unique line with "+record+", "+
+		"sqlite for a SQL script inserting each unique line with "+record+" into a SQLite table, "+
+		"such as piped to sqlite3 -bail, postgres for a psql script copying them into a PostgreSQL table, "+
+		"redis for the commands adding the unique lines to a Redis set, such as piped to redis-cli --pipe, "+
+		"parquet for a Parquet file with a row per unique line with "+record+", "+
+		"or arrow and arrow-stream for the same rows as an Arrow IPC file or stream")
+	fs.StringVar(format, "output-format", string(dedup.FormatText), "the same as the format flag")
+	return formatFlags{
+		format: format,
+		table:  fs.String("table", "lines", "table the sqlite and postgres formats insert into, which is created with a unique index of its lines if absent"),
+		postgresBatchLines: fs.Int("postgres-batch-lines", 100000, "unique lines the postgres format copies into a temporary table "+
+			"at a time, before inserting those not already in the table"),
+		redisKey:        fs.String("redis-key", "lines", "key of the set the redis format adds the unique lines to"),
+		redisBatchLines: fs.Int("redis-batch-lines", 1000, "unique lines each SADD command of the redis format adds to the set"),
+		redisTTL:        fs.Duration("redis-ttl", 0, "expire the set of the redis format this long after the run, such as 24h (default: never)"),
+		rowGroupBytes: fs.Int("parquet-row-group-bytes", 32*1024*1024, "about how many bytes of lines each row group of the parquet "+
+			"format holds in memory until it is written"),
+		arrowBatchLines: fs.Int("arrow-batch-lines", 64*1024, "unique lines each record batch of the arrow and arrow-stream "+
+			"formats holds in memory until it is written"),
+	}
+}
+
+// validate returns an error if the flags are invalid
+func (f formatFlags) validate() error {
+	switch f.outputFormat() {
+	case dedup.FormatText, dedup.FormatJSON, dedup.FormatCSV, dedup.FormatTSV, dedup.FormatSQLite, dedup.FormatPostgres, dedup.FormatRedis,
+		dedup.FormatParquet, dedup.FormatArrow, dedup.FormatArrowStream:
+	default:
+		return fmt.Errorf("format flag must be one of: %s, %s, jsonl, %s, %s, %s, %s, %s, %s, %s, %s", dedup.FormatText, dedup.FormatJSON,
+			dedup.FormatCSV, dedup.FormatTSV, dedup.FormatSQLite, dedup.FormatPostgres, dedup.FormatRedis, dedup.FormatParquet,
+			dedup.FormatArrow, dedup.FormatArrowStream)
+	}
+	if *f.table == "" {
+		return fmt.Errorf("table flag must be non-empty")
+	}
+	if *f.postgresBatchLines <= 0 {
+		return fmt.Errorf("postgres-batch-lines flag must be a positive integer")
+	}
+	if *f.redisKey == "" {
+		return fmt.Errorf("redis-key flag must be non-empty")
+	}
+	if *f.redisBatchLines <= 0 {
+		return fmt.Errorf("redis-batch-lines flag must be a positive integer")
+	}
+	if *f.redisTTL < 0 {
+		return fmt.Errorf("redis-ttl flag must not be negative")
+	}
+	if *f.rowGroupBytes <= 0 {
+		return fmt.Errorf("parquet-row-group-bytes flag must be a positive integer")
+	}
+	if *f.arrowBatchLines <= 0 {
+		return fmt.Errorf("arrow-batch-lines flag must be a positive integer")
+	}
+	return nil
+}
+
+// outputFormat returns the format of the flag, with jsonl being json, which writes JSON Lines
+func (f formatFlags) outputFormat() dedup.OutputFormat {
+	if *f.format == "jsonl" {
+		return dedup.FormatJSON
+	}
+	return dedup.OutputFormat(*f.format)
+}
+
+// records returns true if each unique line is written as a record, rather than as it is
+func (f formatFlags) records() bool {
+	return f.outputFormat() != dedup.FormatText
+}
+
+// binary returns true if the output is a file of its own, rather than lines that can be appended
+func (f formatFlags) binary() bool {
+	switch f.outputFormat() {
+	case dedup.FormatParquet, dedup.FormatArrow, dedup.FormatArrowStream:
+		return true
+	}
+	return false
+}
+
+// apply sets the options of the flags
+func (f formatFlags) apply(opts *dedup.Options) {
+	opts.Format = f.outputFormat()
+	opts.Table = *f.table
+	opts.PostgresBatchLines = *f.postgresBatchLines
+	opts.RedisKey = *f.redisKey
+	opts.RedisBatchLines = *f.redisBatchLines
+	opts.RedisTTL = *f.redisTTL
+	opts.ParquetRowGroupBytes = *f.rowGroupBytes
+	opts.ArrowBatchLines = *f.arrowBatchLines
+}
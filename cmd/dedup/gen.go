@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/veqryn/dedup/gen"
+)
+
+// genCommand generates a file, or files, of random test data
+func genCommand(name string, args []string) error {
+	// Flags
+	fs := newFlagSet(name)
+	outFileLoc := fs.String("out", "testdata.log", "file location for the test data to be created, or - for stdout, "+
+		"to pipe it into dedup or other tools without writing it to disk")
+	appendFlag := fs.Bool("append", false, "should append to file (default: only allow new files)")
+	lineCount := fs.Int("lines", 100, "how many lines to generate")
+	size := fs.String("bytes", "", "how many bytes of lines to generate instead of lines, at most, such as 100GB, or 10GiB. "+
+		"the lines it takes are estimated from the length of the lines of the mode (default: the lines flag)")
+	mode := fs.String("mode", string(gen.ModeHex), "kind of lines to generate: hex, for random hex strings of strlen characters, "+
+		"url, for random urls of varying lengths with weighted hosts, paths, and queries, which share prefixes like those of real logs, "+
+		"jsonl, for JSON objects of the fields, one per line, or csv, for rows of the fields after a header row in each file")
+	strlen := fs.Int("strlen", 50, "length of the strings to generate, with mode hex")
+	minStrlen := fs.Int("min-strlen", 0, "shortest string to generate with mode hex, with max-strlen")
+	maxStrlen := fs.Int("max-strlen", 0, "longest string to generate with mode hex, instead of strlen, so that the lengths of "+
+		"the strings vary from min-strlen to it by strlen-dist (default: strlen)")
+	strlenDist := fs.String("strlen-dist", string(gen.DistributionUniform), "distribution of the lengths of strings from min-strlen "+
+		"to max-strlen: uniform, normal, around the middle of them, or lognormal, with most strings short and a long tail of long ones")
+	fields := fs.String("fields", "id:int,user:word,url:url,time:time,trace:hex", "fields of the lines of modes jsonl and csv, "+
+		"each name:kind, separated by commas, of the kinds: int, hex, word, url, and time")
+	keyFields := fs.String("key-fields", "id,user", "names of the key fields of modes jsonl and csv, separated by commas, which key-dup-ratio repeats")
+	keyDupRatio := fs.Float64("key-dup-ratio", 0, "fraction of the distinct lines of modes jsonl and csv that repeat the key fields of "+
+		"an earlier line with the other fields new, so that they are duplicates only by their key fields, from 0 to less than 1")
+	dupRatio := fs.Float64("dup-ratio", 0, "fraction of the lines that repeat an earlier line, from 0 to less than 1")
+	nearDupRatio := fs.Float64("near-dup-ratio", 0, "fraction of the distinct lines that are near duplicates of an earlier line, the same "+
+		"but for one token, a timestamp, a number, such as an id, or a word, or one character of lines without any, from 0 to less than 1")
+	zipf := fs.Float64("zipf", 0, "exponent, more than 1, of the zipf distribution the lines repeated by dup-ratio are picked by, "+
+		"so that a few lines are repeated very many times and most rarely, like the lines of real logs (default: uniform)")
+	files := fs.Int("files", 1, "split the lines into this many files, each an equal share of lines or bytes, numbered before the "+
+		"extension of the out flag, such as testdata.0.log and testdata.1.log, for testing many inputs")
+	overlap := fs.Bool("overlap", false, "with files, let the lines of each file repeat those of the files before it, so that "+
+		"duplicates are across files, rather than only within each file")
+	compress := fs.String("compress", "", "compress each file: gzip, which adds .gz to the name of each file that does not end with it "+
+		"(default: uncompressed)")
+	workers := fs.Int("workers", runtime.NumCPU(), "number of goroutines generating lines at once, which generate the same lines "+
+		"however many there are. the lines of one file, or of overlapping files, are generated by all of them, and otherwise as many files at once")
+	emptyRatio := fs.Float64("empty-ratio", 0, "fraction of the distinct lines that are empty, an edge case, from 0 to 1")
+	longRatio := fs.Float64("long-ratio", 0, "fraction of the distinct lines that are at or over 64KiB with their new line, "+
+		"the buffer of bufio.Scanner, mostly one byte either side of it, and some up to 1MiB, an edge case, from 0 to 1")
+	invalidUTF8Ratio := fs.Float64("invalid-utf8-ratio", 0, "fraction of the distinct lines with an invalid utf-8 sequence inserted, "+
+		"an edge case, from 0 to 1")
+	crRatio := fs.Float64("cr-ratio", 0, "fraction of the distinct lines with a carriage return inserted, other than at their end, "+
+		"an edge case, from 0 to 1")
+	noTrailingNewline := fs.Bool("no-trailing-newline", false, "leave the new line off the end of the last line of each file, an edge case")
+	order := fs.String("order", "", "write the lines of each file sorted, byte by byte as dedup sorts them, or reverse, for reverse "+
+		"sorted (default: in the order generated)")
+	sortedRun := fs.Int("sorted-run", 0, "with order, sort this many lines at a time, so that each file is partially sorted, in "+
+		"sorted runs of that many lines (default: all the lines of each file, which are kept in memory)")
+	expectedLoc := fs.String("expected", "", "file location to write the answer deduplicating the lines should give to, as JSON: "+
+		"how many lines there are, how many are unique, and a checksum of the unique lines, the sum of their FNV-1a 64-bit hashes, "+
+		"which keeps a hash of each unique line in memory (default: none)")
+	seed := fs.Int64("seed", 0, "seed of the random lines, so that the same flags and seed generate the same lines on any machine "+
+		"(default: seeded from the current time, and printed to generate the same again)")
+	logFlags := addLogFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := logFlags.apply(); err != nil {
+		return err
+	}
+
+	if *lineCount <= 0 {
+		return fmt.Errorf("lines flag must be a positive integer or omitted for the default")
+	}
+	if *files <= 0 {
+		return fmt.Errorf("files flag must be a positive integer or omitted for the default")
+	}
+	if *workers <= 0 {
+		return fmt.Errorf("workers flag must be a positive integer or omitted for the default")
+	}
+	if *strlen <= 0 {
+		return fmt.Errorf("strlen flag must be a positive integer or omitted for the default")
+	}
+	if *maxStrlen != 0 && (*minStrlen <= 0 || *maxStrlen < *minStrlen) {
+		return fmt.Errorf("min-strlen flag must be a positive integer, and max-strlen flag at least min-strlen")
+	}
+	if *maxStrlen == 0 && *minStrlen != 0 {
+		return fmt.Errorf("max-strlen flag is required with min-strlen")
+	}
+	if *strlenDist != string(gen.DistributionUniform) && *strlenDist != string(gen.DistributionNormal) &&
+		*strlenDist != string(gen.DistributionLogNormal) {
+		return fmt.Errorf("strlen-dist flag must be one of: %s, %s, %s", gen.DistributionUniform, gen.DistributionNormal, gen.DistributionLogNormal)
+	}
+	switch gen.Mode(*mode) {
+	case gen.ModeHex, gen.ModeURL, gen.ModeJSON, gen.ModeCSV:
+	default:
+		return fmt.Errorf("mode flag must be one of: %s, %s, %s, %s", gen.ModeHex, gen.ModeURL, gen.ModeJSON, gen.ModeCSV)
+	}
+	parsedFields, err := gen.ParseFields(*fields, *keyFields)
+	if err != nil {
+		return fmt.Errorf("invalid fields or key-fields flag: %v", err)
+	}
+	if *emptyRatio < 0 || *longRatio < 0 || *invalidUTF8Ratio < 0 || *crRatio < 0 ||
+		*emptyRatio+*longRatio+*invalidUTF8Ratio+*crRatio > 1 {
+		return fmt.Errorf("empty-ratio, long-ratio, invalid-utf8-ratio, and cr-ratio flags must be at least 0 and add up to at most 1")
+	}
+	if *order != string(gen.OrderGenerated) && *order != string(gen.OrderSorted) && *order != string(gen.OrderReverse) {
+		return fmt.Errorf("order flag must be %s or %s, or omitted for the order generated", gen.OrderSorted, gen.OrderReverse)
+	}
+	if *sortedRun < 0 {
+		return fmt.Errorf("sorted-run flag must not be negative")
+	}
+	if *keyDupRatio < 0 || *keyDupRatio >= 1 {
+		return fmt.Errorf("key-dup-ratio flag must be at least 0 and less than 1")
+	}
+	var bytes int64
+	if *size != "" {
+		if bytes, err = gen.ParseBytes(*size); err != nil || bytes == 0 {
+			return fmt.Errorf("bytes flag must be a positive byte size, such as 100GB, or omitted for the lines flag")
+		}
+	}
+	if *dupRatio < 0 || *dupRatio >= 1 {
+		return fmt.Errorf("dup-ratio flag must be at least 0 and less than 1")
+	}
+	if *nearDupRatio < 0 || *nearDupRatio >= 1 {
+		return fmt.Errorf("near-dup-ratio flag must be at least 0 and less than 1")
+	}
+	if *zipf != 0 && *zipf <= 1 {
+		return fmt.Errorf("zipf flag must be more than 1, or omitted for a uniform distribution")
+	}
+	if err = checkStdio(nil, *outFileLoc, nil, []stdioConflict{{"append", *appendFlag}, {"files", *files > 1}}); err != nil {
+		return err
+	}
+	if *expectedLoc != "" && *appendFlag {
+		return fmt.Errorf("expected flag can not be used with append, as the lines already in the file are not known")
+	}
+	if *compress != string(gen.CompressionNone) && *compress != string(gen.CompressionGzip) {
+		return fmt.Errorf("compress flag must be %s, or omitted for none", gen.CompressionGzip)
+	}
+
+	// Create the output files for writing
+	paths := []string{*outFileLoc}
+	if *files > 1 {
+		paths = shardPaths(*outFileLoc, *files)
+	}
+	for i, path := range paths {
+		if *compress == string(gen.CompressionGzip) && path != stdio && !strings.HasSuffix(path, ".gz") {
+			paths[i] = path + ".gz"
+		}
+	}
+	outFiles, closeOutFiles, err := createShardFiles(paths, func(path string) (*os.File, error) {
+		if path == stdio {
+			return os.Stdout, nil
+		}
+		return createOutFile(path, *appendFlag)
+	})
+	defer closeOutFiles()
+	if err != nil {
+		return err
+	}
+	var expectedFile *os.File
+	if *expectedLoc != "" {
+		if expectedFile, err = createOutFile(*expectedLoc, false); err != nil {
+			return err
+		}
+		defer expectedFile.Close()
+	}
+	writers := make([]io.Writer, len(outFiles))
+	for i, f := range outFiles {
+		writers[i] = f
+	}
+
+	// Generate, from a seed that is printed, so that the same lines can be generated again
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	amount := fmt.Sprintf("%d lines", *lineCount)
+	if bytes > 0 {
+		amount = fmt.Sprintf("%d bytes of lines", bytes)
+	}
+	console.Printf("Generating %s with seed %d: %s", amount, *seed, strings.Join(paths, ", "))
+	opts := gen.Options{Lines: *lineCount, Bytes: bytes, StrLen: *strlen, Duplicates: *dupRatio, NearDuplicates: *nearDupRatio, Zipf: *zipf,
+		Fields: parsedFields, KeyDuplicates: *keyDupRatio, MinStrLen: *minStrlen, MaxStrLen: *maxStrlen, StrLenDist: gen.Distribution(*strlenDist),
+		EmptyLines: *emptyRatio, LongLines: *longRatio, InvalidUTF8: *invalidUTF8Ratio, CarriageReturns: *crRatio,
+		NoTrailingNewline: *noTrailingNewline, Mode: gen.Mode(*mode), Seed: *seed, Overlap: *overlap, Compression: gen.Compression(*compress),
+		Workers: *workers, Order: gen.Order(*order), SortedRun: *sortedRun}
+	var expected gen.Expected
+	if *expectedLoc != "" {
+		opts.Expected = &expected
+	}
+	err = gen.GenerateFiles(writers, opts)
+	if err != nil {
+		return err
+	}
+	if err = closeOutputs(outFiles); err != nil {
+		return err
+	}
+	if expectedFile != nil {
+		if err = json.NewEncoder(expectedFile).Encode(expected); err != nil {
+			return err
+		}
+		if err = expectedFile.Close(); err != nil {
+			return err
+		}
+		console.Printf("Expected %d unique of %d lines: %s", expected.Unique, expected.Lines, *expectedLoc)
+	}
+	console.Printf("Success!")
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/veqryn/dedup"
+)
+
+// indexExtension is added to the path of an output file for the path of its sidecar index
+const indexExtension = ".idx"
+
+// indexFlags are the index and index-interval flags, which write a sidecar index of the output
+type indexFlags struct {
+	write    *bool
+	interval *int
+}
+
+// addIndexFlags registers the index and index-interval flags on the flag set
+func addIndexFlags(fs *flag.FlagSet) indexFlags {
+	return indexFlags{
+		write: fs.Bool("index", false, "write a sidecar index of the output next to it, with "+indexExtension+" added to its name, of every "+
+			"index-interval-th line and the byte offset it starts at, which lookup, search, and reference use to find lines faster"),
+		interval: fs.Int("index-interval", 1024, "unique lines written for each line in the sidecar index"),
+	}
+}
+
+// validate returns an error if the flags are invalid. The output must be a file of sorted lines, to
+// index it.
+func (f indexFlags) validate(outFileLoc string, sorted bool) error {
+	if *f.interval <= 0 {
+		return fmt.Errorf("index-interval flag must be a positive integer")
+	}
+	if *f.write && (outFileLoc == "" || outFileLoc == stdio || !sorted) {
+		return fmt.Errorf("index flag requires the out flag to be a new file of sorted lines, in the text format, " +
+			"without append, output-shards, or partitions")
+	}
+	return nil
+}
+
+// sidecarIndex is the sidecar index of an output file, written to a temporary file next to it, which
+// is renamed to it once the output is complete
+type sidecarIndex struct {
+	path   string
+	f      *os.File
+	writer *bufio.Writer
+}
+
+// open creates the sidecar index of the output file, unless the flag is not set, and sets the options to
+// write to it. The returned index must be closed, and is nil without the flag.
+func (f indexFlags) open(outFileLoc string, opts *dedup.Options) (*sidecarIndex, error) {
+	if !*f.write {
+		return nil, nil
+	}
+	path := outFileLoc + indexExtension
+	file, err := createAtomicFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &sidecarIndex{path: path, f: file, writer: bufio.NewWriter(file)}
+	opts.IndexInterval = *f.interval
+	opts.OnIndex = dedup.IndexWriter(s.writer, *opts)
+	return s, nil
+}
+
+// commit renames the sidecar index to its path, once the output is complete. It is modified after the
+// output, so that an index older than its file is known to be of an earlier output. With sync, it is
+// flushed to disk first, and its directory after.
+func (s *sidecarIndex) commit(sync bool) error {
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := os.Chtimes(s.f.Name(), now, now); err != nil {
+		return err
+	}
+	return commitAtomic([]*os.File{s.f}, []string{s.path}, sync)
+}
+
+// close removes the sidecar index unless it was committed, and does nothing if it is nil
+func (s *sidecarIndex) close() {
+	if s == nil {
+		return
+	}
+	s.f.Close()
+	os.Remove(s.f.Name())
+}
+
+// openSortedFile returns a SortedFile of the lines of the file at the path, with its sidecar index as its
+// sparse index if it has one that is not older than it
+func openSortedFile(f *os.File, path string, opts dedup.Options) (*dedup.SortedFile, error) {
+	index, err := os.Open(path + indexExtension)
+	if err != nil {
+		return dedup.NewSortedFile(f, opts)
+	}
+	defer index.Close()
+	indexInfo, err := index.Stat()
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if indexInfo.ModTime().Before(info.ModTime()) {
+		console.Warnf("Ignoring sidecar index older than its file: %s", index.Name())
+		return dedup.NewSortedFile(f, opts)
+	}
+	console.Verbosef("Reading sidecar index: %s", index.Name())
+	return dedup.NewIndexedSortedFile(f, bufio.NewReader(index), opts)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// addInListFlag registers the in-list flag on the flag set
+func addInListFlag(fs *flag.FlagSet) *arrayFlags {
+	var inLists arrayFlags
+	fs.Var(&inLists, "in-list", "file listing an input file location or glob on each line, or - to read the list from stdin, "+
+		"read after those of the in flag. blank lines and lines starting with # are ignored (flag can be used multiple times)")
+	return &inLists
+}
+
+// withInLists returns the input file locations and globs of the in flag, followed by those listed in
+// the files of the in-list flag, in the order listed. A listed location can also be a file:// url, such
+// as those written by tools listing the files of a mount.
+func withInLists(inFileGlobs, inLists []string) ([]string, error) {
+	locs := append([]string(nil), inFileGlobs...)
+	var stdin, stdinLists int
+	for _, glob := range inFileGlobs {
+		if glob == stdio {
+			stdin++
+		}
+	}
+	for _, list := range inLists {
+		if list == stdio {
+			stdinLists++
+		}
+	}
+	if stdinLists > 1 || (stdinLists > 0 && stdin > 0) {
+		return nil, fmt.Errorf("stdin can only be read once, as the in-list flag or as an input")
+	}
+	for _, list := range inLists {
+		listed, err := readInList(list)
+		if err != nil {
+			return nil, fmt.Errorf("in-list flag: %w", err)
+		}
+		locs = append(locs, listed...)
+	}
+	return locs, nil
+}
+
+// readInList returns the input file locations listed in the file, or in stdin for stdio
+func readInList(list string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if list != stdio {
+		f, err := os.Open(list)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var locs []string
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		loc := strings.TrimSpace(scanner.Text())
+		if loc == "" || strings.HasPrefix(loc, "#") {
+			continue
+		}
+		if strings.Contains(loc, "://") {
+			u, err := url.Parse(loc)
+			if err != nil || u.Scheme != "file" || (u.Host != "" && u.Host != "localhost") {
+				return nil, fmt.Errorf("%s:%d: only file locations and file:// urls can be listed: %s", list, number, loc)
+			}
+			loc = filepath.FromSlash(u.Path)
+		}
+		if loc == stdio && list == stdio {
+			return nil, fmt.Errorf("%s:%d: stdin can not be listed as an input while the list is read from it", list, number)
+		}
+		locs = append(locs, loc)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", list, err)
+	}
+	return locs, nil
+}
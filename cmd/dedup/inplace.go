@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// createInPlaceFile creates a temporary file in the same directory as the file being replaced,
+// so that it can be renamed over it atomically, with the same mode and ownership. A symlink is
+// replaced through, so the file it links to is the one replaced.
+func createInPlaceFile(path string) (*os.File, error) {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("in-place flag requires a regular file: %s", path)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".dedup.*")
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Chmod(info.Mode().Perm()); err == nil {
+		err = chownLike(f, info)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("unable to preserve the mode and ownership of %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// replaceInPlace flushes the temporary file to disk, then renames it over the original file, or the
+// file it links to, leaving the symlink as it is. The directory is synced as well, so that the rename
+// survives a crash.
+func replaceInPlace(f *os.File, path string) error {
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
@@ -0,0 +1,14 @@
+package main
+
+import "os"
+
+// chownLike does nothing, as plan9 files are owned by user and group names, which only the file
+// server can change
+func chownLike(f *os.File, info os.FileInfo) error {
+	return nil
+}
+
+// syncDir does nothing, as plan9 file servers commit the directory entries of a rename themselves
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestInPlace(t *testing.T) {
+	dir := t.TempDir()
+	inFileLoc := filepath.Join(dir, "in.log")
+	if err := os.WriteFile(inFileLoc, []byte("b\na\nb\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := runCommand("run", []string{"--in=" + inFileLoc, "--in-place", "--quiet"}); err != nil {
+		t.Fatal(err)
+	}
+	expectFile(t, inFileLoc, "a\nb\n")
+
+	// The mode of the file replaced is kept, which windows only has the read-only bit of
+	info, err := os.Stat(inFileLoc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected the mode of the file to be kept; Got: %v", info.Mode())
+	}
+
+	// No temporary file is left next to it
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected only the replaced file to be left; Got: %d files", len(entries))
+	}
+}
+
+func TestInPlaceSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target.log")
+	link := filepath.Join(dir, "link.log")
+	if err := os.WriteFile(target, []byte("b\na\nb\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("Unable to create a symlink: %v", err)
+	}
+	if err := runCommand("run", []string{"--in=" + link, "--in-place", "--quiet"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file linked to is replaced, and the link is left linking to it
+	expectFile(t, target, "a\nb\n")
+	info, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("Expected the symlink to be left as it is; Got: %v", info.Mode())
+	}
+	if dest, err := os.Readlink(link); err != nil || dest != target {
+		t.Fatalf("Expected the symlink to still link to %s; Got: %s %v", target, dest, err)
+	}
+	if info, err = os.Stat(target); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0640 {
+		t.Fatalf("Expected the mode of the file linked to to be kept; Got: %v", info.Mode())
+	}
+}
+
+func TestInPlaceSingleInput(t *testing.T) {
+	dir := t.TempDir()
+	var args []string
+	for _, name := range []string{"a.log", "b.log"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("b\na\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, "--in="+path)
+	}
+	err := runCommand("run", append(args, "--in-place", "--quiet"))
+	if err == nil || !strings.Contains(err.Error(), "exactly one input file") {
+		t.Fatalf("Expected in-place to require exactly one input file; Got: %v", err)
+	}
+
+	// Neither input is replaced
+	for _, name := range []string{"a.log", "b.log"} {
+		expectFile(t, filepath.Join(dir, name), "b\na\n")
+	}
+}
+
+// expectFile fails the test unless the file has the content
+func expectFile(t *testing.T, path, expected string) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != expected {
+		t.Fatalf("Expected %s to be %q; Got: %q", path, expected, b)
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+import "os"
+
+// chownLike does nothing, as windows files do not have a unix owner and group
+func chownLike(f *os.File, info os.FileInfo) error {
+	return nil
+}
+
+// syncDir does nothing, as windows can not sync directories, and renames are already durable
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/veqryn/dedup"
+)
+
+// interruptHandler removes the temporary files of this process, and any partially written files,
+// once it is interrupted or terminated, so that a killed run does not leave them behind
+type interruptHandler struct {
+	signals chan os.Signal
+	done    chan struct{}
+	tmpDirs []string
+
+	mu      sync.Mutex
+	partial []string
+}
+
+// handleInterrupts starts handling SIGINT and SIGTERM, by removing the temporary files of this process
+// from the directories (the os temp dir, if empty) and exiting with 128 plus the signal number, as shells do.
+// The handler must be stopped once the run is over, which restores the default handling.
+func handleInterrupts(tmpDirs ...string) *interruptHandler {
+	h := &interruptHandler{signals: make(chan os.Signal, 1), done: make(chan struct{}), tmpDirs: tmpDirs}
+	signal.Notify(h.signals, os.Interrupt, syscall.SIGTERM)
+	go h.wait()
+	return h
+}
+
+// addRemovePartialFlag registers the remove-partial-output flag on the flag set
+func addRemovePartialFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("remove-partial-output", false, "remove the output file if interrupted, terminated, or timed out, rather than leave it "+
+		"partially written. an output file being appended to is never removed")
+}
+
+// removePartial also removes the files when interrupted, as they are incomplete until the run is over
+func (h *interruptHandler) removePartial(paths ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.partial = append(h.partial, paths...)
+}
+
+// removePartialFiles removes the partially written files, such as once the run has timed out
+func (h *interruptHandler) removePartialFiles() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, path := range h.partial {
+		h.remove(path)
+	}
+}
+
+// stop stops handling the signals
+func (h *interruptHandler) stop() {
+	signal.Stop(h.signals)
+	close(h.done)
+}
+
+// wait cleans up and exits once a signal is received, unless stopped first
+func (h *interruptHandler) wait() {
+	var sig os.Signal
+	select {
+	case sig = <-h.signals:
+	case <-h.done:
+		return
+	}
+	console.Warnf("Received %s, removing temporary files", sig)
+
+	// The run keeps going until the process exits, so the directories are only listed once the
+	// partial files are removed
+	h.removePartialFiles()
+	for _, dir := range h.tmpDirs {
+		files, err := dedup.FindTempFiles(dir)
+		if err != nil {
+			console.Warnf("Error finding temporary files: %v", err)
+			continue
+		}
+		for _, f := range files {
+			if f.Own() {
+				h.remove(f.Path)
+			}
+		}
+	}
+
+	os.Exit(signalExitCode(sig))
+}
+
+// remove removes the file, logging any error other than it not existing
+func (h *interruptHandler) remove(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		console.Warnf("Error removing %s: %v", path, err)
+		return
+	}
+	console.Verbosef("Removed: %s", path)
+}
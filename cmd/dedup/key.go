@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+
+	"github.com/veqryn/dedup"
+)
+
+// keyFlags are the flags that deduplicate the lines by a key of them, rather than the whole lines
+type keyFlags struct {
+	stripTimestamp       *string
+	stripTimestampLayout *string
+	fields               *string
+	delimiter            *string
+	prefixBytes          *int
+	writeKeys            *bool
+	patternsMatchKeys    *bool
+	stripANSI            *bool
+	stripANSIOutput      *bool
+
+	timestamp *regexp.Regexp
+	ranges    []dedup.FieldRange
+	delim     string
+}
+
+// addKeyFlags registers the key flags on the flag set
+func addKeyFlags(fs *flag.FlagSet) *keyFlags {
+	return &keyFlags{
+		stripTimestamp: fs.String("strip-timestamp", "", "re2 regex pattern of the timestamp lines begin with, which is ignored when "+
+			"comparing lines, along with the spaces after it, while still writing the first line read of each"),
+		stripTimestampLayout: fs.String("strip-timestamp-layout", "", "the same as strip-timestamp, with the timestamp in a strptime "+
+			"layout, such as '%Y-%m-%d %H:%M:%S'"),
+		fields: fs.String("key-fields", "", "deduplicate the lines by only these fields of them, separated by key-delim, as a list "+
+			"of cut -f, such as '1,3' or '2-4', while still writing the first line read of each"),
+		delimiter: fs.String("key-delim", "tab", "string separating the fields of key-fields, such as ',' or '\\t', or one of: tab, nul"),
+		prefixBytes: fs.Int("key-prefix-bytes", 0, "deduplicate the lines by only their first this many bytes, once any timestamp is "+
+			"stripped and fields selected, while still writing the first line read of each prefix"),
+		writeKeys: fs.Bool("write-keys", false, "write the key each line is deduplicated by, such as the line without its timestamp, "+
+			"rather than the first line read of each"),
+		stripANSI: fs.Bool("strip-ansi", false, "ignore the ansi escape sequences of the lines, such as the colors of a terminal, when "+
+			"comparing lines, while still writing the first line read of each, so colored lines are duplicates of plain ones"),
+		stripANSIOutput: fs.Bool("strip-ansi-output", false, "strip the ansi escape sequences of the lines before deduplicating them, "+
+			"and write them stripped, keeping the output sorted"),
+		patternsMatchKeys: fs.Bool("patterns-match-keys", false, "match the skip and keep patterns against the key each line is "+
+			"deduplicated by, such as its key-fields, rather than the whole line"),
+	}
+}
+
+// validate returns an error if the flags are invalid, and compiles the timestamp pattern
+func (f *keyFlags) validate() error {
+	if *f.prefixBytes < 0 {
+		return fmt.Errorf("key-prefix-bytes flag must not be negative")
+	}
+	var err error
+	if *f.fields != "" {
+		if f.ranges, err = dedup.ParseFieldRanges(*f.fields); err != nil {
+			return fmt.Errorf("key-fields flag: %w", err)
+		}
+		if f.delim, err = parseDelimiter(*f.delimiter); err != nil {
+			return fmt.Errorf("key-delim flag: %w", err)
+		}
+		if f.delim == "" {
+			return fmt.Errorf("key-delim flag must not be empty")
+		}
+	}
+	switch {
+	case *f.stripTimestamp != "" && *f.stripTimestampLayout != "":
+		return fmt.Errorf("strip-timestamp and strip-timestamp-layout flags can not be used together")
+	case *f.stripTimestamp != "":
+		if f.timestamp, err = regexp.Compile(*f.stripTimestamp); err != nil {
+			return fmt.Errorf("strip-timestamp flag: %w", err)
+		}
+	case *f.stripTimestampLayout != "":
+		if f.timestamp, err = dedup.TimestampPattern(*f.stripTimestampLayout); err != nil {
+			return fmt.Errorf("strip-timestamp-layout flag: %w", err)
+		}
+	}
+	return nil
+}
+
+// keyed returns true if the lines are deduplicated by a key, and the first line read of each is written,
+// so that the output is not sorted by what is written
+func (f *keyFlags) keyed() bool {
+	return (f.timestamp != nil || len(f.ranges) > 0 || *f.prefixBytes > 0 || (*f.stripANSI && !*f.stripANSIOutput)) && !*f.writeKeys
+}
+
+// apply sets the key options
+func (f *keyFlags) apply(opts *dedup.Options) {
+	opts.StripANSI, opts.StripANSIOutput = *f.stripANSI, *f.stripANSIOutput
+	opts.StripTimestamp = f.timestamp
+	opts.KeyFields, opts.KeyDelimiter = f.ranges, f.delim
+	opts.KeyPrefixBytes = *f.prefixBytes
+	opts.WriteKeys = *f.writeKeys
+	opts.PatternsMatchKeys = *f.patternsMatchKeys
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/veqryn/dedup"
+)
+
+// lineMapKeyLength is the length of the sort key at the start of each unsorted line map record:
+// the index of the input file, and the line number within it, both zero padded
+const lineMapKeyLength = 8 + 20
+
+// lineMap writes the output line number of every input line to the line map file, in the order
+// of the input. The lines are mapped in no particular order, so they are sorted by dedup itself.
+type lineMap struct {
+	path     string
+	tmpDir   string
+	unsorted *os.File
+	writer   *bufio.Writer
+	sources  map[string]int
+	mapped   uint64
+}
+
+// openLineMap prepares to write the line map file, unless the path is empty, and sets the options
+// to record to it. The inputs are the paths of the input files. The returned map must be closed,
+// and is nil without a path.
+func openLineMap(path string, inputs []string, tmpDir string, opts *dedup.Options) (*lineMap, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("line map file already exists: %s", path)
+	}
+	unsorted, err := dedup.CreateTemp(tmpDir, "linemap.*.log")
+	if err != nil {
+		return nil, err
+	}
+	m := &lineMap{
+		path:     path,
+		tmpDir:   tmpDir,
+		unsorted: unsorted,
+		writer:   bufio.NewWriter(unsorted),
+		sources:  make(map[string]int, len(inputs)),
+	}
+	for i, input := range inputs {
+		m.sources[input] = i
+	}
+	opts.OnLineMapped = m.record
+	return m, nil
+}
+
+// record writes the mapping of a line to the unsorted file, after its sort key
+func (m *lineMap) record(pos dedup.Position, outputLine uint64) error {
+	m.mapped++
+	target := "removed"
+	if outputLine > 0 {
+		target = strconv.FormatUint(outputLine, 10)
+	}
+	_, err := fmt.Fprintf(m.writer, "%08d%020d%s\t%d\t%s\n", m.sources[pos.Source], pos.Line, pos.Source, pos.Line, target)
+	return err
+}
+
+// finish sorts the recorded mappings into the line map file, as tab separated input file, input line
+// number, and output line number or "removed"
+func (m *lineMap) finish(tmpFileBytes uint64) error {
+	if err := m.writer.Flush(); err != nil {
+		return err
+	}
+	if _, err := m.unsorted.Seek(0, 0); err != nil {
+		return err
+	}
+	sorted, err := dedup.CreateTemp(m.tmpDir, "linemap.*.log")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(sorted.Name())
+	defer sorted.Close()
+
+	// Every key is distinct, so this only sorts
+	opts := dedup.Options{
+		TmpFileBytes: tmpFileBytes,
+		TempDir:      m.tmpDir,
+		OnProgress:   func(dedup.Progress) {},
+		OnEvent:      func(dedup.Event) {},
+	}
+	if _, err = dedup.Run(sorted, opts, m.unsorted, nil); err != nil {
+		return err
+	}
+	if _, err = sorted.Seek(0, 0); err != nil {
+		return err
+	}
+
+	outFile, err := createOutFile(m.path, false)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+	writer := bufio.NewWriter(outFile)
+	scanner := bufio.NewScanner(sorted)
+	for scanner.Scan() {
+		if _, err = writer.Write(scanner.Bytes()[lineMapKeyLength:]); err != nil {
+			return err
+		}
+		if err = writer.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	console.Printf("Wrote %d lines to line map: %s", m.mapped, m.path)
+	return outFile.Close()
+}
+
+// close removes the unsorted file. It is safe to call on a nil lineMap, which does nothing.
+func (m *lineMap) close() {
+	if m == nil {
+		return
+	}
+	m.unsorted.Close()
+	os.Remove(m.unsorted.Name())
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/veqryn/dedup"
+)
+
+// verbosity levels of the console output
+const (
+	verbosityQuiet   = -1 // only errors
+	verbosityNormal  = 0  // phase transitions and progress
+	verbosityVerbose = 1  // every temporary file and merge detail
+)
+
+// log formats of the console output
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// console is where every subcommand writes its log lines, so that they respect the logging flags
+var console = &consoleLogger{w: os.Stderr, format: logFormatText}
+
+// consoleLogger writes log lines to stderr, filtered by verbosity, as either text or JSON lines.
+// While a progress bar is being shown, the bar is cleared before each line and redrawn afterwards.
+type consoleLogger struct {
+	mu        sync.Mutex
+	w         io.Writer
+	verbosity int
+	format    string
+	bar       *progressBar
+}
+
+// logRecord is a single JSON log line. Event and progress fields are only set on those lines.
+type logRecord struct {
+	Time  string `json:"time"`
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+
+	// Event fields
+	Kind  dedup.EventKind `json:"kind,omitempty"`
+	Phase dedup.Phase     `json:"phase,omitempty"`
+	File  string          `json:"file,omitempty"`
+	Lines *uint64         `json:"lines,omitempty"`
+	Bytes *uint64         `json:"bytes,omitempty"`
+	Secs  *float64        `json:"duration_seconds,omitempty"`
+	Error string          `json:"error,omitempty"`
+
+	// Progress fields
+	TotalLines     *uint64  `json:"total_lines,omitempty"`
+	TotalBytes     *uint64  `json:"total_bytes,omitempty"`
+	Percent        *float64 `json:"percent,omitempty"`
+	LinesPerSecond *float64 `json:"lines_per_second,omitempty"`
+	BytesPerSecond *float64 `json:"bytes_per_second,omitempty"`
+	ElapsedSeconds *float64 `json:"elapsed_seconds,omitempty"`
+	ETASeconds     *float64 `json:"eta_seconds,omitempty"`
+}
+
+// logFlags are the logging flags every subcommand has
+type logFlags struct {
+	quiet   *bool
+	verbose *bool
+	format  *string
+}
+
+// addLogFlags registers the logging flags on the flag set
+func addLogFlags(fs *flag.FlagSet) logFlags {
+	return logFlags{
+		quiet:   fs.Bool("quiet", false, "only print errors"),
+		verbose: fs.Bool("verbose", false, "also print every temporary file creation and merge detail"),
+		format:  fs.String("log-format", logFormatText, "format of the log lines and progress: text or json"),
+	}
+}
+
+// apply configures the console with the parsed logging flags
+func (f logFlags) apply() error {
+	if *f.quiet && *f.verbose {
+		return fmt.Errorf("quiet and verbose flags can not be used together")
+	}
+	switch {
+	case *f.quiet:
+		console.verbosity = verbosityQuiet
+	case *f.verbose:
+		console.verbosity = verbosityVerbose
+	default:
+		console.verbosity = verbosityNormal
+	}
+
+	if *f.format != logFormatText && *f.format != logFormatJSON {
+		return fmt.Errorf("log-format flag must be one of: %s, %s", logFormatText, logFormatJSON)
+	}
+	console.format = *f.format
+	return nil
+}
+
+// Printf logs a line at normal verbosity, such as a phase transition
+func (c *consoleLogger) Printf(format string, args ...interface{}) {
+	c.log(verbosityNormal, logRecord{Msg: fmt.Sprintf(format, args...)})
+}
+
+// Verbosef logs a line that is only shown with the verbose flag
+func (c *consoleLogger) Verbosef(format string, args ...interface{}) {
+	c.log(verbosityVerbose, logRecord{Msg: fmt.Sprintf(format, args...)})
+}
+
+// Warnf logs a line that is shown even with the quiet flag
+func (c *consoleLogger) Warnf(format string, args ...interface{}) {
+	c.log(verbosityQuiet, logRecord{Msg: fmt.Sprintf(format, args...)})
+}
+
+// Fatal logs the error and exits, with the code of the error if it is an exitError
+func (c *consoleLogger) Fatal(err error) {
+	c.mu.Lock()
+	c.bar.clear()
+	c.write(logRecord{Level: "error", Msg: err.Error(), Error: err.Error()})
+	c.mu.Unlock()
+
+	code := 1
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.code
+	}
+	os.Exit(code)
+}
+
+// log writes the record if the verbosity is at least the level
+func (c *consoleLogger) log(level int, rec logRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.verbosity < level {
+		return
+	}
+	if rec.Level == "" {
+		rec.Level = levelName(level)
+	}
+	c.bar.clear()
+	c.write(rec)
+	c.bar.redraw()
+}
+
+// write formats and writes the record. The mutex must be held.
+func (c *consoleLogger) write(rec logRecord) {
+	if c.format != logFormatJSON {
+		log.New(c.w, "", log.LstdFlags).Println(rec.Msg)
+		return
+	}
+	rec.Time = time.Now().Format(time.RFC3339Nano)
+	line, err := json.Marshal(rec)
+	if err != nil {
+		line = []byte(fmt.Sprintf(`{"level":"error","msg":%q}`, err.Error()))
+	}
+	c.w.Write(append(line, '\n'))
+}
+
+// levelName returns the name of the level of a verbosity, for JSON log lines
+func levelName(level int) string {
+	switch level {
+	case verbosityQuiet:
+		return "warn"
+	case verbosityVerbose:
+		return "debug"
+	default:
+		return "info"
+	}
+}
+
+// event logs an event from the dedup library at the level matching its kind
+func (c *consoleLogger) event(e dedup.Event) {
+	rec := logRecord{Msg: e.Message, Kind: e.Kind, Phase: e.Phase, File: e.File}
+	if e.Lines > 0 || e.Kind == dedup.EventPhaseFinished || e.Kind == dedup.EventChunkWritten {
+		rec.Lines, rec.Bytes = &e.Lines, &e.Bytes
+	}
+	if e.Duration > 0 {
+		secs := e.Duration.Seconds()
+		rec.Secs = &secs
+	}
+	if e.Err != nil {
+		rec.Error = e.Err.Error()
+	}
+
+	switch {
+	case e.Kind == dedup.EventWarning:
+		c.log(verbosityQuiet, rec)
+	case e.Detail():
+		c.log(verbosityVerbose, rec)
+	default:
+		c.log(verbosityNormal, rec)
+	}
+}
+
+// progress returns the progress reporter to give the dedup library for the logging flags,
+// and the interval it should be called at. The bar is only shown for text logs on a terminal.
+func (c *consoleLogger) progress(showBar bool, linesInterval time.Duration) (func(dedup.Progress), time.Duration) {
+	if c.verbosity == verbosityQuiet {
+		return func(dedup.Progress) {}, linesInterval
+	}
+	if showBar && c.format == logFormatText && isTerminal(os.Stderr) {
+		c.mu.Lock()
+		c.bar = &progressBar{w: c.w}
+		c.mu.Unlock()
+		return func(p dedup.Progress) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.bar.update(p)
+		}, barInterval
+	}
+	return func(p dedup.Progress) {
+		// Finished phases are already logged as events
+		if !p.Done {
+			c.log(verbosityNormal, progressRecord(p))
+		}
+	}, linesInterval
+}
+
+// progressRecord returns the log record of the progress of a phase
+func progressRecord(p dedup.Progress) logRecord {
+	linesPerSecond, bytesPerSecond := p.LinesPerSecond(), p.BytesPerSecond()
+	elapsed := p.Elapsed.Seconds()
+	rec := logRecord{
+		Msg:            progressLine(p),
+		Kind:           "progress",
+		Phase:          p.Phase,
+		Lines:          &p.Lines,
+		Bytes:          &p.Bytes,
+		LinesPerSecond: &linesPerSecond,
+		BytesPerSecond: &bytesPerSecond,
+		ElapsedSeconds: &elapsed,
+	}
+	if p.TotalLines > 0 {
+		rec.TotalLines = &p.TotalLines
+	}
+	if p.TotalBytes > 0 {
+		rec.TotalBytes = &p.TotalBytes
+	}
+	if pct, ok := p.Percent(); ok {
+		rec.Percent = &pct
+	}
+	if eta, ok := p.ETA(); ok {
+		etaSeconds := eta.Seconds()
+		rec.ETASeconds = &etaSeconds
+	}
+	return rec
+}
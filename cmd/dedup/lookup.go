@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/veqryn/dedup"
+)
+
+// maxQueryBytes is the longest line that can be looked up
+const maxQueryBytes = 16 * 1024 * 1024
+
+// lookupCommand answers whether lines are in sorted and deduplicated files over a unix socket, so that
+// other processes can deduplicate their lines online against the output of earlier runs
+func lookupCommand(name string, args []string) error {
+	// Flags
+	fs := newFlagSet(name)
+	var inFileGlobs arrayFlags
+	fs.Var(&inFileGlobs, "in", "sorted and deduplicated file location or glob, such as the output of run, to look lines up in "+
+		"(flag can be used multiple times)")
+	inLists := addInListFlag(fs)
+	socket := fs.String("socket", "", "path of the unix socket to listen on, which must not exist yet")
+	delimiter := addDelimiterFlag(fs)
+	logFlags := addLogFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := logFlags.apply(); err != nil {
+		return err
+	}
+	if *socket == "" {
+		return fmt.Errorf("socket flag must be non-empty")
+	}
+
+	// Open the files to look lines up in
+	inFileLocs, err := withInLists(inFileGlobs, *inLists)
+	if err != nil {
+		return err
+	}
+	paths, err := expandGlobs(inFileLocs)
+	if err != nil {
+		return err
+	}
+	inFiles, closeInFiles, err := openFiles(paths)
+	defer closeInFiles()
+	if err != nil {
+		return err
+	}
+	files := make([]*dedup.SortedFile, len(inFiles))
+	for i, f := range inFiles {
+		if files[i], err = openSortedFile(f, paths[i], dedup.Options{Delimiter: delimiter.value}); err != nil {
+			return err
+		}
+	}
+
+	// The socket is removed once interrupted, so that it can be listened on again
+	ln, err := net.Listen("unix", *socket)
+	if err != nil {
+		return fmt.Errorf("unable to listen: %w", err)
+	}
+	defer ln.Close()
+	interrupts := handleInterrupts()
+	defer interrupts.stop()
+	interrupts.removePartial(*socket)
+
+	console.Printf("Answering lookups in %d files on: %s", len(files), *socket)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			if err := answerLookups(conn, files, delimiter.value); err != nil && !errors.Is(err, net.ErrClosed) {
+				console.Warnf("Error answering lookups: %v", err)
+			}
+		}()
+	}
+}
+
+// answerLookups answers each line read from the connection, split by the delimiter, with a line of 1 if
+// it is in any of the files, or 0 if not, in order. An error is answered with a line starting with
+// "error: ", and ends the connection.
+func answerLookups(conn io.ReadWriter, files []*dedup.SortedFile, delimiter string) error {
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxQueryBytes)
+	if delimiter != "" {
+		scanner.Split(splitQueries([]byte(delimiter)))
+	}
+	for scanner.Scan() {
+		found, err := lookup(files, scanner.Text())
+		if err != nil {
+			fmt.Fprintf(conn, "error: %v\n", err)
+			return err
+		}
+		answer := "0\n"
+		if found {
+			answer = "1\n"
+		}
+		if _, err = io.WriteString(conn, answer); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// lookup returns true if the line is in any of the files
+func lookup(files []*dedup.SortedFile, line string) (bool, error) {
+	for _, f := range files {
+		if found, err := f.Contains(line); err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// splitQueries returns a split function for a scanner of queries, that splits on the delimiter
+func splitQueries(delim []byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := bytes.Index(data, delim); i >= 0 {
+			return i + len(delim), data[:i], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
@@ -0,0 +1,278 @@
+// Package github.com/veqryn/dedup/cmd/dedup can be run to deduplicate string data. To run:
+//
+//	go run github.com/veqryn/dedup/cmd/dedup run --in=testdata/testdata.log --out=deduped.log
+//
+// or
+//
+//	go install github.com/veqryn/dedup/cmd/dedup@latest
+//	dedup run --in=testdata/testdata.log --out=deduped.log
+//
+// or
+//
+//	go build -o ./dedup github.com/veqryn/dedup/cmd/dedup
+//	./dedup run --in=testdata/testdata.log --out=deduped.log
+//
+// Run ./dedup help to list all subcommands, or ./dedup <subcommand> --help for their flags.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/veqryn/dedup"
+)
+
+// command is a subcommand of the dedup executable
+type command struct {
+	name   string
+	short  string
+	run    func(name string, args []string) error
+	hidden bool // not listed in the usage
+}
+
+// commands are all subcommands, in the order they are listed in the usage
+var commands = []command{
+	{name: "run", short: "deduplicate the input files into a sorted output file", run: runCommand},
+	{name: "sort", short: "deduplicate the input files into sorted chunk files, without merging them", run: sortCommand},
+	{name: "merge", short: "merge already sorted files into a single sorted and deduplicated output file", run: mergeCommand},
+	{name: "count", short: "count the lines in the input files", run: countCommand},
+	{name: "diff", short: "compare the unique lines of two sets of input files, which do not need to be sorted", run: diffCommand},
+	{name: "verify", short: "check that the input files are sorted and contain no duplicates", run: verifyCommand},
+	{name: "estimate", short: "estimate the duplicate ratio and output size of the input files from a sample of them", run: estimateCommand},
+	{name: "clean", short: "remove the temporary files left behind by runs that crashed or were killed", run: cleanCommand},
+	{name: "gen", short: "generate a file of random test data", run: genCommand},
+	{name: "bench", short: "benchmark the throughput of deduplicating test data with combinations of settings", run: benchCommand},
+	{name: "lookup", short: "answer whether lines are in sorted output files, over a unix socket", run: lookupCommand},
+	{name: "search", short: "print the lines of sorted output files that are a line or start with a prefix", run: searchCommand},
+}
+
+func main() {
+	args := os.Args[1:]
+	if len(args) == 0 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	name := args[0]
+	switch {
+	case name == "help" || name == "-h" || name == "-help" || name == "--help":
+		usage(os.Stdout)
+		return
+	case strings.HasPrefix(name, "-"):
+		// Flags without a subcommand are how dedup was run before it had subcommands
+		name = "run"
+	default:
+		args = args[1:]
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := cmd.run(name, args); err != nil {
+				console.Fatal(err)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown subcommand: %s\n\n", name)
+	usage(os.Stderr)
+	os.Exit(2)
+}
+
+// exitDuplicates is the exit code when the fail-if-duplicates flag found duplicates
+const exitDuplicates = 3
+
+// exitNotFound is the exit code when the search subcommand matched no lines
+const exitNotFound = 4
+
+// exitError is an error that exits with a specific code, rather than 1
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// usage prints the list of subcommands
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <subcommand> [flags]\n\nSubcommands:\n", filepath.Base(os.Args[0]))
+	for _, cmd := range commands {
+		if !cmd.hidden {
+			fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.short)
+		}
+	}
+	fmt.Fprintf(w, "\nRun '%s <subcommand> --help' for the flags of a subcommand.\n", filepath.Base(os.Args[0]))
+}
+
+// envPrefix is the prefix of the environment variables that can set any flag
+const envPrefix = "DEDUP_"
+
+// newFlagSet returns a flag set for a subcommand
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0])+" "+name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nEvery flag can also be set by an environment variable named %s followed by the flag\n"+
+			"name in upper case with underscores, such as %s for --tmp-file-bytes.\n"+
+			"Flags that can be used multiple times take new line separated values. Flags on the command line win.\n",
+			envPrefix, envName("tmp-file-bytes"))
+	}
+	return fs
+}
+
+// envName returns the name of the environment variable for a flag
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// parseFlags parses the command line arguments, then sets every flag that was not on the
+// command line from its environment variable, if present
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if flagCollector != nil {
+		flagCollector(fs)
+		return errFlagsCollected
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	onCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || onCommandLine[f.Name] || err != nil {
+			return
+		}
+
+		// Flags that can be used multiple times take a value per line
+		values := []string{value}
+		if _, ok := f.Value.(*arrayFlags); ok {
+			values = strings.Split(strings.TrimRight(value, "\n"), "\n")
+		}
+		for _, v := range values {
+			if setErr := fs.Set(f.Name, v); setErr != nil {
+				err = fmt.Errorf("invalid value %q for environment variable %s: %w", v, envName(f.Name), setErr)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// arrayFlags lets you set a flag multiple times
+type arrayFlags []string
+
+func (i *arrayFlags) String() string {
+	return strings.Join(*i, string(os.PathListSeparator))
+}
+
+func (i *arrayFlags) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// expandGlobs returns the paths of all files matching the globs, in the order given
+func expandGlobs(fileGlobs []string) ([]string, error) {
+	if len(fileGlobs) == 0 {
+		return nil, fmt.Errorf("in or in-list flag must be non-empty")
+	}
+
+	var paths []string
+	for _, fileGlob := range fileGlobs {
+		filePaths, err := filepath.Glob(fileGlob)
+		if err != nil {
+			return nil, err
+		}
+		if len(filePaths) == 0 {
+			return nil, fmt.Errorf("No files found: %s", fileGlob)
+		}
+		paths = append(paths, filePaths...)
+	}
+	return paths, nil
+}
+
+// openFiles opens all files for reading, with stdio as stdin. The returned close function closes all
+// of them, and must be called even if an error is returned.
+func openFiles(paths []string) ([]*os.File, func(), error) {
+	var files []*os.File
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	for _, fileLoc := range paths {
+		if fileLoc == stdio {
+			files = append(files, os.Stdin)
+			continue
+		}
+		console.Verbosef("Opening file: %s", fileLoc)
+		f, err := os.Open(fileLoc)
+		if err != nil {
+			return files, closeAll, err
+		}
+		files = append(files, f)
+	}
+	return files, closeAll, nil
+}
+
+// multiReader returns a reader that reads all the files in sequence
+func multiReader(files []*os.File) io.Reader {
+	readers := make([]io.Reader, len(files))
+	for i, f := range files {
+		readers[i] = f
+	}
+	return io.MultiReader(readers...)
+}
+
+// sources returns a reader that reads all the files in sequence, and knows which file each line came from
+func sources(files []*os.File) *dedup.Sources {
+	named := make([]dedup.Source, len(files))
+	for i, f := range files {
+		named[i] = dedup.Source{Name: f.Name(), Reader: f}
+	}
+	return dedup.NewSources(named...)
+}
+
+// closeOutputs closes the output files once they have been written, returning the first error, since
+// an error closing a file may be the first report of its contents failing to be written. The files may
+// be closed again by deferred calls, which then do nothing.
+func closeOutputs(files []*os.File) error {
+	var firstErr error
+	for _, f := range files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("closing output file %s: %w", f.Name(), err)
+		}
+	}
+	return firstErr
+}
+
+// createOutFile creates the output file for writing. Unless appending, the file must not exist yet.
+func createOutFile(outFileLoc string, appendFlag bool) (*os.File, error) {
+	if outFileLoc == "" {
+		return nil, fmt.Errorf("out flag must be non-empty")
+	}
+
+	var fileOpts int
+	if appendFlag {
+		fileOpts = os.O_CREATE | os.O_APPEND | os.O_RDWR
+	} else {
+		// Read as well as written, so that it can be memory mapped
+		fileOpts = os.O_CREATE | os.O_EXCL | os.O_RDWR
+	}
+	return os.OpenFile(outFileLoc, fileOpts, 0644)
+}
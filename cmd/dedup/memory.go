@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/veqryn/dedup"
+)
+
+// memoryFlags are the tmp-file-bytes, memory, adaptive-chunks, prefix-set, hash-set,
+// spill-gc-fraction, and spill-available-bytes flags, which limit how many lines are held in memory
+type memoryFlags struct {
+	fs                  *flag.FlagSet
+	tmpFileBytes        *uint64
+	memory              *memoryFlag
+	memoryFraction      *float64
+	adaptiveChunks      *bool
+	prefixSet           *bool
+	hashSet             *bool
+	spillGCFraction     *float64
+	spillAvailableBytes *uint64
+}
+
+// addMemoryFlags registers the tmp-file-bytes, memory, adaptive-chunks, prefix-set, hash-set,
+// spill-gc-fraction, and spill-available-bytes flags on the flag set, describing the files the
+// lines held in memory are spilled to
+func addMemoryFlags(fs *flag.FlagSet, files string) memoryFlags {
+	f := memoryFlags{
+		fs: fs,
+		tmpFileBytes: fs.Uint64("tmp-file-bytes", 250000000,
+			"max "+files+" byte size. app will use 2-5x more memory than this to run"),
+		memory: &memoryFlag{},
+		memoryFraction: fs.Float64("memory-fraction", 0.75, "without the memory flag, or with auto, the fraction of the memory limit of the "+
+			"container's cgroup or GOMEMLIMIT, whichever is lower, to use as the memory flag. 0 ignores the limit"),
+		adaptiveChunks: fs.Bool("adaptive-chunks", false, "count the overhead of each line in memory towards tmp-file-bytes, "+
+			"from the average line length, so that "+files+"s use about the same memory whatever the length of the lines"),
+		prefixSet: fs.Bool("prefix-set", false, "hold the lines in memory in a radix tree that stores the prefixes they share once, "+
+			"such as those of URLs, and count its memory towards tmp-file-bytes, so that far more lines fit in each "+files+
+			", but are looked up more slowly"),
+		hashSet: fs.Bool("hash-set", false, "hold the lines in memory in a slice, found by their 64-bit hash in a table without pointers, "+
+			"which uses less memory for each line than the default hash map, and less time collecting the garbage"),
+		spillGCFraction: fs.Float64("spill-gc-fraction", 0, "spill to a "+files+" early, however few lines were read, once the garbage "+
+			"collector paused the app for more than this fraction of the time, such as 0.2. 0 disables it"),
+		spillAvailableBytes: fs.Uint64("spill-available-bytes", 0, "spill to a "+files+" early, however few lines were read, once the "+
+			"memory available on the system falls below this many bytes, as other processes use more. linux only. 0 disables it"),
+	}
+	fs.Var(f.memory, "memory", "max byte size of the heap of the app, spilling to a "+files+" whenever it is reached, or auto for "+
+		"half the available memory of the system, re-evaluated before each "+files+". tmp-file-bytes defaults to a quarter of it "+
+		"(default: memory-fraction of the memory limit, if any)")
+	return f
+}
+
+// validate returns an error if the flags are invalid
+func (f memoryFlags) validate() error {
+	if *f.tmpFileBytes <= 0 {
+		return fmt.Errorf("tmp-file-bytes flag must be a positive integer or omitted for the default")
+	}
+	if *f.memoryFraction < 0 || *f.memoryFraction > 1 {
+		return fmt.Errorf("memory-fraction flag must be between 0 and 1")
+	}
+	if *f.prefixSet && *f.hashSet {
+		return fmt.Errorf("prefix-set and hash-set flags can not be used together")
+	}
+	if *f.spillGCFraction < 0 || *f.spillGCFraction >= 1 {
+		return fmt.Errorf("spill-gc-fraction flag must be at least 0 and less than 1")
+	}
+	return nil
+}
+
+// apply sets the TmpFileBytes, MemoryBytes, AutoMemory, AdaptiveChunks, PrefixSet, HashSet,
+// SpillGCFraction, and SpillAvailableBytes options of the flags. Without the memory flag, or with
+// auto, the memory limit of the process is detected. With any, tmp-file-bytes is only passed on if
+// it was set, on the command line or from the environment.
+func (f memoryFlags) apply(opts *dedup.Options) {
+	opts.AdaptiveChunks, opts.PrefixSet, opts.HashSet = *f.adaptiveChunks, *f.prefixSet, *f.hashSet
+	opts.SpillGCFraction, opts.SpillAvailableBytes = *f.spillGCFraction, *f.spillAvailableBytes
+	memoryBytes, auto := f.memory.bytes, f.memory.auto
+	if memoryBytes == 0 && *f.memoryFraction > 0 {
+		if limit, source := dedup.MemoryLimit(); limit > 0 {
+			memoryBytes = uint64(float64(limit) * *f.memoryFraction)
+			console.Printf("Using up to %d bytes of memory, %.0f%% of the limit from %s", memoryBytes, *f.memoryFraction*100, source)
+		}
+	}
+	if memoryBytes == 0 && !auto {
+		opts.TmpFileBytes = *f.tmpFileBytes
+		return
+	}
+	opts.MemoryBytes, opts.AutoMemory = memoryBytes, auto
+	f.fs.Visit(func(fl *flag.Flag) {
+		if fl.Name == "tmp-file-bytes" {
+			opts.TmpFileBytes = *f.tmpFileBytes
+		}
+	})
+}
+
+// memoryFlag is the byte size of the memory flag, or auto
+type memoryFlag struct {
+	bytes uint64
+	auto  bool
+}
+
+func (m *memoryFlag) String() string {
+	switch {
+	case m == nil:
+		return ""
+	case m.auto:
+		return "auto"
+	case m.bytes > 0:
+		return strconv.FormatUint(m.bytes, 10)
+	default:
+		return ""
+	}
+}
+
+func (m *memoryFlag) Set(value string) error {
+	if value == "auto" {
+		m.bytes, m.auto = 0, true
+		return nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("must be a byte size or auto")
+	}
+	m.bytes, m.auto = n, false
+	return nil
+}
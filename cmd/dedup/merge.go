@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/veqryn/dedup"
+)
+
+// mergeCommand merges already sorted files into a single sorted and deduplicated output file
+func mergeCommand(name string, args []string) error {
+	// Flags
+	fs := newFlagSet(name)
+	var inFileGlobs arrayFlags
+	fs.Var(&inFileGlobs, "in", "sorted input file location or glob (flag can be used multiple times)")
+	inLists := addInListFlag(fs)
+	outFileLoc := fs.String("out", "", "output file location")
+	formatFlags := addFormatFlags(fs, "its count")
+	limit := fs.Uint64("limit", 0, "stop after writing this many unique lines, which are the first of the sorted output (default: no limit)")
+	appendFlag := fs.Bool("append", false, "should append to file (default: only allow new files)")
+	removePartial := addRemovePartialFlag(fs)
+	atomic := addAtomicFlag(fs)
+	indexFlags := addIndexFlags(fs)
+	readBufferBytes := fs.Int("read-buffer-bytes", 256*1024, "byte size of the buffer for reading input files, which is also the maximum line length")
+	writeBufferBytes := fs.Int("write-buffer-bytes", 256*1024, "byte size of the buffer for writing the output and temporary files")
+	mergeBufferBytes := fs.Int("merge-buffer-bytes", 4*1024, "initial byte size of the buffer for reading each file while merging")
+	mergeMmap := fs.Bool("merge-mmap", false, "memory map each file while merging instead of reading it through a buffer, where supported")
+	verifyWrites := fs.Bool("verify-writes", false, "read back the output once merged, failing unless it has the lines and checksum "+
+		"written to it, sorted and unique")
+	delimiter := addDelimiterFlag(fs)
+	crlf := fs.Bool("crlf", false, "end each output line with \\r\\n, as is usual on windows, still accepting input lines ending in either (ignored with a delimiter)")
+	noFinalLineEnding := fs.Bool("no-final-line-ending", false, "leave the line ending off the last line of the output, only with the text format")
+	rateLimitFlags := addRateLimitFlags(fs)
+	syncFlags := addSyncFlags(fs)
+	retryFlags := addRetryFlags(fs)
+	timeoutFlags := addTimeoutFlags(fs, false)
+	dupReportFlags := addDupReportFlags(fs)
+	statsFlags := addStatsFlags(fs)
+	profileFlags := addProfileFlags(fs)
+	metricsAddr := addMetricsFlag(fs)
+	progressEventFlags := addProgressEventFlags(fs)
+	logFlags := addLogFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := logFlags.apply(); err != nil {
+		return err
+	}
+	stopProfiling, err := profileFlags.start()
+	defer func() {
+		if stopErr := stopProfiling(); stopErr != nil {
+			console.Warnf("Error writing profiles: %v", stopErr)
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	metrics := &dedup.Metrics{}
+	stopMetrics, err := serveMetrics(*metricsAddr, metrics)
+	defer stopMetrics()
+	if err != nil {
+		return err
+	}
+	if err := statsFlags.validate(); err != nil {
+		return err
+	}
+	if err := formatFlags.validate(); err != nil {
+		return err
+	}
+	if formatFlags.binary() && *appendFlag {
+		return fmt.Errorf("append flag can not be used with the parquet, arrow, or arrow-stream formats")
+	}
+	if *noFinalLineEnding && (*appendFlag || formatFlags.records()) {
+		return fmt.Errorf("no-final-line-ending flag can only be used with the text format, and not with the append flag")
+	}
+	if err := dupReportFlags.validate(); err != nil {
+		return err
+	}
+	if err := rateLimitFlags.validate(); err != nil {
+		return err
+	}
+	if err := retryFlags.validate(); err != nil {
+		return err
+	}
+	if err := progressEventFlags.validate(); err != nil {
+		return err
+	}
+	if err := timeoutFlags.validate(); err != nil {
+		return err
+	}
+
+	if *atomic && *appendFlag {
+		return fmt.Errorf("atomic flag can not be used with the append flag")
+	}
+	if err := indexFlags.validate(*outFileLoc, !formatFlags.records() && !*appendFlag); err != nil {
+		return err
+	}
+	if *readBufferBytes <= 0 || *writeBufferBytes <= 0 || *mergeBufferBytes <= 0 {
+		return fmt.Errorf("buffer byte flags must be positive integers or omitted for the defaults")
+	}
+
+	// Find input files
+	inFileLocs, err := withInLists(inFileGlobs, *inLists)
+	if err != nil {
+		return err
+	}
+	paths, err := expandGlobs(inFileLocs)
+	if err != nil {
+		return err
+	}
+	if err = checkOverlap(paths, []string{*outFileLoc}); err != nil {
+		return err
+	}
+
+	// Once interrupted, the temporary files of a cascaded merge are removed, and the output too if it is not complete
+	interrupts := handleInterrupts("")
+	defer interrupts.stop()
+
+	// Create output file for writing, or a temporary file next to it that is renamed to it once merged
+	var outFile *os.File
+	if *atomic {
+		outFile, err = createAtomicFile(*outFileLoc)
+	} else {
+		outFile, err = createOutFile(*outFileLoc, *appendFlag)
+	}
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+	if *atomic {
+		// Once renamed to the output file, this removal does nothing
+		defer os.Remove(outFile.Name())
+	}
+	if (*removePartial && !*appendFlag) || *atomic {
+		interrupts.removePartial(outFile.Name())
+	}
+
+	// Open input files for reading
+	inFiles, closeInFiles, err := openFiles(paths)
+	defer closeInFiles()
+	if err != nil {
+		return err
+	}
+
+	// Merge
+	console.Printf("Starting merge...")
+	opts := dedup.Options{
+		ReadBufferSize:    *readBufferBytes,
+		WriteBufferSize:   *writeBufferBytes,
+		MergeBufferSize:   *mergeBufferBytes,
+		MergeMmap:         *mergeMmap,
+		VerifyWrites:      *verifyWrites,
+		Delimiter:         delimiter.value,
+		CRLF:              *crlf,
+		NoFinalLineEnding: *noFinalLineEnding,
+		Limit:             *limit,
+		Metrics:           metrics,
+		OnEvent:           console.event,
+	}
+	formatFlags.apply(&opts)
+	rateLimitFlags.apply(&opts)
+	syncFlags.apply(&opts)
+	retryFlags.apply(&opts)
+	cancelTimeout := timeoutFlags.apply(&opts)
+	defer cancelTimeout()
+	opts.OnProgress, opts.ProgressInterval = console.progress(false, time.Minute)
+	progressEvents, err := progressEventFlags.open(&opts)
+	if err != nil {
+		return err
+	}
+	defer progressEvents.close()
+	sidecar, err := indexFlags.open(*outFileLoc, &opts)
+	if err != nil {
+		return err
+	}
+	defer sidecar.close()
+	dupReport, err := dupReportFlags.open(&opts)
+	if err != nil {
+		return err
+	}
+	stats, err := dedup.Merge(outFile, opts, inFiles...)
+	if closeErr := dupReport.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return timedOut(err, interrupts)
+	}
+	if *atomic {
+		if err = commitAtomic([]*os.File{outFile}, []string{*outFileLoc}, syncFlags.syncing()); err != nil {
+			return err
+		}
+		console.Printf("Moved into place: %s", *outFileLoc)
+	} else if err = closeOutputs([]*os.File{outFile}); err != nil {
+		return err
+	}
+	if sidecar != nil {
+		if err = sidecar.commit(syncFlags.syncing()); err != nil {
+			return err
+		}
+		console.Printf("Wrote sidecar index: %s", sidecar.path)
+	}
+	if err = statsFlags.print(stats); err != nil {
+		return err
+	}
+	console.Printf("Success!")
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"expvar"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/veqryn/dedup"
+)
+
+// metricsPhases are the phases that are exported as a label of the phase metric
+var metricsPhases = []dedup.Phase{dedup.PhaseSplitting, dedup.PhaseWriting, dedup.PhaseMerging}
+
+// addMetricsFlag registers the metrics flag on the flag set
+func addMetricsFlag(fs *flag.FlagSet) *string {
+	return fs.String("metrics-addr", "", "address to serve prometheus metrics on at /metrics, and expvar at /debug/vars, such as localhost:9090")
+}
+
+// serveMetrics serves the metrics on the address, unless it is empty. The returned stop function
+// closes the listener, and must be called even if an error is returned.
+func serveMetrics(addr string, metrics *dedup.Metrics) (func() error, error) {
+	if addr == "" {
+		return func() error { return nil }, nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return func() error { return nil }, fmt.Errorf("unable to serve metrics: %w", err)
+	}
+
+	expvar.Publish("dedup", expvar.Func(func() interface{} {
+		return map[string]interface{}{
+			"lines_read":   metrics.LinesRead(),
+			"lines_unique": metrics.LinesUnique(),
+			"chunks":       metrics.Chunks(),
+			"tmp_bytes":    metrics.TmpBytes(),
+			"phase":        metrics.Phase(),
+		}
+	}))
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetric(w, "dedup_lines_read_total", "counter", "Lines read from the input files.", metrics.LinesRead())
+		writeMetric(w, "dedup_lines_unique_total", "counter", "Unique lines written to the output file.", metrics.LinesUnique())
+		writeMetric(w, "dedup_chunks_total", "counter", "Temporary files written.", metrics.Chunks())
+		writeMetric(w, "dedup_tmp_bytes", "gauge", "Bytes of the temporary files currently on disk.", metrics.TmpBytes())
+
+		fmt.Fprintln(w, "# HELP dedup_phase The phase in progress, as a label with the value 1.")
+		fmt.Fprintln(w, "# TYPE dedup_phase gauge")
+		current := metrics.Phase()
+		for _, phase := range metricsPhases {
+			var value int
+			if phase == current {
+				value = 1
+			}
+			fmt.Fprintf(w, "dedup_phase{phase=%q} %d\n", phase, value)
+		}
+	})
+	go http.Serve(ln, mux)
+	console.Printf("Serving metrics on: http://%s/metrics", ln.Addr())
+	return ln.Close, nil
+}
+
+// writeMetric writes a metric in the prometheus text format
+func writeMetric(w http.ResponseWriter, name, kind, help string, value uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
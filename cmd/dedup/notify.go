@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/veqryn/dedup"
+)
+
+// notifyFlags are the notify-url and notify-timeout flags, which post the outcome of a run to a webhook
+type notifyFlags struct {
+	url     *string
+	timeout *time.Duration
+}
+
+// notification is the JSON body posted to the notify url once a run is done
+type notification struct {
+	Command string      `json:"command"`
+	Status  string      `json:"status"`
+	Error   string      `json:"error,omitempty"`
+	Out     string      `json:"out,omitempty"`
+	Stats   statsRecord `json:"stats"`
+}
+
+// addNotifyFlags registers the notify-url and notify-timeout flags on the flag set
+func addNotifyFlags(fs *flag.FlagSet) notifyFlags {
+	return notifyFlags{
+		url: fs.String("notify-url", "", "url to post a JSON summary to once the run is done, whether it succeeded or failed, "+
+			"with its status, error, and the same stats as stats-format=json, such as a webhook of a chat or an orchestrator"),
+		timeout: fs.Duration("notify-timeout", 10*time.Second, "how long to wait for the notify-url to respond"),
+	}
+}
+
+// validate returns an error if the flags are invalid
+func (f notifyFlags) validate() error {
+	if *f.url == "" {
+		return nil
+	}
+	u, err := url.Parse(*f.url)
+	if err != nil {
+		return fmt.Errorf("invalid notify-url flag: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("notify-url flag must be an http or https url")
+	}
+	if *f.timeout <= 0 {
+		return fmt.Errorf("notify-timeout flag must be positive")
+	}
+	return nil
+}
+
+// send posts the outcome of the command to the notify url, if there is one. A notification that fails
+// is only warned about, as the run itself is already done.
+func (f notifyFlags) send(command, outFileLoc string, stats dedup.Stats, runErr error) {
+	if *f.url == "" {
+		return
+	}
+	n := notification{Command: command, Status: "succeeded", Out: outFileLoc, Stats: newStatsRecord(stats)}
+	if runErr != nil {
+		n.Status = "failed"
+		n.Error = runErr.Error()
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		console.Warnf("Error notifying %s: %v", *f.url, err)
+		return
+	}
+	client := &http.Client{Timeout: *f.timeout}
+	resp, err := client.Post(*f.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		console.Warnf("Error notifying %s: %v", *f.url, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		console.Warnf("Error notifying %s: %s", *f.url, resp.Status)
+		return
+	}
+	console.Verbosef("Notified: %s", *f.url)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// checkOverlap returns an error if any of the output files already exists and is one of the input files,
+// whether by the same path, a symlink, or a hard link, since writing it would destroy the input being read
+func checkOverlap(inputs, outputs []string) error {
+	for _, out := range outputs {
+		outInfo, err := os.Stat(out)
+		if err != nil {
+			// An output that does not exist yet can not be an input
+			continue
+		}
+		for _, in := range inputs {
+			inInfo, err := os.Stat(in)
+			if err != nil || !os.SameFile(inInfo, outInfo) {
+				continue
+			}
+			return fmt.Errorf("output file %s is the input file %s", out, in)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOverlap(t *testing.T) {
+	dir := t.TempDir()
+	inFileLoc := filepath.Join(dir, "in.log")
+	if err := os.WriteFile(inFileLoc, []byte("b\na\nb\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	otherFileLoc := filepath.Join(dir, "other.log")
+	if err := os.WriteFile(otherFileLoc, []byte("c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	symlinkLoc := filepath.Join(dir, "symlink.log")
+	if err := os.Symlink(inFileLoc, symlinkLoc); err != nil {
+		t.Skipf("Symlinks are not supported: %v", err)
+	}
+	hardLinkLoc := filepath.Join(dir, "hardlink.log")
+	if err := os.Link(inFileLoc, hardLinkLoc); err != nil {
+		t.Skipf("Hard links are not supported: %v", err)
+	}
+
+	// The output can not be the input, however it is reached, which would destroy the input being read
+	for _, tc := range []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{name: "same path", args: []string{"run", "--in=" + inFileLoc, "--out=" + inFileLoc}, expected: "is the input file"},
+		{name: "symlink", args: []string{"run", "--in=" + inFileLoc, "--out=" + symlinkLoc}, expected: "is the input file"},
+		{name: "hard link", args: []string{"run", "--in=" + inFileLoc, "--out=" + hardLinkLoc}, expected: "is the input file"},
+		{name: "append", args: []string{"run", "--in=" + inFileLoc, "--out=" + symlinkLoc, "--append"}, expected: "is the input file"},
+		{name: "reference", args: []string{"run", "--in=" + otherFileLoc, "--out=" + symlinkLoc, "--reference=" + inFileLoc}, expected: "can not be the output file"},
+		{name: "merge", args: []string{"merge", "--in=" + inFileLoc, "--out=" + hardLinkLoc}, expected: "is the input file"},
+	} {
+		cmd := runCommand
+		if tc.args[0] == "merge" {
+			cmd = mergeCommand
+		}
+		err := cmd(tc.args[0], append(tc.args[1:], "--quiet"))
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Fatalf("Expected an error of %s that %s; Got: %v", tc.name, tc.expected, err)
+		}
+		expectFile(t, inFileLoc, "b\na\nb\n")
+	}
+}
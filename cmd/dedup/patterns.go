@@ -0,0 +1,217 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/veqryn/dedup"
+)
+
+// patternFlags are the flags that choose which lines are deduplicated
+type patternFlags struct {
+	skip          arrayFlags
+	skipFiles     arrayFlags
+	keep          arrayFlags
+	keepFiles     arrayFlags
+	rules         arrayFlags
+	ruleFiles     arrayFlags
+	passthrough   *bool
+	transformMode *string
+}
+
+// patterns are the compiled pattern flags
+type patterns struct {
+	skip          []*regexp.Regexp
+	keep          []*regexp.Regexp
+	rules         []dedup.Rule
+	passthrough   bool
+	transformMode dedup.TransformMode
+}
+
+// addPatternFlags registers the pattern flags on the flag set. The passthrough and transform mode
+// flags are only registered for subcommands with an output file.
+func addPatternFlags(fs *flag.FlagSet, withPassthrough bool) *patternFlags {
+	f := &patternFlags{}
+	fs.Var(&f.skip, "skip-pattern", "re2 regex pattern that will skip the line if it matches (flag can be used multiple times)")
+	fs.Var(&f.skipFiles, "skip-pattern-file",
+		"file of skip-pattern's, one per line, ignoring blank lines and lines starting with # (flag can be used multiple times)")
+	fs.Var(&f.keep, "keep-pattern",
+		"re2 regex pattern that a line must match to be deduplicated, otherwise it is skipped (flag can be used multiple times)")
+	fs.Var(&f.keepFiles, "keep-pattern-file",
+		"file of keep-pattern's, one per line, ignoring blank lines and lines starting with # (flag can be used multiple times)")
+	fs.Var(&f.rules, "rule", "rule evaluated in order before the patterns, the first match wins: action:pattern, where action is "+
+		"skip, keep, passthrough, or transform, and a transform ends with ' -> replacement' (flag can be used multiple times)")
+	fs.Var(&f.ruleFiles, "rule-file",
+		"file of rule's, one per line, ignoring blank lines and lines starting with # (flag can be used multiple times)")
+	if withPassthrough {
+		f.passthrough = fs.Bool("passthrough", false,
+			"write the lines not matching any keep-pattern to the output unchanged, after the deduplicated lines, instead of skipping them")
+		f.transformMode = fs.String("transform-mode", string(dedup.TransformLines),
+			"what the lines matching transform rules are deduplicated by and written as: lines (both transformed), "+
+				"keys (deduplicated transformed, written as first read), or output (deduplicated as read, written transformed)")
+	}
+	return f
+}
+
+// compile compiles the patterns given on the command line and in files
+func (f *patternFlags) compile() (patterns, error) {
+	var p patterns
+	var err error
+	if p.skip, err = compilePatterns(f.skip, f.skipFiles); err != nil {
+		return p, err
+	}
+	if p.keep, err = compilePatterns(f.keep, f.keepFiles); err != nil {
+		return p, err
+	}
+	if p.rules, err = parseRules(f.rules, f.ruleFiles); err != nil {
+		return p, err
+	}
+	if f.passthrough != nil && *f.passthrough {
+		if len(p.keep) == 0 {
+			return p, fmt.Errorf("passthrough flag requires a keep-pattern")
+		}
+		p.passthrough = true
+	}
+	if f.transformMode != nil {
+		p.transformMode = dedup.TransformMode(*f.transformMode)
+		switch p.transformMode {
+		case dedup.TransformLines:
+		case dedup.TransformKeys, dedup.TransformOutput:
+			if !hasTransformRule(p.rules) {
+				return p, fmt.Errorf("transform-mode flag %s requires a transform rule", p.transformMode)
+			}
+		default:
+			return p, fmt.Errorf("transform-mode flag must be one of lines, keys, or output: %q", p.transformMode)
+		}
+	}
+	return p, nil
+}
+
+// hasTransformRule returns true if any of the rules is a transform rule
+func hasTransformRule(rules []dedup.Rule) bool {
+	for _, rule := range rules {
+		if rule.Action == dedup.RuleTransform {
+			return true
+		}
+	}
+	return false
+}
+
+// compilePatterns compiles the re2 regex patterns, followed by those in the files
+func compilePatterns(patterns []string, paths []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re2, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re2)
+	}
+	filePatterns, err := loadPatternFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	return append(compiled, filePatterns...), nil
+}
+
+// ruleSeparator separates the pattern of a transform rule from its replacement
+const ruleSeparator = " -> "
+
+// parseRules parses the rules, followed by those in the files
+func parseRules(rules []string, paths []string) ([]dedup.Rule, error) {
+	var parsed []dedup.Rule
+	for _, rule := range rules {
+		r, err := parseRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, r)
+	}
+	for _, path := range paths {
+		lines, err := readPatternFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			r, err := parseRule(line.text)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line.number, err)
+			}
+			parsed = append(parsed, r)
+		}
+	}
+	return parsed, nil
+}
+
+// parseRule parses a rule in the form action:pattern, or transform:pattern -> replacement
+func parseRule(rule string) (dedup.Rule, error) {
+	parts := strings.SplitN(rule, ":", 2)
+	if len(parts) != 2 {
+		return dedup.Rule{}, fmt.Errorf("rule must be in the form action:pattern: %q", rule)
+	}
+
+	r := dedup.Rule{Action: dedup.RuleAction(parts[0])}
+	pattern := parts[1]
+	switch r.Action {
+	case dedup.RuleSkip, dedup.RuleKeep, dedup.RulePassthrough:
+	case dedup.RuleTransform:
+		i := strings.LastIndex(pattern, ruleSeparator)
+		if i < 0 {
+			return r, fmt.Errorf("transform rule must be in the form transform:pattern%sreplacement: %q", ruleSeparator, rule)
+		}
+		pattern, r.Replacement = pattern[:i], pattern[i+len(ruleSeparator):]
+	default:
+		return r, fmt.Errorf("rule action must be one of skip, keep, passthrough, or transform: %q", rule)
+	}
+
+	var err error
+	r.Pattern, err = regexp.Compile(pattern)
+	return r, err
+}
+
+// loadPatternFiles reads and compiles the re2 regex patterns in the files, one per line
+func loadPatternFiles(paths []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, path := range paths {
+		lines, err := readPatternFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			re2, err := regexp.Compile(line.text)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line.number, err)
+			}
+			compiled = append(compiled, re2)
+		}
+	}
+	return compiled, nil
+}
+
+// patternLine is a line of a pattern or rule file, with its line number for errors
+type patternLine struct {
+	number int
+	text   string
+}
+
+// readPatternFile returns the lines of a pattern or rule file. Blank lines, and lines starting
+// with # (after any indentation) are ignored, so a pattern starting with # must escape it as \#.
+func readPatternFile(path string) ([]patternLine, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lines []patternLine
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		lines = append(lines, patternLine{number: i + 1, text: line})
+	}
+	return lines, nil
+}
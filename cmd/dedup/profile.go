@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+)
+
+// profileFlags are the flags for profiling a subcommand while it runs
+type profileFlags struct {
+	addr       *string
+	cpuProfile *string
+	memProfile *string
+}
+
+// addProfileFlags registers the profiling flags on the flag set
+func addProfileFlags(fs *flag.FlagSet) profileFlags {
+	return profileFlags{
+		addr:       fs.String("pprof", "", "address to serve the net/http/pprof endpoints on while running, such as localhost:6060"),
+		cpuProfile: fs.String("cpu-profile", "", "file to write a cpu profile of the whole run to"),
+		memProfile: fs.String("mem-profile", "", "file to write a heap profile to once done"),
+	}
+}
+
+// start begins profiling as configured by the flags. The returned stop function writes out
+// the profiles and closes the listener, and must be called even if an error is returned.
+func (f profileFlags) start() (func() error, error) {
+	var stops []func() error
+	stop := func() error {
+		var firstErr error
+		for i := len(stops) - 1; i >= 0; i-- {
+			if err := stops[i](); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+
+	if *f.addr != "" {
+		ln, err := net.Listen("tcp", *f.addr)
+		if err != nil {
+			return stop, fmt.Errorf("unable to serve pprof: %w", err)
+		}
+		// Serve only the profiles, and not anything else registered on the default mux
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		go http.Serve(ln, mux)
+		console.Printf("Serving pprof on: http://%s/debug/pprof/", ln.Addr())
+		stops = append(stops, ln.Close)
+	}
+
+	if *f.cpuProfile != "" {
+		cpuFile, err := os.Create(*f.cpuProfile)
+		if err != nil {
+			return stop, err
+		}
+		if err = runtimepprof.StartCPUProfile(cpuFile); err != nil {
+			cpuFile.Close()
+			return stop, err
+		}
+		stops = append(stops, func() error {
+			runtimepprof.StopCPUProfile()
+			console.Printf("Wrote cpu profile: %s", cpuFile.Name())
+			return cpuFile.Close()
+		})
+	}
+
+	if *f.memProfile != "" {
+		memFile, err := os.Create(*f.memProfile)
+		if err != nil {
+			return stop, err
+		}
+		stops = append(stops, func() error {
+			defer memFile.Close()
+			runtime.GC() // Get up-to-date statistics
+			if err := runtimepprof.WriteHeapProfile(memFile); err != nil {
+				return err
+			}
+			console.Printf("Wrote heap profile: %s", memFile.Name())
+			return memFile.Close()
+		})
+	}
+	return stop, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/veqryn/dedup"
+)
+
+// barInterval is how often the progress bar is redrawn
+const barInterval = 500 * time.Millisecond
+
+// barWidth is the number of characters in the bar itself
+const barWidth = 30
+
+// isTerminal returns true if the file is a terminal (character device)
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressBar renders the progress of a run as a single line on a terminal, that is redrawn in place.
+// The counting phase runs at the same time as splitting, so it is shown as a suffix of the other phases.
+// All methods are safe to call on a nil progressBar, which does nothing.
+type progressBar struct {
+	w        io.Writer
+	current  dedup.Progress
+	counting dedup.Progress
+	drawn    bool
+}
+
+// update records the progress of a phase and redraws the bar
+func (b *progressBar) update(p dedup.Progress) {
+	if p.Phase == dedup.PhaseCounting {
+		b.counting = p
+	} else {
+		b.current = p
+	}
+	b.redraw()
+
+	// Leave finished phases on screen, and start the next phase on a new line
+	if p.Done && p.Phase != dedup.PhaseCounting {
+		fmt.Fprintln(b.w)
+		b.drawn = false
+		b.current = dedup.Progress{}
+	}
+}
+
+// clear removes the bar from the current line, so that something else can be written there
+func (b *progressBar) clear() {
+	if b == nil || !b.drawn {
+		return
+	}
+	fmt.Fprint(b.w, "\r\033[K")
+	b.drawn = false
+}
+
+// redraw clears the current line and writes the latest progress to it
+func (b *progressBar) redraw() {
+	if b == nil {
+		return
+	}
+	p := b.current
+	var suffix string
+	switch {
+	case p.Phase == "" && (b.counting.Phase == "" || b.counting.Done):
+		return
+	case p.Phase == "":
+		p = b.counting
+	case !b.counting.Done && b.counting.Phase != "":
+		suffix = fmt.Sprintf("  (counting: %s lines)", humanCount(float64(b.counting.Lines)))
+	}
+
+	bar := strings.Repeat("-", barWidth)
+	percent := "   ?%"
+	if pct, ok := p.Percent(); ok {
+		filled := int(pct / 100 * barWidth)
+		if filled > barWidth {
+			filled = barWidth
+		}
+		bar = strings.Repeat("#", filled) + strings.Repeat("-", barWidth-filled)
+		percent = fmt.Sprintf("%4.0f%%", pct)
+	}
+
+	fmt.Fprintf(b.w, "\r\033[K%-9s [%s] %s  %s%s", p.Phase, bar, percent, progressDetails(p), suffix)
+	b.drawn = true
+}
+
+// progressLine formats the progress as a log line, for when the output is not a terminal
+func progressLine(p dedup.Progress) string {
+	percent := ""
+	if pct, ok := p.Percent(); ok {
+		percent = fmt.Sprintf(" %.0f%%", pct)
+	}
+	return fmt.Sprintf("Progress: %s%s  %s", p.Phase, percent, progressDetails(p))
+}
+
+// progressDetails formats the counts, throughput, and ETA or duration of the progress
+func progressDetails(p dedup.Progress) string {
+	details := fmt.Sprintf("%s lines  %s lines/s  %s/s",
+		humanCount(float64(p.Lines)), humanCount(p.LinesPerSecond()), humanBytes(p.BytesPerSecond()))
+	if p.Done {
+		return details + "  done in " + p.Elapsed.Round(time.Second).String()
+	}
+	if eta, ok := p.ETA(); ok {
+		return details + "  ETA " + eta.Round(time.Second).String()
+	}
+	return details
+}
+
+// humanCount formats a count with a metric suffix, such as 1.5M
+func humanCount(n float64) string {
+	switch {
+	case n >= 1e12:
+		return fmt.Sprintf("%.1fT", n/1e12)
+	case n >= 1e9:
+		return fmt.Sprintf("%.1fG", n/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.1fM", n/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1fk", n/1e3)
+	default:
+		return fmt.Sprintf("%.0f", n)
+	}
+}
+
+// humanBytes formats a byte count with a unit, such as 1.5 MB
+func humanBytes(n float64) string {
+	switch {
+	case n >= 1e12:
+		return fmt.Sprintf("%.1f TB", n/1e12)
+	case n >= 1e9:
+		return fmt.Sprintf("%.1f GB", n/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.1f MB", n/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1f KB", n/1e3)
+	default:
+		return fmt.Sprintf("%.0f B", n)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/veqryn/dedup"
+)
+
+// progressEventFlags are the progress-events and progress-events-interval flags, which write the
+// progress of a run as JSON lines for a workflow engine to follow, apart from the log lines
+type progressEventFlags struct {
+	dest     *string
+	interval *time.Duration
+}
+
+// addProgressEventFlags registers the progress-events and progress-events-interval flags on the flag set
+func addProgressEventFlags(fs *flag.FlagSet) progressEventFlags {
+	return progressEventFlags{
+		dest: fs.String("progress-events", "", "where to write the progress of each phase as JSON lines, with its lines, bytes, "+
+			"totals, percent, ETA, and chunk counts: stderr, fd:N for an inherited file descriptor, or a file or named pipe to append to. "+
+			"opening a named pipe blocks until a reader opens it"),
+		interval: fs.Duration("progress-events-interval", 10*time.Second, "how often to write the progress events of a phase, "+
+			"which is always written once more as it finishes"),
+	}
+}
+
+// validate returns an error if the flags are invalid
+func (f progressEventFlags) validate() error {
+	if *f.interval <= 0 {
+		return fmt.Errorf("progress-events-interval flag must be positive")
+	}
+	if fd := strings.TrimPrefix(*f.dest, "fd:"); fd != *f.dest {
+		if n, err := strconv.Atoi(fd); err != nil || n < 0 {
+			return fmt.Errorf("progress-events flag must be stderr, fd:N for a file descriptor, or a file: %s", *f.dest)
+		}
+	}
+	return nil
+}
+
+// progressEvent is a single JSON line of the progress of a phase
+type progressEvent struct {
+	Time           string      `json:"time"`
+	Phase          dedup.Phase `json:"phase"`
+	Done           bool        `json:"done"`
+	Lines          uint64      `json:"lines"`
+	TotalLines     *uint64     `json:"total_lines,omitempty"`
+	Bytes          uint64      `json:"bytes"`
+	TotalBytes     *uint64     `json:"total_bytes,omitempty"`
+	Percent        *float64    `json:"percent,omitempty"`
+	LinesPerSecond float64     `json:"lines_per_second"`
+	BytesPerSecond float64     `json:"bytes_per_second"`
+	ElapsedSeconds float64     `json:"elapsed_seconds"`
+	ETASeconds     *float64    `json:"eta_seconds,omitempty"`
+	ChunksWritten  int         `json:"chunks_written"`
+	ChunksMerged   int         `json:"chunks_merged"`
+}
+
+// progressEvents writes the progress of a run as JSON lines. The library never calls its progress and
+// event callbacks concurrently, so it needs no lock.
+type progressEvents struct {
+	w      io.Writer
+	closer io.Closer
+	every  *phaseThrottle
+
+	chunksWritten int
+	chunksMerged  int
+}
+
+// open opens the destination of the progress events, if the progress-events flag was given, and sets
+// the options to write to it as well as calling their progress and event callbacks. It must be called
+// once the options have their callbacks. The returned events must be closed, and are nil without the flag.
+func (f progressEventFlags) open(opts *dedup.Options) (*progressEvents, error) {
+	if *f.dest == "" {
+		return nil, nil
+	}
+	e := &progressEvents{}
+	switch fd := strings.TrimPrefix(*f.dest, "fd:"); {
+	case *f.dest == "stderr":
+		e.w = os.Stderr
+	case fd != *f.dest:
+		n, _ := strconv.Atoi(fd)
+		file := os.NewFile(uintptr(n), *f.dest)
+		if _, err := file.Stat(); err != nil {
+			return nil, fmt.Errorf("progress-events flag: %w", err)
+		}
+		e.w, e.closer = file, file
+	default:
+		file, err := os.OpenFile(*f.dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		e.w, e.closer = file, file
+	}
+
+	// The progress is reported at the shorter of the intervals, and each is only written at its own
+	onProgress, onEvent := opts.OnProgress, opts.OnEvent
+	interval := opts.ProgressInterval
+	if interval == 0 {
+		interval = time.Minute
+	}
+	tick := interval
+	if *f.interval < tick {
+		tick = *f.interval
+	}
+	every := newPhaseThrottle(interval, tick)
+	e.every = newPhaseThrottle(*f.interval, tick)
+	opts.ProgressInterval = tick
+	opts.OnProgress = func(p dedup.Progress) {
+		if onProgress != nil && every.due(p) {
+			onProgress(p)
+		}
+		if e.every.due(p) {
+			e.progress(p)
+		}
+	}
+	opts.OnEvent = func(ev dedup.Event) {
+		if onEvent != nil {
+			onEvent(ev)
+		}
+		e.event(ev)
+	}
+	return e, nil
+}
+
+// phaseThrottle passes the progress of each phase on once an interval of it has passed since it was
+// last passed on, and once more as it finishes. The progress is reported every tick, which is at most
+// the interval, and half a tick early is near enough, so that a tick that is a little early is not missed.
+type phaseThrottle struct {
+	interval time.Duration
+	tick     time.Duration
+	last     map[dedup.Phase]time.Duration
+}
+
+// newPhaseThrottle returns a throttle of the interval, of progress reported every tick
+func newPhaseThrottle(interval, tick time.Duration) *phaseThrottle {
+	return &phaseThrottle{interval: interval, tick: tick, last: make(map[dedup.Phase]time.Duration)}
+}
+
+// due returns true if the progress is to be passed on
+func (t *phaseThrottle) due(p dedup.Progress) bool {
+	if !p.Done && p.Elapsed-t.last[p.Phase]+t.tick/2 < t.interval {
+		return false
+	}
+	t.last[p.Phase] = p.Elapsed
+	return true
+}
+
+// event counts the chunks written and merged
+func (e *progressEvents) event(ev dedup.Event) {
+	switch ev.Kind {
+	case dedup.EventChunkWritten:
+		e.chunksWritten++
+	case dedup.EventChunkMerged:
+		e.chunksMerged++
+	}
+}
+
+// progress writes the progress of a phase
+func (e *progressEvents) progress(p dedup.Progress) {
+	rec := progressEvent{
+		Time:           time.Now().Format(time.RFC3339Nano),
+		Phase:          p.Phase,
+		Done:           p.Done,
+		Lines:          p.Lines,
+		Bytes:          p.Bytes,
+		LinesPerSecond: p.LinesPerSecond(),
+		BytesPerSecond: p.BytesPerSecond(),
+		ElapsedSeconds: p.Elapsed.Seconds(),
+		ChunksWritten:  e.chunksWritten,
+		ChunksMerged:   e.chunksMerged,
+	}
+	if p.TotalLines > 0 {
+		rec.TotalLines = &p.TotalLines
+	}
+	if p.TotalBytes > 0 {
+		rec.TotalBytes = &p.TotalBytes
+	}
+	if pct, ok := p.Percent(); ok {
+		rec.Percent = &pct
+	}
+	if eta, ok := p.ETA(); ok {
+		etaSeconds := eta.Seconds()
+		rec.ETASeconds = &etaSeconds
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	// A reader that went away only loses the progress, rather than fail the run
+	e.w.Write(append(line, '\n'))
+}
+
+// close closes the destination of the progress events, unless it is stderr. It does nothing on nil events.
+func (e *progressEvents) close() error {
+	if e == nil || e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/veqryn/dedup"
+)
+
+func TestProgressEvents(t *testing.T) {
+	dir := t.TempDir()
+	eventsLoc := filepath.Join(dir, "events.jsonl")
+	args := []string{"--in=../../testdata/testdata.log", "--out=" + filepath.Join(dir, "out.log"), "--tmp-dir=" + dir,
+		"--tmp-file-bytes=1000", "--quiet", "--progress-events=" + eventsLoc, "--progress-events-interval=1ms"}
+	if err := runCommand("run", args); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(eventsLoc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	done := make(map[dedup.Phase]progressEvent)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event progressEvent
+		if err = json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Invalid progress event %q: %v", scanner.Text(), err)
+		}
+		if event.Done {
+			if _, ok := done[event.Phase]; ok {
+				t.Fatalf("Expected the %s phase to finish once: %s", event.Phase, scanner.Text())
+			}
+			done[event.Phase] = event
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// testdata.log has 204 lines, of which 100 are distinct, split into chunks of about 20 distinct lines
+	splitting, ok := done[dedup.PhaseSplitting]
+	if !ok || splitting.Lines != 204 || splitting.Percent == nil || *splitting.Percent != 100 || splitting.ChunksWritten == 0 {
+		t.Fatalf("Unexpected splitting progress: %+v", splitting)
+	}
+	merging, ok := done[dedup.PhaseMerging]
+	if !ok || merging.ChunksWritten < splitting.ChunksWritten || merging.ChunksMerged != merging.ChunksWritten {
+		t.Fatalf("Unexpected merging progress: %+v", merging)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/veqryn/dedup"
+)
+
+// rateLimitFlags are the max-read-mbps and max-write-mbps flags, which throttle the reads and writes of a run
+type rateLimitFlags struct {
+	read  *float64
+	write *float64
+}
+
+// addRateLimitFlags registers the max-read-mbps and max-write-mbps flags on the flag set
+func addRateLimitFlags(fs *flag.FlagSet) rateLimitFlags {
+	return rateLimitFlags{
+		read: fs.Float64("max-read-mbps", 0, "max megabytes per second to read the input and temporary files at, all together, "+
+			"so that a job on shared storage does not starve other traffic (default: no limit)"),
+		write: fs.Float64("max-write-mbps", 0, "max megabytes per second to write the output and temporary files at, all together (default: no limit)"),
+	}
+}
+
+// validate returns an error if the flags are invalid
+func (f rateLimitFlags) validate() error {
+	if *f.read < 0 {
+		return fmt.Errorf("max-read-mbps flag must not be negative")
+	}
+	if *f.write < 0 {
+		return fmt.Errorf("max-write-mbps flag must not be negative")
+	}
+	return nil
+}
+
+// apply sets the MaxReadBytesPerSecond and MaxWriteBytesPerSecond options of the flags
+func (f rateLimitFlags) apply(opts *dedup.Options) {
+	opts.MaxReadBytesPerSecond = uint64(*f.read * 1e6)
+	opts.MaxWriteBytesPerSecond = uint64(*f.write * 1e6)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/veqryn/dedup"
+)
+
+// referenceFlags are the reference, update-reference, and append-new flags, which only write the lines not
+// already in an earlier output
+type referenceFlags struct {
+	path      *string
+	update    *bool
+	appendNew *bool
+}
+
+// addReferenceFlags registers the reference and update-reference flags on the flag set
+func addReferenceFlags(fs *flag.FlagSet) referenceFlags {
+	return referenceFlags{
+		path: fs.String("reference", "", "sorted and deduplicated file, such as the output of an earlier run, whose lines are skipped, "+
+			"so that only the unique lines not already in it are written"),
+		update: fs.Bool("update-reference", false, "once the run succeeds, merge the unique lines written to the out file into the reference "+
+			"file, which is replaced, so that it stays sorted and has every line written so far"),
+		appendNew: fs.Bool("append-new", false, "append to the out file only the unique lines not already in it, which is read as a "+
+			"reference first, sorting a copy of it if it is not sorted"),
+	}
+}
+
+// validate returns an error if the flags are invalid. The output must be a file of sorted lines, to
+// update the reference with, and a file of the lines as they are deduplicated, to append new lines to.
+func (f referenceFlags) validate(outFileLoc string, sorted, lines bool) error {
+	if *f.appendNew {
+		if *f.path != "" {
+			return fmt.Errorf("append-new flag can not be used with the reference flag")
+		}
+		if outFileLoc == "" || outFileLoc == stdio || !lines {
+			return fmt.Errorf("append-new flag requires the out flag to be a file of the lines as they are deduplicated, " +
+				"in the text format, without output-shards")
+		}
+	}
+	if !*f.update {
+		return nil
+	}
+	if *f.path == "" {
+		return fmt.Errorf("update-reference flag requires the reference flag")
+	}
+	if outFileLoc == "" || outFileLoc == stdio || !sorted {
+		return fmt.Errorf("update-reference flag requires the out flag to be a file of sorted lines, in the text format, " +
+			"without output-shards or partitions by hash")
+	}
+	return nil
+}
+
+// open opens the reference file, or the out file to append new lines to, unless there is neither, and
+// sets the options to skip its lines. The returned function closes it, and removes any sorted copy of it.
+func (f referenceFlags) open(outFileLoc string, opts *dedup.Options) (func(), error) {
+	path := *f.path
+	if *f.appendNew {
+		path = outFileLoc
+	}
+	if path == "" {
+		return func() {}, nil
+	}
+	file, err := os.Open(path)
+	if *f.appendNew && (os.IsNotExist(err) || err == nil && isEmpty(file)) {
+		// Nothing has been written to the out file yet
+		if file != nil {
+			file.Close()
+		}
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	closeReference := func() { file.Close() }
+	if *f.appendNew && !isSorted(file, opts.Delimiter) {
+		var sortedFile *os.File
+		if sortedFile, err = sortCopy(file, *opts); err != nil {
+			file.Close()
+			return nil, err
+		}
+		file.Close()
+		file, path = sortedFile, ""
+		closeReference = func() {
+			sortedFile.Close()
+			os.Remove(sortedFile.Name())
+		}
+	}
+	if path == "" {
+		opts.Reference, err = dedup.NewSortedFile(file, dedup.Options{Delimiter: opts.Delimiter})
+	} else {
+		opts.Reference, err = openSortedFile(file, path, dedup.Options{Delimiter: opts.Delimiter})
+	}
+	if err != nil {
+		closeReference()
+		return nil, err
+	}
+	return closeReference, nil
+}
+
+// endLastLine ends the last line of the out file that new lines are appended to with the delimiter, unless
+// it is empty or already ends with it, such as once written with the no-final-line-ending flag, so that the
+// first line appended is not joined to it
+func (f referenceFlags) endLastLine(outFile *os.File, delim string) error {
+	if !*f.appendNew {
+		return nil
+	}
+	info, err := outFile.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	end := make([]byte, len(delim))
+	if info.Size() >= int64(len(end)) {
+		if _, err = outFile.ReadAt(end, info.Size()-int64(len(end))); err != nil {
+			return err
+		}
+		if string(end) == delim {
+			return nil
+		}
+	}
+	_, err = outFile.WriteString(delim)
+	return err
+}
+
+// isEmpty returns true if the file has no content
+func isEmpty(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Size() == 0
+}
+
+// isSorted returns true if the lines of the file are sorted and unique, as merging it checks, and rewinds it
+func isSorted(f *os.File, delimiter string) bool {
+	opts := dedup.Options{Delimiter: delimiter, DryRun: true, OnProgress: func(dedup.Progress) {}, OnEvent: func(dedup.Event) {}}
+	_, err := dedup.Merge(nil, opts, f)
+	_, seekErr := f.Seek(0, io.SeekStart)
+	return err == nil && seekErr == nil
+}
+
+// sortCopy deduplicates the lines of the file into a sorted temporary file, in the temp dir of the options,
+// and returns it
+func sortCopy(f *os.File, opts dedup.Options) (*os.File, error) {
+	console.Printf("Sorting a copy of the out file to append new lines to: %s", f.Name())
+	sorted, err := dedup.CreateTemp(opts.TempDir, "append.*.log")
+	if err != nil {
+		return nil, err
+	}
+	sortOpts := dedup.Options{
+		Delimiter:    opts.Delimiter,
+		TmpFileBytes: opts.TmpFileBytes,
+		MemoryBytes:  opts.MemoryBytes,
+		TempDir:      opts.TempDir,
+		OnProgress:   func(dedup.Progress) {},
+		OnEvent:      func(dedup.Event) {},
+	}
+	if _, err = dedup.Run(sorted, sortOpts, f, nil); err != nil {
+		sorted.Close()
+		os.Remove(sorted.Name())
+		return nil, err
+	}
+	return sorted, nil
+}
+
+// merge replaces the reference file with the merge of it and the output file, through a temporary file
+// next to it that is renamed over it once complete
+func (f referenceFlags) merge(outFileLoc string, opts dedup.Options) error {
+	tmp, err := createInPlaceFile(*f.path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	inFiles, closeInFiles, err := openFiles([]string{*f.path, outFileLoc})
+	defer closeInFiles()
+	if err != nil {
+		return err
+	}
+	mergeOpts := dedup.Options{
+		Delimiter:  opts.Delimiter,
+		CRLF:       opts.CRLF,
+		OnProgress: func(dedup.Progress) {},
+		OnEvent:    func(dedup.Event) {},
+	}
+	stats, err := dedup.Merge(tmp, mergeOpts, inFiles...)
+	if err != nil {
+		return err
+	}
+	closeInFiles()
+	if err = replaceInPlace(tmp, *f.path); err != nil {
+		return err
+	}
+	console.Printf("Updated reference file with %d lines: %s", stats.LinesUnique, *f.path)
+	return nil
+}
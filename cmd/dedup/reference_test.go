@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAppendNew(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		out      string
+		args     []string
+		expected string
+	}{
+		{name: "ended", out: "a\nb\n", expected: "a\nb\nc\n"},
+		// An out file written with the no-final-line-ending flag has its last line ended before appending
+		{name: "unended", out: "a\nb", expected: "a\nb\nc\n"},
+		{name: "unended delimiter", out: "a|b", args: []string{"--delimiter=|"}, expected: "a|b|c|"},
+		{name: "empty", out: "", expected: "a\nc\n"},
+	} {
+		dir := t.TempDir()
+		inFileLoc := filepath.Join(dir, "in.log")
+		outFileLoc := filepath.Join(dir, "out.log")
+		in := "c\na\nc\n"
+		if len(tc.args) > 0 {
+			in = "c|a|c|"
+		}
+		if err := os.WriteFile(inFileLoc, []byte(in), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(outFileLoc, []byte(tc.out), 0644); err != nil {
+			t.Fatal(err)
+		}
+		args := append([]string{"--in=" + inFileLoc, "--out=" + outFileLoc, "--append-new", "--quiet"}, tc.args...)
+		if err := runCommand("run", args); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(outFileLoc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tc.expected {
+			t.Fatalf("Expected %s out file to be %q; Got: %q", tc.name, tc.expected, b)
+		}
+	}
+}
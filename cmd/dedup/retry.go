@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/veqryn/dedup"
+)
+
+// retryFlags are the io-retries and io-retry-backoff flags, which retry transient errors reading
+// and writing files
+type retryFlags struct {
+	retries *int
+	backoff *time.Duration
+}
+
+// addRetryFlags registers the io-retries and io-retry-backoff flags on the flag set
+func addRetryFlags(fs *flag.FlagSet) retryFlags {
+	return retryFlags{
+		retries: fs.Int("io-retries", 0, "times to try reading the input or temporary files, or writing the temporary files, again "+
+			"after a transient error, such as EIO or ESTALE on NFS, before failing (default: no retries)"),
+		backoff: fs.Duration("io-retry-backoff", 100*time.Millisecond, "how long to wait before the first retry, doubled for each after it"),
+	}
+}
+
+// validate returns an error if the flags are invalid
+func (f retryFlags) validate() error {
+	if *f.retries < 0 {
+		return fmt.Errorf("io-retries flag must not be negative")
+	}
+	if *f.backoff <= 0 {
+		return fmt.Errorf("io-retry-backoff flag must be positive")
+	}
+	return nil
+}
+
+// apply sets the IORetries and IORetryBackoff options of the flags
+func (f retryFlags) apply(opts *dedup.Options) {
+	opts.IORetries, opts.IORetryBackoff = *f.retries, *f.backoff
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rotatePath returns the path of the nth file of a rotated output, numbered before its extension,
+// such as deduped.0000.log and deduped.0001.log for deduped.log
+func rotatePath(outFileLoc string, n int) string {
+	ext := filepath.Ext(outFileLoc)
+	return fmt.Sprintf("%s.%04d%s", strings.TrimSuffix(outFileLoc, ext), n, ext)
+}
+
+// rotatingOutput splits the output of a run into numbered files of at most maxBytes each. The run
+// writes to a pipe, as it would to stdout, and the lines read from it are written to the current file,
+// until the next line would take it over the limit, so that every file ends on a whole line, and is
+// sorted if the output is. A line longer than the limit is written to a file of its own.
+type rotatingOutput struct {
+	outFileLoc string
+	maxBytes   uint64
+	delim      []byte
+	onCreate   func(path string)
+
+	pipe  *os.File
+	done  chan error
+	paths []string
+	file  *os.File
+	w     *bufio.Writer
+	size  uint64
+}
+
+// startRotating returns the rotating output of the out file, whose pipe is the output file to give the
+// run. The first file is created right away, so that there is one even for an empty output, and each
+// file is passed to onCreate once created. The longest line read from the pipe can be maxLine bytes,
+// not counting its delimiter.
+func startRotating(outFileLoc string, maxBytes uint64, delim string, maxLine, bufSize int, onCreate func(path string)) (*rotatingOutput, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	ro := &rotatingOutput{
+		outFileLoc: outFileLoc,
+		maxBytes:   maxBytes,
+		delim:      []byte(delim),
+		onCreate:   onCreate,
+		pipe:       w,
+		done:       make(chan error, 1),
+	}
+	if err = ro.rotate(bufSize); err != nil {
+		r.Close()
+		w.Close()
+		return nil, err
+	}
+	go func() {
+		err := ro.copy(r, maxLine, bufSize)
+		if closeErr := ro.closeFile(); err == nil {
+			err = closeErr
+		}
+		// Closing the read end fails the writes of the run, rather than leave it blocked, if this failed
+		r.Close()
+		ro.done <- err
+	}()
+	return ro, nil
+}
+
+// copy writes the lines read from the pipe to the numbered files
+func (ro *rotatingOutput) copy(r *os.File, maxLine, bufSize int) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLine+len(ro.delim))
+	scanner.Split(ro.splitLines)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if ro.size > 0 && ro.size+uint64(len(line)) > ro.maxBytes {
+			if err := ro.rotate(bufSize); err != nil {
+				return err
+			}
+		}
+		if _, err := ro.w.Write(line); err != nil {
+			return err
+		}
+		ro.size += uint64(len(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("splitting the output into files of out-max-bytes: %w", err)
+	}
+	return nil
+}
+
+// splitLines splits the output into lines that keep their delimiter
+func (ro *rotatingOutput) splitLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.Index(data, ro.delim); i >= 0 {
+		return i + len(ro.delim), data[:i+len(ro.delim)], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// rotate closes the current file, and creates the next one
+func (ro *rotatingOutput) rotate(bufSize int) error {
+	if err := ro.closeFile(); err != nil {
+		return err
+	}
+	path := rotatePath(ro.outFileLoc, len(ro.paths))
+	f, err := createOutFile(path, false)
+	if err != nil {
+		return err
+	}
+	ro.onCreate(path)
+	ro.paths = append(ro.paths, path)
+	ro.file, ro.w, ro.size = f, bufio.NewWriterSize(f, bufSize), 0
+	return nil
+}
+
+// closeFile flushes and closes the current file, if there is one
+func (ro *rotatingOutput) closeFile() error {
+	if ro.file == nil {
+		return nil
+	}
+	err := ro.w.Flush()
+	if closeErr := ro.file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("closing output file %s: %w", ro.file.Name(), closeErr)
+	}
+	ro.file, ro.w = nil, nil
+	return err
+}
+
+// finish closes the pipe once the run is done writing to it, and returns the paths of the files
+// written, and any error writing them, which takes precedence over the error of the run
+func (ro *rotatingOutput) finish(runErr error) ([]string, error) {
+	ro.pipe.Close()
+	if err := <-ro.done; err != nil {
+		return ro.paths, err
+	}
+	return ro.paths, runErr
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRotatePath(t *testing.T) {
+	for loc, expected := range map[string]string{
+		"deduped.log":     "deduped.0012.log",
+		"dir/deduped":     "dir/deduped.0012",
+		"dir.d/out.x.txt": "dir.d/out.x.0012.txt",
+	} {
+		if path := rotatePath(loc, 12); path != expected {
+			t.Fatalf("Expected %s to be numbered %s; Got: %s", loc, expected, path)
+		}
+	}
+}
+
+func TestRotatingOutput(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		maxBytes uint64
+		input    string
+		files    []string
+	}{
+		// Each file is filled up to exactly the limit, then numbered on across several files
+		{name: "exact fit", maxBytes: 6, input: "aa\nbb\ncc\ndd\nee\n", files: []string{"aa\nbb\n", "cc\ndd\n", "ee\n"}},
+		// A file is not taken one byte over the limit, even by the last line
+		{name: "one over", maxBytes: 5, input: "aa\nbb\ncc\n", files: []string{"aa\n", "bb\n", "cc\n"}},
+		// A line longer than the limit is written to a file of its own, between the lines around it
+		{name: "long line", maxBytes: 4, input: "a\nb\nlongline\nc\n", files: []string{"a\nb\n", "longline\n", "c\n"}},
+		{name: "long first line", maxBytes: 4, input: "longline\nc", files: []string{"longline\n", "c"}},
+		// There is always a first file, even for an empty output
+		{name: "empty", maxBytes: 4, input: "", files: []string{""}},
+	} {
+		dir := t.TempDir()
+		outFileLoc := filepath.Join(dir, "deduped.log")
+		var created []string
+		ro, err := startRotating(outFileLoc, tc.maxBytes, "\n", 64, 16, func(path string) {
+			created = append(created, path)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ro.pipe.WriteString(tc.input); err != nil {
+			t.Fatal(err)
+		}
+		paths, err := ro.finish(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var expected []string
+		for n := range tc.files {
+			expected = append(expected, rotatePath(outFileLoc, n))
+		}
+		if !reflect.DeepEqual(paths, expected) || !reflect.DeepEqual(created, expected) {
+			t.Fatalf("Expected %s to write files %q; Got: %q, created %q", tc.name, expected, paths, created)
+		}
+		for n, path := range paths {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tc.files[n] {
+				t.Fatalf("Expected %s file %d to hold %q; Got: %q", tc.name, n, tc.files[n], b)
+			}
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != len(tc.files) {
+			t.Fatalf("Expected %s to write only %d files; Got: %d", tc.name, len(tc.files), len(entries))
+		}
+	}
+}
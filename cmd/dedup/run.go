@@ -0,0 +1,752 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/veqryn/dedup"
+)
+
+// runCommand deduplicates the input files into a sorted output file
+func runCommand(name string, args []string) (err error) {
+	// Flags
+	fs := newFlagSet(name)
+	var inFileGlobs arrayFlags
+	fs.Var(&inFileGlobs, "in", "input file location or glob, or - for stdin (flag can be used multiple times)")
+	inLists := addInListFlag(fs)
+	patternFlags := addPatternFlags(fs, true)
+	keyFlags := addKeyFlags(fs)
+	skipLines := fs.Uint64("skip-lines", 0, "number of lines at the start of the input to ignore")
+	maxLines := fs.Uint64("max-lines", 0, "maximum number of input lines to process after skip-lines (default: no maximum)")
+	outFileLoc := fs.String("out", "", "output file location, or - for stdout")
+	atomic := addAtomicFlag(fs)
+	inPlace := fs.Bool("in-place", false, "deduplicate the single input file, and atomically replace it with the result")
+	update := fs.Bool("update", false, "merge the unique lines of the input into the sorted out file, such as the output of an "+
+		"earlier run, and atomically replace it with the union of both, without deduplicating its lines again")
+	skipUnchanged := fs.Bool("skip-unchanged", false, "do nothing if the out file was written by an earlier run given the same output flags and "+
+		"input files of the same checksums, as kept in a state file next to it, and otherwise replace the out file the earlier run wrote")
+	tmpDir := fs.String("tmp-dir", "", "directory to write temporary files to (default: the os temp dir)")
+	keepTmp := fs.Bool("keep-tmp", false, "keep the temporary files once done, even if the run failed, and print their paths")
+	checkpointFlags := addCheckpointFlags(fs)
+	encryptTmp := fs.Bool("encrypt-tmp", false, "encrypt the temporary files with AES-256-GCM and a random key only held in memory, "+
+		"for a temp dir less trusted than the input and output. can not be used with keep-tmp or checkpoint")
+	checksumTmp := fs.Bool("checksum-tmp", false, "checksum each temporary file as it is written, and verify it once read back while merging, "+
+		"so that a corrupt temporary file fails the run rather than the output")
+	verifyWrites := fs.Bool("verify-writes", false, "read back each temporary file once written, and the output once the run is done, "+
+		"failing unless each has the lines and checksum written to it, sorted and unique, for when a bad output is worse than a slow run")
+	maxTmpBytes := fs.Uint64("max-tmp-bytes", 0, "most bytes of temporary files to write in total, failing with what to do about it "+
+		"rather than fill the temp dir (default: no limit)")
+	minFreeBytes := fs.Uint64("min-free-bytes", 0, "bytes to leave free on the filesystems of the temporary files and the output. "+
+		"fail before starting if either has no room for the size of the input plus this, and while running once either has less than this free, "+
+		"rather than once it is full (default: no check)")
+	memoryFlags := addMemoryFlags(fs, "temporary file")
+	buildWorkers := fs.Int("build-workers", 1, "number of goroutines adding the lines read to sets, each for a hash partition of the lines with an equal share of tmp-file-bytes")
+	sortWorkers := addSortWorkersFlag(fs)
+	workers := addWorkersFlag(fs)
+	limit := fs.Uint64("limit", 0, "stop after writing this many unique lines, which are the first of the sorted output (default: no limit)")
+	outputShards := fs.Int("output-shards", 0, "write the output into this many files, numbered before the extension of the out flag, each sorted (default: a single file)")
+	outMaxBytes := fs.Uint64("out-max-bytes", 0, "split the output into files of at most this many bytes, numbered before the extension "+
+		"of the out flag, such as deduped.0000.log, each ending on a whole line, and sorted if the output is. "+
+		"a line longer than this is written to a file of its own (default: a single file)")
+	partitions := fs.Int("partitions", 0, "split the temporary files into this many partitions, merged in parallel and concatenated. "+
+		"with output-shards, each shard is merged in parallel (default: no partitions)")
+	partitionBy := fs.String("partition-by", string(dedup.ShardHash), "how lines are split between partitions: hash, so the output is only "+
+		"sorted within each partition, or range for contiguous sorted ranges sampled from the first temporary file. ignored with output-shards")
+	shardBy := fs.String("shard-by", string(dedup.ShardHash), "how lines are partitioned between output shards: hash, or range for contiguous sorted ranges")
+	formatFlags := addFormatFlags(fs, "its count and where it was first read")
+	appendFlag := fs.Bool("append", false, "should append to file (default: only allow new files)")
+	progressBarFlag := fs.Bool("progress-bar", false, "show a progress bar with throughput and ETA (falls back to periodic lines when not a terminal)")
+	progressInterval := fs.Duration("progress-interval", time.Minute, "how often to print progress lines, when not showing a progress bar")
+	countLinesFlag := fs.Bool("count-lines", false, "read the input files a second time, at the same time, through the files already open, to count their lines, "+
+		"so that progress is tracked in lines rather than in bytes of the size of the files")
+	failIfDuplicates := fs.Bool("fail-if-duplicates", false, fmt.Sprintf(
+		"exit with code %d if the input contains any duplicates. without the out flag, nothing is written "+
+			"and the run stops as soon as enough duplicates are found", exitDuplicates))
+	expectFlags := addExpectFlags(fs)
+	showDuplicates := fs.Int("show-duplicates", 0, "with fail-if-duplicates, print up to this many of the duplicate lines to stdout")
+	dupReportFlags := addDupReportFlags(fs)
+	sketchFlags := addSketchFlags(fs)
+	lineMapLoc := fs.String("line-map", "", "file to write the output line number of every input line to, or removed, as tab separated input file, input line number, and output line number")
+	seenIndexLoc := fs.String("seen-index", "", "directory of an index of every unique line written by the runs given it, whose lines are "+
+		"skipped, and to which the unique lines written are added once the run succeeds, so that each line is only ever written once")
+	referenceFlags := addReferenceFlags(fs)
+	indexFlags := addIndexFlags(fs)
+	auditLogLoc := fs.String("audit-log", "", "file to write every removed duplicate to as a JSON line, with its input file and line number, and those of the line it duplicates that was retained")
+	removePartial := addRemovePartialFlag(fs)
+	dryRun := fs.Bool("dry-run", false, "perform the full deduplication, but only report what would be written to the output file")
+	countOnly := fs.Bool("count-only", false, "only count the unique and duplicate lines, without an output file, "+
+		"skipping the formatting and writing of the unique lines while merging")
+	readBufferBytes := fs.Int("read-buffer-bytes", 256*1024, "byte size of the buffer for reading input files, which is also the maximum line length")
+	readAhead := addReadAheadFlag(fs)
+	fadviseFlag := addFadviseFlag(fs)
+	readWorkers := fs.Int("read-workers", 1, "number of input files read at once, each on its own goroutine, "+
+		"interleaving their lines. can not be used with skip-lines, max-lines, or tracking where lines were read")
+	writeBufferBytes := fs.Int("write-buffer-bytes", 256*1024, "byte size of the buffer for writing the output and temporary files")
+	mergeBufferBytes := fs.Int("merge-buffer-bytes", 4*1024, "initial byte size of the buffer for reading each file while merging")
+	mergeMmap := fs.Bool("merge-mmap", false, "memory map each file while merging instead of reading it through a buffer, where supported")
+	preallocateOutput := fs.Bool("preallocate-output", false, "allocate the space the output is estimated to need before merging, on linux, "+
+		"giving back what is not needed once written")
+	outputMmap := fs.Bool("output-mmap", false, "preallocate the output, and write it through a memory mapping instead of a buffer, where supported")
+	mergeFanIn := fs.Int("merge-fan-in", 0, "most temporary files to open and merge at once, merging in more passes if there are more "+
+		"(default: merge every temporary file at once, or as many as fit in max-open-files)")
+	maxOpenFiles := fs.Int("max-open-files", 0, "most files to have open at once, including the inputs and outputs, merging the temporary "+
+		"files in more passes if they do not all fit, or -1 for no budget (default: the open file limit, ulimit -n)")
+	delimiter := addDelimiterFlag(fs)
+	crlf := fs.Bool("crlf", false, "end each output line with \\r\\n, as is usual on windows, still accepting input lines ending in either (ignored with a delimiter)")
+	noFinalLineEnding := fs.Bool("no-final-line-ending", false, "leave the line ending off the last line of the output, only with the text format")
+	rateLimitFlags := addRateLimitFlags(fs)
+	syncFlags := addSyncFlags(fs)
+	retryFlags := addRetryFlags(fs)
+	timeoutFlags := addTimeoutFlags(fs, true)
+	statsFlags := addStatsFlags(fs)
+	profileFlags := addProfileFlags(fs)
+	metricsAddr := addMetricsFlag(fs)
+	notifyFlags := addNotifyFlags(fs)
+	progressEventFlags := addProgressEventFlags(fs)
+	logFlags := addLogFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := logFlags.apply(); err != nil {
+		return err
+	}
+	if err := notifyFlags.validate(); err != nil {
+		return err
+	}
+
+	// Notify of the outcome of the run once it is done, however it ends
+	var stats dedup.Stats
+	defer func() {
+		notifyFlags.send(name, *outFileLoc, stats, err)
+	}()
+	stopProfiling, err := profileFlags.start()
+	defer func() {
+		if stopErr := stopProfiling(); stopErr != nil {
+			console.Warnf("Error writing profiles: %v", stopErr)
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	metrics := &dedup.Metrics{}
+	stopMetrics, err := serveMetrics(*metricsAddr, metrics)
+	defer stopMetrics()
+	if err != nil {
+		return err
+	}
+	if err := statsFlags.validate(); err != nil {
+		return err
+	}
+	if err := dupReportFlags.validate(); err != nil {
+		return err
+	}
+
+	if *readBufferBytes <= 0 || *writeBufferBytes <= 0 || *mergeBufferBytes <= 0 {
+		return fmt.Errorf("buffer byte flags must be positive integers or omitted for the defaults")
+	}
+	if err := memoryFlags.validate(); err != nil {
+		return err
+	}
+	if err := rateLimitFlags.validate(); err != nil {
+		return err
+	}
+	if err := expectFlags.validate(); err != nil {
+		return err
+	}
+	if err := retryFlags.validate(); err != nil {
+		return err
+	}
+	if err := progressEventFlags.validate(); err != nil {
+		return err
+	}
+	if err := timeoutFlags.validate(); err != nil {
+		return err
+	}
+	if *timeoutFlags.flush && *inPlace {
+		return fmt.Errorf("flush-on-timeout flag can not be used with in-place, which would replace the input with an incomplete output")
+	}
+	if err := checkpointFlags.validate(); err != nil {
+		return err
+	}
+	if *mergeFanIn < 0 || *mergeFanIn == 1 {
+		return fmt.Errorf("merge-fan-in flag must be an integer of at least 2 or omitted for the default")
+	}
+	if *buildWorkers <= 0 {
+		return fmt.Errorf("build-workers flag must be a positive integer or omitted for the default")
+	}
+	if err := sortWorkers.validate(); err != nil {
+		return err
+	}
+	if *workers <= 0 {
+		return fmt.Errorf("workers flag must be a positive integer or omitted for the default")
+	}
+	if *readAhead < 0 {
+		return fmt.Errorf("read-ahead flag must be zero or a positive integer")
+	}
+	if *readWorkers <= 0 {
+		return fmt.Errorf("read-workers flag must be a positive integer or omitted for the default")
+	}
+
+	// Compile regexp's
+	patterns, err := patternFlags.compile()
+	if err != nil {
+		return err
+	}
+	if err := keyFlags.validate(); err != nil {
+		return err
+	}
+
+	// Find input files
+	inFileLocs, err := withInLists(inFileGlobs, *inLists)
+	if err != nil {
+		return err
+	}
+	paths, err := expandInputs(inFileLocs)
+	if err != nil {
+		return err
+	}
+
+	if *partitions < 0 {
+		return fmt.Errorf("partitions flag must be a positive integer or omitted for no partitions")
+	}
+	if *outputShards < 0 {
+		return fmt.Errorf("output-shards flag must be a positive integer or omitted for a single file")
+	}
+	if *shardBy != string(dedup.ShardHash) && *shardBy != string(dedup.ShardRange) {
+		return fmt.Errorf("shard-by flag must be one of: %s, %s", dedup.ShardHash, dedup.ShardRange)
+	}
+	if *partitionBy != string(dedup.ShardHash) && *partitionBy != string(dedup.ShardRange) {
+		return fmt.Errorf("partition-by flag must be one of: %s, %s", dedup.ShardHash, dedup.ShardRange)
+	}
+	if err := formatFlags.validate(); err != nil {
+		return err
+	}
+	if *referenceFlags.appendNew {
+		*appendFlag = true
+	}
+	if formatFlags.binary() && (*appendFlag || *partitions > 1) {
+		return fmt.Errorf("append and partitions flags can not be used with the parquet, arrow, or arrow-stream formats")
+	}
+	if *noFinalLineEnding && (*appendFlag || *outputMmap || formatFlags.records()) {
+		return fmt.Errorf("no-final-line-ending flag can only be used with the text format, and not with the append or output-mmap flags")
+	}
+	sorted := !formatFlags.records() && *outputShards == 0 && (*partitions <= 1 || *partitionBy == string(dedup.ShardRange)) &&
+		!keyFlags.keyed() && (patterns.transformMode == dedup.TransformLines || !hasTransformRule(patterns.rules) || *keyFlags.writeKeys)
+	lines := !formatFlags.records() && *outputShards == 0 && !keyFlags.keyed() &&
+		(patterns.transformMode == dedup.TransformLines || !hasTransformRule(patterns.rules) || *keyFlags.writeKeys)
+	if err := referenceFlags.validate(*outFileLoc, sorted, lines); err != nil {
+		return err
+	}
+	if err := indexFlags.validate(*outFileLoc, sorted && *partitions <= 1 && !*appendFlag); err != nil {
+		return err
+	}
+	var shardFileLocs []string
+	if *outputShards > 0 {
+		if *inPlace || *outFileLoc == "" {
+			return fmt.Errorf("output-shards flag requires the out flag, and can not be used with the in-place flag")
+		}
+		if formatFlags.records() || *lineMapLoc != "" {
+			return fmt.Errorf("output-shards flag can only be used with the text format, and not with the line-map flag")
+		}
+		shardFileLocs = shardPaths(*outFileLoc, *outputShards)
+	}
+
+	if *outMaxBytes > 0 {
+		if *outFileLoc == "" || *outFileLoc == stdio || formatFlags.records() || *lineMapLoc != "" {
+			return fmt.Errorf("out-max-bytes flag requires the out flag to be a file, in the text format, and can not be used with the line-map flag")
+		}
+		for _, c := range []stdioConflict{
+			{"in-place", *inPlace}, {"update", *update}, {"atomic", *atomic}, {"append", *appendFlag}, {"output-shards", *outputShards > 0},
+			{"update-reference", *referenceFlags.update}, {"index", *indexFlags.write}, {"preallocate-output", *preallocateOutput},
+			{"output-mmap", *outputMmap}, {"verify-writes", *verifyWrites}, {"fsync", *syncFlags.sync},
+			{"fsync-bytes", *syncFlags.bytes > 0}, {"durable", *syncFlags.durable},
+		} {
+			if c.set {
+				return fmt.Errorf("%s flag can not be used with the out-max-bytes flag", c.flag)
+			}
+		}
+	}
+
+	outFileLocs := shardFileLocs
+	switch {
+	case *outMaxBytes > 0:
+		outFileLocs = []string{rotatePath(*outFileLoc, 0)}
+	case *outFileLoc != "" && len(shardFileLocs) == 0:
+		outFileLocs = []string{*outFileLoc}
+	}
+	if *referenceFlags.path != "" {
+		if err = checkOverlap([]string{*referenceFlags.path}, outFileLocs); err != nil {
+			return fmt.Errorf("reference file %s can not be the output file, use the update-reference flag to merge the lines written into it", *referenceFlags.path)
+		}
+	}
+	if err = checkOverlap(paths, outFileLocs); err != nil {
+		if len(paths) == 1 {
+			return fmt.Errorf("%w, use the in-place flag to replace it with its result", err)
+		}
+		return err
+	}
+
+	if err = checkStdio(paths, *outFileLoc, []stdioConflict{
+		{"in-place", *inPlace}, {"count-lines", *countLinesFlag}, {"checkpoint", *checkpointFlags.path != ""},
+		{"skip-unchanged", *skipUnchanged},
+	}, []stdioConflict{
+		{"atomic", *atomic}, {"append", *appendFlag}, {"output-shards", *outputShards > 0}, {"remove-partial-output", *removePartial},
+		{"preallocate-output", *preallocateOutput}, {"output-mmap", *outputMmap}, {"verify-writes", *verifyWrites},
+		{"fsync", *syncFlags.sync}, {"fsync-bytes", *syncFlags.bytes > 0}, {"durable", *syncFlags.durable}, {"stats", *statsFlags.show},
+	}); err != nil {
+		return err
+	}
+	if *atomic && (*appendFlag || *inPlace) {
+		return fmt.Errorf("atomic flag can not be used with the append or in-place flags")
+	}
+	if *inPlace {
+		if *outFileLoc != "" || *appendFlag {
+			return fmt.Errorf("in-place flag can not be used with the out or append flags")
+		}
+		if len(paths) != 1 {
+			return fmt.Errorf("in-place flag requires exactly one input file, found %d", len(paths))
+		}
+	}
+	if *update {
+		if *outFileLoc == "" || *outFileLoc == stdio || !sorted || *partitions > 1 {
+			return fmt.Errorf("update flag requires the out flag to be a file of sorted lines, in the text format, " +
+				"without output-shards or partitions")
+		}
+		if *appendFlag || *atomic || *inPlace {
+			return fmt.Errorf("update flag can not be used with the append, append-new, atomic, or in-place flags, as it is always atomic")
+		}
+	}
+
+	if *skipUnchanged {
+		if *outFileLoc == "" || *outFileLoc == stdio || *outputShards > 0 || *outMaxBytes > 0 {
+			return fmt.Errorf("skip-unchanged flag requires the out flag to be a single file, without output-shards or out-max-bytes")
+		}
+		if *inPlace || *atomic || *indexFlags.write {
+			return fmt.Errorf("skip-unchanged flag can not be used with the in-place, atomic, or index flags")
+		}
+	}
+
+	if *countOnly && (*outFileLoc != "" || *inPlace || *dryRun) {
+		return fmt.Errorf("count-only flag can not be used with the out, in-place, or dry-run flags, as nothing is written")
+	}
+
+	// Without an output file, failing on duplicates is only a check of the input
+	checkOnly := *failIfDuplicates && *outFileLoc == "" && !*inPlace
+
+	// An out file written by an earlier run given the same flags and input files is left as it is, and
+	// one written by an earlier run given anything else is replaced, as it would be by an update
+	var state *runState
+	var replaceOutput bool
+	if *skipUnchanged {
+		if state, err = newRunState(fs, paths); err != nil {
+			return err
+		}
+		written, upToDate := state.check(*outFileLoc)
+		if upToDate {
+			console.Printf("Up to date, the flags and input files are unchanged since the run that wrote: %s", *outFileLoc)
+			return nil
+		}
+		replaceOutput = written && !*appendFlag && !*update
+	}
+
+	// Once interrupted, the temporary files are removed, unless they are checkpointed, and the output too
+	// if it is not complete
+	interrupts := handleInterrupts(checkpointFlags.tmpDirs(*tmpDir)...)
+	defer interrupts.stop()
+
+	// Create output file for writing, or for a dry run check that it could be created. An atomic output is
+	// written to a temporary file next to it, which is removed unless renamed to it once the run succeeds.
+	var outFile *os.File
+	var shardFiles []*os.File
+	var rotating *rotatingOutput
+	var atomicNames []string
+	defer func() {
+		for _, name := range atomicNames {
+			os.Remove(name)
+		}
+	}()
+	createOutput := func(path string) (*os.File, error) {
+		if path == stdio {
+			return os.Stdout, nil
+		}
+		if !*atomic {
+			return createOutFile(path, *appendFlag)
+		}
+		f, err := createAtomicFile(path)
+		if err == nil {
+			interrupts.removePartial(f.Name())
+			atomicNames = append(atomicNames, f.Name())
+		}
+		return f, err
+	}
+	// An out file being updated is merged as one more chunk into a new file next to it, which replaces it
+	var updateFile *os.File
+	if *update {
+		if updateFile, err = os.Open(*outFileLoc); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if updateFile != nil {
+			defer updateFile.Close()
+		}
+	}
+	switch {
+	case checkOnly, *countOnly:
+	case *dryRun:
+		for _, loc := range outFileLocs {
+			if _, err = os.Stat(loc); err == nil && !*appendFlag && !*update && !replaceOutput {
+				return fmt.Errorf("output file already exists: %s", loc)
+			}
+		}
+	case len(shardFileLocs) > 0:
+		var closeShardFiles func()
+		shardFiles, closeShardFiles, err = createShardFiles(shardFileLocs, createOutput)
+		defer closeShardFiles()
+		if err != nil {
+			return err
+		}
+		if *removePartial && !*appendFlag && !*atomic {
+			interrupts.removePartial(shardFileLocs...)
+		}
+	case *inPlace:
+		outFile, err = createInPlaceFile(paths[0])
+		if err != nil {
+			return err
+		}
+		interrupts.removePartial(outFile.Name())
+		// Once renamed over the input file, this removal does nothing
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+	case updateFile != nil || replaceOutput:
+		outFile, err = createInPlaceFile(*outFileLoc)
+		if err != nil {
+			return err
+		}
+		interrupts.removePartial(outFile.Name())
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+	case *outMaxBytes > 0:
+		// The run writes to a pipe, whose lines are split between the files
+		outDelim := delimiter.value
+		if outDelim == "" {
+			outDelim = "\n"
+		}
+		// A crlf output line may be one byte longer than the line read
+		rotating, err = startRotating(*outFileLoc, *outMaxBytes, outDelim, *readBufferBytes+1, *writeBufferBytes, func(path string) {
+			if *removePartial {
+				interrupts.removePartial(path)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		defer rotating.pipe.Close()
+		outFile = rotating.pipe
+	default:
+		outFile, err = createOutput(*outFileLoc)
+		if err != nil {
+			return err
+		}
+		defer outFile.Close()
+		if *removePartial && !*appendFlag && !*atomic {
+			interrupts.removePartial(outFile.Name())
+		}
+	}
+
+	// Open input files for reading
+	inFiles, closeInFiles, err := openFiles(paths)
+	defer closeInFiles()
+	if err != nil {
+		return err
+	}
+
+	// Dedup
+	console.Printf("Starting dedup...")
+	opts := dedup.Options{
+		Partitions:        *partitions,
+		PartitionBy:       dedup.ShardMode(*partitionBy),
+		BuildWorkers:      *buildWorkers,
+		SortWorkers:       sortWorkers.value(),
+		ReadBufferSize:    *readBufferBytes,
+		ReadAhead:         readAheadOption(*readAhead),
+		ReadWorkers:       *readWorkers,
+		FileAdvice:        *fadviseFlag,
+		WriteBufferSize:   *writeBufferBytes,
+		MergeBufferSize:   *mergeBufferBytes,
+		MergeMmap:         *mergeMmap,
+		PreallocateOutput: *preallocateOutput,
+		OutputMmap:        *outputMmap,
+		MergeFanIn:        *mergeFanIn,
+		MaxOpenFiles:      *maxOpenFiles,
+		TempDir:           *tmpDir,
+		KeepTempFiles:     *keepTmp,
+		MinFreeBytes:      *minFreeBytes,
+		MaxTmpBytes:       *maxTmpBytes,
+		ChecksumTempFiles: *checksumTmp,
+		VerifyWrites:      *verifyWrites,
+		EncryptTempFiles:  *encryptTmp,
+		Limit:             *limit,
+		Delimiter:         delimiter.value,
+		CRLF:              *crlf,
+		NoFinalLineEnding: *noFinalLineEnding,
+		SkipLines:         *skipLines,
+		MaxLines:          *maxLines,
+		SkipPatterns:      patterns.skip,
+		KeepPatterns:      patterns.keep,
+		Rules:             patterns.rules,
+		PassthroughUnkept: patterns.passthrough,
+		TransformMode:     patterns.transformMode,
+		ShardBy:           dedup.ShardMode(*shardBy),
+		DryRun:            *dryRun || checkOnly,
+		CountOnly:         *countOnly || checkOnly,
+		CountLines:        *countLinesFlag,
+		Metrics:           metrics,
+		OnEvent:           console.event,
+	}
+	keyFlags.apply(&opts)
+	memoryFlags.apply(&opts)
+	formatFlags.apply(&opts)
+	rateLimitFlags.apply(&opts)
+	syncFlags.apply(&opts)
+	retryFlags.apply(&opts)
+	expectFlags.apply(&opts)
+	checkpointFlags.apply(&opts)
+	cancelTimeout := timeoutFlags.apply(&opts)
+	defer cancelTimeout()
+	applyWorkers(*workers, &opts)
+	opts.OnProgress, opts.ProgressInterval = console.progress(*progressBarFlag, *progressInterval)
+	progressEvents, err := progressEventFlags.open(&opts)
+	if err != nil {
+		return err
+	}
+	defer progressEvents.close()
+	if *failIfDuplicates {
+		opts.OnDuplicate = duplicateChecker(*showDuplicates, checkOnly)
+	}
+	if *seenIndexLoc != "" {
+		seen, err := dedup.OpenSeenSet(*seenIndexLoc, dedup.Options{Delimiter: delimiter.value})
+		if err != nil {
+			return err
+		}
+		defer seen.Close()
+		console.Verbosef("Skipping the %d lines of seen index: %s", seen.Lines(), *seenIndexLoc)
+		opts.Seen = seen
+	}
+	if updateFile != nil {
+		opts.Update = updateFile
+	}
+	if outFile != nil && !*dryRun {
+		lineEnd := delimiter.value
+		if lineEnd == "" {
+			lineEnd = "\n"
+		}
+		if err = referenceFlags.endLastLine(outFile, lineEnd); err != nil {
+			return fmt.Errorf("ending the last line of the out file: %w", err)
+		}
+	}
+	closeReference, err := referenceFlags.open(*outFileLoc, &opts)
+	if err != nil {
+		return err
+	}
+	defer closeReference()
+	var sidecar *sidecarIndex
+	if !*dryRun {
+		if sidecar, err = indexFlags.open(*outFileLoc, &opts); err != nil {
+			return err
+		}
+		defer sidecar.close()
+	}
+	dupReport, err := dupReportFlags.open(&opts)
+	if err != nil {
+		return err
+	}
+	sketchCounts, err := sketchFlags.open(&opts)
+	if err != nil {
+		dupReport.close()
+		return err
+	}
+	auditLog, err := openAuditLog(*auditLogLoc, &opts)
+	if err != nil {
+		dupReport.close()
+		sketchCounts.close()
+		return err
+	}
+	lineMap, err := openLineMap(*lineMapLoc, paths, *tmpDir, &opts)
+	defer lineMap.close()
+	if err != nil {
+		dupReport.close()
+		sketchCounts.close()
+		auditLog.close()
+		return err
+	}
+	if len(shardFileLocs) > 0 {
+		// The shard files are nil for a dry run, which does not write them
+		if shardFiles == nil {
+			shardFiles = make([]*os.File, len(shardFileLocs))
+		}
+		stats, err = dedup.RunShards(shardFiles, opts, sources(inFiles), nil)
+	} else {
+		stats, err = dedup.Run(outFile, opts, sources(inFiles), nil)
+	}
+	var rotatedLocs []string
+	if rotating != nil {
+		rotatedLocs, err = rotating.finish(err)
+	}
+	if closeErr := dupReport.close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := sketchCounts.close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := auditLog.close(); err == nil {
+		err = closeErr
+	}
+	if lineMap != nil && err == nil {
+		err = lineMap.finish(*memoryFlags.tmpFileBytes)
+	}
+	printKeptFiles(stats.TmpFiles)
+	if errors.Is(err, errDuplicateFound) {
+		return &exitError{code: exitDuplicates, err: fmt.Errorf("input contains duplicate lines")}
+	}
+	if err != nil {
+		return expectFlags.failed(timedOut(err, interrupts))
+	}
+	switch {
+	case *countOnly:
+		printCountOnly(stats)
+	case *dryRun:
+		printDryRun(stats)
+	case *atomic && len(shardFiles) > 0:
+		if err = commitAtomic(shardFiles, shardFileLocs, syncFlags.syncing()); err != nil {
+			return err
+		}
+	case *atomic && outFile != nil:
+		if err = commitAtomic([]*os.File{outFile}, []string{*outFileLoc}, syncFlags.syncing()); err != nil {
+			return err
+		}
+		console.Printf("Moved into place: %s", *outFileLoc)
+	case *inPlace:
+		if err = replaceInPlace(outFile, paths[0]); err != nil {
+			return err
+		}
+		console.Printf("Replaced file: %s", paths[0])
+	case updateFile != nil:
+		// The file is closed before it is replaced, which windows requires
+		updateFile.Close()
+		if err = replaceInPlace(outFile, *outFileLoc); err != nil {
+			return err
+		}
+		console.Printf("Updated file: %s", *outFileLoc)
+	case replaceOutput:
+		if err = replaceInPlace(outFile, *outFileLoc); err != nil {
+			return err
+		}
+		console.Printf("Replaced file: %s", *outFileLoc)
+	case len(shardFiles) > 0:
+		if err = closeOutputs(shardFiles); err != nil {
+			return err
+		}
+	case rotating != nil:
+		for _, loc := range rotatedLocs {
+			console.Printf("Wrote output file: %s", loc)
+		}
+	case outFile != nil:
+		if err = closeOutputs([]*os.File{outFile}); err != nil {
+			return err
+		}
+	}
+	if sidecar != nil {
+		if err = sidecar.commit(syncFlags.syncing()); err != nil {
+			return err
+		}
+		console.Printf("Wrote sidecar index: %s", sidecar.path)
+	}
+	if *referenceFlags.update && !*dryRun {
+		// The reference is closed before it is replaced, which windows requires
+		closeReference()
+		if err = referenceFlags.merge(*outFileLoc, opts); err != nil {
+			return err
+		}
+	}
+	if stats.Incomplete {
+		console.Warnf("Timed out after reading %d lines, the output is missing the rest of the input", stats.LinesRead)
+	}
+	for i, lines := range stats.ShardLines {
+		console.Printf("Shard %s: %d lines", shardFileLocs[i], lines)
+	}
+	if err = statsFlags.print(stats); err != nil {
+		return err
+	}
+	if *failIfDuplicates && stats.LinesDuplicate > 0 {
+		return &exitError{code: exitDuplicates, err: fmt.Errorf("input contains %d duplicate lines", stats.LinesDuplicate)}
+	}
+	if state != nil && !*dryRun && !stats.Incomplete {
+		if err = state.commit(*outFileLoc); err != nil {
+			return err
+		}
+	}
+	console.Printf("Success!")
+	return nil
+}
+
+// printDryRun prints what a run would have done
+func printDryRun(stats dedup.Stats) {
+	fmt.Println("Dry run, the output file was not written:")
+	fmt.Printf("  Lines read:                    %d\n", stats.LinesRead)
+	fmt.Printf("  Lines skipped:                 %d\n", stats.LinesSkipped)
+	if stats.LinesSeen > 0 {
+		fmt.Printf("  Lines seen by earlier runs:    %d\n", stats.LinesSeen)
+	}
+	fmt.Printf("  Duplicate lines to be removed: %d\n", stats.LinesDuplicate)
+	fmt.Printf("  Unique lines to be written:    %d\n", stats.LinesUnique)
+	fmt.Printf("  Estimated output size:         %d bytes\n", stats.BytesOut)
+	fmt.Printf("  Temporary space required:      %d bytes in %d files\n", stats.TmpBytes, stats.Chunks)
+}
+
+// printCountOnly prints the counts of a run that only counted the lines
+func printCountOnly(stats dedup.Stats) {
+	fmt.Println("Count only, no output was written:")
+	fmt.Printf("  Lines read:                 %d\n", stats.LinesRead)
+	fmt.Printf("  Lines skipped:              %d\n", stats.LinesSkipped)
+	if stats.LinesSeen > 0 {
+		fmt.Printf("  Lines seen by earlier runs: %d\n", stats.LinesSeen)
+	}
+	if stats.LinesPassedThrough > 0 {
+		fmt.Printf("  Lines passed through:       %d\n", stats.LinesPassedThrough)
+	}
+	fmt.Printf("  Duplicate lines:            %d\n", stats.LinesDuplicate)
+	fmt.Printf("  Unique lines:               %d\n", stats.LinesUnique)
+	fmt.Printf("  Temporary space used:       %d bytes in %d files\n", stats.TmpBytes, stats.Chunks)
+}
+
+// errDuplicateFound stops a run that is only checking for duplicates, once enough are found
+var errDuplicateFound = errors.New("duplicate found")
+
+// duplicateChecker returns a duplicate callback that prints the first distinct duplicate lines
+// to stdout, up to the limit. If stopEarly, it stops the run once the limit is reached.
+func duplicateChecker(limit int, stopEarly bool) func(string) error {
+	shown := make(map[string]bool)
+	return func(line string) error {
+		if len(shown) < limit && !shown[line] {
+			shown[line] = true
+			fmt.Println(line)
+		}
+		if stopEarly && len(shown) >= limit {
+			return errDuplicateFound
+		}
+		return nil
+	}
+}
+
+// printKeptFiles logs the paths of the temporary files that were kept
+func printKeptFiles(paths []string) {
+	for _, path := range paths {
+		console.Warnf("Kept temporary file: %s", path)
+	}
+	if len(paths) > 0 {
+		console.Warnf("The %d kept temporary files can be merged with: %s merge --in=<file> ...",
+			len(paths), filepath.Base(os.Args[0]))
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/veqryn/dedup"
+)
+
+// errSearchLimit stops a search once it has printed as many lines as its limit
+var errSearchLimit = errors.New("search limit reached")
+
+// searchCommand prints the lines of sorted and deduplicated files that are a line or start with a prefix,
+// found by binary search rather than reading the files
+func searchCommand(name string, args []string) error {
+	// Flags
+	fs := newFlagSet(name)
+	var inFileGlobs, lines, prefixes arrayFlags
+	fs.Var(&inFileGlobs, "in", "sorted and deduplicated file location or glob, such as the output of run, to search "+
+		"(flag can be used multiple times)")
+	inLists := addInListFlag(fs)
+	fs.Var(&lines, "line", "line to print if it is in a file (flag can be used multiple times)")
+	fs.Var(&prefixes, "prefix", "print every line of a file starting with this, in sorted order, or every line if empty "+
+		"(flag can be used multiple times)")
+	count := fs.Bool("count", false, "only print how many lines matched")
+	limit := fs.Uint64("limit", 0, "stop after printing this many lines (default: no limit)")
+	delimiter := addDelimiterFlag(fs)
+	logFlags := addLogFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := logFlags.apply(); err != nil {
+		return err
+	}
+	if len(lines) == 0 && len(prefixes) == 0 {
+		return fmt.Errorf("line or prefix flag must be given")
+	}
+
+	// Open the files to search, reading the sidecar index of each that has one
+	inFileLocs, err := withInLists(inFileGlobs, *inLists)
+	if err != nil {
+		return err
+	}
+	paths, err := expandGlobs(inFileLocs)
+	if err != nil {
+		return err
+	}
+	inFiles, closeInFiles, err := openFiles(paths)
+	defer closeInFiles()
+	if err != nil {
+		return err
+	}
+	files := make([]*dedup.SortedFile, len(inFiles))
+	for i, f := range inFiles {
+		if files[i], err = openSortedFile(f, paths[i], dedup.Options{Delimiter: delimiter.value}); err != nil {
+			return err
+		}
+	}
+
+	// Print each match followed by the delimiter, in the order of the flags, then of the files
+	out := bufio.NewWriter(os.Stdout)
+	ending := delimiter.value
+	if ending == "" {
+		ending = "\n"
+	}
+	var matched uint64
+	match := func(line string) error {
+		if *limit > 0 && matched >= *limit {
+			return errSearchLimit
+		}
+		matched++
+		if *count {
+			return nil
+		}
+		if _, err := out.WriteString(line); err != nil {
+			return err
+		}
+		_, err := out.WriteString(ending)
+		return err
+	}
+	err = search(files, lines, prefixes, match)
+	if *count && (err == nil || errors.Is(err, errSearchLimit)) {
+		fmt.Fprintf(out, "%d\n", matched)
+	}
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil && !errors.Is(err, errSearchLimit) {
+		return err
+	}
+	if matched == 0 {
+		return &exitError{code: exitNotFound, err: fmt.Errorf("no lines matched")}
+	}
+	return nil
+}
+
+// search calls match with each of the lines found in any of the files, and then with every line of the
+// files starting with each of the prefixes
+func search(files []*dedup.SortedFile, lines, prefixes []string, match func(line string) error) error {
+	for _, line := range lines {
+		found, err := lookup(files, line)
+		if err != nil {
+			return err
+		}
+		if found {
+			if err = match(line); err != nil {
+				return err
+			}
+		}
+	}
+	for _, prefix := range prefixes {
+		for _, f := range files {
+			if err := f.ScanPrefix(prefix, match); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
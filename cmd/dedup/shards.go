@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shardPaths returns the path of each shard of the output file, numbered before its extension,
+// such as deduped.0.log and deduped.1.log for deduped.log
+func shardPaths(outFileLoc string, shards int) []string {
+	ext := filepath.Ext(outFileLoc)
+	base := strings.TrimSuffix(outFileLoc, ext)
+	digits := len(fmt.Sprint(shards - 1))
+
+	paths := make([]string, shards)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("%s.%0*d%s", base, digits, i, ext)
+	}
+	return paths
+}
+
+// createShardFiles creates every shard file for writing with the create function. The returned close
+// function closes all of them, and must be called even if an error is returned.
+func createShardFiles(paths []string, create func(path string) (*os.File, error)) ([]*os.File, func(), error) {
+	var files []*os.File
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	for _, path := range paths {
+		f, err := create(path)
+		if err != nil {
+			return files, closeAll, err
+		}
+		files = append(files, f)
+	}
+	return files, closeAll, nil
+}
@@ -0,0 +1,18 @@
+//go:build !plan9
+// +build !plan9
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// signalExitCode returns the exit code of a process killed by the signal, 128 plus its number as
+// shells report it, or 1 for a signal without a number
+func signalExitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
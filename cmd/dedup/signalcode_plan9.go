@@ -0,0 +1,8 @@
+package main
+
+import "os"
+
+// signalExitCode returns 1, as plan9 notes are strings rather than numbered signals
+func signalExitCode(sig os.Signal) int {
+	return 1
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/veqryn/dedup"
+)
+
+// sketchFlags are the flags for writing the approximate count of every unique line to a side file
+type sketchFlags struct {
+	path    *string
+	epsilon *float64
+	delta   *float64
+}
+
+// addSketchFlags registers the count sketch flags on the flag set
+func addSketchFlags(fs *flag.FlagSet) sketchFlags {
+	return sketchFlags{
+		path: fs.String("sketch-counts", "", "file to write every unique line to with the approximate number of times it was read, "+
+			"counted in the fixed memory of a Count-Min sketch, in sorted order"),
+		epsilon: fs.Float64("sketch-epsilon", 0.00005, "the most the sketch counts are over by, as a fraction of all lines read"),
+		delta:   fs.Float64("sketch-delta", 0.01, "the probability of a sketch count being over by more than the sketch-epsilon"),
+	}
+}
+
+// open creates the sketch counts file, if the sketch-counts flag was given, and sets the options to
+// count the lines and write their counts to it. The returned report must be closed, and is nil
+// without the flag.
+func (f sketchFlags) open(opts *dedup.Options) (*dupReport, error) {
+	if *f.path == "" {
+		return nil, nil
+	}
+	sketch, err := dedup.NewCountSketch(*f.epsilon, *f.delta)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(*f.path); err == nil {
+		return nil, fmt.Errorf("sketch counts file already exists: %s", *f.path)
+	}
+	file, err := createOutFile(*f.path, false)
+	if err != nil {
+		return nil, err
+	}
+	console.Verbosef("Counting lines in a sketch of %s", humanBytes(float64(sketch.Size())))
+	r := newDupReport("sketch counts", file, opts.Delimiter)
+	opts.CountSketch = sketch
+	opts.OnSketchCount = r.writeCount
+	return r, nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/veqryn/dedup"
+)
+
+// sortCommand deduplicates the input files into sorted chunk files, without merging them
+func sortCommand(name string, args []string) error {
+	// Flags
+	fs := newFlagSet(name)
+	var inFileGlobs arrayFlags
+	fs.Var(&inFileGlobs, "in", "input file location or glob (flag can be used multiple times)")
+	inLists := addInListFlag(fs)
+	patternFlags := addPatternFlags(fs, false)
+	skipLines := fs.Uint64("skip-lines", 0, "number of lines at the start of the input to ignore")
+	maxLines := fs.Uint64("max-lines", 0, "maximum number of input lines to process after skip-lines (default: no maximum)")
+	outDir := fs.String("out-dir", "", "directory to write the sorted chunk files to (default: the os temp dir)")
+	maxTmpBytes := fs.Uint64("max-tmp-bytes", 0, "most bytes of chunk files to write in total, failing with what to do about it "+
+		"rather than fill the out dir (default: no limit)")
+	verifyWrites := fs.Bool("verify-writes", false, "read back each chunk file once written, failing unless it has the lines and checksum "+
+		"written to it, sorted and unique")
+	memoryFlags := addMemoryFlags(fs, "chunk file")
+	buildWorkers := fs.Int("build-workers", 1, "number of goroutines adding the lines read to sets, each for a hash partition of the lines with an equal share of tmp-file-bytes")
+	sortWorkers := addSortWorkersFlag(fs)
+	workers := addWorkersFlag(fs)
+	readBufferBytes := fs.Int("read-buffer-bytes", 256*1024, "byte size of the buffer for reading input files, which is also the maximum line length")
+	readAhead := addReadAheadFlag(fs)
+	fadviseFlag := addFadviseFlag(fs)
+	readWorkers := fs.Int("read-workers", 1, "number of input files read at once, each on its own goroutine, "+
+		"interleaving their lines. can not be used with skip-lines, max-lines, or tracking where lines were read")
+	writeBufferBytes := fs.Int("write-buffer-bytes", 256*1024, "byte size of the buffer for writing the output and temporary files")
+	delimiter := addDelimiterFlag(fs)
+	rateLimitFlags := addRateLimitFlags(fs)
+	syncFlags := addSyncFlags(fs)
+	retryFlags := addRetryFlags(fs)
+	timeoutFlags := addTimeoutFlags(fs, false)
+	profileFlags := addProfileFlags(fs)
+	metricsAddr := addMetricsFlag(fs)
+	progressEventFlags := addProgressEventFlags(fs)
+	logFlags := addLogFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := logFlags.apply(); err != nil {
+		return err
+	}
+	stopProfiling, err := profileFlags.start()
+	defer func() {
+		if stopErr := stopProfiling(); stopErr != nil {
+			console.Warnf("Error writing profiles: %v", stopErr)
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	metrics := &dedup.Metrics{}
+	stopMetrics, err := serveMetrics(*metricsAddr, metrics)
+	defer stopMetrics()
+	if err != nil {
+		return err
+	}
+
+	if *readBufferBytes <= 0 || *writeBufferBytes <= 0 {
+		return fmt.Errorf("buffer byte flags must be positive integers or omitted for the defaults")
+	}
+	if err := memoryFlags.validate(); err != nil {
+		return err
+	}
+	if err := rateLimitFlags.validate(); err != nil {
+		return err
+	}
+	if err := retryFlags.validate(); err != nil {
+		return err
+	}
+	if err := progressEventFlags.validate(); err != nil {
+		return err
+	}
+	if err := timeoutFlags.validate(); err != nil {
+		return err
+	}
+	if *buildWorkers <= 0 {
+		return fmt.Errorf("build-workers flag must be a positive integer or omitted for the default")
+	}
+	if err := sortWorkers.validate(); err != nil {
+		return err
+	}
+	if *workers <= 0 {
+		return fmt.Errorf("workers flag must be a positive integer or omitted for the default")
+	}
+	if *readAhead < 0 {
+		return fmt.Errorf("read-ahead flag must be zero or a positive integer")
+	}
+	if *readWorkers <= 0 {
+		return fmt.Errorf("read-workers flag must be a positive integer or omitted for the default")
+	}
+	if *outDir != "" {
+		if err := os.MkdirAll(*outDir, 0755); err != nil {
+			return err
+		}
+	}
+
+	// Once interrupted, the chunk files written so far are removed, as they are not listed
+	interrupts := handleInterrupts(*outDir)
+	defer interrupts.stop()
+
+	// Compile regexp's
+	patterns, err := patternFlags.compile()
+	if err != nil {
+		return err
+	}
+
+	// Open input files for reading
+	inFileLocs, err := withInLists(inFileGlobs, *inLists)
+	if err != nil {
+		return err
+	}
+	paths, err := expandGlobs(inFileLocs)
+	if err != nil {
+		return err
+	}
+	inFiles, closeInFiles, err := openFiles(paths)
+	defer closeInFiles()
+	if err != nil {
+		return err
+	}
+
+	// Sort into chunks, and list them so they can be given to merge
+	console.Printf("Starting sort...")
+	opts := dedup.Options{
+		BuildWorkers:    *buildWorkers,
+		SortWorkers:     sortWorkers.value(),
+		ReadBufferSize:  *readBufferBytes,
+		ReadAhead:       readAheadOption(*readAhead),
+		ReadWorkers:     *readWorkers,
+		FileAdvice:      *fadviseFlag,
+		WriteBufferSize: *writeBufferBytes,
+		MaxTmpBytes:     *maxTmpBytes,
+		VerifyWrites:    *verifyWrites,
+		Delimiter:       delimiter.value,
+		SkipLines:       *skipLines,
+		MaxLines:        *maxLines,
+		SkipPatterns:    patterns.skip,
+		KeepPatterns:    patterns.keep,
+		Rules:           patterns.rules,
+		Metrics:         metrics,
+		OnEvent:         console.event,
+	}
+	memoryFlags.apply(&opts)
+	rateLimitFlags.apply(&opts)
+	syncFlags.apply(&opts)
+	retryFlags.apply(&opts)
+	cancelTimeout := timeoutFlags.apply(&opts)
+	defer cancelTimeout()
+	applyWorkers(*workers, &opts)
+	opts.OnProgress, opts.ProgressInterval = console.progress(false, time.Minute)
+	progressEvents, err := progressEventFlags.open(&opts)
+	if err != nil {
+		return err
+	}
+	defer progressEvents.close()
+	chunkPaths, err := dedup.SortChunks(*outDir, opts, sources(inFiles))
+	if err != nil {
+		return timedOut(err, interrupts)
+	}
+	for _, chunkPath := range chunkPaths {
+		fmt.Println(chunkPath)
+	}
+	console.Printf("Success!")
+	return nil
+}
+
+// addWorkersFlag registers the workers flag on the flag set
+func addWorkersFlag(fs *flag.FlagSet) *int {
+	return fs.Int("workers", runtime.GOMAXPROCS(0), "max number of CPUs used, and of goroutines sorting chunks, adding lines to sets, "+
+		"or merging partitions at once, which lowers sort-workers and build-workers to it")
+}
+
+// applyWorkers sets GOMAXPROCS and the Workers option to the workers flag, so that the whole process,
+// and not only its workers, is held to that many CPUs
+func applyWorkers(workers int, opts *dedup.Options) {
+	runtime.GOMAXPROCS(workers)
+	opts.Workers = workers
+}
+
+// sortWorkersFlag is how many full chunks are sorted and written at once, while the input keeps being read
+type sortWorkersFlag struct {
+	workers *int
+}
+
+// addSortWorkersFlag registers the sort-workers flag on the flag set
+func addSortWorkersFlag(fs *flag.FlagSet) sortWorkersFlag {
+	return sortWorkersFlag{workers: fs.Int("sort-workers", 1,
+		"number of full chunks sorted and written at once while the input keeps being read, each holding up to "+
+			"tmp-file-bytes in memory. 0 pauses the reading while each chunk is sorted and written")}
+}
+
+// validate returns an error if the flag is invalid
+func (f sortWorkersFlag) validate() error {
+	if *f.workers < 0 {
+		return fmt.Errorf("sort-workers flag must be zero or a positive integer")
+	}
+	return nil
+}
+
+// value returns the SortWorkers option of the flag, which is negative to sort on the reading goroutine
+func (f sortWorkersFlag) value() int {
+	if *f.workers == 0 {
+		return -1
+	}
+	return *f.workers
+}
+
+// addReadAheadFlag registers the read-ahead flag on the flag set
+func addReadAheadFlag(fs *flag.FlagSet) *int {
+	return fs.Int("read-ahead", 2, "number of buffers of read-buffer-bytes read from the input on another goroutine, "+
+		"ahead of the lines being deduplicated. 0 reads the input as its lines are deduplicated")
+}
+
+// readAheadOption returns the ReadAhead option of the read-ahead flag, which is negative to read on
+// the reading goroutine
+func readAheadOption(readAhead int) int {
+	if readAhead == 0 {
+		return -1
+	}
+	return readAhead
+}
+
+// addFadviseFlag registers the fadvise flag on the flag set
+func addFadviseFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("fadvise", false, "advise the kernel that files are read sequentially, and to drop the input and output "+
+		"files from the page cache once read or written, so that huge runs do not evict it. only on linux")
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/veqryn/dedup"
+)
+
+// stats formats
+const (
+	statsFormatText = "text"
+	statsFormatJSON = "json"
+)
+
+// statsSchemaVersion is the version of the JSON summary, which is only increased when a field is
+// changed or removed, rather than added
+const statsSchemaVersion = 1
+
+// statsPhases are the phases in the order they run, for the text summary
+var statsPhases = []dedup.Phase{dedup.PhaseCounting, dedup.PhaseSplitting, dedup.PhaseWriting, dedup.PhaseMerging}
+
+// statsFlags are the flags for printing a summary once a subcommand is done
+type statsFlags struct {
+	show   *bool
+	format *string
+	file   *string
+}
+
+// statsRecord is the JSON summary of a run
+type statsRecord struct {
+	SchemaVersion  int                `json:"schema_version"`
+	LinesRead      uint64             `json:"lines_read"`
+	LinesUnique    uint64             `json:"lines_unique"`
+	LinesRemoved   uint64             `json:"lines_removed"`
+	LinesSkipped   uint64             `json:"lines_skipped"`
+	LinesSeen      uint64             `json:"lines_seen"`
+	LinesPassed    uint64             `json:"lines_passed_through"`
+	RuleLines      []uint64           `json:"rule_lines,omitempty"`
+	SkipLines      []uint64           `json:"skip_pattern_lines,omitempty"`
+	KeepLines      []uint64           `json:"keep_pattern_lines,omitempty"`
+	BytesIn        uint64             `json:"bytes_in"`
+	BytesOut       uint64             `json:"bytes_out"`
+	Limited        bool               `json:"limited"`
+	Chunks         int                `json:"chunks"`
+	PressureSpills int                `json:"pressure_spills"`
+	TmpBytes       uint64             `json:"tmp_bytes"`
+	SpillWaitSecs  float64            `json:"spill_wait_seconds"`
+	SortSecs       float64            `json:"sort_seconds"`
+	ChunkLines     []uint64           `json:"chunk_lines,omitempty"`
+	ElapsedSeconds float64            `json:"elapsed_seconds"`
+	PhaseSeconds   map[string]float64 `json:"phase_seconds"`
+}
+
+// newStatsRecord returns the JSON summary of the stats of a run
+func newStatsRecord(stats dedup.Stats) statsRecord {
+	rec := statsRecord{
+		SchemaVersion:  statsSchemaVersion,
+		LinesRead:      stats.LinesRead,
+		LinesUnique:    stats.LinesUnique,
+		LinesRemoved:   stats.LinesDuplicate,
+		LinesSkipped:   stats.LinesSkipped,
+		LinesSeen:      stats.LinesSeen,
+		LinesPassed:    stats.LinesPassedThrough,
+		RuleLines:      stats.RuleLines,
+		SkipLines:      stats.SkipPatternLines,
+		KeepLines:      stats.KeepPatternLines,
+		BytesIn:        stats.BytesRead,
+		BytesOut:       stats.BytesOut,
+		Limited:        stats.Limited,
+		Chunks:         stats.Chunks,
+		PressureSpills: stats.PressureSpills,
+		TmpBytes:       stats.TmpBytes,
+		SpillWaitSecs:  stats.SpillWait.Seconds(),
+		SortSecs:       stats.SortElapsed.Seconds(),
+		ChunkLines:     stats.ChunkLines,
+		ElapsedSeconds: stats.Elapsed.Seconds(),
+		PhaseSeconds:   make(map[string]float64, len(stats.PhaseElapsed)),
+	}
+	for phase, elapsed := range stats.PhaseElapsed {
+		rec.PhaseSeconds[string(phase)] = elapsed.Seconds()
+	}
+	return rec
+}
+
+// addStatsFlags registers the stats flags on the flag set
+func addStatsFlags(fs *flag.FlagSet) statsFlags {
+	return statsFlags{
+		show:   fs.Bool("stats", false, "print a summary of the counts and durations to stdout once done"),
+		format: fs.String("stats-format", statsFormatText, "format of the summary: text or json"),
+		file: fs.String("stats-file", "", "file to write the summary to as json once done, replacing it if it exists, "+
+			"whatever the stats and stats-format flags"),
+	}
+}
+
+// validate returns an error if the stats flags are invalid
+func (f statsFlags) validate() error {
+	if *f.format != statsFormatText && *f.format != statsFormatJSON {
+		return fmt.Errorf("stats-format flag must be one of: %s, %s", statsFormatText, statsFormatJSON)
+	}
+	return nil
+}
+
+// print writes the summary to stdout, if the stats flag was given, and to the stats file, if one was
+func (f statsFlags) print(stats dedup.Stats) error {
+	if *f.file != "" {
+		if err := writeJSONFile(*f.file, newStatsRecord(stats)); err != nil {
+			return fmt.Errorf("writing stats file %s: %w", *f.file, err)
+		}
+	}
+	if !*f.show {
+		return nil
+	}
+
+	if *f.format == statsFormatJSON {
+		return json.NewEncoder(os.Stdout).Encode(newStatsRecord(stats))
+	}
+
+	var phases []string
+	for _, phase := range statsPhases {
+		if elapsed, ok := stats.PhaseElapsed[phase]; ok {
+			phases = append(phases, fmt.Sprintf("%s %s", phase, elapsed.Round(time.Millisecond)))
+		}
+	}
+	fmt.Println("Stats:")
+	fmt.Printf("  Lines read:       %d\n", stats.LinesRead)
+	fmt.Printf("  Lines unique:     %d\n", stats.LinesUnique)
+	fmt.Printf("  Lines removed:    %d\n", stats.LinesDuplicate)
+	fmt.Printf("  Lines skipped:    %d\n", stats.LinesSkipped)
+	if stats.LinesSeen > 0 {
+		fmt.Printf("  Lines seen:       %d\n", stats.LinesSeen)
+	}
+	if stats.LinesPassedThrough > 0 {
+		fmt.Printf("  Lines passed:     %d\n", stats.LinesPassedThrough)
+	}
+	printMatchCounts("Rules:", stats.RuleLines)
+	printMatchCounts("Skip patterns:", stats.SkipPatternLines)
+	printMatchCounts("Keep patterns:", stats.KeepPatternLines)
+	fmt.Printf("  Bytes in:         %d\n", stats.BytesRead)
+	fmt.Printf("  Bytes out:        %d\n", stats.BytesOut)
+	if stats.Limited {
+		fmt.Println("  Output limited:   yes, there were more unique lines")
+	}
+	fmt.Printf("  Temporary files:  %d (%d bytes)\n", stats.Chunks, stats.TmpBytes)
+	if stats.PressureSpills > 0 {
+		fmt.Printf("  Early spills:     %d, under memory pressure\n", stats.PressureSpills)
+	}
+	if stats.SpillWait > 0 {
+		fmt.Printf("  Spill wait:       %s, waiting for sort-workers\n", stats.SpillWait.Round(time.Millisecond))
+	}
+	if len(stats.ChunkLines) > 1 {
+		least, most := stats.ChunkLines[0], stats.ChunkLines[0]
+		for _, lines := range stats.ChunkLines {
+			if lines < least {
+				least = lines
+			}
+			if lines > most {
+				most = lines
+			}
+		}
+		fmt.Printf("  Chunk lines:      %d to %d, sorted in %s\n", least, most, stats.SortElapsed.Round(time.Millisecond))
+	}
+	fmt.Printf("  Duration:         %s (%s)\n", stats.Elapsed.Round(time.Millisecond), strings.Join(phases, ", "))
+	return nil
+}
+
+// printMatchCounts prints the lines matched by each of the rules or patterns, in the order they were given
+func printMatchCounts(label string, counts []uint64) {
+	if len(counts) == 0 {
+		return
+	}
+	lines := make([]string, len(counts))
+	for i, count := range counts {
+		lines[i] = fmt.Sprint(count)
+	}
+	fmt.Printf("  %-17s %s lines, in the order given\n", label, strings.Join(lines, ", "))
+}
+
+// writeJSONFile writes the value as JSON to a temporary file next to the file, and renames it to the
+// file, so that whatever reads it never reads it partially written
+func writeJSONFile(path string, v interface{}) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".dedup.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err = json.NewEncoder(f).Encode(v); err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+)
+
+// stdio is the path that reads an input from stdin, or writes the output to stdout, so that dedup can
+// be part of a pipeline
+const stdio = "-"
+
+// expandInputs returns the paths of all files matching the globs, in the order given, with stdio as is
+func expandInputs(fileGlobs []string) ([]string, error) {
+	if len(fileGlobs) == 0 {
+		return nil, fmt.Errorf("in or in-list flag must be non-empty")
+	}
+
+	var paths []string
+	for _, fileGlob := range fileGlobs {
+		if fileGlob == stdio {
+			paths = append(paths, stdio)
+			continue
+		}
+		filePaths, err := expandGlobs([]string{fileGlob})
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, filePaths...)
+	}
+	return paths, nil
+}
+
+// stdioConflict is a flag that can not be used while reading stdin or writing stdout, and whether it is set
+type stdioConflict struct {
+	flag string
+	set  bool
+}
+
+// checkStdio returns an error if stdin is read more than once, or if any flag that needs the input files
+// to be read again while reading stdin, or the output file to be renamed, read back, or synced while
+// writing stdout, is set. Stdout can not be written to by anything else then, such as the stats flag.
+func checkStdio(paths []string, outFileLoc string, inConflicts, outConflicts []stdioConflict) error {
+	var stdin int
+	for _, path := range paths {
+		if path == stdio {
+			stdin++
+		}
+	}
+	if stdin > 1 {
+		return fmt.Errorf("stdin can only be read once, but %s was given %d times", stdio, stdin)
+	}
+	if stdin > 0 {
+		for _, c := range inConflicts {
+			if c.set {
+				return fmt.Errorf("%s flag can not be used when reading stdin", c.flag)
+			}
+		}
+	}
+	if outFileLoc == stdio {
+		for _, c := range outConflicts {
+			if c.set {
+				return fmt.Errorf("%s flag can not be used when writing stdout", c.flag)
+			}
+		}
+	}
+	return nil
+}
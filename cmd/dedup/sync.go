@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/veqryn/dedup"
+)
+
+// syncFlags are the fsync, fsync-bytes, and durable flags, which decide when the files written are
+// synced to storage
+type syncFlags struct {
+	sync    *bool
+	bytes   *uint64
+	durable *bool
+}
+
+// addSyncFlags registers the fsync, fsync-bytes, and durable flags on the flag set
+func addSyncFlags(fs *flag.FlagSet) syncFlags {
+	return syncFlags{
+		sync: fs.Bool("fsync", false, "sync the output and temporary files to storage once each has been written, "+
+			"so that the output is durable once the app exits"),
+		bytes: fs.Uint64("fsync-bytes", 0, "also sync the output and temporary files every time this many more bytes have been "+
+			"written to them, so that what is waiting to be written back does not build up, such as on NFS (default: never)"),
+		durable: fs.Bool("durable", false, "sync the output and temporary files like fsync, and also their directories, so that a "+
+			"machine crash can not leave an output file that exists but whose contents were lost"),
+	}
+}
+
+// syncing returns true if the output is synced to storage once written, by either the fsync or durable flag
+func (f syncFlags) syncing() bool {
+	return *f.sync || *f.durable
+}
+
+// apply sets the Sync, SyncBytes, and Durable options of the flags
+func (f syncFlags) apply(opts *dedup.Options) {
+	opts.Sync, opts.SyncBytes, opts.Durable = *f.sync, *f.bytes, *f.durable
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/veqryn/dedup"
+)
+
+// exitTimeout is the exit code when the timeout flag stopped the run
+const exitTimeout = 4
+
+// timeoutFlags are the timeout and flush-on-timeout flags, which stop a run that takes too long
+type timeoutFlags struct {
+	timeout *time.Duration
+	flush   *bool
+}
+
+// addTimeoutFlags registers the timeout flag on the flag set, and the flush-on-timeout flag if the command
+// writes an output that the lines read so far can be merged into
+func addTimeoutFlags(fs *flag.FlagSet, flushable bool) timeoutFlags {
+	f := timeoutFlags{
+		timeout: fs.Duration("timeout", 0, "stop the run once it has taken this long, such as 2h, exiting with code 4 "+
+			"after removing its temporary files, or keeping those of its checkpoint (default: no timeout)"),
+		flush: new(bool),
+	}
+	if flushable {
+		f.flush = fs.Bool("flush-on-timeout", false, "once the timeout passes, stop reading the input and still merge the lines read "+
+			"so far into the output, which is deduplicated but incomplete, rather than failing")
+	}
+	return f
+}
+
+// validate returns an error if the flags are invalid
+func (f timeoutFlags) validate() error {
+	if *f.timeout < 0 {
+		return fmt.Errorf("timeout flag must not be negative")
+	}
+	if *f.flush && *f.timeout == 0 {
+		return fmt.Errorf("flush-on-timeout flag requires the timeout flag")
+	}
+	return nil
+}
+
+// apply sets the Context and FlushOnCancel options of the flags, returning the function that releases
+// the context once the run is done
+func (f timeoutFlags) apply(opts *dedup.Options) context.CancelFunc {
+	if *f.timeout == 0 {
+		return func() {}
+	}
+	var cancel context.CancelFunc
+	opts.Context, cancel = context.WithTimeout(context.Background(), *f.timeout)
+	opts.FlushOnCancel = *f.flush
+	return cancel
+}
+
+// timedOut returns the error of a run that the timeout stopped as one exiting with exitTimeout, once
+// its partially written files have been removed as if it were interrupted, or the error itself
+func timedOut(err error, interrupts *interruptHandler) error {
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	interrupts.removePartialFiles()
+	return &exitError{code: exitTimeout, err: fmt.Errorf("timed out: %w", err)}
+}
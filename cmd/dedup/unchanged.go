@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// stateExtension is added to the path of an output file for the path of the state of the run that wrote it
+const stateExtension = ".dedup-state"
+
+// runStateVersion is the version of the state file, whose states of other versions are never up to date
+const runStateVersion = 1
+
+// runState is what a run that succeeded was given and wrote, kept next to its output, so that a run
+// given the same can skip rewriting it
+type runState struct {
+	Version int               `json:"version"`
+	Flags   map[string]string `json:"flags"`
+	Inputs  []inputState      `json:"inputs"`
+	Output  outputState       `json:"output"`
+}
+
+// inputState is the size and checksum of an input file
+type inputState struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// outputState is the size and modification time of the output file, once written, to know it is
+// still the output of the run
+type outputState struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// outputFlags are the flags of run that change what it writes, to the out file or next to it, or whether
+// it succeeds, which are the only flags a run is compared by. The flags of logging, progress, stats,
+// and performance are not, so that changing them alone does not run it again.
+var outputFlags = map[string]bool{
+	// Input
+	"in": true, "in-list": true, "skip-lines": true, "max-lines": true,
+	"keep-pattern": true, "keep-pattern-file": true, "skip-pattern": true, "skip-pattern-file": true,
+	"rule": true, "rule-file": true, "patterns-match-keys": true, "passthrough": true,
+	"strip-ansi": true, "strip-timestamp": true, "strip-timestamp-layout": true,
+	"reference": true, "update-reference": true, "seen-index": true,
+	// Keys
+	"key-fields": true, "key-delim": true, "key-prefix-bytes": true, "write-keys": true, "transform-mode": true,
+	// Output
+	"out": true, "append": true, "append-new": true, "update": true, "dry-run": true, "count-only": true, "limit": true,
+	"delimiter": true, "crlf": true, "no-final-line-ending": true, "strip-ansi-output": true,
+	"format": true, "output-format": true, "partitions": true, "partition-by": true,
+	"arrow-batch-lines": true, "parquet-row-group-bytes": true, "table": true, "postgres-batch-lines": true,
+	"redis-key": true, "redis-ttl": true, "redis-batch-lines": true,
+	"dup-report": true, "dup-report-format": true, "audit-log": true, "line-map": true,
+	"sketch-counts": true, "sketch-epsilon": true, "sketch-delta": true,
+	// Checks
+	"fail-if-duplicates": true, "expect-unique": true, "expect-unique-tolerance": true,
+}
+
+// newRunState returns the state of a run given the output flags set and the input files, reading each
+// of them to checksum it
+func newRunState(fs *flag.FlagSet, paths []string) (*runState, error) {
+	state := &runState{Version: runStateVersion, Flags: make(map[string]string)}
+	fs.Visit(func(f *flag.Flag) {
+		if outputFlags[f.Name] {
+			state.Flags[f.Name] = f.Value.String()
+		}
+	})
+	for _, path := range paths {
+		input, err := checksumInput(path)
+		if err != nil {
+			return nil, err
+		}
+		state.Inputs = append(state.Inputs, input)
+	}
+	return state, nil
+}
+
+// checksumInput returns the size and checksum of the input file
+func checksumInput(path string) (inputState, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return inputState{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return inputState{}, fmt.Errorf("checksumming input file %s: %w", path, err)
+	}
+	return inputState{Path: path, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// check compares the state with the state file of the output file. The output is written by the
+// earlier run if it is as that run left it, and up to date if that run was also given the same flags
+// and input files. A missing or unreadable state file is of no earlier run.
+func (s *runState) check(outFileLoc string) (written, upToDate bool) {
+	data, err := os.ReadFile(outFileLoc + stateExtension)
+	if err != nil {
+		return false, false
+	}
+	var earlier runState
+	if err = json.Unmarshal(data, &earlier); err != nil || earlier.Version != s.Version {
+		return false, false
+	}
+	info, err := os.Stat(outFileLoc)
+	if err != nil || info.Size() != earlier.Output.Size || !info.ModTime().Equal(earlier.Output.ModTime) {
+		return false, false
+	}
+
+	if len(earlier.Flags) != len(s.Flags) || len(earlier.Inputs) != len(s.Inputs) {
+		return true, false
+	}
+	for name, value := range s.Flags {
+		if earlierValue, ok := earlier.Flags[name]; !ok || earlierValue != value {
+			return true, false
+		}
+	}
+	for i, input := range s.Inputs {
+		if earlier.Inputs[i] != input {
+			return true, false
+		}
+	}
+	return true, true
+}
+
+// commit writes the state next to the output file, once the run writing it has succeeded
+func (s *runState) commit(outFileLoc string) error {
+	info, err := os.Stat(outFileLoc)
+	if err != nil {
+		return err
+	}
+	s.Output = outputState{Size: info.Size(), ModTime: info.ModTime()}
+	if err = writeJSONFile(outFileLoc+stateExtension, s); err != nil {
+		return fmt.Errorf("writing state file %s: %w", outFileLoc+stateExtension, err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSkipUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	inFileLoc := filepath.Join(dir, "in.log")
+	outFileLoc := filepath.Join(dir, "out.log")
+	stateLoc := outFileLoc + stateExtension
+	if err := os.WriteFile(inFileLoc, []byte("b\na\nb\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"--in=" + inFileLoc, "--out=" + outFileLoc, "--skip-unchanged", "--quiet"}
+
+	// run runs dedup, and returns whether it rewrote the output, which a run does along with its state
+	// file, whose modification time is set back each time to tell
+	past := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	run := func(expected string, args ...string) bool {
+		t.Helper()
+		if err := runCommand("run", args); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(outFileLoc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Fatalf("Expected output %q; Got: %q", expected, b)
+		}
+		info, err := os.Stat(stateLoc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chtimes(stateLoc, past, past); err != nil {
+			t.Fatal(err)
+		}
+		return !info.ModTime().Equal(past)
+	}
+
+	if !run("a\nb\n", args...) {
+		t.Fatal("Expected the first run to write the output")
+	}
+	if run("a\nb\n", args...) {
+		t.Fatal("Expected a run of unchanged flags and input to be skipped")
+	}
+
+	// Changed input is run again, even of the same size
+	if err := os.WriteFile(inFileLoc, []byte("c\na\nc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !run("a\nc\n", args...) {
+		t.Fatal("Expected a run of changed input to write the output")
+	}
+	if run("a\nc\n", args...) {
+		t.Fatal("Expected a run of unchanged flags and input to be skipped")
+	}
+
+	// Changed flags are run again, whether added or of a changed value
+	if !run("a\r\nc\r\n", append(args, "--crlf")...) {
+		t.Fatal("Expected a run of an added flag to write the output")
+	}
+	if !run("a\r\nc\r\n", append(args, "--crlf", "--limit=1000")...) {
+		t.Fatal("Expected a run of an added flag to write the output")
+	}
+	if !run("a\r\nc\r\n", append(args, "--crlf", "--limit=2000")...) {
+		t.Fatal("Expected a run of a changed flag value to write the output")
+	}
+	if run("a\r\nc\r\n", append(args, "--crlf", "--limit=2000")...) {
+		t.Fatal("Expected a run of unchanged flags and input to be skipped")
+	}
+
+	// Flags that do not change the output, such as those of logging and performance, are not compared
+	args = append(args, "--crlf", "--limit=2000")
+	for _, flags := range [][]string{
+		{"--quiet=false", "--verbose"},
+		{"--log-format=json", "--stats"},
+		{"--tmp-file-bytes=1000", "--workers=2"},
+	} {
+		if run("a\r\nc\r\n", append(args, flags...)...) {
+			t.Fatalf("Expected a run of added flags %q that do not change the output to be skipped", flags)
+		}
+	}
+}
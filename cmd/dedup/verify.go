@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/veqryn/dedup"
+)
+
+// verifyCommand checks that the input files are sorted and contain no duplicates
+func verifyCommand(name string, args []string) error {
+	// Flags
+	fs := newFlagSet(name)
+	var inFileGlobs arrayFlags
+	fs.Var(&inFileGlobs, "in", "input file location or glob (flag can be used multiple times)")
+	inLists := addInListFlag(fs)
+	logFlags := addLogFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := logFlags.apply(); err != nil {
+		return err
+	}
+
+	// Open input files for reading
+	inFileLocs, err := withInLists(inFileGlobs, *inLists)
+	if err != nil {
+		return err
+	}
+	paths, err := expandGlobs(inFileLocs)
+	if err != nil {
+		return err
+	}
+	inFiles, closeInFiles, err := openFiles(paths)
+	defer closeInFiles()
+	if err != nil {
+		return err
+	}
+
+	// Each file must be sorted and deduplicated on its own
+	for _, inFile := range inFiles {
+		count, err := dedup.Verify(inFile)
+		if err != nil {
+			return fmt.Errorf("%s: %w", inFile.Name(), err)
+		}
+		console.Printf("Verified %d lines: %s", count, inFile.Name())
+	}
+	console.Printf("Success!")
+	return nil
+}
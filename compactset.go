@@ -0,0 +1,47 @@
+package dedup
+
+import "fmt"
+
+// compactSet is a set of the distinct lines read that uses less memory than a map, with PrefixSet or HashSet
+type compactSet interface {
+	// has returns true if the line is in the set
+	has(line []byte) bool
+
+	// add copies the line into the set, and returns true if it was not already in it
+	add(line string) bool
+
+	// len returns how many lines are in the set
+	len() int
+
+	// sortedKeys returns the lines of the set as a sorted slice, which can be given back with putKeys.
+	// The set can not be used after.
+	sortedKeys() []string
+
+	// sample returns up to size lines of the set, sorted, to choose the ranges of partitions from
+	sample(size int) []string
+}
+
+// validateCompactSet returns an error if more than one kind of compact set is configured
+func validateCompactSet(opts Options) error {
+	if opts.PrefixSet && opts.HashSet {
+		return fmt.Errorf("a prefix set and a hash set can not be used together")
+	}
+	return nil
+}
+
+// newCompactSet returns an empty set of the kind configured, or nil to use a map. Compact sets are only
+// used on the reading goroutine, unless partition workers add the lines, and only if where lines were
+// read is not tracked, because they copy the lines they add, so that the line read can be given back.
+func (j *job) newCompactSet(pb *partitionBuilder) compactSet {
+	if pb != nil || j.tracking() {
+		return nil
+	}
+	switch {
+	case j.opts.PrefixSet:
+		return newPrefixSet()
+	case j.opts.HashSet:
+		return newHashSet()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,66 @@
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"strconv"
+)
+
+// csvStart returns the header row of FormatCSV or FormatTSV, unless the output file already has
+// content, such as when appending to the output of an earlier run
+func (j *job) csvStart() string {
+	if j.outFile != nil {
+		if info, err := j.outFile.Stat(); err == nil && info.Mode().IsRegular() && info.Size() > 0 {
+			return ""
+		}
+	}
+	if j.opts.Format == FormatTSV {
+		return "line\tcount\tsource\tline_number\n"
+	}
+	return "line,count,source,line_number\n"
+}
+
+// writeCSVRecord writes the record as a row of FormatCSV, quoting the fields that need it. A missing
+// source or line number is an empty field. It returns how many bytes were written.
+func (j *job) writeCSVRecord(w *bufio.Writer, rec jsonRecord) (uint64, error) {
+	b := &j.record
+	b.Reset()
+	lineNumber := ""
+	if rec.LineNumber > 0 {
+		lineNumber = strconv.FormatUint(rec.LineNumber, 10)
+	}
+	cw := csv.NewWriter(b)
+	if err := cw.Write([]string{rec.Line, strconv.FormatUint(rec.Count, 10), rec.Source, lineNumber}); err != nil {
+		return 0, err
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return 0, err
+	}
+
+	// A quoted line break continues the row on the next line
+	j.scriptLines += uint64(bytes.Count(b.Bytes(), []byte("\n")) - 1)
+	n, err := w.Write(b.Bytes())
+	return uint64(n), err
+}
+
+// writeTSVRecord writes the record as a row of FormatTSV, escaping the backslashes, tabs, and line
+// breaks of its fields as the text format of COPY does. A missing source or line number is an empty
+// field. It returns how many bytes were written.
+func (j *job) writeTSVRecord(w *bufio.Writer, rec jsonRecord) (uint64, error) {
+	b := &j.record
+	b.Reset()
+	writeCopyText(b, rec.Line)
+	b.WriteByte('\t')
+	b.WriteString(strconv.FormatUint(rec.Count, 10))
+	b.WriteByte('\t')
+	writeCopyText(b, rec.Source)
+	b.WriteByte('\t')
+	if rec.LineNumber > 0 {
+		b.WriteString(strconv.FormatUint(rec.LineNumber, 10))
+	}
+	b.WriteByte('\n')
+	n, err := w.Write(b.Bytes())
+	return uint64(n), err
+}
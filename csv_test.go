@@ -0,0 +1,85 @@
+package dedup
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunFormatCSV(t *testing.T) {
+	for _, tc := range []struct {
+		format   OutputFormat
+		expected string
+	}{
+		{format: FormatCSV, expected: "line,count,source,line_number\n" +
+			"\"a,\"\"b\"\"\",2,one,1\n" +
+			"\"c\nd\",1,two,1\n" +
+			"e\tf,2,one,2\n"},
+		{format: FormatTSV, expected: "line\tcount\tsource\tline_number\n" +
+			"a,\"b\"\t2\tone\t1\n" +
+			"c\\nd\t1\ttwo\t1\n" +
+			"e\\tf\t2\tone\t2\n"},
+	} {
+		// Lines with line breaks are read with the NUL delimiter, and are verified as the rows they span
+		for _, tmpFileBytes := range []uint64{1000, 1} {
+			outFile, err := os.CreateTemp("", "dedup.test.*.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(outFile.Name())
+			defer outFile.Close()
+
+			input := NewSources(
+				Source{Name: "one", Reader: strings.NewReader("a,\"b\"\x00e\tf\x00")},
+				Source{Name: "two", Reader: strings.NewReader("c\nd\x00a,\"b\"\x00e\tf\x00")},
+			)
+			opts := Options{TmpFileBytes: tmpFileBytes, Format: tc.format, Delimiter: "\x00", VerifyWrites: true}
+			stats, err := Run(outFile, opts, input, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.LinesUnique != 3 || stats.BytesOut != uint64(len(tc.expected)) {
+				t.Fatalf("Unexpected stats with %s and %d tmp file bytes: %+v", tc.format, tmpFileBytes, stats)
+			}
+			content, err := os.ReadFile(outFile.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tc.expected {
+				t.Fatalf("Unexpected output with %s and %d tmp file bytes:\n%s", tc.format, tmpFileBytes, content)
+			}
+
+			// Appending to the output leaves out the header, and rows merged have no source or line number
+			if _, err = outFile.Seek(0, io.SeekEnd); err != nil {
+				t.Fatal(err)
+			}
+			inFile, err := os.CreateTemp("", "dedup.test.*.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(inFile.Name())
+			defer inFile.Close()
+			if _, err = inFile.WriteString("g\n"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = inFile.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = Merge(outFile, Options{Format: tc.format, VerifyWrites: true}, inFile); err != nil {
+				t.Fatal(err)
+			}
+			content, err = os.ReadFile(outFile.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			appended := "g,1,,\n"
+			if tc.format == FormatTSV {
+				appended = "g\t1\t\t\n"
+			}
+			if string(content) != tc.expected+appended {
+				t.Fatalf("Unexpected appended output with %s:\n%s", tc.format, content)
+			}
+		}
+	}
+}
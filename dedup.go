@@ -1,30 +1,35 @@
 // Package dedup (github.com/veqryn/dedup) is a program to remove duplicate strings/URL's.
-// 	Assumptions:
-// 	* Strings/URL's are all valid and UTF-8
-// 	* Duplicate is defined as an exact match
-// 	* Input and output will be new-line delimited files
-// 	* Line count of the file can be greater than 10 billion (>= 1 terrabyte), too large for memory
-// 	* Average string/URL length is around 100 characters
-// 	* Unlimited disk space
+//
+//	Assumptions:
+//	* Strings/URL's are all valid and UTF-8
+//	* Duplicate is defined as an exact match
+//	* Input and output will be new-line delimited files
+//	* Line count of the file can be greater than 10 billion (>= 1 terrabyte), too large for memory
+//	* Average string/URL length is around 100 characters
+//	* Unlimited disk space
+//
+// Run, RunShards, SortChunks, and Merge, configured by Options, are the API of the package, which the
+// dedup executable of github.com/veqryn/dedup/cmd/dedup is built on.
 package dedup
 
 import (
 	"bufio"
 	"bytes"
+	"container/heap"
 	"context"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
-	"math"
 	"os"
 	"regexp"
 	"sort"
-	"sync/atomic"
 	"time"
 )
 
 const defaultBufferSize int = 256 * 1024 // 256 kb
 
-var delimiter byte = "\n"[0]
+const defaultMergeBufferSize int = 4 * 1024 // 4 kb, the bufio default
 
 //
 // Implementation Design:
@@ -47,54 +52,818 @@ var delimiter byte = "\n"[0]
 // need to split the input file into chunks or create any temporary files.
 //
 
+// Options configures a deduplication run
+type Options struct {
+	// TmpFileBytes is the approximate maximum byte size of the distinct lines held in memory,
+	// before they are spilled to a temporary file.
+	TmpFileBytes uint64
+
+	// MemoryBytes is a budget for the heap of the whole process, if positive. As the set being read
+	// grows, the heap in use is read from the runtime every so often, and once it is at or over the
+	// budget, even after waiting for the chunks being written and collecting the garbage, the set
+	// is spilled, whatever its size. TmpFileBytes still applies, and defaults to a quarter of
+	// MemoryBytes. The heap does not include the memory of the runtime itself, and stacks, so
+	// leave some room. If the rest of the process already uses most of the budget, the chunks are
+	// small. With BuildWorkers, only TmpFileBytes applies.
+	MemoryBytes uint64
+
+	// AutoMemory replaces the MemoryBytes budget before each set is read with the heap in use plus
+	// half the memory available on the system, so that the chunks grow and shrink with the free
+	// memory. MemoryBytes, if positive, caps it, and is used as is where the available memory can
+	// not be read, as it is only read on linux. Without TmpFileBytes, each set is a quarter of the budget.
+	AutoMemory bool
+
+	// Budget, if not nil, caps the bytes of the distinct lines held in memory by this and every other
+	// run given it, all together, so that runs in the same process, such as one per tenant, stay under
+	// a global cap. TmpFileBytes and MemoryBytes still apply to each. With BuildWorkers, it does not apply.
+	Budget *Budget
+
+	// SpillGCFraction spills the set being read early, whatever its size, once the garbage collector
+	// paused the process for more than this fraction of the time, such as 0.2, over a window of at least
+	// a second. The pauses grow as the heap nears what the system can give it, so this spills before
+	// the process is killed for running out of memory. It is checked as often as MemoryBytes, or every
+	// MiB of lines without a budget. Zero disables it. With BuildWorkers, it is not checked.
+	SpillGCFraction float64
+
+	// SpillAvailableBytes spills the set being read early, whatever its size, once the memory available
+	// on the system falls below it, such as when other processes start using more, checked like
+	// SpillGCFraction. The available memory is only read on linux. Zero disables it.
+	SpillAvailableBytes uint64
+
+	// AdaptiveChunks makes TmpFileBytes a budget for the memory of each set, rather than for the bytes
+	// of its lines. The average length of the distinct lines is tracked as the input is read, and
+	// the set is spilled once its lines and the overhead of each in the set reach TmpFileBytes,
+	// so that the memory of a chunk stays about the same whether its lines are 20 bytes or 2 KB.
+	// The overhead is an estimate, and the chunks are smaller, more so the shorter the lines.
+	AdaptiveChunks bool
+
+	// PrefixSet holds the distinct lines of each set in a radix tree rather than a map, so that lines
+	// sharing long prefixes, such as URLs, only store the bytes after what they share, and makes
+	// TmpFileBytes a budget for the memory of the tree rather than for the bytes of its lines. Far
+	// more such lines fit in each set, so there are fewer and larger chunks to merge, though looking
+	// lines up is slower than in a map. It is ignored with BuildWorkers, and if where lines were read
+	// is tracked, for counts of duplicates, audits, line maps, or JSON output.
+	PrefixSet bool
+
+	// HashSet holds the distinct lines of each set in a slice, and finds them by their 64-bit hash in an
+	// open addressing table rather than in a map, with the rare lines whose hash is the same as another
+	// line's in a small map of their own. Each line then uses about 36 bytes besides its own bytes,
+	// rather than about 48, in a few allocations without pointers for the garbage collector to scan.
+	// It can not be used with PrefixSet, and is ignored in the same cases.
+	HashSet bool
+
+	// TempDir is the directory temporary chunk files are written to. If empty, os.TempDir is used,
+	// which is $TMPDIR on unix, and on windows the first of %TMP%, %TEMP%, and %USERPROFILE% that is set.
+	// It must already exist and be writable.
+	TempDir string
+
+	// KeepTempFiles leaves the temporary chunk files in place once the run is done, even if it failed,
+	// so that they can be inspected, or merged later with Merge. Their paths are returned in Stats.
+	KeepTempFiles bool
+
+	// ExpectUnique, if not nil, is called with the number of unique lines once they have all been written,
+	// and the error it returns fails the run, such as when an input has far more or fewer unique lines
+	// than it usually does. The temporary chunk files are then kept, as with KeepTempFiles, to investigate.
+	// It is not called if the run is Incomplete.
+	ExpectUnique func(linesUnique uint64) error
+
+	// Checkpoint, if not empty, is the path of a manifest of the temporary chunk files written so far,
+	// with their checksums and the offset of the input they hold every line before, which is replaced
+	// each time another chunk has been written. If the run fails, the chunks in it are left in place, so
+	// that it can be resumed with Resume, such as once there is room again after a *DiskSpaceError.
+	// The manifest is removed once the run succeeds. It can not be used with BuildWorkers, ReadWorkers,
+	// PassthroughUnkept, or passthrough rules, or when tracking where lines were read, with
+	// OnDuplicateCount, OnAudit, OnLineMapped, or a format other than FormatText.
+	Checkpoint string
+
+	// Resume continues a run from the manifest at Checkpoint, if it exists, instead of from the start
+	// of the input, by checking and reusing its chunks, and skipping the part of the input they hold.
+	// The input, TempDir, and every option that changes which lines are kept must be the same as when
+	// the manifest was written. Without the manifest, the run starts from the beginning.
+	Resume bool
+
+	// MinFreeBytes, if not zero, is the space to leave free on the filesystems of the temporary files
+	// and the output file of Run. Before starting, the run fails if either does not have room for the
+	// size of the input, if known, which is the most the temporary files or the output can take, plus
+	// this much. While running, it fails once either has less than this much free, checked before each
+	// temporary file is created and every 64 MiB written to the output, rather than once the filesystem
+	// is full. The error is a *DiskSpaceError. Free space is only checked on linux.
+	MinFreeBytes uint64
+
+	// MaxTmpBytes, if not zero, caps the total bytes of the temporary chunk files written while reading
+	// the input, so that a run fails, with an error wrapping ErrMaxTmpBytes, rather than fill the temp
+	// dir. A cascaded merge with MergeFanIn also needs room for the files of the group being merged.
+	MaxTmpBytes uint64
+
+	// ChecksumTempFiles computes the CRC-32 checksum of each temporary chunk file as it is written, and
+	// verifies it once the file has been read back while merging, so that corruption of the temporary
+	// files, such as on flaky storage, fails the run rather than silently corrupting the output.
+	ChecksumTempFiles bool
+
+	// EncryptTempFiles encrypts the temporary chunk files, and the file of lines passed through, with
+	// AES-256-GCM, using a random key that is only ever held in memory, for when the temp dir is less
+	// trusted than the input and output. The files can then only be read by the run that wrote them,
+	// so it can not be used with KeepTempFiles or Checkpoint, and MergeMmap reads them instead.
+	EncryptTempFiles bool
+
+	// IORetries is how many times to try again to read the input or the temporary files, or to write the
+	// temporary files, after an error that IsTransient, such as EIO or ESTALE on a network filesystem,
+	// before failing the run with it. A warning is sent for each retry.
+	IORetries int
+
+	// IORetryBackoff is how long to wait before the first retry, which doubles for each after it.
+	// If zero, it is 100 milliseconds.
+	IORetryBackoff time.Duration
+
+	// ReadBufferSize is the byte size of the buffer for reading the input file, which is also the
+	// maximum line length (but at least 64 KB). Defaults to 256 KB.
+	// High latency network filesystems benefit from larger reads.
+	ReadBufferSize int
+
+	// WriteBufferSize is the byte size of the buffer for writing the output and temporary files.
+	// Defaults to 256 KB.
+	WriteBufferSize int
+
+	// MergeBufferSize is the initial byte size of the buffer for reading each chunk while merging,
+	// which grows as needed for long lines. Defaults to 4 KB, because there may be many chunks
+	// open at the same time.
+	MergeBufferSize int
+
+	// MergeFanIn is the most chunks merged at once, if positive. With more chunks than that, they are
+	// closed once written and merged MergeFanIn at a time into intermediate temporary files, in as
+	// many passes as needed, so that no more than MergeFanIn chunk files (and as many meta files)
+	// are open at once, for each of the Partitions. Each pass reads and writes every line again.
+	// Defaults to merging every chunk at once, or as many as fit in MaxOpenFiles. It is ignored by
+	// SortChunks and Merge.
+	MergeFanIn int
+
+	// MaxOpenFiles is the budget of files the process may have open at once. Unless MergeFanIn is set,
+	// it is set to as many chunks (and their meta files) as fit in the budget, after the files the
+	// process already has open, such as the input and output, and a few more for the run's other files,
+	// so that a run with many chunks merges them in a cascade rather than failing with "too many open
+	// files". Defaults to the limit of the process on open files (RLIMIT_NOFILE), where there is one.
+	// If negative, there is no budget.
+	MaxOpenFiles int
+
+	// MergeMmap memory maps each chunk file while merging, where supported, and reads its lines
+	// straight from memory, instead of through a buffer of MergeBufferSize. This saves a system call
+	// for every buffer, and a copy of every line, which adds up with many chunks. The pages of the
+	// files that were read count towards the memory of the process until each file has been merged,
+	// though the kernel can drop them again under memory pressure. A file that can not be mapped,
+	// or any file on Windows, is read through a buffer instead.
+	MergeMmap bool
+
+	// FileAdvice advises the kernel that the input files, and the chunk files while merging, are read
+	// from start to end, and to drop the input files from the page cache as they are read, and the
+	// output files once written, since they are not read again. This keeps a huge run from evicting
+	// the page cache of the other processes on a shared host. It is only supported on linux, on amd64
+	// and arm64, and does nothing elsewhere, or for inputs that are not files. Advice that fails is ignored.
+	FileAdvice bool
+
+	// PreallocateOutput allocates the space the merged output is estimated to need in the output file
+	// of Run before merging, on linux, which keeps the largest write of the run from fragmenting. The
+	// estimate is the bytes of the chunks, and the space that is not needed is given back once merged.
+	// The output file must be written from its end, such as a new file, or it is written as usual.
+	PreallocateOutput bool
+
+	// OutputMmap preallocates the output file like PreallocateOutput, and writes the merged output into
+	// a memory mapping of that space instead of through system calls, where supported. The output file
+	// must be open for reading and writing. Anything past the estimate is written to the file as usual.
+	OutputMmap bool
+
+	// Delimiter separates the lines of the input, and ends each line of the output. It can be any
+	// string, such as "\x00" or "\r\n". Defaults to a new line, with input lines ending in "\r\n"
+	// also accepted (and written with only a new line).
+	Delimiter string
+
+	// CRLF ends each line of the output with "\r\n", as is usual on windows, while still accepting input
+	// lines ending in either "\n" or "\r\n". Temporary files are written with only a new line.
+	// It is ignored if a Delimiter is set.
+	CRLF bool
+
+	// NoFinalLineEnding leaves the line ending, of Delimiter or CRLF, off the last line of the output, so
+	// that it byte-matches what parsers and tests that do not expect a final line ending read. Only
+	// FormatText is supported with it, and not OutputMmap. With RunShards, it is left off each shard.
+	NoFinalLineEnding bool
+
+	// SkipLines is the number of lines at the start of the input to ignore, such as a header,
+	// or the lines of earlier slices when deduplicating a large file in coordinated slices.
+	// Ignored lines are not counted in Stats.
+	SkipLines uint64
+
+	// MaxLines is the maximum number of input lines to process after SkipLines, if positive.
+	// The rest of the input is not read.
+	MaxLines uint64
+
+	// Context, if not nil, cancels the run once it is done, such as when its deadline passes. The run
+	// then returns the error of the context, leaving whatever was written to the output incomplete.
+	Context context.Context
+
+	// FlushOnCancel stops reading the input once the Context is done, and still merges the lines read
+	// before then into the output, rather than discarding the work done so far. The output is sorted and
+	// deduplicated, but is missing the lines of the rest of the input, and Stats.Incomplete is set.
+	// The run then returns no error.
+	FlushOnCancel bool
+
+	// SkipPatterns cause any line matching at least one of the patterns to be skipped
+	SkipPatterns []*regexp.Regexp
+
+	// KeepPatterns, if any, cause only the lines matching at least one of the patterns to be
+	// deduplicated. The other lines are skipped, unless PassthroughUnkept. Skip patterns win.
+	KeepPatterns []*regexp.Regexp
+
+	// Rules are evaluated in order before the skip and keep patterns, and the first rule matching
+	// a line decides whether it is skipped, deduplicated, passed through, or transformed.
+	Rules []Rule
+
+	// Transform, if not nil, rewrites each line that is deduplicated, after the rules and patterns,
+	// such as to normalize or redact it. It must always return the same for the same line.
+	Transform func(line string) string
+
+	// TransformMode is which of a line as read, and as transformed by Transform and the transform rules,
+	// it is deduplicated by, and which is written. Defaults to TransformLines, which deduplicates and
+	// writes the transformed lines. TransformKeys writes the first line read of each instead, and
+	// TransformOutput deduplicates the lines as read, and writes them transformed. Both keep the text
+	// written with every line of the chunks, like OnDuplicateCount does, which counts towards
+	// TmpFileBytes, and are not supported by SortChunks and RunShards, or with Checkpoint or OnIndex.
+	// Callbacks are given the lines as they are deduplicated.
+	TransformMode TransformMode
+
+	// StripTimestamp, if not nil, is the pattern of the timestamp lines begin with, such as one of
+	// TimestampPattern, which is left out of the key each line is deduplicated by, along with the spaces
+	// after it, so that lines that only differ in when they were logged are duplicates. The first line read
+	// of each key is written, as with Key. Lines not beginning with a match are deduplicated whole.
+	StripTimestamp *regexp.Regexp
+
+	// StripANSI leaves the ANSI escape sequences of the lines, such as the colors and formatting of a terminal,
+	// out of the key each line is deduplicated by, before any timestamp is stripped, so that the lines of a
+	// colored log capture are duplicates of their plain counterparts. The first line read of each key is
+	// written, as with Key, unless StripANSIOutput.
+	StripANSI bool
+
+	// StripANSIOutput strips the ANSI escape sequences from the lines before they are transformed, as if by
+	// Transform, so that they are deduplicated and written without them, and the output stays sorted.
+	StripANSIOutput bool
+
+	// KeyFields, if any, deduplicates the lines by only these fields of them, once any timestamp is
+	// stripped, selected as cut -f does: in the order of the line, separated by KeyDelimiter, whichever
+	// ranges they are in. Lines without the delimiter are deduplicated whole. The first line read of
+	// each key is written, as with Key.
+	KeyFields []FieldRange
+
+	// KeyDelimiter separates the fields of KeyFields. Defaults to a tab.
+	KeyDelimiter string
+
+	// KeyPrefixBytes, if positive, deduplicates the lines by their first KeyPrefixBytes bytes, once
+	// any timestamp is stripped and fields selected, such as to collapse records sharing a key
+	// field at a fixed offset. The first line read of each prefix is written, as with Key. Shorter
+	// lines are deduplicated whole.
+	KeyPrefixBytes int
+
+	// Key, if not nil, returns the key each line that is deduplicated is deduplicated by, once
+	// transformed, and once the other keys, such as StripTimestamp, are taken of it, such as a
+	// field of it, so that only the first line read of each key is written. Like TransformKeys, the
+	// text written is kept with every line of the chunks, and it is not supported by SortChunks and
+	// RunShards, or with Checkpoint or OnIndex, unless WriteKeys. It must always return the same
+	// for the same line. Callbacks are given the keys.
+	Key func(line string) string
+
+	// WriteKeys writes the key each line is deduplicated by, of Key, or as transformed with TransformKeys,
+	// rather than the first line read of each, so that the output is the sorted set of the keys, such as
+	// of the normalized lines
+	WriteKeys bool
+
+	// PatternsMatchKeys matches the SkipPatterns and KeepPatterns against the key each line is
+	// deduplicated by, once transformed and keyed, such as its KeyFields, rather than against the line as
+	// read, so that filtering and keying compose. Rules still match the lines as read, and the lines
+	// passed through by PassthroughUnkept are still written as read.
+	PatternsMatchKeys bool
+
+	// PassthroughUnkept writes the lines not matching any KeepPatterns to the output unchanged,
+	// including their duplicates, in the order they were read, after the deduplicated lines.
+	// It is not supported by SortChunks, which has no output.
+	PassthroughUnkept bool
+
+	// OnProgress is called with the progress of each phase of the run that is in progress,
+	// every ProgressInterval, and once more as each phase finishes. It is never called concurrently.
+	// If nil, progress is logged to Logger, or printed without it.
+	OnProgress func(Progress)
+
+	// ProgressInterval is how often progress is reported. Defaults to once a minute.
+	ProgressInterval time.Duration
+
+	// CountLines counts the lines of the input as it is read, so that the progress of splitting is
+	// tracked in lines rather than bytes, without being given inFileAgain. The input, or each of its
+	// Sources, must be a regular file, or an io.ReaderAt that is also an io.Seeker, such as a
+	// bytes.Reader or an io.SectionReader, which is read a second time at its offsets rather than
+	// opened twice. Otherwise, progress is tracked in bytes.
+	CountLines bool
+
+	// OnEvent is called with the events of the run, such as the start and end of each phase and
+	// the creation of each temporary file. It is never called concurrently, even with OnProgress.
+	// If nil, each event is logged to Logger, or its message printed without it.
+	OnEvent func(Event)
+
+	// OnDone, if not nil, is called once by Run, Merge, and RunShards as they return, with the Stats
+	// and error they return, so that whatever is waiting on the run can be notified, whether it
+	// succeeded or failed.
+	OnDone func(stats Stats, err error)
+
+	// Logger, if not nil, is logged to with the events and progress of the run, as records with
+	// attributes, instead of printing their messages, unless OnEvent or OnProgress are set.
+	// A *slog.Logger can be given as is.
+	Logger Logger
+
+	// Limit stops the output after this many unique lines, if positive. Because the output is sorted,
+	// these are the first lines of the sorted and deduplicated input. The input is still fully read.
+	Limit uint64
+
+	// OnDuplicate is called with each line that is removed as a duplicate, as soon as it is found.
+	// Duplicates within a chunk are found while splitting, and those between chunks while merging,
+	// so the lines are not in any particular order. If it returns an error, the run stops with it.
+	OnDuplicate func(line string) error
+
+	// OnDuplicateAt is called like OnDuplicate, with where the line removed as a duplicate was read, such
+	// as to count the duplicates of each source as the run goes. The position of duplicates found while
+	// merging is only known if where lines were first read is tracked, for OnAudit, OnLineMapped, formats of
+	// records, or keyed lines, and never with ReadWorkers or by Merge. Otherwise it is the zero Position.
+	OnDuplicateAt func(line string, pos Position) error
+
+	// OnDuplicateCount is called once for each line that was read more than once, with the number
+	// of times it was read, in sorted order as the line is written to the output. Counting the
+	// occurrences of the lines of each chunk needs a small temporary meta file per chunk.
+	// If it returns an error, the run stops with it.
+	OnDuplicateCount func(line string, count uint64) error
+
+	// CountSketch, if not nil, counts every line deduplicated as it is read, for approximate counts of
+	// how many times each line was read in the fixed memory of the sketch, without the meta files of
+	// OnDuplicateCount. SortChunks adds to it too, for the counts of a later Merge.
+	CountSketch *CountSketch
+
+	// OnSketchCount is called with each unique line and its count in CountSketch, in sorted order as the
+	// line is written to the output, including lines read only once. If it returns an error, the run
+	// stops with it. It is not supported by SortChunks.
+	OnSketchCount func(line string, count uint64) error
+
+	// Seen, if not nil, is an index of the lines written by earlier runs. Lines in it are skipped as they
+	// are read, and once the run succeeds, the unique lines it wrote are added to it, so that lines are
+	// only ever written by one run given it. It is not supported by SortChunks or Merge.
+	Seen *SeenSet
+
+	// Reference, if not nil, is a sorted and deduplicated file, such as the output of an earlier run, whose
+	// lines are skipped as they are read, as those of Seen are, so that only the lines not already in it
+	// are written. It is not supported by SortChunks or Merge.
+	Reference *SortedFile
+
+	// Update, if not nil, is a sorted and deduplicated file, such as the output of an earlier run, that the
+	// output is an updated copy of: its lines are merged with the unique lines of the input as one more chunk,
+	// so that the output is the union of both, without deduplicating the input of the earlier runs again.
+	// The lines of the input already in it count as duplicates. The output must be another file, such as one
+	// renamed over it once the run succeeds. It is read from its start, and is not closed. It is only supported
+	// with the text format, and not with Partitions, PassthroughUnkept or passthrough rules, or keys written as
+	// other than themselves, or by RunShards, and is ignored by SortChunks, and by Merge, which can merge it as it is.
+	Update *os.File
+
+	// OnIndex, if not nil, is called with every IndexInterval-th unique line as it is written to the
+	// output, starting with the first, and the offset of the output it starts at, counting from the
+	// first byte written by the run, such as to write a sidecar index with IndexWriter. It is only
+	// supported with the text format, and not with partitions, shards, or passed through lines.
+	// It is not supported by SortChunks.
+	OnIndex func(line string, offset uint64) error
+
+	// IndexInterval is how many unique lines are written for each line OnIndex is called with.
+	// If zero, it is 1024.
+	IndexInterval int
+
+	// OnAudit is called with each line that is removed as a duplicate, where it was read, and where
+	// the line it duplicates was first read, in sorted order as the lines are merged. The position of
+	// every duplicate in a chunk counts towards TmpFileBytes. If it returns an error, the run stops
+	// with it. It is not supported by SortChunks or Merge.
+	OnAudit func(Duplicate) error
+
+	// OnLineMapped is called with the position of every line read, and the line number it was
+	// written to in the output, or zero if it was removed as a duplicate, skipped, or past the Limit.
+	// The lines are not in any particular order. If it returns an error, the run stops with it.
+	// It is not supported by SortChunks, Merge, or RunShards.
+	OnLineMapped func(pos Position, outputLine uint64) error
+
+	// Format is the format of the unique lines written to the output. Defaults to FormatText.
+	// Only FormatText is supported by RunShards, and FormatSQLite, FormatPostgres, and FormatRedis
+	// are not supported with PassthroughUnkept or passthrough rules. If the input is Sources, the records have
+	// the name of the source each line was first read from.
+	Format OutputFormat
+
+	// Table is the table FormatSQLite and FormatPostgres insert the unique lines into.
+	// Defaults to "lines".
+	Table string
+
+	// PostgresBatchLines is how many unique lines FormatPostgres copies into a temporary table at a
+	// time, before inserting them into the Table. Defaults to 100000.
+	PostgresBatchLines int
+
+	// RedisKey is the key of the set FormatRedis adds the unique lines to. Defaults to "lines".
+	RedisKey string
+
+	// RedisBatchLines is how many unique lines each command of FormatRedis adds to the set.
+	// Defaults to 1000.
+	RedisBatchLines int
+
+	// RedisTTL, if positive, is how long until the set of FormatRedis expires, from the end of the run.
+	RedisTTL time.Duration
+
+	// ParquetRowGroupBytes is about how many bytes of lines and sources each row group of
+	// FormatParquet holds in memory until it is written. Defaults to 32 MiB.
+	ParquetRowGroupBytes int
+
+	// ArrowBatchLines is how many unique lines each record batch of FormatArrow and FormatArrowStream
+	// holds in memory until it is written. Defaults to 65536.
+	ArrowBatchLines int
+
+	// ShardBy decides which output file of RunShards each unique line is written to.
+	// Defaults to ShardHash.
+	ShardBy ShardMode
+
+	// Partitions splits the lines of every chunk by PartitionBy into this many partitions as it is
+	// spilled, if more than 1. The chunks of each partition are then merged on their own goroutine,
+	// in parallel, and the partitions are concatenated in order. With RunShards, the shards are the
+	// partitions, partitioned by ShardBy, and each remains fully sorted. It is not supported with
+	// Limit or OnLineMapped, and is ignored by SortChunks and Merge.
+	Partitions int
+
+	// PartitionBy decides which partition each line is in, with Partitions. By ShardHash, the
+	// default, it is the FNV-1a hash of the line, so the output is only sorted within each partition.
+	// By ShardRange, each partition is a contiguous range of the sorted lines, chosen from a sample
+	// of the first chunk, so the output is fully sorted, but the partitions are only as even as the
+	// first chunk is like the rest of the input.
+	PartitionBy ShardMode
+
+	// BuildWorkers is how many goroutines add the lines read to sets, if more than 1. Each line is
+	// added by the worker of its hash partition, which has its own set of up to an equal share of
+	// TmpFileBytes, and spills it to its own chunks. The reading goroutine still reads and filters
+	// every line, and calls the callbacks. Defaults to 1, adding the lines on the reading goroutine.
+	BuildWorkers int
+
+	// SortWorkers is how many full chunks can be sorted and written to their temporary files on worker
+	// goroutines at once, while the input keeps being read into the next chunk. Each of them holds
+	// up to TmpFileBytes of lines in memory until it is written. Defaults to 1. If negative, each
+	// chunk is sorted and written on the reading goroutine, pausing the reading until it is done.
+	SortWorkers int
+
+	// Workers is the most goroutines that sort chunks, add lines to sets, or merge partitions at once, so
+	// that a run can be held to a budget of CPUs. SortWorkers and BuildWorkers are lowered to it, and only
+	// that many Partitions are merged at once. Defaults to GOMAXPROCS.
+	Workers int
+
+	// ReadWorkers is how many of the sources are read at once, each on a goroutine of its own, if the
+	// input is Sources and it is more than 1, so that the bandwidth of several disks or connections is
+	// used together. The lines of the sources are then interleaved in no particular order, which is
+	// why SkipLines, MaxLines, formats other than FormatText, OnAudit, OnLineMapped, and writing the first line read of
+	// each key, such as with Key or TransformKeys unless WriteKeys, are not supported with it, and the
+	// final line of each source ends with it. Without filters, OnDuplicate, OnDuplicateCount, or a
+	// CountSketch, each worker also drops the duplicates of the lines it has just read, before they are read by the run.
+	ReadWorkers int
+
+	// ReadAhead is how many buffers of ReadBufferSize of the input are read on a goroutine of their
+	// own, ahead of the lines being scanned from them, so that reading the input overlaps with adding
+	// its lines to the sets. Defaults to 2. If negative, the input is read on the reading goroutine,
+	// as the lines are scanned. The input may still be read from for a moment once the run returns.
+	ReadAhead int
+
+	// Sync syncs the output file, and each temporary file, to storage once it has been written, so that
+	// the output is durable once the run returns. Otherwise it is left to the operating system to write
+	// back, which is faster.
+	Sync bool
+
+	// SyncBytes, if not zero, also syncs the output and temporary files every time this many more bytes
+	// have been written to them, so that the data waiting to be written back never builds up, such as on
+	// network file systems that would otherwise stall writing all of it at once when the file is closed.
+	// How much is written to them at a time is WriteBufferSize.
+	SyncBytes uint64
+
+	// VerifyWrites reads back each temporary chunk file once it has been written, and the output file
+	// once the run is done, and fails the run unless each has the lines and CRC-32 checksum that were
+	// written to it, with the deduplicated lines sorted and unique, for when a silently bad output is
+	// worse than a slow run. The output file must be open for reading, and the output of RunShards
+	// is not read back.
+	VerifyWrites bool
+
+	// Durable syncs the output file and each temporary file like Sync, and also the directory of each,
+	// so that after a machine crash the files are not missing, or present but with their contents lost.
+	// The checkpoint manifest is synced along with its directory each time it is replaced.
+	Durable bool
+
+	// MaxReadBytesPerSecond, if not zero, limits how fast the input, and the temporary files as they are
+	// merged, are read, all together, so that a run on shared storage leaves its bandwidth for other work.
+	// Chunks read from memory mappings, with MergeMmap, are not limited.
+	MaxReadBytesPerSecond uint64
+
+	// MaxWriteBytesPerSecond, if not zero, limits how fast the output and the temporary files are written,
+	// all together
+	MaxWriteBytesPerSecond uint64
+
+	// Metrics, if not nil, are updated with the live counters of the run
+	Metrics *Metrics
+
+	// Tracer, if not nil, starts a span for the run, as a child of any span of Context, with a span
+	// for each of its phases, and for each chunk written, with the lines and bytes of each
+	Tracer Tracer
+
+	// DryRun performs the full deduplication without writing anything to the output file,
+	// which may be nil. Temporary files are still written, because they are
+	// needed to find the duplicates between chunks. The returned Stats report what would have
+	// been written to the output file.
+	DryRun bool
+
+	// CountOnly is a DryRun for audits that only need the line counts of Stats: the unique lines
+	// are counted as they are merged, without being formatted or written anywhere, and the lines
+	// passed through are not kept in a temporary file. BytesOut is not counted, and Format, OnIndex,
+	// and NoFinalLineEnding are ignored. With RunShards, it is a DryRun, to count the lines of each shard.
+	CountOnly bool
+}
+
+// Stats summarize a deduplication run
+type Stats struct {
+	// LinesRead is the number of lines read from the input file
+	LinesRead uint64
+
+	// BytesRead is the number of bytes of the lines read from the input file, each counted with a new line
+	BytesRead uint64
+
+	// LinesSkipped is the number of lines read that matched a skip pattern, or no keep pattern
+	LinesSkipped uint64
+
+	// LinesSeen is the number of lines read that were skipped because they are in the Seen set or the
+	// Reference file
+	LinesSeen uint64
+
+	// LinesPassedThrough is the number of lines read that were written to the output unchanged
+	LinesPassedThrough uint64
+
+	// RuleLines is the number of lines each of the Rules decided, being the first rule they matched, in
+	// the order of the rules, so that rules that never match can be found. Nil without rules.
+	RuleLines []uint64
+
+	// SkipPatternLines is the number of lines each of the SkipPatterns skipped, being the first skip
+	// pattern they matched, in the order of the patterns. Nil without skip patterns.
+	SkipPatternLines []uint64
+
+	// KeepPatternLines is the number of lines each of the KeepPatterns kept, being the first keep pattern
+	// they matched, in the order of the patterns. Nil without keep patterns.
+	KeepPatternLines []uint64
+
+	// LinesDuplicate is the number of lines read that were removed as duplicates.
+	// If the output was Limited, only the duplicates of lines up to the limit are counted.
+	LinesDuplicate uint64
+
+	// LinesUnique is the number of distinct lines written to the output file
+	LinesUnique uint64
+
+	// BytesOut is the number of bytes written to the output file
+	BytesOut uint64
+
+	// Limited is true if there were more unique lines than the Limit option, which were not written
+	Limited bool
+
+	// Incomplete is true if the input was not read to its end, because the Context of the run was done.
+	// With FlushOnCancel, the output holds the lines read before then.
+	Incomplete bool
+
+	// Chunks is the number of temporary files written
+	Chunks int
+
+	// PressureSpills is the number of sets spilled early under memory pressure, with SpillGCFraction
+	// or SpillAvailableBytes
+	PressureSpills int
+
+	// TmpLines is the total number of lines written to temporary files
+	TmpLines uint64
+
+	// TmpBytes is the total byte size of the temporary files, all of which exist at the same time
+	// while they are being merged
+	TmpBytes uint64
+
+	// ChunkLines is the number of lines written to each temporary file of the input, in the order they
+	// were written, to see how evenly it was split. Those written by cascading merges are not included.
+	ChunkLines []uint64
+
+	// SortElapsed is how long the sets of the chunks took to sort in total, on whichever goroutines
+	// they were sorted on
+	SortElapsed time.Duration
+
+	// SpillWait is how long reading the input waited for a full chunk to be sorted and written, because
+	// SortWorkers chunks were already being sorted and written. If it is much of the splitting phase,
+	// more sort workers would keep the input being read while the chunks are.
+	SpillWait time.Duration
+
+	// TmpFiles are the paths of the temporary files, if they were kept with KeepTempFiles
+	TmpFiles []string
+
+	// ShardLines is the number of lines written to each output file of RunShards, including
+	// any passed through lines
+	ShardLines []uint64
+
+	// Elapsed is the duration of the whole run
+	Elapsed time.Duration
+
+	// PhaseElapsed is the duration of each phase of the run that finished
+	PhaseElapsed map[Phase]time.Duration
+}
+
 // Dedup is given a file to write to, a file to read from, and the temporary file size
 // for when it needs to spill to disk. It will de-duplicate strings/URL's by reading the input file
 // into a set, and writing out the set to a temporary file each time the set approaches tmpFileBytes
 // in size. It will then merge the temporary files while deduplicating the lines, into the final file.
+//
+// Deprecated: Use Run, which is configured by Options and returns the Stats of the run.
 func Dedup(outFile *os.File, tmpFileBytes uint64, skipPatterns []*regexp.Regexp, inFile, inFileAgain io.Reader) error {
-	// Allow cancellation of progress tracker
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	_, err := Run(outFile, Options{TmpFileBytes: tmpFileBytes, SkipPatterns: skipPatterns}, inFile, inFileAgain)
+	return err
+}
+
+// Run is the same as Dedup, but is configured by Options and returns the Stats of the run.
+// If inFileAgain is not nil, it must read the same content as inFile, and is used to count
+// the lines for progress tracking, as CountLines does without it. Otherwise, the progress of
+// splitting is tracked in bytes, if the size of inFile is known, such as that of a file, an
+// io.Seeker, or a reader with a Len or Size method, which saves reading it twice.
+func Run(outFile *os.File, opts Options, inFile, inFileAgain io.Reader) (Stats, error) {
+	opts = countOnly(opts)
+	if err := checkRun(opts); err != nil {
+		return Stats{}, err
+	}
+
+	j, ctx, done := newJob(opts, opts.TempDir)
+	defer done()
+	j.startTrace("dedup.run")
+
+	// A dry run discards everything that would have been written to the output file
+	var out io.Writer = io.Discard
+	if !opts.DryRun {
+		out = j.sumOutput(j.syncWriter(outFile))
+		j.outFile = outFile
+	}
+	final := j.withoutFinalLineEnding(out)
+	if final != nil {
+		out = final
+	}
+	err := j.dedup(ctx, j.watchDisk(j.limitWriter(out)), inFile, inFileAgain)
+	if err == nil {
+		err = final.finish(&j.stats)
+	}
+	if !opts.DryRun {
+		if err == nil {
+			err = j.syncFile(outFile)
+		}
+		if err == nil {
+			err = j.verifyOutput(outFile)
+		}
+		j.adviseWritten(outFile)
+	}
+	err = j.finishSeen(err)
+	return j.summarize(err), err
+}
 
-	// Get the number of lines in the file, to track progress
-	var progress uint64
+// countOnly returns the options of a DryRun that only counts the lines, with CountOnly
+func countOnly(opts Options) Options {
+	if opts.CountOnly {
+		opts.DryRun = true
+		opts.Format = FormatText
+		opts.OnIndex = nil
+		opts.NoFinalLineEnding = false
+	}
+	return opts
+}
+
+// checkRun returns an error if the options of Run or RunShards are invalid, or the temporary
+// files could not be written
+func checkRun(opts Options) error {
+	if err := validateRules(opts.Rules); err != nil {
+		return err
+	}
+	if err := validateTransform(opts); err != nil {
+		return err
+	}
+	if err := validateKey(opts); err != nil {
+		return err
+	}
+	if err := validateFormat(opts); err != nil {
+		return err
+	}
+	if err := validateLineEnding(opts); err != nil {
+		return err
+	}
+	if err := validatePartitions(opts); err != nil {
+		return err
+	}
+	if err := validateReadWorkers(opts); err != nil {
+		return err
+	}
+	if err := validateCompactSet(opts); err != nil {
+		return err
+	}
+	if err := validateSeen(opts); err != nil {
+		return err
+	}
+	if err := validateIndex(opts); err != nil {
+		return err
+	}
+	if err := validateCheckpoint(opts); err != nil {
+		return err
+	}
+	if err := validateEncryption(opts); err != nil {
+		return err
+	}
+	if err := validateCancel(opts); err != nil {
+		return err
+	}
+	if err := validateMaxOpenFiles(opts); err != nil {
+		return err
+	}
+	if err := validateMemoryPressure(opts); err != nil {
+		return err
+	}
+	if err := validateUpdate(opts); err != nil {
+		return err
+	}
+	if opts.MergeFanIn == 1 {
+		return fmt.Errorf("merge fan-in must be at least 2")
+	}
+
+	// Fail before doing any work if the temporary files could not be written
+	if opts.TempDir != "" {
+		if err := CheckDir(opts.TempDir); err != nil {
+			return fmt.Errorf("invalid temp dir: %w", err)
+		}
+	}
+	return nil
+}
+
+// dedup splits, sorts, deduplicates, and merges the input into the output writer
+func (j *job) dedup(ctx context.Context, out io.Writer, inFile, inFileAgain io.Reader) (err error) {
+	j.budgetFiles()
+	opts := j.opts
+	j.inputSize, _ = inputSize(inFile)
+	if err = j.checkDiskSpace(); err != nil {
+		return err
+	}
+	defer func() {
+		err = j.diskFullError(err)
+	}()
+
+	// Get the size of the input, and the number of lines in it if it can be read again, to track progress
+	if inFileAgain == nil && opts.CountLines {
+		inFileAgain, _ = readAgain(inFile)
+	}
+	splitting := j.startSplitting(inFile)
 	if inFileAgain != nil {
+		counting := j.begin(PhaseCounting)
 		go func() {
-			goal, countErr := countLines(inFileAgain)
+			goal, countErr := countLines(inFileAgain, j.opts.ReadBufferSize, j.delim, counting)
 			if countErr != nil {
-				fmt.Println("Error counting lines")
-				return
-			}
-			fmt.Println("Finished counting lines:", goal)
-			goal *= 2 // Have to write or ignore every line we've read
-			digits := int(math.Floor(math.Log10(float64(goal)) + 1))
-			ticker := time.NewTicker(60 * time.Second)
-			defer ticker.Stop()
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-ticker.C:
-					prog := atomic.LoadUint64(&progress)
-					fmt.Printf("Progress: %*d/%d=%d%%\n", digits, prog, goal, prog*100/goal)
+				// The run may have finished first, and its caller closed the file
+				if ctx.Err() == nil {
+					j.event(Event{Kind: EventWarning, Phase: PhaseCounting, Err: countErr,
+						Message: fmt.Sprint("Error counting lines: ", countErr)})
 				}
+				return
 			}
+			splitting.setTotal(j.sliceLines(goal))
+			j.finish(counting, fmt.Sprint("Finished counting lines: ", goal))
 		}()
 	}
 
-	// Write out chunks
-	chunks, err := splitSortDeduplicate(outFile, tmpFileBytes, skipPatterns, &progress, inFile)
+	// Write out chunks, after the start of the script of FormatSQLite
+	err = j.writeScriptStart(out)
+	var chunks []*os.File
+	if err == nil {
+		chunks, err = j.splitSortDeduplicate(out, splitting, j.retryInput(j.adviseInput(inFile)))
+	}
 
-	// No matter how or when we exit, cleanup all temporary files
+	// No matter how or when we exit, cleanup all temporary files, unless they are being kept, or the
+	// run failed and they are in its checkpoint, to be resumed from. Failing to clean up fails the run.
 	defer func(chunks []*os.File) {
+		var c cleanup
 		for _, chunk := range chunks {
-			// Only cleanup files this package created, do not cleanup the outfile (we didn't create it)
-			if chunk != outFile {
-				chunk.Close()
-				os.Remove(chunk.Name())
+			c.close(chunk)
+			if !opts.KeepTempFiles && !j.keepChunks && !(err != nil && j.checkpointed(chunk.Name())) {
+				c.remove(chunk.Name())
 			}
 		}
+		if !opts.KeepTempFiles && !j.keepChunks && !(err != nil && j.checkpointing()) {
+			opts.Metrics.removeTmpBytes(j.stats.TmpBytes)
+		}
+		if err == nil {
+			c.errs = append(c.errs, j.removeCheckpoint())
+		}
+		c.errs = append(c.errs, j.removePassthrough(), j.removePassthroughOrdinals(), j.removeMeta())
+		if cleanupErr := c.err(); cleanupErr != nil {
+			err = joinErrors(err, fmt.Errorf("cleaning up temporary files: %w", cleanupErr))
+		}
 	}(chunks)
+	if opts.KeepTempFiles {
+		for _, chunk := range chunks {
+			j.stats.TmpFiles = append(j.stats.TmpFiles, chunk.Name())
+		}
+	}
 
 	// Handle error from splitSortDeduplicate
 	if err != nil {
@@ -103,40 +872,395 @@ func Dedup(outFile *os.File, tmpFileBytes uint64, skipPatterns []*regexp.Regexp,
 
 	// No need to merge anything if the input file was empty,
 	// or we were able to fit it in memory and wrote everything directly to the output file already
-	if len(chunks) <= 1 {
-		return nil
+	out, finishOutput := j.prepareOutput(out)
+	switch {
+	case len(chunks) > 0 && j.partitions() > 1:
+		err = j.mergePartitions(out, chunks)
+	case len(chunks) > 0 || j.updating():
+		err = j.mergeSpilled(out, chunks)
+	}
+	if err == nil {
+		err = j.writePassthrough(out)
+	}
+	if err == nil {
+		err = j.writeScriptEnd(out)
 	}
+	if finishErr := finishOutput(); err == nil {
+		err = finishErr
+	}
+	if err == nil {
+		err = j.mapPassthrough()
+	}
+	if err == nil {
+		err = j.expectUnique(chunks)
+	}
+	return err
+}
 
-	fmt.Println("Merging temporary files into:", outFile.Name())
-	return mergeChunks(outFile, &progress, chunks)
+// startSplitting starts the splitting phase, with the size of the input as its total bytes, if known
+func (j *job) startSplitting(inFile io.Reader) *phaseProgress {
+	splitting := j.start(PhaseSplitting, "Splitting input into sorted chunks")
+	if size, ok := inputSize(inFile); ok {
+		splitting.setTotalBytes(size)
+	}
+	return splitting
 }
 
-// countLines returns the number of lines in a file
-func countLines(r io.Reader) (uint64, error) {
-	buf := make([]byte, defaultBufferSize)
+// SortChunks is given a directory to write to, a file to read from, and the maximum chunk file size.
+// It performs only the first half of Dedup: it de-duplicates strings/URL's by reading the input
+// file into a set, and writing out the set as a sorted chunk file in dir (opts.TempDir, or
+// os.TempDir, if empty) each time the set approaches opts.TmpFileBytes in size. The chunk files
+// are not merged or deleted, so that they can be combined later (or elsewhere) using Merge.
+// DryRun, CountOnly, OnDuplicateCount, OnSketchCount, OnAudit, OnLineMapped, Seen, Reference,
+// Update, OnIndex, OnDone, and Format are ignored. It returns the paths of all chunk files written.
+func SortChunks(dir string, opts Options, inFile io.Reader) ([]string, error) {
+	if opts.PassthroughUnkept {
+		return nil, fmt.Errorf("passing through unkept lines is not supported when sorting chunks")
+	}
+	if err := validateRules(opts.Rules); err != nil {
+		return nil, err
+	}
+	if hasPassthroughRules(opts.Rules) {
+		return nil, fmt.Errorf("passthrough rules are not supported when sorting chunks")
+	}
+	if err := validateTransform(opts); err != nil {
+		return nil, err
+	}
+	if err := validateKey(opts); err != nil {
+		return nil, err
+	}
+	if keyed(opts) {
+		return nil, fmt.Errorf("writing lines other than their keys is not supported when sorting chunks")
+	}
+	if dir == "" {
+		dir = opts.TempDir
+	}
+	if dir != "" {
+		if err := CheckDir(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	// Without a merge, there is nothing to total the counts of the chunks, and they are always text
+	opts.Partitions = 0
+	opts.Checkpoint, opts.Resume = "", false
+	opts.EncryptTempFiles = false
+	opts.OnDuplicateCount = nil
+	opts.OnSketchCount = nil
+	opts.OnAudit = nil
+	opts.OnLineMapped = nil
+	opts.Seen = nil
+	opts.Reference = nil
+	opts.Update = nil
+	opts.OnIndex = nil
+	opts.Format = FormatText
+	if err := validateReadWorkers(opts); err != nil {
+		return nil, err
+	}
+	if err := validateCompactSet(opts); err != nil {
+		return nil, err
+	}
+	j, _, done := newJob(opts, dir)
+	defer done()
+
+	chunks, err := j.splitSortDeduplicate(nil, j.startSplitting(inFile), j.retryInput(j.adviseInput(inFile)))
+
+	paths := make([]string, 0, len(chunks))
+	for _, chunk := range chunks {
+		chunk.Close()
+		if err != nil {
+			// Do not leave partial results behind
+			removeFile(chunk.Name())
+			continue
+		}
+		paths = append(paths, chunk.Name())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// Merge is given a file to write to, and files to read from that are each already sorted, such as
+// the chunk files written by SortChunks or the output files written by Dedup. It will merge the
+// files while deduplicating the lines, into the output file. The input files may contain
+// duplicates, but an error is returned if any are not sorted. Only the reporting, buffer size,
+// Context, Delimiter, CRLF, Limit, OnDuplicate, OnDuplicateAt, OnDuplicateCount, OnSketchCount,
+// Format, Table, PostgresBatchLines, RedisKey, RedisBatchLines, RedisTTL, ParquetRowGroupBytes,
+// ArrowBatchLines, OnIndex, IndexInterval, Metrics, Tracer, OnDone, rate limit, sync, DryRun,
+// CountOnly, and ExpectUnique options apply, and the counts of OnSketchCount are those of
+// CountSketch as given. Records have no source or line number, which are not known.
+func Merge(outFile *os.File, opts Options, inFiles ...*os.File) (Stats, error) {
+	opts = countOnly(opts)
+	if err := validateFormat(opts); err != nil {
+		return Stats{}, err
+	}
+	if err := validateIndex(opts); err != nil {
+		return Stats{}, err
+	}
+	if err := validateLineEnding(opts); err != nil {
+		return Stats{}, err
+	}
+	// The positions of the lines in the files are not known, and they are not transformed or keyed
+	opts.TransformMode, opts.Key, opts.StripTimestamp, opts.KeyFields, opts.KeyPrefixBytes = "", nil, nil, nil, 0
+	opts.StripANSI = false
+	opts.OnAudit = nil
+	opts.OnLineMapped = nil
+	opts.Seen = nil
+	opts.Reference = nil
+	opts.Update = nil
+	j, _, done := newJob(opts, "")
+	defer done()
+	j.startTrace("dedup.merge")
+
+	var out io.Writer = io.Discard
+	if !opts.DryRun {
+		out = j.sumOutput(j.syncWriter(outFile))
+		j.outFile = outFile
+	}
+	final := j.withoutFinalLineEnding(out)
+	if final != nil {
+		out = final
+	}
+
+	out = j.limitWriter(out)
+	err := j.writeScriptStart(out)
+	var linesRead, bytesRead uint64
+	if err == nil {
+		linesRead, bytesRead, err = j.mergeChunks(out, inFiles)
+	}
+	if err == nil {
+		err = final.finish(&j.stats)
+	}
+	if err == nil {
+		err = j.expectUnique(nil)
+	}
+	if err == nil {
+		err = j.writeScriptEnd(out)
+	}
+	if err == nil && !opts.DryRun {
+		err = j.syncFile(outFile)
+	}
+	if err == nil && !opts.DryRun {
+		err = j.verifyOutput(outFile)
+	}
+	opts.Metrics.addLinesRead(linesRead)
+	j.stats.LinesRead = linesRead
+	j.stats.BytesRead = bytesRead
+	return j.summarize(err), err
+}
+
+// CheckDir returns an error if dir is not an existing directory that files can be written to
+func CheckDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	// The only reliable way to check permissions (ACLs, read-only mounts, etc) is to try
+	f, err := os.CreateTemp(dir, "dedup.check.*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// job holds the configuration and the shared state of the phases of a single call into this package
+type job struct {
+	*reporter
+	opts    Options
+	delim   string
+	dir     string
+	stats   Stats
+	started time.Time
+	passed  *passthrough
+	shards  *shardWriter
+
+	// The number of lines written to the chunks of each partition, and the first line of each
+	// partition after the first by ShardRange, with Partitions
+	partitionLines  []uint64
+	partitionBounds []string
+
+	// The ordinals of the lines passed through, if mapping
+	passedOrdinals *passthroughOrdinals
+
+	// What is known about the lines of the current chunk, and the meta file of each chunk, if tracking
+	meta      map[string]lineMeta
+	metaFiles []*os.File
+
+	// The temporary files opened again, and the intermediate files written, by a cascaded merge
+	reopened     []*os.File
+	intermediate map[*os.File]bool
+
+	// The input if it is Sources, the offset of the last token scanned from it, and the ordinal
+	// of the first line of each source, if tracking where lines were first read
+	sources      *Sources
+	tokenStart   uint64
+	sourceStarts []sourceStart
+
+	// The buffer each record is encoded into
+	record bytes.Buffer
+
+	// The lines of the output that are not unique lines, such as the statements starting and
+	// ending the scripts of FormatSQLite and FormatPostgres, or the commands of FormatRedis
+	scriptLines uint64
+
+	// The unique lines in the current batch of FormatPostgres or FormatRedis, and the lines of the
+	// batch of FormatRedis, which are written once it is full
+	batchLines int
+	redisBatch bytes.Buffer
+
+	// The row groups of FormatParquet
+	parquet *parquetWriter
+
+	// The record batches of FormatArrow and FormatArrowStream
+	arrow *arrowWriter
+
+	// The budget for the heap while the current set is read, with AutoMemory
+	autoMemory uint64
+
+	// The output file of Run or Merge, unless it is a dry run
+	outFile *os.File
+
+	// Whether the temporary chunk files are kept, because ExpectUnique failed the run
+	keepChunks bool
+
+	// The checksum of what is written to the output file, with VerifyWrites
+	outSum hash.Hash32
+
+	// The limits shared by everything the run reads and writes, with MaxReadBytesPerSecond and
+	// MaxWriteBytesPerSecond
+	readLimit  *rateLimiter
+	writeLimit *rateLimiter
+
+	// The bytes written to the temporary chunk files, and their cap, with MaxTmpBytes
+	tmpCap *tmpCap
+
+	// The checksum each temporary chunk file was written with, by name, with ChecksumTempFiles
+	chunkSums map[string]uint32
+
+	// The length of the longest line written to each temporary chunk file, by name
+	chunkLongest map[string]int
+
+	// The cipher of the temporary files, with EncryptTempFiles
+	tempCipher *tempCipher
+
+	// The size of the input, if known
+	inputSize uint64
+
+	// The manifest of the chunks written, the bytes of the input the scanner has consumed, and the
+	// offset of the end of the last line read, with Checkpoint
+	checkpoint *checkpoint
+	scanned    uint64
+	lineEnd    uint64
+}
+
+// newJob returns a job that has started reporting progress, with a context that is cancelled
+// once the returned done function is called
+func newJob(opts Options, dir string) (*job, context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		reporter: newReporter(opts.OnProgress, opts.OnEvent, opts.Logger),
+		opts:     opts,
+		delim:    defaultDelimiter,
+		dir:      dir,
+		started:  time.Now(),
+
+		readLimit:  newRateLimiter(opts.MaxReadBytesPerSecond),
+		writeLimit: newRateLimiter(opts.MaxWriteBytesPerSecond),
+		tmpCap:     newTmpCap(opts.MaxTmpBytes),
+	}
+	if opts.VerifyWrites {
+		j.outSum = crc32.NewIEEE()
+	}
+	if opts.EncryptTempFiles {
+		j.tempCipher = &tempCipher{}
+	}
+	if opts.Delimiter != "" {
+		j.delim = opts.Delimiter
+	}
+	j.stats.RuleLines = newMatchCounts(len(opts.Rules))
+	j.stats.SkipPatternLines = newMatchCounts(len(opts.SkipPatterns))
+	j.stats.KeepPatternLines = newMatchCounts(len(opts.KeepPatterns))
+	go j.run(ctx, opts.ProgressInterval)
+	return j, ctx, func() {
+		j.stop()
+		cancel()
+	}
+}
+
+// start begins a new phase and sends an event for it
+func (j *job) start(phase Phase, message string) *phaseProgress {
+	j.opts.Metrics.setPhase(phase)
+	j.event(Event{Kind: EventPhaseStarted, Phase: phase, Message: message})
+	return j.begin(phase)
+}
+
+// finish marks the phase as done and sends an event with its final counts
+func (j *job) finish(pp *phaseProgress, message string) {
+	pp.finish()
+	p := pp.snapshot()
+	j.event(Event{Kind: EventPhaseFinished, Phase: p.Phase, Lines: p.Lines, Bytes: p.Bytes, Message: message})
+}
+
+// summarize records the durations of the run and its finished phases, ends its span and calls OnDone
+// with the error it returns, if any, and returns the stats
+func (j *job) summarize(err error) Stats {
+	j.opts.Metrics.setPhase("")
+	j.stats.Elapsed = time.Since(j.started)
+	j.stats.PhaseElapsed = j.elapsed()
+	j.endTrace(err)
+	if j.opts.OnDone != nil {
+		j.opts.OnDone(j.stats, err)
+	}
+	return j.stats
+}
+
+// Count returns the number of lines in the input file
+func Count(inFile io.Reader) (uint64, error) {
+	return countLines(inFile, 0, defaultDelimiter, nil)
+}
+
+// countLines returns the number of lines in a file separated by the delimiter, reading it with a buffer
+// of bufSize bytes (or the default if zero). If progress is nil, the count so far is printed every
+// 100 million lines instead.
+func countLines(r io.Reader, bufSize int, delimiter string, progress *phaseProgress) (uint64, error) {
+	delim := []byte(delimiter)
+	buf := make([]byte, bufferSize(bufSize, defaultBufferSize)+len(delim))
 
 	var count uint64
 	var totalCount uint64
-	var progress uint64
+	var printed uint64
 
-	lineSep := []byte{'\n'}
-	var last byte
+	// A delimiter longer than a byte may be split across reads, so the end of each read
+	// that could be the start of a delimiter is kept for the next one
+	var kept int
+	var read bool
+	var endsWithDelimiter bool
 
 	for {
-		c, err := r.Read(buf)
-		count = uint64(bytes.Count(buf[:c], lineSep))
+		c, err := r.Read(buf[kept:])
+		window := buf[:kept+c]
+		count = uint64(bytes.Count(window, delim))
 		totalCount += count
-		progress += count
-		if progress >= 100000000 {
-			progress = 0
+		if progress != nil {
+			progress.add(count, uint64(c))
+		} else if printed += count; printed >= 100000000 {
+			printed = 0
 			fmt.Println("Counted lines:", totalCount)
 		}
 
 		if c > 0 {
-			last = buf[c-1]
+			read = true
+			endsWithDelimiter = bytes.HasSuffix(window, delim)
+			kept = copy(buf, window[len(window)-tailLength(window, delim):])
 		}
 		if err == io.EOF {
-			if last != '\n' {
+			if read && !endsWithDelimiter {
 				totalCount++ // final line
 			}
 			return totalCount, nil
@@ -147,133 +1271,456 @@ func countLines(r io.Reader) (uint64, error) {
 	}
 }
 
+// tailLength returns how many bytes at the end of the window could be the start of a delimiter
+// that continues in the next read
+func tailLength(window, delim []byte) int {
+	n := len(delim) - 1
+	if n > len(window) {
+		n = len(window)
+	}
+	for ; n > 0; n-- {
+		if bytes.HasPrefix(delim, window[len(window)-n:]) {
+			return n
+		}
+	}
+	return 0
+}
+
 // splitSortDeduplicate reads in the input file, and deduplicates the lines as it reads them in.
 // If the total size of the deduplicated lines exceeds tmpFileBytes, it will begin writing out
 // the sets as sorted chunks to temporary files. If the size doesn't exceed tmpFileBytes,
-// it will write the full sorted deduplicated set to the output file.
-// It returns all files it wrote to.
-func splitSortDeduplicate(outFile *os.File, tmpFileBytes uint64, skipPatterns []*regexp.Regexp, progress *uint64, inFile io.Reader) ([]*os.File, error) {
-	// Create a scanner to buffer the input file and read in line tokens
-	scanner := bufio.NewScanner(inFile)
+// it will write the full sorted deduplicated set to the output writer.
+// If out is nil, the final set is always written to a temporary file.
+// Temporary files are created in the job's dir, or os.TempDir if dir is empty.
+// It finishes the progress of the splitting phase once the input has been read.
+// It returns all temporary files it wrote to.
+func (j *job) splitSortDeduplicate(out io.Writer, progress *phaseProgress, inFile io.Reader) ([]*os.File, error) {
+	// Create the writer of the temporary files being created, with the chunks of the checkpoint if
+	// resuming. Whatever happens, the chunks being written are waited for, so that none are written
+	// to once this returns.
+	cw := j.newChunkWriter()
+	defer cw.wait()
+	resumed, err := j.resume(cw, inFile)
+	if err != nil {
+		return cw.chunks, err
+	}
 
-	// Set scanner's buffer size to be a bit larger
-	scanner.Buffer(make([]byte, 0, defaultBufferSize), bufio.MaxScanTokenSize)
+	sr, stopReadWorkers := j.newSourceReader(inFile)
+	defer stopReadWorkers()
+	var input io.Reader = sr
+	if sr == nil {
+		var stopReading func()
+		input, stopReading = j.newReadAhead(inFile)
+		defer stopReading()
+	}
+
+	// Create a scanner to buffer the input file and read in line tokens, with a larger buffer,
+	// from the buffers read ahead of it, or from the lines of its sources read on read workers
+	scanner := j.newScanner(j.limitReader(input), bufferSize(j.opts.ReadBufferSize, defaultBufferSize))
+	delimLen := uint64(len(j.delim))
+	if sources, ok := inFile.(*Sources); ok {
+		j.sources = sources
+		j.trackOffsets(scanner)
+	}
+	j.trackInput(scanner)
 
 	// Create a hash set (map with empty values) with decent initial size
 	set := make(map[string]struct{}, 1024)
 
-	// Create counters and a slice of temporary files being created
+	j.evaluateMemory()
+	pb := j.newPartitionBuilder(cw)
+	defer pb.stop()
+
+	// The set holds every line as it was read, unless rules transform lines, so a line already in it
+	// is a duplicate without being filtered again. It is then only copied into a string if needed.
+	// Other lines are copied into the arena of the set, and given back if not added to it.
+	lookupBytes := pb == nil && !hasTransforms(j.opts.Rules) && j.opts.Transform == nil && !j.opts.StripANSIOutput &&
+		!hasKey(j.opts)
+	var arena lineArena
+	budget := j.lineBudget()
+	mc := j.newMemoryCheck()
+	lengths := j.newLineLengths()
+
+	// With a compact set, the lines are added to it instead, which copies what it needs of them,
+	// so the arena is given back once they are. The budget of a prefix set is for its memory,
+	// which it measures, and the overhead of each line in a hash set is smaller than in a map.
+	compact := j.newCompactSet(pb)
+	tree, _ := compact.(*prefixSet)
+	if tree != nil {
+		lengths = nil
+	} else if compact != nil {
+		lengths.setOverhead(hashLineOverhead)
+	}
+
+	// Create counters
 	var (
-		chunks      []*os.File
 		bytesUsed   uint64
 		previousLen int
 		currentLen  int
 		lineCount   uint64
+		byteCount   uint64
 	)
 
-	// Advance the scanner to the next token
+	// The set takes the bytes of its lines from the shared budget, and gives them back once it is
+	// written, or when this returns if it never is
+	j.opts.Budget.join()
+	defer func() { j.opts.Budget.leave(bytesUsed) }()
+
+	// Advance the scanner to the next token, past any lines to ignore, which a resumed run is already past
 	hasNext := scanner.Scan()
+	if resumed {
+		progress.add(j.stats.LinesRead, j.stats.BytesRead)
+	}
+	for skipped := uint64(0); hasNext && !resumed && skipped < j.opts.SkipLines; skipped++ {
+		j.locate(skipped)
+		hasNext = scanner.Scan()
+	}
+	if !hasNext && resumed {
+		j.finish(progress, fmt.Sprintf("Finished splitting %d lines", j.stats.LinesRead))
+		return cw.chunks, scanner.Err()
+	}
 	if !hasNext {
-		return nil, scanner.Err()
+		j.finish(progress, "Finished splitting, the input is empty")
+		return cw.chunks, scanner.Err()
 	}
 
 	// Loop until the file is finished
 loop:
 	for {
-		// Read the token in and add to the set
-		line := scanner.Text()
-		hasNext = scanner.Scan() // Peak ahead
+		// Read the token in, looking it up in the set first, which does not allocate
+		var line string
+		isDuplicate := false
+		switch {
+		case lookupBytes && compact != nil:
+			isDuplicate = compact.has(scanner.Bytes())
+		case lookupBytes:
+			_, isDuplicate = set[string(scanner.Bytes())]
+		}
+		if isDuplicate && j.opts.CountSketch != nil {
+			j.opts.CountSketch.addBytes(scanner.Bytes())
+		}
+		mark := arena.mark()
+		switch {
+		case lookupBytes && !isDuplicate:
+			line = arena.copy(scanner.Bytes())
+		case !isDuplicate || j.tracking() || j.opts.OnDuplicate != nil || j.opts.OnDuplicateAt != nil:
+			line = scanner.Text()
+		}
+		lineLen := uint64(len(scanner.Bytes()))
+		ordinal := j.opts.SkipLines + j.stats.LinesRead
+		j.locate(ordinal)
+		j.lineEnd = j.scanned
+		hasNext = !j.maxLinesRead() && !j.cancelled() && scanner.Scan() // Peak ahead
 		lineCount++
+		byteCount += lineLen + delimLen
+		j.stats.LinesRead++
+		j.stats.BytesRead += lineLen + delimLen
 
-		// Skip lines
-		for _, pattern := range skipPatterns {
-			if pattern.MatchString(line) {
-				lineCount++ // One more line that doesn't have to be written
-				continue loop
+		// Skip lines, including those written by earlier runs, or pass them through to the output,
+		// and transform the others, keeping the text written for them if that is not the line
+		action := actionDedup
+		var text string
+		if !isDuplicate {
+			action, line, text = j.filter(line)
+		}
+		if action == actionDedup && !isDuplicate {
+			seen, err := j.seen(line)
+			if err != nil {
+				return cw.chunks, err
+			}
+			if seen {
+				action = actionSeen
+			}
+		}
+		if action != actionDedup {
+			if action == actionPassthrough {
+				if err := j.passthrough(line); err != nil {
+					return cw.chunks, err
+				}
+				if err := j.addPassthroughOrdinal(ordinal); err != nil {
+					return cw.chunks, err
+				}
+			} else {
+				if action == actionSeen {
+					j.stats.LinesSeen++
+				} else {
+					j.stats.LinesSkipped++
+				}
+				if err := j.mapLine(ordinal, 0); err != nil {
+					return cw.chunks, err
+				}
 			}
+			arena.rewind(mark)
+			if !hasNext {
+				progress.add(lineCount, byteCount)
+				j.opts.Metrics.addLinesRead(lineCount)
+				break loop
+			}
+			continue loop
+		}
+
+		// Every line deduplicated is counted, the duplicates found in the set as they were looked up
+		if !isDuplicate && j.opts.CountSketch != nil {
+			j.opts.CountSketch.Add(line)
+		}
+
+		// With partition workers, the line is added to the set of its partition by its worker instead
+		if pb != nil {
+			if err := pb.add(line, text, ordinal); err != nil {
+				return cw.chunks, err
+			}
+			if !hasNext {
+				progress.add(lineCount, byteCount)
+				j.opts.Metrics.addLinesRead(lineCount)
+				break loop
+			}
+			if lineCount >= 1000 {
+				progress.add(lineCount, byteCount)
+				j.opts.Metrics.addLinesRead(lineCount)
+				j.addDropped(sr, progress)
+				lineCount = 0
+				byteCount = 0
+			}
+			continue loop
 		}
 
 		// This is what is written, to chunks or to the output file directly
-		set[line] = struct{}{}
+		if !isDuplicate && compact != nil {
+			compact.add(line)
+			arena.rewind(mark)
+			currentLen = compact.len()
+		} else if !isDuplicate {
+			set[line] = struct{}{}
 
-		// The length of a map is stored in the map (in golang), so the operation is nearly free
-		currentLen = len(set)
+			// The length of a map is stored in the map (in golang), so the operation is nearly free
+			currentLen = len(set)
+		}
+
+		// If the length of the set did not increase, the line is a duplicate of one in this chunk
+		if currentLen > previousLen {
+			j.meta = j.trackLine(j.meta, line, text, ordinal)
+		} else {
+			j.stats.LinesDuplicate++
+			j.meta = j.trackDuplicate(j.meta, line, ordinal)
+			if err := j.mapLine(ordinal, 0); err != nil {
+				return cw.chunks, err
+			}
+			if err := j.duplicate(line, ordinal+1); err != nil {
+				return cw.chunks, err
+			}
+		}
 
 		// Peek ahead to see if there are more tokens, or exit loop if the file is finished
 		if !hasNext {
-			if currentLen == previousLen {
-				lineCount++ // One more line that doesn't have to be written
-			}
-			atomic.AddUint64(progress, lineCount)
+			progress.add(lineCount, byteCount)
+			j.opts.Metrics.addLinesRead(lineCount)
 			break loop
 		}
 		if lineCount >= 1000 {
-			atomic.AddUint64(progress, lineCount)
+			progress.add(lineCount, byteCount)
+			j.opts.Metrics.addLinesRead(lineCount)
+			j.addDropped(sr, progress)
 			lineCount = 0
+			byteCount = 0
 		}
 
 		// If the length of the set increased, add the byte length of the string to the memory counter,
-		// plus one for a new line
+		// plus its delimiter. Duplicates only use memory if their positions are tracked.
+		used := j.duplicateBytes()
 		if currentLen > previousLen {
-			bytesUsed += uint64(len(line)) + 1
+			used = uint64(len(line)+len(text)) + delimLen
+			if tree != nil {
+				used = tree.size() - bytesUsed
+			}
+			lengths.add(used)
+		}
+		if used > 0 {
+			bytesUsed += used
 
 			// If the total bytes of all distinct strings in the set, plus the upcoming line,
-			// are equal or greater than what we want, then spill to a new temp file
-			if bytesUsed+uint64(len(scanner.Bytes()))+1 > tmpFileBytes {
-				// Create a new temporary file
-				chunkFile, err := os.CreateTemp("", "dedup.*.log")
-				if err != nil {
-					return chunks, err
+			// are equal or greater than what we want, or the runs sharing the budget hold too much,
+			// or the process is using too much memory, then spill to a new temp file
+			spill := j.opts.Budget.take(used, bytesUsed)
+			if bytesUsed+uint64(len(scanner.Bytes()))+delimLen > lengths.threshold(budget) {
+				spill = true
+			}
+			if !spill && mc.due(bytesUsed) {
+				var err error
+				if spill, err = j.overMemory(cw); err != nil {
+					return cw.chunks, err
 				}
-				chunks = append(chunks, chunkFile)
-				fmt.Println("Creating temporary file:", chunkFile.Name())
-
-				// Sort and write to file
-				err = writeSlice(chunkFile, sortKeys(set), nil)
+				if !spill {
+					spill = j.underPressure(mc)
+				}
+			}
+			if spill {
+				// Create a new temporary file, then sort and write to it while the next set is read
+				var err error
+				if compact != nil {
+					err = cw.writeCompact(compact, bytesUsed)
+				} else {
+					err = cw.write(set, j.meta, bytesUsed)
+				}
+				bytesUsed = 0
 				if err != nil {
-					return chunks, err
+					return cw.chunks, err
 				}
 
-				// Overwrite the set so the old one can be GC'ed, reset counters
-				set = make(map[string]struct{}, 1024)
-				bytesUsed = 0
+				// Start a new set, leaving the old one to the chunk writer, reset counters
+				if compact != nil {
+					compact = j.newCompactSet(pb)
+					tree, _ = compact.(*prefixSet)
+				} else {
+					set = make(map[string]struct{}, 1024)
+				}
+				arena = lineArena{}
+				j.meta = nil
+				j.evaluateMemory()
+				budget = j.lineBudget()
+				mc = j.newMemoryCheck()
 				currentLen = 0
 			}
-		} else {
-			lineCount++ // One more line that doesn't have to be written
 		}
 		previousLen = currentLen
 	}
-	err := scanner.Err()
+	j.addDropped(sr, progress)
+	err = scanner.Err()
+	if err == nil {
+		err = j.cancelErr()
+	}
 	if err != nil {
-		return chunks, err
+		return cw.chunks, err
+	}
+	if pb != nil {
+		if set, j.meta, err = pb.finish(); err != nil {
+			return cw.chunks, err
+		}
+	}
+	j.finish(progress, fmt.Sprintf("Finished splitting %d lines", j.stats.LinesRead))
+
+	// The set can only be empty here if all lines were skipped or passed through,
+	// or the partition workers have written all of their sets as chunks
+	if len(set) == 0 && (compact == nil || compact.len() == 0) {
+		return cw.chunks, cw.wait()
 	}
 
-	// There is at least one string left in the set.
 	// If no temporary files have been created, it means all the deduplicated strings fit into
 	// memory, and we can write directly to the output file without having to make temporary chunks
-	finalChunk := outFile
-	finalProgress := progress
-	if len(chunks) > 0 {
-		// If we have already made other temporary files, then we have to make another
-		finalChunk, err = os.CreateTemp("", "dedup.*.log")
-		if err != nil {
-			return chunks, err
+	if len(cw.chunks) == 0 && out != nil && !j.updating() {
+		var all []string
+		if compact != nil {
+			all = compact.sortedKeys()
+		} else {
+			all = sortKeys(set)
+		}
+		keys := j.partitionOrder(j.limitKeys(all))
+		if len(keys) < len(all) {
+			j.stats.Limited = true
+		}
+		if err := j.mapRemoved(j.meta, all[len(keys):]); err != nil {
+			return cw.chunks, err
+		}
+		j.shards.splitSorted(keys)
+		writing := j.start(PhaseWriting, fmt.Sprint("Writing to file: ", outputName(out)))
+		writing.setTotal(uint64(len(keys)))
+		var written uint64
+		if j.opts.CountOnly {
+			writing.add(uint64(len(keys)), 0)
+		} else if j.opts.Format.records() {
+			written, err = j.writeRecords(out, keys, writing)
+		} else {
+			written, err = writeSlice(out, j.texts(keys), j.opts.WriteBufferSize, j.lineEnding(), writing)
+		}
+		if err == nil {
+			err = j.reportDuplicates(keys)
+		}
+		if err == nil {
+			err = j.sketchCounts(keys)
+		}
+		if err == nil {
+			err = j.addSeenKeys(keys)
 		}
-		finalProgress = nil
-		fmt.Println("Creating temporary file:", finalChunk.Name())
+		if err == nil {
+			err = j.indexKeys(keys)
+		}
+		if err == nil {
+			err = j.mapWritten(keys)
+		}
+		j.stats.LinesUnique += uint64(len(keys))
+		j.stats.BytesOut += written
+		j.opts.Metrics.addLinesUnique(uint64(len(keys)))
+		j.finish(writing, fmt.Sprintf("Finished writing %d lines to file: %s", len(keys), outputName(out)))
+		return cw.chunks, err
+	}
+
+	// If we have already made other temporary files, then we have to make another,
+	// and write any remaining distinct strings
+	if compact != nil {
+		err = cw.writeCompact(compact, bytesUsed)
 	} else {
-		fmt.Println("Writing to file:", finalChunk.Name())
+		err = cw.write(set, j.meta, bytesUsed)
+	}
+	bytesUsed = 0
+	if err != nil {
+		return cw.chunks, err
+	}
+	j.meta = nil
+	return cw.chunks, cw.wait()
+}
+
+// maxLinesRead returns true if the line being processed is the last one allowed by MaxLines
+func (j *job) maxLinesRead() bool {
+	return j.opts.MaxLines > 0 && j.stats.LinesRead+1 >= j.opts.MaxLines
+}
+
+// sliceLines returns how many of the lines of the input will be processed, after SkipLines and MaxLines
+func (j *job) sliceLines(lines uint64) uint64 {
+	if lines <= j.opts.SkipLines {
+		return 0
+	}
+	lines -= j.opts.SkipLines
+	if j.opts.MaxLines > 0 && lines > j.opts.MaxLines {
+		return j.opts.MaxLines
+	}
+	return lines
+}
+
+// limitKeys returns only as many sorted keys as the output is limited to. Lines past the limit of
+// a chunk can never be in the output, because the limit is reached by the earlier lines first.
+func (j *job) limitKeys(keys []string) []string {
+	if j.opts.Limit > 0 && uint64(len(keys)) > j.opts.Limit {
+		return keys[:j.opts.Limit]
 	}
-	chunks = append(chunks, finalChunk)
+	return keys
+}
+
+// bufferSize returns the configured buffer size, or the default if it is not positive
+func bufferSize(configured, defaultSize int) int {
+	if configured > 0 {
+		return configured
+	}
+	return defaultSize
+}
 
-	// Write any remaining distinct strings
-	return chunks, writeSlice(finalChunk, sortKeys(set), finalProgress)
+// maxLineLength returns the length of the longest line that can be read from the input file
+func (j *job) maxLineLength() int {
+	if size := bufferSize(j.opts.ReadBufferSize, defaultBufferSize); size > bufio.MaxScanTokenSize {
+		return size
+	}
+	return bufio.MaxScanTokenSize
+}
+
+// outputName returns the name of the output file, for logging
+func outputName(out io.Writer) string {
+	if named, ok := out.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return "(discarded)"
 }
 
-// sortKeys takes a map and puts the keys into a sorted slice
+// sortKeys takes a map and puts the keys into a sorted slice, which can be given back with putKeys
 func sortKeys(set map[string]struct{}) []string {
-	slice := make([]string, len(set))
+	slice := getKeys(len(set))
 	i := 0
 	for k := range set {
 		slice[i] = k
@@ -285,163 +1732,443 @@ func sortKeys(set map[string]struct{}) []string {
 	return slice
 }
 
-// writeSlice writes all strings in the slice to the file, delimited by a new line
-func writeSlice(f *os.File, slice []string, progress *uint64) error {
+// writeSlice writes all strings in the slice to the writer, each followed by the delimiter.
+// The writes are buffered in bufSize bytes, or the default if zero. It returns the number of bytes written.
+func writeSlice(w io.Writer, slice []string, bufSize int, delimiter string, progress *phaseProgress) (uint64, error) {
 	// Buffer the writes
-	writer := bufio.NewWriterSize(f, defaultBufferSize)
+	writer := getWriter(w, bufferSize(bufSize, defaultBufferSize))
+	defer putWriter(writer)
 	var line string
 	var err error
+	var written uint64
 
 	// Write to temporary file
-	var lineCount uint64
+	var lineCount, byteCount uint64
 	for _, line = range slice {
 		// Write line
 		_, err = writer.WriteString(line)
 		if err != nil {
-			return err
+			return written, err
 		}
 
 		// Write delimiter
-		err = writer.WriteByte(delimiter)
+		_, err = writer.WriteString(delimiter)
 		if err != nil {
-			return err
+			return written, err
 		}
+		written += uint64(len(line) + len(delimiter))
 
 		lineCount++
-		if lineCount >= 1000 && progress != nil {
-			atomic.AddUint64(progress, lineCount)
+		byteCount += uint64(len(line) + len(delimiter))
+		if lineCount >= 1000 {
+			progress.add(lineCount, byteCount)
 			lineCount = 0
+			byteCount = 0
 		}
 	}
-	if progress != nil {
-		atomic.AddUint64(progress, lineCount)
-	}
+	progress.add(lineCount, byteCount)
 
 	// Flush all remaining bytes to the file
-	return writer.Flush()
+	return written, writer.Flush()
 }
 
-// mergeChunks merges and deduplicates the chunk files into the output file
-func mergeChunks(outFile *os.File, progress *uint64, chunks []*os.File) error {
-	// Create a slice of buffered scanners for each chunk
+// mergeChunks merges and deduplicates the chunk files into the output writer.
+// It returns the number of lines and bytes read from the chunks.
+func (j *job) mergeChunks(out io.Writer, chunks []*os.File) (uint64, uint64, error) {
+	merging := j.start(PhaseMerging, fmt.Sprintf("Merging %d files into: %s", len(chunks), outputName(out)))
+	if !j.updating() {
+		merging.setTotal(j.stats.TmpLines)
+	}
+	j.shards.splitSampled()
+
+	// The file being updated is merged as one more chunk, without meta, after the chunks of the input
+	if j.updating() {
+		chunks = append(chunks[:len(chunks):len(chunks)], j.opts.Update)
+	}
+
+	// Create a slice of scanners for each chunk, releasing any memory they map once merged
 	scanners := make([]*sortableScanner, 0, len(chunks))
+	defer func() {
+		for _, ss := range scanners {
+			ss.release()
+		}
+	}()
 
 	// Add sorted scanners to the slice
-	for _, chunk := range chunks {
-		ss := &sortableScanner{
-			scanner: bufio.NewScanner(chunk), // Use default buffer size since there are many chunks
-			f:       chunk,
+	for i, chunk := range chunks {
+		ss, err := j.newSortableScanner(chunk)
+		if err != nil {
+			return 0, 0, err
 		}
 		scanners = append(scanners, ss)
-
-		// Seek to the beginning of the file to start reading again from the start
-		_, err := ss.f.Seek(0, 0)
-		if err != nil {
-			return err
+		if err = j.openMeta(ss, i); err != nil {
+			return 0, 0, err
 		}
 
 		// Scan the next token
 		ok, err := ss.next()
 		if err != nil {
-			return err
+			return 0, 0, err
 		}
 
-		// Assert that there is content (every file guaranteed to have at least one line in it)
+		// Chunks written by this package always have content, but files given to Merge may not
 		if !ok {
-			panic(ss.f.Name() + " has no content")
+			ss.release()
+			scanners = scanners[:len(scanners)-1]
 		}
 	}
 
-	return mergeSortableScanners(outFile, progress, scanners)
+	err := j.mergeSortableScanners(out, merging, scanners)
+
+	// The merge reorders the scanners, but never removes them from the backing array
+	var bytesRead uint64
+	for _, ss := range scanners {
+		bytesRead += ss.bytes
+	}
+	p := merging.snapshot()
+	if err != nil {
+		return p.Lines, bytesRead, err
+	}
+	j.finish(merging, fmt.Sprintf("Finished merging %d lines into: %s", p.Lines, outputName(out)))
+	return p.Lines, bytesRead, nil
 }
 
 // mergeSortableScanners reads a single token from each of the chunks, then chooses which one comes first
 // lexicographically, and writes that to a buffer. It then reads new token for that chunk, and
 // chooses again, repeating this process until all lines have been read from all chunks.
+// The scanners are kept in a heap by their token, so each choice costs O(log k) for k chunks.
 // To deduplicate, it remembers the previous line written to the output file, and if the next line
 // is equal then it is skipped. This works because all the chunk files are sorted already, so it is
 // guaranteed that all duplicates will be seen together as it reads from the chunks.
-func mergeSortableScanners(outFile *os.File, progress *uint64, scanners []*sortableScanner) error {
-	// Create function to sort the scanners by their token, lexicographically by their bytes
-	sortScanners := func(i, j int) bool {
-		return scanners[i].token < scanners[j].token
-	}
+func (j *job) mergeSortableScanners(out io.Writer, progress *phaseProgress, scanners []*sortableScanner) error {
+	// Order the scanners by their token, lexicographically by their bytes
+	h := scannerHeap(scanners)
+	heap.Init(&h)
 
 	// Create a buffered writer
-	writer := bufio.NewWriterSize(outFile, defaultBufferSize)
+	writer := bufio.NewWriterSize(out, bufferSize(j.opts.WriteBufferSize, defaultBufferSize))
 	var (
-		previousLine string
+		previousLine []byte
 		hasPrevious  bool
 		ok           bool
 		err          error
 		lineCount    uint64
+		byteCount    uint64
+		uniqueCount  uint64
 	)
+	group := lineGroup{j: j, w: writer}
 
 	// Loop until there aren't any scanners left
-	for len(scanners) > 0 {
-		// Sort the scanners
-		if len(scanners) > 1 {
-			sort.Slice(scanners, sortScanners)
+	for len(h) > 0 {
+		if err = j.cancelErr(); err != nil {
+			return err
 		}
 
 		// Pull the top token string, and compare to the previous line.
 		// If it matches the previous line, it is a duplicate we can skip.
-		if !hasPrevious || previousLine != scanners[0].token {
-			// Write to the output buffer
-			_, err = writer.WriteString(scanners[0].token)
-			if err != nil {
+		if !hasPrevious || !bytes.Equal(previousLine, h[0].token) {
+			// Stop once there is a new line past the limit
+			if j.opts.Limit > 0 && j.stats.LinesUnique >= j.opts.Limit {
+				j.stats.Limited = true
+				if err = j.mapRemaining(h); err != nil {
+					return err
+				}
+				break
+			}
+			if err = group.add(h); err != nil {
 				return err
 			}
 
-			// Write delimiter
-			err = writer.WriteByte(delimiter)
-			if err != nil {
+			// Write to the output buffer, unless it is written as a record once all of its
+			// occurrences have been seen, or the text first read with it if keyed
+			text := h[0].token
+			if j.keyed() {
+				text = group.text
+			}
+			if !j.opts.Format.records() && !j.opts.CountOnly {
+				if err = j.indexLine(h[0].token, j.stats.LinesUnique, j.stats.BytesOut); err != nil {
+					return err
+				}
+				_, err = writer.Write(text)
+				if err != nil {
+					return err
+				}
+
+				// Write delimiter
+				_, err = writer.WriteString(j.lineEnding())
+				if err != nil {
+					return err
+				}
+				j.stats.BytesOut += uint64(len(text) + len(j.lineEnding()))
+			}
+			j.stats.LinesUnique++
+			if err = j.mapMerged(h, group.first, j.stats.LinesUnique); err != nil {
+				return err
+			}
+			if err = j.sketchCount(h[0].token); err != nil {
+				return err
+			}
+			if err = j.addSeen(h[0].token); err != nil {
 				return err
 			}
-			previousLine = scanners[0].token
+			byteCount += uint64(len(text) + len(j.lineEnding()))
+			uniqueCount++
 			hasPrevious = true
+		} else {
+			// Duplicates seen here are between chunks, or within a file given to Merge
+			j.stats.LinesDuplicate++
+			if err = group.add(h); err != nil {
+				return err
+			}
+			if err = j.mapDuplicate(h[0]); err != nil {
+				return err
+			}
+			if err = j.duplicate(string(h[0].token), h[0].first); err != nil {
+				return err
+			}
 		}
 
 		// Regardless of whether it was written or ignored, advance the progress
 		lineCount++
 		if lineCount >= 1000 {
-			atomic.AddUint64(progress, lineCount)
+			progress.add(lineCount, byteCount)
+			j.opts.Metrics.addLinesUnique(uniqueCount)
 			lineCount = 0
+			byteCount = 0
+			uniqueCount = 0
 		}
 
-		// Scan the next value
-		ok, err = scanners[0].next()
+		// Scan the next value. The line just merged is then the last line of its scanner, which
+		// is kept until it scans again, so it need not be copied to be compared with the next.
+		ok, err = h[0].next()
 		if err != nil {
 			return err
 		}
+		previousLine = h[0].last
 		if !ok {
-			// This scanner doesn't have any more lines, so remove from the slice
-			scanners = scanners[1:]
+			// This scanner doesn't have any more lines, so remove it from the heap
+			j.event(h[0].merged())
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
 		}
 	}
-	atomic.AddUint64(progress, lineCount)
+	progress.add(lineCount, byteCount)
+	j.opts.Metrics.addLinesUnique(uniqueCount)
+	if err = group.flush(); err != nil {
+		return err
+	}
+	written, err := j.flushRecords(writer)
+	j.stats.BytesOut += written
+	if err != nil {
+		return err
+	}
 
 	// Flush all remaining bytes to the file
 	return writer.Flush()
 }
 
-// sortableScanner is a struct containing the latest token string read in from the file,
+// sortableScanner is a struct containing the latest token read in from the file,
 // as well as the file and scanner objects. It has methods to obtain the next token,
-// and the whole struct can easily be sorted in a slice based off the token.
+// and can be ordered in a scannerHeap based off the token. The token is only valid until
+// the next token is scanned, when it is kept as the last token instead, so that lines are
+// merged and written without being copied into strings.
 type sortableScanner struct {
-	token   string
-	scanner *bufio.Scanner
-	f       *os.File
+	token      []byte
+	last       []byte
+	scanner    *bufio.Scanner
+	f          *os.File
+	delim      uint64
+	lineMapped bool
+	lines      uint64
+	bytes      uint64
+	opened     time.Time
+
+	// The initial buffer of the scanner, and the whole file and the offset of the next line in it,
+	// instead of the scanner, if memory mapped
+	buf    []byte
+	data   []byte
+	offset int
+	split  bufio.SplitFunc
+
+	// The checksum of what has been read of the file, and the one it was written with, with ChecksumTempFiles
+	sum     hash.Hash32
+	wantSum uint32
+
+	// The occurrences of the token and the ordinal it was first read at plus one, the text written
+	// for it if keyed, and the reader of the meta file they are read from, if any
+	count    uint64
+	first    uint64
+	dups     []uint64
+	text     []byte
+	meta     *bufio.Reader
+	metaFile *os.File
+	withDups bool
+	withText bool
+}
+
+// merged returns the event of the scanner having merged every line of its chunk
+func (ss *sortableScanner) merged() Event {
+	elapsed := time.Since(ss.opened)
+	return Event{Kind: EventChunkMerged, Phase: PhaseMerging, File: ss.f.Name(), Lines: ss.lines, Bytes: ss.bytes,
+		Duration: elapsed, Message: fmt.Sprintf("Finished merging %d lines (%d bytes) in %s from: %s",
+			ss.lines, ss.bytes, elapsed.Round(time.Microsecond), ss.f.Name())}
 }
 
 // next scans the next token string in the file, and sets it to the sortableScanner's token field.
 // It returns true if this was successful, false if the end of the file was reached or an error.
+// It returns an error if the file is not sorted, because the merge would then let duplicates through.
 func (ss *sortableScanner) next() (bool, error) {
-	if ss.scanner.Scan() {
-		ss.token = ss.scanner.Text()
-		return true, nil
+	ss.lineMapped = false
+
+	// The scanner reuses its buffer, while a memory mapping never changes
+	if ss.data == nil {
+		ss.last = append(ss.last[:0], ss.token...)
+	} else {
+		ss.last = ss.token
+	}
+	token, ok, err := ss.scan()
+	if ok {
+		ss.lines++
+		ss.bytes += uint64(len(token)) + ss.delim
+		if ss.lines > 1 && bytes.Compare(token, ss.last) < 0 {
+			return false, fmt.Errorf("%s is not sorted: line %d %q comes before the previous line %q",
+				ss.f.Name(), ss.lines, token, ss.last)
+		}
+		ss.token = token
+		return true, ss.readMeta()
 	}
 
-	// Return any error
-	return false, ss.scanner.Err()
+	// Return any error, or the file being corrupt, once all of it has been read
+	if err == nil && ss.sum != nil {
+		err = verifyChunk(ss.f.Name(), ss.sum.Sum32(), ss.wantSum)
+	}
+	return false, err
+}
+
+// newSortableScanner returns a scanner of the lines of the chunk from its start. If MergeMmap is set
+// and the chunk can be memory mapped, the lines are read straight from the mapping. Otherwise they
+// are read through a small buffer by default, since there are many chunks, that still allows any line
+// that could be read.
+func (j *job) newSortableScanner(chunk *os.File) (*sortableScanner, error) {
+	ss := &sortableScanner{f: chunk, delim: uint64(len(j.delim)), opened: time.Now()}
+	if j.opts.MergeMmap && !j.opts.EncryptTempFiles {
+		data, err := mmapFile(chunk)
+		if err != nil {
+			j.event(Event{Kind: EventWarning, Phase: PhaseMerging, File: chunk.Name(), Err: err,
+				Message: fmt.Sprintf("Could not memory map %s, reading it instead: %v", chunk.Name(), err)})
+		}
+		if data != nil {
+			ss.data, ss.split = data, j.split()
+			if want, ok := j.chunkSums[chunk.Name()]; ok {
+				return ss, verifyChunk(chunk.Name(), crc32.ChecksumIEEE(data), want)
+			}
+			return ss, nil
+		}
+	}
+	j.adviseSequential(chunk)
+	ss.buf = getBuffer(bufferSize(j.opts.MergeBufferSize, defaultMergeBufferSize))
+	var r io.Reader = j.limitReader(j.retryReading(chunk, chunk.Name()))
+	if want, ok := j.chunkSums[chunk.Name()]; ok {
+		ss.sum, ss.wantSum = crc32.NewIEEE(), want
+		r = io.TeeReader(r, ss.sum)
+	}
+	// The file being updated is not one of the temporary files, which may be encrypted
+	if chunk != j.opts.Update {
+		var err error
+		if r, err = j.decryptTemp(r); err != nil {
+			return ss, err
+		}
+	}
+	ss.scanner = j.newScannerBuffer(r, ss.buf, j.mergeLineLength(chunk.Name()))
+
+	// Seek to the beginning of the file to start reading again from the start
+	_, err := chunk.Seek(0, 0)
+	return ss, err
+}
+
+// scan returns the next line of the file, from its memory mapping if it has one, and false at the end
+func (ss *sortableScanner) scan() ([]byte, bool, error) {
+	if ss.data == nil {
+		if ss.scanner.Scan() {
+			return ss.scanner.Bytes(), true, nil
+		}
+		return nil, false, ss.scanner.Err()
+	}
+	if ss.offset >= len(ss.data) {
+		return nil, false, nil
+	}
+
+	// The rest of the file is all there is, so the split function always finds the end of the line
+	advance, token, err := ss.split(ss.data[ss.offset:], true)
+	if err != nil {
+		return nil, false, err
+	}
+	if advance <= 0 {
+		return nil, false, fmt.Errorf("%s could not be split at offset %d", ss.f.Name(), ss.offset)
+	}
+	ss.offset += advance
+	return token, true, nil
+}
+
+// release gives back the buffer of the scanner, or the memory mapping of the file, once it is merged
+func (ss *sortableScanner) release() {
+	if ss.buf != nil {
+		putBuffer(ss.buf)
+		ss.buf = nil
+	}
+	if ss.data != nil {
+		munmapFile(ss.data)
+		ss.data = nil
+	}
+}
+
+// scannerHeap is a min-heap of scanners by their token, so that the first scanner always has the
+// next line of the merge. Popped scanners stay in the backing array, past the end of the heap.
+type scannerHeap []*sortableScanner
+
+func (h scannerHeap) Len() int      { return len(h) }
+func (h scannerHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+// Less orders equal lines by where they were first read, if known, so that the intermediate files
+// of a cascaded merge keep them in that order
+func (h scannerHeap) Less(i, j int) bool {
+	if c := bytes.Compare(h[i].token, h[j].token); c != 0 {
+		return c < 0
+	}
+	return h[i].first < h[j].first
+}
+
+func (h *scannerHeap) Push(x interface{}) {
+	*h = append(*h, x.(*sortableScanner))
+}
+
+func (h *scannerHeap) Pop() interface{} {
+	old := *h
+	ss := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return ss
+}
+
+// leading calls fn with every scanner that has the same token as the first scanner, starting with it.
+// Every parent of such a scanner has the same token too, so only they and their children are visited.
+func (h scannerHeap) leading(fn func(ss *sortableScanner) error) error {
+	if len(h) == 0 {
+		return nil
+	}
+	return h.visit(0, h[0].token, fn)
+}
+
+// visit calls fn with the scanner at i and its descendants in the heap, while they have the token
+func (h scannerHeap) visit(i int, token []byte, fn func(ss *sortableScanner) error) error {
+	if i >= len(h) || !bytes.Equal(h[i].token, token) {
+		return nil
+	}
+	if err := fn(h[i]); err != nil {
+		return err
+	}
+	if err := h.visit(2*i+1, token, fn); err != nil {
+		return err
+	}
+	return h.visit(2*i+2, token, fn)
 }
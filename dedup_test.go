@@ -2,8 +2,14 @@ package dedup
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -155,3 +161,662 @@ func TestDedup3(t *testing.T) {
 	}
 	t.Logf("Line count matches (%d)", i)
 }
+
+func TestSortChunksMerge(t *testing.T) {
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+
+	dir := t.TempDir()
+
+	// testdata.log has 100 distinct lines, 204 total lines. Try to sort 20 lines at a time
+	paths, err := SortChunks(dir, Options{TmpFileBytes: 20 * 50}, inFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) < 2 {
+		t.Fatalf("Expected multiple chunk files, got %d", len(paths))
+	}
+
+	// Every chunk must be sorted and deduplicated on its own
+	var chunks []*os.File
+	for _, path := range paths {
+		chunk, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer chunk.Close()
+		if _, err = Verify(chunk); err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	outFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+
+	stats, err := Merge(outFile, Options{}, chunks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.LinesRead != 202 || stats.LinesUnique != 100 || stats.LinesDuplicate != 102 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	// Seek to the beginning of the file to start reading from the beginning
+	_, err = outFile.Seek(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The merged output should be sorted, deduplicated, and have every distinct line
+	i, err := Verify(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 100 {
+		t.Fatalf("Merged file line length (%d) should be 100", i)
+	}
+}
+
+func TestMergeUnsorted(t *testing.T) {
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+
+	outFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+
+	// testdata.log is not sorted, so merging it must fail rather than let duplicates through
+	_, err = Merge(outFile, Options{}, inFile)
+	if err == nil {
+		t.Fatal("Expected an error merging an unsorted file")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		lines uint64
+		ok    bool
+	}{
+		{name: "empty", input: "", lines: 0, ok: true},
+		{name: "sorted", input: "a\nb\nc\n", lines: 3, ok: true},
+		{name: "no trailing new line", input: "a\nb\nc", lines: 3, ok: true},
+		{name: "duplicate", input: "a\nb\nb\nc\n", lines: 3, ok: false},
+		{name: "unsorted", input: "a\nc\nb\n", lines: 3, ok: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			lines, err := Verify(strings.NewReader(tc.input))
+			if (err == nil) != tc.ok {
+				t.Fatalf("Unexpected result: %v", err)
+			}
+			if lines != tc.lines {
+				t.Fatalf("Expected to stop at line %d, got %d", tc.lines, lines)
+			}
+		})
+	}
+}
+
+func TestCount(t *testing.T) {
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+
+	count, err := Count(inFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 204 {
+		t.Fatalf("Line count (%d) should be 204", count)
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+
+	// testdata.log has 100 distinct lines of 50 characters, 204 total lines. Try to dedup 20 lines at a time
+	stats, err := Run(nil, Options{TmpFileBytes: 20 * 50, DryRun: true}, inFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Stats{
+		LinesRead:      204,
+		BytesRead:      204 * 51,
+		LinesDuplicate: 104,
+		LinesUnique:    100,
+		BytesOut:       100 * 51,
+		Chunks:         stats.Chunks,
+		TmpLines:       stats.TmpLines,
+		TmpBytes:       stats.TmpBytes,
+		ChunkLines:     stats.ChunkLines,
+		SortElapsed:    stats.SortElapsed,
+		SpillWait:      stats.SpillWait,
+		Elapsed:        stats.Elapsed,
+		PhaseElapsed:   stats.PhaseElapsed,
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	if stats.Chunks < 2 || stats.TmpBytes < expected.BytesOut {
+		t.Fatalf("Expected multiple temporary files holding at least the output: %+v", stats)
+	}
+	if _, ok := stats.PhaseElapsed[PhaseMerging]; !ok || len(stats.PhaseElapsed) != 2 || stats.Elapsed <= 0 {
+		t.Fatalf("Expected the durations of the splitting and merging phases: %+v", stats)
+	}
+}
+
+func TestRunCountOnly(t *testing.T) {
+	input := "c\nb\nkeep a\nc\nkeep a\na\nb\n"
+	for _, tmpFileBytes := range []uint64{1000, 2} {
+		// Neither the lines passed through, nor the unique lines in whatever format, are written
+		opts := Options{TmpFileBytes: tmpFileBytes, CountOnly: true, Format: FormatJSON,
+			KeepPatterns: []*regexp.Regexp{regexp.MustCompile(`^[ab]$`)}, PassthroughUnkept: true}
+		stats, err := Run(nil, opts, strings.NewReader(input), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 2 || stats.LinesDuplicate != 1 || stats.LinesPassedThrough != 4 || stats.BytesOut != 0 {
+			t.Fatalf("Unexpected stats with %d tmp file bytes: %+v", tmpFileBytes, stats)
+		}
+	}
+
+	// Merging only counts as well
+	stats, err := Merge(nil, Options{CountOnly: true}, writeTemp(t, "a\nb\nb\n"), writeTemp(t, "a\nc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.LinesUnique != 3 || stats.LinesDuplicate != 2 || stats.BytesOut != 0 {
+		t.Fatalf("Unexpected merge stats: %+v", stats)
+	}
+}
+
+func TestRunSkipLastLine(t *testing.T) {
+	outFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+
+	// Skipping the final line must not leave anything behind in the output
+	pattern := regexp.MustCompile(`^skip$`)
+	stats, err := Run(outFile, Options{TmpFileBytes: 1000, SkipPatterns: []*regexp.Regexp{pattern}},
+		strings.NewReader("b\na\nb\nskip\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.LinesRead != 4 || stats.LinesSkipped != 1 || stats.LinesDuplicate != 1 || stats.LinesUnique != 2 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	content, err := os.ReadFile(outFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "a\nb\n" {
+		t.Fatalf("Unexpected output: %q", content)
+	}
+}
+
+func TestRunTempDir(t *testing.T) {
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+
+	// Every temporary file must be created in the temp dir
+	dir := t.TempDir()
+	var chunks []string
+	opts := Options{TmpFileBytes: 20 * 50, TempDir: dir, DryRun: true, OnEvent: func(e Event) {
+		if e.Kind == EventChunkCreated {
+			chunks = append(chunks, e.File)
+		}
+	}}
+	if _, err = Run(nil, opts, inFile, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("Expected multiple temporary files: %v", chunks)
+	}
+	for _, chunk := range chunks {
+		if filepath.Dir(chunk) != dir {
+			t.Fatalf("Temporary file %s is not in %s", chunk, dir)
+		}
+	}
+
+	// Missing directories and regular files must be rejected before reading anything
+	for _, bad := range []string{filepath.Join(dir, "missing"), inFile.Name()} {
+		opts.TempDir = bad
+		if _, err = Run(nil, opts, strings.NewReader("a\n"), nil); err == nil {
+			t.Fatalf("Expected an error for temp dir %s", bad)
+		}
+	}
+}
+
+func TestRunKeepTempFiles(t *testing.T) {
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+
+	dir := t.TempDir()
+	stats, err := Run(nil, Options{TmpFileBytes: 20 * 50, TempDir: dir, KeepTempFiles: true, DryRun: true}, inFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.TmpFiles) != stats.Chunks || stats.Chunks < 2 {
+		t.Fatalf("Expected every temporary file to be returned: %+v", stats)
+	}
+
+	// The kept chunks must still exist, and merge into the same result
+	chunks := make([]*os.File, len(stats.TmpFiles))
+	for i, path := range stats.TmpFiles {
+		if chunks[i], err = os.Open(path); err != nil {
+			t.Fatal(err)
+		}
+		defer chunks[i].Close()
+	}
+	merged, err := Merge(nil, Options{DryRun: true}, chunks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.LinesUnique != stats.LinesUnique || merged.BytesOut != stats.BytesOut {
+		t.Fatalf("Merging the kept files gave %+v, expected %+v", merged, stats)
+	}
+}
+
+func TestRunOnDuplicate(t *testing.T) {
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+
+	// Every removed line must be reported, whether it was found while splitting or while merging
+	var duplicates []string
+	opts := Options{TmpFileBytes: 20 * 50, DryRun: true, OnDuplicate: func(line string) error {
+		duplicates = append(duplicates, line)
+		return nil
+	}}
+	stats, err := Run(nil, opts, inFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(len(duplicates)) != stats.LinesDuplicate || stats.Chunks < 2 {
+		t.Fatalf("Reported %d duplicates, expected %d: %+v", len(duplicates), stats.LinesDuplicate, stats)
+	}
+
+	// An error from the callback must stop the run
+	errStop := errors.New("stop")
+	opts.OnDuplicate = func(string) error { return errStop }
+	if _, err = Run(nil, opts, strings.NewReader("a\nb\na\nc\n"), nil); err != errStop {
+		t.Fatalf("Expected the run to stop with the callback error, got: %v", err)
+	}
+}
+
+func TestRunOnDuplicateAt(t *testing.T) {
+	// Duplicates found while splitting are where they were read, and those found while merging only
+	// if where lines were first read is tracked
+	tests := []struct {
+		opts     Options
+		expected []Position
+	}{
+		{opts: Options{TmpFileBytes: 1000}, expected: []Position{{Source: "a.log", Line: 3}, {Source: "b.log", Line: 1}}},
+		{opts: Options{TmpFileBytes: 1000, BuildWorkers: 2}, expected: []Position{{Source: "a.log", Line: 3}, {Source: "b.log", Line: 1}}},
+		{opts: Options{TmpFileBytes: 2}, expected: []Position{{}, {}}},
+		{opts: Options{TmpFileBytes: 2, Format: FormatJSON}, expected: []Position{{Source: "a.log", Line: 3}, {Source: "b.log", Line: 1}}},
+	}
+	for _, tt := range tests {
+		input := NewSources(
+			Source{Name: "a.log", Reader: strings.NewReader("x\ny\nx\n")},
+			Source{Name: "b.log", Reader: strings.NewReader("y\n")},
+		)
+		var positions []Position
+		tt.opts.DryRun = true
+		tt.opts.OnDuplicateAt = func(line string, pos Position) error {
+			positions = append(positions, pos)
+			return nil
+		}
+		if _, err := Run(nil, tt.opts, input, nil); err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(positions, func(i, j int) bool {
+			return positions[i].Source < positions[j].Source
+		})
+		if !reflect.DeepEqual(positions, tt.expected) {
+			t.Fatalf("Unexpected positions with %+v: %+v", tt.opts, positions)
+		}
+	}
+}
+
+func TestRunLimit(t *testing.T) {
+	input := "e\nb\nd\nb\na\nc\na\nf\n"
+
+	// The limit must give the first unique lines, whether they fit in memory or were merged from chunks
+	for _, tmpFileBytes := range []uint64{1000, 4} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		stats, err := Run(outFile, Options{TmpFileBytes: tmpFileBytes, Limit: 3}, strings.NewReader(input), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 3 || !stats.Limited {
+			t.Fatalf("Unexpected stats with %d tmp file bytes: %+v", tmpFileBytes, stats)
+		}
+
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "a\nb\nc\n" {
+			t.Fatalf("Unexpected output with %d tmp file bytes: %q", tmpFileBytes, content)
+		}
+	}
+
+	// A limit that is not reached must not change anything
+	stats, err := Run(nil, Options{TmpFileBytes: 4, Limit: 6, DryRun: true}, strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.LinesUnique != 6 || stats.LinesDuplicate != 2 || stats.Limited {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestRunSlice(t *testing.T) {
+	tests := []struct {
+		skipLines uint64
+		maxLines  uint64
+		expected  string
+	}{
+		{skipLines: 0, maxLines: 0, expected: "a\nb\nc\nd\n"},
+		{skipLines: 2, maxLines: 0, expected: "a\nb\nd\n"},
+		{skipLines: 0, maxLines: 3, expected: "a\nb\nc\n"},
+		{skipLines: 1, maxLines: 3, expected: "a\nb\n"},
+		{skipLines: 6, maxLines: 0, expected: ""},
+	}
+	for _, tt := range tests {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		opts := Options{TmpFileBytes: 4, SkipLines: tt.skipLines, MaxLines: tt.maxLines}
+		stats, err := Run(outFile, opts, strings.NewReader("c\na\nb\na\nd\nb\n"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != tt.expected {
+			t.Fatalf("Unexpected output skipping %d lines with a max of %d: %q", tt.skipLines, tt.maxLines, content)
+		}
+		if stats.LinesRead != stats.LinesDuplicate+stats.LinesUnique {
+			t.Fatalf("Unexpected stats skipping %d lines with a max of %d: %+v", tt.skipLines, tt.maxLines, stats)
+		}
+	}
+}
+
+func TestRunBufferSizes(t *testing.T) {
+	// Lines longer than the default merge buffer must be merged, and tiny write buffers must still work
+	long := strings.Repeat("x", 100*1024)
+	input := long + "b\n" + long + "a\n" + long + "b\n"
+	outFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+
+	opts := Options{TmpFileBytes: 1, ReadBufferSize: 512 * 1024, WriteBufferSize: 16, MergeBufferSize: 16}
+	stats, err := Run(outFile, opts, strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Chunks != 3 || stats.LinesUnique != 2 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	content, err := os.ReadFile(outFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != long+"a\n"+long+"b\n" {
+		t.Fatalf("Unexpected output of %d bytes", len(content))
+	}
+}
+
+func TestRunDelimiter(t *testing.T) {
+	// Lines may contain new lines, and the two byte delimiter is split across buffer reads of the count
+	input := "b\nx\r\na\r\nb\nx\r\nc\r\na"
+	for _, tmpFileBytes := range []uint64{1, 1000} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		opts := Options{TmpFileBytes: tmpFileBytes, ReadBufferSize: 3, Delimiter: "\r\n"}
+		stats, err := Run(outFile, opts, strings.NewReader(input), strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesRead != 5 || stats.LinesUnique != 3 || stats.BytesRead != uint64(len(input))+2 {
+			t.Fatalf("Unexpected stats: %+v", stats)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "a\r\nb\nx\r\nc\r\n" {
+			t.Fatalf("Unexpected output: %q", content)
+		}
+	}
+
+	for input, expected := range map[string]uint64{"": 0, "a": 1, "a\r\n": 1, "a\r\r\n\r": 2, input: 5} {
+		count, err := countLines(strings.NewReader(input), 3, "\r\n", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != expected {
+			t.Fatalf("Expected %d lines counted in %q, got %d", expected, input, count)
+		}
+	}
+}
+
+func TestRunCRLF(t *testing.T) {
+	// Input lines may end in either, and the output lines all end in \r\n, whether merged from chunks or not
+	input := "b\r\na\nb\nc\r\na"
+	for _, tmpFileBytes := range []uint64{1, 1000} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		stats, err := Run(outFile, Options{TmpFileBytes: tmpFileBytes, CRLF: true}, strings.NewReader(input), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "a\r\nb\r\nc\r\n" {
+			t.Fatalf("Unexpected output: %q", content)
+		}
+		if stats.LinesUnique != 3 || stats.BytesOut != uint64(len(content)) {
+			t.Fatalf("Unexpected stats: %+v", stats)
+		}
+	}
+}
+
+func TestRunManyChunks(t *testing.T) {
+	// Hundreds of chunks, with every line duplicated within and between many of them
+	var input strings.Builder
+	counts := make(map[string]uint64)
+	for i := 0; i < 5000; i++ {
+		line := fmt.Sprintf("%03d", (i*7919)%700)
+		input.WriteString(line + "\n")
+		counts[line]++
+	}
+
+	var duplicated []string
+	opts := Options{
+		TmpFileBytes: 40,
+		DryRun:       true,
+		OnDuplicateCount: func(line string, count uint64) error {
+			if count != counts[line] {
+				t.Fatalf("Expected %d occurrences of %q; Got: %d", counts[line], line, count)
+			}
+			duplicated = append(duplicated, line)
+			return nil
+		},
+	}
+	stats, err := Run(nil, opts, strings.NewReader(input.String()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Chunks < 100 || stats.LinesUnique != 700 || stats.LinesDuplicate != 5000-700 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	if len(duplicated) != 700 || !sort.StringsAreSorted(duplicated) {
+		t.Fatalf("Expected every line to be reported once, in order; Got: %v", duplicated)
+	}
+}
+
+// benchmarkInput returns lines of which only distinct are different, repeated in a scattered order
+func benchmarkInput(lines, distinct int) string {
+	var input strings.Builder
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&input, "https://example.com/path/%08d\n", (i*7919)%distinct)
+	}
+	return input.String()
+}
+
+func BenchmarkRun(b *testing.B) {
+	for _, bc := range []struct {
+		name         string
+		distinct     int
+		tmpFileBytes uint64
+		skip         bool
+	}{
+		{"unique", 100000, 1 << 30, false},
+		{"duplicates", 1000, 1 << 30, false},
+		{"duplicates-skip-patterns", 1000, 1 << 30, true},
+		{"spilling", 100000, 1 << 20, false},
+		{"many-chunks", 100000, 64 << 10, false},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			input := benchmarkInput(100000, bc.distinct)
+			opts := Options{TmpFileBytes: bc.tmpFileBytes, DryRun: true, OnEvent: func(Event) {}, OnProgress: func(Progress) {}}
+			if bc.skip {
+				opts.SkipPatterns = []*regexp.Regexp{regexp.MustCompile(`/skipped/`)}
+			}
+			b.SetBytes(int64(len(input)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Run(nil, opts, strings.NewReader(input), nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRunSets(b *testing.B) {
+	input := benchmarkInput(100000, 50000)
+	for _, bc := range []struct {
+		name string
+		opts Options
+	}{
+		{"map", Options{}},
+		{"hash-set", Options{HashSet: true}},
+		{"prefix-set", Options{PrefixSet: true}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			opts := bc.opts
+			opts.TmpFileBytes, opts.DryRun = 1<<20, true
+			opts.OnEvent, opts.OnProgress = func(Event) {}, func(Progress) {}
+			b.SetBytes(int64(len(input)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Run(nil, opts, strings.NewReader(input), nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	// Sorted chunks of the lines, each with half of the lines of the one before it
+	var chunks []*os.File
+	var size int64
+	for c := 0; c < 8; c++ {
+		f, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		keys := strings.Split(strings.TrimSuffix(benchmarkInput(20000, 20000), "\n"), "\n")
+		sort.Strings(keys)
+		for i, key := range keys {
+			if i%(c+1) == 0 {
+				n, _ := fmt.Fprintln(f, key)
+				size += int64(n)
+			}
+		}
+		chunks = append(chunks, f)
+	}
+
+	opts := Options{DryRun: true, OnEvent: func(Event) {}, OnProgress: func(Progress) {}}
+	b.SetBytes(size)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, f := range chunks {
+			if _, err := f.Seek(0, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if _, err := Merge(nil, opts, chunks...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
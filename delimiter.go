@@ -0,0 +1,138 @@
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// defaultDelimiter ends each line of the output and temporary files, when no delimiter is configured
+const defaultDelimiter = "\n"
+
+// lineEnding returns what ends each line of the output, which is the delimiter unless lines are
+// ended with "\r\n" by CRLF
+func (j *job) lineEnding() string {
+	if j.opts.CRLF && j.opts.Delimiter == "" {
+		return "\r\n"
+	}
+	return j.delim
+}
+
+// newScanner returns a scanner of the lines of r, split by the delimiter of the job, with an
+// initial buffer of bufSize bytes that can grow up to the maximum line length
+func (j *job) newScanner(r io.Reader, bufSize int) *bufio.Scanner {
+	return j.newScannerBuffer(r, make([]byte, 0, bufSize), j.maxLineLength())
+}
+
+// newScannerBuffer returns a scanner like newScanner, with the buffer given to start with, that can
+// grow up to max bytes
+func (j *job) newScannerBuffer(r io.Reader, buf []byte, max int) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(buf, max)
+	scanner.Split(j.split())
+	return scanner
+}
+
+// split returns the split function for the lines of the input and temporary files
+func (j *job) split() bufio.SplitFunc {
+	// Without a configured delimiter, lines ending in \r\n are also accepted, as they always were
+	if j.opts.Delimiter == "" {
+		return bufio.ScanLines
+	}
+	return splitOn(j.opts.Delimiter)
+}
+
+// splitOn returns a split function for a scanner, that splits on the delimiter exactly
+func splitOn(delimiter string) bufio.SplitFunc {
+	delim := []byte(delimiter)
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := bytes.Index(data, delim); i >= 0 {
+			return i + len(delim), data[:i], nil
+		}
+		// The final line does not need to end with a delimiter
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// validateLineEnding returns an error if the output can not be written without its final line ending
+func validateLineEnding(opts Options) error {
+	if !opts.NoFinalLineEnding {
+		return nil
+	}
+	if opts.Format != "" && opts.Format != FormatText {
+		return fmt.Errorf("leaving off the final line ending is not supported with the %s format", opts.Format)
+	}
+	if opts.OutputMmap {
+		return fmt.Errorf("leaving off the final line ending is not supported with output mmap")
+	}
+	return nil
+}
+
+// finalLineWriter holds back whatever of the line ending the output written through it ends with,
+// until more is written, so that the output can be finished without the line ending of its last line,
+// with NoFinalLineEnding
+type finalLineWriter struct {
+	w      io.Writer
+	ending string
+	held   []byte
+}
+
+// withoutFinalLineEnding returns a writer of the output that leaves off its final line ending once
+// finished, or nil without NoFinalLineEnding
+func (j *job) withoutFinalLineEnding(w io.Writer) *finalLineWriter {
+	if !j.opts.NoFinalLineEnding {
+		return nil
+	}
+	return &finalLineWriter{w: w, ending: j.lineEnding()}
+}
+
+func (w *finalLineWriter) Write(p []byte) (int, error) {
+	// What was held back is only kept with a write too short to hold a line ending of its own
+	data := p
+	if len(p) < len(w.ending) {
+		data = append(w.held, p...)
+	} else if len(w.held) > 0 {
+		if _, err := w.w.Write(w.held); err != nil {
+			return 0, err
+		}
+	}
+	held := 0
+	for k := len(w.ending); k > 0; k-- {
+		if bytes.HasSuffix(data, []byte(w.ending[:k])) {
+			held = k
+			break
+		}
+	}
+	if _, err := w.w.Write(data[:len(data)-held]); err != nil {
+		return 0, err
+	}
+	w.held = append(w.held[:0], data[len(data)-held:]...)
+	return len(p), nil
+}
+
+// Name returns the name of the output written to, to log it by
+func (w *finalLineWriter) Name() string {
+	return outputName(w.w)
+}
+
+// finish writes what was held back, unless it is the final line ending, which is left off and no
+// longer counted in the bytes of the output. It does nothing on a nil writer.
+func (w *finalLineWriter) finish(stats *Stats) error {
+	if w == nil {
+		return nil
+	}
+	if string(w.held) == w.ending {
+		stats.BytesOut -= uint64(len(w.ending))
+		w.held = w.held[:0]
+		return nil
+	}
+	_, err := w.w.Write(w.held)
+	return err
+}
@@ -0,0 +1,100 @@
+package dedup
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunNoFinalLineEnding(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		input    string
+		expected string
+	}{
+		{name: "lf", input: "b\na\nb\nc\n", expected: "a\nb\nc"},
+		{name: "crlf", opts: Options{CRLF: true}, input: "b\r\na\nb\nc", expected: "a\r\nb\r\nc"},
+		{name: "delimiter", opts: Options{Delimiter: "\x00\x00"}, input: "b\x00\x00a\x00\x00b", expected: "a\x00\x00b"},
+		{name: "one line", input: "a\na\n", expected: "a"},
+		{name: "empty line", input: "\na\n", expected: "\na"},
+		{name: "empty", input: "", expected: ""},
+	}
+	for _, tt := range tests {
+		for _, tmpFileBytes := range []uint64{1000, 2} {
+			outFile, err := os.CreateTemp("", "dedup.test.*.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(outFile.Name())
+			defer outFile.Close()
+
+			opts := tt.opts
+			opts.TmpFileBytes, opts.NoFinalLineEnding, opts.VerifyWrites = tmpFileBytes, true, true
+			stats, err := Run(outFile, opts, strings.NewReader(tt.input), nil)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			content, err := os.ReadFile(outFile.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.expected {
+				t.Fatalf("Unexpected output of %s with %d tmp file bytes: %q", tt.name, tmpFileBytes, content)
+			}
+			if stats.BytesOut != uint64(len(content)) {
+				t.Fatalf("Expected %s to count %d bytes out, got %d", tt.name, len(content), stats.BytesOut)
+			}
+		}
+	}
+}
+
+func TestMergeNoFinalLineEnding(t *testing.T) {
+	var inFiles []*os.File
+	for _, content := range []string{"a\r\nc\r\n", "b\nc\n"} {
+		inFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(inFile.Name())
+		defer inFile.Close()
+		if _, err = inFile.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = inFile.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		inFiles = append(inFiles, inFile)
+	}
+
+	outFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+
+	if _, err = Merge(outFile, Options{CRLF: true, NoFinalLineEnding: true, VerifyWrites: true}, inFiles...); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(outFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "a\r\nb\r\nc" {
+		t.Fatalf("Unexpected output: %q", content)
+	}
+}
+
+func TestRunNoFinalLineEndingFormat(t *testing.T) {
+	outFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+
+	if _, err = Run(outFile, Options{Format: FormatCSV, NoFinalLineEnding: true}, strings.NewReader("a\n"), nil); err == nil {
+		t.Fatal("Expected an error leaving the final line ending off a csv output")
+	}
+}
@@ -0,0 +1,127 @@
+package dedup
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DiffStats summarize a comparison of two inputs
+type DiffStats struct {
+	// LinesAdded is the number of unique lines only in the new input
+	LinesAdded uint64
+
+	// LinesRemoved is the number of unique lines only in the old input
+	LinesRemoved uint64
+
+	// LinesCommon is the number of unique lines in both inputs
+	LinesCommon uint64
+
+	// Old and New are the stats of deduplicating each input
+	Old, New Stats
+}
+
+// Diff compares the unique lines of two inputs, neither of which needs to be sorted or fit in memory.
+// Each input is deduplicated and sorted by Run into a temporary file, and the two files are then
+// read together in sorted order. onRemoved is called with every line only in oldFile, and onAdded
+// with every line only in newFile, both in sorted order; either may be nil. The options configure
+// both runs, except that Limit, OnAudit, OnLineMapped, Seen, Reference, OnIndex, OnDone, Format,
+// DryRun, and CountOnly are ignored, and passed through lines can not be compared.
+func Diff(opts Options, oldFile, newFile io.Reader, onRemoved, onAdded func(line string) error) (DiffStats, error) {
+	if opts.PassthroughUnkept {
+		return DiffStats{}, fmt.Errorf("passed through lines can not be compared")
+	}
+	for _, rule := range opts.Rules {
+		if rule.Action == RulePassthrough {
+			return DiffStats{}, fmt.Errorf("passed through lines can not be compared")
+		}
+	}
+	opts.Limit = 0
+	opts.OnAudit = nil
+	opts.OnLineMapped = nil
+	opts.Seen = nil
+	opts.Reference = nil
+	opts.OnIndex = nil
+	opts.OnDone = nil
+	opts.Format = FormatText
+	opts.DryRun = false
+	opts.CountOnly = false
+
+	var stats DiffStats
+	oldSorted, err := diffSorted(opts, oldFile, &stats.Old)
+	if oldSorted != nil {
+		defer os.Remove(oldSorted.Name())
+		defer oldSorted.Close()
+	}
+	if err != nil {
+		return stats, err
+	}
+	newSorted, err := diffSorted(opts, newFile, &stats.New)
+	if newSorted != nil {
+		defer os.Remove(newSorted.Name())
+		defer newSorted.Close()
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	// Walk both sorted files together, reading from whichever is behind
+	j := &job{opts: opts}
+	bufSize := bufferSize(opts.MergeBufferSize, defaultMergeBufferSize)
+	oldScanner := j.newScanner(oldSorted, bufSize)
+	newScanner := j.newScanner(newSorted, bufSize)
+	oldOk, newOk := oldScanner.Scan(), newScanner.Scan()
+	for oldOk || newOk {
+		// A file that has run out sorts after every line of the other file
+		cmp := -1
+		switch {
+		case !oldOk:
+			cmp = 1
+		case newOk:
+			cmp = bytes.Compare(oldScanner.Bytes(), newScanner.Bytes())
+		}
+
+		switch {
+		case cmp < 0:
+			stats.LinesRemoved++
+			if onRemoved != nil {
+				if err = onRemoved(oldScanner.Text()); err != nil {
+					return stats, err
+				}
+			}
+			oldOk = oldScanner.Scan()
+
+		case cmp > 0:
+			stats.LinesAdded++
+			if onAdded != nil {
+				if err = onAdded(newScanner.Text()); err != nil {
+					return stats, err
+				}
+			}
+			newOk = newScanner.Scan()
+
+		default:
+			stats.LinesCommon++
+			oldOk, newOk = oldScanner.Scan(), newScanner.Scan()
+		}
+	}
+	if err = oldScanner.Err(); err != nil {
+		return stats, err
+	}
+	return stats, newScanner.Err()
+}
+
+// diffSorted deduplicates and sorts the input into a new temporary file, rewound to its start.
+// It returns the file if it was created, even if there was an error writing to it.
+func diffSorted(opts Options, inFile io.Reader, stats *Stats) (*os.File, error) {
+	sorted, err := CreateTemp(opts.TempDir, "diff.*.log")
+	if err != nil {
+		return nil, err
+	}
+	if *stats, err = Run(sorted, opts, inFile, nil); err != nil {
+		return sorted, err
+	}
+	_, err = sorted.Seek(0, io.SeekStart)
+	return sorted, err
+}
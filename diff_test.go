@@ -0,0 +1,41 @@
+package dedup
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	oldInput := "c\na\nb\na\ne\n"
+	newInput := "d\nb\nf\nb\nc\ng\n"
+
+	// Whether everything fits in memory or not
+	for _, tmpFileBytes := range []uint64{1000, 4, 1} {
+		var removed, added []string
+		opts := Options{TmpFileBytes: tmpFileBytes}
+		stats, err := Diff(opts, strings.NewReader(oldInput), strings.NewReader(newInput),
+			func(line string) error {
+				removed = append(removed, line)
+				return nil
+			},
+			func(line string) error {
+				added = append(added, line)
+				return nil
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(removed, []string{"a", "e"}) || !reflect.DeepEqual(added, []string{"d", "f", "g"}) {
+			t.Fatalf("Unexpected diff with %d tmp file bytes; Removed: %v; Added: %v", tmpFileBytes, removed, added)
+		}
+		if stats.LinesRemoved != 2 || stats.LinesAdded != 3 || stats.LinesCommon != 2 ||
+			stats.Old.LinesRead != 5 || stats.New.LinesRead != 6 {
+			t.Fatalf("Unexpected stats with %d tmp file bytes: %+v", tmpFileBytes, stats)
+		}
+	}
+
+	if _, err := Diff(Options{PassthroughUnkept: true}, strings.NewReader(""), strings.NewReader(""), nil, nil); err == nil {
+		t.Fatal("Expected an error comparing passed through lines")
+	}
+}
@@ -0,0 +1,182 @@
+package dedup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// diskCheckBytes is how many bytes are written to the output between checks of its free space
+const diskCheckBytes = 64 << 20
+
+// DiskSpaceError is returned when a filesystem the run writes to does not have the space it is
+// estimated to need before starting, has less than MinFreeBytes left while running, or is full
+type DiskSpaceError struct {
+	// Path is the directory or file on the filesystem
+	Path string
+
+	// Free is the bytes available on the filesystem
+	Free uint64
+
+	// Needed is the bytes that should have been available. Once the filesystem is full, it is the
+	// most that is estimated to still be written to finish, from the checkpoint if there is one.
+	Needed uint64
+
+	// Checkpoint is the manifest the run can be resumed from once there is room, if any
+	Checkpoint string
+
+	// Err is the error of the write that found the filesystem full, if it was
+	Err error
+}
+
+func (e *DiskSpaceError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("not enough free space for %s: %d bytes free, %d bytes needed", e.Path, e.Free, e.Needed)
+	}
+	msg := fmt.Sprintf("out of space for %s, with %d bytes free and up to %d bytes needed to finish: %v", e.Path, e.Free, e.Needed, e.Err)
+	if e.Checkpoint != "" {
+		msg += fmt.Sprint("; resume from checkpoint ", e.Checkpoint, " once there is room")
+	}
+	return msg
+}
+
+func (e *DiskSpaceError) Unwrap() error {
+	return e.Err
+}
+
+// checkDiskSpace returns a DiskSpaceError, with MinFreeBytes, if the temporary directory or the directory
+// of the output file does not have room for what the run is estimated to write to it, plus MinFreeBytes.
+// Unless the input fits in memory, the temporary files hold up to every line of the input, and the
+// output at most all of them, so each is estimated as the size of the input, if it is known. Where
+// both are on the same filesystem, it needs room for both.
+func (j *job) checkDiskSpace() error {
+	if j.opts.MinFreeBytes == 0 {
+		return nil
+	}
+	size := j.inputSize
+	type need struct {
+		path  string
+		disk  diskUsage
+		bytes uint64
+	}
+	var needs []need
+	add := func(path string) {
+		disk, ok := diskOf(path)
+		if !ok {
+			return
+		}
+		for i := range needs {
+			if needs[i].disk.device == disk.device {
+				needs[i].bytes += size
+				return
+			}
+		}
+		needs = append(needs, need{path: path, disk: disk, bytes: size + j.opts.MinFreeBytes})
+	}
+	add(j.tempDir())
+	if j.outFile != nil {
+		add(filepath.Dir(j.outFile.Name()))
+	}
+	for _, n := range needs {
+		if n.disk.free < n.bytes {
+			return &DiskSpaceError{Path: n.path, Free: n.disk.free, Needed: n.bytes}
+		}
+	}
+	return nil
+}
+
+// diskFullError returns the error of a write that failed because the filesystem is full as a
+// DiskSpaceError, with the most that is estimated to still be written to finish. The temporary files
+// of the rest of the input, after the checkpoint if there is one, take up to its size, and the output,
+// unless it is a dry run, up to the size of every temporary file. With Checkpoint, the chunks in it
+// are kept, to be resumed from.
+func (j *job) diskFullError(err error) error {
+	var diskErr *DiskSpaceError
+	if err == nil || !isNoSpace(err) || errors.As(err, &diskErr) {
+		return err
+	}
+	diskErr = &DiskSpaceError{Path: j.tempDir(), Err: err}
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		diskErr.Path = pathErr.Path
+	}
+	if disk, ok := diskOf(filepath.Dir(diskErr.Path)); ok {
+		diskErr.Free = disk.free
+	}
+
+	read, written := j.stats.BytesRead, j.stats.TmpBytes
+	if j.checkpoint != nil {
+		diskErr.Checkpoint = j.opts.Checkpoint
+		read, written = j.checkpoint.Offset, 0
+		for _, chunk := range j.checkpoint.Chunks {
+			for _, bytes := range chunk.Bytes {
+				written += bytes
+			}
+		}
+	}
+	var rest uint64
+	if j.inputSize > read {
+		rest = j.inputSize - read
+	}
+	diskErr.Needed = rest
+	if !j.opts.DryRun {
+		diskErr.Needed += written + rest
+	}
+	return diskErr
+}
+
+// checkFreeSpace returns a DiskSpaceError if the filesystem of the path has less than MinFreeBytes free
+func (j *job) checkFreeSpace(path string) error {
+	if j.opts.MinFreeBytes == 0 {
+		return nil
+	}
+	disk, ok := diskOf(path)
+	if ok && disk.free < j.opts.MinFreeBytes {
+		return &DiskSpaceError{Path: path, Free: disk.free, Needed: j.opts.MinFreeBytes}
+	}
+	return nil
+}
+
+// tempDir returns the directory the temporary files are written to
+func (j *job) tempDir() string {
+	if j.dir == "" {
+		return os.TempDir()
+	}
+	return j.dir
+}
+
+// diskWatcher is a writer to a file that checks the free space of its filesystem every
+// diskCheckBytes written, and fails once it is less than MinFreeBytes
+type diskWatcher struct {
+	w     io.Writer
+	j     *job
+	path  string
+	since int
+}
+
+func (w *diskWatcher) Write(p []byte) (int, error) {
+	w.since += len(p)
+	if w.since >= diskCheckBytes {
+		w.since = 0
+		if err := w.j.checkFreeSpace(w.path); err != nil {
+			return 0, err
+		}
+	}
+	return w.w.Write(p)
+}
+
+// Name returns the name of the file written to, to log it by
+func (w *diskWatcher) Name() string {
+	return outputName(w.w)
+}
+
+// watchDisk returns the writer checking the free space of the output file as it is written to, with
+// MinFreeBytes, or the writer itself
+func (j *job) watchDisk(w io.Writer) io.Writer {
+	if j.opts.MinFreeBytes == 0 || j.outFile == nil {
+		return w
+	}
+	return &diskWatcher{w: w, j: j, path: filepath.Dir(j.outFile.Name())}
+}
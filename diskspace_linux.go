@@ -0,0 +1,29 @@
+package dedup
+
+import (
+	"os"
+	"syscall"
+)
+
+// diskUsage is the device of a filesystem, and the bytes available on it to unprivileged users
+type diskUsage struct {
+	device uint64
+	free   uint64
+}
+
+// diskOf returns the usage of the filesystem of the path, or false if it can not be read
+func diskOf(path string) (diskUsage, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return diskUsage{}, false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return diskUsage{}, false
+	}
+	var fs syscall.Statfs_t
+	if err = syscall.Statfs(path, &fs); err != nil {
+		return diskUsage{}, false
+	}
+	return diskUsage{device: uint64(st.Dev), free: uint64(fs.Bavail) * uint64(fs.Bsize)}, true
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package dedup
+
+// diskUsage is the device of a filesystem, and the bytes available on it to unprivileged users
+type diskUsage struct {
+	device uint64
+	free   uint64
+}
+
+// diskOf returns false, because the usage of filesystems is not read here
+func diskOf(path string) (diskUsage, bool) {
+	return diskUsage{}, false
+}
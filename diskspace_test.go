@@ -0,0 +1,52 @@
+package dedup
+
+import (
+	"errors"
+	"math"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestRunMinFreeBytes(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("free space is only checked on linux")
+	}
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+
+	// No filesystem has this much free, so the run fails before reading anything
+	dir := t.TempDir()
+	stats, err := Run(nil, Options{TempDir: dir, MinFreeBytes: math.MaxUint64 / 2, DryRun: true}, inFile, nil)
+	var diskErr *DiskSpaceError
+	if !errors.As(err, &diskErr) || diskErr.Path != dir || diskErr.Free >= diskErr.Needed {
+		t.Fatalf("Expected a disk space error for %s; Got: %v", dir, err)
+	}
+	if stats.LinesRead != 0 {
+		t.Fatalf("Expected nothing to be read; Got: %d lines", stats.LinesRead)
+	}
+
+	// A little headroom is always there
+	if _, err = Run(nil, Options{TempDir: dir, TmpFileBytes: 20 * 50, MinFreeBytes: 1, DryRun: true}, inFile, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("free space is only checked on linux")
+	}
+	dir := t.TempDir()
+	j := &job{opts: Options{MinFreeBytes: math.MaxUint64}, dir: dir}
+	var diskErr *DiskSpaceError
+	if err := j.checkFreeSpace(dir); !errors.As(err, &diskErr) || diskErr.Needed != math.MaxUint64 {
+		t.Fatalf("Expected a disk space error; Got: %v", err)
+	}
+	j.opts.MinFreeBytes = 0
+	if err := j.checkFreeSpace(dir); err != nil {
+		t.Fatal(err)
+	}
+}
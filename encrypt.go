@@ -0,0 +1,220 @@
+package dedup
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// encryptSegmentSize is how many bytes of a temporary file are sealed at a time, each with a
+	// header of its sealed length, whose highest bit marks the last segment of the file
+	encryptSegmentSize = 64 << 10
+	encryptLastSegment = 1 << 31
+
+	// encryptPrefixSize is the bytes of the random prefix of the nonces of a file, written at its
+	// start, which are followed by the number of the segment
+	encryptPrefixSize = 8
+)
+
+// tempCipher is the AES-256-GCM cipher the temporary files of a run are encrypted with, with
+// EncryptTempFiles, whose key is random and only ever held in memory. It is created once it is first
+// needed, by whichever goroutine is first to write a temporary file.
+type tempCipher struct {
+	once sync.Once
+	aead cipher.AEAD
+	err  error
+}
+
+// get returns the cipher, creating it with a new random key the first time
+func (c *tempCipher) get() (cipher.AEAD, error) {
+	c.once.Do(func() {
+		key := make([]byte, 32)
+		if _, c.err = rand.Read(key); c.err != nil {
+			return
+		}
+		var block cipher.Block
+		if block, c.err = aes.NewCipher(key); c.err != nil {
+			return
+		}
+		c.aead, c.err = cipher.NewGCM(block)
+	})
+	return c.aead, c.err
+}
+
+// validateEncryption returns an error if the temporary files are encrypted, but must be read once the
+// run is over, when the key is gone
+func validateEncryption(opts Options) error {
+	if opts.EncryptTempFiles && (opts.KeepTempFiles || opts.Checkpoint != "") {
+		return fmt.Errorf("encrypted temporary files can not be kept or checkpointed, because their key is lost once the run is over")
+	}
+	return nil
+}
+
+// encryptWriter seals what is written to it a segment at a time into the writer of a temporary file.
+// It must be closed to write the last segment.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	nonce   []byte
+	buf     []byte
+	sealed  []byte
+	segment uint32
+	started bool
+}
+
+// encryptTemp returns a writer encrypting into the writer of a temporary file, with EncryptTempFiles,
+// or the writer itself, which must be closed once everything has been written to it
+func (j *job) encryptTemp(w io.Writer) (io.WriteCloser, error) {
+	if !j.opts.EncryptTempFiles {
+		return nopWriteCloser{w}, nil
+	}
+	aead, err := j.tempCipher.get()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce[:encryptPrefixSize]); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, nonce: nonce, buf: make([]byte, 0, encryptSegmentSize)}, nil
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+		if len(w.buf) == cap(w.buf) {
+			if err := w.seal(false); err != nil {
+				return written - n, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close seals and writes the last segment, which may be empty
+func (w *encryptWriter) Close() error {
+	return w.seal(true)
+}
+
+// seal writes the buffered segment, after the nonce prefix of the file if it is the first
+func (w *encryptWriter) seal(last bool) error {
+	if !w.started {
+		w.started = true
+		if _, err := w.w.Write(w.nonce[:encryptPrefixSize]); err != nil {
+			return err
+		}
+	}
+	binary.BigEndian.PutUint32(w.nonce[encryptPrefixSize:], w.segment)
+	w.segment++
+	header := uint32(len(w.buf) + w.aead.Overhead())
+	if last {
+		header |= encryptLastSegment
+	}
+	w.sealed = append(w.sealed[:0], 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(w.sealed, header)
+	w.sealed = w.aead.Seal(w.sealed, w.nonce, w.buf, w.sealed[:4])
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(w.sealed)
+	return err
+}
+
+// decryptReader opens the segments of an encrypted temporary file as it is read
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	nonce   []byte
+	sealed  []byte
+	buf     []byte
+	segment uint32
+	started bool
+	last    bool
+}
+
+// decryptTemp returns a reader decrypting the reader of a temporary file, with EncryptTempFiles,
+// or the reader itself
+func (j *job) decryptTemp(r io.Reader) (io.Reader, error) {
+	if !j.opts.EncryptTempFiles {
+		return r, nil
+	}
+	aead, err := j.tempCipher.get()
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: r, aead: aead, nonce: make([]byte, aead.NonceSize())}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.last {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// open reads and opens the next segment, after the nonce prefix of the file if it is the first
+func (r *decryptReader) open() error {
+	if !r.started {
+		r.started = true
+		if _, err := io.ReadFull(r.r, r.nonce[:encryptPrefixSize]); err != nil {
+			return encryptedFileError(err)
+		}
+	}
+	var header [4]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		return encryptedFileError(err)
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	r.last = size&encryptLastSegment != 0
+	size &^= encryptLastSegment
+	if size > encryptSegmentSize+uint32(r.aead.Overhead()) {
+		return fmt.Errorf("encrypted temporary file is corrupt: segment %d is %d bytes", r.segment, size)
+	}
+	if cap(r.sealed) < int(size) {
+		r.sealed = make([]byte, size)
+	}
+	r.sealed = r.sealed[:size]
+	if _, err := io.ReadFull(r.r, r.sealed); err != nil {
+		return encryptedFileError(err)
+	}
+	binary.BigEndian.PutUint32(r.nonce[encryptPrefixSize:], r.segment)
+	r.segment++
+	var err error
+	if r.buf, err = r.aead.Open(r.sealed[:0], r.nonce, r.sealed, header[:]); err != nil {
+		return fmt.Errorf("encrypted temporary file is corrupt: segment %d could not be decrypted: %w", r.segment-1, err)
+	}
+	return nil
+}
+
+// encryptedFileError returns the error of reading an encrypted temporary file, which is truncated if
+// it ends before its last segment
+func encryptedFileError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("encrypted temporary file is truncated: %w", io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+// nopWriteCloser is a writer with a Close method that does nothing
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
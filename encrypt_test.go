@@ -0,0 +1,118 @@
+package dedup
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestEncryptTemp(t *testing.T) {
+	j := &job{opts: Options{EncryptTempFiles: true}, tempCipher: &tempCipher{}}
+	for _, size := range []int{0, 1, encryptSegmentSize - 1, encryptSegmentSize, 3*encryptSegmentSize + 7} {
+		plain := bytes.Repeat([]byte("0123456789abcdef\n"), size/17+1)[:size]
+		var sealed bytes.Buffer
+		w, err := j.encryptTemp(&sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.Copy(w, bytes.NewReader(plain)); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if size > 16 && bytes.Contains(sealed.Bytes(), plain[:16]) {
+			t.Fatalf("Expected %d bytes to be encrypted", size)
+		}
+
+		r, err := j.decryptTemp(bytes.NewReader(sealed.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		opened, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(opened, plain) {
+			t.Fatalf("Expected %d bytes back as they were written; Got: %d bytes", size, len(opened))
+		}
+
+		// A file cut short, or changed, can not be read back
+		r, _ = j.decryptTemp(bytes.NewReader(sealed.Bytes()[:sealed.Len()-1]))
+		if _, err = io.ReadAll(r); err == nil {
+			t.Fatalf("Expected an error reading %d bytes cut short", size)
+		}
+		changed := append([]byte(nil), sealed.Bytes()...)
+		changed[len(changed)/2] ^= 1
+		r, _ = j.decryptTemp(bytes.NewReader(changed))
+		if _, err = io.ReadAll(r); err == nil {
+			t.Fatalf("Expected an error reading %d bytes changed", size)
+		}
+	}
+}
+
+func TestRunEncryptTempFiles(t *testing.T) {
+	b, err := os.ReadFile("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := strings.SplitN(string(b), "\n", 2)[0]
+	want, err := Run(nil, Options{DryRun: true}, strings.NewReader(string(b)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// None of the lines can be read from the temporary files, which are still merged into the same output
+	for _, opts := range []Options{{}, {MergeMmap: true, ChecksumTempFiles: true}, {MergeFanIn: 2}, {Partitions: 2}} {
+		var plain bool
+		opts.TmpFileBytes, opts.DryRun, opts.EncryptTempFiles = 20*50, true, true
+		opts.OnEvent = func(e Event) {
+			if e.Kind == EventChunkWritten {
+				chunk, err := os.ReadFile(e.File)
+				if err != nil {
+					t.Fatal(err)
+				}
+				plain = plain || bytes.Contains(chunk, []byte(first))
+			}
+		}
+		stats, err := Run(nil, opts, strings.NewReader(string(b)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plain {
+			t.Fatalf("Expected no lines in the temporary files with %+v", opts)
+		}
+		if stats.Chunks < 2 || stats.LinesUnique != want.LinesUnique || stats.BytesOut != want.BytesOut {
+			t.Fatalf("Expected %+v; Got: %+v", want, stats)
+		}
+	}
+
+	// Lines passed through are written to an encrypted temporary file of their own
+	var out bytes.Buffer
+	outFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+	rules := []Rule{{Pattern: regexp.MustCompile(`^#`), Action: RulePassthrough}}
+	if _, err = Run(outFile, Options{EncryptTempFiles: true, Rules: rules}, strings.NewReader("b\n#2\na\n#1\nb\n"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = outFile.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.Copy(&out, outFile); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "a\nb\n#2\n#1\n" {
+		t.Fatalf("Expected the lines passed through after the rest; Got: %q", out.String())
+	}
+
+	if _, err = Run(nil, Options{EncryptTempFiles: true, KeepTempFiles: true, DryRun: true}, strings.NewReader(string(b)), nil); err == nil {
+		t.Fatal("Expected an error keeping encrypted temporary files")
+	}
+}
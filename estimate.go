@@ -0,0 +1,163 @@
+package dedup
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// estimateBlocks is how many blocks at random offsets of the input a sample is read from
+const estimateBlocks = 64
+
+// Estimate is an estimate of the result of deduplicating an input, from a sample of its lines
+type Estimate struct {
+	// Bytes is the total byte size of the input
+	Bytes uint64
+
+	// Lines is the estimated number of lines of the input
+	Lines uint64
+
+	// UniqueLines is the estimated number of distinct lines of the input
+	UniqueLines uint64
+
+	// OutputBytes is the estimated byte size of the deduplicated output
+	OutputBytes uint64
+
+	// SampledLines and SampledBytes are how much of the input was read
+	SampledLines uint64
+	SampledBytes uint64
+
+	// Exact is true if the whole input was read, because it was no larger than the sample, so that
+	// the estimate is the actual result
+	Exact bool
+}
+
+// DuplicateRatio returns the estimated fraction of the lines of the input that are duplicates
+func (e Estimate) DuplicateRatio() float64 {
+	if e.Lines == 0 {
+		return 0
+	}
+	return 1 - float64(e.UniqueLines)/float64(e.Lines)
+}
+
+// EstimateDuplicates reads a sample of about sampleBytes of whole lines from the files, in blocks at
+// random offsets spread evenly across all of them, and estimates how many distinct lines they have and
+// the size of the output, without reading the rest of the input. If the files are no larger than the
+// sample, they are read in full, and the estimate is exact. The distinct lines are estimated from how
+// many lines of the sample were seen once, twice, and so on, by the estimator of Shlosser, which is
+// exact for an input without duplicates, and close for one where a few lines are repeated many times.
+// It overestimates the distinct lines of an input where every line is repeated a few times, and lines
+// repeated close together are sampled together, so the sample should be large enough to have many
+// lines from each block. Only the Delimiter and ReadBufferSize options are used, and every line is
+// estimated as it is read, before any patterns or rules.
+func EstimateDuplicates(opts Options, sampleBytes uint64, inFiles ...*os.File) (Estimate, error) {
+	if sampleBytes == 0 {
+		return Estimate{}, fmt.Errorf("sample bytes must be positive")
+	}
+	j := &job{opts: opts, delim: defaultDelimiter}
+	if opts.Delimiter != "" {
+		j.delim = opts.Delimiter
+	}
+
+	var e Estimate
+	sizes := make([]uint64, len(inFiles))
+	for i, f := range inFiles {
+		info, err := f.Stat()
+		if err != nil {
+			return e, err
+		}
+		sizes[i] = uint64(info.Size())
+		e.Bytes += sizes[i]
+	}
+
+	// The count of each line sampled, and the bytes of the distinct ones
+	counts := make(map[string]uint32)
+	var distinctBytes uint64
+	sample := func(r io.Reader, limit uint64, partial bool) error {
+		scanner := j.newScanner(r, bufferSize(opts.ReadBufferSize, defaultBufferSize))
+		if partial && !scanner.Scan() {
+			return scanner.Err()
+		}
+		var read uint64
+		for read < limit && scanner.Scan() {
+			line := scanner.Bytes()
+			size := uint64(len(line) + len(j.delim))
+			read += size
+			e.SampledLines++
+			e.SampledBytes += size
+			if counts[string(line)] == 0 {
+				distinctBytes += size
+			}
+			counts[string(line)]++
+		}
+		return scanner.Err()
+	}
+
+	if e.Bytes <= sampleBytes {
+		for _, f := range inFiles {
+			if err := sample(io.NewSectionReader(f, 0, math.MaxInt64), math.MaxUint64, false); err != nil {
+				return e, err
+			}
+		}
+		e.Exact = true
+		e.Lines, e.UniqueLines, e.OutputBytes = e.SampledLines, uint64(len(counts)), distinctBytes
+		return e, nil
+	}
+
+	// Each block is at a random offset of its own equal part of all of the files, after the partial
+	// line it starts in, and ends at the end of the line it ends in
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	blockBytes := sampleBytes / estimateBlocks
+	if blockBytes == 0 {
+		blockBytes = 1
+	}
+	part := e.Bytes / estimateBlocks
+	for b := uint64(0); b < estimateBlocks; b++ {
+		offset := b * part
+		if part > blockBytes {
+			offset += uint64(random.Int63n(int64(part - blockBytes)))
+		}
+		i := 0
+		for i < len(sizes) && offset >= sizes[i] {
+			offset -= sizes[i]
+			i++
+		}
+		if i == len(sizes) {
+			continue
+		}
+		r := io.NewSectionReader(inFiles[i], int64(offset), math.MaxInt64)
+		if err := sample(r, blockBytes, offset > 0); err != nil {
+			return e, err
+		}
+	}
+	if e.SampledLines == 0 {
+		return e, nil
+	}
+
+	// Each line seen once stands for lines never sampled, by how likely lines seen i times were to be
+	// seen in a sample of the fraction of lines q
+	e.Lines = uint64(float64(e.Bytes) * float64(e.SampledLines) / float64(e.SampledBytes))
+	if e.Lines < e.SampledLines {
+		e.Lines = e.SampledLines
+	}
+	frequencies := make(map[uint32]uint64)
+	for _, count := range counts {
+		frequencies[count]++
+	}
+	q := float64(e.SampledLines) / float64(e.Lines)
+	var unseen, seen float64
+	for i, f := range frequencies {
+		unseen += math.Pow(1-q, float64(i)) * float64(f)
+		seen += float64(i) * q * math.Pow(1-q, float64(i-1)) * float64(f)
+	}
+	unique := float64(len(counts))
+	if seen > 0 {
+		unique += float64(frequencies[1]) * unseen / seen
+	}
+	e.UniqueLines = uint64(math.Min(unique, float64(e.Lines)))
+	e.OutputBytes = uint64(float64(e.UniqueLines) * float64(distinctBytes) / float64(len(counts)))
+	return e, nil
+}
@@ -0,0 +1,68 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestEstimateDuplicatesExact(t *testing.T) {
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+
+	// testdata.log has 100 distinct lines of 50 characters, 204 total lines, and fits in the sample
+	e, err := EstimateDuplicates(Options{}, 1<<20, inFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Exact || e.Lines != 204 || e.UniqueLines != 100 || e.OutputBytes != 100*51 {
+		t.Fatalf("Unexpected estimate: %+v", e)
+	}
+	if ratio := e.DuplicateRatio(); ratio < 0.50 || ratio > 0.52 {
+		t.Fatalf("Unexpected duplicate ratio: %v", ratio)
+	}
+}
+
+func TestEstimateDuplicatesSampled(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		line   func(i int) int
+		unique int
+	}{
+		// Every line is different
+		{"unique", func(i int) int { return i }, 100000},
+		// Half of the lines are different, and the other half are 100 lines repeated
+		{"skewed", func(i int) int {
+			if i%2 == 0 {
+				return i
+			}
+			return -(i % 200)
+		}, 50100},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inFile, err := os.CreateTemp("", "dedup.test.*.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(inFile.Name())
+			defer inFile.Close()
+			for i := 0; i < 100000; i++ {
+				fmt.Fprintf(inFile, "https://example.com/%08d\n", tc.line(i))
+			}
+
+			e, err := EstimateDuplicates(Options{}, 300000, inFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.Exact || e.SampledLines < 10000 || e.SampledLines > 15000 || e.Lines < 95000 || e.Lines > 105000 {
+				t.Fatalf("Unexpected sample: %+v", e)
+			}
+			if float64(e.UniqueLines) < 0.8*float64(tc.unique) || float64(e.UniqueLines) > 1.2*float64(tc.unique) {
+				t.Fatalf("Expected about %d unique lines; Got: %+v", tc.unique, e)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package dedup
+
+import (
+	"time"
+)
+
+// EventKind identifies what happened in an Event
+type EventKind string
+
+const (
+	// EventPhaseStarted is sent when a phase of the run starts
+	EventPhaseStarted EventKind = "phase_started"
+
+	// EventPhaseFinished is sent when a phase of the run finishes, with its line and byte counts
+	EventPhaseFinished EventKind = "phase_finished"
+
+	// EventChunkCreated is sent when a temporary chunk file is created
+	EventChunkCreated EventKind = "chunk_created"
+
+	// EventChunkSorted is sent when the set of a chunk has been sorted, before it is written to its
+	// temporary files, with its line count and how long sorting it took
+	EventChunkSorted EventKind = "chunk_sorted"
+
+	// EventChunkWritten is sent when a temporary chunk file has been written, with its line and byte counts,
+	// and how long writing it took
+	EventChunkWritten EventKind = "chunk_written"
+
+	// EventChunkMerged is sent when every line of a chunk file has been merged, with its line and byte
+	// counts, and how long it was merged for
+	EventChunkMerged EventKind = "chunk_merged"
+
+	// EventResumed is sent when a run resumes from its checkpoint, with the line and byte counts of
+	// the input it skips
+	EventResumed EventKind = "resumed"
+
+	// EventChunkVerified is sent when a temporary chunk file has been read back and verified, with
+	// VerifyWrites, with its line count
+	EventChunkVerified EventKind = "chunk_verified"
+
+	// EventOutputVerified is sent when the output file has been read back and verified, with
+	// VerifyWrites, with its line and byte counts
+	EventOutputVerified EventKind = "output_verified"
+
+	// EventWarning is sent when something went wrong that does not stop the run, with the error
+	EventWarning EventKind = "warning"
+)
+
+// Event describes something that happened during a run, such as a phase transition or
+// the creation of a temporary file
+type Event struct {
+	// Kind identifies what happened
+	Kind EventKind
+
+	// Phase is the phase of the run the event happened in
+	Phase Phase
+
+	// Message is a human readable description of the event
+	Message string
+
+	// File is the name of the file the event is about, if any
+	File string
+
+	// Lines is the number of lines the event is about, if any
+	Lines uint64
+
+	// Bytes is the number of bytes the event is about, if any
+	Bytes uint64
+
+	// Duration is how long what the event is about took, if known, such as writing a chunk file
+	Duration time.Duration
+
+	// Err is the error a warning is about
+	Err error
+}
+
+// Detail returns true if the event is a detail of a phase, such as the creation or merging of
+// a single temporary file, rather than a phase transition or warning
+func (e Event) Detail() bool {
+	switch e.Kind {
+	case EventChunkCreated, EventChunkSorted, EventChunkWritten, EventChunkMerged, EventChunkVerified:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,114 @@
+package dedup
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRunEvents(t *testing.T) {
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+
+	// Count the events of each kind
+	kinds := make(map[EventKind]int)
+	var merged, sorted uint64
+	var chunkLines []uint64
+	opts := Options{
+		TmpFileBytes: 20 * 50,
+		DryRun:       true,
+		OnProgress:   func(Progress) {},
+		OnEvent: func(e Event) {
+			kinds[e.Kind]++
+			switch e.Kind {
+			case EventChunkMerged:
+				merged += e.Lines
+			case EventChunkSorted:
+				sorted += e.Lines
+			case EventChunkWritten:
+				chunkLines = append(chunkLines, e.Lines)
+			}
+			if e.Detail() && e.Kind != EventChunkCreated && e.Duration <= 0 {
+				t.Errorf("Expected a duration for %s of %s", e.Kind, e.File)
+			}
+		},
+	}
+
+	stats, err := Run(nil, opts, inFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Splitting and merging each start and finish, and every chunk is created, written, and merged
+	if kinds[EventPhaseStarted] != 2 || kinds[EventPhaseFinished] != 2 {
+		t.Fatalf("Unexpected phase events: %v", kinds)
+	}
+	if kinds[EventChunkCreated] != stats.Chunks || kinds[EventChunkSorted] != stats.Chunks ||
+		kinds[EventChunkWritten] != stats.Chunks || kinds[EventChunkMerged] != stats.Chunks {
+		t.Fatalf("Unexpected chunk events for %d chunks: %v", stats.Chunks, kinds)
+	}
+	if merged != stats.TmpLines || sorted != stats.TmpLines {
+		t.Fatalf("Merged (%d) and sorted (%d) chunk lines should match temporary lines (%d)", merged, sorted, stats.TmpLines)
+	}
+
+	// The stats have the lines of each chunk, in the order they were written
+	if len(stats.ChunkLines) != stats.Chunks || stats.SortElapsed <= 0 {
+		t.Fatalf("Unexpected chunk stats: %v, sorted in %v", stats.ChunkLines, stats.SortElapsed)
+	}
+	for i := range chunkLines {
+		if stats.ChunkLines[i] != chunkLines[i] {
+			t.Fatalf("Expected chunk lines %v, got %v", chunkLines, stats.ChunkLines)
+		}
+	}
+}
+
+func TestRunOnDone(t *testing.T) {
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+
+	// OnDone is called once, with what the run returns
+	var calls int
+	var done Stats
+	var doneErr error
+	opts := Options{
+		TmpFileBytes: 20 * 50,
+		DryRun:       true,
+		OnProgress:   func(Progress) {},
+		OnEvent:      func(Event) {},
+		OnDone: func(stats Stats, err error) {
+			calls++
+			done, doneErr = stats, err
+		},
+	}
+	stats, err := Run(nil, opts, inFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 || doneErr != nil || done.LinesUnique != stats.LinesUnique || done.LinesRead != 204 {
+		t.Fatalf("Unexpected OnDone calls %d: %+v, %v", calls, done, doneErr)
+	}
+
+	// A failed merge is done too, with its error
+	unsorted, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(unsorted.Name())
+	defer unsorted.Close()
+	if _, err = unsorted.WriteString("b\na\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = unsorted.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	calls = 0
+	_, err = Merge(nil, opts, unsorted)
+	if err == nil || calls != 1 || doneErr != err {
+		t.Fatalf("Expected OnDone to be called with the error of the merge %v; Got %d calls: %v", err, calls, doneErr)
+	}
+}
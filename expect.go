@@ -0,0 +1,26 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+)
+
+// expectUnique calls ExpectUnique with the number of unique lines, once they have all been written,
+// unless the input was not read to its end. If it fails the run, the temporary chunk files are kept,
+// and listed in Stats, to investigate.
+func (j *job) expectUnique(chunks []*os.File) error {
+	if j.opts.ExpectUnique == nil || j.stats.Incomplete {
+		return nil
+	}
+	err := j.opts.ExpectUnique(j.stats.LinesUnique)
+	if err == nil {
+		return nil
+	}
+	if !j.opts.KeepTempFiles && len(chunks) > 0 {
+		j.keepChunks = true
+		for _, chunk := range chunks {
+			j.stats.TmpFiles = append(j.stats.TmpFiles, chunk.Name())
+		}
+	}
+	return fmt.Errorf("unexpected number of unique lines: %w", err)
+}
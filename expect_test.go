@@ -0,0 +1,55 @@
+package dedup
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunExpectUnique(t *testing.T) {
+	errUnexpected := errors.New("unexpected")
+	for _, expected := range []uint64{3, 4} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		var called uint64
+		opts := Options{TmpFileBytes: 2, ExpectUnique: func(linesUnique uint64) error {
+			called = linesUnique
+			if linesUnique != expected {
+				return errUnexpected
+			}
+			return nil
+		}}
+		stats, err := Run(outFile, opts, strings.NewReader("c\na\nb\na\nc\n"), nil)
+		for _, tmpFile := range stats.TmpFiles {
+			defer os.Remove(tmpFile)
+		}
+		if called != 3 {
+			t.Fatalf("Expected to be called with 3 unique lines, got %d", called)
+		}
+		if expected == 3 {
+			if err != nil || len(stats.TmpFiles) != 0 {
+				t.Fatalf("Expected the run to succeed without keeping its temporary files: %v %v", err, stats.TmpFiles)
+			}
+			continue
+		}
+
+		// The run fails, keeping its temporary files to investigate
+		if !errors.Is(err, errUnexpected) {
+			t.Fatalf("Expected the error of ExpectUnique, got %v", err)
+		}
+		if len(stats.TmpFiles) == 0 || len(stats.TmpFiles) != stats.Chunks {
+			t.Fatalf("Expected the temporary files to be kept: %+v", stats)
+		}
+		for _, tmpFile := range stats.TmpFiles {
+			if _, err = os.Stat(tmpFile); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package dedup
+
+import (
+	"os"
+	"syscall"
+)
+
+// fadvise advises the kernel how the length bytes of the file from the offset will be accessed,
+// or the rest of the file if the length is zero
+func fadvise(f *os.File, offset, length int64, advice int) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), uintptr(offset), uintptr(length), uintptr(advice), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
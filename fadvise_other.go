@@ -0,0 +1,11 @@
+//go:build !linux || !(amd64 || arm64)
+// +build !linux !amd64,!arm64
+
+package dedup
+
+import "os"
+
+// fadvise does nothing, because advising the kernel is not supported here
+func fadvise(f *os.File, offset, length int64, advice int) error {
+	return nil
+}
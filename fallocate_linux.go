@@ -0,0 +1,14 @@
+package dedup
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE, which allocates the space without changing the size of the file
+const fallocKeepSize = 0x01
+
+// fallocate allocates the length bytes of the file from the offset on disk, without changing its size
+func fallocate(f *os.File, offset, length int64) error {
+	return syscall.Fallocate(int(f.Fd()), fallocKeepSize, offset, length)
+}
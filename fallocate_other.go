@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package dedup
+
+import "os"
+
+// fallocate does nothing, because allocating space ahead of writing is not supported here
+func fallocate(f *os.File, offset, length int64) error {
+	return nil
+}
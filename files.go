@@ -0,0 +1,55 @@
+package dedup
+
+import (
+	"fmt"
+)
+
+// reservedFiles is how many of the open files budgeted for a run are left for the files besides its
+// chunks, such as the output, passthrough, checkpoint, and directories being synced
+const reservedFiles = 16
+
+// validateMaxOpenFiles returns an error if the budget of open files is too small for a cascaded merge,
+// or MergeFanIn would open more files than it
+func validateMaxOpenFiles(opts Options) error {
+	switch {
+	case opts.MaxOpenFiles <= 0:
+		return nil
+	case opts.MaxOpenFiles < reservedFiles+2:
+		return fmt.Errorf("max open files must be at least %d", reservedFiles+2)
+	case opts.MergeFanIn > opts.MaxOpenFiles-reservedFiles:
+		return fmt.Errorf("merge fan-in of %d would open more than the max open files of %d", opts.MergeFanIn, opts.MaxOpenFiles)
+	}
+	return nil
+}
+
+// budgetFiles sets MergeFanIn, unless it is set already, so that no more chunks and meta files are
+// open at once than fit in what is left of MaxOpenFiles, or the limit of the process on open files,
+// once the files the process already has open and reservedFiles are taken from it
+func (j *job) budgetFiles() {
+	if j.opts.MergeFanIn > 0 || j.opts.MaxOpenFiles < 0 {
+		return
+	}
+	budget := j.opts.MaxOpenFiles
+	if budget == 0 {
+		var ok bool
+		if budget, ok = openFileLimit(); !ok {
+			return
+		}
+	}
+	budget -= openFiles() + reservedFiles
+
+	// Every partition that is merged at once has its own chunks open, each with its meta file if tracked
+	perChunk := 1
+	if j.tracking() {
+		perChunk = 2
+	}
+	merging := j.partitions()
+	if workers := j.workers(); merging > workers {
+		merging = workers
+	}
+	fanIn := budget / (perChunk * merging)
+	if fanIn < 2 {
+		fanIn = 2
+	}
+	j.opts.MergeFanIn = fanIn
+}
@@ -0,0 +1,14 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris && !windows
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris,!windows
+
+package dedup
+
+// openFileLimit returns false, as the limit on open files is not known on this platform
+func openFileLimit() (int, bool) {
+	return 0, false
+}
+
+// openFiles returns zero, as what is open is not counted without a limit
+func openFiles() int {
+	return 0
+}
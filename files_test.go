@@ -0,0 +1,67 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBudgetFiles(t *testing.T) {
+	open := openFiles()
+	for _, test := range []struct {
+		opts     Options
+		expected int
+	}{
+		{Options{MaxOpenFiles: open + reservedFiles + 8}, 8},
+		{Options{MaxOpenFiles: open + reservedFiles + 8, Partitions: 4, Workers: 2}, 4},
+		{Options{MaxOpenFiles: open + reservedFiles + 8, OnAudit: func(Duplicate) error { return nil }}, 4},
+		{Options{MaxOpenFiles: open + reservedFiles}, 2},
+		{Options{MaxOpenFiles: open + reservedFiles + 8, MergeFanIn: 3}, 3},
+		{Options{MaxOpenFiles: -1}, 0},
+	} {
+		j := &job{opts: test.opts}
+		j.budgetFiles()
+		if j.opts.MergeFanIn != test.expected {
+			t.Fatalf("Expected a fan-in of %d with %+v; Got: %d", test.expected, test.opts, j.opts.MergeFanIn)
+		}
+	}
+
+	if err := validateMaxOpenFiles(Options{MaxOpenFiles: reservedFiles}); err == nil {
+		t.Fatal("Expected an error with too small a budget")
+	}
+	if err := validateMaxOpenFiles(Options{MaxOpenFiles: 100, MergeFanIn: 100}); err == nil {
+		t.Fatal("Expected an error with a fan-in over the budget")
+	}
+}
+
+func TestRunMaxOpenFiles(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&input, "%04d\n", (i*7919)%900)
+	}
+
+	// Many more chunks are written than fit in the budget, so they are merged in a cascade
+	var intermediates int
+	outFile, err := os.CreateTemp(t.TempDir(), "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outFile.Close()
+	opts := Options{TempDir: t.TempDir(), TmpFileBytes: 100, MaxOpenFiles: openFiles() + reservedFiles + 4,
+		OnEvent: func(e Event) {
+			if e.Kind == EventChunkCreated && e.Phase == PhaseMerging {
+				intermediates++
+			}
+		}}
+	stats, err := Run(outFile, opts, strings.NewReader(input.String()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Chunks <= 4 || intermediates == 0 {
+		t.Fatalf("Expected more chunks than the budget, merged in a cascade; Got %d chunks and %d intermediate files", stats.Chunks, intermediates)
+	}
+	if stats.LinesUnique != 900 {
+		t.Fatalf("Expected 900 unique lines; Got: %d", stats.LinesUnique)
+	}
+}
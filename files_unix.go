@@ -0,0 +1,29 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package dedup
+
+import (
+	"os"
+	"syscall"
+)
+
+// openFileLimit returns the limit of the process on open files, if it has one
+func openFileLimit() (int, bool) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil || limit.Cur > 1<<30 {
+		return 0, false
+	}
+	return int(limit.Cur), true
+}
+
+// openFiles returns how many files the process has open, or zero if that can not be known
+func openFiles() int {
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		if entries, err := os.ReadDir(dir); err == nil {
+			// Reading the directory opened it
+			return len(entries) - 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,12 @@
+package dedup
+
+// openFileLimit returns false, as windows has no limit on the open files of a process that is low
+// enough to matter
+func openFileLimit() (int, bool) {
+	return 0, false
+}
+
+// openFiles returns zero, as what is open is not counted without a limit
+func openFiles() int {
+	return 0
+}
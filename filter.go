@@ -0,0 +1,245 @@
+package dedup
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+// RuleAction is what a Rule does with the lines matching its pattern
+type RuleAction string
+
+const (
+	// RuleSkip drops the line
+	RuleSkip RuleAction = "skip"
+
+	// RuleKeep deduplicates the line, without checking the skip and keep patterns
+	RuleKeep RuleAction = "keep"
+
+	// RulePassthrough writes the line to the output unchanged, after the deduplicated lines
+	RulePassthrough RuleAction = "passthrough"
+
+	// RuleTransform replaces the line with the Replacement, then deduplicates it
+	RuleTransform RuleAction = "transform"
+)
+
+// Rule is a pattern with an action for the lines matching it. The rules of a run are evaluated
+// in order, and the first rule matching a line decides what happens to it. Lines that match
+// no rule are then checked against the skip and keep patterns.
+type Rule struct {
+	// Pattern is the re2 regex pattern the line must match
+	Pattern *regexp.Regexp
+
+	// Action is what is done with a matching line
+	Action RuleAction
+
+	// Replacement is the template a matching line is replaced with, for RuleTransform.
+	// It is expanded as by regexp.Regexp.Expand, so $1 or ${name} insert submatches.
+	Replacement string
+}
+
+// lineAction is what is done with a line of the input
+type lineAction int
+
+const (
+	// actionDedup deduplicates the line into the output
+	actionDedup lineAction = iota
+
+	// actionSkip drops the line
+	actionSkip
+
+	// actionPassthrough writes the line to the output unchanged, even if it is a duplicate
+	actionPassthrough
+
+	// actionSeen drops the line, because it is in the Seen set of the run
+	actionSeen
+)
+
+// filter returns what to do with a line, according to the rules and then the skip and keep patterns,
+// along with what the line is deduplicated by, which is changed by transform rules, Transform, and the
+// keys, and the text written for it if it is keyed. Skip patterns win over keep patterns.
+func (j *job) filter(read string) (lineAction, string, string) {
+	action, line, ruled := j.filterRules(read)
+	if !ruled && !j.opts.PatternsMatchKeys {
+		action = j.filterPatterns(line)
+	}
+	if action != actionDedup {
+		return action, line, ""
+	}
+	line, text := j.transform(read, line)
+	if !ruled && j.opts.PatternsMatchKeys {
+		// Lines passed through are still written as they were read
+		if action = j.filterPatterns(line); action != actionDedup {
+			return action, read, ""
+		}
+	}
+	return action, line, text
+}
+
+// filterRules returns what the first rule matching the line does with it, along with the line itself,
+// which is changed by transform rules, and whether any rule matched it
+func (j *job) filterRules(line string) (lineAction, string, bool) {
+	for i, rule := range j.opts.Rules {
+		if rule.Action == RuleTransform {
+			match := rule.Pattern.FindStringSubmatchIndex(line)
+			if match == nil {
+				continue
+			}
+			j.stats.RuleLines[i]++
+			return actionDedup, string(rule.Pattern.ExpandString(nil, rule.Replacement, line, match)), true
+		}
+		if !rule.Pattern.MatchString(line) {
+			continue
+		}
+		j.stats.RuleLines[i]++
+		switch rule.Action {
+		case RuleSkip:
+			return actionSkip, line, true
+		case RulePassthrough:
+			return actionPassthrough, line, true
+		default:
+			return actionDedup, line, true
+		}
+	}
+	return actionDedup, line, false
+}
+
+// hasFilters returns true if any rules, patterns, or Transform may skip, pass through, or transform lines
+func hasFilters(opts Options) bool {
+	return len(opts.Rules) > 0 || len(opts.SkipPatterns) > 0 || len(opts.KeepPatterns) > 0 || opts.Transform != nil ||
+		opts.StripANSIOutput
+}
+
+// hasTransforms returns true if any of the rules transform the lines they match
+func hasTransforms(rules []Rule) bool {
+	for _, rule := range rules {
+		if rule.Action == RuleTransform {
+			return true
+		}
+	}
+	return false
+}
+
+// filterPatterns returns what to do with a line, according to the skip and keep patterns
+func (j *job) filterPatterns(line string) lineAction {
+	if i := matchFirst(j.opts.SkipPatterns, line); i >= 0 {
+		j.stats.SkipPatternLines[i]++
+		return actionSkip
+	}
+	if len(j.opts.KeepPatterns) > 0 {
+		i := matchFirst(j.opts.KeepPatterns, line)
+		if i >= 0 {
+			j.stats.KeepPatternLines[i]++
+		} else if j.opts.PassthroughUnkept {
+			return actionPassthrough
+		} else {
+			return actionSkip
+		}
+	}
+	return actionDedup
+}
+
+// matchFirst returns the index of the first of the patterns the line matches, or -1 if none
+func matchFirst(patterns []*regexp.Regexp, line string) int {
+	for i, pattern := range patterns {
+		if pattern.MatchString(line) {
+			return i
+		}
+	}
+	return -1
+}
+
+// newMatchCounts returns the counts of the lines matched by each of n patterns or rules, or nil if none
+func newMatchCounts(n int) []uint64 {
+	if n == 0 {
+		return nil
+	}
+	return make([]uint64, n)
+}
+
+// passthrough holds the lines that are passed through to the output, in the order they were read,
+// in a temporary file until the deduplicated lines have been written
+type passthrough struct {
+	f         *os.File
+	encrypted io.WriteCloser
+	writer    *bufio.Writer
+}
+
+// passthrough writes the line to the passthrough file, creating it if needed
+func (j *job) passthrough(line string) error {
+	if j.opts.CountOnly {
+		// Never written, so only counted
+		j.stats.LinesPassedThrough++
+		return nil
+	}
+	if j.passed == nil {
+		f, err := CreateTemp(j.dir, "passthrough.*.log")
+		if err != nil {
+			return err
+		}
+		encrypted, err := j.encryptTemp(f)
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return err
+		}
+		j.passed = &passthrough{f: f, encrypted: encrypted,
+			writer: bufio.NewWriterSize(encrypted, bufferSize(j.opts.WriteBufferSize, defaultBufferSize))}
+	}
+	j.stats.LinesPassedThrough++
+	if _, err := j.passed.writer.WriteString(line); err != nil {
+		return err
+	}
+	_, err := j.passed.writer.WriteString(j.lineEnding())
+	return err
+}
+
+// writePassthrough copies the passed through lines to the end of the output
+func (j *job) writePassthrough(out io.Writer) error {
+	if j.passed == nil {
+		return nil
+	}
+	if err := j.passed.writer.Flush(); err != nil {
+		return err
+	}
+	if err := j.passed.encrypted.Close(); err != nil {
+		return err
+	}
+	if _, err := j.passed.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r, err := j.decryptTemp(j.passed.f)
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(out, r)
+	j.stats.BytesOut += uint64(written)
+	return err
+}
+
+// removePassthrough closes and removes the passthrough file, if there is one
+func (j *job) removePassthrough() error {
+	var c cleanup
+	if j.passed != nil {
+		c.close(j.passed.f)
+		c.remove(j.passed.f.Name())
+	}
+	return c.err()
+}
+
+// validateRules returns an error if any of the rules are incomplete
+func validateRules(rules []Rule) error {
+	for i, rule := range rules {
+		if rule.Pattern == nil {
+			return fmt.Errorf("rule %d has no pattern", i+1)
+		}
+		switch rule.Action {
+		case RuleSkip, RuleKeep, RulePassthrough, RuleTransform:
+		default:
+			return fmt.Errorf("rule %d has an unknown action: %q", i+1, rule.Action)
+		}
+	}
+	return nil
+}
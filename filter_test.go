@@ -0,0 +1,126 @@
+package dedup
+
+import (
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRunKeepPatterns(t *testing.T) {
+	input := "x2\nb\nx1\na\nb\nx2\nskip\n"
+	keep := []*regexp.Regexp{regexp.MustCompile(`^[a-z]$`)}
+	skip := []*regexp.Regexp{regexp.MustCompile(`^skip$`)}
+
+	tests := []struct {
+		passthrough   bool
+		expected      string
+		skipped       uint64
+		passedThrough uint64
+	}{
+		{passthrough: false, expected: "a\nb\n", skipped: 4},
+		{passthrough: true, expected: "a\nb\nx2\nx1\nx2\n", skipped: 1, passedThrough: 3},
+	}
+	for _, tt := range tests {
+		// Whether everything fits in memory or not, unkept lines come after the deduplicated lines
+		for _, tmpFileBytes := range []uint64{1000, 2} {
+			outFile, err := os.CreateTemp("", "dedup.test.*.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(outFile.Name())
+			defer outFile.Close()
+
+			opts := Options{TmpFileBytes: tmpFileBytes, SkipPatterns: skip, KeepPatterns: keep, PassthroughUnkept: tt.passthrough}
+			stats, err := Run(outFile, opts, strings.NewReader(input), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.LinesSkipped != tt.skipped || stats.LinesPassedThrough != tt.passedThrough ||
+				stats.LinesUnique != 2 || stats.LinesDuplicate != 1 || stats.BytesOut != uint64(len(tt.expected)) {
+				t.Fatalf("Unexpected stats with passthrough %t: %+v", tt.passthrough, stats)
+			}
+
+			content, err := os.ReadFile(outFile.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.expected {
+				t.Fatalf("Unexpected output with passthrough %t and %d tmp file bytes: %q", tt.passthrough, tmpFileBytes, content)
+			}
+		}
+	}
+}
+
+func TestRunRules(t *testing.T) {
+	rules := []Rule{
+		{Pattern: regexp.MustCompile(`^#`), Action: RulePassthrough},
+		{Pattern: regexp.MustCompile(`^keep `), Action: RuleKeep},
+		{Pattern: regexp.MustCompile(`^(https?://[^?]*)\?.*$`), Action: RuleTransform, Replacement: "$1"},
+		{Pattern: regexp.MustCompile(`^ftp://`), Action: RuleSkip},
+	}
+	skip := []*regexp.Regexp{regexp.MustCompile(`skip`)}
+	input := "# header\nhttp://a?x=1\nhttp://a\nftp://b\nkeep skip\nhttps://c?y\nother skip\n# header\n"
+
+	outFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+
+	// The first matching rule wins, and only lines matching no rule are checked against the skip patterns
+	stats, err := Run(outFile, Options{TmpFileBytes: 1000, Rules: rules, SkipPatterns: skip}, strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.LinesSkipped != 2 || stats.LinesPassedThrough != 2 || stats.LinesDuplicate != 1 || stats.LinesUnique != 3 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	content, err := os.ReadFile(outFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "http://a\nhttps://c\nkeep skip\n# header\n# header\n"; string(content) != expected {
+		t.Fatalf("Unexpected output: %q", content)
+	}
+
+	// Incomplete rules are an error
+	if _, err = Run(outFile, Options{Rules: []Rule{{Action: RuleSkip}}}, strings.NewReader(input), nil); err == nil {
+		t.Fatal("Expected an error for a rule without a pattern")
+	}
+}
+
+func TestRunPatternLines(t *testing.T) {
+	input := "# comment\nftp://a\nskip 1\nskip 2\ndebug\nerror 1\nerror 2\nwarn\ninfo\n"
+	opts := Options{
+		DryRun: true,
+		Rules: []Rule{
+			{Pattern: regexp.MustCompile(`^#`), Action: RuleSkip},
+			{Pattern: regexp.MustCompile(`^ftp://`), Action: RuleSkip},
+			{Pattern: regexp.MustCompile(`^never`), Action: RuleSkip},
+		},
+		// Each line counts for the first pattern it matches, so the second skip pattern never skips one
+		SkipPatterns: []*regexp.Regexp{regexp.MustCompile(`^skip`), regexp.MustCompile(`^skip 2`), regexp.MustCompile(`^debug`)},
+		KeepPatterns: []*regexp.Regexp{regexp.MustCompile(`^error`), regexp.MustCompile(`^warn`), regexp.MustCompile(`^fatal`)},
+	}
+	stats, err := Run(nil, opts, strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stats.RuleLines, []uint64{1, 1, 0}) || !reflect.DeepEqual(stats.SkipPatternLines, []uint64{2, 0, 1}) ||
+		!reflect.DeepEqual(stats.KeepPatternLines, []uint64{2, 1, 0}) || stats.LinesSkipped != 6 {
+		t.Fatalf("Unexpected pattern lines: rules %v, skip %v, keep %v, skipped %d",
+			stats.RuleLines, stats.SkipPatternLines, stats.KeepPatternLines, stats.LinesSkipped)
+	}
+
+	// Without patterns or rules, there are no counts
+	stats, err = Run(nil, Options{DryRun: true}, strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.RuleLines != nil || stats.SkipPatternLines != nil || stats.KeepPatternLines != nil {
+		t.Fatalf("Expected no pattern lines without patterns: %+v", stats)
+	}
+}
@@ -0,0 +1,197 @@
+package dedup
+
+import (
+	"encoding/binary"
+)
+
+// flatBuilder builds a FlatBuffer, which the metadata of Arrow is written in, back to front as the
+// FlatBuffers library does, so that every object is written before the objects that refer to it.
+// Offsets are from the end of the buffer until it is finished.
+type flatBuilder struct {
+	buf      []byte
+	head     int
+	minAlign int
+
+	// The table being built, where its fields were written, and where it started
+	slots       []int
+	objectStart int
+}
+
+// newFlatBuilder returns a builder with room for about size bytes
+func newFlatBuilder(size int) *flatBuilder {
+	return &flatBuilder{buf: make([]byte, size), head: size, minAlign: 1}
+}
+
+// offset returns the offset of what was last written, from the end of the buffer
+func (b *flatBuilder) offset() int {
+	return len(b.buf) - b.head
+}
+
+// grow doubles the buffer, keeping what was written at its end
+func (b *flatBuilder) grow() {
+	size := 2 * len(b.buf)
+	if size == 0 {
+		size = 64
+	}
+	buf := make([]byte, size)
+	copy(buf[size-b.offset():], b.buf[b.head:])
+	b.head += size - len(b.buf)
+	b.buf = buf
+}
+
+// prep pads the buffer so that after writing additional bytes, the next size bytes written are aligned
+// to size, and makes room for them
+func (b *flatBuilder) prep(size, additional int) {
+	if size > b.minAlign {
+		b.minAlign = size
+	}
+	pad := (-(b.offset() + additional)) & (size - 1)
+	for b.head < pad+size+additional {
+		b.grow()
+	}
+	for i := 0; i < pad; i++ {
+		b.head--
+		b.buf[b.head] = 0
+	}
+}
+
+// The scalars are written without any alignment, which prep must have made
+func (b *flatBuilder) placeUint8(v uint8) {
+	b.head--
+	b.buf[b.head] = v
+}
+
+func (b *flatBuilder) placeUint16(v uint16) {
+	b.head -= 2
+	binary.LittleEndian.PutUint16(b.buf[b.head:], v)
+}
+
+func (b *flatBuilder) placeUint32(v uint32) {
+	b.head -= 4
+	binary.LittleEndian.PutUint32(b.buf[b.head:], v)
+}
+
+func (b *flatBuilder) placeUint64(v uint64) {
+	b.head -= 8
+	binary.LittleEndian.PutUint64(b.buf[b.head:], v)
+}
+
+// The prepended scalars are aligned to their size
+func (b *flatBuilder) prependUint8(v uint8) {
+	b.prep(1, 0)
+	b.placeUint8(v)
+}
+
+func (b *flatBuilder) prependUint16(v uint16) {
+	b.prep(2, 0)
+	b.placeUint16(v)
+}
+
+func (b *flatBuilder) prependUint32(v uint32) {
+	b.prep(4, 0)
+	b.placeUint32(v)
+}
+
+func (b *flatBuilder) prependUint64(v uint64) {
+	b.prep(8, 0)
+	b.placeUint64(v)
+}
+
+// prependOffset writes an offset to an object already written, relative to where the offset is
+func (b *flatBuilder) prependOffset(off int) {
+	b.prep(4, 0)
+	b.placeUint32(uint32(b.offset() - off + 4))
+}
+
+// createString writes a string, and returns its offset
+func (b *flatBuilder) createString(s string) int {
+	b.prep(4, len(s)+1)
+	b.placeUint8(0)
+	b.head -= len(s)
+	copy(b.buf[b.head:], s)
+	b.placeUint32(uint32(len(s)))
+	return b.offset()
+}
+
+// startVector starts a vector of n elements of the size, aligned to alignment, whose elements are
+// then prepended in reverse order, until endVector
+func (b *flatBuilder) startVector(size, n, alignment int) {
+	b.prep(4, size*n)
+	b.prep(alignment, size*n)
+}
+
+// endVector ends a vector of n elements, and returns its offset
+func (b *flatBuilder) endVector(n int) int {
+	b.prep(4, 0)
+	b.placeUint32(uint32(n))
+	return b.offset()
+}
+
+// createOffsets writes a vector of offsets to objects already written, and returns its offset
+func (b *flatBuilder) createOffsets(offsets []int) int {
+	b.startVector(4, len(offsets), 4)
+	for i := len(offsets) - 1; i >= 0; i-- {
+		b.prependOffset(offsets[i])
+	}
+	return b.endVector(len(offsets))
+}
+
+// startTable starts a table of n fields, which are then written with the add methods, until endTable
+func (b *flatBuilder) startTable(n int) {
+	b.slots = make([]int, n)
+	b.objectStart = b.offset()
+}
+
+// The add methods write a field of the table, and remember where
+func (b *flatBuilder) addUint8(field int, v uint8) {
+	b.prependUint8(v)
+	b.slots[field] = b.offset()
+}
+
+func (b *flatBuilder) addUint16(field int, v uint16) {
+	b.prependUint16(v)
+	b.slots[field] = b.offset()
+}
+
+func (b *flatBuilder) addUint32(field int, v uint32) {
+	b.prependUint32(v)
+	b.slots[field] = b.offset()
+}
+
+func (b *flatBuilder) addUint64(field int, v uint64) {
+	b.prependUint64(v)
+	b.slots[field] = b.offset()
+}
+
+func (b *flatBuilder) addOffset(field int, off int) {
+	b.prependOffset(off)
+	b.slots[field] = b.offset()
+}
+
+// endTable writes the table, preceded by its vtable of where each of its fields is, and returns its offset
+func (b *flatBuilder) endTable() int {
+	b.prependUint32(0)
+	object := b.offset()
+	for i := len(b.slots) - 1; i >= 0; i-- {
+		var off uint16
+		if b.slots[i] != 0 {
+			off = uint16(object - b.slots[i])
+		}
+		b.prependUint16(off)
+	}
+	b.prependUint16(uint16(object - b.objectStart))
+	b.prependUint16(uint16(2 * (len(b.slots) + 2)))
+
+	// The table starts with the offset of its vtable back from it
+	vtable := b.offset()
+	binary.LittleEndian.PutUint32(b.buf[len(b.buf)-object:], uint32(int32(vtable-object)))
+	b.slots = nil
+	return object
+}
+
+// finish writes the offset of the root table at the start of the buffer, and returns the buffer
+func (b *flatBuilder) finish(root int) []byte {
+	b.prep(b.minAlign, 4)
+	b.prependOffset(root)
+	return b.buf[b.head:]
+}
@@ -0,0 +1,65 @@
+package gen
+
+import (
+	"encoding/hex"
+)
+
+// longLineBytes is the length, with its new line, that long lines are at or over: that of the default
+// buffer of bufio.Scanner, which lines longer than fail to be read
+const longLineBytes = 64 * 1024
+
+// edgeSalt salts the seed of the random streams that pick which lines are edge cases, so that they are
+// apart from the streams of the lines themselves
+const edgeSalt = 0x656467652d636173
+
+// invalidUTF8 are byte sequences that are not valid UTF-8: a byte that never is, a sequence cut short,
+// a UTF-16 surrogate, and an overlong encoding
+var invalidUTF8 = []string{"\xff", "\xc3\x28", "\xe2\x82", "\xed\xa0\x80", "\xc0\xaf"}
+
+// hasEdges returns true if any of the lines are edge cases
+func (g *generator) hasEdges() bool {
+	return g.emptyLines > 0 || g.longLines > 0 || g.invalidUTF8 > 0 || g.carriageReturns > 0
+}
+
+// edge replaces the line being generated, the distinct line n without its new line, with an edge case,
+// for about the fraction of lines of each: an empty line, a line at or over longLineBytes, or the line
+// with an invalid UTF-8 sequence, or a carriage return, inserted in it
+func (g *generator) edge(seed uint64, n int) {
+	r := newLineRandom(seed^edgeSalt, n)
+	x := r.float64()
+	switch {
+	case x < g.emptyLines:
+		g.out = g.out[:0]
+
+	case x < g.emptyLines+g.longLines:
+		// Mostly, with the new line, one byte short of the length, right at it, or one byte over it,
+		// where reads break
+		size := longLineBytes - 2 + r.intn(3)
+		if r.intn(4) == 0 {
+			size = longLineBytes + r.intn(15*longLineBytes)
+		}
+		if cap(g.long) < (size+1)/2 {
+			g.long = make([]byte, (size+1)/2)
+		}
+		raw := g.long[:(size+1)/2]
+		lineBytes(raw, r)
+		g.out = append(g.out[:0], hex.EncodeToString(raw)[:size]...)
+
+	case x < g.emptyLines+g.longLines+g.invalidUTF8:
+		g.out = insertAt(g.out, r.intn(len(g.out)+1), invalidUTF8[r.intn(len(invalidUTF8))])
+
+	case x < g.emptyLines+g.longLines+g.invalidUTF8+g.carriageReturns:
+		// Never at the end, where dedup reads it as the end of a \r\n line
+		if len(g.out) > 0 {
+			g.out = insertAt(g.out, r.intn(len(g.out)), "\r")
+		}
+	}
+}
+
+// insertAt returns the buffer with the string inserted at i
+func insertAt(buf []byte, i int, s string) []byte {
+	buf = append(buf, s...)
+	copy(buf[i+len(s):], buf[i:len(buf)-len(s)])
+	copy(buf[i:], s)
+	return buf
+}
@@ -0,0 +1,158 @@
+package gen
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Expected is the answer that deduplicating the lines generated should give, to verify the output of
+// dedup against without another implementation of it
+type Expected struct {
+	// Lines is how many lines were generated, across all the writers
+	Lines int64 `json:"lines"`
+
+	// Unique is how many of the lines are unique
+	Unique int64 `json:"unique"`
+
+	// NearDuplicates is how many of the unique lines are near duplicates of an earlier line, which
+	// deduplicating near duplicates as well should remove about as many of
+	NearDuplicates int64 `json:"near_duplicates,omitempty"`
+
+	// Checksum is the sum of the FNV-1a 64-bit hashes of the unique lines, without their new lines, in
+	// hex. It is the same in any order, so it is the checksum of the sorted unique lines as well as of
+	// the unsorted. Sum returns the same of the output of dedup.
+	Checksum string `json:"checksum"`
+}
+
+// Sum reads the new-line delimited lines of r, such as the output of deduplicating the lines generated,
+// and returns how many there are and their checksum, to compare to the Unique and Checksum of Expected.
+// A last line without a new line is read as well.
+func Sum(r io.Reader) (lines int64, checksum string, err error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	var sum uint64
+	h := uint64(fnvOffset)
+	open := false
+	for {
+		chunk, err := br.ReadSlice('\n')
+		end := len(chunk) > 0 && chunk[len(chunk)-1] == '\n'
+		if end {
+			chunk = chunk[:len(chunk)-1]
+		}
+		if len(chunk) > 0 {
+			h = fnvAdd(h, chunk)
+			open = true
+		}
+		if end || (err == io.EOF && open) {
+			lines++
+			sum += h
+			h = fnvOffset
+			open = false
+		}
+		switch err {
+		case nil, bufio.ErrBufferFull:
+		case io.EOF:
+			return lines, fmt.Sprintf("%016x", sum), nil
+		default:
+			return 0, "", err
+		}
+	}
+}
+
+// FNV-1a 64-bit offset basis and prime
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+// fnvAdd returns the FNV-1a 64-bit hash h continued with the bytes
+func fnvAdd(h uint64, b []byte) uint64 {
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= fnvPrime
+	}
+	return h
+}
+
+// lineRef is a distinct line of a stream, which can be generated again from the seed of its stream
+type lineRef struct {
+	seed uint64
+	n    int
+}
+
+// expectation counts the lines written and those that are unique, exactly, by keeping the hash of each
+// unique line. The same string can be generated from different distinct lines, such as a URL of a
+// popular host without a path, so a hash seen before is compared to the line it was of, generated again.
+// The few lines whose hash is that of a different line are kept whole.
+type expectation struct {
+	mu       sync.Mutex
+	gen      *generator
+	lines    int64
+	unique   int64
+	near     int64
+	sum      uint64
+	hashes   map[uint64]lineRef
+	collided map[string]struct{}
+}
+
+// newExpectation returns an expectation of the lines of the options
+func newExpectation(opts Options) *expectation {
+	return &expectation{gen: newGenerator(opts), hashes: make(map[uint64]lineRef), collided: make(map[string]struct{})}
+}
+
+// add counts the lines written of a batch, and the distinct lines among them, the first written of each
+// distinct line of their stream, which may still be the same as others. Each ends with a new line.
+func (e *expectation) add(lines int, distinct [][]byte, refs []lineRef) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lines += int64(lines)
+	for i, line := range distinct {
+		line = line[:len(line)-1]
+		h := fnvAdd(fnvOffset, line)
+		if ref, ok := e.hashes[h]; !ok {
+			e.hashes[h] = refs[i]
+		} else {
+			earlier := e.gen.line(ref.seed, ref.n)
+			if bytes.Equal(earlier[:len(earlier)-1], line) {
+				continue
+			}
+			if _, ok := e.collided[string(line)]; ok {
+				continue
+			}
+			e.collided[string(line)] = struct{}{}
+		}
+		e.unique++
+		e.sum += h
+		if _, _, ok := e.gen.nearOf(refs[i].seed, refs[i].n); ok {
+			e.near++
+		}
+	}
+}
+
+// addHeaders counts the header written to each of the parts opened, once they are all written, which is
+// one unique line more
+func (e *expectation) addHeaders(header []byte, parts []*part) {
+	if header == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, p := range parts {
+		if p.writer != nil {
+			e.lines++
+		}
+	}
+	if e.lines > 0 {
+		e.unique++
+		e.sum += fnvAdd(fnvOffset, header[:len(header)-1])
+	}
+}
+
+// expected returns the answer of the lines written
+func (e *expectation) expected() Expected {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return Expected{Lines: e.lines, Unique: e.unique, NearDuplicates: e.near, Checksum: fmt.Sprintf("%016x", e.sum)}
+}
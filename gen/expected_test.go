@@ -0,0 +1,63 @@
+package gen
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/veqryn/dedup"
+)
+
+func TestExpectedMatchesDedup(t *testing.T) {
+	for _, opts := range []Options{
+		{Lines: 5000, StrLen: 8, Duplicates: 0.5, Zipf: 1.2},
+		{Lines: 5000, MinStrLen: 1, MaxStrLen: 3, Duplicates: 0.3},
+		{Lines: 3000, Mode: ModeURL, Duplicates: 0.4, NearDuplicates: 0.2},
+		{Lines: 3000, Mode: ModeJSON, Duplicates: 0.4, KeyDuplicates: 0.3},
+		{Lines: 3000, Mode: ModeCSV, Duplicates: 0.4, EmptyLines: 0.01, NoTrailingNewline: true},
+		{Lines: 3000, StrLen: 8, Duplicates: 0.4, Workers: 4},
+	} {
+		opts.Seed = 42
+		dir := t.TempDir()
+		var expected Expected
+		opts.Expected = &expected
+		in, err := os.Create(filepath.Join(dir, "in.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer in.Close()
+		if err = Generate(in, opts); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = in.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+
+		// Spill to temporary files, so that the lines are merged as well
+		out, err := os.Create(filepath.Join(dir, "out.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer out.Close()
+		stats, err := dedup.Run(out, dedup.Options{TmpFileBytes: 10000, TempDir: dir}, in, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesRead != uint64(expected.Lines) || stats.LinesUnique != uint64(expected.Unique) ||
+			stats.LinesDuplicate != uint64(expected.Lines-expected.Unique) {
+			t.Fatalf("Expected %d lines read and %d unique with %+v; Got: %+v", expected.Lines, expected.Unique, opts, stats)
+		}
+
+		if _, err = out.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		lines, checksum, err := Sum(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines != expected.Unique || checksum != expected.Checksum {
+			t.Fatalf("Expected %d lines of checksum %s with %+v; Got: %d of %s", expected.Unique, expected.Checksum, opts, lines, checksum)
+		}
+	}
+}
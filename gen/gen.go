@@ -0,0 +1,459 @@
+// Package gen (github.com/veqryn/dedup/gen) generates random string data for testing and
+// benchmarking the dedup package.
+package gen
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Mode is the kind of lines generated
+type Mode string
+
+const (
+	// ModeHex generates random hex strings of StrLen characters
+	ModeHex Mode = "hex"
+
+	// ModeURL generates URLs that look like those of real logs, of varying lengths, with weighted
+	// schemes and hostnames, path segments, and query parameters, so that many lines share prefixes
+	ModeURL Mode = "url"
+
+	// ModeJSON generates JSON objects of the Fields, one per line, such as those of structured logs
+	ModeJSON Mode = "jsonl"
+
+	// ModeCSV generates CSV rows of the Fields, after a header row of their names in each writer
+	ModeCSV Mode = "csv"
+)
+
+// Compression is how the lines generated are compressed
+type Compression string
+
+const (
+	// CompressionNone writes the lines uncompressed
+	CompressionNone Compression = ""
+
+	// CompressionGzip writes each writer a gzip stream of its lines
+	CompressionGzip Compression = "gzip"
+)
+
+// Options configures the data to be generated
+type Options struct {
+	// Lines is how many lines to generate
+	Lines int
+
+	// Bytes, if positive, is how many bytes of lines to generate instead of Lines, at most. How many
+	// lines that is, which Zipf picks the lines repeated from, is estimated from the mean length of
+	// the lines of the Mode.
+	Bytes int64
+
+	// StrLen is the length of the strings to generate with ModeHex
+	StrLen int
+
+	// MinStrLen and MaxStrLen, if MaxStrLen is positive, are the shortest and longest strings to
+	// generate with ModeHex instead of StrLen, whose lengths vary between them by StrLenDist
+	MinStrLen int
+	MaxStrLen int
+
+	// StrLenDist is how the lengths of strings vary between MinStrLen and MaxStrLen. Defaults to
+	// DistributionUniform.
+	StrLenDist Distribution
+
+	// Mode is the kind of lines to generate. Defaults to ModeHex.
+	Mode Mode
+
+	// Fields are the fields of the lines of ModeJSON and ModeCSV, in order. Defaults to DefaultFields.
+	Fields []Field
+
+	// KeyDuplicates, with ModeJSON and ModeCSV, is the fraction of the distinct lines, from 0 to 1, that
+	// repeat the key fields of an earlier distinct line, chosen at random, with the other fields new, so
+	// that they are duplicates only by their key fields
+	KeyDuplicates float64
+
+	// NearDuplicates is the fraction of the distinct lines, from 0 to 1, that are near duplicates of an
+	// earlier distinct line, chosen at random: the same but for one token, a timestamp, a number, such as
+	// an id, or a word, or, of lines without any, one character
+	NearDuplicates float64
+
+	// Duplicates is the fraction of lines, from 0 to 1, that repeat one of the distinct lines before
+	// them, chosen at random. The others are new random strings.
+	Duplicates float64
+
+	// Zipf, if not zero, is the exponent of the Zipf distribution the distinct lines repeated by
+	// Duplicates are chosen by, which must be more than 1. The first distinct lines are repeated the
+	// most, such that a few are repeated very many times and most rarely, like the lines of real logs.
+	// If zero, each distinct line is as likely to be repeated as any other.
+	Zipf float64
+
+	// EmptyLines, LongLines, InvalidUTF8, and CarriageReturns are the fractions of the distinct lines,
+	// which add up to at most 1, that are edge cases that break dedup runs: empty lines, lines of at or
+	// over 64 KiB with their new line, and lines with an invalid UTF-8 sequence, or a carriage return
+	// other than at their end, inserted in them
+	EmptyLines      float64
+	LongLines       float64
+	InvalidUTF8     float64
+	CarriageReturns float64
+
+	// NoTrailingNewline leaves the new line off the end of the last line written to each writer, unless
+	// it is an empty line
+	NoTrailingNewline bool
+
+	// Order is the order the lines of each writer are written in. Defaults to OrderGenerated.
+	Order Order
+
+	// SortedRun, with OrderSorted and OrderReverse, is how many lines are sorted at a time, so that each
+	// writer is partially sorted, in sorted runs of that many lines. If zero, all the lines of each writer
+	// are sorted, which keeps them all in memory.
+	SortedRun int
+
+	// Seed seeds the random lines, so that the same options with the same seed always generate the
+	// same lines, on any machine. If zero, the lines are seeded from the current time.
+	Seed int64
+
+	// Compression compresses the lines written to each writer. Bytes and Lines are of the lines before
+	// they are compressed. Defaults to CompressionNone.
+	Compression Compression
+
+	// Workers is how many goroutines generate lines at once, which write the same lines however many
+	// there are. The lines of each writer are generated by all of them, unless the writers of
+	// GenerateFiles do not Overlap, when as many writers are generated at once instead. Defaults to 1.
+	Workers int
+
+	// Expected, if not nil, is set to the answer that deduplicating the lines generated should give,
+	// across all the writers, once they are written. It keeps a hash of each unique line in memory.
+	Expected *Expected
+
+	// Overlap, with GenerateFiles, lets each writer repeat the lines of the writers before it, rather
+	// than only its own, so that the same lines are in more than one file
+	Overlap bool
+}
+
+// Generate writes opts.Lines new-line delimited random hex strings of length opts.StrLen, or from
+// opts.MinStrLen to opts.MaxStrLen, to w, or random URLs with ModeURL, about opts.Duplicates of
+// which repeat an earlier line. With opts.Bytes, it writes lines until the next would not fit in
+// that many bytes instead.
+func Generate(w io.Writer, opts Options) error {
+	return GenerateFiles([]io.Writer{w}, opts)
+}
+
+// GenerateFiles writes the lines of Generate split across the writers, each an equal share of
+// opts.Lines, or of opts.Bytes. With opts.Overlap, the lines are one stream split into consecutive
+// parts, so that the lines of each writer also repeat those of the writers before it. Otherwise, each
+// writer has a stream of its own, seeded differently, whose lines only repeat its own.
+func GenerateFiles(ws []io.Writer, opts Options) error {
+	if len(ws) == 0 {
+		return errors.New("at least one writer is required")
+	}
+	if opts.Bytes < 0 {
+		return errors.New("bytes must not be negative")
+	}
+	if opts.Lines <= 0 && opts.Bytes == 0 {
+		return errors.New("lines must be a positive integer")
+	}
+	switch opts.Mode {
+	case "", ModeHex:
+		if opts.MaxStrLen == 0 && opts.StrLen <= 0 {
+			return errors.New("strlen must be a positive integer")
+		}
+	case ModeURL:
+	case ModeJSON, ModeCSV:
+		fields := opts.Fields
+		if len(fields) == 0 {
+			fields = DefaultFields
+		}
+		if err := validateFields(fields, opts.KeyDuplicates); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("mode must be one of: %s, %s, %s, %s", ModeHex, ModeURL, ModeJSON, ModeCSV)
+	}
+	if opts.KeyDuplicates < 0 || opts.KeyDuplicates >= 1 {
+		return errors.New("key duplicates must be at least 0 and less than 1")
+	}
+	if opts.MaxStrLen != 0 && (opts.MinStrLen <= 0 || opts.MaxStrLen < opts.MinStrLen) {
+		return errors.New("min strlen must be a positive integer, and max strlen at least min strlen")
+	}
+	if opts.MaxStrLen == 0 && opts.MinStrLen != 0 {
+		return errors.New("max strlen is required with min strlen")
+	}
+	switch opts.StrLenDist {
+	case "", DistributionUniform, DistributionNormal, DistributionLogNormal:
+	default:
+		return fmt.Errorf("strlen dist must be one of: %s, %s, %s", DistributionUniform, DistributionNormal, DistributionLogNormal)
+	}
+	for _, rate := range []float64{opts.EmptyLines, opts.LongLines, opts.InvalidUTF8, opts.CarriageReturns} {
+		if rate < 0 || rate > 1 {
+			return errors.New("empty lines, long lines, invalid utf8, and carriage returns must be from 0 to 1")
+		}
+	}
+	if opts.EmptyLines+opts.LongLines+opts.InvalidUTF8+opts.CarriageReturns > 1 {
+		return errors.New("empty lines, long lines, invalid utf8, and carriage returns must add up to at most 1")
+	}
+	if opts.Order != OrderGenerated && opts.Order != OrderSorted && opts.Order != OrderReverse {
+		return fmt.Errorf("order must be %s or %s, or empty for the order generated", OrderSorted, OrderReverse)
+	}
+	if opts.SortedRun < 0 {
+		return errors.New("sorted run must not be negative")
+	}
+	if opts.NearDuplicates < 0 || opts.NearDuplicates >= 1 {
+		return errors.New("near duplicates must be at least 0 and less than 1")
+	}
+	if opts.Duplicates < 0 || opts.Duplicates >= 1 {
+		return errors.New("duplicates must be at least 0 and less than 1")
+	}
+	if opts.Zipf != 0 && opts.Zipf <= 1 {
+		return errors.New("zipf must be more than 1, or 0 for a uniform distribution")
+	}
+	if opts.Compression != CompressionNone && opts.Compression != CompressionGzip {
+		return fmt.Errorf("compression must be %s, or empty for none", CompressionGzip)
+	}
+
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	header := newGenerator(opts).header()
+	parts := make([]*part, len(ws))
+	for k, w := range ws {
+		parts[k] = &part{w: w, lines: int(share(int64(opts.Lines), len(ws), k)), bytes: share(opts.Bytes, len(ws), k),
+			header: header, noTrailingNewline: opts.NoTrailingNewline, order: opts.Order, runLines: opts.SortedRun, compression: opts.Compression}
+	}
+
+	var e *expectation
+	if opts.Expected != nil {
+		e = newExpectation(opts)
+		defer func() {
+			e.addHeaders(header, parts)
+			*opts.Expected = e.expected()
+		}()
+	}
+
+	// Overlapping writers are the consecutive parts of one stream, whose lines are generated by all
+	// the workers
+	if opts.Overlap || len(ws) == 1 {
+		return newStream(seed, opts, opts.Lines, opts.Bytes).write(parts, opts, workers, e)
+	}
+
+	// Otherwise, the stream of each writer is generated on its own, as many at once as there are workers
+	errs := make(chan error, len(parts))
+	limit := make(chan struct{}, workers)
+	for k, p := range parts {
+		limit <- struct{}{}
+		go func(k int, p *part) {
+			defer func() { <-limit }()
+			errs <- newStream(seed+int64(k), opts, p.lines, p.bytes).write([]*part{p}, opts, 1, e)
+		}(k, p)
+	}
+	var firstErr error
+	for range parts {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// share returns the share of the total of the k-th of n writers, which add up to the total
+func share(total int64, n, k int) int64 {
+	return total*int64(k+1)/int64(n) - total*int64(k)/int64(n)
+}
+
+// stream picks the distinct line each line generated is, of the random stream of its seed
+type stream struct {
+	random     *rand.Rand
+	lineSeed   uint64
+	zipf       *rand.Zipf
+	duplicates float64
+	distinct   int
+}
+
+// newStream returns a stream of the seed, of the lines, or about as many as fit in the bytes, if
+// positive, which Zipf picks the lines repeated from
+func newStream(seed int64, opts Options, lines int, bytes int64) *stream {
+	// Create a new random source, and a seed of the distinct lines
+	random := rand.New(rand.NewSource(seed))
+	s := &stream{random: random, lineSeed: random.Uint64(), duplicates: opts.Duplicates}
+	if bytes > 0 {
+		lines = newGenerator(opts).estimateLines(bytes, s.lineSeed)
+	}
+	if opts.Zipf != 0 && lines > 0 {
+		s.zipf = rand.NewZipf(random, opts.Zipf, 1, uint64(lines-1))
+	}
+	return s
+}
+
+// next returns the number of the distinct line to generate next: an earlier distinct line to repeat,
+// or the next new one
+func (s *stream) next() int {
+	n := s.distinct
+	switch {
+	case s.distinct == 0 || s.random.Float64() >= s.duplicates:
+		s.distinct++
+	case s.zipf != nil:
+		// Ranks beyond the distinct lines so far wrap around to the most repeated
+		n = int(s.zipf.Uint64() % uint64(s.distinct))
+	default:
+		n = s.random.Intn(s.distinct)
+	}
+	return n
+}
+
+// estimateSampleLines is how many distinct lines the mean length of lines is estimated from, when their
+// length varies
+const estimateSampleLines = 10000
+
+// generator generates the lines of the Mode, reusing its buffers
+type generator struct {
+	mode   Mode
+	strlen int
+
+	// The lengths hex strings vary between, if maxLen is positive, instead of strlen
+	minLen int
+	maxLen int
+	dist   Distribution
+
+	// The fields of ModeJSON and ModeCSV, and the fraction of lines repeating the key fields of another
+	fields        []Field
+	keyDuplicates float64
+
+	// The fraction of lines that are near duplicates of another
+	nearDuplicates float64
+
+	// The fractions of lines that are each edge case
+	emptyLines      float64
+	longLines       float64
+	invalidUTF8     float64
+	carriageReturns float64
+
+	// The random bytes of a hex string, two hex characters to a byte, those of a long line, and the
+	// line being generated
+	raw  []byte
+	long []byte
+	out  []byte
+}
+
+// newGenerator returns a generator of the lines of the options
+func newGenerator(opts Options) *generator {
+	g := &generator{mode: opts.Mode, strlen: opts.StrLen, minLen: opts.MinStrLen, maxLen: opts.MaxStrLen, dist: opts.StrLenDist,
+		fields: opts.Fields, keyDuplicates: opts.KeyDuplicates, emptyLines: opts.EmptyLines, longLines: opts.LongLines,
+		invalidUTF8: opts.InvalidUTF8, carriageReturns: opts.CarriageReturns, nearDuplicates: opts.NearDuplicates}
+	if len(g.fields) == 0 {
+		g.fields = DefaultFields
+	}
+	if g.maxLen > 0 {
+		g.raw = make([]byte, (g.maxLen+1)/2)
+	} else {
+		g.raw = make([]byte, int(math.Ceil(float64(g.strlen)/2.0)))
+	}
+	return g
+}
+
+// line returns the distinct line n of the seed, ending with a new line, which is only valid until the
+// next call
+func (g *generator) line(seed uint64, n int) []byte {
+	// A near duplicate is the line it is of, changed
+	if m, r, ok := g.nearOf(seed, n); ok {
+		g.line(seed, m)
+		g.out = g.out[:len(g.out)-1]
+		g.near(r)
+		g.out = append(g.out, '\n')
+		return g.out
+	}
+
+	r := newLineRandom(seed, n)
+	switch g.mode {
+	case ModeURL:
+		g.out = appendURL(g.out[:0], r)
+	case ModeJSON, ModeCSV:
+		g.out = g.appendRecord(g.out[:0], seed, n)
+	default:
+		// Encode to hex, cut off at the length
+		size := g.strlen
+		if g.maxLen > 0 {
+			size = g.length(r)
+		}
+		raw := g.raw[:(size+1)/2]
+		lineBytes(raw, r)
+		g.out = append(g.out[:0], hex.EncodeToString(raw)[:size]...)
+	}
+	if g.hasEdges() {
+		g.edge(seed, n)
+	}
+	g.out = append(g.out, '\n')
+	return g.out
+}
+
+// estimateLines returns about how many lines fit in the bytes, from the mean length of the first
+// distinct lines of the seed, or exactly when they are all the same length. It is at least 1.
+func (g *generator) estimateLines(bytes int64, seed uint64) int {
+	var sampled int64
+	samples := estimateSampleLines
+	if (g.mode == "" || g.mode == ModeHex) && g.maxLen == 0 && !g.hasEdges() && g.nearDuplicates == 0 {
+		samples = 1
+	}
+	for n := 0; n < samples; n++ {
+		sampled += int64(len(g.line(seed, n)))
+	}
+	lines := bytes * int64(samples) / sampled
+	if lines < 1 {
+		return 1
+	}
+	return int(lines)
+}
+
+// lineRandom is a splitmix64 stream of random numbers of a distinct line, which are always the same for
+// the same seed, so that earlier lines can be repeated without keeping them. Each line is a stream of
+// its own, starting from the seed mixed with its number.
+type lineRandom struct {
+	state uint64
+}
+
+// newLineRandom returns the random stream of the distinct line n
+func newLineRandom(seed uint64, n int) *lineRandom {
+	return &lineRandom{state: seed ^ uint64(n)*0xd1342543de82ef95}
+}
+
+// next returns the next random number of the stream
+func (r *lineRandom) next() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	z := r.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// intn returns a random number from 0 to less than n
+func (r *lineRandom) intn(n int) int {
+	return int(r.next() % uint64(n))
+}
+
+// float64 returns a random number from 0 to less than 1
+func (r *lineRandom) float64() float64 {
+	return float64(r.next()>>11) / (1 << 53)
+}
+
+// normFloat64 returns a normally distributed random number, with a mean of 0 and a standard
+// deviation of 1, by the Box-Muller transform
+func (r *lineRandom) normFloat64() float64 {
+	u1 := float64(r.next()>>11+1) / (1 << 53)
+	u2 := r.float64()
+	return math.Sqrt(-2*math.Log(u1)) * math.Cos(2*math.Pi*u2)
+}
+
+// lineBytes fills the buffer with random bytes from the stream of a line
+func lineBytes(buff []byte, r *lineRandom) {
+	for i := 0; i < len(buff); i += 8 {
+		z := r.next()
+		for k := 0; k < 8 && i+k < len(buff); k++ {
+			buff[i+k] = byte(z >> (8 * k))
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package gen
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestGenerateWorkers(t *testing.T) {
+	for _, opts := range []Options{
+		{Lines: 20000, StrLen: 8, Duplicates: 0.5, Zipf: 1.2},
+		{Bytes: 100000, MinStrLen: 1, MaxStrLen: 20, StrLenDist: DistributionNormal, Duplicates: 0.3},
+		{Lines: 5000, Mode: ModeURL, Duplicates: 0.4, NearDuplicates: 0.2},
+		{Lines: 5000, Mode: ModeJSON, Duplicates: 0.4, KeyDuplicates: 0.3, Compression: CompressionGzip},
+		{Lines: 5000, StrLen: 8, Duplicates: 0.4, Order: OrderSorted, SortedRun: 100},
+	} {
+		opts.Seed = 42
+		for _, files := range []int{1, 3} {
+			for _, overlap := range []bool{false, true} {
+				opts.Overlap = overlap
+				opts.Workers = 1
+				single := generateFiles(t, files, opts)
+				opts.Workers = 4
+				parallel := generateFiles(t, files, opts)
+				for k := range single {
+					if !bytes.Equal(single[k], parallel[k]) {
+						t.Fatalf("Expected file %d of %d, overlapping %t, to be the same with 4 workers as with 1: %+v", k, files, overlap, opts)
+					}
+				}
+			}
+		}
+	}
+}
+
+// generateFiles returns the bytes of the files generated
+func generateFiles(t *testing.T, files int, opts Options) [][]byte {
+	t.Helper()
+	bufs := make([]*bytes.Buffer, files)
+	ws := make([]io.Writer, files)
+	for k := range bufs {
+		bufs[k] = &bytes.Buffer{}
+		ws[k] = bufs[k]
+	}
+	if err := GenerateFiles(ws, opts); err != nil {
+		t.Fatal(err)
+	}
+	b := make([][]byte, files)
+	for k, buf := range bufs {
+		if buf.Len() == 0 {
+			t.Fatalf("Expected file %d of %d to have lines: %+v", k, files, opts)
+		}
+		b[k] = buf.Bytes()
+	}
+	return b
+}
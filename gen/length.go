@@ -0,0 +1,43 @@
+package gen
+
+import (
+	"math"
+)
+
+// Distribution is how the lengths of hex strings vary between MinStrLen and MaxStrLen
+type Distribution string
+
+const (
+	// DistributionUniform makes every length from MinStrLen to MaxStrLen as likely as any other
+	DistributionUniform Distribution = "uniform"
+
+	// DistributionNormal makes lengths normally distributed around the middle of MinStrLen and
+	// MaxStrLen, with a sixth of the range between them as the standard deviation
+	DistributionNormal Distribution = "normal"
+
+	// DistributionLogNormal makes lengths log-normally distributed, normal around the middle of the
+	// logarithms of MinStrLen and MaxStrLen, so that most lines are short and a long tail of them long,
+	// like the lines of real logs
+	DistributionLogNormal Distribution = "lognormal"
+)
+
+// length returns the length of the hex string of the random stream of a line, from minLen to maxLen.
+// Lengths drawn beyond them, which are rare, are drawn again.
+func (g *generator) length(r *lineRandom) int {
+	if g.dist == "" || g.dist == DistributionUniform {
+		return g.minLen + r.intn(g.maxLen-g.minLen+1)
+	}
+	lo, hi := float64(g.minLen), float64(g.maxLen)
+	if g.dist == DistributionLogNormal {
+		lo, hi = math.Log(lo), math.Log(hi)
+	}
+	for {
+		x := (lo+hi)/2 + (hi-lo)/6*r.normFloat64()
+		if g.dist == DistributionLogNormal {
+			x = math.Exp(x)
+		}
+		if n := int(math.Round(x)); n >= g.minLen && n <= g.maxLen {
+			return n
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package gen
+
+import (
+	"time"
+)
+
+// nearSalt salts the seed of the random streams that pick which lines are near duplicates, and what of
+// them is changed, so that they are apart from the streams of the lines themselves
+const nearSalt = 0x6e6561722d647570
+
+// urlWordSet is the set of urlWords, which the words of lines that near duplicates change are of
+var urlWordSet = func() map[string]bool {
+	set := make(map[string]bool, len(urlWords))
+	for _, w := range urlWords {
+		set[w] = true
+	}
+	return set
+}()
+
+// Kinds of the tokens that near duplicates change
+const (
+	tokenTime = iota
+	tokenNumber
+	tokenWord
+	tokenKinds
+)
+
+// token is where a token of a line is, and its kind
+type token struct {
+	start, end int
+	kind       int
+}
+
+// nearOf returns the earlier distinct line that the distinct line n is a near duplicate of, if it is
+// one, and the random stream that picks what of it is changed
+func (g *generator) nearOf(seed uint64, n int) (int, *lineRandom, bool) {
+	if g.nearDuplicates == 0 || n == 0 {
+		return 0, nil, false
+	}
+	r := newLineRandom(seed^nearSalt, n)
+	if r.float64() >= g.nearDuplicates {
+		return 0, nil, false
+	}
+	return r.intn(n), r, true
+}
+
+// near changes one token of the line being generated, without its new line, so that it is a near
+// duplicate of it: a timestamp, a number, such as an id, or a word, of the kinds the line has, picked at
+// random. Lines without any, such as hex strings of letters only, have one character changed instead.
+func (g *generator) near(r *lineRandom) {
+	var tokens [tokenKinds][]token
+	for i := 0; i < len(g.out); {
+		switch c := g.out[i]; {
+		case isTimestamp(g.out[i:]):
+			tokens[tokenTime] = append(tokens[tokenTime], token{start: i, end: i + len(timestampLayout), kind: tokenTime})
+			i += len(timestampLayout)
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(g.out) && g.out[i] >= '0' && g.out[i] <= '9' {
+				i++
+			}
+			tokens[tokenNumber] = append(tokens[tokenNumber], token{start: start, end: i, kind: tokenNumber})
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(g.out) && (g.out[i] >= 'a' && g.out[i] <= 'z' || g.out[i] >= 'A' && g.out[i] <= 'Z') {
+				i++
+			}
+			// Not the keys of JSON objects, which are the same in every line
+			isKey := i+1 < len(g.out) && g.out[i] == '"' && g.out[i+1] == ':'
+			if urlWordSet[string(g.out[start:i])] && !isKey {
+				tokens[tokenWord] = append(tokens[tokenWord], token{start: start, end: i, kind: tokenWord})
+			}
+		default:
+			i++
+		}
+	}
+
+	var kinds []int
+	for kind := range tokens {
+		if len(tokens[kind]) > 0 {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) == 0 {
+		g.nearCharacter(r)
+		return
+	}
+	candidates := tokens[kinds[r.intn(len(kinds))]]
+	t := candidates[r.intn(len(candidates))]
+	old := g.out[t.start:t.end]
+
+	var replacement []byte
+	switch t.kind {
+	case tokenTime:
+		// Within an hour either side of it
+		at, _ := time.Parse(time.RFC3339, string(old))
+		shift := time.Duration(1+r.intn(3600)) * time.Second
+		if r.intn(2) == 0 {
+			shift = -shift
+		}
+		replacement = at.Add(shift).UTC().AppendFormat(nil, time.RFC3339)
+	case tokenNumber:
+		// As many digits, one of them at least different
+		replacement = make([]byte, len(old))
+		for i := range replacement {
+			replacement[i] = byte('0' + r.intn(10))
+		}
+		if string(replacement) == string(old) {
+			replacement[len(replacement)-1] = '0' + (replacement[len(replacement)-1]-'0'+1)%10
+		}
+	case tokenWord:
+		i := r.intn(len(urlWords))
+		if urlWords[i] == string(old) {
+			i = (i + 1) % len(urlWords)
+		}
+		replacement = []byte(urlWords[i])
+	}
+	rest := append(replacement, g.out[t.end:]...)
+	g.out = append(g.out[:t.start], rest...)
+}
+
+// nearCharacter changes one character of the line being generated to another hex character, or adds one
+// to an empty line
+func (g *generator) nearCharacter(r *lineRandom) {
+	const hexChars = "0123456789abcdef"
+	if len(g.out) == 0 {
+		g.out = append(g.out, hexChars[r.intn(len(hexChars))])
+		return
+	}
+	i := r.intn(len(g.out))
+	c := r.intn(len(hexChars))
+	if hexChars[c] == g.out[i] {
+		c = (c + 1) % len(hexChars)
+	}
+	g.out[i] = hexChars[c]
+}
+
+// timestampLayout is the layout of the timestamps near duplicates change, RFC 3339 times in UTC without
+// fractions of a second, such as those of FieldTime, where d is a digit
+const timestampLayout = "dddd-dd-ddTdd:dd:ddZ"
+
+// isTimestamp returns true if the bytes begin with a timestamp of timestampLayout
+func isTimestamp(b []byte) bool {
+	if len(b) < len(timestampLayout) {
+		return false
+	}
+	for i := 0; i < len(timestampLayout); i++ {
+		if timestampLayout[i] == 'd' {
+			if b[i] < '0' || b[i] > '9' {
+				return false
+			}
+		} else if b[i] != timestampLayout[i] {
+			return false
+		}
+	}
+	return true
+}
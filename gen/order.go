@@ -0,0 +1,51 @@
+package gen
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Order is the order the lines generated are written in
+type Order string
+
+const (
+	// OrderGenerated writes the lines in the order they are generated, which is random
+	OrderGenerated Order = ""
+
+	// OrderSorted writes the lines sorted, byte by byte, as dedup sorts them
+	OrderSorted Order = "sorted"
+
+	// OrderReverse writes the lines sorted in reverse
+	OrderReverse Order = "reverse"
+)
+
+// run is the lines of a part that are sorted before they are written, each ending with a new line
+type run struct {
+	buf  []byte
+	ends []int
+}
+
+// add adds the line to the run
+func (r *run) add(line []byte) {
+	r.buf = append(r.buf, line...)
+	r.ends = append(r.ends, len(r.buf))
+}
+
+// sorted returns the lines of the run sorted by the order, without their new lines, and empties it.
+// The lines are only valid until the next line is added.
+func (r *run) sorted(order Order) [][]byte {
+	lines := make([][]byte, len(r.ends))
+	start := 0
+	for i, end := range r.ends {
+		lines[i] = r.buf[start : end-1]
+		start = end
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if order == OrderReverse {
+			return bytes.Compare(lines[i], lines[j]) > 0
+		}
+		return bytes.Compare(lines[i], lines[j]) < 0
+	})
+	r.buf, r.ends = r.buf[:0], r.ends[:0]
+	return lines
+}
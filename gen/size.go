@@ -0,0 +1,37 @@
+package gen
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// byteUnits are the suffixes of byte sizes, and the bytes of each, decimal and binary
+var byteUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30}, {"tib", 1 << 40},
+	{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9}, {"tb", 1e12},
+	{"k", 1e3}, {"m", 1e6}, {"g", 1e9}, {"t", 1e12},
+	{"b", 1},
+}
+
+// ParseBytes parses a byte size, such as 100GB, for the Bytes option. It is a whole number of bytes,
+// or a number followed by a decimal unit (KB, MB, GB, or TB, or just K, M, G, or T), or a binary one
+// (KiB, MiB, GiB, or TiB), of any case.
+func ParseBytes(size string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(size))
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			value, unit = strings.TrimSpace(strings.TrimSuffix(value, u.suffix)), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(n >= 0) || n*float64(unit) >= 1<<63 {
+		return 0, errors.New("invalid byte size " + strconv.Quote(size) + ", such as 100GB")
+	}
+	return int64(n * float64(unit)), nil
+}
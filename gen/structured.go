@@ -0,0 +1,198 @@
+package gen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldKind is the kind of the values of a field of the lines of ModeJSON and ModeCSV
+type FieldKind string
+
+const (
+	// FieldInt values are random integers, less than a million
+	FieldInt FieldKind = "int"
+
+	// FieldHex values are random hex strings of 16 characters
+	FieldHex FieldKind = "hex"
+
+	// FieldWord values are words, of those the paths of ModeURL are made of
+	FieldWord FieldKind = "word"
+
+	// FieldURL values are URLs, the same as the lines of ModeURL
+	FieldURL FieldKind = "url"
+
+	// FieldTime values are RFC 3339 times in UTC, within a year of fieldTimeStart
+	FieldTime FieldKind = "time"
+)
+
+// fieldTimeStart is the earliest time of FieldTime values
+var fieldTimeStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+
+// Field is a field of the lines of ModeJSON and ModeCSV
+type Field struct {
+	// Name is the key of the field in JSON objects, and its column in the CSV header
+	Name string
+
+	// Kind is the kind of the values of the field
+	Kind FieldKind
+
+	// Key makes the field one of the key fields, which KeyDuplicates repeats
+	Key bool
+}
+
+// DefaultFields are the fields of the lines of ModeJSON and ModeCSV, if Options.Fields is empty
+var DefaultFields = []Field{
+	{Name: "id", Kind: FieldInt, Key: true},
+	{Name: "user", Kind: FieldWord, Key: true},
+	{Name: "url", Kind: FieldURL},
+	{Name: "time", Kind: FieldTime},
+	{Name: "trace", Kind: FieldHex},
+}
+
+// ParseFields parses fields of the form name:kind, separated by commas, such as
+// "id:int,user:word,url:url", and makes those named by keys, separated by commas, key fields
+func ParseFields(fields, keys string) ([]Field, error) {
+	var parsed []Field
+	for _, spec := range strings.Split(fields, ",") {
+		i := strings.LastIndexByte(spec, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid field %q: must be of the form name:kind", spec)
+		}
+		parsed = append(parsed, Field{Name: strings.TrimSpace(spec[:i]), Kind: FieldKind(strings.TrimSpace(spec[i+1:]))})
+	}
+	if keys == "" {
+		return parsed, nil
+	}
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		found := false
+		for i := range parsed {
+			if parsed[i].Name == key {
+				parsed[i].Key = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("key field %q is not one of the fields", key)
+		}
+	}
+	return parsed, nil
+}
+
+// validateFields returns an error if a field has no name, or one that is not made of letters, digits,
+// '_', '-', and '.', so that it needs no quoting, or of a kind that is unknown, or if two have the same
+// name, or if keyDuplicates has no key field to repeat
+func validateFields(fields []Field, keyDuplicates float64) error {
+	names := make(map[string]bool, len(fields))
+	hasKey := false
+	for _, f := range fields {
+		if f.Name == "" || strings.IndexFunc(f.Name, func(c rune) bool {
+			return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.')
+		}) >= 0 {
+			return fmt.Errorf("field name %q must be letters, digits, '_', '-', and '.'", f.Name)
+		}
+		if names[f.Name] {
+			return fmt.Errorf("field name %q is used more than once", f.Name)
+		}
+		names[f.Name] = true
+		switch f.Kind {
+		case FieldInt, FieldHex, FieldWord, FieldURL, FieldTime:
+		default:
+			return fmt.Errorf("kind of field %q must be one of: %s, %s, %s, %s, %s", f.Name, FieldInt, FieldHex, FieldWord, FieldURL, FieldTime)
+		}
+		hasKey = hasKey || f.Key
+	}
+	if keyDuplicates > 0 && !hasKey {
+		return fmt.Errorf("key duplicates require a key field")
+	}
+	return nil
+}
+
+// Salts of the seed of the random streams that pick the distinct line whose key fields a line repeats,
+// and that the values of the key fields are of, so that they are apart from the streams of the lines
+const (
+	keyPickSalt  = 0x6b65792d7069636b
+	keyValueSalt = 0x6b65792d76616c75
+)
+
+// keyOf returns the distinct line whose key fields the distinct line n has: about keyDuplicates of
+// lines repeat the key fields of an earlier line, which may repeat those of one earlier still
+func (g *generator) keyOf(seed uint64, n int) int {
+	for n > 0 {
+		r := newLineRandom(seed^keyPickSalt, n)
+		if r.float64() >= g.keyDuplicates {
+			return n
+		}
+		n = r.intn(n)
+	}
+	return 0
+}
+
+// appendRecord appends the fields of the distinct line n to the buffer, as a JSON object, or a CSV row.
+// The key fields are random from the stream of the line whose key fields it has, and the others from
+// its own, so that lines repeating the key fields of another differ only outside them.
+func (g *generator) appendRecord(buf []byte, seed uint64, n int) []byte {
+	r := newLineRandom(seed, n)
+	kr := newLineRandom(seed^keyValueSalt, n)
+	if g.keyDuplicates > 0 {
+		kr = newLineRandom(seed^keyValueSalt, g.keyOf(seed, n))
+	}
+	if g.mode == ModeJSON {
+		buf = append(buf, '{')
+	}
+	for i, f := range g.fields {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		if g.mode == ModeJSON {
+			buf = append(buf, '"')
+			buf = append(buf, f.Name...)
+			buf = append(buf, `":`...)
+		}
+		fr := r
+		if f.Key {
+			fr = kr
+		}
+		quote := g.mode == ModeJSON && f.Kind != FieldInt
+		if quote {
+			buf = append(buf, '"')
+		}
+		switch f.Kind {
+		case FieldInt:
+			buf = strconv.AppendInt(buf, int64(fr.intn(1000000)), 10)
+		case FieldHex:
+			v := fr.next()
+			for shift := 60; shift >= 0; shift -= 4 {
+				buf = append(buf, "0123456789abcdef"[v>>uint(shift)&0xf])
+			}
+		case FieldWord:
+			buf = append(buf, urlWords[fr.intn(len(urlWords))]...)
+		case FieldURL:
+			buf = appendURL(buf, fr)
+		case FieldTime:
+			buf = time.Unix(fieldTimeStart+int64(fr.intn(365*24*60*60)), 0).UTC().AppendFormat(buf, time.RFC3339)
+		}
+		if quote {
+			buf = append(buf, '"')
+		}
+	}
+	if g.mode == ModeJSON {
+		buf = append(buf, '}')
+	}
+	return buf
+}
+
+// header returns the header row of ModeCSV, of the names of the fields, ending with a new line, or nil
+// for the other modes
+func (g *generator) header() []byte {
+	if g.mode != ModeCSV {
+		return nil
+	}
+	names := make([]string, len(g.fields))
+	for i, f := range g.fields {
+		names[i] = f.Name
+	}
+	return []byte(strings.Join(names, ",") + "\n")
+}
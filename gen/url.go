@@ -0,0 +1,128 @@
+package gen
+
+import (
+	"strconv"
+)
+
+// urlHosts are the hostnames of generated URLs, with the weight each is picked by. The rest of the hosts
+// are made up from urlWords, so that a few hosts have most of the URLs, with a long tail of others.
+var urlHosts = []struct {
+	host   string
+	weight int
+}{
+	{"www.google.com", 200},
+	{"www.youtube.com", 120},
+	{"www.facebook.com", 100},
+	{"en.wikipedia.org", 80},
+	{"www.amazon.com", 70},
+	{"twitter.com", 60},
+	{"www.instagram.com", 50},
+	{"www.reddit.com", 45},
+	{"www.linkedin.com", 40},
+	{"github.com", 35},
+	{"www.example.com", 30},
+	{"cdn.jsdelivr.net", 25},
+	{"api.stripe.com", 20},
+	{"news.ycombinator.com", 15},
+	{"stackoverflow.com", 15},
+}
+
+// urlMadeUpWeight is the weight of a made up host, out of urlHosts and it
+const urlMadeUpWeight = 300
+
+// urlHostsWeight is the total weight of urlHosts and the made up hosts
+var urlHostsWeight = func() int {
+	total := urlMadeUpWeight
+	for _, h := range urlHosts {
+		total += h.weight
+	}
+	return total
+}()
+
+// urlWords are the words the path segments, query parameters, and made up hosts of URLs are made of
+var urlWords = []string{
+	"about", "account", "api", "archive", "article", "assets", "blog", "cart", "category", "checkout",
+	"comments", "config", "content", "dashboard", "docs", "download", "events", "feed", "files", "help",
+	"home", "images", "index", "item", "jobs", "login", "logout", "media", "news", "orders",
+	"page", "photos", "posts", "product", "profile", "projects", "releases", "reports", "search", "settings",
+	"shop", "static", "status", "store", "support", "tags", "team", "topics", "user", "users",
+	"v1", "v2", "video", "watch", "wiki", "admin", "auth", "billing", "calendar", "contact",
+	"forum", "groups", "issues", "library",
+}
+
+// urlTLDs are the top level domains of made up hosts
+var urlTLDs = []string{".com", ".com", ".com", ".net", ".org", ".io", ".co.uk", ".de"}
+
+// urlParams are the names of query parameters
+var urlParams = []string{"id", "q", "page", "ref", "sort", "utm_source", "utm_medium", "lang", "session", "v"}
+
+// appendURL appends a random URL to the buffer, from the random stream of a line
+func appendURL(buf []byte, r *lineRandom) []byte {
+	// Scheme
+	if r.intn(100) < 85 {
+		buf = append(buf, "https://"...)
+	} else {
+		buf = append(buf, "http://"...)
+	}
+
+	// Host, weighted towards the popular ones
+	if host := pickHost(r); host != "" {
+		buf = append(buf, host...)
+	} else {
+		if r.intn(3) == 0 {
+			buf = append(buf, "www."...)
+		}
+		buf = append(buf, urlWords[r.intn(len(urlWords))]...)
+		buf = strconv.AppendInt(buf, int64(r.intn(10000)), 10)
+		buf = append(buf, urlTLDs[r.intn(len(urlTLDs))]...)
+	}
+
+	// Path segments, of words, numeric ids, and hex hashes
+	segments := r.intn(5)
+	if segments == 0 {
+		buf = append(buf, '/')
+	}
+	for i := 0; i < segments; i++ {
+		buf = append(buf, '/')
+		switch kind := r.intn(10); {
+		case kind < 7:
+			buf = append(buf, urlWords[r.intn(len(urlWords))]...)
+		case kind < 9:
+			buf = strconv.AppendInt(buf, int64(r.intn(1000000)), 10)
+		default:
+			buf = strconv.AppendUint(buf, r.next(), 16)
+		}
+	}
+
+	// Query parameters
+	if r.intn(10) < 4 {
+		params := 1 + r.intn(3)
+		for i := 0; i < params; i++ {
+			if i == 0 {
+				buf = append(buf, '?')
+			} else {
+				buf = append(buf, '&')
+			}
+			buf = append(buf, urlParams[r.intn(len(urlParams))]...)
+			buf = append(buf, '=')
+			if r.intn(2) == 0 {
+				buf = strconv.AppendInt(buf, int64(r.intn(100000)), 10)
+			} else {
+				buf = append(buf, urlWords[r.intn(len(urlWords))]...)
+			}
+		}
+	}
+	return buf
+}
+
+// pickHost returns one of urlHosts by their weights, or nothing for a made up host
+func pickHost(r *lineRandom) string {
+	pick := r.intn(urlHostsWeight)
+	for _, h := range urlHosts {
+		if pick < h.weight {
+			return h.host
+		}
+		pick -= h.weight
+	}
+	return ""
+}
@@ -0,0 +1,224 @@
+package gen
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+)
+
+// batchLines is how many lines each batch a worker generates has
+const batchLines = 1024
+
+// part is a writer the lines of a stream are written to, until it has its share of lines or bytes
+type part struct {
+	w           io.Writer
+	lines       int
+	bytes       int64
+	header      []byte
+	compression Compression
+
+	// order sorts the lines in runs of runLines, or all of them if runLines is 0, before they are written
+	order    Order
+	runLines int
+	run      run
+
+	// noTrailingNewline leaves the new line off the last line, which is only written with the next,
+	// unless the last line is empty, which would not be a line without it
+	noTrailingNewline bool
+	newline           bool
+	empty             bool
+
+	gz         *gzip.Writer
+	writer     *bufio.Writer
+	lineCount  int
+	bytesCount int64
+}
+
+// open starts writing to the part, through a buffer and its compression, beginning with its header, if
+// it has one, which is counted in its bytes but not its lines
+func (p *part) open() error {
+	w := p.w
+	if p.compression == CompressionGzip {
+		p.gz = gzip.NewWriter(w)
+		w = p.gz
+	}
+	p.writer = bufio.NewWriterSize(w, 256*1024)
+	p.bytesCount += int64(len(p.header))
+	_, err := p.writer.Write(p.header)
+	return err
+}
+
+// fits returns true if the part has room for the line: fewer than its lines, or, if it has bytes,
+// enough bytes left for it
+func (p *part) fits(line []byte) bool {
+	if p.bytes > 0 {
+		return p.bytesCount+int64(len(line)) <= p.bytes
+	}
+	return p.lineCount < p.lines
+}
+
+// write writes the line to the part, or adds it to the run to sort, writing the run once it is full
+func (p *part) write(line []byte) error {
+	p.lineCount++
+	p.bytesCount += int64(len(line))
+	if p.order == OrderGenerated {
+		return p.emit(line)
+	}
+	p.run.add(line)
+	if len(p.run.ends) == p.runLines {
+		return p.writeRun()
+	}
+	return nil
+}
+
+// writeRun writes the lines of the run, sorted
+func (p *part) writeRun() error {
+	for _, line := range p.run.sorted(p.order) {
+		// The new line is still after the line in the buffer of the run
+		if err := p.emit(line[:len(line)+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emit writes the line to the writer
+func (p *part) emit(line []byte) error {
+	if !p.noTrailingNewline {
+		_, err := p.writer.Write(line)
+		return err
+	}
+	if p.newline {
+		if err := p.writer.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	p.newline, p.empty = true, len(line) == 1
+	_, err := p.writer.Write(line[:len(line)-1])
+	return err
+}
+
+// close flushes the part, and ends its compression
+func (p *part) close() error {
+	if len(p.run.ends) > 0 {
+		if err := p.writeRun(); err != nil {
+			return err
+		}
+	}
+	if p.empty {
+		if err := p.writer.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	if err := p.writer.Flush(); err != nil {
+		return err
+	}
+	if p.gz != nil {
+		return p.gz.Close()
+	}
+	return nil
+}
+
+// batch is consecutive lines of a stream: the distinct line each is, and once generated, their bytes
+// and where each ends in them
+type batch struct {
+	ns   []int
+	out  []byte
+	ends []int
+	done chan struct{}
+}
+
+// write writes the lines of the stream to the parts in order, moving to the next part once a line
+// does not fit in the current one, until none are left. The distinct line each line is, is picked
+// in order, in batches that the workers generate at once, and which are written in order, so that
+// the lines are the same however many workers there are. The lines written are added to the
+// expectation, if there is one.
+func (s *stream) write(parts []*part, opts Options, workers int, e *expectation) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	jobs := make(chan *batch, workers)
+	order := make(chan *batch, 2*workers)
+
+	// Pick the lines of each batch in order
+	go func() {
+		defer close(jobs)
+		defer close(order)
+		for {
+			b := &batch{ns: make([]int, batchLines), done: make(chan struct{})}
+			for i := range b.ns {
+				b.ns[i] = s.next()
+			}
+			select {
+			case order <- b:
+			case <-stop:
+				return
+			}
+			select {
+			case jobs <- b:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	// Generate the batches
+	for i := 0; i < workers; i++ {
+		go func() {
+			g := newGenerator(opts)
+			for b := range jobs {
+				for _, n := range b.ns {
+					b.out = append(b.out, g.line(s.lineSeed, n)...)
+					b.ends = append(b.ends, len(b.out))
+				}
+				close(b.done)
+			}
+		}()
+	}
+
+	// Write the batches in order
+	k := 0
+	if err := parts[k].open(); err != nil {
+		return err
+	}
+	next := 0 // The next new distinct line
+	var distinct [][]byte
+	var refs []lineRef
+	for b := range order {
+		<-b.done
+		start, written := 0, 0
+		distinct, refs = distinct[:0], refs[:0]
+		for i, end := range b.ends {
+			line := b.out[start:end]
+			start = end
+			for k < len(parts) && !parts[k].fits(line) {
+				if err := parts[k].close(); err != nil {
+					return err
+				}
+				if k++; k < len(parts) {
+					if err := parts[k].open(); err != nil {
+						return err
+					}
+				}
+			}
+			if k == len(parts) {
+				break
+			}
+			if err := parts[k].write(line); err != nil {
+				return err
+			}
+			written++
+			if b.ns[i] == next {
+				next++
+				distinct = append(distinct, line)
+				refs = append(refs, lineRef{seed: s.lineSeed, n: b.ns[i]})
+			}
+		}
+		if e != nil {
+			e.add(written, distinct, refs)
+		}
+		if k == len(parts) {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package dedup
+
+import (
+	"hash/maphash"
+	"sort"
+)
+
+// hashLineOverhead is about how many bytes a hash set uses for each of its lines besides the line
+// itself: the string header of the line, and its hash and index in a table that is half to three
+// quarters full
+const hashLineOverhead = 36
+
+// minHashSlots is how many slots the table of a hash set starts with
+const minHashSlots = 1024
+
+// hashSet is a compact set of lines, used instead of a map with HashSet. The lines are copied into the
+// arena of the set and kept in a slice, in the order they were added, and the membership of a line is
+// looked up by its 64-bit hash in an open addressing table of the hash and index of each line. Neither
+// table holds pointers, so the garbage collector has few objects to scan however many lines there are.
+// A line with the same hash as a different line already in the table is rare, and is added to a small
+// map of its own instead, where it is found exactly.
+type hashSet struct {
+	hash     maphash.Hash
+	hashes   []uint64
+	indexes  []uint32 // The index of the line of each slot in lines, plus one, or zero if the slot is empty
+	lines    []string
+	overflow map[string]struct{}
+	arena    lineArena
+}
+
+// newHashSet returns an empty hash set
+func newHashSet() *hashSet {
+	return &hashSet{
+		hashes:  make([]uint64, minHashSlots),
+		indexes: make([]uint32, minHashSlots),
+	}
+}
+
+func (s *hashSet) len() int {
+	return len(s.lines) + len(s.overflow)
+}
+
+func (s *hashSet) has(line []byte) bool {
+	s.hash.Reset()
+	s.hash.Write(line)
+	slot, found := s.find(s.hash.Sum64())
+	if !found {
+		return false
+	}
+	if s.lines[s.indexes[slot]-1] == string(line) {
+		return true
+	}
+	_, ok := s.overflow[string(line)]
+	return ok
+}
+
+func (s *hashSet) add(line string) bool {
+	s.hash.Reset()
+	s.hash.WriteString(line)
+	h := s.hash.Sum64()
+	slot, found := s.find(h)
+	if found {
+		if s.lines[s.indexes[slot]-1] == line {
+			return false
+		}
+		if _, ok := s.overflow[line]; ok {
+			return false
+		}
+		if s.overflow == nil {
+			s.overflow = make(map[string]struct{})
+		}
+		s.overflow[s.arena.copyString(line)] = struct{}{}
+		return true
+	}
+
+	s.lines = append(s.lines, s.arena.copyString(line))
+	s.hashes[slot], s.indexes[slot] = h, uint32(len(s.lines))
+	if 4*len(s.lines) > 3*len(s.hashes) {
+		s.grow()
+	}
+	return true
+}
+
+// find returns the slot of the hash in the table, and true, or the empty slot it would go in, and false
+func (s *hashSet) find(h uint64) (int, bool) {
+	mask := uint64(len(s.hashes) - 1)
+	for slot := h & mask; ; slot = (slot + 1) & mask {
+		if s.indexes[slot] == 0 {
+			return int(slot), false
+		}
+		if s.hashes[slot] == h {
+			return int(slot), true
+		}
+	}
+}
+
+// grow doubles the slots of the table, moving each line to its slot in the larger one
+func (s *hashSet) grow() {
+	hashes, indexes := s.hashes, s.indexes
+	s.hashes, s.indexes = make([]uint64, 2*len(hashes)), make([]uint32, 2*len(indexes))
+	for i, index := range indexes {
+		if index != 0 {
+			slot, _ := s.find(hashes[i])
+			s.hashes[slot], s.indexes[slot] = hashes[i], index
+		}
+	}
+}
+
+// sortedKeys sorts the slice of lines of the set in place, along with those of the overflow map
+func (s *hashSet) sortedKeys() []string {
+	keys := s.lines
+	for line := range s.overflow {
+		keys = append(keys, line)
+	}
+	sort.Strings(keys)
+	s.lines, s.overflow, s.hashes, s.indexes = nil, nil, nil, nil
+	return keys
+}
+
+// sample takes the lines of the first slots of the table, which are in the random order of their hashes
+func (s *hashSet) sample(size int) []string {
+	if size > len(s.lines) {
+		size = len(s.lines)
+	}
+	sample := make([]string, 0, size)
+	for _, index := range s.indexes {
+		if len(sample) == size {
+			break
+		}
+		if index != 0 {
+			sample = append(sample, s.lines[index-1])
+		}
+	}
+	sort.Strings(sample)
+	return sample
+}
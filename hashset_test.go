@@ -0,0 +1,98 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestHashSet(t *testing.T) {
+	s := newHashSet()
+	set := make(map[string]struct{})
+	for i := 0; i < 5000; i++ {
+		line := fmt.Sprint(i % 3000)
+		_, exists := set[line]
+		if s.has([]byte(line)) != exists {
+			t.Fatalf("Expected %q to be in the set: %t", line, exists)
+		}
+		if added := s.add(line); added == exists {
+			t.Fatalf("Expected %q to be added: %t", line, !exists)
+		}
+		set[line] = struct{}{}
+	}
+	if s.len() != len(set) || len(s.hashes) <= minHashSlots {
+		t.Fatalf("Expected %d lines in a larger table; Got: %d lines in %d slots", len(set), s.len(), len(s.hashes))
+	}
+
+	sample := s.sample(10)
+	if len(sample) != 10 || !sort.StringsAreSorted(sample) {
+		t.Fatalf("Unexpected sample: %q", sample)
+	}
+	expected := sortKeys(set)
+	if keys := s.sortedKeys(); strings.Join(keys, ",") != strings.Join(expected, ",") {
+		t.Fatal("Expected the sorted lines of the set")
+	}
+}
+
+func TestHashSetCollision(t *testing.T) {
+	s := newHashSet()
+	s.add("a")
+
+	// Make the hash of "b" point at "a", as if their hashes were the same
+	s.hash.Reset()
+	s.hash.WriteString("b")
+	slot, _ := s.find(s.hash.Sum64())
+	s.hashes[slot], s.indexes[slot] = s.hash.Sum64(), 1
+
+	if s.has([]byte("b")) {
+		t.Fatal("Expected b not to be found as a")
+	}
+	if !s.add("b") || s.add("b") || !s.has([]byte("b")) || !s.has([]byte("a")) {
+		t.Fatal("Expected b to be added once, to the overflow")
+	}
+	if len(s.overflow) != 1 || s.len() != 2 {
+		t.Fatalf("Expected 2 lines, one in the overflow; Got: %d, %d", s.len(), len(s.overflow))
+	}
+	if keys := s.sortedKeys(); strings.Join(keys, ",") != "a,b" {
+		t.Fatalf("Unexpected sorted lines: %q", keys)
+	}
+}
+
+func TestRunHashSet(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&input, "line %d\n", (i*7)%500)
+	}
+
+	var outputs [2]string
+	for i, hashSet := range []bool{false, true} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		stats, err := Run(outFile, Options{TmpFileBytes: 1000, HashSet: hashSet}, strings.NewReader(input.String()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 500 || stats.Chunks < 2 {
+			t.Fatalf("Expected 500 unique lines from several chunks; Got: %+v", stats)
+		}
+		b, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs[i] = string(b)
+	}
+	if outputs[1] != outputs[0] {
+		t.Fatal("Expected the same output with a hash set")
+	}
+
+	if _, err := Run(nil, Options{HashSet: true, PrefixSet: true}, strings.NewReader(""), nil); err == nil {
+		t.Fatal("Expected an error with both a hash set and a prefix set")
+	}
+}
@@ -0,0 +1,125 @@
+package dedup
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// defaultIndexInterval is how many unique lines are written for each line given to OnIndex, by default
+const defaultIndexInterval = 1024
+
+// IndexWriter returns an OnIndex callback writing each line it is called with to w as an entry of a
+// sidecar index, which NewIndexedSortedFile reads: the offset in decimal, a tab, and the line, ended by
+// the Delimiter of the options, or a new line without one. w should be buffered.
+func IndexWriter(w io.Writer, opts Options) func(line string, offset uint64) error {
+	delim := opts.Delimiter
+	if delim == "" {
+		delim = defaultDelimiter
+	}
+	var buf []byte
+	return func(line string, offset uint64) error {
+		buf = strconv.AppendUint(buf[:0], offset, 10)
+		buf = append(buf, '\t')
+		buf = append(buf, line...)
+		buf = append(buf, delim...)
+		_, err := w.Write(buf)
+		return err
+	}
+}
+
+// NewIndexedSortedFile returns a SortedFile of the lines of the file, like NewSortedFile, with the sidecar
+// index written for it by IndexWriter as its sparse index, rather than lines sampled from the file. The
+// index is read into memory, so that each lookup only reads the lines between two of its entries.
+func NewIndexedSortedFile(f *os.File, index io.Reader, opts Options) (*SortedFile, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	s := &SortedFile{r: f, size: info.Size(), delim: []byte(opts.Delimiter)}
+	if opts.Delimiter == "" {
+		s.delim, s.trimCR = []byte(defaultDelimiter), true
+	}
+
+	j := &job{opts: opts}
+	scanner := j.newScanner(index, bufferSize(opts.MergeBufferSize, defaultMergeBufferSize))
+	for scanner.Scan() {
+		entry := scanner.Bytes()
+		tab := bytes.IndexByte(entry, '\t')
+		if tab < 0 {
+			return nil, fmt.Errorf("index entry %d has no tab after its offset", len(s.index)+1)
+		}
+		offset, err := strconv.ParseInt(string(entry[:tab]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("index entry %d has an invalid offset: %w", len(s.index)+1, err)
+		}
+		line := s.trim(entry[tab+1:])
+		if n := len(s.index); offset < 0 || offset >= s.size ||
+			(n > 0 && (offset <= s.index[n-1].offset || bytes.Compare(line, s.index[n-1].line) <= 0)) {
+			return nil, fmt.Errorf("index entry %d at offset %d is not sorted, or not of this file", n+1, offset)
+		}
+		s.index = append(s.index, sortedIndexEntry{line: append([]byte(nil), line...), offset: offset})
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// validateIndex returns an error if the output can not be indexed, because the offsets of its lines are
+// not known as they are written
+func validateIndex(opts Options) error {
+	if opts.OnIndex == nil {
+		return nil
+	}
+	switch {
+	case opts.IndexInterval < 0:
+		return fmt.Errorf("index interval must not be negative")
+	case opts.Format.records():
+		return fmt.Errorf("indexing the output is not supported with the %s format", opts.Format)
+	case opts.Partitions > 1:
+		return fmt.Errorf("indexing the output is not supported with partitions")
+	case opts.PassthroughUnkept:
+		return fmt.Errorf("indexing the output is not supported with passed through lines")
+	}
+	for _, rule := range opts.Rules {
+		if rule.Action == RulePassthrough {
+			return fmt.Errorf("indexing the output is not supported with passed through lines")
+		}
+	}
+	return nil
+}
+
+// indexLine calls OnIndex with the unique line about to be written to the output at the offset, if it
+// is the first of an interval, given how many unique lines were written before it
+func (j *job) indexLine(line []byte, written, offset uint64) error {
+	if j.opts.OnIndex == nil {
+		return nil
+	}
+	interval := uint64(defaultIndexInterval)
+	if j.opts.IndexInterval > 0 {
+		interval = uint64(j.opts.IndexInterval)
+	}
+	if written%interval != 0 {
+		return nil
+	}
+	return j.opts.OnIndex(string(line), offset)
+}
+
+// indexKeys calls OnIndex with the sorted lines that begin an interval, when all lines fit in memory and
+// are written directly to the output
+func (j *job) indexKeys(keys []string) error {
+	if j.opts.OnIndex == nil {
+		return nil
+	}
+	var offset uint64
+	for i, key := range keys {
+		if err := j.indexLine([]byte(key), uint64(i), offset); err != nil {
+			return err
+		}
+		offset += uint64(len(key) + len(j.lineEnding()))
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package dedup
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRunIndex(t *testing.T) {
+	var lines []string
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i*7%100))
+	}
+	input := strings.Join(append(lines, lines[:10]...), "\n") + "\n"
+
+	for _, tc := range []struct {
+		opts   Options
+		ending string
+	}{
+		{opts: Options{TmpFileBytes: 10000}, ending: "\n"},
+		{opts: Options{TmpFileBytes: 1}, ending: "\n"},
+		{opts: Options{TmpFileBytes: 1, CRLF: true}, ending: "\r\n"},
+		{opts: Options{TmpFileBytes: 10000, CRLF: true}, ending: "\r\n"},
+	} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+		var index bytes.Buffer
+		opts := tc.opts
+		opts.IndexInterval = 7
+		opts.OnIndex = IndexWriter(&index, opts)
+		if _, err = Run(outFile, opts, strings.NewReader(input), nil); err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Every 7th line is indexed at the offset it starts at, starting with the first
+		entries := strings.Split(strings.TrimSuffix(index.String(), "\n"), "\n")
+		if len(entries) != 15 {
+			t.Fatalf("Expected 15 index entries with %+v; Got: %q", tc.opts, entries)
+		}
+		written := strings.Split(strings.TrimSuffix(string(content), tc.ending), tc.ending)
+		for i, entry := range entries {
+			var offset int
+			var line string
+			if _, err = fmt.Sscanf(entry, "%d\t", &offset); err != nil {
+				t.Fatal(err)
+			}
+			line = entry[strings.IndexByte(entry, '\t')+1:]
+			if line != written[i*7] || !strings.HasPrefix(string(content[offset:]), line+tc.ending) {
+				t.Fatalf("Unexpected index entry %d with %+v: %q", i, tc.opts, entry)
+			}
+		}
+
+		// The sidecar index is the sparse index of the output
+		s, err := NewIndexedSortedFile(outFile, &index, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s.index) != 15 {
+			t.Fatalf("Expected 15 lines in the sparse index; Got: %d", len(s.index))
+		}
+		for i, line := range written {
+			offset, found, err := s.Lookup(line)
+			if err != nil || !found || !strings.HasPrefix(string(content[offset:]), line+tc.ending) {
+				t.Fatalf("Expected line %d to be found; Got: %d, %t, %v", i, offset, found, err)
+			}
+		}
+		if ok, err := s.Contains("line 50x"); err != nil || ok {
+			t.Fatalf("Expected nothing to be found between lines; Got: %t, %v", ok, err)
+		}
+	}
+
+	// Merge indexes its output too
+	inFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(inFile.Name())
+	defer inFile.Close()
+	if _, err = inFile.WriteString("a\x00b\x00b\x00c\x00"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = inFile.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	outFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+	var index bytes.Buffer
+	opts := Options{IndexInterval: 2, Delimiter: "\x00"}
+	opts.OnIndex = IndexWriter(&index, opts)
+	if _, err = Merge(outFile, opts, inFile); err != nil {
+		t.Fatal(err)
+	}
+	if index.String() != "0\ta\x004\tc\x00" {
+		t.Fatalf("Unexpected index of merge: %q", index.String())
+	}
+
+	// An index must be sorted, and of offsets within the file
+	for _, bad := range []string{"0\tb\n0\ta\n", "0\tb\n2\ta\n", "100\ta\n", "a\n", "x\ta\n"} {
+		if _, err = NewIndexedSortedFile(outFile, strings.NewReader(bad), Options{}); err == nil {
+			t.Fatalf("Expected an error reading the index %q", bad)
+		}
+	}
+
+	// The offsets of lines are not known with partitions or records
+	for _, opts := range []Options{{Partitions: 2}, {Format: FormatCSV}, {PassthroughUnkept: true, KeepPatterns: []*regexp.Regexp{regexp.MustCompile("a")}}} {
+		opts.OnIndex = IndexWriter(&index, opts)
+		if _, err = Run(nil, opts, strings.NewReader("a\n"), nil); err == nil {
+			t.Fatalf("Expected an error indexing with %+v", opts)
+		}
+	}
+}
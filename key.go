@@ -0,0 +1,209 @@
+package dedup
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FieldRange is a range of the fields of a line, counting from 1, such as those cut -f selects
+type FieldRange struct {
+	// From is the first field of the range
+	From int
+
+	// To is the last field of the range, or zero for every field from From on
+	To int
+}
+
+// contains returns true if the field n is in the range
+func (r FieldRange) contains(n int) bool {
+	return n >= r.From && (r.To == 0 || n <= r.To)
+}
+
+// ParseFieldRanges parses a list of fields and ranges of fields, separated by commas, in the form of the
+// list of cut -f, such as "1,3", "2-4", "-2" for the first two fields, or "3-" for the third field on
+func ParseFieldRanges(list string) ([]FieldRange, error) {
+	var ranges []FieldRange
+	for _, part := range strings.Split(list, ",") {
+		from, to := part, part
+		if i := strings.IndexByte(part, '-'); i >= 0 {
+			from, to = part[:i], part[i+1:]
+			if from == "" && to == "" {
+				return nil, fmt.Errorf("invalid field range %q: a range needs a first or last field", part)
+			}
+			if from == "" {
+				from = "1"
+			}
+			if to == "" {
+				to = "0"
+			}
+		}
+		var r FieldRange
+		var err error
+		if r.From, err = strconv.Atoi(from); err != nil || r.From < 1 {
+			return nil, fmt.Errorf("invalid field range %q: fields are numbered from 1", part)
+		}
+		if r.To, err = strconv.Atoi(to); err != nil || r.To < 0 || r.To > 0 && r.To < r.From {
+			return nil, fmt.Errorf("invalid field range %q: fields are numbered from 1", part)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// hasKey returns true if the lines are deduplicated by a key of them, rather than the lines themselves
+func hasKey(opts Options) bool {
+	return opts.Key != nil || (opts.StripANSI && !opts.StripANSIOutput) || opts.StripTimestamp != nil ||
+		len(opts.KeyFields) > 0 || opts.KeyPrefixBytes > 0
+}
+
+// validateKey returns an error if the keys of the lines are invalid
+func validateKey(opts Options) error {
+	if opts.KeyPrefixBytes < 0 {
+		return fmt.Errorf("key prefix bytes must not be negative")
+	}
+	if opts.KeyDelimiter != "" && len(opts.KeyFields) == 0 {
+		return fmt.Errorf("a key delimiter requires key fields")
+	}
+	for _, r := range opts.KeyFields {
+		if r.From < 1 || r.To < 0 || r.To > 0 && r.To < r.From {
+			return fmt.Errorf("invalid key field range %d-%d: fields are numbered from 1", r.From, r.To)
+		}
+	}
+	return nil
+}
+
+// key returns the key the line is deduplicated by: the line without its ANSI escape sequences, if
+// StripANSI, then without the timestamp it begins with, if StripTimestamp, then its KeyFields, then its
+// first KeyPrefixBytes, and then the Key of that. It is the line itself without a key.
+func (j *job) key(line string) string {
+	if j.opts.StripANSI && !j.opts.StripANSIOutput {
+		line = stripANSI(line)
+	}
+	if j.opts.StripTimestamp != nil {
+		line = stripTimestamp(j.opts.StripTimestamp, line)
+	}
+	if len(j.opts.KeyFields) > 0 {
+		delim := j.opts.KeyDelimiter
+		if delim == "" {
+			delim = "\t"
+		}
+		line = selectFields(line, delim, j.opts.KeyFields)
+	}
+	if j.opts.KeyPrefixBytes > 0 && len(line) > j.opts.KeyPrefixBytes {
+		line = line[:j.opts.KeyPrefixBytes]
+	}
+	if j.opts.Key != nil {
+		line = j.opts.Key(line)
+	}
+	return line
+}
+
+// selectFields returns the fields of the line in any of the ranges, in the order of the line, separated
+// by the delimiter, as cut -f does, or the whole line if it has no delimiter
+func selectFields(line, delim string, ranges []FieldRange) string {
+	if !strings.Contains(line, delim) {
+		return line
+	}
+	var key []byte
+	selected := 0
+	for n := 1; ; n++ {
+		field := line
+		end := strings.Index(line, delim)
+		if end >= 0 {
+			field = line[:end]
+		}
+		for _, r := range ranges {
+			if r.contains(n) {
+				if selected > 0 {
+					key = append(key, delim...)
+				}
+				key = append(key, field...)
+				selected++
+				break
+			}
+		}
+		if end < 0 {
+			return string(key)
+		}
+		line = line[end+len(delim):]
+	}
+}
+
+// stripTimestamp returns the line without the timestamp of the pattern it begins with, and the spaces
+// and tabs after it, or the whole line if it does not begin with one
+func stripTimestamp(pattern *regexp.Regexp, line string) string {
+	// The leftmost match is at the start, if any is
+	loc := pattern.FindStringIndex(line)
+	if loc == nil || loc[0] != 0 {
+		return line
+	}
+	return strings.TrimLeft(line[loc[1]:], " \t")
+}
+
+// timestampDirectives are the patterns of the directives of the layouts of TimestampPattern
+var timestampDirectives = map[byte]string{
+	'Y': `\d{4}`,
+	'y': `\d{2}`,
+	'm': `\d{1,2}`,
+	'd': `\d{1,2}`,
+	'e': ` ?\d{1,2}`,
+	'j': `\d{3}`,
+	'H': `\d{1,2}`,
+	'I': `\d{1,2}`,
+	'M': `\d{2}`,
+	'S': `\d{2}(?:[.,]\d+)?`,
+	'f': `\d+`,
+	's': `\d+`,
+	'p': `[AaPp][Mm]`,
+	'b': `[A-Za-z]{3}`,
+	'h': `[A-Za-z]{3}`,
+	'B': `[A-Za-z]+`,
+	'a': `[A-Za-z]{3}`,
+	'A': `[A-Za-z]+`,
+	'z': `(?:Z|[+-]\d{2}:?\d{2})`,
+	'Z': `[A-Za-z]+`,
+	'n': `\s+`,
+	't': `\s+`,
+	'%': `%`,
+}
+
+// timestampShorthands are the directives of the layouts of TimestampPattern that stand for others
+var timestampShorthands = map[byte]string{
+	'T': "%H:%M:%S",
+	'F': "%Y-%m-%d",
+	'D': "%m/%d/%y",
+	'R': "%H:%M",
+}
+
+// TimestampPattern returns the pattern of the timestamps of a layout in the style of strftime and
+// strptime, for StripTimestamp, such as "%Y-%m-%dT%H:%M:%S%z", or "[%d/%b/%Y:%H:%M:%S %z]" for the
+// common log format. The directives are %Y, %y, %m, %d, %e, %j, %H, %I, %M, %S, %f, %s, %p, %b, %h,
+// %B, %a, %A, %z, %Z, %n, %t, %%, and the shorthands %T, %F, %D, and %R. %S also matches a fraction of
+// a second after it, such as ",123" or ".123456", and %z matches "Z". Anything else matches itself.
+func TimestampPattern(layout string) (*regexp.Regexp, error) {
+	original := layout
+	var b strings.Builder
+	b.WriteByte('^')
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' {
+			b.WriteString(regexp.QuoteMeta(layout[i : i+1]))
+			continue
+		}
+		if i++; i == len(layout) {
+			return nil, fmt.Errorf("timestamp layout %q ends with %%", original)
+		}
+		if shorthand, ok := timestampShorthands[layout[i]]; ok {
+			// Read the directives it stands for next
+			layout = layout[:i+1] + shorthand + layout[i+1:]
+			continue
+		}
+		pattern, ok := timestampDirectives[layout[i]]
+		if !ok {
+			return nil, fmt.Errorf("timestamp layout %q has an unknown directive: %%%c", original, layout[i])
+		}
+		b.WriteString(pattern)
+	}
+	return regexp.Compile(b.String())
+}
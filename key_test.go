@@ -0,0 +1,303 @@
+package dedup
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRunKey(t *testing.T) {
+	firstField := func(line string) string {
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			return line[:i]
+		}
+		return line
+	}
+	input := "b 1\na 2\nc\nb 3\na 1\n"
+
+	tests := []struct {
+		writeKeys bool
+		expected  string
+	}{
+		{writeKeys: false, expected: "a 2\nb 1\nc\n"},
+		{writeKeys: true, expected: "a\nb\nc\n"},
+	}
+	for _, tt := range tests {
+		for _, tmpFileBytes := range []uint64{1000, 2} {
+			outFile, err := os.CreateTemp("", "dedup.test.*.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(outFile.Name())
+			defer outFile.Close()
+
+			var dups []string
+			opts := Options{TmpFileBytes: tmpFileBytes, Key: firstField, WriteKeys: tt.writeKeys,
+				OnDuplicate: func(line string) error {
+					dups = append(dups, line)
+					return nil
+				}}
+			stats, err := Run(outFile, opts, strings.NewReader(input), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.LinesUnique != 3 || stats.LinesDuplicate != 2 || stats.BytesOut != uint64(len(tt.expected)) {
+				t.Fatalf("Unexpected stats with write keys %t: %+v", tt.writeKeys, stats)
+			}
+			if len(dups) != 2 || dups[0] != "a" && dups[0] != "b" {
+				t.Fatalf("Unexpected duplicates with write keys %t: %q", tt.writeKeys, dups)
+			}
+			content, err := os.ReadFile(outFile.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.expected {
+				t.Fatalf("Unexpected output with write keys %t and %d tmp file bytes: %q", tt.writeKeys, tmpFileBytes, content)
+			}
+		}
+	}
+}
+
+func TestRunKeyTransformed(t *testing.T) {
+	// The key is of the lines as transformed, and writing keys writes them as such
+	opts := Options{
+		TmpFileBytes: 1000,
+		Transform:    strings.ToLower,
+		Key: func(line string) string {
+			return strings.TrimSuffix(line, "!")
+		},
+		WriteKeys: true,
+	}
+	outFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+	if _, err := Run(outFile, opts, strings.NewReader("B!\na\nb\nA!\n"), nil); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(outFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "a\nb\n" {
+		t.Fatalf("Unexpected output: %q", content)
+	}
+
+	// Writing keys needs no text kept, so sorting chunks is supported
+	paths, err := SortChunks("", opts, strings.NewReader("B!\na\nb\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
+
+func TestTimestampPattern(t *testing.T) {
+	tests := []struct {
+		layout  string
+		line    string
+		matches bool
+	}{
+		{layout: "%Y-%m-%dT%H:%M:%S%z", line: "2024-01-02T03:04:05Z x", matches: true},
+		{layout: "%Y-%m-%dT%H:%M:%S%z", line: "2024-01-02T03:04:05+01:00 x", matches: true},
+		{layout: "%F %T", line: "2024-01-02 03:04:05,123 x", matches: true},
+		{layout: "[%d/%b/%Y:%H:%M:%S %z]", line: "[10/Oct/2000:13:55:36 -0700] GET /", matches: true},
+		{layout: "%b %e %T", line: "Oct  1 13:55:36 host x", matches: true},
+		{layout: "%F %T", line: "x 2024-01-02 03:04:05", matches: false},
+		{layout: "100%% %s", line: "100% 1700000000 x", matches: true},
+	}
+	for _, tt := range tests {
+		pattern, err := TimestampPattern(tt.layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pattern.MatchString(tt.line) != tt.matches {
+			t.Fatalf("Expected %q to match %q: %t, with pattern %s", tt.layout, tt.line, tt.matches, pattern)
+		}
+	}
+	for _, layout := range []string{"%Y-%m-%d %", "%Q"} {
+		if _, err := TimestampPattern(layout); err == nil {
+			t.Fatalf("Expected an error for layout %q", layout)
+		}
+	}
+}
+
+func TestRunStripTimestamp(t *testing.T) {
+	pattern, err := TimestampPattern("%F %T")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := "2024-01-02 03:04:06 b\n2024-01-02 03:04:05 a\n2024-01-02 03:04:07  b\nno timestamp\n2024-01-02 03:04:01 a\n"
+
+	for _, tmpFileBytes := range []uint64{1000, 2} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		// The first line read of each is written, sorted by what is left of it
+		opts := Options{TmpFileBytes: tmpFileBytes, StripTimestamp: pattern}
+		stats, err := Run(outFile, opts, strings.NewReader(input), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 3 || stats.LinesDuplicate != 2 {
+			t.Fatalf("Unexpected stats: %+v", stats)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "2024-01-02 03:04:05 a\n2024-01-02 03:04:06 b\nno timestamp\n"
+		if string(content) != expected {
+			t.Fatalf("Unexpected output with %d tmp file bytes: %q", tmpFileBytes, content)
+		}
+	}
+}
+
+func TestRunKeyPrefixBytes(t *testing.T) {
+	input := "0002,b\n0001,a\n0002,c\n01\n0001,d\n"
+	for _, tmpFileBytes := range []uint64{1000, 2} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		// Lines shorter than the prefix are deduplicated whole
+		stats, err := Run(outFile, Options{TmpFileBytes: tmpFileBytes, KeyPrefixBytes: 4}, strings.NewReader(input), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 3 || stats.LinesDuplicate != 2 {
+			t.Fatalf("Unexpected stats: %+v", stats)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "0001,a\n0002,b\n01\n" {
+			t.Fatalf("Unexpected output with %d tmp file bytes: %q", tmpFileBytes, content)
+		}
+	}
+	if _, err := Run(nil, Options{KeyPrefixBytes: -1}, strings.NewReader("a\n"), nil); err == nil {
+		t.Fatal("Expected an error with negative key prefix bytes")
+	}
+}
+
+func TestParseFieldRanges(t *testing.T) {
+	ranges, err := ParseFieldRanges("1,3-4,-2,5-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []FieldRange{{From: 1, To: 1}, {From: 3, To: 4}, {From: 1, To: 2}, {From: 5}}
+	if len(ranges) != len(expected) {
+		t.Fatalf("Unexpected ranges: %+v", ranges)
+	}
+	for i := range ranges {
+		if ranges[i] != expected[i] {
+			t.Fatalf("Unexpected ranges: %+v", ranges)
+		}
+	}
+	for _, list := range []string{"", "0", "a", "3-2", "1,", "-"} {
+		if _, err := ParseFieldRanges(list); err == nil {
+			t.Fatalf("Expected an error for %q", list)
+		}
+	}
+}
+
+func TestSelectFields(t *testing.T) {
+	tests := []struct {
+		line     string
+		ranges   []FieldRange
+		expected string
+	}{
+		{line: "a\tb\tc\td", ranges: []FieldRange{{From: 3, To: 3}, {From: 1, To: 1}}, expected: "a\tc"},
+		{line: "a\tb\tc\td", ranges: []FieldRange{{From: 2}}, expected: "b\tc\td"},
+		{line: "a\tb", ranges: []FieldRange{{From: 3, To: 3}}, expected: ""},
+		{line: "no fields", ranges: []FieldRange{{From: 2, To: 2}}, expected: "no fields"},
+		{line: "a\t\tc", ranges: []FieldRange{{From: 2, To: 3}}, expected: "\tc"},
+	}
+	for _, tt := range tests {
+		if key := selectFields(tt.line, "\t", tt.ranges); key != tt.expected {
+			t.Fatalf("Expected fields %+v of %q to be %q, got %q", tt.ranges, tt.line, tt.expected, key)
+		}
+	}
+}
+
+func TestRunKeyFields(t *testing.T) {
+	input := "b,1,x\na,2,y\nb,3,x\na,4,z\n"
+	for _, tmpFileBytes := range []uint64{1000, 2} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		opts := Options{TmpFileBytes: tmpFileBytes, KeyFields: []FieldRange{{From: 1, To: 1}, {From: 3, To: 3}}, KeyDelimiter: ","}
+		stats, err := Run(outFile, opts, strings.NewReader(input), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 3 || stats.LinesDuplicate != 1 {
+			t.Fatalf("Unexpected stats: %+v", stats)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "a,2,y\na,4,z\nb,1,x\n" {
+			t.Fatalf("Unexpected output with %d tmp file bytes: %q", tmpFileBytes, content)
+		}
+	}
+	if _, err := Run(nil, Options{KeyDelimiter: ","}, strings.NewReader("a\n"), nil); err == nil {
+		t.Fatal("Expected an error with a key delimiter without key fields")
+	}
+}
+
+func TestRunPatternsMatchKeys(t *testing.T) {
+	input := "a,/api/x\nb,/web/y\n/api/z,c\nd,/api/x\n"
+	opts := Options{
+		TmpFileBytes:      1000,
+		KeyFields:         []FieldRange{{From: 2, To: 2}},
+		KeyDelimiter:      ",",
+		KeepPatterns:      []*regexp.Regexp{regexp.MustCompile(`^/api/`)},
+		PassthroughUnkept: true,
+	}
+	tests := []struct {
+		patternsMatchKeys bool
+		expected          string
+	}{
+		// The lines as read all begin with their first field
+		{patternsMatchKeys: false, expected: "/api/z,c\na,/api/x\nb,/web/y\nd,/api/x\n"},
+		{patternsMatchKeys: true, expected: "a,/api/x\nb,/web/y\n/api/z,c\n"},
+	}
+	for _, tt := range tests {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		opts.PatternsMatchKeys = tt.patternsMatchKeys
+		if _, err := Run(outFile, opts, strings.NewReader(input), nil); err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != tt.expected {
+			t.Fatalf("Unexpected output with patterns matching keys %t: %q", tt.patternsMatchKeys, content)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package dedup
+
+import (
+	"bytes"
+	"io"
+)
+
+// defaultLinesBytes is the TmpFileBytes of RunLines without TmpFileBytes or MemoryBytes, the same as the
+// default of the tmp-file-bytes flag of the command
+const defaultLinesBytes = 250000000
+
+// RunLines deduplicates and sorts the input as Run does, but rather than write the unique lines to an
+// output file, it calls onLine with each of them in the order they would have been written, without
+// their line ending, for programs that work with the lines themselves rather than read them back from
+// a file. While the distinct lines fit in TmpFileBytes, nothing is written to disk. Beyond it, they are
+// spilled to temporary files and merged as usual. Without TmpFileBytes or MemoryBytes, TmpFileBytes
+// defaults to 250 MB. An error returned by onLine stops the run. The lines are always of FormatText,
+// and CRLF, NoFinalLineEnding, DryRun, CountOnly, VerifyWrites, PreallocateOutput, and OutputMmap are
+// ignored. BytesOut counts the lines as they would have been written.
+func RunLines(opts Options, inFile io.Reader, onLine func(line string) error) (Stats, error) {
+	opts.Format = FormatText
+	opts.CRLF = false
+	opts.NoFinalLineEnding = false
+	opts.DryRun = false
+	opts.CountOnly = false
+	opts.VerifyWrites = false
+	opts.PreallocateOutput = false
+	opts.OutputMmap = false
+	if opts.TmpFileBytes == 0 && opts.MemoryBytes == 0 {
+		opts.TmpFileBytes = defaultLinesBytes
+	}
+	if err := checkRun(opts); err != nil {
+		return Stats{}, err
+	}
+
+	j, ctx, done := newJob(opts, opts.TempDir)
+	defer done()
+	j.startTrace("dedup.run_lines")
+
+	out := &lineWriter{ending: []byte(j.lineEnding()), onLine: onLine}
+	err := j.dedup(ctx, j.limitWriter(out), inFile, nil)
+	if err == nil {
+		err = out.finish()
+	}
+	err = j.finishSeen(err)
+	return j.summarize(err), err
+}
+
+// Lines returns the unique lines of the input, in the order RunLines calls onLine with them, for inputs
+// whose unique lines fit in memory
+func Lines(opts Options, inFile io.Reader) ([]string, Stats, error) {
+	var lines []string
+	stats, err := RunLines(opts, inFile, func(line string) error {
+		lines = append(lines, line)
+		return nil
+	})
+	return lines, stats, err
+}
+
+// lineWriter splits what is written to it back into the lines of the output, and calls onLine with each
+type lineWriter struct {
+	ending []byte
+	onLine func(line string) error
+	buf    []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	start := 0
+	for {
+		i := bytes.Index(w.buf[start:], w.ending)
+		if i < 0 {
+			break
+		}
+		if err := w.onLine(string(w.buf[start : start+i])); err != nil {
+			return 0, err
+		}
+		start += i + len(w.ending)
+	}
+	w.buf = w.buf[:copy(w.buf, w.buf[start:])]
+	return len(p), nil
+}
+
+// finish calls onLine with the last line, if it was not ended
+func (w *lineWriter) finish() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := string(w.buf)
+	w.buf = w.buf[:0]
+	return w.onLine(line)
+}
@@ -0,0 +1,65 @@
+package dedup
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	input := "c\na\r\nb\na\n\nc"
+	for _, tmpFileBytes := range []uint64{1000, 2} {
+		lines, stats, err := Lines(Options{TmpFileBytes: tmpFileBytes, CRLF: true}, strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := []string{"", "a", "b", "c"}; !reflect.DeepEqual(lines, expected) {
+			t.Fatalf("Expected lines %q with %d tmp file bytes, got %q", expected, tmpFileBytes, lines)
+		}
+		if stats.LinesUnique != 4 || stats.LinesDuplicate != 2 {
+			t.Fatalf("Unexpected stats with %d tmp file bytes: %+v", tmpFileBytes, stats)
+		}
+		// Only a run whose lines do not fit in memory writes temporary files
+		if spilled := stats.TmpBytes > 0; spilled != (tmpFileBytes == 2) {
+			t.Fatalf("Expected spilled to be %t with %d tmp file bytes, got %d tmp bytes", !spilled, tmpFileBytes, stats.TmpBytes)
+		}
+	}
+}
+
+func TestLinesDefaultBudget(t *testing.T) {
+	// Without TmpFileBytes or MemoryBytes, the lines are still held in memory rather than spilled
+	lines, stats, err := Lines(Options{}, strings.NewReader("c\na\nb\na\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Expected lines %q, got %q", expected, lines)
+	}
+	if stats.Chunks != 0 || stats.TmpBytes != 0 {
+		t.Fatalf("Expected no temporary files: %+v", stats)
+	}
+}
+
+func TestRunLines(t *testing.T) {
+	var lines []string
+	_, err := RunLines(Options{Delimiter: "|"}, strings.NewReader("b|a\nx|b|"), func(line string) error {
+		lines = append(lines, line)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a\nx", "b"}; !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Expected lines %q, got %q", expected, lines)
+	}
+
+	// An error of onLine stops the run
+	errStop := errors.New("stop")
+	_, err = RunLines(Options{}, strings.NewReader("a\nb\n"), func(line string) error {
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("Expected the error of onLine, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package dedup
+
+// Logger is a structured logger, such as a *slog.Logger, that the events and progress of a run are
+// logged to with the Logger option. The args of each record are alternating keys and values.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+}
+
+// logEvent returns an event reporter logging each event with its attributes. Warnings are logged as
+// warnings, and the details of a phase, such as each temporary file written, at debug level.
+func logEvent(logger Logger) func(Event) {
+	return func(e Event) {
+		args := []interface{}{"event", string(e.Kind), "phase", string(e.Phase)}
+		if e.File != "" {
+			args = append(args, "file", e.File)
+		}
+		if e.Lines != 0 {
+			args = append(args, "lines", e.Lines)
+		}
+		if e.Bytes != 0 {
+			args = append(args, "bytes", e.Bytes)
+		}
+		if e.Duration != 0 {
+			args = append(args, "duration", e.Duration)
+		}
+		switch {
+		case e.Kind == EventWarning:
+			if e.Err != nil {
+				args = append(args, "error", e.Err.Error())
+			}
+			logger.Warn(e.Message, args...)
+		case e.Detail():
+			logger.Debug(e.Message, args...)
+		default:
+			logger.Info(e.Message, args...)
+		}
+	}
+}
+
+// logProgress returns a progress reporter logging the progress of each phase that is in progress,
+// with its totals and percent done, if known
+func logProgress(logger Logger) func(Progress) {
+	return func(p Progress) {
+		if p.Done {
+			// Phase transitions are logged as events
+			return
+		}
+		args := []interface{}{"phase", string(p.Phase), "lines", p.Lines, "bytes", p.Bytes, "elapsed", p.Elapsed}
+		if p.TotalLines > 0 {
+			args = append(args, "total_lines", p.TotalLines)
+		}
+		if p.TotalBytes > 0 {
+			args = append(args, "total_bytes", p.TotalBytes)
+		}
+		if pct, ok := p.Percent(); ok {
+			args = append(args, "percent", pct)
+		}
+		logger.Info("Progress", args...)
+	}
+}
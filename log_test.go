@@ -0,0 +1,79 @@
+package dedup
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// testRecord is a record logged to testLogger
+type testRecord struct {
+	level string
+	msg   string
+	attrs map[string]interface{}
+}
+
+// testLogger records what is logged to it, with the same methods as a *slog.Logger
+type testLogger struct {
+	records []testRecord
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) { l.log("debug", msg, args) }
+func (l *testLogger) Info(msg string, args ...interface{})  { l.log("info", msg, args) }
+func (l *testLogger) Warn(msg string, args ...interface{})  { l.log("warn", msg, args) }
+
+func (l *testLogger) log(level, msg string, args []interface{}) {
+	attrs := make(map[string]interface{})
+	for i := 0; i+1 < len(args); i += 2 {
+		attrs[args[i].(string)] = args[i+1]
+	}
+	l.records = append(l.records, testRecord{level: level, msg: msg, attrs: attrs})
+}
+
+func TestRunLogger(t *testing.T) {
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+
+	logger := &testLogger{}
+	opts := Options{
+		TmpFileBytes: 20 * 50,
+		DryRun:       true,
+		Logger:       logger,
+		OnProgress:   func(Progress) {},
+	}
+	stats, err := Run(nil, opts, inFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Phases are logged as info, and the temporary files as debug as they are created, sorted, written, and merged,
+	// with their attributes
+	levels := make(map[string]int)
+	for _, r := range logger.records {
+		levels[r.level]++
+		if r.msg == "" || r.attrs["event"] == nil || r.attrs["phase"] == nil {
+			t.Fatalf("Unexpected record: %+v", r)
+		}
+		if r.attrs["event"] == string(EventPhaseFinished) && r.attrs["phase"] == string(PhaseSplitting) && r.attrs["lines"] != uint64(204) {
+			t.Fatalf("Unexpected splitting record: %+v", r)
+		}
+	}
+	if levels["info"] != 4 || levels["debug"] != 4*stats.Chunks || levels["warn"] != 0 {
+		t.Fatalf("Unexpected levels logged for %d chunks: %v", stats.Chunks, levels)
+	}
+
+	// Warnings are logged with their error, and progress with its totals
+	logger = &testLogger{}
+	logEvent(logger)(Event{Kind: EventWarning, Phase: PhaseMerging, Message: "Could not remove", Err: errors.New("busy")})
+	logProgress(logger)(Progress{Phase: PhaseSplitting, Lines: 25, TotalLines: 100})
+	logProgress(logger)(Progress{Phase: PhaseSplitting, Lines: 100, TotalLines: 100, Done: true})
+	if len(logger.records) != 2 || logger.records[0].level != "warn" || logger.records[0].attrs["error"] != "busy" {
+		t.Fatalf("Unexpected records: %+v", logger.records)
+	}
+	if progress := logger.records[1]; progress.level != "info" || progress.attrs["total_lines"] != uint64(100) || progress.attrs["percent"] != float64(25) {
+		t.Fatalf("Unexpected progress record: %+v", progress)
+	}
+}
@@ -0,0 +1,32 @@
+package dedup
+
+// longestLine returns the byte length of the longest of the lines
+func longestLine(lines []string) int {
+	longest := 0
+	for _, line := range lines {
+		if len(line) > longest {
+			longest = len(line)
+		}
+	}
+	return longest
+}
+
+// addChunkLongest records the length of the longest line written to a chunk file, so that the chunk
+// is read back while merging with a buffer that can hold it, even if it is longer than the lines of
+// the input could be, such as once a rule has transformed it
+func (j *job) addChunkLongest(name string, longest int) {
+	if j.chunkLongest == nil {
+		j.chunkLongest = make(map[string]int)
+	}
+	j.chunkLongest[name] = longest
+}
+
+// mergeLineLength returns the most bytes a line of a chunk file can have when it is merged: the
+// maximum line length, or the longest line written to it with its delimiter, if that is longer
+func (j *job) mergeLineLength(name string) int {
+	max := j.maxLineLength()
+	if longest, ok := j.chunkLongest[name]; ok && longest+len(j.delim)+1 > max {
+		max = longest + len(j.delim) + 1
+	}
+	return max
+}
@@ -0,0 +1,47 @@
+package dedup
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRunTransformLongerThanMaxLine(t *testing.T) {
+	// The transformed lines are longer than any line of the input could be, so the chunks they are
+	// spilled to can only be merged if read back with a buffer sized from their longest line
+	padding := strings.Repeat("x", 2*defaultBufferSize)
+	rules := []Rule{{Pattern: regexp.MustCompile(`^(.*)$`), Action: RuleTransform, Replacement: "${1}" + padding}}
+	input := "c\na\nb\na\nc\n"
+	expected := "a" + padding + "\nb" + padding + "\nc" + padding + "\n"
+
+	for _, partitions := range []int{0, 2} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		opts := Options{TmpFileBytes: 1, Rules: rules, Partitions: partitions}
+		stats, err := Run(outFile, opts, strings.NewReader(input), nil)
+		if err != nil {
+			t.Fatalf("Unexpected error with %d partitions: %v", partitions, err)
+		}
+		if stats.Chunks < 2 || stats.LinesUnique != 3 {
+			t.Fatalf("Unexpected stats with %d partitions: %+v", partitions, stats)
+		}
+
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Hash partitions are each sorted, but not in order of one another
+		lines := strings.SplitAfter(string(content), "\n")
+		sort.Strings(lines)
+		if strings.Join(lines, "") != expected {
+			t.Fatalf("Unexpected output with %d partitions of %d bytes", partitions, len(content))
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// sortedFileBlock is how many bytes of a sorted file are read at a time while looking for a line
+const sortedFileBlock = 4096
+
+// sortedFileIndexEntries is how many lines of a sorted file are sampled into its sparse index at most
+const sortedFileIndexEntries = 1024
+
+// SortedFile answers whether lines are in a sorted and deduplicated file, such as the output of Run,
+// by binary search over the bytes of the file, so that a file of terabytes can be queried without
+// reading it into memory. Each query reads a few blocks for each halving of the file, which the page
+// cache of the system mostly holds after the first queries. The first halvings are done in memory,
+// with a sparse index of lines sampled evenly from the file once opened. It is safe for concurrent use.
+//
+// The file must be sorted by the bytes of its lines, which output written with hash partitions and
+// JSON output are not.
+type SortedFile struct {
+	r      io.ReaderAt
+	size   int64
+	delim  []byte
+	trimCR bool
+	index  []sortedIndexEntry
+}
+
+// sortedIndexEntry is a line of a sorted file in its sparse index, with the offset it starts at
+type sortedIndexEntry struct {
+	line   []byte
+	offset int64
+}
+
+// NewSortedFile returns a SortedFile of the lines of the file, split by the Delimiter of the options.
+// Without one, lines are split by new lines, and a carriage return before them is not part of the line.
+// The file is not closed by it, and must not change while it is queried. A line is sampled into its
+// sparse index for every few blocks of the file, up to a thousand or so lines.
+func NewSortedFile(f *os.File, opts Options) (*SortedFile, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	s := &SortedFile{r: f, size: info.Size(), delim: []byte(opts.Delimiter)}
+	if opts.Delimiter == "" {
+		s.delim, s.trimCR = []byte("\n"), true
+	}
+	if err = s.buildIndex(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// buildIndex samples the lines starting after evenly spaced offsets of the file into its sparse index,
+// with no more than one for each few blocks, so that a small file has none
+func (s *SortedFile) buildIndex() error {
+	entries := s.size / (4 * sortedFileBlock)
+	if entries > sortedFileIndexEntries {
+		entries = sortedFileIndexEntries
+	}
+	for i := int64(1); i <= entries; i++ {
+		start, err := s.lineStart(i*s.size/(entries+1), s.size)
+		if err != nil {
+			return err
+		}
+		if start >= s.size || (len(s.index) > 0 && start <= s.index[len(s.index)-1].offset) {
+			continue
+		}
+		line, _, err := s.lineAt(start)
+		if err != nil {
+			return err
+		}
+		s.index = append(s.index, sortedIndexEntry{line: append([]byte(nil), line...), offset: start})
+	}
+	return nil
+}
+
+// Contains returns true if the line is one of the lines of the file
+func (s *SortedFile) Contains(line string) (bool, error) {
+	_, found, err := s.Lookup(line)
+	return found, err
+}
+
+// Lookup returns the offset in the file of the line, and true, if it is one of the lines of the file.
+// Otherwise it returns the offset of the first line after it, or the size of the file if there is none,
+// and false. Reading the file from the offset returned reads the lines from the line on, in order.
+func (s *SortedFile) Lookup(line string) (int64, bool, error) {
+	target := []byte(line)
+
+	// Every line starting before lo is less than the line, and every line starting at or after hi
+	// is greater or equal, so the first line not less than it starts in between. The sparse index
+	// narrows them down without reading the file.
+	lo, hi := int64(0), s.size
+	i := sort.Search(len(s.index), func(i int) bool { return bytes.Compare(s.index[i].line, target) >= 0 })
+	if i < len(s.index) {
+		if bytes.Equal(s.index[i].line, target) {
+			return s.index[i].offset, true, nil
+		}
+		hi = s.index[i].offset
+	}
+	if i > 0 {
+		lo = s.index[i-1].offset
+	}
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, err := s.lineStart(mid, hi)
+		if err != nil {
+			return 0, false, err
+		}
+		if start >= hi {
+			// No line starts between mid and hi
+			hi = mid
+			continue
+		}
+		token, next, err := s.lineAt(start)
+		if err != nil {
+			return 0, false, err
+		}
+		switch c := bytes.Compare(token, target); {
+		case c == 0:
+			return start, true, nil
+		case c < 0:
+			lo = next
+		default:
+			hi = mid
+		}
+	}
+
+	// The first line not less than the line is the first starting at or after where the search ended
+	start, err := s.lineStart(lo, s.size)
+	return start, false, err
+}
+
+// lineStart returns the offset of the first line starting at or after the offset, or limit if none
+// starts before it
+func (s *SortedFile) lineStart(offset, limit int64) (int64, error) {
+	if offset == 0 {
+		return 0, nil
+	}
+
+	// A delimiter ending at or after the offset may start before it
+	pos := offset - int64(len(s.delim))
+	if pos < 0 {
+		pos = 0
+	}
+	buf := make([]byte, sortedFileBlock+len(s.delim))
+	for pos < limit {
+		n, err := s.r.ReadAt(buf, pos)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.Index(buf[:n], s.delim); i >= 0 {
+			return pos + int64(i+len(s.delim)), nil
+		}
+		if err == io.EOF || n <= len(s.delim) {
+			return limit, nil
+		}
+		// The next block overlaps this one by the delimiter, less a byte, in case one is split between them
+		pos += int64(n - len(s.delim) + 1)
+	}
+	return limit, nil
+}
+
+// lineAt returns the line starting at the offset, and the offset of the line after it
+func (s *SortedFile) lineAt(offset int64) ([]byte, int64, error) {
+	var line []byte
+	buf := make([]byte, sortedFileBlock)
+	pos := offset
+	for {
+		n, err := s.r.ReadAt(buf, pos)
+		if err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		line = append(line, buf[:n]...)
+		pos += int64(n)
+
+		// The delimiter may have been split between this block and the last
+		from := len(line) - n - len(s.delim) + 1
+		if from < 0 {
+			from = 0
+		}
+		if i := bytes.Index(line[from:], s.delim); i >= 0 {
+			end := from + i
+			return s.trim(line[:end]), offset + int64(end+len(s.delim)), nil
+		}
+		if err == io.EOF || n == 0 {
+			// The final line does not need to end with a delimiter
+			return s.trim(line), s.size, nil
+		}
+	}
+}
+
+// trim removes the carriage return at the end of a line split by new lines, if any
+func (s *SortedFile) trim(line []byte) []byte {
+	if s.trimCR && len(line) > 0 && line[len(line)-1] == '\r' {
+		return line[:len(line)-1]
+	}
+	return line
+}
+
+// ScanPrefix calls fn with every line of the file that starts with the prefix, in order, reading the file
+// from the first of them, found by Lookup, until a line does not. An empty prefix matches every line.
+// If fn returns an error, it stops with it.
+func (s *SortedFile) ScanPrefix(prefix string, fn func(line string) error) error {
+	offset, _, err := s.Lookup(prefix)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(io.NewSectionReader(s.r, offset, s.size-offset))
+	maxLine := s.size - offset + 1
+	if maxLine > math.MaxInt32 {
+		maxLine = math.MaxInt32
+	}
+	scanner.Buffer(make([]byte, 0, sortedFileBlock), int(maxLine))
+	scanner.Split(splitOn(string(s.delim)))
+	target := []byte(prefix)
+	for scanner.Scan() {
+		line := s.trim(scanner.Bytes())
+		if !bytes.HasPrefix(line, target) {
+			return nil
+		}
+		if err = fn(string(line)); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
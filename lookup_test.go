@@ -0,0 +1,140 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSortedFileContains(t *testing.T) {
+	// Lines longer than a block are read across blocks, and so are delimiters split between them
+	long := strings.Repeat("l", 3*sortedFileBlock)
+	lines := []string{"", "a", "b", "ba", "c", long, long + "x", "m", "z"}
+	for i := 0; i < 200; i++ {
+		lines = append(lines, fmt.Sprintf("k%04d", i*2))
+	}
+	sort.Strings(lines)
+	absent := []string{"0", "aa", "bb", "d", long[1:], "k0001", "k0399", "k9999", "zz", "\r"}
+
+	for _, tc := range []struct {
+		delimiter string
+		ending    string
+		final     bool
+	}{
+		{delimiter: "", ending: "\n", final: true},
+		{delimiter: "", ending: "\n", final: false},
+		{delimiter: "", ending: "\r\n", final: true},
+		{delimiter: "\x00", ending: "\x00", final: true},
+		{delimiter: "<sep>", ending: "<sep>", final: false},
+	} {
+		content := strings.Join(lines, tc.ending)
+		if tc.final {
+			content += tc.ending
+		}
+		f, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		if _, err = f.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := NewSortedFile(f, Options{Delimiter: tc.delimiter})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range lines {
+			if ok, err := s.Contains(line); err != nil || !ok {
+				t.Fatalf("Expected %.10q to be found with the ending %q; Got: %t, %v", line, tc.ending, ok, err)
+			}
+		}
+		for _, line := range absent {
+			if ok, err := s.Contains(line); err != nil || ok {
+				t.Fatalf("Expected %.10q not to be found with the ending %q; Got: %t, %v", line, tc.ending, ok, err)
+			}
+		}
+
+		// The lines with a prefix are read in order from the first of them
+		for _, prefix := range []string{"", "k01", "b", "l", "zz"} {
+			var expected, scanned []string
+			for _, line := range lines {
+				if strings.HasPrefix(line, prefix) {
+					expected = append(expected, line)
+				}
+			}
+			if err = s.ScanPrefix(prefix, func(line string) error {
+				scanned = append(scanned, line)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(scanned, "|") != strings.Join(expected, "|") {
+				t.Fatalf("Unexpected lines with the prefix %q and the ending %q: %.100q", prefix, tc.ending, scanned)
+			}
+		}
+	}
+
+	// Nothing is in an empty file
+	f, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	s, err := NewSortedFile(f, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.Contains(""); err != nil || ok {
+		t.Fatalf("Expected nothing to be found in an empty file; Got: %t, %v", ok, err)
+	}
+}
+
+func TestSortedFileLookup(t *testing.T) {
+	// Every line is 8 bytes, so the offset of a line is 8 times its index
+	var b strings.Builder
+	const lines = 100000
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&b, "k%06d\n", i*2)
+	}
+	f, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err = f.WriteString(b.String()); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSortedFile(f, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.index) == 0 || len(s.index) > sortedFileIndexEntries {
+		t.Fatalf("Unexpected sparse index of %d lines", len(s.index))
+	}
+
+	// Lines in the sparse index are found without reading the file, and those in between by reading it
+	for _, entry := range s.index {
+		if entry.offset%8 != 0 || string(entry.line) != fmt.Sprintf("k%06d", entry.offset/8*2) {
+			t.Fatalf("Unexpected sparse index entry %q at %d", entry.line, entry.offset)
+		}
+	}
+	for i := 0; i < lines; i += 7 {
+		if offset, found, err := s.Lookup(fmt.Sprintf("k%06d", i*2)); err != nil || !found || offset != int64(i*8) {
+			t.Fatalf("Expected line %d to be found at %d; Got: %d, %t, %v", i, i*8, offset, found, err)
+		}
+		if offset, found, err := s.Lookup(fmt.Sprintf("k%06d", i*2+1)); err != nil || found || offset != int64(i*8+8) {
+			t.Fatalf("Expected the line after line %d to start at %d; Got: %d, %t, %v", i, i*8+8, offset, found, err)
+		}
+	}
+	for line, expected := range map[string]int64{"": 0, "a": 0, "k": 0, "k0000000": 8, "z": lines * 8} {
+		if offset, found, err := s.Lookup(line); err != nil || found || offset != expected {
+			t.Fatalf("Expected %q to be before %d; Got: %d, %t, %v", line, expected, offset, found, err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package dedup
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// mapping returns true if every line read is being mapped to its line in the output, for OnLineMapped
+func (j *job) mapping() bool {
+	return j.opts.OnLineMapped != nil
+}
+
+// mapLine calls OnLineMapped for the line at the ordinal, with its line number in the output,
+// or zero if it was removed
+func (j *job) mapLine(ordinal, outputLine uint64) error {
+	if !j.mapping() {
+		return nil
+	}
+	var pos Position
+	pos.Source, pos.Line = j.position(ordinal)
+	return j.opts.OnLineMapped(pos, outputLine)
+}
+
+// mapRemoved maps the first occurrences of the lines that were dropped by the Limit as removed,
+// from what is known about them in the set of their chunk
+func (j *job) mapRemoved(meta map[string]lineMeta, keys []string) error {
+	if !j.mapping() {
+		return nil
+	}
+	for _, key := range keys {
+		if first := meta[key].first; first > 0 {
+			if err := j.mapLine(first-1, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mapWritten maps the first occurrence of each of the sorted lines written directly to the output
+func (j *job) mapWritten(keys []string) error {
+	if !j.mapping() {
+		return nil
+	}
+	for i, key := range keys {
+		if first := j.meta[key].first; first > 0 {
+			if err := j.mapLine(first-1, uint64(i)+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mapMerged maps the first occurrence of the line of each scanner with the same token as the first
+// scanner of the heap: either to the output line, if it is the retained first occurrence, or as removed.
+func (j *job) mapMerged(h scannerHeap, retained, outputLine uint64) error {
+	if !j.mapping() {
+		return nil
+	}
+	return h.leading(func(ss *sortableScanner) error {
+		if ss.first == 0 {
+			return nil
+		}
+		target := outputLine
+		if ss.first != retained {
+			target = 0
+		}
+		ss.lineMapped = true
+		return j.mapLine(ss.first-1, target)
+	})
+}
+
+// mapDuplicate maps the first occurrence of the line of the scanner as removed, unless it was already
+// mapped along with the retained line. Only the intermediate files of a cascaded merge have the same
+// line more than once, and only the first of them is at the top of the heap along with the others.
+func (j *job) mapDuplicate(ss *sortableScanner) error {
+	if !j.mapping() || ss.lineMapped || ss.first == 0 {
+		return nil
+	}
+	ss.lineMapped = true
+	return j.mapLine(ss.first-1, 0)
+}
+
+// mapRemaining maps the first occurrences of every line left in the scanners as removed,
+// once the Limit has been reached
+func (j *job) mapRemaining(h scannerHeap) error {
+	if !j.mapping() {
+		return nil
+	}
+	for _, ss := range h {
+		for ok := true; ok; {
+			if ss.first > 0 {
+				if err := j.mapLine(ss.first-1, 0); err != nil {
+					return err
+				}
+			}
+			var err error
+			if ok, err = ss.next(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// passthroughOrdinals holds the ordinals of the lines that were passed through, in a temporary file,
+// so that they can be mapped to the end of the output
+type passthroughOrdinals struct {
+	f      *os.File
+	writer *bufio.Writer
+	buf    []byte
+}
+
+// addPassthroughOrdinal records the ordinal of a line that was passed through, if mapping
+func (j *job) addPassthroughOrdinal(ordinal uint64) error {
+	if !j.mapping() {
+		return nil
+	}
+	if j.passedOrdinals == nil {
+		f, err := CreateTemp(j.dir, "passthrough.*.meta")
+		if err != nil {
+			return err
+		}
+		j.passedOrdinals = &passthroughOrdinals{f: f, writer: bufio.NewWriter(f), buf: make([]byte, binary.MaxVarintLen64)}
+	}
+	p := j.passedOrdinals
+	n := binary.PutUvarint(p.buf, ordinal)
+	_, err := p.writer.Write(p.buf[:n])
+	return err
+}
+
+// mapPassthrough maps the lines that were passed through to the lines after the deduplicated lines
+func (j *job) mapPassthrough() error {
+	p := j.passedOrdinals
+	if p == nil {
+		return nil
+	}
+	if err := p.writer.Flush(); err != nil {
+		return err
+	}
+	if _, err := p.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(p.f)
+	outputLine := j.stats.LinesUnique
+	for {
+		ordinal, err := binary.ReadUvarint(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		outputLine++
+		if err = j.mapLine(ordinal, outputLine); err != nil {
+			return err
+		}
+	}
+}
+
+// removePassthroughOrdinals closes and removes the passthrough ordinals file, if there is one
+func (j *job) removePassthroughOrdinals() error {
+	var c cleanup
+	if j.passedOrdinals != nil {
+		c.close(j.passedOrdinals.f)
+		c.remove(j.passedOrdinals.f.Name())
+	}
+	return c.err()
+}
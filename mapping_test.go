@@ -0,0 +1,51 @@
+package dedup
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRunOnLineMapped(t *testing.T) {
+	tests := []struct {
+		limit    uint64
+		expected []string
+	}{
+		// Output: a, c, d, then the passed through #x
+		{expected: []string{"one:1 2", "one:2 1", "one:3 0", "two:1 0", "two:2 0", "two:3 4", "two:4 0", "two:5 3"}},
+		// Output: a, then the passed through #x
+		{limit: 1, expected: []string{"one:1 0", "one:2 1", "one:3 0", "two:1 0", "two:2 0", "two:3 2", "two:4 0", "two:5 0"}},
+	}
+	for _, tt := range tests {
+		// Whether everything fits in memory or not, every line read is mapped once
+		for _, tmpFileBytes := range []uint64{1000, 4, 1} {
+			var mapped []string
+			input := NewSources(
+				Source{Name: "one", Reader: strings.NewReader("c\na\nskip\n")},
+				Source{Name: "two", Reader: strings.NewReader("a\nc\n#x\nskip\nd\n")},
+			)
+			opts := Options{
+				TmpFileBytes: tmpFileBytes,
+				Limit:        tt.limit,
+				DryRun:       true,
+				SkipPatterns: []*regexp.Regexp{regexp.MustCompile(`^skip$`)},
+				Rules:        []Rule{{Pattern: regexp.MustCompile(`^#`), Action: RulePassthrough}},
+				OnLineMapped: func(pos Position, outputLine uint64) error {
+					mapped = append(mapped, fmt.Sprintf("%s:%d %d", pos.Source, pos.Line, outputLine))
+					return nil
+				},
+			}
+			if _, err := Run(nil, opts, input, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			sort.Strings(mapped)
+			if !reflect.DeepEqual(mapped, tt.expected) {
+				t.Fatalf("Unexpected mapping with limit %d and %d tmp file bytes: %q", tt.limit, tmpFileBytes, mapped)
+			}
+		}
+	}
+}
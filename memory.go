@@ -0,0 +1,229 @@
+package dedup
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// memoryChecksPerBudget is how many times the memory of the process is read while the lines of a set
+// grow to MemoryBytes, since reading it briefly stops every goroutine
+const memoryChecksPerBudget = 64
+
+// meminfoPath is the file the memory available on the system is read from on linux
+const meminfoPath = "/proc/meminfo"
+
+// memoryBudget returns the budget for the heap of the process while the current set is read: the one
+// evaluated before the set with AutoMemory, or else MemoryBytes
+func (j *job) memoryBudget() uint64 {
+	if j.autoMemory > 0 {
+		return j.autoMemory
+	}
+	return j.opts.MemoryBytes
+}
+
+// evaluateMemory sets the budget of the next set with AutoMemory, to the heap in use plus half the memory
+// available on the system, up to MemoryBytes. If the available memory can not be read, MemoryBytes is used.
+func (j *job) evaluateMemory() {
+	if !j.opts.AutoMemory {
+		return
+	}
+	available, ok := availableMemory()
+	if !ok {
+		j.autoMemory = 0
+		return
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	j.autoMemory = m.HeapAlloc + available/2
+	if j.opts.MemoryBytes > 0 && j.autoMemory > j.opts.MemoryBytes {
+		j.autoMemory = j.opts.MemoryBytes
+	}
+}
+
+// lineBudget returns the most bytes of distinct lines to hold in a set before it is spilled: TmpFileBytes,
+// or without it a quarter of the memory budget, because a set uses 2-5x more memory than its lines
+func (j *job) lineBudget() uint64 {
+	switch {
+	case j.opts.TmpFileBytes > 0:
+		return j.opts.TmpFileBytes
+	case j.memoryBudget() > 0:
+		return j.memoryBudget() / 4
+	default:
+		return 0
+	}
+}
+
+// memoryCheck decides when the set being read has grown enough since the last check for the memory
+// of the process to be read again, against the memory budget and the memory pressure thresholds.
+// It also holds the total garbage collection pauses of the process when the current window started.
+type memoryCheck struct {
+	step uint64
+	next uint64
+
+	gcPauses uint64
+	gcSince  time.Time
+}
+
+// newMemoryCheck returns the memory check of the set being read, or nil if there is no memory budget
+// and the set is not spilled early under memory pressure
+func (j *job) newMemoryCheck() *memoryCheck {
+	budget := j.memoryBudget()
+	if budget == 0 && j.spillsOnPressure() {
+		budget = j.lineBudget()
+	}
+	if budget == 0 && !j.spillsOnPressure() {
+		return nil
+	}
+	step := budget / memoryChecksPerBudget
+	switch {
+	case budget == 0:
+		step = pressureCheckBytes
+	case step == 0:
+		step = 1
+	}
+	mc := &memoryCheck{step: step, next: step, gcSince: time.Now()}
+	if j.opts.SpillGCFraction > 0 {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		mc.gcPauses = m.PauseTotalNs
+	}
+	return mc
+}
+
+// due returns true if the set has grown to bytesUsed past the next check, and schedules the one after
+func (mc *memoryCheck) due(bytesUsed uint64) bool {
+	if mc == nil || bytesUsed < mc.next {
+		return false
+	}
+	mc.next = bytesUsed + mc.step
+	return true
+}
+
+// overMemory returns true if the heap of the process is at or over the memory budget, so that the set
+// must be spilled. Memory that is no longer used is only given back by the garbage collector, so over
+// the budget, the chunks still being written are waited for and collected before checking again.
+func (j *job) overMemory(cw *chunkWriter) (bool, error) {
+	budget := j.memoryBudget()
+	if budget == 0 {
+		return false, nil
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	if m.HeapAlloc < budget {
+		return false, nil
+	}
+	if err := cw.wait(); err != nil {
+		return false, err
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc >= budget, nil
+}
+
+// cgroupRoot is where the cgroup file system is mounted on linux
+const cgroupRoot = "/sys/fs/cgroup"
+
+// noCgroupLimit is the smallest cgroup v1 memory limit that means there is none, as the kernel
+// reports no limit as the largest page aligned int64
+const noCgroupLimit = 1 << 62
+
+// goMemLimitUnits are the unit suffixes of GOMEMLIMIT, longest first
+var goMemLimitUnits = []struct {
+	suffix string
+	bytes  uint64
+}{{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}, {"B", 1}}
+
+// MemoryLimit returns the lowest memory limit the process runs under, and where it is from: the
+// GOMEMLIMIT environment variable, or the memory limit of the cgroup of its container, on linux.
+// It returns zero and an empty source if there is no limit.
+func MemoryLimit() (uint64, string) {
+	return memoryLimit(os.Getenv("GOMEMLIMIT"), cgroupRoot)
+}
+
+// memoryLimit returns the lower of the GOMEMLIMIT value and the memory limit of the cgroup mounted at root
+func memoryLimit(goMemLimit, root string) (uint64, string) {
+	var limit uint64
+	var source string
+	if l, ok := parseGoMemLimit(goMemLimit); ok {
+		limit, source = l, "GOMEMLIMIT"
+	}
+	if l, file, ok := cgroupMemoryLimit(root); ok && (limit == 0 || l < limit) {
+		limit, source = l, file
+	}
+	return limit, source
+}
+
+// parseGoMemLimit returns the bytes of a GOMEMLIMIT value, such as 512MiB, or false if it is off or invalid
+func parseGoMemLimit(value string) (uint64, bool) {
+	value = strings.TrimSpace(value)
+	unit := uint64(1)
+	for _, u := range goMemLimitUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			value, unit = strings.TrimSuffix(value, u.suffix), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || n == 0 {
+		return 0, false
+	}
+	return n * unit, true
+}
+
+// cgroupMemoryLimit returns the memory limit of the cgroup v2 or v1 mounted at root, and the file it
+// was read from, or false if neither has one
+func cgroupMemoryLimit(root string) (uint64, string, bool) {
+	for _, file := range []string{
+		filepath.Join(root, "memory.max"),
+		filepath.Join(root, "memory", "memory.limit_in_bytes"),
+	} {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		value := strings.TrimSpace(string(b))
+		if value == "max" {
+			return 0, "", false
+		}
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || n == 0 || n >= noCgroupLimit {
+			return 0, "", false
+		}
+		return n, file, true
+	}
+	return 0, "", false
+}
+
+// availableMemory returns the memory available on the system to start new processes without swapping,
+// or false if it can not be read, which is the case other than on linux
+func availableMemory() (uint64, bool) {
+	f, err := os.Open(meminfoPath)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	return parseMemAvailable(f)
+}
+
+// parseMemAvailable returns the bytes of the MemAvailable line of a /proc/meminfo file, given in kB
+func parseMemAvailable(r io.Reader) (uint64, bool) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return kb * 1024, true
+	}
+	return 0, false
+}
@@ -0,0 +1,126 @@
+package dedup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunMemoryBytes(t *testing.T) {
+	input := strings.Repeat("c\na\nb\na\nc\na\nd\nc\n", 100)
+
+	// The heap of any process is over a single byte, so every check spills, while it never reaches 1 TB
+	for _, tc := range []struct {
+		memoryBytes uint64
+		spills      bool
+	}{{1, true}, {1 << 40, false}} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		stats, err := Run(outFile, Options{MemoryBytes: tc.memoryBytes}, strings.NewReader(input), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 4 || stats.LinesDuplicate != 796 || (stats.Chunks > 0) != tc.spills {
+			t.Fatalf("Unexpected stats with a budget of %d bytes: %+v", tc.memoryBytes, stats)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "a\nb\nc\nd\n" {
+			t.Fatalf("Unexpected output with a budget of %d bytes: %q", tc.memoryBytes, content)
+		}
+	}
+
+	// Without TmpFileBytes, a quarter of the budget can be lines
+	j := &job{opts: Options{MemoryBytes: 1000}}
+	if budget := j.lineBudget(); budget != 250 {
+		t.Fatalf("Expected a line budget of 250 bytes; Got: %d", budget)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dedup.test.dir.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Without a cgroup, only GOMEMLIMIT limits the memory, unless it is off
+	for value, expected := range map[string]uint64{"": 0, "off": 0, "1000": 1000, "2KiB": 2048, "3GiB": 3 << 30, "bad": 0} {
+		if limit, _ := memoryLimit(value, dir); limit != expected {
+			t.Fatalf("Expected a limit of %d from GOMEMLIMIT=%s; Got: %d", expected, value, limit)
+		}
+	}
+
+	// A cgroup v1 limit of the largest page aligned int64 is no limit
+	v1 := filepath.Join(dir, "memory", "memory.limit_in_bytes")
+	if err = os.MkdirAll(filepath.Dir(v1), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(v1, []byte("9223372036854771712\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if limit, _ := memoryLimit("", dir); limit != 0 {
+		t.Fatalf("Expected no limit; Got: %d", limit)
+	}
+
+	// The lowest of the cgroup v2 limit and GOMEMLIMIT wins
+	v2 := filepath.Join(dir, "memory.max")
+	if err = os.WriteFile(v2, []byte("4096\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if limit, source := memoryLimit("1MiB", dir); limit != 4096 || source != v2 {
+		t.Fatalf("Expected the cgroup limit of 4096 bytes; Got: %d from %s", limit, source)
+	}
+	if limit, source := memoryLimit("1KiB", dir); limit != 1024 || source != "GOMEMLIMIT" {
+		t.Fatalf("Expected the GOMEMLIMIT of 1024 bytes; Got: %d from %s", limit, source)
+	}
+	if err = os.WriteFile(v2, []byte("max\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if limit, _ := memoryLimit("", dir); limit != 0 {
+		t.Fatalf("Expected no limit; Got: %d", limit)
+	}
+}
+
+func TestAutoMemory(t *testing.T) {
+	meminfo := "MemTotal:       16384000 kB\nMemFree:         1024000 kB\nMemAvailable:    8192000 kB\n"
+	if available, ok := parseMemAvailable(strings.NewReader(meminfo)); !ok || available != 8192000*1024 {
+		t.Fatalf("Expected 8192000 kB available; Got: %d, %t", available, ok)
+	}
+	if _, ok := parseMemAvailable(strings.NewReader("MemTotal: 16384000 kB\n")); ok {
+		t.Fatal("Expected no available memory")
+	}
+
+	// MemoryBytes caps the budget, and is used as is if the available memory can not be read
+	j := &job{opts: Options{MemoryBytes: 1000, AutoMemory: true}}
+	j.evaluateMemory()
+	if budget := j.memoryBudget(); budget != 1000 {
+		t.Fatalf("Expected a memory budget of 1000 bytes; Got: %d", budget)
+	}
+
+	outFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+
+	if _, err = Run(outFile, Options{AutoMemory: true}, strings.NewReader("b\na\nb\n"), nil); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(outFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "a\nb\n" {
+		t.Fatalf("Unexpected output: %q", content)
+	}
+}
@@ -0,0 +1,557 @@
+package dedup
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// OutputFormat is the format of the unique lines written to the output
+type OutputFormat string
+
+const (
+	// FormatText writes each unique line followed by the delimiter
+	FormatText OutputFormat = "text"
+
+	// FormatJSON writes each unique line as a JSON object on its own line, with the number of
+	// times it was read, and the source and line number it was first read at
+	FormatJSON OutputFormat = "json"
+
+	// FormatCSV writes a header row, and then each unique line as a row of CSV, with the number of
+	// times it was read, and the source and line number it was first read at. The header is left out
+	// when the output file already has content, such as when appending.
+	FormatCSV OutputFormat = "csv"
+
+	// FormatTSV writes the same rows as FormatCSV separated by tabs, with the backslashes, tabs, and
+	// line breaks of the fields escaped as \\, \t, \n, and \r, so that each row is a line of its own
+	FormatTSV OutputFormat = "tsv"
+
+	// FormatSQLite writes a SQL script that inserts each unique line into the Table of a
+	// SQLite database, with the number of times it was read, and the source and line number it was
+	// first read at, such as for the sqlite3 command. The table and a unique index of its lines are
+	// created if absent, and a line already in the table has its count increased instead.
+	FormatSQLite OutputFormat = "sqlite"
+
+	// FormatPostgres writes a psql script that copies the unique lines into the Table of a PostgreSQL
+	// database in batches of PostgresBatchLines, with the number of times each was read, and the
+	// source and line number it was first read at. The table and a unique index of its lines are
+	// created if absent, and a line already in the table is left as it is.
+	FormatPostgres OutputFormat = "postgres"
+
+	// FormatRedis writes the commands of the Redis protocol that add the unique lines to the set at
+	// RedisKey, RedisBatchLines at a time, such as for redis-cli --pipe, and then expire it after
+	// RedisTTL, if any
+	FormatRedis OutputFormat = "redis"
+
+	// FormatParquet writes a Parquet file, with the columns line, count, source, and line_number,
+	// in row groups of about ParquetRowGroupBytes, declared sorted by line unless the lines are keyed,
+	// and so in the order of their keys. It is not supported with Partitions.
+	FormatParquet OutputFormat = "parquet"
+
+	// FormatArrow writes an Arrow IPC file, also known as Feather V2, with the columns line, count,
+	// source, and line_number, in record batches of ArrowBatchLines. It is not supported with Partitions.
+	FormatArrow OutputFormat = "arrow"
+
+	// FormatArrowStream writes the same record batches as FormatArrow as an Arrow IPC stream, which
+	// can be read as it is written, such as from a pipe. It is not supported with Partitions.
+	FormatArrowStream OutputFormat = "arrow-stream"
+)
+
+// jsonRecord is a unique line written as a record, as it is encoded in FormatJSON
+type jsonRecord struct {
+	Line       string `json:"line"`
+	Count      uint64 `json:"count"`
+	Source     string `json:"source,omitempty"`
+	LineNumber uint64 `json:"line_number,omitempty"`
+}
+
+// lineMeta is what is known about a line of the current chunk, beyond the line itself
+type lineMeta struct {
+	// extra is the number of occurrences of the line after the first
+	extra uint64
+
+	// first is the ordinal of the first occurrence of the line in the input plus one,
+	// or zero if not known
+	first uint64
+
+	// dups are the ordinals of the occurrences after the first, if auditing
+	dups []uint64
+
+	// text is what is written for the line, if keyed, from its first occurrence
+	text string
+}
+
+// records returns true if the format writes each unique line as a record, with the number of times
+// it was read and where it was first read, rather than as the line itself
+func (f OutputFormat) records() bool {
+	return f == FormatJSON || f == FormatCSV || f == FormatTSV || f == FormatSQLite || f == FormatPostgres || f == FormatRedis || f == FormatParquet ||
+		f == FormatArrow || f == FormatArrowStream
+}
+
+// binary returns true if the format is not written as lines, which can not be read back as such
+func (f OutputFormat) binary() bool {
+	return f == FormatParquet || f == FormatArrow || f == FormatArrowStream
+}
+
+// validateFormat returns an error if the output format is unknown, or cannot be written with the
+// other options
+func validateFormat(opts Options) error {
+	switch opts.Format {
+	case "", FormatText, FormatJSON, FormatCSV, FormatTSV:
+		return nil
+	case FormatSQLite, FormatPostgres, FormatRedis:
+		return validateScript(opts)
+	case FormatParquet:
+		return validateParquet(opts)
+	case FormatArrow, FormatArrowStream:
+		return validateArrow(opts)
+	default:
+		return fmt.Errorf("unknown output format: %q", opts.Format)
+	}
+}
+
+// tracking returns true if the occurrences of each line are being tracked, which needs a small
+// temporary meta file per chunk
+func (j *job) tracking() bool {
+	return j.opts.OnDuplicateCount != nil || j.tracksFirst()
+}
+
+// tracksFirst returns true if where each line was first read is being tracked, for every line
+// rather than only the duplicated ones
+func (j *job) tracksFirst() bool {
+	return j.opts.Format.records() || j.auditing() || j.mapping() || j.keyed()
+}
+
+// trackLine records where a line just added to the set of a chunk was first read, and the text
+// written for it if keyed, in the meta of the chunk, which is created if nil. It returns the meta.
+func (j *job) trackLine(meta map[string]lineMeta, line, text string, ordinal uint64) map[string]lineMeta {
+	if !j.tracksFirst() {
+		return meta
+	}
+	if meta == nil {
+		meta = make(map[string]lineMeta)
+	}
+	meta[line] = lineMeta{first: ordinal + 1, text: text}
+	return meta
+}
+
+// trackDuplicate records another occurrence of a line already in the set of a chunk, in the meta of
+// the chunk, which is created if nil. It returns the meta.
+func (j *job) trackDuplicate(meta map[string]lineMeta, line string, ordinal uint64) map[string]lineMeta {
+	if !j.tracking() {
+		return meta
+	}
+	if meta == nil {
+		meta = make(map[string]lineMeta)
+	}
+	m := meta[line]
+	m.extra++
+	if j.auditing() {
+		m.dups = append(m.dups, ordinal)
+	}
+	meta[line] = m
+	return meta
+}
+
+// reportDuplicates calls OnDuplicateCount and OnAudit for each of the sorted lines that were read
+// more than once, when all lines fit in memory and are written directly to the output
+func (j *job) reportDuplicates(keys []string) error {
+	if (j.opts.OnDuplicateCount == nil && !j.auditing()) || len(j.meta) == 0 {
+		return nil
+	}
+	for _, key := range keys {
+		m := j.meta[key]
+		if m.extra == 0 {
+			continue
+		}
+		if j.opts.OnDuplicateCount != nil {
+			if err := j.opts.OnDuplicateCount(key, m.extra+1); err != nil {
+				return err
+			}
+		}
+		if err := j.audit(key, m.first, 0, m.dups); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRecords writes all sorted lines to the writer as records, reporting progress as it goes,
+// and returns how many bytes were written
+func (j *job) writeRecords(w io.Writer, keys []string, progress *phaseProgress) (uint64, error) {
+	writer := bufio.NewWriterSize(w, bufferSize(j.opts.WriteBufferSize, defaultBufferSize))
+	var written, lineCount, byteCount uint64
+	for _, key := range keys {
+		m := j.meta[key]
+		line := key
+		if j.keyed() {
+			line = m.text
+		}
+		n, err := j.writeRecord(writer, line, m.extra+1, m.first)
+		written += n
+		if err != nil {
+			return written, err
+		}
+
+		lineCount++
+		byteCount += n
+		if lineCount >= 1000 {
+			progress.add(lineCount, byteCount)
+			lineCount = 0
+			byteCount = 0
+		}
+	}
+	progress.add(lineCount, byteCount)
+	n, err := j.flushRecords(writer)
+	written += n
+	if err != nil {
+		return written, err
+	}
+	return written, writer.Flush()
+}
+
+// writeRecord writes a single line as a record of the format, and returns how many bytes were written
+func (j *job) writeRecord(w *bufio.Writer, line string, occurrences, first uint64) (uint64, error) {
+	rec := jsonRecord{Line: line, Count: occurrences}
+	if first > 0 {
+		rec.Source, rec.LineNumber = j.position(first - 1)
+	}
+	switch j.opts.Format {
+	case FormatCSV:
+		return j.writeCSVRecord(w, rec)
+	case FormatTSV:
+		return j.writeTSVRecord(w, rec)
+	case FormatSQLite:
+		return j.writeSQLiteRecord(w, rec)
+	case FormatPostgres:
+		return j.writePostgresRecord(w, rec)
+	case FormatRedis:
+		return j.writeRedisRecord(w, rec)
+	case FormatParquet:
+		return j.writeParquetRecord(w, rec)
+	case FormatArrow, FormatArrowStream:
+		return j.writeArrowRecord(w, rec)
+	}
+
+	// Lines are often URL's, which are easier to read without escaping their HTML characters
+	j.record.Reset()
+	enc := json.NewEncoder(&j.record)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(rec); err != nil {
+		return 0, err
+	}
+	n, err := w.Write(j.record.Bytes())
+	return uint64(n), err
+}
+
+// createMeta creates the temporary meta file of the next chunk, or returns nil if the lines are not
+// being tracked
+func (j *job) createMeta() (*os.File, error) {
+	if !j.tracking() {
+		return nil, nil
+	}
+	metaFile, err := CreateTemp(j.dir, "*.meta")
+	if err != nil {
+		return nil, err
+	}
+	j.metaFiles = append(j.metaFiles, metaFile)
+	return metaFile, nil
+}
+
+// writeMeta writes what is known about each line of a chunk, in the same order as the chunk, to its
+// meta file, if any. It is needed to total the occurrences of each line, and find where it was first
+// read, while merging.
+func (j *job) writeMeta(metaFile *os.File, meta map[string]lineMeta, keys []string) error {
+	if metaFile == nil {
+		return nil
+	}
+	writer := getWriter(j.limitWriter(metaFile), bufferSize(j.opts.WriteBufferSize, defaultBufferSize))
+	defer putWriter(writer)
+	buf := make([]byte, 2*binary.MaxVarintLen64)
+	for _, key := range keys {
+		m := meta[key]
+		if err := appendMeta(writer, buf, m.extra, m.first, m.dups); err != nil {
+			return err
+		}
+		if j.keyed() {
+			if err := appendText(writer, buf, m.text); err != nil {
+				return err
+			}
+		}
+	}
+	return writer.Flush()
+}
+
+// appendMeta writes what is known about a single line to a meta file, using buf to encode it
+func appendMeta(writer *bufio.Writer, buf []byte, extra, first uint64, dups []uint64) error {
+	n := binary.PutUvarint(buf, extra)
+	n += binary.PutUvarint(buf[n:], first)
+	if _, err := writer.Write(buf[:n]); err != nil {
+		return err
+	}
+
+	// When auditing, the ordinal of every duplicate follows
+	for _, dup := range dups {
+		n = binary.PutUvarint(buf, dup)
+		if _, err := writer.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendText writes the text written for a keyed line to a meta file, after what appendMeta wrote
+// of it, using buf to encode its length
+func appendText(writer *bufio.Writer, buf []byte, text string) error {
+	n := binary.PutUvarint(buf, uint64(len(text)))
+	if _, err := writer.Write(buf[:n]); err != nil {
+		return err
+	}
+	_, err := writer.WriteString(text)
+	return err
+}
+
+// removeMeta closes and removes the temporary meta files
+func (j *job) removeMeta() error {
+	var c cleanup
+	for _, metaFile := range j.metaFiles {
+		c.close(metaFile)
+		c.remove(metaFile.Name())
+	}
+	return c.err()
+}
+
+// openMeta prepares the scanner of a chunk to read what is known about its lines from the meta file
+// of the chunk, if they are being tracked
+func (j *job) openMeta(ss *sortableScanner, chunk int) error {
+	if !j.tracking() || chunk >= len(j.metaFiles) {
+		return nil
+	}
+	metaFile := j.metaFiles[chunk]
+	if _, err := metaFile.Seek(0, 0); err != nil {
+		return err
+	}
+	ss.meta = bufio.NewReaderSize(j.limitReader(metaFile), bufferSize(j.opts.MergeBufferSize, defaultMergeBufferSize))
+	ss.withDups = j.auditing()
+	ss.withText = j.keyed()
+	return nil
+}
+
+// readMeta reads the occurrences, first ordinal, and text of the current token of the scanner,
+// from its meta file if any
+func (ss *sortableScanner) readMeta() error {
+	ss.count, ss.first, ss.dups = 1, 0, ss.dups[:0]
+	if ss.meta == nil {
+		return nil
+	}
+	extra, err := binary.ReadUvarint(ss.meta)
+	if err == nil {
+		ss.first, err = binary.ReadUvarint(ss.meta)
+	}
+	for i := uint64(0); ss.withDups && i < extra && err == nil; i++ {
+		var dup uint64
+		dup, err = binary.ReadUvarint(ss.meta)
+		ss.dups = append(ss.dups, dup)
+	}
+	if ss.withText && err == nil {
+		var n uint64
+		if n, err = binary.ReadUvarint(ss.meta); err == nil {
+			if uint64(cap(ss.text)) < n {
+				ss.text = make([]byte, n)
+			}
+			ss.text = ss.text[:n]
+			_, err = io.ReadFull(ss.meta, ss.text)
+		}
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("meta file of %s ended at line %d", ss.f.Name(), ss.lines)
+	}
+	ss.count += extra
+	return err
+}
+
+// lineGroup totals the occurrences of each line while merging, and finds where it was first read,
+// and the text read there if keyed. Once all of its occurrences have been seen, a line read more than
+// once is reported, and in a format of records, such as FormatJSON, the line is written. When auditing,
+// every duplicate is reported as it is merged.
+type lineGroup struct {
+	j           *job
+	w           *bufio.Writer
+	line        string
+	text        []byte
+	occurrences uint64
+	first       uint64
+}
+
+// add records the occurrences of the line in the chunk of the first scanner of the heap, finishing
+// the previous line if this one is different
+func (g *lineGroup) add(h scannerHeap) error {
+	if !g.j.tracking() {
+		return nil
+	}
+	ss := h[0]
+	if g.occurrences == 0 || string(ss.token) != g.line {
+		if err := g.flush(); err != nil {
+			return err
+		}
+
+		// Every chunk with this line is at the top of the heap, so the first occurrence is known
+		// before any of its duplicates are audited
+		g.line, g.first = string(ss.token), 0
+		h.leading(func(other *sortableScanner) error {
+			if other.first > 0 && (g.first == 0 || other.first < g.first) {
+				g.first = other.first
+				g.text = append(g.text[:0], other.text...)
+			}
+			return nil
+		})
+	}
+	g.occurrences += ss.count
+	return g.j.audit(g.line, g.first, ss.first, ss.dups)
+}
+
+// flush finishes the current line, if any
+func (g *lineGroup) flush() error {
+	occurrences := g.occurrences
+	g.occurrences = 0
+	if !g.j.tracking() || occurrences == 0 {
+		return nil
+	}
+	if g.j.opts.OnDuplicateCount != nil && occurrences > 1 {
+		if err := g.j.opts.OnDuplicateCount(g.line, occurrences); err != nil {
+			return err
+		}
+	}
+	if g.j.opts.Format.records() {
+		line := g.line
+		if g.j.keyed() {
+			line = string(g.text)
+		}
+		written, err := g.j.writeRecord(g.w, line, occurrences, g.first)
+		g.j.stats.BytesOut += written
+		return err
+	}
+	return nil
+}
+
+// Source is a named input, such as a file
+type Source struct {
+	Name   string
+	Reader io.Reader
+}
+
+// Sources reads each of the sources in turn, as a single input to Run or RunShards, and remembers
+// where each of them starts, so that lines can be traced back to the source they were read from.
+// A source that does not end with the delimiter runs its final line into the next source.
+//
+// Every line of a source is read before any of the next, so a line read from an earlier source is
+// always first read before the same line, or key, of a later one. Whatever the chunks, Partitions,
+// BuildWorkers, SortWorkers, and MergeFanIn, the first line read of each key that is written, and the
+// source and line number of the records of formats such as FormatJSON, are of the earliest source it
+// is in, and of its earliest line there, so that an earlier source is authoritative. ReadWorkers reads
+// the sources in no particular order, so it is not supported with either.
+type Sources struct {
+	sources []Source
+	offset  uint64
+
+	// The starts are read while the next sources are read ahead on another goroutine
+	mu     sync.Mutex
+	starts []uint64
+}
+
+// NewSources returns a reader of the sources, in order
+func NewSources(sources ...Source) *Sources {
+	s := &Sources{sources: sources}
+	if len(sources) > 0 {
+		s.starts = []uint64{0}
+	}
+	return s
+}
+
+func (s *Sources) Read(p []byte) (int, error) {
+	for len(s.starts) > 0 && len(s.starts) <= len(s.sources) {
+		n, err := s.sources[len(s.starts)-1].Reader.Read(p)
+		s.offset += uint64(n)
+		if err == io.EOF {
+			// Start the next source, returning what was read from this one first
+			s.mu.Lock()
+			s.starts = append(s.starts, s.offset)
+			s.mu.Unlock()
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+	return 0, io.EOF
+}
+
+// sourceAt returns the index of the source the byte at the offset was read from
+func (s *Sources) sourceAt(offset uint64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The last source starting at or before the offset, which skips any empty sources
+	i := sort.Search(len(s.starts), func(i int) bool {
+		return s.starts[i] > offset
+	}) - 1
+	if i >= len(s.sources) {
+		i = len(s.sources) - 1
+	}
+	return i
+}
+
+// sourceStart is the ordinal of the first line read from a source
+type sourceStart struct {
+	source  int
+	ordinal uint64
+}
+
+// locate records the source of the line at the ordinal, if the input is Sources and where lines were
+// read are being tracked. The line must be the last token scanned.
+func (j *job) locate(ordinal uint64) {
+	if j.sources == nil || !j.locating() {
+		return
+	}
+	source := j.sources.sourceAt(j.tokenStart)
+	if n := len(j.sourceStarts); n == 0 || j.sourceStarts[n-1].source != source {
+		j.sourceStarts = append(j.sourceStarts, sourceStart{source: source, ordinal: ordinal})
+	}
+}
+
+// position returns the name of the source, and the line number within it, of the line at the ordinal.
+// Without Sources, the name is empty and the line number is within the whole input.
+func (j *job) position(ordinal uint64) (string, uint64) {
+	i := sort.Search(len(j.sourceStarts), func(i int) bool {
+		return j.sourceStarts[i].ordinal > ordinal
+	}) - 1
+	if i < 0 {
+		return "", ordinal + 1
+	}
+	start := j.sourceStarts[i]
+	return j.sources.sources[start.source].Name, ordinal - start.ordinal + 1
+}
+
+// trackOffsets wraps the split function of the input scanner, to record the offset of each token
+// in the input, if the input is Sources and where lines were read are being tracked
+func (j *job) trackOffsets(scanner *bufio.Scanner) {
+	if j.sources == nil || !j.locating() {
+		return
+	}
+	split := j.split()
+	var offset uint64
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if token != nil {
+			j.tokenStart = offset
+		}
+		offset += uint64(advance)
+		return advance, token, err
+	})
+}
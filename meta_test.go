@@ -0,0 +1,173 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRunOnDuplicateCount(t *testing.T) {
+	input := "c\na\nb\na\nc\na\nd\nc\n"
+	expected := []string{"a:3", "c:3"}
+
+	// Whether everything fits in memory or not, and whether duplicates are within or between chunks
+	for _, tmpFileBytes := range []uint64{1000, 6, 1} {
+		var counts []string
+		opts := Options{
+			TmpFileBytes: tmpFileBytes,
+			DryRun:       true,
+			OnDuplicateCount: func(line string, count uint64) error {
+				counts = append(counts, fmt.Sprintf("%s:%d", line, count))
+				return nil
+			},
+		}
+		stats, err := Run(nil, opts, strings.NewReader(input), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesDuplicate != 4 {
+			t.Fatalf("Unexpected stats with %d tmp file bytes: %+v", tmpFileBytes, stats)
+		}
+		if !reflect.DeepEqual(counts, expected) {
+			t.Fatalf("Unexpected counts with %d tmp file bytes: %v", tmpFileBytes, counts)
+		}
+	}
+}
+
+func TestMergeOnDuplicateCount(t *testing.T) {
+	var inFiles []*os.File
+	for _, content := range []string{"a\nb\nb\n", "b\nc\n"} {
+		inFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(inFile.Name())
+		defer inFile.Close()
+		if _, err = inFile.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+		inFiles = append(inFiles, inFile)
+	}
+
+	var counts []string
+	opts := Options{DryRun: true, OnDuplicateCount: func(line string, count uint64) error {
+		counts = append(counts, fmt.Sprintf("%s:%d", line, count))
+		return nil
+	}}
+	if _, err := Merge(nil, opts, inFiles...); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(counts, []string{"b:3"}) {
+		t.Fatalf("Unexpected counts: %v", counts)
+	}
+}
+
+func TestRunFormatJSON(t *testing.T) {
+	expected := `{"line":"a","count":3,"source":"one","line_number":2}
+{"line":"b","count":1,"source":"two","line_number":2}
+{"line":"c","count":2,"source":"one","line_number":1}
+{"line":"d&e","count":1,"source":"two","line_number":4}
+`
+	// Whether everything fits in memory or not, the first occurrence is the earliest one
+	for _, tmpFileBytes := range []uint64{1000, 4, 1} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		input := NewSources(
+			Source{Name: "one", Reader: strings.NewReader("c\na\n")},
+			Source{Name: "empty", Reader: strings.NewReader("")},
+			Source{Name: "two", Reader: strings.NewReader("a\nb\nc\nd&e\na\n")},
+		)
+		opts := Options{TmpFileBytes: tmpFileBytes, Format: FormatJSON}
+		stats, err := Run(outFile, opts, input, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 4 || stats.LinesDuplicate != 3 || stats.BytesOut != uint64(len(expected)) {
+			t.Fatalf("Unexpected stats with %d tmp file bytes: %+v", tmpFileBytes, stats)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expected {
+			t.Fatalf("Unexpected output with %d tmp file bytes:\n%s", tmpFileBytes, content)
+		}
+	}
+}
+
+func TestRunFirstReadSourceOrder(t *testing.T) {
+	// The same keys are in both sources, and in a later line of the first, with other text
+	firstField := func(line string) string {
+		return strings.SplitN(line, " ", 2)[0]
+	}
+	var a, b strings.Builder
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&a, "k%02d a\n", i)
+		fmt.Fprintf(&b, "k%02d b\n", 49-i)
+	}
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&a, "k%02d a-later\n", i)
+	}
+
+	// The earlier source is authoritative however the lines are split between chunks and workers
+	tests := []Options{
+		{TmpFileBytes: 10000},
+		{TmpFileBytes: 40},
+		{TmpFileBytes: 40, MergeFanIn: 2},
+		{TmpFileBytes: 40, Partitions: 3},
+		{TmpFileBytes: 40, BuildWorkers: 3},
+		{TmpFileBytes: 40, SortWorkers: 3},
+	}
+	for i, opts := range tests {
+		for _, format := range []OutputFormat{FormatText, FormatJSON} {
+			opts.Key, opts.Format = firstField, format
+			opts.OnProgress, opts.OnEvent = func(Progress) {}, func(Event) {}
+			outFile, err := os.CreateTemp("", "dedup.test.*.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(outFile.Name())
+			defer outFile.Close()
+
+			input := NewSources(Source{Name: "a", Reader: strings.NewReader(a.String())},
+				Source{Name: "b", Reader: strings.NewReader(b.String())})
+			if _, err = Run(outFile, opts, input, nil); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(outFile.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Partitions by hash are only sorted within each
+			lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+			sort.Strings(lines)
+			if len(lines) != 50 {
+				t.Fatalf("Expected 50 lines with options %d and format %s, got %d", i, format, len(lines))
+			}
+			for n, line := range lines {
+				expected := fmt.Sprintf("k%02d a", n)
+				if format == FormatJSON {
+					expected = fmt.Sprintf(`{"line":"k%02d a","count":3,"source":"a","line_number":%d}`, n, n+1)
+				}
+				if line != expected {
+					t.Fatalf("Expected line %d with options %d and format %s to be %q, got %q", n, i, format, expected, line)
+				}
+			}
+		}
+	}
+
+	// Read workers read the sources in no particular order
+	input := NewSources(Source{Name: "a", Reader: strings.NewReader(a.String())},
+		Source{Name: "b", Reader: strings.NewReader(b.String())})
+	if _, err := Run(nil, Options{DryRun: true, Key: firstField, ReadWorkers: 2}, input, nil); err == nil {
+		t.Fatal("Expected an error writing the first line read of each key with read workers")
+	}
+}
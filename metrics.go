@@ -0,0 +1,102 @@
+package dedup
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Metrics are live counters of the runs they are given to with Options.Metrics, which are safe
+// to read while the runs are in progress, such as to serve them to a monitoring system.
+// The counters add up over every run they are given to, and are updated in batches of lines.
+// All methods are safe to call on a nil Metrics, which counts nothing.
+type Metrics struct {
+	// Atomically accessed fields first, so that they are 64-bit aligned on 32-bit platforms
+	linesRead   uint64
+	linesUnique uint64
+	chunks      uint64
+	tmpBytes    uint64
+
+	mu    sync.Mutex
+	phase Phase
+}
+
+// LinesRead returns the number of lines read from the input files so far
+func (m *Metrics) LinesRead() uint64 {
+	if m == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&m.linesRead)
+}
+
+// LinesUnique returns the number of unique lines written to the output files so far
+func (m *Metrics) LinesUnique() uint64 {
+	if m == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&m.linesUnique)
+}
+
+// Chunks returns the number of temporary files written so far
+func (m *Metrics) Chunks() uint64 {
+	if m == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&m.chunks)
+}
+
+// TmpBytes returns the byte size of the temporary files that are currently on disk
+func (m *Metrics) TmpBytes() uint64 {
+	if m == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&m.tmpBytes)
+}
+
+// Phase returns the phase of the run in progress, or an empty phase if no run is in progress
+func (m *Metrics) Phase() Phase {
+	if m == nil {
+		return ""
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.phase
+}
+
+// addLinesRead increments the lines read
+func (m *Metrics) addLinesRead(lines uint64) {
+	if m != nil {
+		atomic.AddUint64(&m.linesRead, lines)
+	}
+}
+
+// addLinesUnique increments the unique lines written
+func (m *Metrics) addLinesUnique(lines uint64) {
+	if m != nil {
+		atomic.AddUint64(&m.linesUnique, lines)
+	}
+}
+
+// addChunk counts a temporary file that was written
+func (m *Metrics) addChunk(bytes uint64) {
+	if m != nil {
+		atomic.AddUint64(&m.chunks, 1)
+		atomic.AddUint64(&m.tmpBytes, bytes)
+	}
+}
+
+// removeTmpBytes subtracts the byte size of temporary files that were removed
+func (m *Metrics) removeTmpBytes(bytes uint64) {
+	if m != nil {
+		atomic.AddUint64(&m.tmpBytes, ^(bytes - 1))
+	}
+}
+
+// setPhase sets the phase of the run in progress
+func (m *Metrics) setPhase(phase Phase) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.phase = phase
+}
@@ -0,0 +1,54 @@
+package dedup
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRunMetrics(t *testing.T) {
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+
+	// Record the phase and temporary bytes on disk as each chunk is written
+	metrics := &Metrics{}
+	var phases []Phase
+	var maxTmpBytes uint64
+	opts := Options{
+		TmpFileBytes: 20 * 50,
+		DryRun:       true,
+		Metrics:      metrics,
+		OnProgress:   func(Progress) {},
+		OnEvent: func(e Event) {
+			phases = append(phases, metrics.Phase())
+			if metrics.TmpBytes() > maxTmpBytes {
+				maxTmpBytes = metrics.TmpBytes()
+			}
+		},
+	}
+
+	stats, err := Run(nil, opts, inFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics.LinesRead() != stats.LinesRead || metrics.LinesUnique() != stats.LinesUnique ||
+		metrics.Chunks() != uint64(stats.Chunks) {
+		t.Fatalf("Metrics do not match the stats: %+v", stats)
+	}
+	if maxTmpBytes != stats.TmpBytes || metrics.TmpBytes() != 0 {
+		t.Fatalf("Expected %d temporary bytes during the run and none after, got %d and %d",
+			stats.TmpBytes, maxTmpBytes, metrics.TmpBytes())
+	}
+	if phases[0] != PhaseSplitting || phases[len(phases)-1] != PhaseMerging || metrics.Phase() != "" {
+		t.Fatalf("Unexpected phases: %v, then %q", phases, metrics.Phase())
+	}
+
+	// A nil Metrics counts nothing
+	var none *Metrics
+	none.addLinesRead(1)
+	if none.LinesRead() != 0 || none.Phase() != "" {
+		t.Fatal("Expected a nil Metrics to count nothing")
+	}
+}
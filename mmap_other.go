@@ -0,0 +1,23 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package dedup
+
+import "os"
+
+// mmapFile returns nil, because memory mapping is not supported here, so files are always read
+// through a buffer
+func mmapFile(f *os.File) ([]byte, error) {
+	return nil, nil
+}
+
+// mmapWritable returns nil, because memory mapping is not supported here, so files are always written
+// through a buffer
+func mmapWritable(f *os.File, offset int64, length int) ([]byte, error) {
+	return nil, nil
+}
+
+// munmapFile does nothing, because nothing is ever mapped
+func munmapFile(data []byte) error {
+	return nil
+}
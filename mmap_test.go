@@ -0,0 +1,78 @@
+package dedup
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunMergeMmap(t *testing.T) {
+	for _, tc := range []struct {
+		delimiter string
+		input     string
+		expected  string
+	}{
+		{"", "c\r\na\nb\n\na\r\nc\nd", "\na\nb\nc\nd\n"},
+		{"\x00", "c\x00a\x00b\x00a\x00c\x00d", "a\x00b\x00c\x00d\x00"},
+	} {
+		// The mapped chunks are read the same as buffered chunks, whether there are any or not
+		for _, tmpFileBytes := range []uint64{1000, 4, 1} {
+			outFile, err := os.CreateTemp("", "dedup.test.*.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(outFile.Name())
+			defer outFile.Close()
+
+			opts := Options{TmpFileBytes: tmpFileBytes, Delimiter: tc.delimiter, MergeMmap: true, MergeFanIn: 2}
+			if _, err = Run(outFile, opts, strings.NewReader(tc.input), nil); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(outFile.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tc.expected {
+				t.Fatalf("Unexpected output with %d tmp file bytes: %q", tmpFileBytes, content)
+			}
+		}
+	}
+}
+
+func TestMergeMmap(t *testing.T) {
+	var inFiles []*os.File
+	for _, content := range []string{"a\nb\nb\n", "", "b\nc"} {
+		inFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(inFile.Name())
+		defer inFile.Close()
+		if _, err = inFile.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+		inFiles = append(inFiles, inFile)
+	}
+
+	outFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+
+	stats, err := Merge(outFile, Options{MergeMmap: true}, inFiles...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.LinesRead != 5 || stats.LinesUnique != 3 || stats.LinesDuplicate != 2 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	content, err := os.ReadFile(outFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "a\nb\nc\n" {
+		t.Fatalf("Unexpected output: %q", content)
+	}
+}
@@ -0,0 +1,34 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package dedup
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the whole of the file into memory for reading, or returns nil if it is empty
+// or too large to map
+func mmapFile(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size <= 0 || int64(int(size)) != size {
+		return nil, nil
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// mmapWritable maps length bytes of the file from the offset, which must be a multiple of the page size,
+// into memory for writing. The file must be open for reading and writing, and at least that long.
+func mmapWritable(f *os.File, offset int64, length int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), offset, length, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+}
+
+// munmapFile releases the memory mapped by mmapFile or mmapWritable
+func munmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
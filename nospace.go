@@ -0,0 +1,14 @@
+//go:build !plan9
+// +build !plan9
+
+package dedup
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isNoSpace returns true if the error is of a write to a filesystem that is full
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
@@ -0,0 +1,6 @@
+package dedup
+
+// isNoSpace returns false, as plan9 has no error number of a filesystem that is full
+func isNoSpace(err error) bool {
+	return false
+}
@@ -0,0 +1,64 @@
+//go:build !plan9
+// +build !plan9
+
+package dedup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestRunDiskFull(t *testing.T) {
+	b, err := os.ReadFile("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "checkpoint.json")
+
+	// The filesystem fills up while merging into the output, once every chunk has been checkpointed
+	outFile, err := os.Create(filepath.Join(dir, "out.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outFile.Close()
+	full := &os.PathError{Op: "write", Path: outFile.Name(), Err: syscall.ENOSPC}
+	opts := Options{TmpFileBytes: 20 * 50, TempDir: dir, Checkpoint: manifest}
+	opts.OnDuplicate = func(string) error {
+		if _, err := os.Stat(manifest); err == nil {
+			return full
+		}
+		return nil
+	}
+	stats, err := Run(outFile, opts, strings.NewReader(string(b)), nil)
+	var diskErr *DiskSpaceError
+	if !errors.As(err, &diskErr) || !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("Expected a disk space error; Got: %v", err)
+	}
+	if diskErr.Path != full.Path || diskErr.Checkpoint != manifest || diskErr.Needed != stats.TmpBytes {
+		t.Fatalf("Expected the output to need up to the %d bytes of the chunks, resuming from %s; Got: %+v", stats.TmpBytes, manifest, diskErr)
+	}
+
+	// Only the chunks in the checkpoint are left, and the run resumes from them
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "checkpoint.json" && entry.Name() != "out.log" && !strings.Contains(string(m), entry.Name()) {
+			t.Fatalf("Expected %s to be removed, since it is not in the checkpoint", entry.Name())
+		}
+	}
+	opts.OnDuplicate, opts.Resume, opts.DryRun = nil, true, true
+	if _, err = Run(nil, opts, strings.NewReader(string(b)), nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,129 @@
+package dedup
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// prepareOutput returns the writer of the merged output. With PreallocateOutput or OutputMmap, the space
+// the output is estimated to need, the bytes of the chunks, is allocated in the output file first, and
+// with OutputMmap the output is written into a memory mapping of it. The returned function finishes the
+// output file once everything is written, giving back the space that was not needed. The output file
+// is only prepared if it is written from its end, such as a new file, and nothing goes wrong preparing it.
+func (j *job) prepareOutput(out io.Writer) (io.Writer, func() error) {
+	f := j.outFile
+	if f == nil || (!j.opts.PreallocateOutput && !j.opts.OutputMmap) || j.stats.TmpBytes == 0 {
+		return out, func() error { return nil }
+	}
+	start, err := f.Seek(0, io.SeekCurrent)
+	if err == nil {
+		var info os.FileInfo
+		if info, err = f.Stat(); err == nil && info.Size() != start {
+			return out, func() error { return nil }
+		}
+	}
+	estimate := int64(j.stats.TmpBytes)
+	if err == nil {
+		err = fallocate(f, start, estimate)
+	}
+	if err != nil {
+		j.outputWarning(err, "Could not allocate space for %s, writing it as usual: %v")
+		return out, func() error { return nil }
+	}
+
+	// Whatever is written, the output ends where the writing stopped
+	finish := func() error {
+		end, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		return f.Truncate(end)
+	}
+	if !j.opts.OutputMmap || int64(int(estimate)) != estimate {
+		return out, finish
+	}
+	w, err := newMmapWriter(f, start, int(estimate))
+	if err != nil || w == nil {
+		if err != nil {
+			j.outputWarning(err, "Could not memory map %s, writing it instead: %v")
+		}
+		return out, finish
+	}
+	return j.sumOutput(j.limitWriter(w)), w.finish
+}
+
+// outputWarning sends a warning about the output file, with a message formatted with its name and the error
+func (j *job) outputWarning(err error, format string) {
+	j.event(Event{Kind: EventWarning, Phase: PhaseMerging, File: j.outFile.Name(), Err: err,
+		Message: fmt.Sprintf(format, j.outFile.Name(), err)})
+}
+
+// mmapWriter writes to a memory mapping of the end of a file that has been extended for it, instead
+// of writing to the file through system calls. Once the mapping is full, or the writing is finished,
+// it is unmapped, and the file is cut back to where the writing stopped, so that anything more is
+// written to the file as usual.
+type mmapWriter struct {
+	f     *os.File
+	start int64
+	data  []byte
+	skip  int
+	n     int
+}
+
+// newMmapWriter extends the file to length bytes past the start, and maps them into memory for writing,
+// from the page the start is in. It returns nil if memory mapping is not supported.
+func newMmapWriter(f *os.File, start int64, length int) (*mmapWriter, error) {
+	if err := f.Truncate(start + int64(length)); err != nil {
+		return nil, err
+	}
+	pageSize := int64(os.Getpagesize())
+	aligned := start - start%pageSize
+	skip := int(start - aligned)
+	data, err := mmapWritable(f, aligned, skip+length)
+	if err != nil || data == nil {
+		return nil, truncateTo(f, start, err)
+	}
+	return &mmapWriter{f: f, start: start, data: data, skip: skip}, nil
+}
+
+func (w *mmapWriter) Write(p []byte) (int, error) {
+	if w.data != nil {
+		if len(p) <= len(w.data)-w.skip-w.n {
+			copy(w.data[w.skip+w.n:], p)
+			w.n += len(p)
+			return len(p), nil
+		}
+		if err := w.unmap(); err != nil {
+			return 0, err
+		}
+	}
+	return w.f.Write(p)
+}
+
+// unmap releases the mapping, and cuts the file back to where the writing stopped
+func (w *mmapWriter) unmap() error {
+	err := munmapFile(w.data)
+	w.data = nil
+	return truncateTo(w.f, w.start+int64(w.n), err)
+}
+
+// finish releases the mapping, if the file is still written through it
+func (w *mmapWriter) finish() error {
+	if w.data == nil {
+		return nil
+	}
+	return w.unmap()
+}
+
+// truncateTo cuts the file back to the offset, and continues writing it from there. It returns err
+// if not nil, or else any error doing so.
+func truncateTo(f *os.File, offset int64, err error) error {
+	if truncateErr := f.Truncate(offset); err == nil {
+		err = truncateErr
+	}
+	if _, seekErr := f.Seek(offset, io.SeekStart); err == nil {
+		err = seekErr
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package dedup
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunOutputMmap(t *testing.T) {
+	input := strings.Repeat("c\na\nb\na\nd\n", 100)
+
+	// JSON records are longer than the chunks, so they run past the memory mapping
+	for _, opts := range []Options{
+		{TmpFileBytes: 4, PreallocateOutput: true},
+		{TmpFileBytes: 4, OutputMmap: true},
+		{TmpFileBytes: 4, OutputMmap: true, Format: FormatJSON},
+		{TmpFileBytes: 4, OutputMmap: true, Partitions: 2, PartitionBy: ShardRange},
+	} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		opts.OnEvent = func(e Event) {
+			if e.Kind == EventWarning {
+				t.Errorf("Unexpected warning: %s", e.Message)
+			}
+		}
+		stats, err := Run(outFile, opts, strings.NewReader(input), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uint64(len(content)) != stats.BytesOut || stats.Chunks == 0 {
+			t.Fatalf("Expected %d bytes written from chunks; Got: %d, %+v", stats.BytesOut, len(content), stats)
+		}
+		if opts.Format != FormatJSON && string(content) != "a\nb\nc\nd\n" {
+			t.Fatalf("Unexpected output: %q", content)
+		}
+	}
+}
+
+func TestMmapWriter(t *testing.T) {
+	f, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// Writing starts past the start of a page, and continues past the end of the mapping
+	if _, err = f.WriteString("head\n"); err != nil {
+		t.Fatal(err)
+	}
+	w, err := newMmapWriter(f, 5, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out io.Writer = f
+	finish := func() error { return nil }
+	if w != nil {
+		out, finish = w, w.finish
+	}
+	for _, s := range []string{"abc\n", "def\n", "ghi\n"} {
+		if _, err = io.WriteString(out, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = finish(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "head\nabc\ndef\nghi\n" {
+		t.Fatalf("Unexpected content: %q", content)
+	}
+}
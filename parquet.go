@@ -0,0 +1,403 @@
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// defaultParquetRowGroupBytes is about how many bytes of lines and sources each row group of
+// FormatParquet holds, when not configured
+const defaultParquetRowGroupBytes = 32 * 1024 * 1024
+
+// parquetMagic starts and ends every Parquet file
+const parquetMagic = "PAR1"
+
+// The physical types, repetitions, encodings, and converted types of Parquet used by FormatParquet
+const (
+	parquetInt64     = 2
+	parquetByteArray = 6
+	parquetRequired  = 0
+	parquetOptional  = 1
+	parquetPlain     = 0
+	parquetRLE       = 3
+	parquetUTF8      = 0
+)
+
+// parquetColumn is a column of FormatParquet, in the order of its schema
+type parquetColumn struct {
+	name     string
+	typ      int32
+	optional bool
+	utf8     bool
+}
+
+// parquetColumns are the columns of FormatParquet
+var parquetColumns = []parquetColumn{
+	{name: "line", typ: parquetByteArray, utf8: true},
+	{name: "count", typ: parquetInt64},
+	{name: "source", typ: parquetByteArray, optional: true, utf8: true},
+	{name: "line_number", typ: parquetInt64, optional: true},
+}
+
+// parquetChunk is where the column chunk of a row group was written, for the footer
+type parquetChunk struct {
+	offset int64
+	size   int64
+}
+
+// parquetRowGroup is a row group that was written, for the footer
+type parquetRowGroup struct {
+	rows   int64
+	chunks []parquetChunk
+}
+
+// parquetWriter holds the rows of the current row group of FormatParquet, column by column, already
+// encoded as they are written, and what the footer needs to know about the row groups written so far
+type parquetWriter struct {
+	offset int64
+	rows   int64
+	values [4]bytes.Buffer
+	levels [4][]byte
+	groups []parquetRowGroup
+}
+
+// validateParquet returns an error if FormatParquet can not be written with the options. Each
+// partition would be written with offsets of its own, and lines passed through are not rows.
+func validateParquet(opts Options) error {
+	if opts.ParquetRowGroupBytes < 0 {
+		return fmt.Errorf("parquet row group bytes must not be negative: %d", opts.ParquetRowGroupBytes)
+	}
+	if opts.Partitions > 1 {
+		return fmt.Errorf("parquet output is not supported with partitions")
+	}
+	if opts.PassthroughUnkept {
+		return fmt.Errorf("parquet output is not supported when passing through unkept lines")
+	}
+	for _, rule := range opts.Rules {
+		if rule.Action == RulePassthrough {
+			return fmt.Errorf("parquet output is not supported with passthrough rules")
+		}
+	}
+	return nil
+}
+
+// parquetStart writes the magic bytes starting a Parquet file, from where the output file is at,
+// since the offsets of the row groups are from the start of the file
+func (j *job) parquetStart(w io.Writer) error {
+	j.parquet = &parquetWriter{}
+	if j.outFile != nil {
+		if offset, err := j.outFile.Seek(0, io.SeekCurrent); err == nil {
+			j.parquet.offset = offset
+		}
+	}
+	return j.writeParquet(w, []byte(parquetMagic))
+}
+
+// writeParquetRecord adds the record as a row of the current row group of FormatParquet, and writes
+// the row group once it is full. It returns how many bytes were written.
+func (j *job) writeParquetRecord(w *bufio.Writer, rec jsonRecord) (uint64, error) {
+	p := j.parquet
+	p.rows++
+	writeParquetBytes(&p.values[0], rec.Line)
+	writeParquetInt64(&p.values[1], rec.Count)
+	p.levels[2] = append(p.levels[2], 0)
+	if rec.Source != "" {
+		p.levels[2][len(p.levels[2])-1] = 1
+		writeParquetBytes(&p.values[2], rec.Source)
+	}
+	p.levels[3] = append(p.levels[3], 0)
+	if rec.LineNumber > 0 {
+		p.levels[3][len(p.levels[3])-1] = 1
+		writeParquetInt64(&p.values[3], rec.LineNumber)
+	}
+	rowGroupBytes := j.opts.ParquetRowGroupBytes
+	if rowGroupBytes == 0 {
+		rowGroupBytes = defaultParquetRowGroupBytes
+	}
+	if p.values[0].Len()+p.values[2].Len() < rowGroupBytes {
+		return 0, nil
+	}
+	return p.flush(w)
+}
+
+// flush writes the current row group, if it has any rows, as a column chunk of a single data page for
+// each column, and returns how many bytes were written
+func (p *parquetWriter) flush(w io.Writer) (uint64, error) {
+	if p.rows == 0 {
+		return 0, nil
+	}
+	group := parquetRowGroup{rows: p.rows}
+	var written uint64
+	for i, column := range parquetColumns {
+		var data bytes.Buffer
+		if column.optional {
+			levels := encodeParquetLevels(p.levels[i])
+			var length [4]byte
+			binary.LittleEndian.PutUint32(length[:], uint32(len(levels)))
+			data.Write(length[:])
+			data.Write(levels)
+		}
+		data.Write(p.values[i].Bytes())
+
+		var header thriftWriter
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(data.Len()))
+		header.i32(3, int32(data.Len()))
+		header.begin(5)
+		header.i32(1, int32(p.rows))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.end()
+
+		chunk := parquetChunk{offset: p.offset, size: int64(header.b.Len() + data.Len())}
+		n, err := w.Write(header.b.Bytes())
+		if err == nil {
+			var m int
+			m, err = w.Write(data.Bytes())
+			n += m
+		}
+		written += uint64(n)
+		p.offset += int64(n)
+		if err != nil {
+			return written, err
+		}
+		group.chunks = append(group.chunks, chunk)
+		p.values[i].Reset()
+		p.levels[i] = p.levels[i][:0]
+	}
+	p.groups = append(p.groups, group)
+	p.rows = 0
+	return written, nil
+}
+
+// parquetEnd writes the footer of the Parquet file, with the schema and where each row group was
+// written, then its length and the magic bytes ending the file
+func (j *job) parquetEnd(w io.Writer) error {
+	p := j.parquet
+	var meta thriftWriter
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, 1+len(parquetColumns))
+	meta.element()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(parquetColumns)))
+	meta.end()
+	for _, column := range parquetColumns {
+		meta.element()
+		meta.i32(1, column.typ)
+		repetition := int32(parquetRequired)
+		if column.optional {
+			repetition = parquetOptional
+		}
+		meta.i32(3, repetition)
+		meta.binary(4, column.name)
+		if column.utf8 {
+			meta.i32(6, parquetUTF8)
+			meta.begin(10) // LogicalType
+			meta.begin(1)  // STRING
+			meta.end()
+			meta.end()
+		}
+		meta.end()
+	}
+	var rows int64
+	for _, group := range p.groups {
+		rows += group.rows
+	}
+	meta.i64(3, rows)
+	meta.list(4, thriftStruct, len(p.groups))
+	for _, group := range p.groups {
+		meta.element()
+		meta.list(1, thriftStruct, len(group.chunks))
+		var size int64
+		for i, chunk := range group.chunks {
+			column := parquetColumns[i]
+			meta.element()
+			meta.i64(2, chunk.offset)
+			meta.begin(3)
+			meta.i32(1, column.typ)
+			meta.list(2, thriftI32, 2)
+			meta.varint(zigzag(parquetPlain))
+			meta.varint(zigzag(parquetRLE))
+			meta.list(3, thriftBinary, 1)
+			meta.varint(uint64(len(column.name)))
+			meta.b.WriteString(column.name)
+			meta.i32(4, 0) // UNCOMPRESSED
+			meta.i64(5, group.rows)
+			meta.i64(6, chunk.size)
+			meta.i64(7, chunk.size)
+			meta.i64(9, chunk.offset)
+			meta.end()
+			meta.end()
+			size += chunk.size
+		}
+		meta.i64(2, size)
+		meta.i64(3, group.rows)
+
+		// The lines are sorted, which lets readers skip row groups by range, unless they are keyed,
+		// when they are in the order of their keys instead
+		if !j.keyed() {
+			meta.list(4, thriftStruct, 1)
+			meta.element()
+			meta.i32(1, 0)
+			meta.bool(2, false)
+			meta.bool(3, false)
+			meta.end()
+		}
+		meta.end()
+	}
+	meta.binary(6, "github.com/veqryn/dedup")
+	meta.end()
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(meta.b.Len()))
+	meta.b.Write(length[:])
+	meta.b.WriteString(parquetMagic)
+	return j.writeParquet(w, meta.b.Bytes())
+}
+
+// writeParquet writes bytes of the Parquet file that are not rows
+func (j *job) writeParquet(w io.Writer, b []byte) error {
+	n, err := w.Write(b)
+	j.stats.BytesOut += uint64(n)
+	j.parquet.offset += int64(n)
+	return err
+}
+
+// writeParquetBytes writes the string as a PLAIN encoded BYTE_ARRAY value
+func writeParquetBytes(b *bytes.Buffer, s string) {
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(s)))
+	b.Write(length[:])
+	b.WriteString(s)
+}
+
+// writeParquetInt64 writes the number as a PLAIN encoded INT64 value
+func writeParquetInt64(b *bytes.Buffer, v uint64) {
+	var value [8]byte
+	binary.LittleEndian.PutUint64(value[:], v)
+	b.Write(value[:])
+}
+
+// encodeParquetLevels encodes definition levels of 0 or 1 as runs of the RLE encoding of Parquet,
+// with a bit width of 1
+func encodeParquetLevels(levels []byte) []byte {
+	var b []byte
+	var header [binary.MaxVarintLen64]byte
+	for i := 0; i < len(levels); {
+		run := 1
+		for i+run < len(levels) && levels[i+run] == levels[i] {
+			run++
+		}
+		b = append(b, header[:binary.PutUvarint(header[:], uint64(run)<<1)]...)
+		b = append(b, levels[i])
+		i += run
+	}
+	return b
+}
+
+// The types of the Thrift compact protocol used by the footer of FormatParquet
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs with the Thrift compact protocol, which the page headers and footer of
+// Parquet are written in. Fields must be written in order of their ids within each struct.
+type thriftWriter struct {
+	b    bytes.Buffer
+	last []int16
+}
+
+// field writes the header of a field, with its id as a delta from the last field of the struct
+func (t *thriftWriter) field(id int16, typ byte) {
+	if len(t.last) == 0 {
+		t.last = append(t.last, 0)
+	}
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.b.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.b.WriteByte(typ)
+		t.varint(zigzag(int64(id)))
+	}
+	*last = id
+}
+
+// i32 writes a 32-bit integer field
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+// i64 writes a 64-bit integer field
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+// bool writes a boolean field, whose value is its type
+func (t *thriftWriter) bool(id int16, v bool) {
+	typ := byte(thriftFalse)
+	if v {
+		typ = thriftTrue
+	}
+	t.field(id, typ)
+}
+
+// binary writes a string field
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.varint(uint64(len(s)))
+	t.b.WriteString(s)
+}
+
+// list writes the header of a list field of n elements of the type, which are written next
+func (t *thriftWriter) list(id int16, typ byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.b.WriteByte(byte(n)<<4 | typ)
+		return
+	}
+	t.b.WriteByte(0xf0 | typ)
+	t.varint(uint64(n))
+}
+
+// begin starts a struct field, whose fields are written next, until end
+func (t *thriftWriter) begin(id int16) {
+	t.field(id, thriftStruct)
+	t.element()
+}
+
+// element starts a struct that is an element of a list, whose fields are written next, until end
+func (t *thriftWriter) element() {
+	t.last = append(t.last, 0)
+}
+
+// end ends the current struct
+func (t *thriftWriter) end() {
+	t.b.WriteByte(0)
+	if len(t.last) > 0 {
+		t.last = t.last[:len(t.last)-1]
+	}
+}
+
+// varint writes an unsigned varint
+func (t *thriftWriter) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	t.b.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+// zigzag encodes a signed integer as an unsigned one, for a varint
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
@@ -0,0 +1,341 @@
+package dedup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunFormatParquet(t *testing.T) {
+	// Small row groups split the lines between several of them
+	for _, tc := range []struct {
+		tmpFileBytes  uint64
+		rowGroupBytes int
+		groups        int
+	}{
+		{tmpFileBytes: 1000, groups: 1},
+		{tmpFileBytes: 1, groups: 1},
+		{tmpFileBytes: 1000, rowGroupBytes: 20, groups: 3},
+		{tmpFileBytes: 4, rowGroupBytes: 1, groups: 5},
+	} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.parquet")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		input := NewSources(
+			Source{Name: "one", Reader: strings.NewReader("c\na\n")},
+			Source{Name: "two", Reader: strings.NewReader("a\nb\nc\nd\ne\na\n")},
+		)
+		opts := Options{TmpFileBytes: tc.tmpFileBytes, Format: FormatParquet, ParquetRowGroupBytes: tc.rowGroupBytes, VerifyWrites: true}
+		stats, err := Run(outFile, opts, input, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 5 || stats.BytesOut != uint64(len(content)) {
+			t.Fatalf("Unexpected stats with %+v: %+v", tc, stats)
+		}
+
+		groups, rows := readParquet(t, content)
+		if groups != tc.groups {
+			t.Fatalf("Expected %d row groups with %+v; Got: %d", tc.groups, tc, groups)
+		}
+		expected := []string{"a 3 one 2", "b 1 two 2", "c 2 one 1", "d 1 two 4", "e 1 two 5"}
+		if !reflect.DeepEqual(rows, expected) {
+			t.Fatalf("Unexpected rows with %+v: %q", tc, rows)
+		}
+	}
+
+	// Without Sources, no row has a source, and merged rows have no line number either
+	outFile, err := os.CreateTemp("", "dedup.test.*.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+	inFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(inFile.Name())
+	defer inFile.Close()
+	if _, err = inFile.WriteString("a\na\nb\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = inFile.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Merge(outFile, Options{Format: FormatParquet, VerifyWrites: true}, inFile); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(outFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, rows := readParquet(t, content); !reflect.DeepEqual(rows, []string{"a 2 <nil> <nil>", "b 1 <nil> <nil>"}) {
+		t.Fatalf("Unexpected merged rows: %q", rows)
+	}
+
+	if _, err = Run(nil, Options{Format: FormatParquet, Partitions: 2}, strings.NewReader("a\n"), nil); err == nil {
+		t.Fatal("Expected an error with partitions")
+	}
+}
+
+func TestParquetSortingColumns(t *testing.T) {
+	// Keyed rows are in the order of their keys rather than of their lines, so they are not declared
+	// sorted by line
+	for i, tc := range []struct {
+		opts   Options
+		rows   []string
+		sorted bool
+	}{
+		{opts: Options{}, rows: []string{"y\tb 1", "z\ta 1"}, sorted: true},
+		{opts: Options{KeyFields: []FieldRange{{From: 2, To: 2}}}, rows: []string{"z\ta 1", "y\tb 1"}, sorted: false},
+		{opts: Options{KeyFields: []FieldRange{{From: 2, To: 2}}, WriteKeys: true}, rows: []string{"a 1", "b 1"}, sorted: true},
+		{opts: Options{KeyPrefixBytes: 1}, rows: []string{"y\tb 1", "z\ta 1"}, sorted: false},
+	} {
+		outFile, err := os.Create(filepath.Join(t.TempDir(), "out.parquet"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer outFile.Close()
+		tc.opts.Format = FormatParquet
+		if _, err = Run(outFile, tc.opts, strings.NewReader("z\ta\ny\tb\n"), nil); err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, rows := readParquet(t, content)
+		for i, row := range rows {
+			// Without the source and line number
+			rows[i] = row[:strings.LastIndex(row[:strings.LastIndex(row, " ")], " ")]
+		}
+		if !reflect.DeepEqual(rows, tc.rows) {
+			t.Fatalf("Unexpected rows of case %d: %q", i, rows)
+		}
+		footerLength := int(binary.LittleEndian.Uint32(content[len(content)-8:]))
+		meta, _ := readThriftStruct(t, content[len(content)-8-footerLength:len(content)-8])
+		for _, group := range meta[4].([]interface{}) {
+			if _, sorted := group.(map[int16]interface{})[4]; sorted != tc.sorted {
+				t.Fatalf("Expected the row groups of case %d to be declared sorted %t", i, tc.sorted)
+			}
+		}
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestParquetGolden(t *testing.T) {
+	// Every byte is pinned down, so that a change to what other implementations read is on purpose,
+	// and made along with the golden file by running the tests with -update
+	content := runGolden(t, Options{TmpFileBytes: 1000, Format: FormatParquet, ParquetRowGroupBytes: 20})
+	checkGolden(t, "golden.parquet", content)
+	groups, rows := readParquet(t, content)
+	if expected := []string{"a 3 one 2", "b 1 two 2", "c 2 one 1", "d 1 two 4", "e 1 two 5"}; groups != 3 || !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("Expected 3 row groups of rows %q; Got: %d of %q", expected, groups, rows)
+	}
+}
+
+// runGolden runs the lines of two sources, with duplicates within and between them, and returns the
+// output written with the options
+func runGolden(t *testing.T, opts Options) []byte {
+	t.Helper()
+	outFile, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outFile.Close()
+	input := NewSources(
+		Source{Name: "one", Reader: strings.NewReader("c\na\n")},
+		Source{Name: "two", Reader: strings.NewReader("a\nb\nc\nd\ne\na\n")},
+	)
+	if _, err = Run(outFile, opts, input, nil); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(outFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+// checkGolden compares the content to the golden file of the name in testdata, or rewrites it with -update
+func checkGolden(t *testing.T, name string, content []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, expected) {
+		t.Fatalf("Expected the bytes of %s; Got:\n%x", path, content)
+	}
+}
+
+// readParquet reads the footer of a Parquet file written by FormatParquet, and the pages it points
+// to, and returns the number of row groups and each row, with its columns separated by spaces
+func readParquet(t *testing.T, content []byte) (int, []string) {
+	t.Helper()
+	if !bytes.HasPrefix(content, []byte(parquetMagic)) || !bytes.HasSuffix(content, []byte(parquetMagic)) {
+		t.Fatalf("Expected the file to start and end with %s", parquetMagic)
+	}
+	footerLength := int(binary.LittleEndian.Uint32(content[len(content)-8:]))
+	footer := content[len(content)-8-footerLength : len(content)-8]
+	meta, n := readThriftStruct(t, footer)
+	if n != len(footer) {
+		t.Fatalf("Expected the footer to be %d bytes; Got: %d", len(footer), n)
+	}
+
+	var names []string
+	for _, element := range meta[2].([]interface{}) {
+		names = append(names, string(element.(map[int16]interface{})[4].([]byte)))
+	}
+	if !reflect.DeepEqual(names, []string{"schema", "line", "count", "source", "line_number"}) {
+		t.Fatalf("Unexpected schema: %v", names)
+	}
+
+	var rows []string
+	var total int64
+	groups := meta[4].([]interface{})
+	for _, g := range groups {
+		group := g.(map[int16]interface{})
+		numRows := group[3].(int64)
+		total += numRows
+		columns := make([][]string, numRows)
+		for i, c := range group[1].([]interface{}) {
+			columnMeta := c.(map[int16]interface{})[3].(map[int16]interface{})
+			offset := columnMeta[9].(int64)
+			header, n := readThriftStruct(t, content[offset:])
+			size := header[2].(int64)
+			if int64(n)+size != columnMeta[6].(int64) {
+				t.Fatalf("Expected column %d to be %d bytes; Got: %d", i, columnMeta[6], int64(n)+size)
+			}
+			if values := header[5].(map[int16]interface{})[1].(int64); values != numRows {
+				t.Fatalf("Expected %d values in column %d; Got: %d", numRows, i, values)
+			}
+			page := content[offset+int64(n) : offset+int64(n)+size]
+			defined := make([]bool, numRows)
+			for r := range defined {
+				defined[r] = true
+			}
+			if parquetColumns[i].optional {
+				length := binary.LittleEndian.Uint32(page)
+				levels := page[4 : 4+length]
+				page = page[4+length:]
+				r := 0
+				for len(levels) > 0 {
+					run, n := binary.Uvarint(levels)
+					for k := uint64(0); k < run>>1; k++ {
+						defined[r] = levels[n] == 1
+						r++
+					}
+					levels = levels[n+1:]
+				}
+			}
+			for r := range columns {
+				switch {
+				case !defined[r]:
+					columns[r] = append(columns[r], "<nil>")
+				case parquetColumns[i].typ == parquetByteArray:
+					length := binary.LittleEndian.Uint32(page)
+					columns[r] = append(columns[r], string(page[4:4+length]))
+					page = page[4+length:]
+				default:
+					columns[r] = append(columns[r], fmt.Sprint(binary.LittleEndian.Uint64(page)))
+					page = page[8:]
+				}
+			}
+		}
+		for _, columns := range columns {
+			rows = append(rows, strings.Join(columns, " "))
+		}
+	}
+	if meta[3].(int64) != total {
+		t.Fatalf("Expected %d rows in the footer; Got: %d", total, meta[3])
+	}
+	return len(groups), rows
+}
+
+// readThriftStruct reads a struct of the Thrift compact protocol, and returns its fields by id, and
+// how many bytes it was
+func readThriftStruct(t *testing.T, b []byte) (map[int16]interface{}, int) {
+	t.Helper()
+	fields := make(map[int16]interface{})
+	pos := 0
+	var id int16
+	for {
+		header := b[pos]
+		pos++
+		if header == 0 {
+			return fields, pos
+		}
+		typ := header & 0x0f
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, n := binary.Varint(b[pos:])
+			id = int16(v)
+			pos += n
+		}
+		var n int
+		fields[id], n = readThriftValue(t, b[pos:], typ)
+		pos += n
+	}
+}
+
+// readThriftValue reads a value of the type of the Thrift compact protocol, and returns it and how
+// many bytes it was
+func readThriftValue(t *testing.T, b []byte, typ byte) (interface{}, int) {
+	t.Helper()
+	switch typ {
+	case thriftTrue:
+		return true, 0
+	case thriftFalse:
+		return false, 0
+	case thriftI32, thriftI64:
+		v, n := binary.Varint(b)
+		return v, n
+	case thriftBinary:
+		length, n := binary.Uvarint(b)
+		return b[n : n+int(length)], n + int(length)
+	case thriftStruct:
+		return readThriftStruct(t, b)
+	case thriftList:
+		size, elementType := int(b[0]>>4), b[0]&0x0f
+		pos := 1
+		if size == 15 {
+			v, n := binary.Uvarint(b[pos:])
+			size = int(v)
+			pos += n
+		}
+		var list []interface{}
+		for i := 0; i < size; i++ {
+			v, n := readThriftValue(t, b[pos:], elementType)
+			list = append(list, v)
+			pos += n
+		}
+		return list, pos
+	default:
+		t.Fatalf("Unexpected Thrift type: %d", typ)
+		return nil, 0
+	}
+}
@@ -0,0 +1,515 @@
+package dedup
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// partitionBatchSize is how many lines are handed to a partition worker at once
+const partitionBatchSize = 1024
+
+// partitionLine is a line read from the input, with the text written for it if keyed, and the
+// ordinal it was read at
+type partitionLine struct {
+	line    string
+	text    string
+	ordinal uint64
+}
+
+// partitionResult is what a partition worker hands back to the reading goroutine: the duplicates
+// it found, for the callbacks, and the set of a full chunk that needs writing, if any
+type partitionResult struct {
+	duplicates uint64
+	dups       []partitionLine
+	set        map[string]struct{}
+	meta       map[string]lineMeta
+	final      bool
+}
+
+// partition is the set of the lines of a hash partition, that is built by its own worker goroutine
+type partition struct {
+	in    chan []partitionLine
+	batch []partitionLine
+	set   map[string]struct{}
+	meta  map[string]lineMeta
+	used  uint64
+
+	// The lengths of the lines of the partition, with AdaptiveChunks
+	lengths *lineLengths
+}
+
+// partitionBuilder spreads the lines read across partition workers by their hash, so that adding
+// lines to sets, and finding the duplicates in them, uses as many cores as there are workers.
+// Every line is always in the same partition, so the duplicates of a chunk are always found by one
+// worker. Each partition gets an equal share of TmpFileBytes, and spills its set on its own.
+// The results of the workers, such as full sets, are handled on the reading goroutine, so that the
+// job is only ever updated by it, and callbacks are never called concurrently.
+type partitionBuilder struct {
+	j       *job
+	cw      *chunkWriter
+	parts   []*partition
+	budget  uint64
+	results chan partitionResult
+	wg      sync.WaitGroup
+	closed  bool
+
+	// The last set of every partition, once the input has been read
+	finals []partitionResult
+}
+
+// newPartitionBuilder starts the configured number of partition workers, up to Workers, or returns nil
+// if the lines are added to a single set on the reading goroutine. Full sets are written by the chunk writer.
+func (j *job) newPartitionBuilder(cw *chunkWriter) *partitionBuilder {
+	workers := j.limitWorkers(j.opts.BuildWorkers)
+	if workers <= 1 {
+		return nil
+	}
+	pb := &partitionBuilder{
+		j:       j,
+		cw:      cw,
+		parts:   make([]*partition, workers),
+		budget:  j.lineBudget() / uint64(workers),
+		results: make(chan partitionResult, workers),
+	}
+	if pb.budget == 0 {
+		pb.budget = 1
+	}
+	for i := range pb.parts {
+		p := &partition{in: make(chan []partitionLine, 1), set: make(map[string]struct{}, 1024), lengths: j.newLineLengths()}
+		pb.parts[i] = p
+		pb.wg.Add(1)
+		go func() {
+			defer pb.wg.Done()
+			pb.build(p)
+		}()
+	}
+	go func() {
+		pb.wg.Wait()
+		close(pb.results)
+	}()
+	return pb
+}
+
+// partitionOf returns the index of the partition of the line
+func partitionOf(line string, partitions int) int {
+	h := fnv.New32a()
+	h.Write([]byte(line))
+	return int(h.Sum32() % uint32(partitions))
+}
+
+// add hands the line to the worker of its partition, in batches
+func (pb *partitionBuilder) add(line, text string, ordinal uint64) error {
+	p := pb.parts[partitionOf(line, len(pb.parts))]
+	p.batch = append(p.batch, partitionLine{line: line, text: text, ordinal: ordinal})
+	if len(p.batch) < partitionBatchSize {
+		return nil
+	}
+	return pb.send(p)
+}
+
+// send hands the batch of the partition to its worker, handling the results of the workers while
+// it waits, so that neither ever waits on the other
+func (pb *partitionBuilder) send(p *partition) error {
+	for {
+		select {
+		case p.in <- p.batch:
+			p.batch = make([]partitionLine, 0, partitionBatchSize)
+			return nil
+		case r := <-pb.results:
+			if err := pb.handle(r); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle counts the duplicates a worker found, calls the callbacks for them, and writes full sets
+func (pb *partitionBuilder) handle(r partitionResult) error {
+	j := pb.j
+	j.stats.LinesDuplicate += r.duplicates
+	for _, dup := range r.dups {
+		if err := j.mapLine(dup.ordinal, 0); err != nil {
+			return err
+		}
+		if err := j.duplicate(dup.line, dup.ordinal+1); err != nil {
+			return err
+		}
+	}
+	if r.final {
+		pb.finals = append(pb.finals, r)
+		return nil
+	}
+	if r.set != nil {
+		return pb.cw.write(r.set, r.meta, 0)
+	}
+	return nil
+}
+
+// finish hands the last batches to the workers, and waits for them to finish. If no partition has
+// written a chunk, it returns the union of their sets and metas, because everything fit in memory.
+// Otherwise the remaining sets are written as chunks, and it returns an empty set.
+func (pb *partitionBuilder) finish() (map[string]struct{}, map[string]lineMeta, error) {
+	for _, p := range pb.parts {
+		if len(p.batch) > 0 {
+			if err := pb.send(p); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	pb.close()
+	for r := range pb.results {
+		if err := pb.handle(r); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(pb.cw.chunks) > 0 {
+		for _, r := range pb.finals {
+			if len(r.set) == 0 {
+				continue
+			}
+			if err := pb.cw.write(r.set, r.meta, 0); err != nil {
+				return nil, nil, err
+			}
+		}
+		return nil, nil, nil
+	}
+
+	// The partitions never have a line in common, so their union has the sizes of all of them
+	var size, metaSize int
+	for _, r := range pb.finals {
+		size += len(r.set)
+		metaSize += len(r.meta)
+	}
+	set := make(map[string]struct{}, size)
+	var meta map[string]lineMeta
+	if metaSize > 0 {
+		meta = make(map[string]lineMeta, metaSize)
+	}
+	for _, r := range pb.finals {
+		for line := range r.set {
+			set[line] = struct{}{}
+		}
+		for line, m := range r.meta {
+			meta[line] = m
+		}
+	}
+	return set, meta, nil
+}
+
+// close stops the workers once they have finished their batches. It is safe to call more than once.
+func (pb *partitionBuilder) close() {
+	if pb == nil || pb.closed {
+		return
+	}
+	pb.closed = true
+	for _, p := range pb.parts {
+		close(p.in)
+	}
+}
+
+// stop stops the workers without waiting for them, discarding their results, after an error
+func (pb *partitionBuilder) stop() {
+	if pb == nil {
+		return
+	}
+	pb.close()
+	go func() {
+		for range pb.results {
+		}
+	}()
+}
+
+// build adds the batches of lines to the set of the partition, spilling it once it is full,
+// then hands back its last set once there are no more batches. It runs on the worker goroutine,
+// and only reads the job.
+func (pb *partitionBuilder) build(p *partition) {
+	j := pb.j
+	delimLen := uint64(len(j.delim))
+	withDups := j.mapping() || j.opts.OnDuplicate != nil || j.opts.OnDuplicateAt != nil
+	for batch := range p.in {
+		var r partitionResult
+		for _, l := range batch {
+			// If the length of the set did not increase, the line is a duplicate of one in this chunk
+			previousLen := len(p.set)
+			p.set[l.line] = struct{}{}
+			used := j.duplicateBytes()
+			if len(p.set) > previousLen {
+				p.meta = j.trackLine(p.meta, l.line, l.text, l.ordinal)
+				used = uint64(len(l.line)+len(l.text)) + delimLen
+				p.lengths.add(used)
+			} else {
+				r.duplicates++
+				p.meta = j.trackDuplicate(p.meta, l.line, l.ordinal)
+				if withDups {
+					r.dups = append(r.dups, l)
+				}
+			}
+
+			// Hand back the set once it is full, and start a new one
+			p.used += used
+			if p.used >= p.lengths.threshold(pb.budget) {
+				pb.results <- partitionResult{set: p.set, meta: p.meta}
+				p.set = make(map[string]struct{}, 1024)
+				p.meta = nil
+				p.used = 0
+			}
+		}
+		if r.duplicates > 0 {
+			pb.results <- r
+		}
+	}
+	pb.results <- partitionResult{set: p.set, meta: p.meta, final: true}
+}
+
+// rangeSamplesPerPartition is how many lines of the first chunk are sampled for each partition, to
+// choose the ranges of the partitions by ShardRange
+const rangeSamplesPerPartition = 1000
+
+// validatePartitions returns an error if the options can not be used with Partitions
+func validatePartitions(opts Options) error {
+	switch opts.PartitionBy {
+	case "", ShardHash, ShardRange:
+	default:
+		return fmt.Errorf("unknown partition mode: %q", opts.PartitionBy)
+	}
+	if opts.Partitions <= 1 {
+		return nil
+	}
+	if opts.Limit > 0 {
+		return fmt.Errorf("a limit is not supported with partitions")
+	}
+	if opts.OnLineMapped != nil {
+		return fmt.Errorf("mapping lines is not supported with partitions")
+	}
+	return nil
+}
+
+// partitions returns how many partitions the chunks are split into as they are spilled, which is 1
+// without Partitions
+func (j *job) partitions() int {
+	if j.opts.Partitions > 1 {
+		return j.opts.Partitions
+	}
+	return 1
+}
+
+// partitionKeys splits the sorted keys by their partition, keeping them sorted within each partition
+func (j *job) partitionKeys(keys []string) [][]string {
+	n := j.partitions()
+	if n == 1 {
+		return [][]string{keys}
+	}
+	parts := make([][]string, n)
+
+	// Each range is a contiguous run of the sorted keys, starting at its boundary
+	if j.opts.PartitionBy == ShardRange {
+		start := 0
+		for p, bound := range j.partitionBounds {
+			end := start + sort.SearchStrings(keys[start:], bound)
+			parts[p] = keys[start:end]
+			start = end
+		}
+		parts[len(j.partitionBounds)] = keys[start:]
+		return parts
+	}
+
+	for _, key := range keys {
+		p := partitionOf(key, n)
+		parts[p] = append(parts[p], key)
+	}
+	return parts
+}
+
+// partitionOrder returns the sorted keys in the order they are written with Partitions: the sorted
+// keys of each partition in turn, which are still sorted by ShardRange
+func (j *job) partitionOrder(keys []string) []string {
+	if j.partitions() == 1 || j.opts.PartitionBy == ShardRange {
+		return keys
+	}
+	ordered := make([]string, 0, len(keys))
+	for _, part := range j.partitionKeys(keys) {
+		ordered = append(ordered, part...)
+	}
+	return ordered
+}
+
+// sampleSet returns up to size lines of the set, sorted. The order of a map is random, so its first
+// lines are a sample of all of them.
+func sampleSet(set map[string]struct{}, size int) []string {
+	if size > len(set) {
+		size = len(set)
+	}
+	sample := make([]string, 0, size)
+	for line := range set {
+		if len(sample) == size {
+			break
+		}
+		sample = append(sample, line)
+	}
+	sort.Strings(sample)
+	return sample
+}
+
+// splitRanges chooses the ranges of the partitions by ShardRange from a sorted sample of the set of the
+// first chunk, unless they have already been chosen. Every later chunk is split by the same ranges,
+// whether its lines are like those of the first chunk or not, so an input that is already sorted is
+// partitioned unevenly.
+func (j *job) splitRanges(sampleSet func(size int) []string) {
+	n := j.partitions()
+	if n == 1 || j.opts.PartitionBy != ShardRange || j.partitionBounds != nil {
+		return
+	}
+	sample := sampleSet(n * rangeSamplesPerPartition)
+
+	// Each boundary is the first line of a partition after the first, so a partition can be empty
+	// if there are fewer distinct lines than partitions
+	j.partitionBounds = make([]string, 0, n-1)
+	for p := 1; p < n; p++ {
+		at := len(sample) * p / n
+		if at < len(sample) && (len(j.partitionBounds) == 0 || j.partitionBounds[len(j.partitionBounds)-1] != sample[at]) {
+			j.partitionBounds = append(j.partitionBounds, cloneLine(sample[at]))
+		}
+	}
+}
+
+// mergePartitions merges the chunks of each partition on a goroutine of its own, then concatenates
+// the partitions into the output writer, in order. A line is only ever in the chunks of its own
+// partition, so the partitions never need to be merged against each other. With RunShards, each
+// partition is merged straight into the shard of the same index, which has the same lines.
+func (j *job) mergePartitions(out io.Writer, chunks []*os.File) error {
+	// Each partition is merged by a job of its own, that holds the mutex while calling the callbacks,
+	// so that they are still never called concurrently
+	var mu sync.Mutex
+	opts := lockCallbacks(j.opts, &mu)
+	n := j.partitions()
+	subs := make([]*job, n)
+	partChunks := make([][]*os.File, n)
+	outs := make([]io.Writer, n)
+	var partFiles []*os.File
+	defer func() {
+		for _, f := range partFiles {
+			f.Close()
+			removeFile(f.Name())
+		}
+	}()
+	if j.shards != nil && j.opts.PartitionBy == ShardRange {
+		// Lines passed through after the merge go to the shard of their range too
+		j.shards.boundaries = j.partitionBounds
+	}
+	for p := range subs {
+		subs[p] = &job{reporter: j.reporter, opts: opts, delim: j.delim, dir: j.dir,
+			sources: j.sources, sourceStarts: j.sourceStarts, readLimit: j.readLimit, writeLimit: j.writeLimit, tempCipher: j.tempCipher}
+		subs[p].stats.TmpLines = j.partitionLines[p]
+		for i := p; i < len(chunks); i += n {
+			partChunks[p] = append(partChunks[p], chunks[i])
+			if sum, ok := j.chunkSums[chunks[i].Name()]; ok {
+				subs[p].addChunkSum(chunks[i].Name(), sum)
+			}
+			if longest, ok := j.chunkLongest[chunks[i].Name()]; ok {
+				subs[p].addChunkLongest(chunks[i].Name(), longest)
+			}
+			if i < len(j.metaFiles) {
+				subs[p].metaFiles = append(subs[p].metaFiles, j.metaFiles[i])
+			}
+		}
+
+		switch {
+		case j.shards != nil:
+			outs[p] = j.shards.writers[p]
+		case out == io.Discard:
+			outs[p] = io.Discard
+		default:
+			partFile, err := CreateTemp(j.dir, "partition.*.log")
+			if err != nil {
+				return err
+			}
+			partFiles = append(partFiles, partFile)
+			outs[p] = j.limitWriter(partFile)
+		}
+	}
+
+	// No more than Workers partitions are merged at once
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	workers := make(chan struct{}, j.workers())
+	for p := range subs {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+			errs[p] = subs[p].mergeSpilled(outs[p], partChunks[p])
+		}(p)
+	}
+	wg.Wait()
+	for p, sub := range subs {
+		j.stats.LinesUnique += sub.stats.LinesUnique
+		j.stats.LinesDuplicate += sub.stats.LinesDuplicate
+		j.stats.BytesOut += sub.stats.BytesOut
+		j.scriptLines += sub.scriptLines
+		if j.shards != nil {
+			j.shards.lines[p] += sub.stats.LinesUnique
+		}
+		if errs[p] != nil {
+			return errs[p]
+		}
+	}
+
+	// Every partition is sorted, so they only need to be concatenated. By ShardRange, every line of
+	// a partition sorts before those of the next one, so the output is still sorted.
+	for _, partFile := range partFiles {
+		if _, err := partFile.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, partFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockCallbacks returns the options with the callbacks that are called while merging wrapped to
+// hold the mutex while they are called
+func lockCallbacks(opts Options, mu *sync.Mutex) Options {
+	if onDuplicate := opts.OnDuplicate; onDuplicate != nil {
+		opts.OnDuplicate = func(line string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return onDuplicate(line)
+		}
+	}
+	if onDuplicateAt := opts.OnDuplicateAt; onDuplicateAt != nil {
+		opts.OnDuplicateAt = func(line string, pos Position) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return onDuplicateAt(line, pos)
+		}
+	}
+	if onDuplicateCount := opts.OnDuplicateCount; onDuplicateCount != nil {
+		opts.OnDuplicateCount = func(line string, count uint64) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return onDuplicateCount(line, count)
+		}
+	}
+	if onSketchCount := opts.OnSketchCount; onSketchCount != nil {
+		opts.OnSketchCount = func(line string, count uint64) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return onSketchCount(line, count)
+		}
+	}
+	if onAudit := opts.OnAudit; onAudit != nil {
+		opts.OnAudit = func(d Duplicate) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return onAudit(d)
+		}
+	}
+	return opts
+}
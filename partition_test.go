@@ -0,0 +1,167 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRunBuildWorkers(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&input, "%03d\n", (i*7919)%500)
+	}
+
+	// The same output, first occurrences, and duplicates as on the reading goroutine, whether
+	// everything fits in memory or not
+	run := func(buildWorkers int, tmpFileBytes uint64) (string, []string, Stats) {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		var dups []string
+		opts := Options{
+			TmpFileBytes: tmpFileBytes,
+			BuildWorkers: buildWorkers,
+			Workers:      buildWorkers,
+			Format:       FormatJSON,
+			OnProgress:   func(Progress) {},
+			OnEvent:      func(Event) {},
+			OnDuplicate: func(line string) error {
+				dups = append(dups, line)
+				return nil
+			},
+		}
+		stats, err := Run(outFile, opts, strings.NewReader(input.String()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(dups)
+		return string(content), dups, stats
+	}
+
+	expected, expectedDups, _ := run(1, 100000)
+	for _, tmpFileBytes := range []uint64{100000, 200, 1} {
+		content, dups, stats := run(4, tmpFileBytes)
+		if content != expected || !reflect.DeepEqual(dups, expectedDups) {
+			t.Fatalf("Unexpected output with %d tmp file bytes:\n%s", tmpFileBytes, content)
+		}
+		if stats.LinesUnique != 500 || stats.LinesDuplicate != 2500 || (tmpFileBytes < 1000 && stats.Chunks < 4) {
+			t.Fatalf("Unexpected stats with %d tmp file bytes: %+v", tmpFileBytes, stats)
+		}
+	}
+}
+
+func TestRunPartitions(t *testing.T) {
+	var input strings.Builder
+	counts := make(map[string]uint64)
+	for i := 0; i < 2000; i++ {
+		line := fmt.Sprintf("%03d", (i*7919)%300)
+		input.WriteString(line + "\n")
+		counts[line]++
+	}
+
+	// The sorted lines of each partition in turn
+	var expected []string
+	for p := 0; p < 3; p++ {
+		for i := 0; i < 300; i++ {
+			if line := fmt.Sprintf("%03d", i); partitionOf(line, 3) == p {
+				expected = append(expected, line)
+			}
+		}
+	}
+
+	// Whether everything fits in memory or not, and with the duplicates counted by every partition
+	for _, tmpFileBytes := range []uint64{100000, 200, 1} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		var duplicated int
+		opts := Options{
+			TmpFileBytes: tmpFileBytes,
+			Partitions:   3,
+			OnProgress:   func(Progress) {},
+			OnEvent:      func(Event) {},
+			OnDuplicateCount: func(line string, count uint64) error {
+				if count != counts[line] {
+					t.Errorf("Expected %d occurrences of %q; Got: %d", counts[line], line, count)
+				}
+				duplicated++
+				return nil
+			},
+		}
+		stats, err := Run(outFile, opts, strings.NewReader(input.String()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 300 || stats.LinesDuplicate != 1700 || duplicated != 300 {
+			t.Fatalf("Unexpected stats with %d tmp file bytes: %+v", tmpFileBytes, stats)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n"); !reflect.DeepEqual(lines, expected) {
+			t.Fatalf("Unexpected output with %d tmp file bytes:\n%s", tmpFileBytes, content)
+		}
+	}
+
+	if _, err := Run(nil, Options{Partitions: 2, Limit: 1, DryRun: true}, strings.NewReader("a\n"), nil); err == nil {
+		t.Fatal("Expected an error with a limit")
+	}
+}
+
+func TestRunPartitionsByRange(t *testing.T) {
+	var input strings.Builder
+	var expected []string
+	for i := 0; i < 300; i++ {
+		expected = append(expected, fmt.Sprintf("%03d", i))
+	}
+	for i := 0; i < 2000; i++ {
+		input.WriteString(expected[(i*7919)%300] + "\n")
+	}
+
+	// The ranges are concatenated in order, so the output is fully sorted
+	for _, tmpFileBytes := range []uint64{100000, 200, 1} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		opts := Options{TmpFileBytes: tmpFileBytes, Partitions: 3, PartitionBy: ShardRange}
+		stats, err := Run(outFile, opts, strings.NewReader(input.String()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 300 || stats.LinesDuplicate != 1700 {
+			t.Fatalf("Unexpected stats with %d tmp file bytes: %+v", tmpFileBytes, stats)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n"); !reflect.DeepEqual(lines, expected) {
+			t.Fatalf("Unexpected output with %d tmp file bytes:\n%s", tmpFileBytes, content)
+		}
+	}
+
+	if _, err := Run(nil, Options{Partitions: 2, PartitionBy: "none", DryRun: true}, strings.NewReader("a\n"), nil); err == nil {
+		t.Fatal("Expected an error with an unknown partition mode")
+	}
+}
@@ -0,0 +1,61 @@
+package dedup
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// The buffers of every chunk of a run are the same size, and a run can write and merge hundreds
+// of chunks, so their buffers are reused rather than allocated for each chunk. A pooled buffer that
+// is the wrong size, because it is from a run with other options, is dropped for a new one.
+var (
+	writerPool sync.Pool // *bufio.Writer, for writing chunk and meta files
+	bufferPool sync.Pool // *[]byte, the initial buffers of the scanners of chunks being merged
+	keysPool   sync.Pool // *[]string, the sorted lines of a chunk
+)
+
+// getWriter returns a writer buffering size bytes of writes to w, reused from the pool if possible
+func getWriter(w io.Writer, size int) *bufio.Writer {
+	if bw, ok := writerPool.Get().(*bufio.Writer); ok && bw.Size() == size {
+		bw.Reset(w)
+		return bw
+	}
+	return bufio.NewWriterSize(w, size)
+}
+
+// putWriter returns a writer that is no longer used to the pool, without what it writes to
+func putWriter(bw *bufio.Writer) {
+	bw.Reset(nil)
+	writerPool.Put(bw)
+}
+
+// getBuffer returns an empty buffer with a capacity of size bytes, reused from the pool if possible
+func getBuffer(size int) []byte {
+	if b, ok := bufferPool.Get().(*[]byte); ok && cap(*b) == size {
+		return (*b)[:0]
+	}
+	return make([]byte, 0, size)
+}
+
+// putBuffer returns a buffer that is no longer used to the pool
+func putBuffer(b []byte) {
+	bufferPool.Put(&b)
+}
+
+// getKeys returns a slice of n lines, reused from the pool if it is large enough
+func getKeys(n int) []string {
+	if keys, ok := keysPool.Get().(*[]string); ok && cap(*keys) >= n {
+		return (*keys)[:n]
+	}
+	return make([]string, n)
+}
+
+// putKeys returns the lines of a chunk that has been written to the pool, clearing them first so
+// that the pool does not keep them, or the set they are from, alive
+func putKeys(keys []string) {
+	for i := range keys {
+		keys[i] = ""
+	}
+	keysPool.Put(&keys)
+}
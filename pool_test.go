@@ -0,0 +1,44 @@
+package dedup
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPools(t *testing.T) {
+	// Writers are reset to write to their new writer, and only reused at the same size
+	var first, second bytes.Buffer
+	bw := getWriter(&first, 1024)
+	bw.WriteString("a")
+	putWriter(bw)
+	bw = getWriter(&second, 1024)
+	if bw.Size() != 1024 || bw.Buffered() != 0 {
+		t.Fatalf("Unexpected writer: %d bytes, %d buffered", bw.Size(), bw.Buffered())
+	}
+	bw.WriteString("b")
+	if err := bw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if first.Len() != 0 || second.String() != "b" {
+		t.Fatalf("Unexpected writes: %q, %q", first.String(), second.String())
+	}
+	putWriter(bw)
+	if bw = getWriter(&second, 2048); bw.Size() != 2048 {
+		t.Fatalf("Expected a writer of 2048 bytes; Got: %d", bw.Size())
+	}
+
+	putBuffer(make([]byte, 10, 64))
+	if b := getBuffer(128); len(b) != 0 || cap(b) != 128 {
+		t.Fatalf("Unexpected buffer: %d of %d bytes", len(b), cap(b))
+	}
+
+	// The lines given back are cleared, so that they can be freed
+	keys := []string{"a", "b", "c"}
+	putKeys(keys)
+	if keys[0] != "" || keys[2] != "" {
+		t.Fatalf("Expected the keys to be cleared; Got: %q", keys)
+	}
+	if got := getKeys(5); len(got) != 5 {
+		t.Fatalf("Expected 5 keys; Got: %d", len(got))
+	}
+}
@@ -0,0 +1,100 @@
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// defaultPostgresBatchLines is how many unique lines each COPY of FormatPostgres holds, when not
+// configured
+const defaultPostgresBatchLines = 100000
+
+// postgresBatch is the temporary table each batch of FormatPostgres is copied into, before its lines
+// are inserted into the table
+const postgresBatch = `"dedup_batch"`
+
+// postgresColumns are the columns of the table of FormatPostgres, in the order each row is copied
+const postgresColumns = "line, count, source, line_number"
+
+// postgresStart returns the lines starting the script of FormatPostgres, up to the COPY of the first batch
+func (j *job) postgresStart() string {
+	table := j.table()
+	return "\\set ON_ERROR_STOP on\n" +
+		"BEGIN;\n" +
+		"CREATE TABLE IF NOT EXISTS " + table + " (line text NOT NULL, count bigint NOT NULL, source text, line_number bigint);\n" +
+		"CREATE UNIQUE INDEX IF NOT EXISTS " + quoteIdentifier(j.tableName()+"_line") + " ON " + table + " (line);\n" +
+		"CREATE TEMPORARY TABLE " + postgresBatch + " (line text, count bigint, source text, line_number bigint) ON COMMIT DROP;\n" +
+		"COPY " + postgresBatch + " (" + postgresColumns + ") FROM STDIN;\n"
+}
+
+// postgresInsert returns the lines ending the COPY of a batch, and inserting its lines into the table,
+// unless they are already there
+func (j *job) postgresInsert() string {
+	return "\\.\n" +
+		"INSERT INTO " + j.table() + " (" + postgresColumns + ") SELECT " + postgresColumns + " FROM " + postgresBatch +
+		" ON CONFLICT (line) DO NOTHING;\n"
+}
+
+// postgresEnd returns the lines ending the script of FormatPostgres, after the last batch
+func (j *job) postgresEnd() string {
+	return j.postgresInsert() + "COMMIT;\n"
+}
+
+// writePostgresRecord writes the record as a row of the COPY of the current batch, after starting the
+// next batch if the current one is full. It returns how many bytes were written.
+func (j *job) writePostgresRecord(w *bufio.Writer, rec jsonRecord) (uint64, error) {
+	b := &j.record
+	b.Reset()
+	batchLines := j.opts.PostgresBatchLines
+	if batchLines == 0 {
+		batchLines = defaultPostgresBatchLines
+	}
+	if j.batchLines >= batchLines {
+		next := j.postgresInsert() + "TRUNCATE " + postgresBatch + ";\n" +
+			"COPY " + postgresBatch + " (" + postgresColumns + ") FROM STDIN;\n"
+		b.WriteString(next)
+		j.scriptLines += uint64(strings.Count(next, "\n"))
+		j.batchLines = 0
+	}
+	j.batchLines++
+
+	writeCopyText(b, rec.Line)
+	b.WriteByte('\t')
+	b.WriteString(strconv.FormatUint(rec.Count, 10))
+	b.WriteByte('\t')
+	if rec.Source == "" {
+		b.WriteString(`\N`)
+	} else {
+		writeCopyText(b, rec.Source)
+	}
+	b.WriteByte('\t')
+	if rec.LineNumber == 0 {
+		b.WriteString(`\N`)
+	} else {
+		b.WriteString(strconv.FormatUint(rec.LineNumber, 10))
+	}
+	b.WriteByte('\n')
+	n, err := w.Write(b.Bytes())
+	return uint64(n), err
+}
+
+// writeCopyText writes the string as a column of the text format of COPY, escaping the backslashes,
+// and the tabs and line breaks that would end the column or the row
+func writeCopyText(b *bytes.Buffer, s string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package dedup
+
+// prefixNodeSize is about how many bytes each node of a prefix set uses, besides its label
+const prefixNodeSize = 32
+
+// prefixNode is a node of a prefix set. Its label is the part of the lines below it that follows the
+// labels of its parents, and it is a line of the set itself if terminal. Its children are linked from
+// its first child through their next siblings, in order of the first byte of their labels.
+type prefixNode struct {
+	label    string
+	child    int32
+	next     int32
+	terminal bool
+}
+
+// prefixSet is a compact set of lines as a radix tree, used instead of a map with PrefixSet. Lines that share
+// a prefix, such as the URLs of a site, share the node of that prefix, so that each line only stores
+// the bytes that differ from the lines before it. The labels are copied into the arena of the set, and
+// split without copying as lines are added, and whole lines are only put back together once the set
+// is sorted to be written. The nodes are indexed in a single slice rather than being
+// pointers, so the garbage collector has few objects to track however many lines there are.
+type prefixSet struct {
+	nodes []prefixNode
+	arena lineArena
+	lines int
+	bytes uint64
+}
+
+// newPrefixSet returns an empty prefix set
+func newPrefixSet() *prefixSet {
+	return &prefixSet{nodes: []prefixNode{{child: -1, next: -1}}}
+}
+
+func (s *prefixSet) len() int {
+	return s.lines
+}
+
+// size returns about how many bytes of memory the set uses, for its labels and nodes
+func (s *prefixSet) size() uint64 {
+	return s.bytes + uint64(len(s.nodes))*prefixNodeSize
+}
+
+func (s *prefixSet) has(line []byte) bool {
+	n := int32(0)
+	for len(line) > 0 {
+		c := s.nodes[n].child
+		for c >= 0 && s.nodes[c].label[0] < line[0] {
+			c = s.nodes[c].next
+		}
+		if c < 0 || s.nodes[c].label[0] != line[0] {
+			return false
+		}
+		label := s.nodes[c].label
+		if len(label) > len(line) || label != string(line[:len(label)]) {
+			return false
+		}
+		n, line = c, line[len(label):]
+	}
+	return s.nodes[n].terminal
+}
+
+// add copies only the part of the line not shared with a line already in the set
+func (s *prefixSet) add(line string) bool {
+	n := int32(0)
+	for len(line) > 0 {
+		// Find the child with the same first byte, or where a new child with it is linked in
+		prev, c := int32(-1), s.nodes[n].child
+		for c >= 0 && s.nodes[c].label[0] < line[0] {
+			prev, c = c, s.nodes[c].next
+		}
+		if c < 0 || s.nodes[c].label[0] != line[0] {
+			leaf := s.newNode(s.arena.copyString(line), -1, c)
+			s.nodes[leaf].terminal = true
+			s.link(n, prev, leaf)
+			s.bytes += uint64(len(line))
+			s.lines++
+			return true
+		}
+
+		// If the line only shares part of the label of the child, the child is split at the end of
+		// what they share, with a new parent of the shared part
+		label := s.nodes[c].label
+		i := commonPrefixLength(label, line)
+		if i < len(label) {
+			parent := s.newNode(label[:i], c, s.nodes[c].next)
+			s.nodes[c].label, s.nodes[c].next = label[i:], -1
+			s.replace(n, prev, parent)
+			c = parent
+		}
+		n, line = c, line[i:]
+	}
+	if s.nodes[n].terminal {
+		return false
+	}
+	s.nodes[n].terminal = true
+	s.lines++
+	return true
+}
+
+// newNode appends a node to the set, and returns its index
+func (s *prefixSet) newNode(label string, child, next int32) int32 {
+	s.nodes = append(s.nodes, prefixNode{label: label, child: child, next: next})
+	return int32(len(s.nodes) - 1)
+}
+
+// link links the node in as the child of the parent after the sibling prev, or as the first child
+// if prev is negative
+func (s *prefixSet) link(parent, prev, node int32) {
+	if prev < 0 {
+		s.nodes[node].next, s.nodes[parent].child = s.nodes[parent].child, node
+	} else {
+		s.nodes[node].next, s.nodes[prev].next = s.nodes[prev].next, node
+	}
+}
+
+// replace links the node in instead of the child of the parent after the sibling prev, or instead of
+// the first child if prev is negative. The node must already be linked to the next sibling of the child.
+func (s *prefixSet) replace(parent, prev, node int32) {
+	if prev < 0 {
+		s.nodes[parent].child = node
+	} else {
+		s.nodes[prev].next = node
+	}
+}
+
+// walk calls fn with each line of the set in sorted order, as a buffer that is only valid until fn returns
+func (s *prefixSet) walk(fn func(line []byte)) {
+	var buf []byte
+	var visit func(n int32)
+	visit = func(n int32) {
+		for c := s.nodes[n].child; c >= 0; c = s.nodes[c].next {
+			length := len(buf)
+			buf = append(buf, s.nodes[c].label...)
+			if s.nodes[c].terminal {
+				fn(buf)
+			}
+			visit(c)
+			buf = buf[:length]
+		}
+	}
+	if s.nodes[0].terminal {
+		fn(buf)
+	}
+	visit(0)
+}
+
+// sortedKeys copies the lines into an arena of their own, as the labels of the set are not whole lines
+func (s *prefixSet) sortedKeys() []string {
+	slice := getKeys(s.lines)
+	var arena lineArena
+	i := 0
+	s.walk(func(line []byte) {
+		slice[i] = arena.copy(line)
+		i++
+	})
+	return slice
+}
+
+// sample spreads the lines evenly across the sorted lines of the set
+func (s *prefixSet) sample(size int) []string {
+	if size <= 0 || s.lines == 0 {
+		return nil
+	}
+	sample := make([]string, 0, size)
+	i := 0
+	s.walk(func(line []byte) {
+		if len(sample) < size && i >= len(sample)*s.lines/size {
+			sample = append(sample, string(line))
+		}
+		i++
+	})
+	return sample
+}
+
+// commonPrefixLength returns how many bytes the label and line start with that are the same
+func commonPrefixLength(label, line string) int {
+	i := 0
+	for i < len(label) && i < len(line) && label[i] == line[i] {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,86 @@
+package dedup
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPrefixSet(t *testing.T) {
+	s := newPrefixSet()
+	set := make(map[string]struct{})
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		// Short lines of few letters, so that lines are often prefixes of each other, or empty
+		b := make([]byte, r.Intn(6))
+		for k := range b {
+			b[k] = "abc"[r.Intn(3)]
+		}
+		_, exists := set[string(b)]
+		if s.has(b) != exists {
+			t.Fatalf("Expected %q to be in the set: %t", b, exists)
+		}
+		if added := s.add(string(b)); added == exists {
+			t.Fatalf("Expected %q to be added: %t", b, !exists)
+		}
+		set[string(b)] = struct{}{}
+	}
+	if s.len() != len(set) {
+		t.Fatalf("Expected %d lines; Got: %d", len(set), s.len())
+	}
+
+	expected := sortKeys(set)
+	keys := s.sortedKeys()
+	if strings.Join(keys, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected the sorted lines %q; Got: %q", expected, keys)
+	}
+
+	sample := s.sample(10)
+	if len(sample) != 10 || !sort.StringsAreSorted(sample) || sample[0] != expected[0] {
+		t.Fatalf("Unexpected sample: %q", sample)
+	}
+	if sample := s.sample(1000); len(sample) != len(set) {
+		t.Fatalf("Expected every line in the sample; Got: %d lines", len(sample))
+	}
+}
+
+func TestRunPrefixSet(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&input, "https://www.example.com/some/long/path/to/a/page/%d\n", i%500)
+	}
+
+	// The shared prefix of the lines is stored once, so fewer chunks are needed
+	var outputs [2]string
+	var chunks [2]int
+	for i, prefixSet := range []bool{false, true} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		stats, err := Run(outFile, Options{TmpFileBytes: 5000, PrefixSet: prefixSet}, strings.NewReader(input.String()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 500 || stats.LinesDuplicate != 1500 {
+			t.Fatalf("Expected 500 unique lines; Got: %+v", stats)
+		}
+		b, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs[i], chunks[i] = string(b), stats.Chunks
+	}
+	if outputs[1] != outputs[0] {
+		t.Fatal("Expected the same output with a prefix set")
+	}
+	if chunks[1] >= chunks[0] {
+		t.Fatalf("Expected fewer chunks with a prefix set; Got: %d, then %d", chunks[0], chunks[1])
+	}
+}
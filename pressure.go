@@ -0,0 +1,69 @@
+package dedup
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// pressureWindow is the least time the garbage collection pauses of the process are measured over,
+// so that a single pause right after a check does not count as most of the time since
+const pressureWindow = time.Second
+
+// pressureCheckBytes is how much the set being read grows between checks of the memory pressure,
+// without a memory budget or TmpFileBytes to check it a number of times per budget
+const pressureCheckBytes = 1 << 20
+
+// spillsOnPressure returns true if the set being read is spilled early under memory pressure, with
+// SpillGCFraction or SpillAvailableBytes
+func (j *job) spillsOnPressure() bool {
+	return j.opts.SpillGCFraction > 0 || j.opts.SpillAvailableBytes > 0
+}
+
+// underPressure returns true if the set being read should be spilled now, whatever its size, because
+// the memory available on the system is below SpillAvailableBytes, or the garbage collector paused the
+// process for more than SpillGCFraction of the time since the last window was measured, and sends
+// a warning about it
+func (j *job) underPressure(mc *memoryCheck) bool {
+	if mc == nil {
+		return false
+	}
+	if j.opts.SpillAvailableBytes > 0 {
+		if available, ok := availableMemory(); ok && available < j.opts.SpillAvailableBytes {
+			j.pressureSpill(fmt.Sprintf("%d bytes of memory are available on the system, below %d",
+				available, j.opts.SpillAvailableBytes))
+			return true
+		}
+	}
+	if j.opts.SpillGCFraction > 0 {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		elapsed := time.Since(mc.gcSince)
+		if elapsed < pressureWindow {
+			return false
+		}
+		fraction := float64(m.PauseTotalNs-mc.gcPauses) / float64(elapsed)
+		mc.gcPauses, mc.gcSince = m.PauseTotalNs, time.Now()
+		if fraction > j.opts.SpillGCFraction {
+			j.pressureSpill(fmt.Sprintf("the garbage collector paused the process for %.0f%% of the last %v",
+				fraction*100, elapsed.Round(time.Millisecond)))
+			return true
+		}
+	}
+	return false
+}
+
+// pressureSpill counts a set spilled early under memory pressure, and sends a warning with the reason
+func (j *job) pressureSpill(reason string) {
+	j.stats.PressureSpills++
+	j.event(Event{Kind: EventWarning, Phase: PhaseSplitting,
+		Message: fmt.Sprintf("Spilling the set early under memory pressure: %s", reason)})
+}
+
+// validateMemoryPressure returns an error if the thresholds of the memory pressure are out of range
+func validateMemoryPressure(opts Options) error {
+	if opts.SpillGCFraction < 0 || opts.SpillGCFraction >= 1 {
+		return fmt.Errorf("spill gc fraction must be at least 0 and less than 1")
+	}
+	return nil
+}
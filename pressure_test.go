@@ -0,0 +1,75 @@
+package dedup
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunSpillAvailableBytes(t *testing.T) {
+	if _, ok := availableMemory(); !ok {
+		t.Skip("The available memory can not be read on this system")
+	}
+	input := strings.Repeat("c\na\nb\na\nc\na\nd\nc\n", 100)
+
+	// No system has an exabyte available, so every check spills, while it always has more than a byte.
+	// The sets are checked every byte they grow, as TmpFileBytes is 64 times that, but never reach it.
+	for _, tc := range []struct {
+		availableBytes uint64
+		spills         bool
+	}{{1 << 60, true}, {1, false}} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		var warnings int
+		opts := Options{TmpFileBytes: 64, SpillAvailableBytes: tc.availableBytes, OnEvent: func(e Event) {
+			if e.Kind == EventWarning {
+				warnings++
+			}
+		}}
+		stats, err := Run(outFile, opts, strings.NewReader(input), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 4 || stats.LinesDuplicate != 796 || (stats.PressureSpills > 0) != tc.spills ||
+			(stats.Chunks > 0) != tc.spills || warnings != stats.PressureSpills {
+			t.Fatalf("Unexpected stats with %d bytes available: %+v", tc.availableBytes, stats)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "a\nb\nc\nd\n" {
+			t.Fatalf("Unexpected output with %d bytes available: %q", tc.availableBytes, content)
+		}
+	}
+}
+
+func TestNewMemoryCheckPressure(t *testing.T) {
+	// Without a memory budget, the pressure is checked every so often through TmpFileBytes, or every MiB
+	for _, tc := range []struct {
+		opts Options
+		step uint64
+	}{
+		{Options{SpillGCFraction: 0.2, TmpFileBytes: 6400}, 100},
+		{Options{SpillAvailableBytes: 1}, pressureCheckBytes},
+		{Options{SpillGCFraction: 0.2, MemoryBytes: 640}, 10},
+	} {
+		j := &job{opts: tc.opts}
+		if mc := j.newMemoryCheck(); mc == nil || mc.step != tc.step {
+			t.Fatalf("Expected a check every %d bytes with %+v; Got: %+v", tc.step, tc.opts, mc)
+		}
+	}
+	if mc := (&job{opts: Options{TmpFileBytes: 6400}}).newMemoryCheck(); mc != nil {
+		t.Fatalf("Expected no check without a budget or pressure thresholds; Got: %+v", mc)
+	}
+
+	// No process can spend all of its time paused
+	if err := checkRun(Options{SpillGCFraction: 1}); err == nil {
+		t.Fatal("Expected an error with a spill gc fraction of 1")
+	}
+}
@@ -0,0 +1,14 @@
+package dedup
+
+import "os"
+
+// processRunning returns true if a process with the id exists
+func processRunning(pid int) bool {
+	// On windows, finding a process opens it, which fails if it does not exist
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
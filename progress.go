@@ -0,0 +1,409 @@
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Phase is a stage of a deduplication run
+type Phase string
+
+const (
+	// PhaseCounting counts the lines of the input file, so that the progress of splitting can be
+	// tracked. It runs at the same time as PhaseSplitting, and only if a second reader was given.
+	PhaseCounting Phase = "counting"
+
+	// PhaseSplitting reads the input file into sets, and writes them out as sorted chunks
+	PhaseSplitting Phase = "splitting"
+
+	// PhaseWriting writes the sorted lines directly to the output file, when they all fit in memory
+	PhaseWriting Phase = "writing"
+
+	// PhaseMerging merges the sorted chunks into the output file
+	PhaseMerging Phase = "merging"
+)
+
+// defaultProgressInterval is how often progress is reported, when not configured
+const defaultProgressInterval = 60 * time.Second
+
+// Progress is a snapshot of the progress of a single phase of a run
+type Progress struct {
+	// Phase is the phase this progress is for
+	Phase Phase
+
+	// Lines is the number of lines processed so far in this phase
+	Lines uint64
+
+	// TotalLines is the number of lines this phase will process, or zero if not yet known
+	TotalLines uint64
+
+	// Bytes is the number of bytes processed so far in this phase
+	Bytes uint64
+
+	// TotalBytes is the number of bytes this phase will process, or zero if not known. Splitting
+	// knows it from the size of the input, when it is a file, or Sources of files, without reading
+	// the input a second time to count its lines.
+	TotalBytes uint64
+
+	// Elapsed is the time since the phase started
+	Elapsed time.Duration
+
+	// Done is true once the phase has finished
+	Done bool
+}
+
+// Percent returns the percentage of the phase that is complete, if the total is known, in lines,
+// or else in bytes
+func (p Progress) Percent() (float64, bool) {
+	if p.Done {
+		return 100, true
+	}
+	done, total := p.completed()
+	if total == 0 {
+		return 0, false
+	}
+	return float64(done) * 100 / float64(total), true
+}
+
+// completed returns the lines processed and the total lines, if the total is known, or else the
+// bytes processed and the total bytes
+func (p Progress) completed() (uint64, uint64) {
+	if p.TotalLines == 0 && p.TotalBytes > 0 {
+		// A delimiter is counted after the last line, even if the input does not end with one
+		if p.Bytes > p.TotalBytes {
+			return p.TotalBytes, p.TotalBytes
+		}
+		return p.Bytes, p.TotalBytes
+	}
+	return p.Lines, p.TotalLines
+}
+
+// LinesPerSecond returns the average throughput of the phase in lines
+func (p Progress) LinesPerSecond() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Lines) / p.Elapsed.Seconds()
+}
+
+// BytesPerSecond returns the average throughput of the phase in bytes
+func (p Progress) BytesPerSecond() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / p.Elapsed.Seconds()
+}
+
+// ETA returns the estimated time remaining until the phase finishes, if the total is known
+func (p Progress) ETA() (time.Duration, bool) {
+	if p.Done {
+		return 0, true
+	}
+	done, total := p.completed()
+	if total == 0 || done == 0 {
+		return 0, false
+	}
+	if done >= total {
+		return 0, true
+	}
+	perUnit := float64(p.Elapsed) / float64(done)
+	return time.Duration(perUnit * float64(total-done)), true
+}
+
+// printProgress is the default progress reporter, for when no callback is configured
+func printProgress(p Progress) {
+	switch {
+	case p.Done:
+		// Phase transitions are printed as events
+	case p.TotalLines > 0:
+		digits := len(fmt.Sprint(p.TotalLines))
+		fmt.Printf("Progress: %s %*d/%d=%d%%\n", p.Phase, digits, p.Lines, p.TotalLines, p.Lines*100/p.TotalLines)
+	case p.TotalBytes > 0:
+		pct, _ := p.Percent()
+		fmt.Printf("Progress: %s %d lines, %d/%d bytes=%.0f%%\n", p.Phase, p.Lines, p.Bytes, p.TotalBytes, pct)
+	default:
+		fmt.Printf("Progress: %s %d lines\n", p.Phase, p.Lines)
+	}
+}
+
+// phaseProgress tracks the progress of a single phase. Its counters are updated atomically,
+// so that they can be read by the reporting goroutine while the phase is running.
+// All methods are safe to call on a nil phaseProgress, which tracks nothing.
+type phaseProgress struct {
+	// Atomically accessed fields first, so that they are 64-bit aligned on 32-bit platforms
+	lines      uint64
+	totalLines uint64
+	bytes      uint64
+	totalBytes uint64
+	elapsed    int64
+	done       uint32
+
+	tracker *reporter
+	phase   Phase
+	start   time.Time
+}
+
+// add increments the lines and bytes processed
+func (pp *phaseProgress) add(lines, bytes uint64) {
+	if pp == nil {
+		return
+	}
+	atomic.AddUint64(&pp.lines, lines)
+	atomic.AddUint64(&pp.bytes, bytes)
+}
+
+// setTotal sets the total number of lines the phase will process
+func (pp *phaseProgress) setTotal(lines uint64) {
+	if pp == nil {
+		return
+	}
+	atomic.StoreUint64(&pp.totalLines, lines)
+}
+
+// setTotalBytes sets the total number of bytes the phase will process
+func (pp *phaseProgress) setTotalBytes(bytes uint64) {
+	if pp == nil {
+		return
+	}
+	atomic.StoreUint64(&pp.totalBytes, bytes)
+}
+
+// inputSize returns the bytes left to read of the input, if it is a regular file, a reader that knows
+// its length or size, a seeker, or Sources of only those, and true, or else false
+func inputSize(r io.Reader) (uint64, bool) {
+	switch r := r.(type) {
+	case *Sources:
+		var total uint64
+		for _, source := range r.sources {
+			size, ok := inputSize(source.Reader)
+			if !ok {
+				return 0, false
+			}
+			total += size
+		}
+		return total, true
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return 0, false
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil || offset > info.Size() {
+			return 0, false
+		}
+		return uint64(info.Size() - offset), true
+	case interface{ Len() int }:
+		return uint64(r.Len()), true
+	case io.Seeker:
+		offset, end, ok := seekerBounds(r)
+		return uint64(end - offset), ok
+	case interface{ Size() int64 }:
+		// Without a way to know how much was read, the reader is assumed to be read from its start
+		if size := r.Size(); size >= 0 {
+			return uint64(size), true
+		}
+		return 0, false
+	default:
+		return 0, false
+	}
+}
+
+// seekerBounds returns the current offset of the seeker and the offset of its end, seeking back to
+// where it was, and true, or else false
+func seekerBounds(s io.Seeker) (int64, int64, bool) {
+	offset, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, 0, false
+	}
+	end, err := s.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, 0, false
+	}
+	if _, err = s.Seek(offset, io.SeekStart); err != nil || end < offset {
+		return 0, 0, false
+	}
+	return offset, end, true
+}
+
+// readAgain returns a reader of what is left to read of the input, through io.ReaderAt, so that it can
+// be read a second time while it is being read, if it is a regular file, an io.ReaderAt that is also an
+// io.Seeker, or Sources of only those, and true, or else false
+func readAgain(r io.Reader) (io.Reader, bool) {
+	switch r := r.(type) {
+	case *Sources:
+		readers := make([]io.Reader, len(r.sources))
+		for i, source := range r.sources {
+			again, ok := readAgain(source.Reader)
+			if !ok {
+				return nil, false
+			}
+			readers[i] = again
+		}
+		return io.MultiReader(readers...), true
+	case *os.File:
+		size, ok := inputSize(r)
+		if !ok {
+			return nil, false
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, false
+		}
+		return io.NewSectionReader(r, offset, int64(size)), true
+	case interface {
+		io.ReaderAt
+		io.Seeker
+	}:
+		offset, end, ok := seekerBounds(r)
+		if !ok {
+			return nil, false
+		}
+		return io.NewSectionReader(r, offset, end-offset), true
+	default:
+		return nil, false
+	}
+}
+
+// finish marks the phase as done, and reports its final progress
+func (pp *phaseProgress) finish() {
+	if pp == nil || !atomic.CompareAndSwapUint32(&pp.done, 0, 1) {
+		return
+	}
+	atomic.StoreInt64(&pp.elapsed, int64(time.Since(pp.start)))
+	pp.tracker.report(pp.snapshot())
+}
+
+// snapshot returns the current progress of the phase
+func (pp *phaseProgress) snapshot() Progress {
+	p := Progress{
+		Phase:      pp.phase,
+		Lines:      atomic.LoadUint64(&pp.lines),
+		TotalLines: atomic.LoadUint64(&pp.totalLines),
+		Bytes:      atomic.LoadUint64(&pp.bytes),
+		TotalBytes: atomic.LoadUint64(&pp.totalBytes),
+		Done:       atomic.LoadUint32(&pp.done) == 1,
+	}
+	if p.Done {
+		p.Elapsed = time.Duration(atomic.LoadInt64(&pp.elapsed))
+	} else {
+		p.Elapsed = time.Since(pp.start)
+	}
+	return p
+}
+
+// reporter reports the progress of every phase of a run, both periodically and as they finish,
+// as well as the events of the run. Callbacks are never called concurrently with each other.
+type reporter struct {
+	mu         sync.Mutex
+	onProgress func(Progress)
+	onEvent    func(Event)
+	phases     []*phaseProgress
+	stopped    bool
+
+	// The spans of the run, with a Tracer
+	tracing *tracing
+}
+
+// newReporter returns a reporter calling the callbacks, or logging or printing for those that are nil
+func newReporter(onProgress func(Progress), onEvent func(Event), logger Logger) *reporter {
+	if onProgress == nil {
+		onProgress = printProgress
+		if logger != nil {
+			onProgress = logProgress(logger)
+		}
+	}
+	if onEvent == nil {
+		onEvent = printEvent
+		if logger != nil {
+			onEvent = logEvent(logger)
+		}
+	}
+	return &reporter{onProgress: onProgress, onEvent: onEvent}
+}
+
+// printEvent is the default event reporter, for when no callback is configured
+func printEvent(e Event) {
+	fmt.Println(e.Message)
+}
+
+// begin starts tracking a new phase
+func (r *reporter) begin(phase Phase) *phaseProgress {
+	pp := &phaseProgress{tracker: r, phase: phase, start: time.Now()}
+	r.mu.Lock()
+	r.phases = append(r.phases, pp)
+	if r.tracing != nil {
+		r.tracing.beginPhase(phase)
+	}
+	r.mu.Unlock()
+	return pp
+}
+
+// report calls the progress callback, unless the reporter has stopped
+func (r *reporter) report(p Progress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.stopped {
+		r.onProgress(p)
+	}
+}
+
+// event calls the event callback, unless the reporter has stopped, and adds it to the spans of the run
+func (r *reporter) event(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tracing != nil {
+		r.tracing.event(e)
+	}
+	if !r.stopped {
+		r.onEvent(e)
+	}
+}
+
+// stop prevents any further reports, such as from phases still running after the run returned
+func (r *reporter) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = true
+}
+
+// elapsed returns the duration of each finished phase
+func (r *reporter) elapsed() map[Phase]time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	durations := make(map[Phase]time.Duration, len(r.phases))
+	for _, pp := range r.phases {
+		// The elapsed time is only set once the phase is done
+		if elapsed := atomic.LoadInt64(&pp.elapsed); elapsed > 0 {
+			durations[pp.phase] += time.Duration(elapsed)
+		}
+	}
+	return durations
+}
+
+// run reports the progress of all unfinished phases every interval, until the context is done
+func (r *reporter) run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.mu.Lock()
+			for _, pp := range r.phases {
+				if !r.stopped && atomic.LoadUint32(&pp.done) == 0 {
+					r.onProgress(pp.snapshot())
+				}
+			}
+			r.mu.Unlock()
+		}
+	}
+}
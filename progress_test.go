@@ -0,0 +1,181 @@
+package dedup
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRunProgress(t *testing.T) {
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+
+	inFileAgain, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFileAgain.Close()
+
+	// Collect the final progress of each phase
+	final := make(map[Phase]Progress)
+	opts := Options{
+		TmpFileBytes: 20 * 50,
+		DryRun:       true,
+		OnProgress: func(p Progress) {
+			if p.Done {
+				final[p.Phase] = p
+			}
+		},
+	}
+
+	// testdata.log has 100 distinct lines of 50 characters, 204 total lines.
+	stats, err := Run(nil, opts, inFile, inFileAgain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	splitting, ok := final[PhaseSplitting]
+	if !ok || splitting.Lines != 204 || splitting.Bytes != 204*51 {
+		t.Fatalf("Unexpected splitting progress: %+v", splitting)
+	}
+	merging, ok := final[PhaseMerging]
+	if !ok || merging.Lines != stats.TmpLines || merging.TotalLines != stats.TmpLines || merging.Bytes != 100*51 {
+		t.Fatalf("Unexpected merging progress: %+v", merging)
+	}
+	if pct, ok := merging.Percent(); !ok || pct != 100 {
+		t.Fatalf("Finished phase should be 100%% complete: %v", pct)
+	}
+}
+
+func TestProgressETA(t *testing.T) {
+	p := Progress{Phase: PhaseSplitting, Lines: 25, TotalLines: 100, Bytes: 2500, Elapsed: 10 * time.Second}
+
+	if pct, ok := p.Percent(); !ok || pct != 25 {
+		t.Fatalf("Unexpected percent: %v", pct)
+	}
+	if eta, ok := p.ETA(); !ok || eta != 30*time.Second {
+		t.Fatalf("Unexpected ETA: %v", eta)
+	}
+	if p.LinesPerSecond() != 2.5 || p.BytesPerSecond() != 250 {
+		t.Fatalf("Unexpected throughput: %v %v", p.LinesPerSecond(), p.BytesPerSecond())
+	}
+
+	// Without a total, there is no percent or ETA
+	p.TotalLines = 0
+	if _, ok := p.Percent(); ok {
+		t.Fatal("Expected no percent without a total")
+	}
+	if _, ok := p.ETA(); ok {
+		t.Fatal("Expected no ETA without a total")
+	}
+}
+
+func TestRunProgressBytes(t *testing.T) {
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+
+	info, err := inFile.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a second reader to count the lines, the total is the size of the file
+	var splitting Progress
+	opts := Options{
+		TmpFileBytes: 20 * 50,
+		DryRun:       true,
+		OnProgress: func(p Progress) {
+			if p.Phase == PhaseSplitting && p.Done {
+				splitting = p
+			}
+		},
+	}
+	if _, err := Run(nil, opts, NewSources(Source{Name: inFile.Name(), Reader: inFile}), nil); err != nil {
+		t.Fatal(err)
+	}
+	if splitting.TotalLines != 0 || splitting.TotalBytes != uint64(info.Size()) || splitting.Bytes < splitting.TotalBytes {
+		t.Fatalf("Unexpected splitting progress: %+v", splitting)
+	}
+
+	p := Progress{Phase: PhaseSplitting, Lines: 10, Bytes: 250, TotalBytes: 1000, Elapsed: 10 * time.Second}
+	if pct, ok := p.Percent(); !ok || pct != 25 {
+		t.Fatalf("Unexpected percent: %v", pct)
+	}
+	if eta, ok := p.ETA(); !ok || eta != 30*time.Second {
+		t.Fatalf("Unexpected ETA: %v", eta)
+	}
+	if _, ok := inputSize(struct{ io.Reader }{inFile}); ok {
+		t.Fatal("Expected no size of a reader of an unknown length")
+	}
+}
+
+func TestRunProgressCountLines(t *testing.T) {
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+	content, err := io.ReadAll(inFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = inFile.Seek(10, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file and a reader that can be read at offsets are read again from where they are, without being
+	// opened twice, and without moving them
+	section := io.NewSectionReader(bytes.NewReader(content), 0, int64(len(content)))
+	if _, err = section.Seek(20, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	input := NewSources(Source{Name: inFile.Name(), Reader: inFile}, Source{Name: "section", Reader: section})
+	again, ok := readAgain(input)
+	if !ok {
+		t.Fatal("Expected a second reader of a file and a section")
+	}
+	read, err := io.ReadAll(again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(read) != string(content[10:])+string(content[20:]) {
+		t.Fatalf("Unexpected second read of %d bytes", len(read))
+	}
+	if size, ok := inputSize(input); !ok || size != uint64(len(read)) {
+		t.Fatalf("Unexpected size of the input: %d %t", size, ok)
+	}
+
+	// The lines are counted while the input is read, where the end of the file, which has no final new
+	// line, runs into the start of the section
+	opts := Options{TmpFileBytes: 20 * 50, DryRun: true, CountLines: true, OnProgress: func(Progress) {}}
+	stats, err := Run(nil, opts, input, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.LinesRead != 407 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	// A reader of its size is assumed to be unread
+	type sizer interface{ Size() int64 }
+	sized := struct {
+		io.Reader
+		sizer
+	}{section, section}
+	if size, ok := inputSize(sized); !ok || size != uint64(len(content)) {
+		t.Fatalf("Unexpected size of a sizer: %d %t", size, ok)
+	}
+
+	// Without a way to read it again, progress is tracked in bytes
+	if _, ok := readAgain(NewSources(Source{Reader: section}, Source{Reader: struct{ io.Reader }{section}})); ok {
+		t.Fatal("Expected no second reader of a reader that can not be read at offsets")
+	}
+}
@@ -0,0 +1,90 @@
+package dedup
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket of bytes, shared by every reader or writer of a run it limits, which
+// fills at the rate and holds up to a second of it. Reads and writes larger than what is in the bucket
+// are allowed, leaving it in debt, and the next one waits until the debt is paid back.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter of the bytes per second, or nil if it is zero, for no limit
+func newRateLimiter(bytesPerSecond uint64) *rateLimiter {
+	if bytesPerSecond == 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(bytesPerSecond), tokens: float64(bytesPerSecond), last: time.Now()}
+}
+
+// wait takes n bytes from the bucket, first waiting until it is no longer in debt. Waiting holds the
+// lock, so that the readers or writers sharing the limiter take turns.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	if l.tokens < 0 {
+		wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+		time.Sleep(wait)
+		l.tokens, l.last = 0, now.Add(wait)
+	}
+	l.tokens -= float64(n)
+}
+
+// limitedReader is a reader that is limited to the rate of its limiter
+type limitedReader struct {
+	r io.Reader
+	l *rateLimiter
+}
+
+func (r limitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.l.wait(n)
+	return n, err
+}
+
+// limitedWriter is a writer that is limited to the rate of its limiter
+type limitedWriter struct {
+	w io.Writer
+	l *rateLimiter
+}
+
+func (w limitedWriter) Write(p []byte) (int, error) {
+	w.l.wait(len(p))
+	return w.w.Write(p)
+}
+
+// Name returns the name of the file written to, to log it by
+func (w limitedWriter) Name() string {
+	return outputName(w.w)
+}
+
+// limitReader returns the reader limited to MaxReadBytesPerSecond, shared with every other reader of
+// the run, or the reader itself without a limit
+func (j *job) limitReader(r io.Reader) io.Reader {
+	if j.readLimit == nil {
+		return r
+	}
+	return limitedReader{r: r, l: j.readLimit}
+}
+
+// limitWriter returns the writer limited to MaxWriteBytesPerSecond, shared with every other writer of
+// the run, or the writer itself without a limit, or if it discards what is written
+func (j *job) limitWriter(w io.Writer) io.Writer {
+	if j.writeLimit == nil || w == nil || w == io.Discard {
+		return w
+	}
+	return limitedWriter{w: w, l: j.writeLimit}
+}
@@ -0,0 +1,53 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(100000)
+	start := time.Now()
+
+	// The first second is in the bucket already, and the rest waits for it to fill
+	for i := 0; i < 15; i++ {
+		l.wait(10000)
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("Expected about 0.4 seconds to pass; Got: %s", elapsed)
+	}
+	if newRateLimiter(0) != nil {
+		t.Fatal("Expected no limiter without a limit")
+	}
+}
+
+func TestRunRateLimit(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&input, "%09d\n", i%5000)
+	}
+
+	outFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+
+	// 100 KB is read at 100 KB/s, after the first second in the bucket, and then the chunks are read
+	start := time.Now()
+	opts := Options{TmpFileBytes: 25000, MaxReadBytesPerSecond: 100000, MaxWriteBytesPerSecond: 1000000}
+	stats, err := Run(outFile, opts, strings.NewReader(input.String()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.LinesUnique != 5000 {
+		t.Fatalf("Expected 5000 unique lines; Got: %+v", stats)
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Fatalf("Expected the reads to take at least half a second; Got: %s", elapsed)
+	}
+}
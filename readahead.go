@@ -0,0 +1,90 @@
+package dedup
+
+import "io"
+
+// defaultReadAhead is how many buffers of the input are read ahead of the lines being scanned,
+// when not configured
+const defaultReadAhead = 2
+
+// readAheadBlock is what a single read of the input returned
+type readAheadBlock struct {
+	b   []byte
+	err error
+}
+
+// readAhead reads the input on its own goroutine into a few buffers, so that waiting on the disk or
+// network for the next buffer of input overlaps with scanning the lines of the previous ones and
+// adding them to the set. Together with the chunk writer and partition workers, reading, adding
+// lines, and writing chunks each run on goroutines of their own.
+type readAhead struct {
+	full chan readAheadBlock
+	free chan []byte
+	done chan struct{}
+
+	// The buffer being scanned, the part of it not scanned yet, and the error that ended the input
+	buf    []byte
+	unread []byte
+	err    error
+}
+
+// newReadAhead returns the input itself if ReadAhead is negative, or else a reader that reads it
+// ahead on another goroutine, into buffers of the read buffer size. The returned function stops
+// the reading goroutine, which is left blocked in any read of the input already started.
+func (j *job) newReadAhead(r io.Reader) (io.Reader, func()) {
+	blocks := j.opts.ReadAhead
+	if blocks < 0 {
+		return r, func() {}
+	}
+	if blocks == 0 {
+		blocks = defaultReadAhead
+	}
+	ra := &readAhead{
+		full: make(chan readAheadBlock, blocks),
+		free: make(chan []byte, blocks+1),
+		done: make(chan struct{}),
+	}
+	size := bufferSize(j.opts.ReadBufferSize, defaultBufferSize)
+	for i := 0; i < blocks+1; i++ {
+		ra.free <- make([]byte, size)
+	}
+	go ra.read(r)
+	return ra, func() { close(ra.done) }
+}
+
+// read reads the input into free buffers until it is finished or fails, or the reader is stopped
+func (ra *readAhead) read(r io.Reader) {
+	for {
+		var buf []byte
+		select {
+		case buf = <-ra.free:
+		case <-ra.done:
+			return
+		}
+		n, err := r.Read(buf)
+		select {
+		case ra.full <- readAheadBlock{b: buf[:n], err: err}:
+		case <-ra.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (ra *readAhead) Read(p []byte) (int, error) {
+	for len(ra.unread) == 0 {
+		if ra.err != nil {
+			return 0, ra.err
+		}
+		// The buffer just finished with is read into again
+		if ra.buf != nil {
+			ra.free <- ra.buf
+		}
+		block := <-ra.full
+		ra.buf, ra.unread, ra.err = block.b[:cap(block.b)], block.b, block.err
+	}
+	n := copy(p, ra.unread)
+	ra.unread = ra.unread[n:]
+	return n, nil
+}
@@ -0,0 +1,37 @@
+package dedup
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadAhead(t *testing.T) {
+	input := strings.Repeat("abcdefg\n", 100)
+
+	// Reads are split across the small buffers, and the input is read as it was without them
+	for _, readAhead := range []int{-1, 0, 1, 5} {
+		j := &job{opts: Options{ReadAhead: readAhead, ReadBufferSize: 3}}
+		r, stop := j.newReadAhead(iotest.HalfReader(strings.NewReader(input)))
+		b, err := io.ReadAll(r)
+		stop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != input {
+			t.Fatalf("Unexpected input read %d buffers ahead: %q", readAhead, b)
+		}
+	}
+
+	// The error that ended the input is returned once everything before it is read
+	readErr := errors.New("read failed")
+	j := &job{opts: Options{ReadBufferSize: 4}}
+	r, stop := j.newReadAhead(io.MultiReader(strings.NewReader("abcdef"), iotest.ErrReader(readErr)))
+	defer stop()
+	b, err := io.ReadAll(r)
+	if !errors.Is(err, readErr) || string(b) != "abcdef" {
+		t.Fatalf("Expected the read error after the input; Got: %q, %v", b, err)
+	}
+}
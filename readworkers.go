@@ -0,0 +1,172 @@
+package dedup
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// preDeduplicateBytes is how many bytes of the lines it has just sent each read worker remembers,
+// to drop their duplicates before they are sent again
+const preDeduplicateBytes = 4 * 1024 * 1024
+
+// sourceBatch is a batch of whole lines read from a source by a read worker, each followed by the
+// delimiter, with the count and bytes of the duplicates it dropped, or the error that stopped it
+type sourceBatch struct {
+	b       []byte
+	dropped uint64
+	bytes   uint64
+	err     error
+}
+
+// sourceReader reads the Sources of the input at once, each on a read worker goroutine of its own,
+// with up to ReadWorkers at a time, so that the disk or network bandwidth of several sources is used
+// together. Each worker scans the lines of its source, drops those that duplicate a line it has just
+// sent when it is safe to, and hands batches of whole lines to the reading goroutine, which reads
+// them in the order they are ready, as a single input.
+type sourceReader struct {
+	batches chan sourceBatch
+	done    chan struct{}
+	unread  []byte
+	err     error
+
+	// The count and bytes of the lines dropped by the workers, not yet added to the stats
+	dropped uint64
+	bytes   uint64
+}
+
+// validateReadWorkers returns an error if the options can not be used with ReadWorkers, because
+// they depend on the order of the lines of the input
+func validateReadWorkers(opts Options) error {
+	if opts.ReadWorkers <= 1 {
+		return nil
+	}
+	if opts.SkipLines > 0 || opts.MaxLines > 0 {
+		return fmt.Errorf("skipping lines or a maximum of lines is not supported with read workers")
+	}
+	if opts.Format.records() || opts.OnAudit != nil || opts.OnLineMapped != nil {
+		return fmt.Errorf("tracking where lines were read is not supported with read workers")
+	}
+	if keyed(opts) {
+		return fmt.Errorf("writing the first line read of each key is not supported with read workers")
+	}
+	return nil
+}
+
+// newSourceReader returns a reader of the sources on read workers, or nil if the input is not
+// Sources or there is a single read worker. The returned function stops the workers, which are
+// left blocked in any read of their source already started.
+func (j *job) newSourceReader(r io.Reader) (*sourceReader, func()) {
+	sources, ok := r.(*Sources)
+	if !ok || j.opts.ReadWorkers <= 1 {
+		return nil, func() {}
+	}
+	sr := &sourceReader{
+		batches: make(chan sourceBatch, j.opts.ReadWorkers),
+		done:    make(chan struct{}),
+	}
+	workers := make(chan struct{}, j.opts.ReadWorkers)
+	var wg sync.WaitGroup
+	for _, source := range sources.sources {
+		source := source
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case workers <- struct{}{}:
+			case <-sr.done:
+				return
+			}
+			defer func() { <-workers }()
+			j.readSource(sr, source.Reader)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(sr.batches)
+	}()
+	return sr, func() { close(sr.done) }
+}
+
+// readSource scans the lines of a source on a read worker, and sends them in batches of the read
+// buffer size. Without filters, callbacks of each duplicate, or counts, the duplicates of the lines
+// sent recently are dropped, as the reading goroutine would find them anyway.
+func (j *job) readSource(sr *sourceReader, r io.Reader) {
+	size := bufferSize(j.opts.ReadBufferSize, defaultBufferSize)
+	scanner := j.newScanner(r, size)
+	preDeduplicate := !hasFilters(j.opts) && j.opts.OnDuplicate == nil && j.opts.OnDuplicateAt == nil && !j.tracking() && j.opts.CountSketch == nil
+	var (
+		recent     map[string]struct{}
+		recentUsed int
+		batch      sourceBatch
+	)
+	send := func() bool {
+		select {
+		case sr.batches <- batch:
+			batch = sourceBatch{}
+			return true
+		case <-sr.done:
+			return false
+		}
+	}
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if preDeduplicate {
+			if _, ok := recent[string(line)]; ok {
+				batch.dropped++
+				batch.bytes += uint64(len(line) + len(j.delim))
+				continue
+			}
+			if recent == nil || recentUsed >= preDeduplicateBytes {
+				recent, recentUsed = make(map[string]struct{}, 1024), 0
+			}
+			recent[string(line)] = struct{}{}
+			recentUsed += len(line)
+		}
+		if batch.b == nil {
+			batch.b = make([]byte, 0, size)
+		}
+		batch.b = append(batch.b, line...)
+		batch.b = append(batch.b, j.delim...)
+		if len(batch.b) >= size && !send() {
+			return
+		}
+	}
+	batch.err = scanner.Err()
+	if batch.b != nil || batch.dropped > 0 || batch.err != nil {
+		send()
+	}
+}
+
+func (sr *sourceReader) Read(p []byte) (int, error) {
+	for len(sr.unread) == 0 {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		batch, ok := <-sr.batches
+		if !ok {
+			sr.err = io.EOF
+			continue
+		}
+		sr.unread, sr.err = batch.b, batch.err
+		sr.dropped += batch.dropped
+		sr.bytes += batch.bytes
+	}
+	n := copy(p, sr.unread)
+	sr.unread = sr.unread[n:]
+	return n, nil
+}
+
+// addDropped adds the lines dropped by the read workers since the last call to the stats and progress,
+// as duplicates that were read
+func (j *job) addDropped(sr *sourceReader, progress *phaseProgress) {
+	if sr == nil || sr.dropped == 0 {
+		return
+	}
+	j.stats.LinesRead += sr.dropped
+	j.stats.LinesDuplicate += sr.dropped
+	j.stats.BytesRead += sr.bytes
+	progress.add(sr.dropped, sr.bytes)
+	j.opts.Metrics.addLinesRead(sr.dropped)
+	sr.dropped, sr.bytes = 0, 0
+}
@@ -0,0 +1,57 @@
+package dedup
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRunReadWorkers(t *testing.T) {
+	newSources := func() *Sources {
+		return NewSources(
+			Source{Name: "a", Reader: strings.NewReader(strings.Repeat("c\na\nc\n", 50))},
+			Source{Name: "b", Reader: strings.NewReader("b\na\nd")},
+			Source{Name: "c", Reader: strings.NewReader("")},
+			Source{Name: "d", Reader: strings.NewReader(strings.Repeat("e\nb\n", 100))},
+		)
+	}
+
+	// Every line is counted, whether it was dropped by its read worker or found by the run, and the
+	// final line of a source is not run into the next
+	for _, opts := range []Options{
+		{ReadWorkers: 2},
+		{ReadWorkers: 4, TmpFileBytes: 4},
+		{ReadWorkers: 3, BuildWorkers: 2, Workers: 2, SkipPatterns: []*regexp.Regexp{regexp.MustCompile(`^x`)}},
+	} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		stats, err := Run(outFile, opts, newSources(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesRead != 353 || stats.LinesUnique != 5 || stats.LinesDuplicate != 348 || stats.BytesRead != 706 {
+			t.Fatalf("Unexpected stats with %d read workers: %+v", opts.ReadWorkers, stats)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "a\nb\nc\nd\ne\n" {
+			t.Fatalf("Unexpected output with %d read workers: %q", opts.ReadWorkers, content)
+		}
+	}
+
+	// Where lines were read in the input is unknown
+	if _, err := Run(nil, Options{ReadWorkers: 2, Format: FormatJSON}, newSources(), nil); err == nil {
+		t.Fatal("Expected an error tracking where lines were read")
+	}
+	if _, err := SortChunks("", Options{ReadWorkers: 2, MaxLines: 10}, newSources()); err == nil {
+		t.Fatal("Expected an error with a maximum of lines")
+	}
+}
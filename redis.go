@@ -0,0 +1,91 @@
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"time"
+)
+
+// defaultRedisBatchLines is how many unique lines each SADD of FormatRedis adds, when not configured
+const defaultRedisBatchLines = 1000
+
+// writeRedisRecord adds the line of the record to the current batch of FormatRedis, and writes the
+// batch once it is full. It returns how many bytes were written.
+func (j *job) writeRedisRecord(w *bufio.Writer, rec jsonRecord) (uint64, error) {
+	writeRedisString(&j.redisBatch, rec.Line)
+	j.batchLines++
+	batchLines := j.opts.RedisBatchLines
+	if batchLines == 0 {
+		batchLines = defaultRedisBatchLines
+	}
+	if j.batchLines < batchLines {
+		return 0, nil
+	}
+	return j.flushRedis(w)
+}
+
+// flushRedis writes the lines of the current batch of FormatRedis, if any, as a single SADD command
+// to the key. It returns how many bytes were written.
+func (j *job) flushRedis(w *bufio.Writer) (uint64, error) {
+	if j.batchLines == 0 {
+		return 0, nil
+	}
+	b := &j.record
+	b.Reset()
+	writeRedisArray(b, 2+j.batchLines)
+	writeRedisString(b, "SADD")
+	writeRedisString(b, j.redisKey())
+	lines := uint64(bytes.Count(b.Bytes(), []byte("\n")) + bytes.Count(j.redisBatch.Bytes(), []byte("\n")) - j.batchLines)
+	n, err := w.Write(b.Bytes())
+	if err == nil {
+		var m int
+		m, err = w.Write(j.redisBatch.Bytes())
+		n += m
+	}
+	j.scriptLines += lines
+	j.redisBatch.Reset()
+	j.batchLines = 0
+	return uint64(n), err
+}
+
+// redisEnd returns the command ending the output of FormatRedis, which sets the RedisTTL of the key,
+// if any
+func (j *job) redisEnd() string {
+	if j.opts.RedisTTL <= 0 {
+		return ""
+	}
+	var b bytes.Buffer
+	writeRedisArray(&b, 3)
+	writeRedisString(&b, "PEXPIRE")
+	writeRedisString(&b, j.redisKey())
+
+	// Rounded up, since an expiry of zero would remove the set at once
+	ms := (j.opts.RedisTTL + time.Millisecond - 1) / time.Millisecond
+	writeRedisString(&b, strconv.FormatInt(int64(ms), 10))
+	return b.String()
+}
+
+// redisKey returns the key of the set FormatRedis adds the unique lines to
+func (j *job) redisKey() string {
+	if j.opts.RedisKey == "" {
+		return defaultTable
+	}
+	return j.opts.RedisKey
+}
+
+// writeRedisArray writes the start of an array of the Redis protocol, with the number of its elements
+func writeRedisArray(b *bytes.Buffer, elements int) {
+	b.WriteByte('*')
+	b.WriteString(strconv.Itoa(elements))
+	b.WriteString("\r\n")
+}
+
+// writeRedisString writes the string as a bulk string of the Redis protocol, which may hold any bytes
+func writeRedisString(b *bytes.Buffer, s string) {
+	b.WriteByte('$')
+	b.WriteString(strconv.Itoa(len(s)))
+	b.WriteString("\r\n")
+	b.WriteString(s)
+	b.WriteString("\r\n")
+}
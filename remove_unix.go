@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package dedup
+
+import "os"
+
+// removeFile removes a temporary file, which can be removed even while it is open
+func removeFile(name string) error {
+	return os.Remove(name)
+}
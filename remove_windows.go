@@ -0,0 +1,32 @@
+package dedup
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// errSharingViolation is the error removing a file that another process, such as a virus scanner or
+// search indexer, has open without allowing it to be deleted
+const errSharingViolation = syscall.Errno(32)
+
+// removeAttempts is how many times a temporary file is tried to be removed, a moment apart, while
+// another process has it open
+const removeAttempts = 5
+
+// removeFile removes a temporary file, which on windows fails while any process has it open. Those
+// that briefly open every new file, such as virus scanners, are waited for.
+func removeFile(name string) error {
+	var err error
+	for attempt := 0; attempt < removeAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 50 * time.Millisecond)
+		}
+		err = os.Remove(name)
+		if !errors.Is(err, errSharingViolation) && !errors.Is(err, syscall.ERROR_ACCESS_DENIED) {
+			return err
+		}
+	}
+	return err
+}
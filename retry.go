@@ -0,0 +1,119 @@
+package dedup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"time"
+)
+
+// defaultRetryBackoff is how long to wait before the first retry of a transient error, when not configured
+const defaultRetryBackoff = 100 * time.Millisecond
+
+// IsTransient returns true if the error is one that IO fails with intermittently, such as EIO and
+// ESTALE on network filesystems, which may succeed if tried again. Every other error, such as a full
+// filesystem or a missing file, is fatal.
+func IsTransient(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	return transientErrno(errno)
+}
+
+// retryWait waits before the retry of a transient error, IORetryBackoff doubled for each earlier
+// attempt, and sends a warning about it
+func (j *job) retryWait(attempt int, op, name string, err error) {
+	backoff := j.opts.IORetryBackoff
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+	backoff <<= uint(attempt)
+	j.event(Event{Kind: EventWarning, File: name, Err: err,
+		Message: fmt.Sprintf("Retrying %s %s in %v, after attempt %d of %d failed: %v", op, name, backoff, attempt+1, j.opts.IORetries+1, err)})
+	time.Sleep(backoff)
+}
+
+// retryReader is a reader that reads again after a transient error, up to IORetries times
+type retryReader struct {
+	r    io.Reader
+	j    *job
+	name string
+}
+
+func (r retryReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	for attempt := 0; n == 0 && attempt < r.j.opts.IORetries && IsTransient(err); attempt++ {
+		r.j.retryWait(attempt, "reading", r.name, err)
+		n, err = r.r.Read(p)
+	}
+	if n > 0 && IsTransient(err) {
+		// What was read is returned, and the next read tries again
+		return n, nil
+	}
+	return n, err
+}
+
+// retryWriter is a writer that writes the rest again after a transient error, up to IORetries times
+type retryWriter struct {
+	w io.Writer
+	j *job
+}
+
+func (w retryWriter) Write(p []byte) (int, error) {
+	written := 0
+	for attempt := 0; ; attempt++ {
+		n, err := w.w.Write(p[written:])
+		written += n
+		if err == nil || attempt >= w.j.opts.IORetries || !IsTransient(err) {
+			return written, err
+		}
+		w.j.retryWait(attempt, "writing", outputName(w.w), err)
+	}
+}
+
+// Name returns the name of the file written to, to log it by
+func (w retryWriter) Name() string {
+	return outputName(w.w)
+}
+
+// retryReading returns the reader of the named file retrying transient errors, with IORetries, or the
+// reader itself
+func (j *job) retryReading(r io.Reader, name string) io.Reader {
+	if j.opts.IORetries <= 0 {
+		return r
+	}
+	return retryReader{r: r, j: j, name: name}
+}
+
+// retryWriting returns the writer retrying transient errors, with IORetries, or the writer itself
+func (j *job) retryWriting(w io.Writer) io.Writer {
+	if j.opts.IORetries <= 0 || w == nil || w == io.Discard {
+		return w
+	}
+	return retryWriter{w: w, j: j}
+}
+
+// retryInput returns the input retrying transient errors, or each of its sources if it is Sources,
+// with IORetries
+func (j *job) retryInput(r io.Reader) io.Reader {
+	if j.opts.IORetries <= 0 {
+		return r
+	}
+	if sources, ok := r.(*Sources); ok {
+		for i, source := range sources.sources {
+			sources.sources[i].Reader = j.retryReading(source.Reader, source.Name)
+		}
+		return sources
+	}
+	return j.retryReading(r, inputName(r))
+}
+
+// inputName returns the name of the input, if it is a file, to log it by
+func inputName(r io.Reader) string {
+	if named, ok := r.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return "the input"
+}
@@ -0,0 +1,15 @@
+//go:build !plan9
+// +build !plan9
+
+package dedup
+
+import "syscall"
+
+// transientErrno returns true if the error number is one that IO fails with intermittently
+func transientErrno(errno syscall.Errno) bool {
+	switch errno {
+	case syscall.EIO, syscall.ESTALE, syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT:
+		return true
+	}
+	return false
+}
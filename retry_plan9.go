@@ -0,0 +1,8 @@
+package dedup
+
+import "syscall"
+
+// transientErrno returns false, as plan9 reports errors as strings rather than error numbers
+func transientErrno(errno syscall.Errno) bool {
+	return false
+}
@@ -0,0 +1,114 @@
+//go:build !plan9
+// +build !plan9
+
+package dedup
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyReader fails with the error every few reads
+type flakyReader struct {
+	r     io.Reader
+	err   error
+	reads int
+}
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	r.reads++
+	if r.reads%3 == 0 {
+		return 0, r.err
+	}
+	if len(p) > 100 {
+		p = p[:100]
+	}
+	return r.r.Read(p)
+}
+
+// flakyWriter fails with the error every other write, after writing half of it
+type flakyWriter struct {
+	w      io.Writer
+	err    error
+	writes int
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes%2 == 1 && len(p) > 1 {
+		n, _ := w.w.Write(p[:len(p)/2])
+		return n, w.err
+	}
+	return w.w.Write(p)
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, err := range []error{syscall.EIO, syscall.ESTALE, &os.PathError{Op: "read", Path: "x", Err: syscall.EIO}, fmt.Errorf("wrapped: %w", syscall.ESTALE)} {
+		if !IsTransient(err) {
+			t.Fatalf("Expected %v to be transient", err)
+		}
+	}
+	for _, err := range []error{nil, io.EOF, syscall.ENOSPC, os.ErrNotExist, errors.New("other")} {
+		if IsTransient(err) {
+			t.Fatalf("Expected %v to be fatal", err)
+		}
+	}
+}
+
+func TestRunIORetries(t *testing.T) {
+	b, err := os.ReadFile("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Run(nil, Options{DryRun: true}, strings.NewReader(string(b)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading fails now and then, which is retried
+	var retries int
+	opts := Options{TmpFileBytes: 20 * 50, DryRun: true, IORetries: 2, IORetryBackoff: time.Microsecond, ReadBufferSize: 4096,
+		OnEvent: func(e Event) {
+			if e.Kind == EventWarning && errors.Is(e.Err, syscall.EIO) {
+				retries++
+			}
+		}}
+	stats, err := Run(nil, opts, &flakyReader{r: strings.NewReader(string(b)), err: syscall.EIO}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retries == 0 || stats.LinesUnique != want.LinesUnique || stats.BytesOut != want.BytesOut {
+		t.Fatalf("Expected %+v after retries; Got: %+v after %d retries", want, stats, retries)
+	}
+
+	// Without retries, or for an error that is not transient, the run fails
+	opts.IORetries = 0
+	if _, err = Run(nil, opts, &flakyReader{r: strings.NewReader(string(b)), err: syscall.EIO}, nil); !errors.Is(err, syscall.EIO) {
+		t.Fatalf("Expected the error without retries; Got: %v", err)
+	}
+	opts.IORetries = 2
+	if _, err = Run(nil, opts, &flakyReader{r: strings.NewReader(string(b)), err: syscall.ENOSPC}, nil); !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("Expected a fatal error not to be retried; Got: %v", err)
+	}
+}
+
+func TestRetryWriting(t *testing.T) {
+	j := &job{reporter: newReporter(func(Progress) {}, func(Event) {}, nil), opts: Options{IORetries: 1, IORetryBackoff: time.Microsecond}}
+	var out bytes.Buffer
+	w := j.retryWriting(&flakyWriter{w: &out, err: syscall.ESTALE})
+	for _, line := range []string{"one\n", "two\n", "three\n"} {
+		if n, err := w.Write([]byte(line)); err != nil || n != len(line) {
+			t.Fatalf("Expected %q to be written after a retry; Got: %d, %v", line, n, err)
+		}
+	}
+	if out.String() != "one\ntwo\nthree\n" {
+		t.Fatalf("Expected every line written once; Got: %q", out.String())
+	}
+}
@@ -0,0 +1,115 @@
+package dedup
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// defaultTable is the table the unique lines are inserted into by the scripts of FormatSQLite and
+// FormatPostgres, when not configured
+const defaultTable = "lines"
+
+// validateScript returns an error if the script of FormatSQLite, FormatPostgres, or FormatRedis can not
+// be written with the options. Lines passed through unchanged would not be statements of the script.
+func validateScript(opts Options) error {
+	if strings.ContainsAny(opts.Table, "\x00\n\r") {
+		return fmt.Errorf("table must not contain a line break or NUL: %q", opts.Table)
+	}
+	if opts.PostgresBatchLines < 0 {
+		return fmt.Errorf("postgres batch lines must not be negative: %d", opts.PostgresBatchLines)
+	}
+	if opts.RedisBatchLines < 0 || opts.RedisTTL < 0 {
+		return fmt.Errorf("redis batch lines and TTL must not be negative")
+	}
+	if opts.PassthroughUnkept {
+		return fmt.Errorf("%s output is not supported when passing through unkept lines", opts.Format)
+	}
+	for _, rule := range opts.Rules {
+		if rule.Action == RulePassthrough {
+			return fmt.Errorf("%s output is not supported with passthrough rules", opts.Format)
+		}
+	}
+	return nil
+}
+
+// tableName returns the name of the table the unique lines are inserted into
+func (j *job) tableName() string {
+	if j.opts.Table == "" {
+		return defaultTable
+	}
+	return j.opts.Table
+}
+
+// table returns the quoted name of the table the unique lines are inserted into
+func (j *job) table() string {
+	return quoteIdentifier(j.tableName())
+}
+
+// writeScriptStart writes the header row of FormatCSV or FormatTSV, the statements starting the script
+// of FormatSQLite or FormatPostgres, which begin a transaction, and create the table and the unique
+// index of its lines if absent, the start of the file of FormatParquet, or the schema of FormatArrow and
+// FormatArrowStream
+func (j *job) writeScriptStart(w io.Writer) error {
+	switch j.opts.Format {
+	case FormatCSV, FormatTSV:
+		return j.writeScript(w, j.csvStart())
+	case FormatSQLite:
+		return j.writeScript(w, j.sqliteStart())
+	case FormatPostgres:
+		return j.writeScript(w, j.postgresStart())
+	case FormatParquet:
+		return j.parquetStart(w)
+	case FormatArrow, FormatArrowStream:
+		return j.arrowStart(w)
+	}
+	return nil
+}
+
+// writeScriptEnd writes the statements ending the script of FormatSQLite or FormatPostgres, which
+// commit it, so that the script of a run that failed part way inserts nothing, the command ending
+// FormatRedis, the footer of FormatParquet, or the end of FormatArrow and FormatArrowStream
+func (j *job) writeScriptEnd(w io.Writer) error {
+	switch j.opts.Format {
+	case FormatSQLite:
+		return j.writeScript(w, "COMMIT;\n")
+	case FormatPostgres:
+		return j.writeScript(w, j.postgresEnd())
+	case FormatRedis:
+		return j.writeScript(w, j.redisEnd())
+	case FormatParquet:
+		return j.parquetEnd(w)
+	case FormatArrow, FormatArrowStream:
+		return j.arrowEnd(w)
+	}
+	return nil
+}
+
+// flushRecords writes the records of FormatRedis, FormatParquet, FormatArrow, or FormatArrowStream
+// that are held until their batch or row group is full, once the last of them has been. It returns
+// how many bytes were written.
+func (j *job) flushRecords(w *bufio.Writer) (uint64, error) {
+	switch j.opts.Format {
+	case FormatRedis:
+		return j.flushRedis(w)
+	case FormatParquet:
+		return j.parquet.flush(w)
+	case FormatArrow, FormatArrowStream:
+		return j.arrow.flush(w)
+	}
+	return 0, nil
+}
+
+// writeScript writes lines of the script that are not unique lines
+func (j *job) writeScript(w io.Writer, lines string) error {
+	n, err := io.WriteString(w, lines)
+	j.stats.BytesOut += uint64(n)
+	j.scriptLines += uint64(strings.Count(lines, "\n"))
+	return err
+}
+
+// quoteIdentifier returns the name quoted as a SQL identifier
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,236 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunFormatSQLite(t *testing.T) {
+	expected := `BEGIN;
+CREATE TABLE IF NOT EXISTS "my ""lines""" (line TEXT NOT NULL, count INTEGER NOT NULL, source TEXT, line_number INTEGER);
+CREATE UNIQUE INDEX IF NOT EXISTS "my ""lines""_line" ON "my ""lines""" (line);
+INSERT INTO "my ""lines""" (line, count, source, line_number) VALUES (CAST(X'0a62' AS TEXT), 1, 'it''s', 2) ON CONFLICT (line) DO UPDATE SET count = count + excluded.count;
+INSERT INTO "my ""lines""" (line, count, source, line_number) VALUES ('a', 3, 'one', 2) ON CONFLICT (line) DO UPDATE SET count = count + excluded.count;
+INSERT INTO "my ""lines""" (line, count, source, line_number) VALUES ('c''d', 2, 'one', 1) ON CONFLICT (line) DO UPDATE SET count = count + excluded.count;
+COMMIT;
+`
+	// Whether everything fits in memory or not, every line is a statement of its own
+	for _, tmpFileBytes := range []uint64{1000, 4, 1} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.sql")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		input := NewSources(
+			Source{Name: "one", Reader: strings.NewReader("c'd\x00a\x00")},
+			Source{Name: "it's", Reader: strings.NewReader("a\x00\nb\x00c'd\x00a\x00")},
+		)
+		opts := Options{TmpFileBytes: tmpFileBytes, Delimiter: "\x00", Format: FormatSQLite, Table: `my "lines"`, VerifyWrites: true}
+		stats, err := Run(outFile, opts, input, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 3 || stats.LinesDuplicate != 3 || stats.BytesOut != uint64(len(expected)) {
+			t.Fatalf("Unexpected stats with %d tmp file bytes: %+v", tmpFileBytes, stats)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expected {
+			t.Fatalf("Unexpected output with %d tmp file bytes:\n%s", tmpFileBytes, content)
+		}
+	}
+}
+
+func TestMergeFormatSQLite(t *testing.T) {
+	expected := `BEGIN;
+CREATE TABLE IF NOT EXISTS "lines" (line TEXT NOT NULL, count INTEGER NOT NULL, source TEXT, line_number INTEGER);
+CREATE UNIQUE INDEX IF NOT EXISTS "lines_line" ON "lines" (line);
+INSERT INTO "lines" (line, count, source, line_number) VALUES ('a', 2, NULL, NULL) ON CONFLICT (line) DO UPDATE SET count = count + excluded.count;
+INSERT INTO "lines" (line, count, source, line_number) VALUES ('b', 1, NULL, NULL) ON CONFLICT (line) DO UPDATE SET count = count + excluded.count;
+COMMIT;
+`
+	var inFiles []*os.File
+	for _, content := range []string{"a\nb\n", "a\n"} {
+		f, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		if _, err = f.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		inFiles = append(inFiles, f)
+	}
+	outFile, err := os.CreateTemp("", "dedup.test.*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+
+	stats, err := Merge(outFile, Options{Format: FormatSQLite, VerifyWrites: true}, inFiles...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.BytesOut != uint64(len(expected)) {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+	content, err := os.ReadFile(outFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != expected {
+		t.Fatalf("Unexpected output:\n%s", content)
+	}
+}
+
+func TestRunFormatScriptInvalid(t *testing.T) {
+	for _, opts := range []Options{
+		{Format: FormatSQLite, PassthroughUnkept: true},
+		{Format: FormatPostgres, PassthroughUnkept: true},
+		{Format: FormatPostgres, PostgresBatchLines: -1},
+		{Format: FormatRedis, RedisTTL: -1},
+		{Format: FormatRedis, PassthroughUnkept: true},
+		{Format: FormatSQLite, Rules: []Rule{{Action: RulePassthrough, Pattern: regexp.MustCompile("a")}}},
+		{Format: FormatSQLite, Table: "a\nb"},
+	} {
+		if _, err := Run(nil, opts, strings.NewReader("a\n"), nil); err == nil {
+			t.Fatalf("Expected an error with the options: %+v", opts)
+		}
+	}
+}
+
+func TestRunFormatPostgres(t *testing.T) {
+	insert := `\.
+INSERT INTO "lines" (line, count, source, line_number) SELECT line, count, source, line_number FROM "dedup_batch" ON CONFLICT (line) DO NOTHING;
+`
+	copyBatch := `COPY "dedup_batch" (line, count, source, line_number) FROM STDIN;
+`
+	expected := `\set ON_ERROR_STOP on
+BEGIN;
+CREATE TABLE IF NOT EXISTS "lines" (line text NOT NULL, count bigint NOT NULL, source text, line_number bigint);
+CREATE UNIQUE INDEX IF NOT EXISTS "lines_line" ON "lines" (line);
+CREATE TEMPORARY TABLE "dedup_batch" (line text, count bigint, source text, line_number bigint) ON COMMIT DROP;
+` + copyBatch + `a\tb	2	\N	2
+b\\c	1	\N	1
+` + insert + `TRUNCATE "dedup_batch";
+` + copyBatch + `z	1	\N	3
+` + insert + `COMMIT;
+`
+	// Whether everything fits in memory or not, every batch holds two lines
+	for _, tmpFileBytes := range []uint64{1000, 4, 1} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.sql")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		opts := Options{TmpFileBytes: tmpFileBytes, Format: FormatPostgres, PostgresBatchLines: 2, VerifyWrites: true}
+		stats, err := Run(outFile, opts, strings.NewReader("b\\c\na\tb\nz\na\tb\n"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 3 || stats.BytesOut != uint64(len(expected)) {
+			t.Fatalf("Unexpected stats with %d tmp file bytes: %+v", tmpFileBytes, stats)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expected {
+			t.Fatalf("Unexpected output with %d tmp file bytes:\n%s", tmpFileBytes, content)
+		}
+	}
+
+	// Each partition starts batches of its own, which are still read back as the lines written
+	outFile, err := os.CreateTemp("", "dedup.test.*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+	var input strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&input, "%d\n%d\n", i, i/2)
+	}
+	opts := Options{TmpFileBytes: 50, Partitions: 3, Format: FormatPostgres, PostgresBatchLines: 7, VerifyWrites: true}
+	stats, err := Run(outFile, opts, strings.NewReader(input.String()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.LinesUnique != 100 {
+		t.Fatalf("Unexpected stats with partitions: %+v", stats)
+	}
+}
+
+func TestRunFormatRedis(t *testing.T) {
+	sadd := "$4\r\nSADD\r\n$3\r\nset\r\n"
+	expected := "*4\r\n" + sadd + "$0\r\n\r\n$3\r\na\nb\r\n" +
+		"*3\r\n" + sadd + "$1\r\nc\r\n" +
+		"*3\r\n$7\r\nPEXPIRE\r\n$3\r\nset\r\n$4\r\n1501\r\n"
+
+	// Whether everything fits in memory or not, the last batch is written once every line has been
+	for _, tmpFileBytes := range []uint64{1000, 4, 1} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.resp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		opts := Options{TmpFileBytes: tmpFileBytes, Delimiter: "\x00", Format: FormatRedis, RedisKey: "set", RedisBatchLines: 2,
+			RedisTTL: 1500*time.Millisecond + 1, VerifyWrites: true}
+		stats, err := Run(outFile, opts, strings.NewReader("c\x00a\nb\x00\x00c\x00"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 3 || stats.BytesOut != uint64(len(expected)) {
+			t.Fatalf("Unexpected stats with %d tmp file bytes: %+v", tmpFileBytes, stats)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expected {
+			t.Fatalf("Unexpected output with %d tmp file bytes:\n%q", tmpFileBytes, content)
+		}
+	}
+
+	// Each partition writes its last batch before the next partition starts
+	outFile, err := os.CreateTemp("", "dedup.test.*.resp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+	var input strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&input, "%d\n%d\n", i, i/2)
+	}
+	opts := Options{TmpFileBytes: 50, Partitions: 3, Format: FormatRedis, RedisBatchLines: 7, VerifyWrites: true}
+	stats, err := Run(outFile, opts, strings.NewReader(input.String()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(outFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sadds := strings.Count(string(content), "SADD")
+	if members := strings.Count(string(content), "\r\n$") - 2*sadds; stats.LinesUnique != 100 || members != 100 {
+		t.Fatalf("Unexpected output with partitions: %+v\n%q", stats, content)
+	}
+}
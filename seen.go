@@ -0,0 +1,399 @@
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// seenManifest is the name of the manifest of a SeenSet, in its directory
+const seenManifest = "manifest.json"
+
+// seenVersion is the version of the manifest of a SeenSet, which is changed when it is incompatible
+const seenVersion = 1
+
+// maxSeenSegments is how many segments a SeenSet has at most, before they are merged into one
+const maxSeenSegments = 8
+
+// SeenSet is an index on disk of every unique line written by the runs given it as their Seen option,
+// so that each run only writes the lines that no earlier run has. It is a directory of sorted segment
+// files, each holding the lines written by a run, and a manifest listing them, which is replaced as a
+// whole once a segment has been written, so that a run that fails adds nothing. Once there are more
+// than a few segments, they are merged into one. A line is looked up by binary search over each
+// segment, as SortedFile does.
+//
+// A SeenSet must not be used by more than one run at once, whether in this process or another.
+type SeenSet struct {
+	dir      string
+	manifest seenSetManifest
+	mu       sync.RWMutex
+	files    []*os.File
+	segments []*SortedFile
+
+	// The lines written by the current run, which become a segment once it succeeds, and whether they
+	// were written in sorted order, which they are not with partitions by hash
+	pendingMu sync.Mutex
+	pending   *os.File
+	writer    *bufio.Writer
+	last      []byte
+	lines     uint64
+	unsorted  bool
+}
+
+// seenSetManifest lists the segments of a SeenSet, oldest first
+type seenSetManifest struct {
+	Version   int           `json:"version"`
+	Delimiter string        `json:"delimiter"`
+	Next      int           `json:"next"`
+	Segments  []seenSegment `json:"segments"`
+}
+
+// seenSegment is a segment file of a SeenSet, with the number of lines in it
+type seenSegment struct {
+	Name  string `json:"name"`
+	Lines uint64 `json:"lines"`
+}
+
+// OpenSeenSet opens the SeenSet in the directory, which is created if it does not exist, with lines
+// split by the Delimiter of the options, which must be that of every run given it. It must be closed.
+func OpenSeenSet(dir string, opts Options) (*SeenSet, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &SeenSet{dir: dir, manifest: seenSetManifest{Version: seenVersion, Delimiter: opts.Delimiter}}
+	b, err := os.ReadFile(filepath.Join(dir, seenManifest))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return s, nil
+	case err != nil:
+		return nil, err
+	}
+	var m seenSetManifest
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("reading seen set manifest %s: %w", filepath.Join(dir, seenManifest), err)
+	}
+	switch {
+	case m.Version != seenVersion:
+		return nil, fmt.Errorf("seen set %s is of version %d, but version %d is supported", dir, m.Version, seenVersion)
+	case m.Delimiter != opts.Delimiter:
+		return nil, fmt.Errorf("seen set %s has lines split by %q, not %q", dir, m.Delimiter, opts.Delimiter)
+	}
+	s.manifest = m
+	for _, segment := range m.Segments {
+		if err = s.openSegment(segment.Name); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// openSegment opens the segment file, to look up lines in it
+func (s *SeenSet) openSegment(name string) error {
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return err
+	}
+	segment, err := NewSortedFile(f, Options{Delimiter: s.manifest.Delimiter})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.files = append(s.files, f)
+	s.segments = append(s.segments, segment)
+	return nil
+}
+
+// Contains returns true if the line was written by a run given the SeenSet that succeeded
+func (s *SeenSet) Contains(line string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// The newest segments are the smallest, until they are merged
+	for i := len(s.segments) - 1; i >= 0; i-- {
+		if ok, err := s.segments[i].Contains(line); ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// Lines returns how many lines the SeenSet has, counting a line in more than one segment once for each,
+// until they are merged
+func (s *SeenSet) Lines() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var lines uint64
+	for _, segment := range s.manifest.Segments {
+		lines += segment.Lines
+	}
+	return lines
+}
+
+// Close closes the segment files, and removes the lines of a run that did not finish, if any
+func (s *SeenSet) Close() error {
+	s.discard()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var firstErr error
+	for _, f := range s.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.files, s.segments = nil, nil
+	return firstErr
+}
+
+// add writes a unique line written by the run to the pending segment, creating it if needed
+func (s *SeenSet) add(line []byte) error {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if s.pending == nil {
+		f, err := CreateTemp(s.dir, "*.seen")
+		if err != nil {
+			return err
+		}
+		s.pending, s.writer = f, bufio.NewWriterSize(f, defaultBufferSize)
+	}
+	if s.lines > 0 && bytes.Compare(line, s.last) <= 0 {
+		s.unsorted = true
+	}
+	s.last = append(s.last[:0], line...)
+	s.lines++
+	if _, err := s.writer.Write(line); err != nil {
+		return err
+	}
+	_, err := s.writer.WriteString(s.delimiter())
+	return err
+}
+
+// discard removes the pending segment, if any
+func (s *SeenSet) discard() {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if s.pending != nil {
+		s.pending.Close()
+		os.Remove(s.pending.Name())
+	}
+	s.pending, s.writer, s.last, s.lines, s.unsorted = nil, nil, nil, 0, false
+}
+
+// commit makes the pending segment, if any, a segment of the SeenSet, sorting it first if needed, and
+// then merges the segments into one if there are too many
+func (s *SeenSet) commit(durable bool) error {
+	defer s.discard()
+	s.pendingMu.Lock()
+	pending, writer, lines, unsorted := s.pending, s.writer, s.lines, s.unsorted
+	s.pendingMu.Unlock()
+	if pending == nil {
+		return nil
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := s.nextSegment()
+	path := filepath.Join(s.dir, name)
+	if unsorted {
+		if _, err := pending.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := s.writeSegment(path, durable, func(f *os.File) error {
+			opts := s.options()
+			opts.TempDir = s.dir
+			_, err := Run(f, opts, pending, nil)
+			return err
+		}); err != nil {
+			return err
+		}
+	} else {
+		if durable {
+			if err := pending.Sync(); err != nil {
+				return err
+			}
+		}
+		if err := pending.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(pending.Name(), path); err != nil {
+			return err
+		}
+	}
+
+	segments := append(s.manifest.Segments, seenSegment{Name: name, Lines: lines})
+	if err := s.writeManifest(segments, durable); err != nil {
+		os.Remove(path)
+		return err
+	}
+	if err := s.openSegment(name); err != nil {
+		return err
+	}
+	if len(s.manifest.Segments) > maxSeenSegments {
+		return s.compact(durable)
+	}
+	return nil
+}
+
+// compact merges every segment into a new one, which replaces them
+func (s *SeenSet) compact(durable bool) error {
+	name := s.nextSegment()
+	path := filepath.Join(s.dir, name)
+	var stats Stats
+	if err := s.writeSegment(path, durable, func(f *os.File) error {
+		var err error
+		stats, err = Merge(f, s.options(), s.files...)
+		return err
+	}); err != nil {
+		return err
+	}
+	old, oldFiles := s.manifest.Segments, s.files
+	if err := s.writeManifest([]seenSegment{{Name: name, Lines: stats.LinesUnique}}, durable); err != nil {
+		os.Remove(path)
+		return err
+	}
+	s.files, s.segments = nil, nil
+	for i, f := range oldFiles {
+		f.Close()
+		os.Remove(filepath.Join(s.dir, old[i].Name))
+	}
+	return s.openSegment(name)
+}
+
+// nextSegment returns the name of the next segment file
+func (s *SeenSet) nextSegment() string {
+	s.manifest.Next++
+	return fmt.Sprintf("segment.%06d", s.manifest.Next)
+}
+
+// writeSegment creates the segment file, and writes it with the function
+func (s *SeenSet) writeSegment(path string, durable bool, write func(f *os.File) error) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if err == nil && durable {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// writeManifest replaces the manifest with one listing the segments, through a temporary file that is
+// renamed over it, so that it is never partially written
+func (s *SeenSet) writeManifest(segments []seenSegment, durable bool) error {
+	m := s.manifest
+	m.Segments = segments
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, seenManifest)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil && durable {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	s.manifest = m
+	if durable {
+		return syncDir(s.dir)
+	}
+	return nil
+}
+
+// options returns the options of the runs sorting and merging the segments, which report nothing
+func (s *SeenSet) options() Options {
+	return Options{Delimiter: s.manifest.Delimiter, OnProgress: func(Progress) {}, OnEvent: func(Event) {}}
+}
+
+// delimiter returns what ends each line of the segment files
+func (s *SeenSet) delimiter() string {
+	if s.manifest.Delimiter == "" {
+		return "\n"
+	}
+	return s.manifest.Delimiter
+}
+
+// validateSeen returns an error if the lines of the run are not split like those of its SeenSet
+func validateSeen(opts Options) error {
+	if opts.Seen != nil && opts.Seen.manifest.Delimiter != opts.Delimiter {
+		return fmt.Errorf("seen set %s has lines split by %q, not %q", opts.Seen.dir, opts.Seen.manifest.Delimiter, opts.Delimiter)
+	}
+	return nil
+}
+
+// seen returns true if the line is in the Reference file or the Seen set of the run, if either
+func (j *job) seen(line string) (bool, error) {
+	if j.opts.Reference != nil {
+		if ok, err := j.opts.Reference.Contains(line); ok || err != nil {
+			return ok, err
+		}
+	}
+	if j.opts.Seen == nil {
+		return false, nil
+	}
+	return j.opts.Seen.Contains(line)
+}
+
+// addSeen adds a unique line as it is written to the output to the Seen set of the run, if any
+func (j *job) addSeen(line []byte) error {
+	if j.opts.Seen == nil {
+		return nil
+	}
+	return j.opts.Seen.add(line)
+}
+
+// addSeenKeys adds the sorted lines, when all lines fit in memory and are written directly to the
+// output, to the Seen set of the run, if any
+func (j *job) addSeenKeys(keys []string) error {
+	if j.opts.Seen == nil {
+		return nil
+	}
+	for _, key := range keys {
+		if err := j.opts.Seen.add([]byte(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finishSeen adds the lines written by the run to its Seen set, if any, once it has succeeded and the
+// output was written, and otherwise discards them. It returns the error of the run, if any.
+func (j *job) finishSeen(err error) error {
+	if j.opts.Seen == nil {
+		return err
+	}
+	if err != nil || j.opts.DryRun {
+		j.opts.Seen.discard()
+		return err
+	}
+	return j.opts.Seen.commit(j.opts.Sync || j.opts.Durable)
+}
@@ -0,0 +1,169 @@
+package dedup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunSeen(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dedup.test.seen.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// run deduplicates the input with the seen set, and returns the output and stats
+	run := func(seen *SeenSet, opts Options, input string) (string, Stats) {
+		t.Helper()
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+		opts.Seen = seen
+		stats, err := Run(outFile, opts, strings.NewReader(input), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content), stats
+	}
+
+	seen, err := OpenSeenSet(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seen.Close()
+	if out, stats := run(seen, Options{}, "b\na\nb\n"); out != "a\nb\n" || stats.LinesSeen != 0 {
+		t.Fatalf("Unexpected first run: %q %+v", out, stats)
+	}
+
+	// Only the lines no earlier run wrote are written, whether or not they fit in memory
+	if out, stats := run(seen, Options{TmpFileBytes: 1}, "c\na\nd\nc\na\n"); out != "c\nd\n" || stats.LinesSeen != 2 || stats.LinesUnique != 2 {
+		t.Fatalf("Unexpected second run: %q %+v", out, stats)
+	}
+
+	// A dry run adds nothing
+	if _, stats := run(seen, Options{DryRun: true}, "e\n"); stats.LinesUnique != 1 {
+		t.Fatalf("Unexpected dry run: %+v", stats)
+	}
+	if seen.Lines() != 4 {
+		t.Fatalf("Expected 4 lines in the seen set; Got: %d", seen.Lines())
+	}
+
+	// The lines of partitions by hash are not written in order, so they are sorted into their segment
+	var lines []string
+	for c := 'f'; c <= 'z'; c++ {
+		lines = append(lines, string(c))
+	}
+	input := strings.Join(lines, "\n") + "\n"
+	if out, _ := run(seen, Options{TmpFileBytes: 8, Partitions: 3}, "a\n"+input); len(out) != len(input) {
+		t.Fatalf("Unexpected partitioned run: %q", out)
+	}
+
+	// The seen set is kept on disk, and its segments are merged once there are too many
+	if err = seen.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if seen, err = OpenSeenSet(dir, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	defer seen.Close()
+	for i := 0; i < maxSeenSegments; i++ {
+		if out, _ := run(seen, Options{}, "a\n"+string(rune('A'+i))+"\n"); out != string(rune('A'+i))+"\n" {
+			t.Fatalf("Unexpected output of run %d: %q", i, out)
+		}
+	}
+	if len(seen.segments) > maxSeenSegments {
+		t.Fatalf("Expected at most %d segments; Got: %d", maxSeenSegments, len(seen.segments))
+	}
+	for _, line := range append(lines, "a", "b", "c", "d", "A", "H") {
+		if ok, err := seen.Contains(line); err != nil || !ok {
+			t.Fatalf("Expected %q to be seen: %v", line, err)
+		}
+	}
+	for _, line := range []string{"e", "I", ""} {
+		if ok, err := seen.Contains(line); err != nil || ok {
+			t.Fatalf("Expected %q not to be seen: %v", line, err)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1+len(seen.segments) {
+		t.Fatalf("Expected only the manifest and segments; Got: %v", files)
+	}
+
+	// The lines of every run must be split the same way
+	if _, err = Run(nil, Options{Delimiter: "\x00", Seen: seen, DryRun: true}, strings.NewReader("a\x00"), nil); err == nil {
+		t.Fatal("Expected an error with another delimiter")
+	}
+	if _, err = OpenSeenSet(dir, Options{Delimiter: "\x00"}); err == nil {
+		t.Fatal("Expected an error opening with another delimiter")
+	}
+}
+
+func TestRunReference(t *testing.T) {
+	refFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(refFile.Name())
+	defer refFile.Close()
+	if _, err = refFile.WriteString("a\nb\nd\n"); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := NewSortedFile(refFile, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the lines not in the reference are written, whether or not they fit in memory, and a line
+	// in both the reference and the seen set is counted once
+	seenDir, err := os.MkdirTemp("", "dedup.test.seen.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(seenDir)
+	seen, err := OpenSeenSet(seenDir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seen.Close()
+	for _, tc := range []struct {
+		opts     Options
+		expected string
+		seen     uint64
+	}{
+		{opts: Options{}, expected: "c\ne\n", seen: 3},
+		{opts: Options{TmpFileBytes: 1}, expected: "c\ne\n", seen: 3},
+		{opts: Options{Seen: seen}, expected: "c\ne\n", seen: 3},
+		{opts: Options{Seen: seen}, expected: "", seen: 6},
+	} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+		tc.opts.Reference = ref
+		stats, err := Run(outFile, tc.opts, strings.NewReader("c\na\ne\nb\nc\nd\n"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != tc.expected || stats.LinesSeen != tc.seen {
+			t.Fatalf("Unexpected output with %+v: %q %+v", tc.opts, content, stats)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ShardMode decides which output file of RunShards each unique line is written to
+type ShardMode string
+
+const (
+	// ShardHash writes each line to the shard chosen by its FNV-1a hash, so that the same line
+	// always goes to the same shard, no matter the rest of the input
+	ShardHash ShardMode = "hash"
+
+	// ShardRange writes contiguous ranges of the sorted lines to each shard, so that every line of
+	// a shard sorts before every line of the next one. When the lines do not fit in memory, the
+	// ranges are estimated from a sample of each chunk, so the shards are only about equal in size.
+	ShardRange ShardMode = "range"
+)
+
+// RunShards is the same as Run, but partitions the unique lines between the output files by
+// opts.ShardBy. Each output file is sorted and contains no duplicates, and no line is written to
+// more than one of them. Lines passed through are still written after the sorted lines.
+func RunShards(outFiles []*os.File, opts Options, inFile, inFileAgain io.Reader) (Stats, error) {
+	if len(outFiles) == 0 {
+		return Stats{}, fmt.Errorf("at least one output file is required")
+	}
+	switch opts.ShardBy {
+	case "", ShardHash, ShardRange:
+	default:
+		return Stats{}, fmt.Errorf("unknown shard mode: %q", opts.ShardBy)
+	}
+	if opts.Format.records() {
+		return Stats{}, fmt.Errorf("%s output is not supported with shards", opts.Format)
+	}
+	if opts.OnLineMapped != nil {
+		return Stats{}, fmt.Errorf("mapping lines is not supported with shards")
+	}
+	if opts.OnIndex != nil {
+		return Stats{}, fmt.Errorf("indexing the output is not supported with shards")
+	}
+	if keyed(opts) {
+		return Stats{}, fmt.Errorf("writing lines other than their keys is not supported with shards")
+	}
+	if opts.Update != nil {
+		return Stats{}, fmt.Errorf("updating a sorted file is not supported with shards")
+	}
+	if opts.CountOnly {
+		// The lines of each shard are only counted as they are written to it
+		opts.DryRun, opts.CountOnly = true, false
+	}
+	if opts.Partitions > 1 {
+		// Each shard is merged on its own, from the chunks of its own lines
+		opts.Partitions = len(outFiles)
+		opts.PartitionBy = opts.ShardBy
+	}
+	if err := checkRun(opts); err != nil {
+		return Stats{}, err
+	}
+
+	j, ctx, done := newJob(opts, opts.TempDir)
+	defer done()
+	j.startTrace("dedup.run_shards")
+
+	// A dry run discards everything that would have been written to the output files,
+	// but still counts the lines of each shard
+	outs := make([]io.Writer, len(outFiles))
+	finals := make([]*finalLineWriter, len(outFiles))
+	for i, outFile := range outFiles {
+		outs[i] = io.Discard
+		if !opts.DryRun {
+			outs[i] = j.limitWriter(j.syncWriter(outFile))
+		}
+		if finals[i] = j.withoutFinalLineEnding(outs[i]); finals[i] != nil {
+			outs[i] = finals[i]
+		}
+	}
+	j.shards = newShardWriter(outs, opts, j.lineEnding())
+
+	err := j.dedup(ctx, j.shards, inFile, inFileAgain)
+	if flushErr := j.shards.flush(); err == nil {
+		err = flushErr
+	}
+	for _, final := range finals {
+		if err == nil {
+			err = final.finish(&j.stats)
+		}
+	}
+	if !opts.DryRun {
+		for _, outFile := range outFiles {
+			if err == nil {
+				err = j.syncFile(outFile)
+			}
+			j.adviseWritten(outFile)
+		}
+	}
+	j.stats.ShardLines = j.shards.lines
+	err = j.finishSeen(err)
+	return j.summarize(err), err
+}
+
+// shardSamplesPerChunk is how many lines of each chunk are sampled to estimate the ranges of ShardRange
+const shardSamplesPerChunk = 1000
+
+// shardWriter is the output writer of RunShards, which splits what is written to it into lines,
+// and writes each of them to the buffered writer of its shard
+type shardWriter struct {
+	outs    []io.Writer
+	writers []*bufio.Writer
+	lines   []uint64
+	mode    ShardMode
+	limit   uint64
+	delim   []byte
+	pending []byte
+
+	// The first line of every shard but the first, and the samples they are chosen from, for ShardRange
+	boundaries []string
+	samples    []shardSample
+}
+
+// shardSample is a line of a chunk, standing in for the weight lines around it
+type shardSample struct {
+	line   string
+	weight uint64
+}
+
+// newShardWriter returns a writer partitioning lines between the writers
+func newShardWriter(outs []io.Writer, opts Options, delimiter string) *shardWriter {
+	w := &shardWriter{
+		outs:    outs,
+		writers: make([]*bufio.Writer, len(outs)),
+		lines:   make([]uint64, len(outs)),
+		mode:    opts.ShardBy,
+		limit:   opts.Limit,
+		delim:   []byte(delimiter),
+	}
+	for i, out := range outs {
+		w.writers[i] = bufio.NewWriterSize(out, bufferSize(opts.WriteBufferSize, defaultBufferSize))
+	}
+	return w
+}
+
+// splitSorted chooses the ranges of the shards from all of the sorted lines about to be written.
+// It is safe to call on a nil shardWriter, which does nothing, like the other split methods.
+func (w *shardWriter) splitSorted(keys []string) {
+	if w == nil || w.mode != ShardRange {
+		return
+	}
+	w.boundaries = w.boundaries[:0]
+	for i := 1; i < len(w.writers); i++ {
+		if at := len(keys) * i / len(w.writers); at < len(keys) {
+			w.boundaries = append(w.boundaries, keys[at])
+		}
+	}
+}
+
+// sample keeps evenly spaced lines of a sorted chunk, to later estimate the ranges of the shards
+func (w *shardWriter) sample(keys []string) {
+	if w == nil || w.mode != ShardRange || len(keys) == 0 {
+		return
+	}
+	step := (len(keys) + shardSamplesPerChunk - 1) / shardSamplesPerChunk
+	for i := 0; i < len(keys); i += step {
+		weight := step
+		if i+step > len(keys) {
+			weight = len(keys) - i
+		}
+		w.samples = append(w.samples, shardSample{line: cloneLine(keys[i]), weight: uint64(weight)})
+	}
+}
+
+// splitSampled chooses the ranges of the shards from the samples of every chunk, which are about to be merged
+func (w *shardWriter) splitSampled() {
+	if w == nil || w.mode != ShardRange {
+		return
+	}
+	sort.Slice(w.samples, func(a, b int) bool {
+		return w.samples[a].line < w.samples[b].line
+	})
+	var total uint64
+	for _, s := range w.samples {
+		total += s.weight
+	}
+	if w.limit > 0 && w.limit < total {
+		total = w.limit
+	}
+
+	// Each boundary is the first sample past its share of the total weight
+	w.boundaries = w.boundaries[:0]
+	var cumulative uint64
+	next := 1
+	for _, s := range w.samples {
+		for next < len(w.writers) && cumulative >= total*uint64(next)/uint64(len(w.writers)) {
+			if len(w.boundaries) == 0 || w.boundaries[len(w.boundaries)-1] != s.line {
+				w.boundaries = append(w.boundaries, s.line)
+			}
+			next++
+		}
+		cumulative += s.weight
+	}
+	w.samples = nil
+}
+
+// Name returns the names of the shards, for logging
+func (w *shardWriter) Name() string {
+	names := make([]string, len(w.outs))
+	for i, out := range w.outs {
+		names[i] = outputName(out)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Write writes every complete line to its shard, and keeps the rest until the next write
+func (w *shardWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(w.pending) > 0 {
+		w.pending = append(w.pending, p...)
+		p = w.pending
+	}
+	for {
+		i := bytes.Index(p, w.delim)
+		if i < 0 {
+			break
+		}
+		if err := w.writeLine(p[:i], p[:i+len(w.delim)]); err != nil {
+			return 0, err
+		}
+		p = p[i+len(w.delim):]
+	}
+	w.pending = append(w.pending[:0], p...)
+	return n, nil
+}
+
+// writeLine writes the record, which is the line followed by its delimiter, to the shard of the line
+func (w *shardWriter) writeLine(line, record []byte) error {
+	shard := w.shard(line)
+	w.lines[shard]++
+	_, err := w.writers[shard].Write(record)
+	return err
+}
+
+// shard returns the index of the shard the line is written to
+func (w *shardWriter) shard(line []byte) int {
+	if w.mode == ShardRange {
+		// The number of shards whose first line sorts at or before this one
+		return sort.Search(len(w.boundaries), func(i int) bool {
+			return w.boundaries[i] > string(line)
+		})
+	}
+	h := fnv.New32a()
+	h.Write(line)
+	return int(uint64(h.Sum32()) % uint64(len(w.writers)))
+}
+
+// flush writes any final line without a delimiter, then flushes every shard
+func (w *shardWriter) flush() error {
+	if len(w.pending) > 0 {
+		if err := w.writeLine(w.pending, w.pending); err != nil {
+			return err
+		}
+		w.pending = w.pending[:0]
+	}
+	for _, writer := range w.writers {
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
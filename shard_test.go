@@ -0,0 +1,92 @@
+package dedup
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRunShards(t *testing.T) {
+	var input []string
+	for i := 0; i < 100; i++ {
+		input = append(input, string(rune('a'+i%26))+string(rune('a'+i%7)))
+	}
+	expected := append([]string(nil), input...)
+	sort.Strings(expected)
+	expected = dedupSorted(expected)
+
+	// With partitions, each shard is merged on its own
+	for _, tc := range []struct {
+		mode       ShardMode
+		partitions int
+	}{{ShardHash, 0}, {ShardRange, 0}, {ShardHash, 2}, {ShardRange, 2}} {
+		mode := tc.mode
+
+		// Whether everything fits in memory or not, the shards partition the sorted output
+		for _, tmpFileBytes := range []uint64{1000, 20} {
+			outFiles := make([]*os.File, 3)
+			for i := range outFiles {
+				outFile, err := os.CreateTemp("", "dedup.test.*.log")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.Remove(outFile.Name())
+				defer outFile.Close()
+				outFiles[i] = outFile
+			}
+
+			opts := Options{TmpFileBytes: tmpFileBytes, ShardBy: mode, Partitions: tc.partitions}
+			stats, err := RunShards(outFiles, opts, strings.NewReader(strings.Join(input, "\n")), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.LinesUnique != uint64(len(expected)) || len(stats.ShardLines) != 3 {
+				t.Fatalf("Unexpected stats for %s: %+v", mode, stats)
+			}
+
+			var all []string
+			for i, outFile := range outFiles {
+				content, err := os.ReadFile(outFile.Name())
+				if err != nil {
+					t.Fatal(err)
+				}
+				lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+				if len(content) == 0 || uint64(len(lines)) != stats.ShardLines[i] {
+					t.Fatalf("Unexpected shard %d for %s with %d tmp file bytes: %q", i, mode, tmpFileBytes, content)
+				}
+				if !sort.StringsAreSorted(lines) {
+					t.Fatalf("Shard %d for %s is not sorted: %q", i, mode, content)
+				}
+				all = append(all, lines...)
+			}
+			if mode == ShardHash {
+				sort.Strings(all)
+			}
+			if !reflect.DeepEqual(all, expected) {
+				t.Fatalf("Unexpected lines for %s with %d tmp file bytes: %q", mode, tmpFileBytes, all)
+			}
+		}
+	}
+}
+
+func TestRunShardsInvalid(t *testing.T) {
+	if _, err := RunShards(nil, Options{}, strings.NewReader("a\n"), nil); err == nil {
+		t.Fatal("Expected an error without output files")
+	}
+	if _, err := RunShards([]*os.File{nil}, Options{ShardBy: "random"}, strings.NewReader("a\n"), nil); err == nil {
+		t.Fatal("Expected an error for an unknown shard mode")
+	}
+}
+
+// dedupSorted removes the adjacent duplicates of a sorted slice
+func dedupSorted(lines []string) []string {
+	var unique []string
+	for i, line := range lines {
+		if i == 0 || line != lines[i-1] {
+			unique = append(unique, line)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,142 @@
+package dedup
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math"
+)
+
+// CountSketch is a Count-Min sketch of how many times each line was read, in a fixed amount of memory
+// however many distinct lines there are. Each line is counted in one counter of each row, chosen by its
+// hash, and its count is the smallest of its counters, which is never less than the number of times it
+// was read, and only more where other lines were counted in all of the same counters. Only the
+// counters a line is the smallest of are incremented, which keeps the other lines' counts lower.
+// Counts can be read concurrently, but not while lines are being added.
+type CountSketch struct {
+	seed   maphash.Seed
+	width  uint64
+	depth  int
+	counts []uint32
+	lines  uint64
+}
+
+// NewCountSketch returns an empty sketch whose count of any line is more than the times it was read
+// by at most epsilon times the number of lines added, with a probability of at least 1 - delta.
+// It uses 4 * ceil(e / epsilon) * ceil(ln(1 / delta)) bytes, such as 1.1 MB for an epsilon of
+// 0.00005 (1 in 20000 lines) and a delta of 0.01.
+func NewCountSketch(epsilon, delta float64) (*CountSketch, error) {
+	if !(epsilon > 0 && epsilon < 1) {
+		return nil, fmt.Errorf("count sketch epsilon must be more than 0 and less than 1")
+	}
+	if !(delta > 0 && delta < 1) {
+		return nil, fmt.Errorf("count sketch delta must be more than 0 and less than 1")
+	}
+	width := uint64(math.Ceil(math.E / epsilon))
+	depth := int(math.Ceil(math.Log(1 / delta)))
+	if float64(width)*float64(depth) > math.MaxInt32 {
+		return nil, fmt.Errorf("count sketch epsilon and delta need too many counters")
+	}
+	return &CountSketch{
+		seed:   maphash.MakeSeed(),
+		width:  width,
+		depth:  depth,
+		counts: make([]uint32, width*uint64(depth)),
+	}, nil
+}
+
+// Add counts one more occurrence of the line
+func (s *CountSketch) Add(line string) {
+	var h maphash.Hash
+	h.SetSeed(s.seed)
+	h.WriteString(line)
+	s.add(h.Sum64())
+}
+
+// Count returns the approximate number of times the line was added, which is never less than the
+// actual number of times
+func (s *CountSketch) Count(line string) uint64 {
+	var h maphash.Hash
+	h.SetSeed(s.seed)
+	h.WriteString(line)
+	return s.count(h.Sum64())
+}
+
+// Lines returns how many lines were added, which the error of the counts is proportional to
+func (s *CountSketch) Lines() uint64 {
+	return s.lines
+}
+
+// Size returns the number of bytes of the counters of the sketch
+func (s *CountSketch) Size() uint64 {
+	return uint64(len(s.counts)) * 4
+}
+
+// addBytes counts one more occurrence of the line without copying it into a string
+func (s *CountSketch) addBytes(line []byte) {
+	var h maphash.Hash
+	h.SetSeed(s.seed)
+	h.Write(line)
+	s.add(h.Sum64())
+}
+
+// countBytes returns the approximate count of the line without copying it into a string
+func (s *CountSketch) countBytes(line []byte) uint64 {
+	var h maphash.Hash
+	h.SetSeed(s.seed)
+	h.Write(line)
+	return s.count(h.Sum64())
+}
+
+// add increments the smallest counters of the hash, and no others, saturating at the largest count
+func (s *CountSketch) add(hash uint64) {
+	s.lines++
+	min := s.count(hash)
+	if min == math.MaxUint32 {
+		return
+	}
+	for row := 0; row < s.depth; row++ {
+		if i := s.index(hash, row); uint64(s.counts[i]) == min {
+			s.counts[i]++
+		}
+	}
+}
+
+// count returns the smallest counter of the hash
+func (s *CountSketch) count(hash uint64) uint64 {
+	min := uint32(math.MaxUint32)
+	for row := 0; row < s.depth; row++ {
+		if c := s.counts[s.index(hash, row)]; c < min {
+			min = c
+		}
+	}
+	return uint64(min)
+}
+
+// index returns the index of the counter of the hash in the row. The counter of each row is chosen by
+// combining the two halves of the hash, which is as good as a hash per row.
+func (s *CountSketch) index(hash uint64, row int) uint64 {
+	h1, h2 := hash&math.MaxUint32, hash>>32
+	return uint64(row)*s.width + (h1+uint64(row)*h2)%s.width
+}
+
+// sketchCount calls OnSketchCount with a unique line as it is merged into the output, and its count
+func (j *job) sketchCount(line []byte) error {
+	if j.opts.CountSketch == nil || j.opts.OnSketchCount == nil {
+		return nil
+	}
+	return j.opts.OnSketchCount(string(line), j.opts.CountSketch.countBytes(line))
+}
+
+// sketchCounts calls OnSketchCount with each of the sorted lines and its count, when all lines fit in
+// memory and are written directly to the output
+func (j *job) sketchCounts(keys []string) error {
+	if j.opts.CountSketch == nil || j.opts.OnSketchCount == nil {
+		return nil
+	}
+	for _, key := range keys {
+		if err := j.opts.OnSketchCount(key, j.opts.CountSketch.Count(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
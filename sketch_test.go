@@ -0,0 +1,92 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCountSketch(t *testing.T) {
+	s, err := NewCountSketch(0.001, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]uint64)
+	for i := 0; i < 20000; i++ {
+		line := fmt.Sprint(i % (i%7 + 1000))
+		s.Add(line)
+		counts[line]++
+	}
+	if s.Lines() != 20000 {
+		t.Fatalf("Expected 20000 lines; Got: %d", s.Lines())
+	}
+
+	// No count is less than the actual count, and few are more than epsilon times the lines over it
+	over := 0
+	for line, count := range counts {
+		estimate := s.Count(line)
+		if estimate < count {
+			t.Fatalf("Expected a count of at least %d for %q; Got: %d", count, line, estimate)
+		}
+		if estimate > count+20 {
+			over++
+		}
+	}
+	if over > len(counts)/100 {
+		t.Fatalf("Expected at most 1%% of the counts over by more than 20; Got: %d of %d", over, len(counts))
+	}
+	if s.Count("never added") > 20 {
+		t.Fatalf("Expected a small count for a line never added; Got: %d", s.Count("never added"))
+	}
+
+	if _, err := NewCountSketch(0, 0.01); err == nil {
+		t.Fatal("Expected an error for an epsilon of 0")
+	}
+	if _, err := NewCountSketch(0.01, 1); err == nil {
+		t.Fatal("Expected an error for a delta of 1")
+	}
+}
+
+func TestRunCountSketch(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&input, "line %d\n", i%(i%3+100))
+	}
+
+	// The counts are the same whether the lines are written directly or merged from chunks
+	for _, tmpFileBytes := range []uint64{0, 200} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		sketch, err := NewCountSketch(0.0001, 0.01)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var counted []string
+		var total uint64
+		opts := Options{TmpFileBytes: tmpFileBytes, CountSketch: sketch, OnSketchCount: func(line string, count uint64) error {
+			counted = append(counted, line)
+			total += count
+			return nil
+		}}
+		stats, err := Run(outFile, opts, strings.NewReader(input.String()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(counted, "\n")+"\n" != string(b) {
+			t.Fatalf("Expected a count of every line written, with %d bytes of chunks", tmpFileBytes)
+		}
+		if total != stats.LinesRead || sketch.Lines() != stats.LinesRead {
+			t.Fatalf("Expected counts totaling %d lines; Got: %d, of %d lines in the sketch", stats.LinesRead, total, sketch.Lines())
+		}
+	}
+}
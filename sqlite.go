@@ -0,0 +1,61 @@
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// sqliteStart returns the statements starting the script of FormatSQLite
+func (j *job) sqliteStart() string {
+	table := j.table()
+	return "BEGIN;\n" +
+		"CREATE TABLE IF NOT EXISTS " + table + " (line TEXT NOT NULL, count INTEGER NOT NULL, source TEXT, line_number INTEGER);\n" +
+		"CREATE UNIQUE INDEX IF NOT EXISTS " + quoteIdentifier(j.tableName()+"_line") + " ON " + table + " (line);\n"
+}
+
+// writeSQLiteRecord writes the record as a statement on a line of its own, that inserts its line into
+// the table, or adds its count to that of the line if already there. It returns how many bytes were
+// written.
+func (j *job) writeSQLiteRecord(w *bufio.Writer, rec jsonRecord) (uint64, error) {
+	b := &j.record
+	b.Reset()
+	b.WriteString("INSERT INTO ")
+	b.WriteString(j.table())
+	b.WriteString(" (line, count, source, line_number) VALUES (")
+	writeSQLString(b, rec.Line)
+	b.WriteString(", ")
+	b.WriteString(strconv.FormatUint(rec.Count, 10))
+	b.WriteString(", ")
+	if rec.Source == "" {
+		b.WriteString("NULL")
+	} else {
+		writeSQLString(b, rec.Source)
+	}
+	b.WriteString(", ")
+	if rec.LineNumber == 0 {
+		b.WriteString("NULL")
+	} else {
+		b.WriteString(strconv.FormatUint(rec.LineNumber, 10))
+	}
+	b.WriteString(") ON CONFLICT (line) DO UPDATE SET count = count + excluded.count;\n")
+	n, err := w.Write(b.Bytes())
+	return uint64(n), err
+}
+
+// writeSQLString writes the string as a SQL literal. A string that is not valid UTF-8, or has a line
+// break or NUL, is written as a blob cast to text, so that every statement stays on a line of its own.
+func writeSQLString(b *bytes.Buffer, s string) {
+	if !utf8.ValidString(s) || strings.ContainsAny(s, "\x00\n\r") {
+		b.WriteString("CAST(X'")
+		b.WriteString(hex.EncodeToString([]byte(s)))
+		b.WriteString("' AS TEXT)")
+		return
+	}
+	b.WriteByte('\'')
+	b.WriteString(strings.ReplaceAll(s, "'", "''"))
+	b.WriteByte('\'')
+}
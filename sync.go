@@ -0,0 +1,59 @@
+package dedup
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// periodicSync is a writer to a file that syncs the file to storage every so many bytes written to it,
+// so that the data written is never far ahead of what is stored, rather than all written back at once
+type periodicSync struct {
+	f     *os.File
+	every uint64
+	since uint64
+}
+
+func (w *periodicSync) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.since += uint64(n)
+	if err == nil && w.since >= w.every {
+		w.since = 0
+		err = w.f.Sync()
+	}
+	return n, err
+}
+
+// Name returns the name of the file written to, to log it by
+func (w *periodicSync) Name() string {
+	return w.f.Name()
+}
+
+// syncWriter returns a writer to the file that syncs it every SyncBytes written to it, or the file itself
+// without SyncBytes
+func (j *job) syncWriter(f *os.File) io.Writer {
+	if j.opts.SyncBytes == 0 {
+		return f
+	}
+	return &periodicSync{f: f, every: j.opts.SyncBytes}
+}
+
+// syncFile syncs a file that has been written to storage, with Sync or SyncBytes, and with Durable,
+// its directory as well
+func (j *job) syncFile(f *os.File) error {
+	if !j.opts.Sync && !j.opts.Durable && j.opts.SyncBytes == 0 {
+		return nil
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if !j.opts.Durable {
+		return nil
+	}
+	// Only a regular file has a directory entry to sync, unlike a pipe or terminal
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return err
+	}
+	return syncDir(filepath.Dir(f.Name()))
+}
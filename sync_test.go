@@ -0,0 +1,80 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPeriodicSync(t *testing.T) {
+	f, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := (&job{opts: Options{SyncBytes: 10}}).syncWriter(f).(*periodicSync)
+	for i, expected := range []uint64{6, 0, 6} {
+		if _, err := w.Write([]byte("line " + fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+		if w.since != expected {
+			t.Fatalf("Expected %d bytes since the last sync; Got: %d", expected, w.since)
+		}
+	}
+	if w.Name() != f.Name() {
+		t.Fatalf("Expected the name of the file; Got: %s", w.Name())
+	}
+	if (&job{}).syncWriter(f) != f {
+		t.Fatal("Expected the file itself without SyncBytes")
+	}
+}
+
+func TestRunSync(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&input, "%04d\n", i%700)
+	}
+
+	outFile, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+
+	opts := Options{TmpFileBytes: 1000, Sync: true, SyncBytes: 512, WriteBufferSize: 256}
+	stats, err := Run(outFile, opts, strings.NewReader(input.String()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.LinesUnique != 700 || stats.Chunks < 2 || stats.BytesOut != 3500 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestSyncFileDurable(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString("line\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err = (&job{opts: Options{Durable: true}}).syncFile(f); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file that was removed from its directory can not have it synced
+	if err = os.RemoveAll(filepath.Dir(f.Name())); err != nil {
+		t.Fatal(err)
+	}
+	if err = (&job{opts: Options{Durable: true}}).syncFile(f); err == nil && runtime.GOOS != "windows" {
+		t.Fatal("Expected an error syncing the directory of a removed file")
+	}
+}
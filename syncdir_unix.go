@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package dedup
+
+import "os"
+
+// syncDir syncs the entries of the directory to storage, so that the files created in it are not lost
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
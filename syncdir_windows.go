@@ -0,0 +1,7 @@
+package dedup
+
+// syncDir does nothing, as windows can not sync directories, and the files created in them are
+// already durable once synced
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,106 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// owner tags the names of the temporary files of this process, so that files left behind can be
+// traced back to the run that wrote them: the host name, with any dots replaced, and the process id
+var owner = fmt.Sprintf("%s-%d", hostTag(), os.Getpid())
+
+// hostTag returns the host name, in a form that can be part of a temporary file name
+func hostTag() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '-', '/', '\\', '*', ' ':
+			return '_'
+		}
+		return r
+	}, host)
+}
+
+// tempFileName matches the names of the temporary files of a run, with the owner tag if any.
+// The files of runs from before temporary files were tagged only have a random number.
+var tempFileName = regexp.MustCompile(`^dedup\.(?:([^.]+)-(\d+)\.)?(?:[a-z]+\.)?\d+\.(?:log|meta)$`)
+
+// CreateTemp creates a new temporary file in dir (or os.TempDir, if empty), with the pattern
+// (as for os.CreateTemp) after a prefix that tags the file with its owner, so that it is found by
+// FindTempFiles if it is left behind
+func CreateTemp(dir, pattern string) (*os.File, error) {
+	return os.CreateTemp(dir, "dedup."+owner+"."+pattern)
+}
+
+// TempFile is a temporary file written by a run
+type TempFile struct {
+	// Path is the path of the file
+	Path string
+
+	// ModTime is when the file was last written to
+	ModTime time.Time
+
+	// Size is the byte size of the file
+	Size int64
+
+	// Host is the host name of the run that wrote the file, with any dots replaced by underscores,
+	// or empty if the file is not tagged
+	Host string
+
+	// PID is the process id of the run that wrote the file, or zero if the file is not tagged
+	PID int
+}
+
+// FindTempFiles returns the temporary files written by runs in dir (or os.TempDir, if empty),
+// whether they are still running or not
+func FindTempFiles(dir string) ([]TempFile, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []TempFile
+	for _, entry := range entries {
+		match := tempFileName.FindStringSubmatch(entry.Name())
+		if match == nil || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since the directory was read
+			continue
+		}
+		f := TempFile{Path: filepath.Join(dir, entry.Name()), ModTime: info.ModTime(), Size: info.Size(), Host: match[1]}
+		if match[2] != "" {
+			f.PID, _ = strconv.Atoi(match[2])
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// Own returns true if the file was written by this process
+func (f TempFile) Own() bool {
+	return f.PID == os.Getpid() && f.Host == hostTag()
+}
+
+// Running returns true if the run that wrote the file is still running on this host.
+// Whether runs on other hosts, or that did not tag their files, are running can not be known,
+// so they are assumed not to be.
+func (f TempFile) Running() bool {
+	if f.PID == 0 || f.Host != hostTag() {
+		return false
+	}
+	return f.PID == os.Getpid() || processRunning(f.PID)
+}
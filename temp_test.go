@@ -0,0 +1,50 @@
+package dedup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindTempFiles(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dedup.test.dir.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tagged, err := CreateTemp(dir, "*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagged.Close()
+
+	// Files of older runs, of other hosts, and files that are not from runs at all
+	for _, name := range []string{"dedup.123.log", "dedup.other_host-1.passthrough.456.meta", "dedup.log", "other.123.log"} {
+		if err = os.WriteFile(filepath.Join(dir, name), []byte("a\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := FindTempFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]TempFile, len(files))
+	for _, f := range files {
+		found[filepath.Base(f.Path)] = f
+	}
+	if len(found) != 3 {
+		t.Fatalf("Expected 3 temp files; Got: %v", files)
+	}
+
+	if f, ok := found[filepath.Base(tagged.Name())]; !ok || f.PID != os.Getpid() || f.Host != hostTag() || !f.Running() {
+		t.Errorf("Expected the file of this process to be running; Got: %+v", f)
+	}
+	if f := found["dedup.123.log"]; f.PID != 0 || f.Host != "" || f.Running() || f.Size != 2 {
+		t.Errorf("Expected an untagged file that is not running; Got: %+v", f)
+	}
+	if f := found["dedup.other_host-1.passthrough.456.meta"]; f.PID != 1 || f.Host != "other_host" || f.Running() {
+		t.Errorf("Expected a file of another host that is not running; Got: %+v", f)
+	}
+}
@@ -0,0 +1,63 @@
+package dedup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// ErrMaxTmpBytes is returned, wrapped with what to do about it, when writing the temporary files
+// would take more than MaxTmpBytes
+var ErrMaxTmpBytes = errors.New("temporary files would exceed the max tmp bytes")
+
+// tmpCap is the total bytes written to the temporary chunk files of a run, shared by the workers
+// writing them, and the most they may be, with MaxTmpBytes
+type tmpCap struct {
+	written uint64 // First, so that it is aligned for atomic operations on 32 bit platforms
+	max     uint64
+}
+
+// newTmpCap returns a cap of the bytes, or nil if it is zero, for no cap
+func newTmpCap(max uint64) *tmpCap {
+	if max == 0 {
+		return nil
+	}
+	return &tmpCap{max: max}
+}
+
+// add counts bytes already written, such as the chunks of a resumed checkpoint
+func (c *tmpCap) add(n uint64) {
+	if c != nil {
+		atomic.AddUint64(&c.written, n)
+	}
+}
+
+// err returns the error of the cap being exceeded
+func (c *tmpCap) err() error {
+	return fmt.Errorf("%w of %d bytes: raise it on a larger temp dir, or give each temporary file more memory "+
+		"with TmpFileBytes or Memory, so that more of the duplicates are removed before they are spilled", ErrMaxTmpBytes, c.max)
+}
+
+// cappedWriter is a writer of a temporary file that fails, without writing, once the bytes written to
+// every temporary file would be more than the cap
+type cappedWriter struct {
+	w   io.Writer
+	cap *tmpCap
+}
+
+func (w cappedWriter) Write(p []byte) (int, error) {
+	if atomic.AddUint64(&w.cap.written, uint64(len(p))) > w.cap.max {
+		return 0, w.cap.err()
+	}
+	return w.w.Write(p)
+}
+
+// capTmp returns the writer of a temporary file counted towards MaxTmpBytes, or the writer itself
+// without a cap
+func (j *job) capTmp(w io.Writer) io.Writer {
+	if j.tmpCap == nil {
+		return w
+	}
+	return cappedWriter{w: w, cap: j.tmpCap}
+}
@@ -0,0 +1,48 @@
+package dedup
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestRunMaxTmpBytes(t *testing.T) {
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+
+	// The temporary files fit under the cap of their own size
+	dir := t.TempDir()
+	stats, err := Run(nil, Options{TmpFileBytes: 20 * 50, TempDir: dir, DryRun: true}, inFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = inFile.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	capped, err := Run(nil, Options{TmpFileBytes: 20 * 50, TempDir: dir, MaxTmpBytes: stats.TmpBytes, DryRun: true}, inFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capped.LinesUnique != stats.LinesUnique {
+		t.Fatalf("Expected %d unique lines; Got: %d", stats.LinesUnique, capped.LinesUnique)
+	}
+
+	// But not under a byte less, and none are left behind
+	if _, err = inFile.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Run(nil, Options{TmpFileBytes: 20 * 50, TempDir: dir, MaxTmpBytes: stats.TmpBytes - 1, DryRun: true, SortWorkers: 2, Workers: 2}, inFile, nil)
+	if !errors.Is(err, ErrMaxTmpBytes) {
+		t.Fatalf("Expected the max tmp bytes to be exceeded; Got: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("Expected no temporary files left; Got: %d", len(entries))
+	}
+}
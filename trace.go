@@ -0,0 +1,169 @@
+package dedup
+
+import (
+	"context"
+)
+
+// Tracer starts the spans of the runs given it as their Tracer option, so that their phases show up in
+// the traces of the application. It is what an OpenTelemetry trace.Tracer needs to be adapted to, with
+// a Span that passes its attributes on as attribute.KeyValue, without this package depending on it.
+type Tracer interface {
+	// Start starts a span that is a child of the span of the context, if any, and returns it with a
+	// context holding it
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer. Its methods are never called concurrently.
+type Span interface {
+	// SetAttributes sets attributes of the span
+	SetAttributes(attrs ...Attribute)
+
+	// AddEvent records something that happened during the span, with its attributes
+	AddEvent(name string, attrs ...Attribute)
+
+	// RecordError records the error the span failed with
+	RecordError(err error)
+
+	// End ends the span
+	End()
+}
+
+// Attribute is a key and value of a Span or its event. The value is a string, an int64, or a bool.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// stringAttribute returns an attribute with a string value
+func stringAttribute(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// intAttribute returns an attribute with an int64 value
+func intAttribute(key string, value uint64) Attribute {
+	return Attribute{Key: key, Value: int64(value)}
+}
+
+// tracing is the spans of a run: the span of the whole run, the spans of its phases, which are its
+// children, and the spans of the chunks being written, which are children of the phase they are
+// written in. It is kept by the reporter, and only used with its lock held.
+type tracing struct {
+	tracer Tracer
+	ctx    context.Context
+	run    Span
+	phases map[Phase][]Span
+	chunks map[string]Span
+
+	// The context holding the span of each phase that started last, whose chunks are its children,
+	// even those written once it has finished, such as the last chunk of splitting
+	phaseCtx map[Phase]context.Context
+}
+
+// startTrace starts the span of the run, with the name, if it has a Tracer. The span is a child of the
+// span of the Context of the run, if any.
+func (j *job) startTrace(name string) {
+	if j.opts.Tracer == nil {
+		return
+	}
+	parent := j.opts.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, span := j.opts.Tracer.Start(parent, name)
+	span.SetAttributes(intAttribute("dedup.tmp_file_bytes", j.opts.TmpFileBytes))
+	j.mu.Lock()
+	j.tracing = &tracing{
+		tracer: j.opts.Tracer,
+		ctx:    ctx,
+		run:    span,
+		phases: make(map[Phase][]Span),
+		chunks: make(map[string]Span),
+
+		phaseCtx: make(map[Phase]context.Context),
+	}
+	j.mu.Unlock()
+}
+
+// endTrace ends the span of the run, with its stats and error, and any spans of phases and chunks it
+// left unfinished
+func (j *job) endTrace(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	t := j.tracing
+	if t == nil {
+		return
+	}
+	j.tracing = nil
+	for _, span := range t.chunks {
+		span.End()
+	}
+	for _, spans := range t.phases {
+		for _, span := range spans {
+			span.End()
+		}
+	}
+	t.run.SetAttributes(
+		intAttribute("dedup.input_bytes", j.inputSize),
+		intAttribute("dedup.lines_read", j.stats.LinesRead),
+		intAttribute("dedup.bytes_read", j.stats.BytesRead),
+		intAttribute("dedup.lines_unique", j.stats.LinesUnique),
+		intAttribute("dedup.lines_duplicate", j.stats.LinesDuplicate),
+		intAttribute("dedup.bytes_out", j.stats.BytesOut),
+		intAttribute("dedup.chunks", uint64(j.stats.Chunks)),
+		intAttribute("dedup.tmp_bytes", j.stats.TmpBytes),
+	)
+	if err != nil {
+		t.run.RecordError(err)
+	}
+	t.run.End()
+}
+
+// beginPhase starts the span of a phase, as a child of the span of the run
+func (t *tracing) beginPhase(phase Phase) {
+	ctx, span := t.tracer.Start(t.ctx, "dedup."+string(phase))
+	t.phases[phase] = append(t.phases[phase], span)
+	t.phaseCtx[phase] = ctx
+}
+
+// event adds the event to the spans, ending the span of a phase once it has finished, and those of
+// chunks once they have been written. A phase that runs more than once at a time, such as merging
+// partitions, ends its spans in the order they started.
+func (t *tracing) event(e Event) {
+	switch e.Kind {
+	case EventPhaseFinished:
+		spans := t.phases[e.Phase]
+		if len(spans) == 0 {
+			return
+		}
+		spans[0].SetAttributes(intAttribute("dedup.lines", e.Lines), intAttribute("dedup.bytes", e.Bytes))
+		spans[0].End()
+		t.phases[e.Phase] = spans[1:]
+	case EventChunkCreated:
+		ctx := t.ctx
+		if phaseCtx, ok := t.phaseCtx[e.Phase]; ok {
+			ctx = phaseCtx
+		}
+		_, span := t.tracer.Start(ctx, "dedup.chunk")
+		span.SetAttributes(stringAttribute("dedup.file", e.File))
+		t.chunks[e.File] = span
+	case EventChunkSorted:
+		if span := t.chunks[e.File]; span != nil {
+			span.AddEvent("dedup.chunk_sorted", intAttribute("dedup.lines", e.Lines),
+				intAttribute("dedup.sort_ns", uint64(e.Duration)))
+		}
+	case EventChunkWritten:
+		if span := t.chunks[e.File]; span != nil {
+			span.SetAttributes(intAttribute("dedup.lines", e.Lines), intAttribute("dedup.bytes", e.Bytes))
+			span.End()
+			delete(t.chunks, e.File)
+		}
+	case EventWarning:
+		attrs := []Attribute{stringAttribute("dedup.message", e.Message), stringAttribute("dedup.phase", string(e.Phase))}
+		if e.Err != nil {
+			attrs = append(attrs, stringAttribute("dedup.error", e.Err.Error()))
+		}
+		t.run.AddEvent("dedup.warning", attrs...)
+	case EventChunkMerged, EventChunkVerified, EventOutputVerified, EventResumed:
+		t.run.AddEvent("dedup."+string(e.Kind), stringAttribute("dedup.file", e.File), intAttribute("dedup.lines", e.Lines))
+	}
+}
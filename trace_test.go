@@ -0,0 +1,151 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+)
+
+// testSpan is a span recorded by testTracer
+type testSpan struct {
+	name   string
+	parent *testSpan
+	attrs  map[string]interface{}
+	events []string
+	err    error
+	ended  bool
+}
+
+func (s *testSpan) SetAttributes(attrs ...Attribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *testSpan) AddEvent(name string, attrs ...Attribute) { s.events = append(s.events, name) }
+func (s *testSpan) RecordError(err error)                    { s.err = err }
+func (s *testSpan) End()                                     { s.ended = true }
+
+type testSpanKey struct{}
+
+// testTracer records the spans it starts, with the span of the context as their parent
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parent, _ := ctx.Value(testSpanKey{}).(*testSpan)
+	s := &testSpan{name: name, parent: parent, attrs: make(map[string]interface{})}
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, testSpanKey{}, s), s
+}
+
+// named returns the spans with the name
+func (t *testTracer) named(name string) []*testSpan {
+	var spans []*testSpan
+	for _, s := range t.spans {
+		if s.name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func TestRunTracer(t *testing.T) {
+	inFile, err := os.Open("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inFile.Close()
+
+	// The run is a child of the span of the context it is given
+	tracer := &testTracer{}
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	opts := Options{
+		TmpFileBytes: 20 * 50,
+		DryRun:       true,
+		Context:      ctx,
+		Tracer:       tracer,
+		OnProgress:   func(Progress) {},
+		OnEvent:      func(Event) {},
+	}
+
+	// testdata.log has 100 distinct lines of 50 characters, 204 total lines.
+	stats, err := Run(nil, opts, inFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := tracer.named("dedup.run")
+	if len(runs) != 1 || runs[0].parent != parent || !runs[0].ended || runs[0].err != nil {
+		t.Fatalf("Unexpected run spans: %+v", runs)
+	}
+	run := runs[0]
+	if run.attrs["dedup.lines_read"] != int64(204) || run.attrs["dedup.lines_unique"] != int64(100) ||
+		run.attrs["dedup.chunks"] != int64(stats.Chunks) || run.attrs["dedup.bytes_read"] != int64(204*51) {
+		t.Fatalf("Unexpected run span attributes: %+v", run.attrs)
+	}
+
+	// Each phase is a child of the run, and each chunk a child of the phase it was written in
+	for _, phase := range []Phase{PhaseSplitting, PhaseMerging} {
+		spans := tracer.named("dedup." + string(phase))
+		if len(spans) != 1 || spans[0].parent != run || !spans[0].ended {
+			t.Fatalf("Unexpected %s spans: %+v", phase, spans)
+		}
+	}
+	splitting := tracer.named("dedup." + string(PhaseSplitting))[0]
+	if splitting.attrs["dedup.lines"] != int64(204) {
+		t.Fatalf("Unexpected splitting span attributes: %+v", splitting.attrs)
+	}
+	chunks := tracer.named("dedup.chunk")
+	if len(chunks) != stats.Chunks {
+		t.Fatalf("Expected %d chunk spans; Got: %d", stats.Chunks, len(chunks))
+	}
+	var chunkLines int64
+	for _, chunk := range chunks {
+		if chunk.parent != splitting || !chunk.ended || chunk.attrs["dedup.file"] == "" {
+			t.Fatalf("Unexpected chunk span: %+v", chunk)
+		}
+		chunkLines += chunk.attrs["dedup.lines"].(int64)
+	}
+	if uint64(chunkLines) != stats.TmpLines {
+		t.Fatalf("Expected the chunk spans to have %d lines; Got: %d", stats.TmpLines, chunkLines)
+	}
+
+	// A failed merge records its error
+	tracer = &testTracer{}
+	_, err = Merge(nil, Options{DryRun: true, Tracer: tracer, OnProgress: func(Progress) {}, OnEvent: func(Event) {}},
+		writeTemp(t, "b\na\n"))
+	merges := tracer.named("dedup.merge")
+	if err == nil || len(merges) != 1 || !errors.Is(merges[0].err, err) || !merges[0].ended {
+		t.Fatalf("Expected the merge span to record the error %v; Got: %+v", err, merges)
+	}
+	for _, s := range tracer.spans {
+		if !s.ended {
+			t.Fatalf("Expected every span to be ended; Got: %+v", s)
+		}
+	}
+}
+
+// writeTemp returns a temporary file with the content, at its start, which is removed once the test ends
+func writeTemp(t *testing.T, content string) *os.File {
+	f, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(f.Name())
+	})
+	if _, err = f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
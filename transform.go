@@ -0,0 +1,100 @@
+package dedup
+
+import (
+	"fmt"
+)
+
+// TransformMode is which of a line as it was read, and as it was transformed by the transform rules and
+// Transform, the line is deduplicated by, and which is written
+type TransformMode string
+
+const (
+	// TransformLines deduplicates the lines as they were transformed, and writes them as such
+	TransformLines TransformMode = "lines"
+
+	// TransformKeys deduplicates the lines as they were transformed, but writes the first line read of
+	// each as it was read, such as to deduplicate lines by what is left of them once normalized
+	TransformKeys TransformMode = "keys"
+
+	// TransformOutput deduplicates the lines as they were read, but writes them as they were transformed,
+	// such as to redact credentials from them. Lines differing only in what was transformed are then each
+	// written, the same, and the output is sorted by the lines as they were read.
+	TransformOutput TransformMode = "output"
+)
+
+// keyed returns true if the lines are deduplicated by something other than the text written for them,
+// which is then kept in the meta of each line, along with where it was first read
+func keyed(opts Options) bool {
+	if opts.WriteKeys {
+		return false
+	}
+	if hasKey(opts) {
+		return true
+	}
+	if opts.TransformMode != TransformKeys && opts.TransformMode != TransformOutput {
+		return false
+	}
+	return opts.Transform != nil || opts.StripANSIOutput || hasTransforms(opts.Rules)
+}
+
+// keyed returns true if the lines are deduplicated by something other than the text written for them
+func (j *job) keyed() bool {
+	return keyed(j.opts)
+}
+
+// transform returns what the line is deduplicated by, and the text written for it if it is keyed,
+// from the line as it was read, and as it was filtered by the rules. The key of the line is of what
+// the transform mode deduplicates it by.
+func (j *job) transform(read, line string) (string, string) {
+	if j.opts.StripANSIOutput {
+		line = stripANSI(line)
+	}
+	if j.opts.Transform != nil {
+		line = j.opts.Transform(line)
+	}
+	text := line
+	switch j.opts.TransformMode {
+	case TransformKeys:
+		text = read
+	case TransformOutput:
+		line = read
+	}
+	line = j.key(line)
+	if !j.keyed() {
+		return line, ""
+	}
+	return line, text
+}
+
+// texts returns the text written for each of the sorted lines, which is the line itself unless
+// they are keyed
+func (j *job) texts(keys []string) []string {
+	if !j.keyed() {
+		return keys
+	}
+	texts := make([]string, len(keys))
+	for i, key := range keys {
+		texts[i] = j.meta[key].text
+	}
+	return texts
+}
+
+// validateTransform returns an error if the transform mode is unknown, or the text written for keyed
+// lines can not be kept with the other options
+func validateTransform(opts Options) error {
+	switch opts.TransformMode {
+	case "", TransformLines, TransformKeys, TransformOutput:
+	default:
+		return fmt.Errorf("unknown transform mode: %q", opts.TransformMode)
+	}
+	if !keyed(opts) {
+		return nil
+	}
+	switch {
+	case opts.Checkpoint != "":
+		return fmt.Errorf("checkpoints are not supported when writing lines other than their keys")
+	case opts.OnIndex != nil:
+		return fmt.Errorf("indexing the output is not supported when writing lines other than their keys")
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package dedup
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRunTransform(t *testing.T) {
+	token := regexp.MustCompile(`token=\w+`)
+	redact := func(line string) string {
+		return token.ReplaceAllString(line, "token=***")
+	}
+	input := "b token=2\na token=3\nb token=2\na token=1\nc\n"
+
+	tests := []struct {
+		mode       TransformMode
+		expected   string
+		duplicates uint64
+	}{
+		{mode: "", expected: "a token=***\nb token=***\nc\n", duplicates: 2},
+		{mode: TransformLines, expected: "a token=***\nb token=***\nc\n", duplicates: 2},
+		{mode: TransformKeys, expected: "a token=3\nb token=2\nc\n", duplicates: 2},
+		{mode: TransformOutput, expected: "a token=***\na token=***\nb token=***\nc\n", duplicates: 1},
+	}
+	for _, tt := range tests {
+		// Whether everything fits in memory, or is spilled to chunks and merged in a cascade, or by workers
+		for _, opts := range []Options{
+			{TmpFileBytes: 1000},
+			{TmpFileBytes: 2},
+			{TmpFileBytes: 2, MergeFanIn: 2},
+			{TmpFileBytes: 1000, BuildWorkers: 2},
+		} {
+			outFile, err := os.CreateTemp("", "dedup.test.*.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(outFile.Name())
+			defer outFile.Close()
+
+			opts.Transform, opts.TransformMode = redact, tt.mode
+			stats, err := Run(outFile, opts, strings.NewReader(input), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.LinesDuplicate != tt.duplicates || stats.BytesOut != uint64(len(tt.expected)) {
+				t.Fatalf("Unexpected stats with transform mode %q and %+v: %+v", tt.mode, opts, stats)
+			}
+			content, err := os.ReadFile(outFile.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.expected {
+				t.Fatalf("Unexpected output with transform mode %q and %d tmp file bytes: %q", tt.mode, opts.TmpFileBytes, content)
+			}
+		}
+	}
+}
+
+func TestRunTransformRecords(t *testing.T) {
+	// Transform rules are keyed the same, and records have the text written, and where it was first read
+	rules := []Rule{{Pattern: regexp.MustCompile(`^(\w+) .*$`), Action: RuleTransform, Replacement: "$1"}}
+	input := "b 1\na 2\nb 3\na 4\n"
+	for _, tmpFileBytes := range []uint64{1000, 2} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		opts := Options{TmpFileBytes: tmpFileBytes, Rules: rules, TransformMode: TransformKeys, Format: FormatJSON}
+		if _, err := Run(outFile, opts, strings.NewReader(input), nil); err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `{"line":"a 2","count":2,"line_number":2}` + "\n" + `{"line":"b 1","count":2,"line_number":1}` + "\n"
+		if string(content) != expected {
+			t.Fatalf("Unexpected output with %d tmp file bytes: %q", tmpFileBytes, content)
+		}
+	}
+}
+
+func TestRunTransformInvalid(t *testing.T) {
+	upper := func(line string) string { return strings.ToUpper(line) }
+	tests := []Options{
+		{Transform: upper, TransformMode: "unknown"},
+		{Transform: upper, TransformMode: TransformKeys, Checkpoint: "checkpoint.json"},
+		{Transform: upper, TransformMode: TransformOutput, OnIndex: func(string, uint64) error { return nil }},
+	}
+	for _, opts := range tests {
+		if _, err := Run(nil, opts, strings.NewReader("a\n"), nil); err == nil {
+			t.Fatalf("Expected an error with %+v", opts)
+		}
+	}
+	if _, err := SortChunks("", Options{Transform: upper, TransformMode: TransformKeys}, strings.NewReader("a\n")); err == nil {
+		t.Fatal("Expected an error sorting chunks with the keys transform mode")
+	}
+	if _, err := RunShards([]*os.File{nil}, Options{Transform: upper, TransformMode: TransformKeys}, strings.NewReader("a\n"), nil); err == nil {
+		t.Fatal("Expected an error running shards with the keys transform mode")
+	}
+}
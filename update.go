@@ -0,0 +1,40 @@
+package dedup
+
+import (
+	"fmt"
+)
+
+// validateUpdate returns an error if the output can not be the union of the Update file and the unique
+// lines of the input, sorted as it is
+func validateUpdate(opts Options) error {
+	if opts.Update == nil {
+		return nil
+	}
+	switch {
+	case opts.Format.records():
+		return fmt.Errorf("%s output is not supported when updating a sorted file", opts.Format)
+	case opts.Partitions > 1:
+		return fmt.Errorf("partitions are not supported when updating a sorted file")
+	case keyed(opts):
+		return fmt.Errorf("writing lines other than their keys is not supported when updating a sorted file")
+	case opts.PassthroughUnkept || hasPassthroughRules(opts.Rules):
+		return fmt.Errorf("passing lines through is not supported when updating a sorted file, which would no longer be sorted")
+	}
+	return nil
+}
+
+// hasPassthroughRules returns true if any of the rules pass the lines they match through
+func hasPassthroughRules(rules []Rule) bool {
+	for _, rule := range rules {
+		if rule.Action == RulePassthrough {
+			return true
+		}
+	}
+	return false
+}
+
+// updating returns true if the output is the union of the Update file and the unique lines of the input,
+// which is then always merged, with the Update file as one more chunk
+func (j *job) updating() bool {
+	return j.opts.Update != nil
+}
@@ -0,0 +1,72 @@
+package dedup
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunUpdate(t *testing.T) {
+	existing, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(existing.Name())
+	defer existing.Close()
+	if _, err = existing.WriteString("a\nc\ne\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+		dups     uint64
+	}{
+		{input: "d\nb\na\nd\n", expected: "a\nb\nc\nd\ne\n", dups: 2},
+		{input: "", expected: "a\nc\ne\n", dups: 0},
+	}
+	for _, tt := range tests {
+		// Whether the input fits in memory, or is spilled to chunks and merged in a cascade
+		for _, opts := range []Options{{TmpFileBytes: 1000}, {TmpFileBytes: 2}, {TmpFileBytes: 2, MergeFanIn: 2}} {
+			outFile, err := os.CreateTemp("", "dedup.test.*.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(outFile.Name())
+			defer outFile.Close()
+
+			opts.Update = existing
+			stats, err := Run(outFile, opts, strings.NewReader(tt.input), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.LinesUnique != uint64(strings.Count(tt.expected, "\n")) || stats.LinesDuplicate != tt.dups {
+				t.Fatalf("Unexpected stats with input %q and %+v: %+v", tt.input, opts, stats)
+			}
+			content, err := os.ReadFile(outFile.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.expected {
+				t.Fatalf("Unexpected output with input %q and %d tmp file bytes: %q", tt.input, opts.TmpFileBytes, content)
+			}
+		}
+	}
+
+	// The file being updated must be sorted, and the output must be too
+	unsorted, err := os.CreateTemp("", "dedup.test.*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(unsorted.Name())
+	defer unsorted.Close()
+	if _, err = unsorted.WriteString("b\na\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Run(nil, Options{Update: unsorted, DryRun: true}, strings.NewReader("c\n"), nil); err == nil {
+		t.Fatal("Expected an error updating an unsorted file")
+	}
+	if _, err = Run(nil, Options{Update: existing, Format: FormatJSON, DryRun: true}, strings.NewReader("c\n"), nil); err == nil {
+		t.Fatal("Expected an error updating a sorted file with JSON output")
+	}
+}
@@ -0,0 +1,181 @@
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Verify reads the input file and confirms that its lines are sorted and contain no duplicates,
+// which is true of every file written by Dedup and Merge. It returns the number of lines read,
+// and an error describing the first line found to be duplicated or out of order.
+func Verify(inFile io.Reader) (uint64, error) {
+	// Create a scanner to buffer the input file and read in line tokens
+	scanner := bufio.NewScanner(inFile)
+	scanner.Buffer(make([]byte, 0, defaultBufferSize), bufio.MaxScanTokenSize)
+
+	var (
+		previousLine string
+		lineCount    uint64
+	)
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineCount++
+
+		// Every line must come strictly after the previous line
+		if lineCount > 1 {
+			if line == previousLine {
+				return lineCount, fmt.Errorf("line %d is a duplicate of the previous line: %q", lineCount, line)
+			}
+			if line < previousLine {
+				return lineCount, fmt.Errorf("line %d %q comes before the previous line %q", lineCount, line, previousLine)
+			}
+		}
+		previousLine = line
+	}
+	return lineCount, scanner.Err()
+}
+
+// verifyLines reads the lines of a file written by the run, split as it was written, and returns an
+// error unless there are as many as expected, and the first sorted of them are in order without
+// duplicates
+func (j *job) verifyLines(r io.Reader, split bufio.SplitFunc, expected, sorted uint64) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, defaultBufferSize), j.maxLineLength())
+	scanner.Split(split)
+	var (
+		previousLine []byte
+		lineCount    uint64
+	)
+	for scanner.Scan() {
+		lineCount++
+		if lineCount > sorted {
+			continue
+		}
+		line := scanner.Bytes()
+		if lineCount > 1 {
+			switch cmp := bytes.Compare(line, previousLine); {
+			case cmp == 0:
+				return fmt.Errorf("line %d is a duplicate of the previous line: %q", lineCount, line)
+			case cmp < 0:
+				return fmt.Errorf("line %d %q comes before the previous line %q", lineCount, line, previousLine)
+			}
+		}
+		previousLine = append(previousLine[:0], line...)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if lineCount != expected {
+		return fmt.Errorf("%d lines were written, but %d were read back", expected, lineCount)
+	}
+	return nil
+}
+
+// verifyWrittenChunk reads back a chunk file once it has been written and synced, with VerifyWrites,
+// and returns an error unless it has the lines and checksum it was written with, sorted and unique
+func (j *job) verifyWrittenChunk(name string, lines uint64, sum uint32) error {
+	if !j.opts.VerifyWrites {
+		return nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	crc := crc32.NewIEEE()
+	r, err := j.decryptTemp(io.TeeReader(j.limitReader(j.retryReading(f, name)), crc))
+	if err == nil {
+		err = j.verifyLines(r, j.split(), lines, lines)
+	}
+	if err == nil {
+		// Whatever is left after the last line, such as the last segment of an encrypted file
+		_, err = io.Copy(io.Discard, r)
+	}
+	if err == nil {
+		err = verifyChunk(name, crc.Sum32(), sum)
+	}
+	if err != nil {
+		return fmt.Errorf("verifying temporary file %s: %w", name, err)
+	}
+	j.event(Event{Kind: EventChunkVerified, Phase: PhaseSplitting, File: name, Lines: lines,
+		Message: fmt.Sprintf("Verified %d lines read back from temporary file: %s", lines, name)})
+	return nil
+}
+
+// summedWriter is a writer to the output that adds what is written to its checksum
+type summedWriter struct {
+	w   io.Writer
+	sum hash.Hash32
+}
+
+func (w *summedWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.sum.Write(p[:n])
+	return n, err
+}
+
+// Name returns the name of the output written to, to log it by
+func (w *summedWriter) Name() string {
+	return outputName(w.w)
+}
+
+// sumOutput returns a writer that also adds what is written to the output to its checksum, with
+// VerifyWrites, or the writer itself
+func (j *job) sumOutput(out io.Writer) io.Writer {
+	if j.outSum == nil {
+		return out
+	}
+	return &summedWriter{w: out, sum: j.outSum}
+}
+
+// verifyOutput reads back the output file once it has been written and synced, with VerifyWrites,
+// and returns an error unless it ends with the bytes and lines the run wrote to it, whose deduplicated
+// lines are sorted and unique when written in order, with the checksum they were written with
+func (j *job) verifyOutput(f *os.File) error {
+	if j.outSum == nil {
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	start := info.Size() - int64(j.stats.BytesOut)
+	if start < 0 {
+		return fmt.Errorf("verifying output file %s: %d bytes were written, but it is %d bytes", f.Name(), j.stats.BytesOut, info.Size())
+	}
+
+	// Partitions by hash are written one after another, records are not lines to compare, and keyed
+	// lines are sorted by their keys rather than their text
+	var sorted uint64
+	if !j.opts.Format.records() && !j.keyed() && (j.partitions() == 1 || j.opts.PartitionBy == ShardRange) {
+		sorted = j.stats.LinesUnique
+	}
+	split := j.split()
+	if j.opts.Format.records() {
+		split = bufio.ScanLines
+	}
+	lines := j.stats.LinesUnique + j.stats.LinesPassedThrough + j.scriptLines
+	crc := crc32.NewIEEE()
+	r := io.TeeReader(j.limitReader(io.NewSectionReader(f, start, int64(j.stats.BytesOut))), crc)
+	if j.opts.Format.binary() {
+		// Only the checksum of a binary format can be compared, and its rows are the unique lines
+		lines = j.stats.LinesUnique
+		_, err = io.Copy(io.Discard, r)
+	} else {
+		err = j.verifyLines(r, split, lines, sorted)
+	}
+	if err == nil && crc.Sum32() != j.outSum.Sum32() {
+		err = fmt.Errorf("its CRC-32 checksum is %08x, but it was written with %08x", crc.Sum32(), j.outSum.Sum32())
+	}
+	if err != nil {
+		return fmt.Errorf("verifying output file %s: %w", f.Name(), err)
+	}
+	j.event(Event{Kind: EventOutputVerified, File: f.Name(), Lines: lines, Bytes: j.stats.BytesOut,
+		Message: fmt.Sprintf("Verified %d lines (%d bytes) read back from: %s", lines, j.stats.BytesOut, f.Name())})
+	return nil
+}
@@ -0,0 +1,113 @@
+package dedup
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRunVerifyWrites(t *testing.T) {
+	b, err := os.ReadFile("testdata/testdata.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := "# header\n" + string(b)
+	passthrough := []Rule{{Pattern: regexp.MustCompile(`^#`), Action: RulePassthrough}}
+	for name, opts := range map[string]Options{
+		"in memory":          {},
+		"spilled":            {TmpFileBytes: 20 * 50},
+		"cascaded":           {TmpFileBytes: 20 * 50, MergeFanIn: 3},
+		"partitions by hash": {TmpFileBytes: 20 * 50, Partitions: 3},
+		"encrypted":          {TmpFileBytes: 20 * 50, EncryptTempFiles: true},
+		"passthrough":        {TmpFileBytes: 20 * 50, Rules: passthrough},
+		"crlf":               {TmpFileBytes: 20 * 50, CRLF: true},
+		"delimiter":          {Delimiter: "\x00"},
+		"json":               {TmpFileBytes: 20 * 50, Format: FormatJSON},
+	} {
+		t.Run(name, func(t *testing.T) {
+			outFile, err := os.CreateTemp(t.TempDir(), "dedup.test.*.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer outFile.Close()
+
+			var chunks, outputs int
+			opts.TempDir = t.TempDir()
+			opts.VerifyWrites = true
+			opts.OnEvent = func(e Event) {
+				switch e.Kind {
+				case EventChunkVerified:
+					chunks++
+				case EventOutputVerified:
+					outputs++
+				}
+			}
+			in := input
+			if opts.Delimiter != "" {
+				in = strings.ReplaceAll(input, "\n", opts.Delimiter)
+			}
+			stats, err := Run(outFile, opts, strings.NewReader(in), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if outputs != 1 || chunks != stats.Chunks {
+				t.Fatalf("Expected the output and %d chunks verified; Got: %d outputs and %d chunks", stats.Chunks, outputs, chunks)
+			}
+		})
+	}
+}
+
+func TestVerifyWritten(t *testing.T) {
+	j := &job{opts: Options{VerifyWrites: true}, delim: defaultDelimiter}
+	chunk := filepath.Join(t.TempDir(), "chunk")
+	if err := os.WriteFile(chunk, []byte("a\nc\nb\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.verifyWrittenChunk(chunk, 3, 0); err == nil || !strings.Contains(err.Error(), "comes before") {
+		t.Fatalf("Expected an error verifying an unsorted chunk; Got: %v", err)
+	}
+	if err := os.WriteFile(chunk, []byte("a\nb\nc\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.verifyWrittenChunk(chunk, 2, 0); err == nil || !strings.Contains(err.Error(), "read back") {
+		t.Fatalf("Expected an error verifying a chunk with too many lines; Got: %v", err)
+	}
+	if err := j.verifyWrittenChunk(chunk, 3, 0); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("Expected an error verifying a chunk with another checksum; Got: %v", err)
+	}
+
+	// The output must end with what was written to it
+	outFile, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outFile.Close()
+	if _, err = outFile.WriteString("a\nb\n"); err != nil {
+		t.Fatal(err)
+	}
+	j = newVerifyingJob()
+	j.stats.LinesUnique, j.stats.BytesOut = 2, 4
+	if _, err = j.outSum.Write([]byte("a\nb\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err = j.verifyOutput(outFile); err != nil {
+		t.Fatal(err)
+	}
+	j = newVerifyingJob()
+	j.stats.LinesUnique, j.stats.BytesOut = 2, 4
+	if _, err = j.outSum.Write([]byte("a\nc\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err = j.verifyOutput(outFile); err == nil {
+		t.Fatal("Expected an error verifying an output with another checksum")
+	}
+}
+
+// newVerifyingJob returns a job that verifies what it writes
+func newVerifyingJob() *job {
+	j, _, done := newJob(Options{VerifyWrites: true}, "")
+	done()
+	return j
+}
@@ -0,0 +1,20 @@
+package dedup
+
+import "runtime"
+
+// workers returns the most goroutines the parallel parts of the run may use at once, from Workers,
+// or GOMAXPROCS if it is not set
+func (j *job) workers() int {
+	if j.opts.Workers > 0 {
+		return j.opts.Workers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// limitWorkers returns the configured number of workers of a part of the run, but no more than Workers
+func (j *job) limitWorkers(configured int) int {
+	if workers := j.workers(); configured > workers {
+		return workers
+	}
+	return configured
+}
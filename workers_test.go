@@ -0,0 +1,55 @@
+package dedup
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestLimitWorkers(t *testing.T) {
+	j := &job{opts: Options{Workers: 2}}
+	if j.limitWorkers(4) != 2 || j.limitWorkers(1) != 1 || j.limitWorkers(-1) != -1 {
+		t.Fatal("Expected the workers to be lowered to 2")
+	}
+	j = &job{}
+	if j.workers() != runtime.GOMAXPROCS(0) {
+		t.Fatalf("Expected GOMAXPROCS workers by default; Got: %d", j.workers())
+	}
+}
+
+func TestRunWorkers(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&input, "%03d\n", (i*7919)%500)
+	}
+
+	// A single worker still builds, sorts, and merges every partition, one at a time
+	var outputs []string
+	for _, workers := range []int{1, 4} {
+		outFile, err := os.CreateTemp("", "dedup.test.*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(outFile.Name())
+		defer outFile.Close()
+
+		opts := Options{TmpFileBytes: 300, Workers: workers, BuildWorkers: 4, SortWorkers: 4, Partitions: 4, PartitionBy: ShardRange}
+		stats, err := Run(outFile, opts, strings.NewReader(input.String()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.LinesUnique != 500 {
+			t.Fatalf("Expected 500 unique lines with %d workers; Got: %+v", workers, stats)
+		}
+		b, err := os.ReadFile(outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, string(b))
+	}
+	if outputs[0] != outputs[1] {
+		t.Fatal("Expected the same output with 1 worker as with 4")
+	}
+}